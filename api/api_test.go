@@ -1668,7 +1668,7 @@ func (f *localResponseWriter) WriteHeader(c int) {
 	f.statusCode = c
 }
 
-func TestwriteJSON(t *testing.T) {
+func TestWriteJSON(t *testing.T) {
 	testCode := 55
 	testData, err := json.Marshal("test data")
 	if err != nil {
@@ -1676,11 +1676,11 @@ func TestwriteJSON(t *testing.T) {
 	}
 
 	rsp := newWriter()
-	writeJSON(rsp, testCode, testData)
+	writeJSON(rsp, testCode, "test data")
 	if rsp.statusCode != testCode {
 		t.Fatalf("writeJSON() failed to set the status code. Expected %d. Got %d", testCode, rsp.statusCode)
 	}
-	if !bytes.Equal(testData, rsp.body) {
+	if !bytes.Equal(testData, bytes.TrimSpace(rsp.body)) {
 		t.Fatalf("writeJSON() failed to set the body. Expected %s. Got %s", testData, rsp.body)
 	}
 
@@ -194,6 +194,17 @@ func (h *Handle) SetAny() (uint32, error) {
 	return h.set(0, true, false)
 }
 
+// FirstAvailable returns the ordinal of the first unset bit, the one SetAny would set, without setting it
+func (h *Handle) FirstAvailable() (uint32, error) {
+	h.Lock()
+	defer h.Unlock()
+	bytePos, bitPos, err := getFirstAvailable(h.head)
+	if err != nil {
+		return invalidPos, err
+	}
+	return posToOrdinal(bytePos, bitPos), nil
+}
+
 // Set atomically sets the corresponding bit in the sequence
 func (h *Handle) Set(ordinal uint32) error {
 	if err := h.validateOrdinal(ordinal); err != nil {
@@ -147,7 +147,7 @@ func TestClientNetworkCreateWithDriver(t *testing.T) {
 
 	err = cli.Cmd("docker", "network", "create", "-d=dummy", mockNwName)
 	if err != nil {
-		t.Fatalf(err.Error())
+		t.Fatal(err)
 	}
 }
 
@@ -21,6 +21,19 @@ type DaemonCfg struct {
 	DefaultNetwork string
 	DefaultDriver  string
 	Labels         []string
+	PluginDirs     []string
+	SandboxBackend string
+	// InstanceID identifies the controller among the ones sharing the host
+	// or the datastore. It must be stable across restarts.
+	InstanceID string
+	// DisabledDrivers are the network types whose drivers are not registered
+	DisabledDrivers []string
+	// NetworkQuotas caps, per network label and label value, the number of
+	// networks carrying the label with that value
+	NetworkQuotas map[string]map[string]int
+	// Logger receives the log entries of the operations which support it,
+	// instead of the standard logger
+	Logger *log.Logger `toml:"-"`
 }
 
 // ClusterCfg represents cluster configuration
@@ -32,8 +45,9 @@ type ClusterCfg struct {
 
 // DatastoreCfg represents Datastore configuration.
 type DatastoreCfg struct {
-	Embedded bool
-	Client   DatastoreClientCfg
+	Embedded    bool
+	Client      DatastoreClientCfg
+	QueueWrites bool
 }
 
 // DatastoreClientCfg represents Datastore Client-only mode configuration
@@ -82,6 +96,79 @@ func OptionLabels(labels []string) Option {
 	}
 }
 
+// OptionPluginDirs function returns an option setter for the directories
+// scanned for remote driver plugins, instead of the default one
+func OptionPluginDirs(dirs []string) Option {
+	return func(c *Config) {
+		log.Infof("Option PluginDirs: %v", dirs)
+		for _, dir := range dirs {
+			if dir = strings.TrimSpace(dir); dir != "" {
+				c.Daemon.PluginDirs = append(c.Daemon.PluginDirs, dir)
+			}
+		}
+	}
+}
+
+// OptionSandboxBackend function returns an option setter for the backend the
+// sandboxes are created with, the network namespace one by default
+func OptionSandboxBackend(backend string) Option {
+	return func(c *Config) {
+		log.Infof("Option SandboxBackend: %s", backend)
+		c.Daemon.SandboxBackend = strings.TrimSpace(backend)
+	}
+}
+
+// OptionInstanceID function returns an option setter for the ID of the
+// controller instance, stamped on the sandbox joins it persists so that the
+// other controllers embedded on the host leave its sandboxes alone
+func OptionInstanceID(id string) Option {
+	return func(c *Config) {
+		log.Infof("Option InstanceID: %s", id)
+		c.Daemon.InstanceID = strings.TrimSpace(id)
+	}
+}
+
+// OptionDisableDriver function returns an option setter to keep the driver of
+// the network type from being registered, like the host or the null driver in
+// deployments where containers must not share the host network namespace
+func OptionDisableDriver(networkType string) Option {
+	return func(c *Config) {
+		log.Infof("Option DisableDriver: %s", networkType)
+		if networkType = strings.TrimSpace(networkType); networkType != "" {
+			c.Daemon.DisabledDrivers = append(c.Daemon.DisabledDrivers, networkType)
+		}
+	}
+}
+
+// OptionNetworkQuota function returns an option setter for the maximum number
+// of networks carrying the label with the passed value, like the networks of a
+// tenant. A limit of zero or less removes the quota.
+func OptionNetworkQuota(label, value string, limit int) Option {
+	return func(c *Config) {
+		log.Infof("Option NetworkQuota: %s=%s: %d", label, value, limit)
+		label, value = strings.TrimSpace(label), strings.TrimSpace(value)
+		if limit <= 0 {
+			delete(c.Daemon.NetworkQuotas[label], value)
+			return
+		}
+		if c.Daemon.NetworkQuotas == nil {
+			c.Daemon.NetworkQuotas = make(map[string]map[string]int)
+		}
+		if c.Daemon.NetworkQuotas[label] == nil {
+			c.Daemon.NetworkQuotas[label] = make(map[string]int)
+		}
+		c.Daemon.NetworkQuotas[label][value] = limit
+	}
+}
+
+// OptionLogger function returns an option setter for the logger the controller
+// operations log to, instead of the standard logger
+func OptionLogger(logger *log.Logger) Option {
+	return func(c *Config) {
+		c.Daemon.Logger = logger
+	}
+}
+
 // OptionKVProvider function returns an option setter for kvstore provider
 func OptionKVProvider(provider string) Option {
 	return func(c *Config) {
@@ -98,6 +185,15 @@ func OptionKVProviderURL(url string) Option {
 	}
 }
 
+// OptionKVQueueWrites function returns an option setter to queue the writes
+// while the kvstore is unavailable, instead of failing them
+func OptionKVQueueWrites(enable bool) Option {
+	return func(c *Config) {
+		log.Infof("Option OptionKVQueueWrites: %t", enable)
+		c.Datastore.QueueWrites = enable
+	}
+}
+
 // ProcessOptions processes options and stores it in config
 func (c *Config) ProcessOptions(options ...Option) {
 	for _, opt := range options {
@@ -47,6 +47,7 @@ import (
 	"container/heap"
 	"fmt"
 	"net"
+	"sort"
 	"strings"
 	"sync"
 
@@ -56,7 +57,9 @@ import (
 	"github.com/docker/libnetwork/config"
 	"github.com/docker/libnetwork/datastore"
 	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/drivers/remote"
 	"github.com/docker/libnetwork/hostdiscovery"
+	"github.com/docker/libnetwork/ipam"
 	"github.com/docker/libnetwork/netlabel"
 	"github.com/docker/libnetwork/osl"
 	"github.com/docker/libnetwork/types"
@@ -72,21 +75,69 @@ type NetworkController interface {
 	Config() config.Config
 
 	// Create a new network. The options parameter carries network specific options.
-	// Labels support will be added in the near future.
+	// Labels are passed through NetworkOptionLabels.
 	NewNetwork(networkType, name string, options ...NetworkOption) (Network, error)
 
 	// Networks returns the list of Network(s) managed by this controller.
 	Networks() []Network
 
+	// ListNetworks returns, ordered by id, the page of at most limit Network(s) starting at
+	// offset, along with the total number of networks. A zero limit returns all the remaining ones.
+	ListNetworks(offset, limit int) ([]Network, int, error)
+
+	// NetworkQuota returns the number of networks carrying the label with the passed
+	// value and the maximum number allowed, as set through config.OptionNetworkQuota.
+	// A zero limit means there is no quota.
+	NetworkQuota(label, value string) (int, int)
+
 	// WalkNetworks uses the provided function to walk the Network(s) managed by this controller.
 	WalkNetworks(walker NetworkWalker)
 
+	// FilterNetworks returns the Network(s) whose labels carry all the key-value
+	// pairs of the selector. An empty selector returns all the networks.
+	FilterNetworks(selector map[string]string) []Network
+
+	// FilterEndpoints returns the Endpoint(s), across all the networks, whose labels
+	// carry all the key-value pairs of the selector. An empty selector returns all the endpoints.
+	FilterEndpoints(selector map[string]string) []Endpoint
+
 	// NetworkByName returns the Network which has the passed name. If not found, the error ErrNoSuchNetwork is returned.
 	NetworkByName(name string) (Network, error)
 
 	// NetworkByID returns the Network which has the passed id. If not found, the error ErrNoSuchNetwork is returned.
 	NetworkByID(id string) (Network, error)
 
+	// CreateIpamPool creates a named address pool for the passed subnet. Networks
+	// reference it through NetworkOptionIpamPool, and the pool outlives them.
+	// The pool is kept in the datastore, when one is configured. Only the
+	// networks of the drivers implementing driverapi.PoolAddressDriver can use
+	// the pools, the other drivers allocate their own addresses.
+	CreateIpamPool(name string, subnet *net.IPNet) error
+
+	// DeleteIpamPool deletes the named address pool. It fails if any network is still using the pool.
+	DeleteIpamPool(name string) error
+
+	// UpdateIpamPool moves the named address pool to the passed subnet. New addresses
+	// come from it, while the endpoints keep their address until Endpoint.Renumber.
+	UpdateIpamPool(name string, subnet *net.IPNet) error
+
+	// IpamPools returns the named address pools with their utilization, sorted by name
+	IpamPools() []PoolInfo
+
+	// SetDefaultNetwork designates the network, by id, new sandboxes are connected to
+	// unless they opt out through OptionNoDefaultNetwork. An empty id clears it.
+	SetDefaultNetwork(id string) error
+
+	// MoveEndpoint moves the passed endpoint to the target network: it creates a new endpoint,
+	// with the same name and the passed options, on the target network, joins it to the sandbox
+	// the endpoint is joined to, if any, then leaves and deletes the endpoint. The new endpoint
+	// is returned. On failure, the changes are rolled back and the endpoint is left as it was.
+	MoveEndpoint(ep Endpoint, target Network, options ...EndpointOption) (Endpoint, error)
+
+	// SetResolvConfGenerator installs the function generating the resolv.conf file of the
+	// sandboxes from the default content. A nil function restores the default content.
+	SetResolvConfGenerator(gen ResolvConfGenerator)
+
 	// NewSandbox cretes a new network sandbox for the passed container id
 	NewSandbox(containerID string, options ...SandboxOption) (Sandbox, error)
 
@@ -99,14 +150,43 @@ type NetworkController interface {
 	// SandboxByID returns the Sandbox which has the passed id. If not found, a types.NotFoundError is returned.
 	SandboxByID(id string) (Sandbox, error)
 
+	// Diagnostics returns a snapshot of the networks, endpoints, sandboxes, ipam pools and drivers
+	// managed by this controller. The report is JSON serializable, meant to be attached to bug reports.
+	Diagnostics() (Report, error)
+
+	// Metrics returns the counters of the datastore operations issued by this controller
+	Metrics() Metrics
+
+	// ID returns the ID of this controller instance, the configured one or a
+	// generated one otherwise.
+	ID() string
+
+	// DeleteSandbox deletes a sandbox of this controller. The sandboxes of the
+	// other controllers are refused with ErrForeignSandbox.
+	DeleteSandbox(sb Sandbox) error
+
 	// GC triggers immediate garbage collection of resources which are garbage collected.
 	GC()
+
+	// PauseBackgroundTasks suspends the periodic garbage collection, the endpoint
+	// health probes, the datastore watchers and the retries of the queued datastore
+	// writes, so that maintenance can be carried out without the controller acting
+	// on the intermediate states. The changes seen meanwhile are processed on resume.
+	PauseBackgroundTasks()
+
+	// ResumeBackgroundTasks resumes the background tasks suspended by PauseBackgroundTasks
+	ResumeBackgroundTasks()
 }
 
 // NetworkWalker is a client provided function which will be used to walk the Networks.
 // When the function returns true, the walk will stop.
 type NetworkWalker func(nw Network) bool
 
+// ResolvConfGenerator is a client provided function which returns the resolv.conf
+// content to write to the sandbox, given the content libnetwork generated for it.
+// It is called again with the new content each time libnetwork updates the file.
+type ResolvConfGenerator func(sb Sandbox, base []byte) ([]byte, error)
+
 // SandboxWalker is a client provided function which will be used to walk the Sandboxes.
 // When the function returns true, the walk will stop.
 type SandboxWalker func(sb Sandbox) bool
@@ -122,36 +202,103 @@ type endpointTable map[string]*endpoint
 type sandboxTable map[string]*sandbox
 
 type controller struct {
-	networks  networkTable
-	drivers   driverTable
-	sandboxes sandboxTable
-	cfg       *config.Config
-	store     datastore.DataStore
+	id          string
+	networks    networkTable
+	creating    networkTable // networks being created, counted against the quotas
+	creatingSb  sandboxTable // sandboxes being created, holding their container ID
+	drivers     driverTable
+	sandboxes   sandboxTable
+	ipamPools   ipamPoolTable
+	ipam        *ipam.Allocator
+	cfg         *config.Config
+	store       datastore.DataStore
+	storeQueue  storeQueue
+	bgPause     backgroundPause
+	defaultNw   string // id of the network new sandboxes are connected to
+	sboxBackend osl.Backend
+	resolvGen   ResolvConfGenerator
 	sync.Mutex
 }
 
+// Context is the part of a context NewWithContext watches to abort the
+// controller startup. The golang.org/x/net/context contexts implement it.
+type Context interface {
+	// Done returns a channel closed when the startup must be aborted
+	Done() <-chan struct{}
+	// Err returns the reason of the abort once Done is closed, nil before
+	Err() error
+}
+
+// noAbort is the Context of a startup which is never aborted
+type noAbort struct{}
+
+func (noAbort) Done() <-chan struct{} {
+	return nil
+}
+
+func (noAbort) Err() error {
+	return nil
+}
+
 // New creates a new instance of network controller.
 func New(cfgOptions ...config.Option) (NetworkController, error) {
+	return NewWithContext(noAbort{}, cfgOptions...)
+}
+
+// NewWithContext creates a new instance of network controller like New. The
+// datastore connection and the reconciliation of the networks and sandboxes
+// it holds are aborted once the context is done, ErrStartupCanceled is then
+// returned.
+func NewWithContext(ctx Context, cfgOptions ...config.Option) (NetworkController, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, ErrStartupCanceled{Err: err}
+	}
+
 	var cfg *config.Config
 	if len(cfgOptions) > 0 {
 		cfg = &config.Config{}
 		cfg.ProcessOptions(cfgOptions...)
 	}
 	c := &controller{
-		cfg:       cfg,
-		networks:  networkTable{},
-		sandboxes: sandboxTable{},
-		drivers:   driverTable{}}
+		id:         stringid.GenerateRandomID(),
+		cfg:        cfg,
+		networks:   networkTable{},
+		creating:   networkTable{},
+		sandboxes:  sandboxTable{},
+		creatingSb: sandboxTable{},
+		ipamPools:  ipamPoolTable{},
+		drivers:    driverTable{}}
 	if err := initDrivers(c); err != nil {
 		return nil, err
 	}
 
+	var backend string
 	if cfg != nil {
-		if err := c.initDataStore(); err != nil {
+		backend = cfg.Daemon.SandboxBackend
+	}
+	sboxBackend, err := osl.GetBackend(backend)
+	if err != nil {
+		return nil, err
+	}
+	c.sboxBackend = sboxBackend
+	if id := c.ownerStamp(); id != "" {
+		c.id = id
+	}
+
+	if cfg != nil {
+		if err := c.initDataStore(ctx); err != nil {
+			if _, ok := err.(ErrStartupCanceled); ok {
+				c.closeDataStore()
+				return nil, err
+			}
 			// Failing to initalize datastore is a bad situation to be in.
 			// But it cannot fail creating the Controller
 			log.Debugf("Failed to Initialize Datastore due to %v. Operating in non-clustered mode", err)
 		}
+		if err := ctx.Err(); err != nil {
+			c.closeDataStore()
+			return nil, ErrStartupCanceled{Err: err}
+		}
 		if err := c.initDiscovery(); err != nil {
 			// Failing to initalize discovery is a bad situation to be in.
 			// But it cannot fail creating the Controller
@@ -184,6 +331,38 @@ func (c *controller) hostJoinCallback(hosts []net.IP) {
 func (c *controller) hostLeaveCallback(hosts []net.IP) {
 }
 
+func (c *controller) ID() string {
+	return c.id
+}
+
+// ownerStamp returns the instance ID the persisted objects are stamped with,
+// only the configured one: a generated ID does not survive a restart
+func (c *controller) ownerStamp() string {
+	if c.cfg == nil {
+		return ""
+	}
+	return c.cfg.Daemon.InstanceID
+}
+
+// checkOwner fails if the sandbox is managed by another controller
+func (c *controller) checkOwner(sb *sandbox) error {
+	if sb.controller != c {
+		return ErrForeignSandbox{Sandbox: sb.ID(), Owner: sb.Owner()}
+	}
+	return nil
+}
+
+func (c *controller) DeleteSandbox(sbox Sandbox) error {
+	sb, ok := sbox.(*sandbox)
+	if !ok {
+		return types.BadRequestErrorf("not a valid Sandbox interface")
+	}
+	if err := c.checkOwner(sb); err != nil {
+		return err
+	}
+	return sb.Delete()
+}
+
 func (c *controller) Config() config.Config {
 	c.Lock()
 	defer c.Unlock()
@@ -203,12 +382,57 @@ func (c *controller) ConfigureNetworkDriver(networkType string, options map[stri
 	return dd.driver.Config(options)
 }
 
+// ResyncDriver replays the networks of the type, and their endpoints, to the
+// driver. The networks are the ones the controller knows of, kept in sync with
+// the datastore. Failures are logged and do not stop the replay of the others.
+func (c *controller) ResyncDriver(networkType string) error {
+	c.Lock()
+	dd, ok := c.drivers[networkType]
+	var nws []*network
+	for _, n := range c.networks {
+		if n.networkType == networkType {
+			nws = append(nws, n)
+		}
+	}
+	c.Unlock()
+
+	if !ok {
+		return NetworkTypeError(networkType)
+	}
+
+	for _, n := range nws {
+		if err := dd.driver.CreateNetwork(n.id, n.driverOptions()); err != nil {
+			log.Warnf("Failed to replay network %s to driver %s: %v", n.Name(), networkType, err)
+			continue
+		}
+		for _, e := range n.Endpoints() {
+			ep := e.(*endpoint)
+			ep.Lock()
+			generic := ep.generic
+			ep.Unlock()
+			if err := dd.driver.CreateEndpoint(n.id, ep.id, ep, generic); err != nil {
+				log.Warnf("Failed to replay endpoint %s to driver %s: %v", ep.Name(), networkType, err)
+			}
+		}
+	}
+
+	return nil
+}
+
 func (c *controller) RegisterDriver(networkType string, driver driverapi.Driver, capability driverapi.Capability) error {
 	c.Lock()
 	if !config.IsValidName(networkType) {
 		c.Unlock()
 		return ErrInvalidName(networkType)
 	}
+	if c.driverDisabled(networkType) {
+		c.Unlock()
+		log.Infof("Skipping the registration of disabled network driver %s", networkType)
+		if td, ok := driver.(driverapi.TeardownDriver); ok {
+			td.Teardown()
+		}
+		return nil
+	}
 	if _, ok := c.drivers[networkType]; ok {
 		c.Unlock()
 		return driverapi.ErrActiveRegistration(networkType)
@@ -270,13 +494,76 @@ func (c *controller) NewNetwork(networkType, name string, options ...NetworkOpti
 
 	network.processOptions(options...)
 
+	switch network.allocation {
+	case "", netlabel.AllocationSequential, netlabel.AllocationRandom:
+	default:
+		return nil, types.BadRequestErrorf("invalid allocation strategy %q", network.allocation)
+	}
+
+	switch network.exhaustion {
+	case "", netlabel.ExhaustionFail:
+	case netlabel.ExhaustionExpand:
+		if network.ipamPool == "" || network.expansion == nil || isV6(network.expansion) {
+			return nil, types.BadRequestErrorf("exhaustion policy %q requires an ipam pool and an IPv4 expansion subnet", network.exhaustion)
+		}
+	case netlabel.ExhaustionQueue:
+		return nil, ErrQueueNotSupported(name)
+	default:
+		return nil, types.BadRequestErrorf("invalid exhaustion policy %q", network.exhaustion)
+	}
+
+	if strings.ContainsAny(network.domain, " \t") {
+		return nil, types.BadRequestErrorf("invalid domain %q", network.domain)
+	}
+
+	if err := validateDNSForward(network.dnsForward); err != nil {
+		return nil, err
+	}
+
+	// The network holds its quota slot until it is added, or failed to be
+	c.Lock()
+	err := c.checkNetworkQuota(network)
+	if err == nil {
+		c.creating[network.id] = network
+	}
+	c.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		c.Lock()
+		delete(c.creating, network.id)
+		c.Unlock()
+	}()
+
+	if network.ipamPool != "" {
+		if err := c.attachIpamPool(network.ipamPool, network.id, false); err != nil {
+			return nil, err
+		}
+	}
+	if network.ipamPoolV6 != "" {
+		if err := c.attachIpamPool(network.ipamPoolV6, network.id, true); err != nil {
+			if network.ipamPool != "" {
+				c.detachIpamPool(network.ipamPool, network.id)
+			}
+			return nil, err
+		}
+	}
+
 	if err := c.addNetwork(network); err != nil {
+		if network.ipamPool != "" {
+			c.detachIpamPool(network.ipamPool, network.id)
+		}
+		if network.ipamPoolV6 != "" {
+			c.detachIpamPool(network.ipamPoolV6, network.id)
+		}
 		return nil, err
 	}
 
 	if err := c.updateNetworkToStore(network); err != nil {
 		log.Warnf("couldnt create network %s: %v", network.name, err)
-		if e := network.Delete(); e != nil {
+		// The network never made it to the store, only the local state is cleaned up
+		if e := network.deleteNetwork(); e != nil {
 			log.Warnf("couldnt cleanup network %s: %v", network.name, err)
 		}
 		return nil, err
@@ -300,15 +587,26 @@ func (c *controller) addNetwork(n *network) error {
 		}
 	}
 
+	// The driver must program the addresses allocated from the pools
+	if n.ipamPool != "" || n.ipamPoolV6 != "" {
+		if pd, ok := dd.driver.(driverapi.PoolAddressDriver); !ok || !pd.SupportsPoolAddresses() {
+			return types.BadRequestErrorf("network %s driver %s cannot use an ipam pool", n.name, n.networkType)
+		}
+	}
+
 	n.Lock()
 	n.svcRecords = svcMap{}
 	n.driver = dd.driver
+	n.scope = datastore.LocalScope
+	if dd.capability.Scope == driverapi.GlobalScope {
+		n.scope = datastore.GlobalScope
+	}
 	d := n.driver
 	n.Unlock()
 
 	// Create the network
-	if err := d.CreateNetwork(n.id, n.generic); err != nil {
-		return err
+	if err := d.CreateNetwork(n.id, n.driverOptions()); err != nil {
+		return mapDriverError(err)
 	}
 	if err := n.watchEndpoints(); err != nil {
 		return err
@@ -332,6 +630,35 @@ func (c *controller) Networks() []Network {
 	return list
 }
 
+func (c *controller) ListNetworks(offset, limit int) ([]Network, int, error) {
+	if offset < 0 || limit < 0 {
+		return nil, 0, types.BadRequestErrorf("invalid network page offset %d and limit %d", offset, limit)
+	}
+
+	c.Lock()
+	ids := make([]string, 0, len(c.networks))
+	for id := range c.networks {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	total := len(ids)
+	if offset > total {
+		offset = total
+	}
+	end := total
+	if limit > 0 && offset+limit < total {
+		end = offset + limit
+	}
+	list := make([]Network, 0, end-offset)
+	for _, id := range ids[offset:end] {
+		list = append(list, c.networks[id])
+	}
+	c.Unlock()
+
+	return list, total, nil
+}
+
 func (c *controller) WalkNetworks(walker NetworkWalker) {
 	for _, n := range c.Networks() {
 		if walker(n) {
@@ -340,6 +667,41 @@ func (c *controller) WalkNetworks(walker NetworkWalker) {
 	}
 }
 
+func (c *controller) FilterNetworks(selector map[string]string) []Network {
+	list := []Network{}
+	for _, n := range c.Networks() {
+		if n.(*network).matchLabels(selector) {
+			list = append(list, n)
+		}
+	}
+
+	return list
+}
+
+func (c *controller) FilterEndpoints(selector map[string]string) []Endpoint {
+	list := []Endpoint{}
+	for _, n := range c.Networks() {
+		for _, ep := range n.Endpoints() {
+			if ep.(*endpoint).matchLabels(selector) {
+				list = append(list, ep)
+			}
+		}
+	}
+
+	return list
+}
+
+// labelsMatch returns whether labels carry all the selector key-value pairs
+func labelsMatch(labels, selector map[string]string) bool {
+	for k, v := range selector {
+		if lv, ok := labels[k]; !ok || lv != v {
+			return false
+		}
+	}
+
+	return true
+}
+
 func (c *controller) NetworkByName(name string) (Network, error) {
 	if name == "" {
 		return nil, ErrInvalidName(name)
@@ -383,22 +745,51 @@ func (c *controller) NewSandbox(containerID string, options ...SandboxOption) (S
 		return nil, types.BadRequestErrorf("invalid container ID")
 	}
 
-	var existing Sandbox
-	look := SandboxContainerWalker(&existing, containerID)
-	c.WalkSandboxes(look)
-	if existing != nil {
-		return nil, types.BadRequestErrorf("container %s is already present: %v", containerID, existing)
-	}
-
 	// Create sandbox and process options first. Key generation depends on an option
 	sb := &sandbox{
 		id:          stringid.GenerateRandomID(),
 		containerID: containerID,
 		endpoints:   epHeap{},
 		epPriority:  map[string]int{},
+		lazyEps:     map[string]*endpoint{},
 		config:      containerConfig{},
 		controller:  c,
+		createDone:  make(chan struct{}),
+	}
+
+	for {
+		c.Lock()
+		existing := c.sandboxByContainerID(containerID)
+		if existing == nil {
+			// Concurrent creations for the container find this one
+			c.creatingSb[sb.id] = sb
+			c.Unlock()
+			break
+		}
+		c.Unlock()
+
+		// Creation retries get the sandbox already created for the container,
+		// once its creation completed. Start over if it failed meanwhile.
+		existing.waitCreated()
+		c.Lock()
+		_, ok := c.sandboxes[existing.id]
+		c.Unlock()
+		if !ok {
+			continue
+		}
+		if !existing.compatibleOptions(options...) {
+			return nil, ErrSandboxConflict(containerID)
+		}
+		return existing, nil
 	}
+
+	// Deferred first to run last, once the sandbox is either registered or dropped
+	defer close(sb.createDone)
+	defer func() {
+		c.Lock()
+		delete(c.creatingSb, sb.id)
+		c.Unlock()
+	}()
 	// This sandbox may be using an existing osl sandbox, sharing it with another sandbox
 	var peerSb Sandbox
 	c.WalkSandboxes(SandboxKeyWalker(&peerSb, sb.Key()))
@@ -410,6 +801,11 @@ func (c *controller) NewSandbox(containerID string, options ...SandboxOption) (S
 
 	sb.processOptions(options...)
 
+	// The classification rules would apply to all the host traffic
+	if sb.config.netClsClassID != 0 && sb.config.useDefaultSandBox {
+		return nil, types.BadRequestErrorf("net_cls classid cannot be set on a sandbox using the host network namespace")
+	}
+
 	err = sb.buildHostsFile()
 	if err != nil {
 		return nil, err
@@ -426,7 +822,7 @@ func (c *controller) NewSandbox(containerID string, options ...SandboxOption) (S
 	}
 
 	if sb.osSbox == nil {
-		if sb.osSbox, err = osl.NewSandbox(sb.Key(), !sb.config.useDefaultSandBox); err != nil {
+		if sb.osSbox, err = c.sboxBackend.NewSandbox(sb.Key(), !sb.config.useDefaultSandBox); err != nil {
 			return nil, fmt.Errorf("failed to create new osl sandbox: %v", err)
 		}
 	}
@@ -435,9 +831,138 @@ func (c *controller) NewSandbox(containerID string, options ...SandboxOption) (S
 	c.sandboxes[sb.id] = sb
 	c.Unlock()
 
+	if err = sb.joinDefaultNetwork(); err != nil {
+		if e := sb.Delete(); e != nil {
+			log.Warnf("could not cleanup sandbox %s on failure: %v", sb.id, e)
+		}
+		return nil, err
+	}
+
 	return sb, nil
 }
 
+// sandboxByContainerID returns the sandbox of the container, created or being
+// created, if any. It must be called with the controller lock held.
+func (c *controller) sandboxByContainerID(containerID string) *sandbox {
+	for _, tbl := range []sandboxTable{c.sandboxes, c.creatingSb} {
+		for _, sb := range tbl {
+			if sb.containerID == containerID {
+				return sb
+			}
+		}
+	}
+	return nil
+}
+
+func (c *controller) SetResolvConfGenerator(gen ResolvConfGenerator) {
+	c.Lock()
+	c.resolvGen = gen
+	c.Unlock()
+}
+
+func (c *controller) resolvConfGenerator() ResolvConfGenerator {
+	c.Lock()
+	defer c.Unlock()
+	return c.resolvGen
+}
+
+// hostSandboxes returns whether the sandboxes are created with the host
+// backend, sharing the network namespace of the controller
+func (c *controller) hostSandboxes() bool {
+	return c.cfg != nil && c.cfg.Daemon.SandboxBackend == osl.HostBackend
+}
+
+func (c *controller) SetDefaultNetwork(id string) error {
+	if id != "" {
+		if _, err := c.NetworkByID(id); err != nil {
+			return err
+		}
+	}
+
+	c.Lock()
+	c.defaultNw = id
+	c.Unlock()
+
+	return nil
+}
+
+func (c *controller) MoveEndpoint(ep Endpoint, target Network, options ...EndpointOption) (Endpoint, error) {
+	var err error
+
+	if ep == nil {
+		return nil, types.BadRequestErrorf("cannot move a nil endpoint")
+	}
+	old, ok := ep.(*endpoint)
+	if !ok {
+		return nil, types.BadRequestErrorf("not a valid Endpoint interface")
+	}
+
+	if target == nil {
+		return nil, types.BadRequestErrorf("cannot move endpoint %s to a nil network", old.Name())
+	}
+	n, ok := target.(*network)
+	if !ok || n.getController() != c {
+		return nil, types.BadRequestErrorf("not a valid Network interface")
+	}
+	if old.getNetwork() == n {
+		return nil, types.ForbiddenErrorf("endpoint %s is already on network %s", old.Name(), n.Name())
+	}
+
+	sb, joined := old.getSandbox()
+
+	newEp, err := n.CreateEndpoint(old.Name(), options...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			if e := newEp.Delete(); e != nil {
+				log.Warnf("Failed to delete endpoint %s on network %s while rolling back the move: %v", newEp.Name(), n.Name(), e)
+			}
+		}
+	}()
+
+	if !joined {
+		if err = old.Delete(); err != nil {
+			return nil, err
+		}
+		return newEp, nil
+	}
+
+	// Join the new endpoint before leaving the old one, so that the sandbox
+	// is never left without connectivity
+	if err = newEp.Join(sb, options...); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			if e := newEp.Leave(sb); e != nil {
+				log.Warnf("Failed to leave endpoint %s on network %s while rolling back the move: %v", newEp.Name(), n.Name(), e)
+			}
+		}
+	}()
+
+	if err = old.Leave(sb); err != nil {
+		return nil, err
+	}
+
+	if err = old.Delete(); err != nil {
+		if e := old.Join(sb); e != nil {
+			log.Warnf("Failed to rejoin endpoint %s while rolling back the move: %v", old.Name(), e)
+		}
+		return nil, err
+	}
+
+	// The sandbox owns the new endpoint if it owned the old one
+	sb.Lock()
+	if sb.defaultEp == old {
+		sb.defaultEp = newEp.(*endpoint)
+	}
+	sb.Unlock()
+
+	return newEp, nil
+}
+
 func (c *controller) Sandboxes() []Sandbox {
 	c.Lock()
 	defer c.Unlock()
@@ -493,13 +1018,89 @@ func SandboxKeyWalker(out *Sandbox, key string) SandboxWalker {
 	}
 }
 
+func (c *controller) NetworkQuota(label, value string) (int, int) {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.countLabeledNetworks(label, value), c.networkQuota(label, value)
+}
+
+// networkQuota returns the number of networks allowed to carry the label with the value, zero if unlimited
+func (c *controller) networkQuota(label, value string) int {
+	if c.cfg == nil {
+		return 0
+	}
+	return c.cfg.Daemon.NetworkQuotas[label][value]
+}
+
+// countLabeledNetworks counts the networks carrying the label with the value,
+// including the ones being created. It must be called with the controller lock held.
+func (c *controller) countLabeledNetworks(label, value string) int {
+	count := 0
+	hasLabel := func(n *network) bool {
+		n.Lock()
+		defer n.Unlock()
+		v, ok := n.labels[label]
+		return ok && v == value
+	}
+	for _, n := range c.networks {
+		if hasLabel(n) {
+			count++
+		}
+	}
+	for id, n := range c.creating {
+		if _, ok := c.networks[id]; !ok && hasLabel(n) {
+			count++
+		}
+	}
+	return count
+}
+
+// checkNetworkQuota returns ErrQuotaExceeded if the network labels reached
+// their quota. It must be called with the controller lock held.
+func (c *controller) checkNetworkQuota(n *network) error {
+	for label, value := range n.labels {
+		limit := c.networkQuota(label, value)
+		if limit > 0 && c.countLabeledNetworks(label, value) >= limit {
+			return ErrQuotaExceeded{Label: label, Value: value, Limit: limit}
+		}
+	}
+	return nil
+}
+
+// driverDisabled tells whether the driver of the network type was disabled
+// through config.OptionDisableDriver
+func (c *controller) driverDisabled(networkType string) bool {
+	if c.cfg == nil {
+		return false
+	}
+	for _, d := range c.cfg.Daemon.DisabledDrivers {
+		if d == networkType {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *controller) loadDriver(networkType string) (*driverData, error) {
-	// Plugins pkg performs lazy loading of plugins that acts as remote drivers.
-	// As per the design, this Get call will result in remote driver discovery if there is a corresponding plugin available.
-	_, err := plugins.Get(networkType, driverapi.NetworkPluginEndpointType)
+	if c.driverDisabled(networkType) {
+		return nil, types.NotFoundErrorf("network driver %s is disabled", networkType)
+	}
+
+	err := plugins.ErrNotFound
+	// The configured plugin directories are searched first, the plugins pkg
+	// default directory is the fallback.
+	if c.cfg != nil && len(c.cfg.Daemon.PluginDirs) > 0 {
+		err = remote.Discover(c, c.cfg.Daemon.PluginDirs, networkType)
+	}
+	if err == plugins.ErrNotFound {
+		// Plugins pkg performs lazy loading of plugins that acts as remote drivers.
+		// As per the design, this Get call will result in remote driver discovery if there is a corresponding plugin available.
+		_, err = plugins.Get(networkType, driverapi.NetworkPluginEndpointType)
+	}
 	if err != nil {
 		if err == plugins.ErrNotFound {
-			return nil, types.NotFoundErrorf(err.Error())
+			return nil, types.NotFoundErrorf("%v", err)
 		}
 		return nil, err
 	}
@@ -525,6 +1126,14 @@ func (c *controller) isDriverGlobalScoped(networkType string) (bool, error) {
 	return false, nil
 }
 
+// logger returns the logger the controller operations log to
+func (c *controller) logger() *log.Logger {
+	if c.cfg != nil && c.cfg.Daemon.Logger != nil {
+		return c.cfg.Daemon.Logger
+	}
+	return log.StandardLogger()
+}
+
 func (c *controller) GC() {
 	osl.GC()
 }
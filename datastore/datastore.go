@@ -26,6 +26,9 @@ type DataStore interface {
 	DeleteTree(kvObject KV) error
 	// KVStore returns access to the KV Store
 	KVStore() store.Store
+	// Metrics returns the call count, error count and latency of the KV Store
+	// operations, keyed by operation name
+	Metrics() map[string]OpMetrics
 }
 
 // ErrKeyModified is raised for an atomic update when the update is working on a stale state
@@ -35,7 +38,7 @@ var (
 )
 
 type datastore struct {
-	store store.Store
+	store *instrumentedStore
 }
 
 //KV Key Value interface used by objects to be part of the DataStore
@@ -62,6 +65,19 @@ const (
 	NetworkKeyPrefix = "network"
 	// EndpointKeyPrefix is the prefix for endpoint key in the kv store
 	EndpointKeyPrefix = "endpoint"
+	// IpamPoolKeyPrefix is the prefix for the named ipam pool key in the kv store
+	IpamPoolKeyPrefix = "ipampool"
+)
+
+// DataScope indicates whether an object is local to a host or shared across hosts
+type DataScope string
+
+const (
+	// LocalScope indicates an object local to the host. Objects of a same
+	// name on different hosts are distinct.
+	LocalScope DataScope = "local"
+	// GlobalScope indicates an object shared across the hosts of the cluster
+	GlobalScope DataScope = "global"
 )
 
 var rootChain = []string{"docker", "libnetwork"}
@@ -90,7 +106,7 @@ func newClient(kv string, addrs string) (DataStore, error) {
 	if err != nil {
 		return nil, err
 	}
-	ds := &datastore{store: store}
+	ds := &datastore{store: newInstrumentedStore(store)}
 	return ds, nil
 }
 
@@ -105,13 +121,17 @@ func NewDataStore(cfg *config.DatastoreCfg) (DataStore, error) {
 
 // NewCustomDataStore can be used by clients to plugin cusom datatore that adhers to store.Store
 func NewCustomDataStore(customStore store.Store) DataStore {
-	return &datastore{store: customStore}
+	return &datastore{store: newInstrumentedStore(customStore)}
 }
 
 func (ds *datastore) KVStore() store.Store {
 	return ds.store
 }
 
+func (ds *datastore) Metrics() map[string]OpMetrics {
+	return ds.store.metrics()
+}
+
 // PutObjectAtomic adds a new Record based on an object into the datastore
 func (ds *datastore) PutObjectAtomic(kvObject KV) error {
 	if kvObject == nil {
@@ -15,7 +15,7 @@ var dummyKey = "dummy"
 
 // NewCustomDataStore can be used by other Tests in order to use custom datastore
 func NewTestDataStore() DataStore {
-	return &datastore{store: NewMockStore()}
+	return NewCustomDataStore(NewMockStore())
 }
 
 func TestKey(t *testing.T) {
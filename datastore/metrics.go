@@ -0,0 +1,123 @@
+package datastore
+
+import (
+	"sync"
+	"time"
+
+	"github.com/docker/libkv/store"
+)
+
+// OpMetrics accounts for the calls of a store operation
+type OpMetrics struct {
+	// Count is the number of calls
+	Count uint64
+	// Errors is the number of calls which failed. Looking up a missing key
+	// is not accounted as a failure.
+	Errors uint64
+	// Latency is the time spent in the calls
+	Latency time.Duration
+	// MaxLatency is the time spent in the slowest call
+	MaxLatency time.Duration
+}
+
+// instrumentedStore times the calls to the wrapped store and counts their errors
+type instrumentedStore struct {
+	store store.Store
+	ops   map[string]*OpMetrics
+	sync.Mutex
+}
+
+func newInstrumentedStore(s store.Store) *instrumentedStore {
+	return &instrumentedStore{store: s, ops: map[string]*OpMetrics{}}
+}
+
+func (is *instrumentedStore) record(op string, start time.Time, err error) {
+	d := time.Since(start)
+
+	is.Lock()
+	defer is.Unlock()
+
+	m, ok := is.ops[op]
+	if !ok {
+		m = &OpMetrics{}
+		is.ops[op] = m
+	}
+	m.Count++
+	if err != nil && err != store.ErrKeyNotFound {
+		m.Errors++
+	}
+	m.Latency += d
+	if d > m.MaxLatency {
+		m.MaxLatency = d
+	}
+}
+
+func (is *instrumentedStore) metrics() map[string]OpMetrics {
+	is.Lock()
+	defer is.Unlock()
+
+	ops := make(map[string]OpMetrics, len(is.ops))
+	for op, m := range is.ops {
+		ops[op] = *m
+	}
+	return ops
+}
+
+func (is *instrumentedStore) Put(key string, value []byte, options *store.WriteOptions) (err error) {
+	defer func(start time.Time) { is.record("Put", start, err) }(time.Now())
+	return is.store.Put(key, value, options)
+}
+
+func (is *instrumentedStore) Get(key string) (kvp *store.KVPair, err error) {
+	defer func(start time.Time) { is.record("Get", start, err) }(time.Now())
+	return is.store.Get(key)
+}
+
+func (is *instrumentedStore) Delete(key string) (err error) {
+	defer func(start time.Time) { is.record("Delete", start, err) }(time.Now())
+	return is.store.Delete(key)
+}
+
+func (is *instrumentedStore) Exists(key string) (ok bool, err error) {
+	defer func(start time.Time) { is.record("Exists", start, err) }(time.Now())
+	return is.store.Exists(key)
+}
+
+func (is *instrumentedStore) Watch(key string, stopCh <-chan struct{}) (ch <-chan *store.KVPair, err error) {
+	defer func(start time.Time) { is.record("Watch", start, err) }(time.Now())
+	return is.store.Watch(key, stopCh)
+}
+
+func (is *instrumentedStore) WatchTree(directory string, stopCh <-chan struct{}) (ch <-chan []*store.KVPair, err error) {
+	defer func(start time.Time) { is.record("WatchTree", start, err) }(time.Now())
+	return is.store.WatchTree(directory, stopCh)
+}
+
+func (is *instrumentedStore) NewLock(key string, options *store.LockOptions) (l store.Locker, err error) {
+	defer func(start time.Time) { is.record("NewLock", start, err) }(time.Now())
+	return is.store.NewLock(key, options)
+}
+
+func (is *instrumentedStore) List(directory string) (kvps []*store.KVPair, err error) {
+	defer func(start time.Time) { is.record("List", start, err) }(time.Now())
+	return is.store.List(directory)
+}
+
+func (is *instrumentedStore) DeleteTree(directory string) (err error) {
+	defer func(start time.Time) { is.record("DeleteTree", start, err) }(time.Now())
+	return is.store.DeleteTree(directory)
+}
+
+func (is *instrumentedStore) AtomicPut(key string, value []byte, previous *store.KVPair, options *store.WriteOptions) (ok bool, kvp *store.KVPair, err error) {
+	defer func(start time.Time) { is.record("AtomicPut", start, err) }(time.Now())
+	return is.store.AtomicPut(key, value, previous, options)
+}
+
+func (is *instrumentedStore) AtomicDelete(key string, previous *store.KVPair) (ok bool, err error) {
+	defer func(start time.Time) { is.record("AtomicDelete", start, err) }(time.Now())
+	return is.store.AtomicDelete(key, previous)
+}
+
+func (is *instrumentedStore) Close() {
+	is.store.Close()
+}
@@ -0,0 +1,241 @@
+package libnetwork
+
+import (
+	"sort"
+
+	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/types"
+)
+
+// Report is a snapshot of the controller state, meant to be attached to bug reports
+type Report struct {
+	Networks  []NetworkReport  `json:"networks"`
+	Sandboxes []SandboxReport  `json:"sandboxes"`
+	IpamPools []IpamPoolReport `json:"ipam_pools"`
+	Drivers   []DriverReport   `json:"drivers"`
+}
+
+// NetworkReport describes a network and its endpoints
+type NetworkReport struct {
+	Name          string           `json:"name"`
+	ID            string           `json:"id"`
+	Type          string           `json:"type"`
+	EndpointCount uint64           `json:"endpoint_count"`
+	MaxEndpoints  uint64           `json:"max_endpoints"`
+	IpamPool      string           `json:"ipam_pool,omitempty"`
+	IpamPoolV6    string           `json:"ipam_pool_v6,omitempty"`
+	Endpoints     []EndpointReport `json:"endpoints"`
+	FDB           []FDBEntry       `json:"fdb,omitempty"`
+	Neighbors     []NeighborEntry  `json:"neighbors,omitempty"`
+	TablesError   string           `json:"tables_error,omitempty"`
+}
+
+// EndpointReport describes an endpoint, as seen by libnetwork and by its driver
+type EndpointReport struct {
+	Name            string                 `json:"name"`
+	ID              string                 `json:"id"`
+	Sandbox         string                 `json:"sandbox,omitempty"`
+	Interfaces      []InterfaceReport      `json:"interfaces"`
+	Gateway         string                 `json:"gateway,omitempty"`
+	GatewayIPv6     string                 `json:"gateway_ipv6,omitempty"`
+	DriverInfo      map[string]interface{} `json:"driver_info,omitempty"`
+	DriverInfoError string                 `json:"driver_info_error,omitempty"`
+	JoinFailures    uint64                 `json:"join_failures,omitempty"`
+	LeaveFailures   uint64                 `json:"leave_failures,omitempty"`
+}
+
+// InterfaceReport describes an endpoint interface
+type InterfaceReport struct {
+	ID          int    `json:"id"`
+	MacAddress  string `json:"mac_address"`
+	Address     string `json:"address,omitempty"`
+	AddressIPv6 string `json:"address_ipv6,omitempty"`
+}
+
+// SandboxReport describes a sandbox and the endpoints it joined
+type SandboxReport struct {
+	ID          string   `json:"id"`
+	ContainerID string   `json:"container_id"`
+	Key         string   `json:"key"`
+	Endpoints   []string `json:"endpoints"`
+}
+
+// IpamPoolReport describes a named ipam pool and its utilization
+type IpamPoolReport struct {
+	Name      string   `json:"name"`
+	Subnet    string   `json:"subnet"`
+	Networks  []string `json:"networks"`
+	Addresses uint32   `json:"addresses"`
+	Free      uint32   `json:"free"`
+}
+
+// DriverReport describes a registered driver
+type DriverReport struct {
+	Type  string `json:"type"`
+	Scope string `json:"scope"`
+}
+
+// Metrics holds the counters of the operations the controller depends on
+type Metrics struct {
+	// Store accounts for the datastore operations, keyed by operation name
+	Store map[string]datastore.OpMetrics `json:"store"`
+}
+
+func (c *controller) Metrics() Metrics {
+	c.Lock()
+	cs := c.store
+	c.Unlock()
+
+	m := Metrics{Store: map[string]datastore.OpMetrics{}}
+	if cs != nil {
+		m.Store = cs.Metrics()
+	}
+	return m
+}
+
+func (c *controller) Diagnostics() (Report, error) {
+	r := Report{
+		Networks:  []NetworkReport{},
+		Sandboxes: []SandboxReport{},
+		IpamPools: []IpamPoolReport{},
+		Drivers:   []DriverReport{},
+	}
+
+	for _, n := range c.Networks() {
+		r.Networks = append(r.Networks, n.(*network).report())
+	}
+
+	for _, s := range c.Sandboxes() {
+		r.Sandboxes = append(r.Sandboxes, s.(*sandbox).report())
+	}
+
+	for _, p := range c.IpamPools() {
+		r.IpamPools = append(r.IpamPools, IpamPoolReport{
+			Name:      p.Name,
+			Subnet:    p.Subnet.String(),
+			Networks:  p.Networks,
+			Addresses: p.Addresses,
+			Free:      p.Addresses - p.Allocated,
+		})
+	}
+
+	c.Lock()
+	for ntype, d := range c.drivers {
+		scope := "local"
+		if d.capability.Scope == driverapi.GlobalScope {
+			scope = "global"
+		}
+		r.Drivers = append(r.Drivers, DriverReport{Type: ntype, Scope: scope})
+	}
+	c.Unlock()
+
+	// Map iteration order is random, keep the report stable
+	sort.Sort(byNetworkName(r.Networks))
+	sort.Sort(byDriverType(r.Drivers))
+
+	return r, nil
+}
+
+func (n *network) report() NetworkReport {
+	n.Lock()
+	nr := NetworkReport{
+		Name:          n.name,
+		ID:            n.id,
+		Type:          n.networkType,
+		EndpointCount: n.endpointCnt,
+		MaxEndpoints:  n.maxEndpoints,
+		IpamPool:      n.ipamPool,
+		IpamPoolV6:    n.ipamPoolV6,
+		Endpoints:     []EndpointReport{},
+	}
+	n.Unlock()
+
+	for _, e := range n.Endpoints() {
+		nr.Endpoints = append(nr.Endpoints, e.(*endpoint).report())
+	}
+
+	// Most drivers do not back their networks with host devices
+	var err error
+	if nr.FDB, err = n.FDB(); err == nil {
+		nr.Neighbors, err = n.Neighbors()
+	}
+	if err != nil {
+		if _, ok := err.(types.NotImplementedError); !ok {
+			nr.TablesError = err.Error()
+		}
+	}
+
+	return nr
+}
+
+func (ep *endpoint) report() EndpointReport {
+	ep.Lock()
+	er := EndpointReport{
+		Name:          ep.name,
+		ID:            ep.id,
+		Sandbox:       ep.sandboxID,
+		Interfaces:    []InterfaceReport{},
+		JoinFailures:  ep.failures.Join,
+		LeaveFailures: ep.failures.Leave,
+	}
+	for _, i := range ep.iFaces {
+		ir := InterfaceReport{ID: i.id, MacAddress: i.mac.String()}
+		if len(i.addr.IP) != 0 {
+			ir.Address = i.addr.String()
+		}
+		if len(i.addrv6.IP) != 0 {
+			ir.AddressIPv6 = i.addrv6.String()
+		}
+		er.Interfaces = append(er.Interfaces, ir)
+	}
+	if ep.joinInfo != nil {
+		if len(ep.joinInfo.gw) != 0 {
+			er.Gateway = ep.joinInfo.gw.String()
+		}
+		if len(ep.joinInfo.gw6) != 0 {
+			er.GatewayIPv6 = ep.joinInfo.gw6.String()
+		}
+	}
+	ep.Unlock()
+
+	info, err := ep.DriverInfo()
+	if err != nil {
+		er.DriverInfoError = err.Error()
+	}
+	er.DriverInfo = info
+
+	return er
+}
+
+func (sb *sandbox) report() SandboxReport {
+	sb.Lock()
+	defer sb.Unlock()
+
+	sr := SandboxReport{
+		ID:          sb.id,
+		ContainerID: sb.containerID,
+		Key:         sb.Key(),
+		Endpoints:   []string{},
+	}
+	for _, ep := range sb.endpoints {
+		sr.Endpoints = append(sr.Endpoints, ep.ID())
+	}
+	for epid := range sb.lazyEps {
+		sr.Endpoints = append(sr.Endpoints, epid)
+	}
+
+	return sr
+}
+
+type byNetworkName []NetworkReport
+
+func (b byNetworkName) Len() int           { return len(b) }
+func (b byNetworkName) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byNetworkName) Less(i, j int) bool { return b[i].Name < b[j].Name }
+
+type byDriverType []DriverReport
+
+func (b byDriverType) Len() int           { return len(b) }
+func (b byDriverType) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byDriverType) Less(i, j int) bool { return b[i].Type < b[j].Type }
@@ -43,6 +43,135 @@ type Driver interface {
 	Type() string
 }
 
+// NetworkInfoDriver is implemented by the drivers which report operational data
+// about their networks. It is optional, on top of the Driver interface.
+type NetworkInfoDriver interface {
+	// NetworkOperInfo retrieves from the driver the operational data related to the specified network
+	NetworkOperInfo(nid string) (map[string]interface{}, error)
+}
+
+// NetworkUpdateDriver is implemented by the drivers able to change some options
+// of their networks at runtime. It is optional, on top of the Driver interface.
+type NetworkUpdateDriver interface {
+	// UpdateNetwork applies the passed options, in the format of the CreateNetwork
+	// ones, to the specified network. Options which cannot be updated are rejected.
+	UpdateNetwork(nid string, options map[string]interface{}) error
+}
+
+// NetworkVerifyDriver is implemented by the drivers able to check the host
+// resources of their networks. It is optional, on top of the Driver interface.
+type NetworkVerifyDriver interface {
+	// VerifyNetwork compares the host resources of the specified network against
+	// the ones the driver configured and returns the differences found
+	VerifyNetwork(nid string) ([]Discrepancy, error)
+}
+
+// AddressPreviewDriver is implemented by the drivers which allocate the endpoint
+// addresses themselves and can tell the next one. It is optional, on top of
+// the Driver interface.
+type AddressPreviewDriver interface {
+	// PreviewAddress returns the IPv4 address the next endpoint created on the
+	// specified network would get, without reserving it
+	PreviewAddress(nid string) (net.IP, error)
+}
+
+// PoolAddressDriver is implemented by the drivers able to program the endpoint
+// addresses libnetwork allocates from an ipam pool, which are passed in the
+// interfaces of the endpoint info on CreateEndpoint. It is optional, on top of
+// the Driver interface: the networks of the other drivers cannot use a pool.
+type PoolAddressDriver interface {
+	// SupportsPoolAddresses tells whether the driver programs the passed addresses
+	SupportsPoolAddresses() bool
+}
+
+// EndpointDrainDriver is implemented by the drivers able to stop the new
+// connections to an endpoint while the established ones go on. It is
+// optional, on top of the Driver interface.
+type EndpointDrainDriver interface {
+	// DrainEndpoint blocks, or unblocks if drain is false, the new connections
+	// to the specified endpoint. The block is lifted when the endpoint leaves.
+	DrainEndpoint(nid, eid string, drain bool) error
+
+	// EndpointConnections returns the number of connections the kernel tracks
+	// to the specified endpoint
+	EndpointConnections(nid, eid string) (int, error)
+}
+
+// PortBindingDriver is implemented by the drivers able to reserve the host ports
+// of the endpoint port mappings before forwarding them. It is optional, on top
+// of the Driver interface.
+type PortBindingDriver interface {
+	// ActivatePortBindings forwards the host ports claimed for the specified
+	// endpoint, created with the netlabel.PortMapClaimOnly option
+	ActivatePortBindings(nid, eid string) error
+}
+
+// EndpointLinkDriver is implemented by the drivers able to defer the creation
+// of the endpoint interfaces until the endpoint is activated in its sandbox. It
+// is optional, on top of the Driver interface.
+type EndpointLinkDriver interface {
+	// CreateEndpointLink creates the interfaces of the specified endpoint,
+	// created with the netlabel.LazyLink option. It is a no-op once they exist.
+	CreateEndpointLink(nid, eid string) error
+}
+
+// NetworkTablesDriver is implemented by the drivers able to dump the link layer
+// tables of the host devices backing their networks. It is optional, on top of
+// the Driver interface.
+type NetworkTablesDriver interface {
+	// NetworkFDB returns the forwarding database entries of the specified network
+	NetworkFDB(nid string) ([]FDBEntry, error)
+
+	// NetworkNeighbors returns the host neighbor entries on the devices of the
+	// specified network
+	NetworkNeighbors(nid string) ([]NeighborEntry, error)
+}
+
+// NetworkFirewallDriver is implemented by the drivers programming host firewall
+// rules for their networks. It is optional, on top of the Driver interface.
+type NetworkFirewallDriver interface {
+	// NetworkFirewallRules returns the rules currently installed on the host for
+	// the specified network, one rendered rule per line
+	NetworkFirewallRules(nid string) ([]string, error)
+}
+
+// TeardownDriver is implemented by the drivers running background tasks, which
+// are stopped once the controller drops the driver. It is optional, on top of
+// the Driver interface.
+type TeardownDriver interface {
+	// Teardown stops the background tasks of the driver
+	Teardown()
+}
+
+// FDBEntry is a forwarding database entry: frames to MacAddress go out of Interface
+type FDBEntry struct {
+	MacAddress net.HardwareAddr
+	Interface  string
+	// Permanent is set for the entries not learned from the traffic, like the
+	// addresses of the network devices themselves
+	Permanent bool
+}
+
+// NeighborEntry is a neighbor cache entry: IP is reachable at MacAddress through Interface
+type NeighborEntry struct {
+	IP         net.IP
+	MacAddress net.HardwareAddr
+	Interface  string
+	// State is the state of the entry, as named by iproute2
+	State string
+}
+
+// Discrepancy describes a difference between the intended state of a network
+// resource and the one found on the host
+type Discrepancy struct {
+	// Interface is the host interface the resource belongs to
+	Interface string
+	// Resource is the kind of resource, as defined by the driver
+	Resource string
+	Expected string
+	Actual   string
+}
+
 // EndpointInfo provides a go interface to fetch or populate endpoint assigned network resources.
 type EndpointInfo interface {
 	// Interfaces returns a list of interfaces bound to the endpoint.
@@ -111,6 +240,14 @@ type DriverCallback interface {
 	RegisterDriver(name string, driver Driver, capability Capability) error
 }
 
+// DriverResyncCallback is implemented by the DriverCallback able to replay the
+// networks and endpoints of a driver which lost them, like a restarted remote
+// driver plugin
+type DriverResyncCallback interface {
+	// ResyncDriver replays the networks and endpoints of the NetworkType to its driver
+	ResyncDriver(name string) error
+}
+
 // Scope indicates the drivers scope capability
 type Scope int
 
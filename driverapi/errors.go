@@ -54,3 +54,34 @@ func (ar ErrActiveRegistration) Error() string {
 
 // Forbidden denotes the type of this error
 func (ar ErrActiveRegistration) Forbidden() {}
+
+// Error codes a driver can set on a DriverError. They select the kind of error
+// the error is reported as to the libnetwork user.
+const (
+	// ErrCodeBadRequest denotes a request the driver found invalid
+	ErrCodeBadRequest = "BadRequest"
+	// ErrCodeForbidden denotes a valid request the driver cannot honor
+	ErrCodeForbidden = "Forbidden"
+	// ErrCodeNotFound denotes a resource the request needs which the driver does not know
+	ErrCodeNotFound = "NotFound"
+	// ErrCodeInternal denotes a failure internal to the driver
+	ErrCodeInternal = "Internal"
+)
+
+// DriverError is a structured error returned by a driver. Code tells the kind
+// of failure, Message describes it and Retryable tells whether the same request
+// may succeed later.
+type DriverError struct {
+	Code      string
+	Message   string
+	Retryable bool
+}
+
+func (de *DriverError) Error() string {
+	return de.Message
+}
+
+// Temporary returns whether the failed request may succeed when retried
+func (de *DriverError) Temporary() bool {
+	return de.Retryable
+}
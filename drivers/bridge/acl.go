@@ -0,0 +1,96 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/types"
+)
+
+const aclChainPrefix = "DOCKER-ACL-"
+
+// programEndpointChain creates/removes the per endpoint filter chains. Tests
+// replace it
+var programEndpointChain = func(name string, create bool) error {
+	if create {
+		_, err := iptables.NewChain(name, iptables.Filter, false)
+		return err
+	}
+	return iptables.RemoveExistingChain(name, iptables.Filter)
+}
+
+// aclChainName returns the name of the chain holding the endpoint access
+// control list, within the iptables chain name length limit
+func aclChainName(eid string) string {
+	if len(eid) > 12 {
+		eid = eid[:12]
+	}
+	return aclChainPrefix + eid
+}
+
+// getACLRules returns the filter rules of the endpoint chain enforcing the
+// access control list, in order. An allowed packet returns to the FORWARD
+// chain, where the network isolation and ICC rules still apply to it: the list
+// can only restrict the traffic.
+func getACLRules(chain string, acl []types.ACLRule) []iptRule {
+	rules := make([]iptRule, 0, len(acl))
+	for _, r := range acl {
+		var args []string
+		if r.Source != nil {
+			args = append(args, "-s", r.Source.String())
+		}
+		if r.Proto != 0 {
+			args = append(args, "-p", r.Proto.String())
+		}
+		if r.Port != 0 {
+			args = append(args, "--dport", strconv.Itoa(int(r.Port)))
+		}
+		target := "DROP"
+		if r.Action == types.ACLAllow {
+			target = "RETURN"
+		}
+		rules = append(rules, iptRule{table: iptables.Filter, chain: chain, args: append(args, "-j", target)})
+	}
+	return rules
+}
+
+// getACLJumpRule returns the FORWARD rule sending the traffic forwarded through
+// the bridge to the endpoint address into the endpoint chain
+func getACLJumpRule(bridgeName string, ip net.IP, chain string) iptRule {
+	return iptRule{table: iptables.Filter, chain: "FORWARD", args: []string{"-o", bridgeName, "-d", ip.String(), "-j", chain}}
+}
+
+// Install/Removes the endpoint access control rules. Rules are inserted at the
+// top of the endpoint chain, hence in reverse order to be evaluated as listed.
+func setEndpointACL(config *networkConfiguration, ep *bridgeEndpoint, enable bool) error {
+	if ep.config == nil || len(ep.config.ACL) == 0 || ep.addr == nil {
+		return nil
+	}
+
+	chain := aclChainName(ep.id)
+	jump := getACLJumpRule(config.BridgeName, ep.addr.IP, chain)
+	if !enable {
+		if err := programEndpointRule(jump, "ACL", false); err != nil {
+			return err
+		}
+		return programEndpointChain(chain, false)
+	}
+
+	if err := programEndpointChain(chain, true); err != nil {
+		return fmt.Errorf("failed to create the ACL chain %s: %v", chain, err)
+	}
+	rules := getACLRules(chain, ep.config.ACL)
+	for i := len(rules) - 1; i >= 0; i-- {
+		if err := programEndpointRule(rules[i], fmt.Sprintf("ACL %s", ep.config.ACL[i]), true); err != nil {
+			programEndpointChain(chain, false)
+			return err
+		}
+	}
+	if err := programEndpointRule(jump, "ACL", true); err != nil {
+		programEndpointChain(chain, false)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package bridge
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/types"
+)
+
+// aclPacket is a packet forwarded to the endpoint, as seen by the fake filter table
+type aclPacket struct {
+	in, src string
+	proto   string
+	port    string
+}
+
+// fakeFilter is a minimal filter table model: the rules match on the input
+// interface, the source network, the protocol and the destination port.
+type fakeFilter map[string][][]string
+
+func (f fakeFilter) verdict(chain string, p aclPacket) string {
+	for _, args := range f[chain] {
+		matched, target := true, ""
+		for i := 0; i < len(args); i += 2 {
+			switch args[i] {
+			case "-i":
+				matched = matched && args[i+1] == p.in
+			case "-s":
+				_, n, _ := net.ParseCIDR(args[i+1])
+				matched = matched && n.Contains(net.ParseIP(p.src))
+			case "-p":
+				matched = matched && args[i+1] == p.proto
+			case "--dport":
+				matched = matched && args[i+1] == p.port
+			case "-j":
+				target = args[i+1]
+			}
+		}
+		if !matched {
+			continue
+		}
+		switch target {
+		case "ACCEPT", "DROP":
+			return target
+		case "RETURN":
+			return ""
+		}
+		if v := f.verdict(target, p); v != "" {
+			return v
+		}
+	}
+	if chain == "FORWARD" {
+		return "ACCEPT"
+	}
+	return ""
+}
+
+func TestEndpointACL(t *testing.T) {
+	// The isolation rule dropping the traffic from another network
+	isolation := []string{"-i", "br-other", "-o", "br-acl", "-j", "DROP"}
+	fw := fakeFilter{"FORWARD": {isolation}}
+
+	defer func(f func(iptRule, string, bool) error) { programEndpointRule = f }(programEndpointRule)
+	programEndpointRule = func(rule iptRule, descr string, insert bool) error {
+		if insert {
+			fw[rule.chain] = append([][]string{rule.args}, fw[rule.chain]...)
+			return nil
+		}
+		for i, args := range fw[rule.chain] {
+			if reflect.DeepEqual(args, rule.args) {
+				fw[rule.chain] = append(fw[rule.chain][:i], fw[rule.chain][i+1:]...)
+				break
+			}
+		}
+		return nil
+	}
+	defer func(f func(string, bool) error) { programEndpointChain = f }(programEndpointChain)
+	programEndpointChain = func(name string, create bool) error {
+		if create {
+			fw[name] = [][]string{}
+		} else {
+			delete(fw, name)
+		}
+		return nil
+	}
+
+	_, src, _ := net.ParseCIDR("10.0.0.0/8")
+	acl := []types.ACLRule{
+		{Action: types.ACLAllow, Proto: types.TCP, Port: 443, Source: src},
+		{Action: types.ACLDeny},
+	}
+	epConfig, err := parseEndpointOptions(map[string]interface{}{netlabel.ACL: acl})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &networkConfiguration{BridgeName: "br-acl"}
+	ep := &bridgeEndpoint{
+		id:     "0123456789abcdef",
+		config: epConfig,
+		addr:   &net.IPNet{IP: net.ParseIP("172.18.0.2"), Mask: net.CIDRMask(16, 32)},
+	}
+
+	if err := setEndpointACL(config, ep, true); err != nil {
+		t.Fatal(err)
+	}
+
+	chain := "DOCKER-ACL-0123456789ab"
+	expected := fakeFilter{
+		"FORWARD": {{"-o", "br-acl", "-d", "172.18.0.2", "-j", chain}, isolation},
+		chain: {
+			{"-s", "10.0.0.0/8", "-p", "tcp", "--dport", "443", "-j", "RETURN"},
+			{"-j", "DROP"},
+		},
+	}
+	if !reflect.DeepEqual(fw, expected) {
+		t.Fatalf("Unexpected rules programmed on join.\nExpected: %v\nGot:      %v", expected, fw)
+	}
+
+	for _, c := range []struct {
+		p       aclPacket
+		verdict string
+	}{
+		{aclPacket{in: "eth0", src: "10.1.1.1", proto: "tcp", port: "443"}, "ACCEPT"},
+		{aclPacket{in: "eth0", src: "10.1.1.1", proto: "tcp", port: "80"}, "DROP"},
+		{aclPacket{in: "eth0", src: "192.168.1.1", proto: "tcp", port: "443"}, "DROP"},
+		// An allowed packet is still subject to the network isolation
+		{aclPacket{in: "br-other", src: "10.1.1.1", proto: "tcp", port: "443"}, "DROP"},
+	} {
+		if v := fw.verdict("FORWARD", c.p); v != c.verdict {
+			t.Fatalf("Expected %s for %v. Got %s", c.verdict, c.p, v)
+		}
+	}
+
+	if err := setEndpointACL(config, ep, false); err != nil {
+		t.Fatal(err)
+	}
+	expected = fakeFilter{"FORWARD": {isolation}}
+	if !reflect.DeepEqual(fw, expected) {
+		t.Fatalf("Unexpected rules left on leave.\nExpected: %v\nGot:      %v", expected, fw)
+	}
+}
+
+func TestEndpointACLValidation(t *testing.T) {
+	for _, r := range []types.ACLRule{
+		{Action: "reject"},
+		{Action: types.ACLAllow, Port: 80},
+		{Action: types.ACLAllow, Proto: types.ICMP, Port: 80},
+	} {
+		_, err := parseEndpointOptions(map[string]interface{}{netlabel.ACL: []types.ACLRule{r}})
+		if _, ok := err.(types.BadRequestError); !ok {
+			t.Fatalf("Expected a bad request error for acl rule %s. Got %v", r, err)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package bridge
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+// addressGroupPrefixLen is the size of the sub-ranges the endpoints of an
+// address group are spread across
+const addressGroupPrefixLen = 28
+
+// requestGroupAddress allocates the endpoint an address from the first sub-range
+// of the network holding no other endpoint of the group. It returns nil when
+// every sub-range holds one or is full, for the caller to fall back to any free
+// address.
+func (n *bridgeNetwork) requestGroupAddress(eid, group string) net.IP {
+	n.Lock()
+	subnet := n.bridge.bridgeIPv4
+	var taken []net.IP
+	for _, ep := range n.endpoints {
+		if ep.id != eid && ep.config != nil && ep.config.AddressGroup == group && ep.addr != nil {
+			taken = append(taken, ep.addr.IP)
+		}
+	}
+	n.Unlock()
+
+	if subnet == nil || subnet.IP.To4() == nil {
+		return nil
+	}
+	ones, _ := subnet.Mask.Size()
+	if ones >= addressGroupPrefixLen {
+		return nil
+	}
+
+	base := binary.BigEndian.Uint32(subnet.IP.To4().Mask(subnet.Mask))
+	shift := uint(32 - addressGroupPrefixLen)
+	used := make(map[uint32]bool, len(taken))
+	for _, ip := range taken {
+		if ip4 := ip.To4(); ip4 != nil {
+			used[(binary.BigEndian.Uint32(ip4)-base)>>shift] = true
+		}
+	}
+
+	for i := uint32(0); i < 1<<uint(addressGroupPrefixLen-ones); i++ {
+		if used[i] {
+			continue
+		}
+		start, end := make(net.IP, 4), make(net.IP, 4)
+		binary.BigEndian.PutUint32(start, base+i<<shift)
+		binary.BigEndian.PutUint32(end, base+(i+1)<<shift-1)
+		if ip, err := ipAllocator.RequestIPInRange(subnet, start, end); err == nil {
+			return ip
+		}
+	}
+
+	return nil
+}
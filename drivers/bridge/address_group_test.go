@@ -0,0 +1,56 @@
+package bridge
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/netutils"
+)
+
+func TestAddressGroupSpread(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	d := newDriver()
+
+	if err := d.Config(nil); err != nil {
+		t.Fatalf("Failed to setup driver config: %v", err)
+	}
+
+	ip, subnet, _ := net.ParseCIDR("172.29.40.1/26")
+	subnet.IP = ip
+	netconfig := &networkConfiguration{BridgeName: DefaultBridgeName, AddressIPv4: subnet}
+	netOptions := make(map[string]interface{})
+	netOptions[netlabel.GenericData] = netconfig
+
+	if err := d.CreateNetwork("net1", netOptions); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	// The /26 network has four /28 sub-ranges, each of the groups gets one address in every one of them
+	used := map[string]map[byte]string{"a": {}, "b": {}}
+	for i := 0; i < 4; i++ {
+		for _, group := range []string{"a", "b"} {
+			eid := fmt.Sprintf("ep-%s%d", group, i)
+			te := &testEndpoint{ifaces: []*testInterface{}}
+			if err := d.CreateEndpoint("net1", eid, te, map[string]interface{}{netlabel.AddressGroup: group}); err != nil {
+				t.Fatalf("Failed to create endpoint %s: %v", eid, err)
+			}
+			addr := te.ifaces[0].addr.IP.To4()
+			sub := addr[3] >> 4
+			if other, ok := used[group][sub]; ok {
+				t.Fatalf("Endpoint %s of group %s got %s, in the sub-range of %s", eid, group, addr, other)
+			}
+			used[group][sub] = eid
+		}
+	}
+
+	// With the sub-ranges exhausted, the group falls back to any free address
+	te := &testEndpoint{ifaces: []*testInterface{}}
+	if err := d.CreateEndpoint("net1", "ep-a4", te, map[string]interface{}{netlabel.AddressGroup: "a"}); err != nil {
+		t.Fatalf("Failed to create an endpoint past the group sub-ranges: %v", err)
+	}
+	if !subnet.Contains(te.ifaces[0].addr.IP) {
+		t.Fatalf("Endpoint address %s out of the network %s", te.ifaces[0].addr.IP, subnet)
+	}
+}
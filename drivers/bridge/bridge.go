@@ -12,6 +12,7 @@ import (
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/docker/libnetwork/driverapi"
@@ -43,6 +44,42 @@ type configuration struct {
 	EnableIPForwarding  bool
 	EnableIPTables      bool
 	EnableUserlandProxy bool
+	// AllowedHostPortStart and AllowedHostPortEnd bound the host ports
+	// endpoints can publish to. No bound is enforced when both are zero.
+	AllowedHostPortStart int
+	AllowedHostPortEnd   int
+}
+
+// Validate performs a static validation on the driver configuration
+func (c *configuration) Validate() error {
+	if c.AllowedHostPortStart == 0 && c.AllowedHostPortEnd == 0 {
+		return nil
+	}
+	if c.AllowedHostPortStart < 1 || c.AllowedHostPortEnd > 65535 || c.AllowedHostPortStart > c.AllowedHostPortEnd {
+		return &ErrInvalidDriverConfig{}
+	}
+	return nil
+}
+
+// checkPortBindings fails if a binding publishes to a host port outside the
+// allowed range. Bindings letting the driver pick the host port are accepted.
+func (c *configuration) checkPortBindings(epConfig *endpointConfiguration) error {
+	if epConfig == nil || (c.AllowedHostPortStart == 0 && c.AllowedHostPortEnd == 0) {
+		return nil
+	}
+	for _, pb := range epConfig.PortBindings {
+		if pb.HostPort == 0 {
+			continue
+		}
+		end := pb.HostPortEnd
+		if end == 0 {
+			end = pb.HostPort
+		}
+		if int(pb.HostPort) < c.AllowedHostPortStart || int(end) > c.AllowedHostPortEnd {
+			return ErrPortNotAllowed(fmt.Sprintf("%d-%d", pb.HostPort, end))
+		}
+	}
+	return nil
 }
 
 // networkConfiguration for network specific configuration
@@ -50,22 +87,53 @@ type networkConfiguration struct {
 	BridgeName            string
 	AddressIPv4           *net.IPNet
 	FixedCIDR             *net.IPNet
+	FixedRangeStart       net.IP
+	FixedRangeEnd         net.IP
 	FixedCIDRv6           *net.IPNet
 	EnableIPv6            bool
+	EnableIPv6RA          bool
 	EnableIPMasquerade    bool
 	EnableICC             bool
+	DisableForwarding     bool
 	Mtu                   int
 	DefaultGatewayIPv4    net.IP
 	DefaultGatewayIPv6    net.IP
 	DefaultBindingIP      net.IP
 	AllowNonDefaultBridge bool
+	EnableIPTablesLogging bool
+	IPTablesLogPrefix     string
+	IPTablesLogLimit      string
+	TxQueueLen            int
+	Offloads              map[string]bool
+	AllocationStrategy    string
+	ConntrackZone         int
+	AgeingTime            int
+	ForwardDelay          int
+	GroupFwdMask          uint16
+	EgressInterface       string
+	// Socket options of the userland proxy TCP connections, in seconds and bytes
+	ProxyKeepAlive         bool
+	ProxyKeepAliveInterval int
+	ProxyReadBuffer        int
+	ProxyWriteBuffer       int
 }
 
 // endpointConfiguration represents the user specified configuration for the sandbox endpoint
 type endpointConfiguration struct {
 	MacAddress   net.HardwareAddr
+	IPAddress    net.IP
 	PortBindings []types.PortBinding
+	PortTarget   net.IP
+	PortClaim    bool
+	LazyLink     bool
 	ExposedPorts []types.TransportPort
+	TxQueueLen   int
+	Offloads     map[string]bool
+	ACL          []types.ACLRule
+	DSCP         *int
+	ConnLimit    int
+	VIP          net.IP
+	AddressGroup string
 }
 
 // containerConfiguration represents the user specified configuration for a container
@@ -77,12 +145,20 @@ type containerConfiguration struct {
 type bridgeEndpoint struct {
 	id              string
 	srcName         string
+	hostIfName      string
 	addr            *net.IPNet
 	addrv6          *net.IPNet
 	macAddress      net.HardwareAddr
 	config          *endpointConfiguration // User specified parameters
 	containerConfig *containerConfiguration
 	portMapping     []types.PortBinding // Operation port bindings
+	portsClaimed    bool                // Whether the host ports are reserved but not forwarded yet
+	txQueueLen      int                 // Operation transmit queue length, 0 if left untouched
+	mtu             int                 // Operation MTU, 0 if left untouched
+	mtuClamped      bool                // Whether the MTU was lowered to the one of the bridge uplinks
+	offloads        map[string]bool     // Operation offload settings
+	draining        bool                // Whether the new connections to the endpoint are blocked
+	linkDeferred    bool                // Whether the pipe is only created on the endpoint activation
 }
 
 type bridgeNetwork struct {
@@ -91,7 +167,9 @@ type bridgeNetwork struct {
 	config     *networkConfiguration
 	endpoints  map[string]*bridgeEndpoint // key: endpoint id
 	portMapper *portmapper.PortMapper
-	driver     *driver // The network's driver
+	driver     *driver      // The network's driver
+	ra         *raResponder // Router advertisements responder, if enabled
+	egressRule *iptRule     // The egress interface address translation rule, if programmed
 	sync.Mutex
 }
 
@@ -101,6 +179,7 @@ type driver struct {
 	natChain    *iptables.ChainInfo
 	filterChain *iptables.ChainInfo
 	networks    map[string]*bridgeNetwork
+	veths       vethNameTable
 	sync.Mutex
 }
 
@@ -136,6 +215,12 @@ func Init(dc driverapi.DriverCallback) error {
 	return dc.RegisterDriver(networkType, newDriver(), c)
 }
 
+// ipv6Configured tells whether the network has an IPv6 container subnet,
+// in which case it can do without an IPv4 one
+func (c *networkConfiguration) ipv6Configured() bool {
+	return c.EnableIPv6 && c.FixedCIDRv6 != nil
+}
+
 // Validate performs a static validation on the network configuration parameters.
 // Whatever can be assessed a priori before attempting any programming.
 func (c *networkConfiguration) Validate() error {
@@ -143,6 +228,53 @@ func (c *networkConfiguration) Validate() error {
 		return ErrInvalidMtu(c.Mtu)
 	}
 
+	if len(c.IPTablesLogPrefix) > maxLogPrefixLen {
+		return ErrInvalidLogPrefix(c.IPTablesLogPrefix)
+	}
+
+	if c.TxQueueLen < 0 {
+		return ErrInvalidTxQueueLen(c.TxQueueLen)
+	}
+
+	if c.ConntrackZone < 0 || c.ConntrackZone > maxConntrackZone {
+		return ErrInvalidConntrackZone(c.ConntrackZone)
+	}
+
+	if c.AgeingTime != 0 && (c.AgeingTime < minAgeingTime || c.AgeingTime > maxAgeingTime) {
+		return ErrInvalidAgeingTime(c.AgeingTime)
+	}
+
+	if c.ForwardDelay != 0 && (c.ForwardDelay < minForwardDelay || c.ForwardDelay > maxForwardDelay) {
+		return ErrInvalidForwardDelay(c.ForwardDelay)
+	}
+
+	if c.GroupFwdMask&restrictedGroupFwdMask != 0 {
+		return ErrInvalidGroupFwdMask(c.GroupFwdMask)
+	}
+
+	for opt, v := range map[string]int{
+		"ProxyKeepAliveInterval": c.ProxyKeepAliveInterval,
+		"ProxyReadBuffer":        c.ProxyReadBuffer,
+		"ProxyWriteBuffer":       c.ProxyWriteBuffer,
+	} {
+		if v < 0 {
+			return ErrInvalidProxyOption(fmt.Sprintf("%s %d", opt, v))
+		}
+	}
+
+	for f := range c.Offloads {
+		if _, ok := offloadCommands[f]; !ok {
+			return ErrInvalidOffload(f)
+		}
+	}
+
+	// The container address range is an alternative to the container subnet
+	if c.FixedRangeStart != nil || c.FixedRangeEnd != nil {
+		if err := c.validateFixedRange(); err != nil {
+			return err
+		}
+	}
+
 	// If bridge v4 subnet is specified
 	if c.AddressIPv4 != nil {
 		// If Container restricted subnet is specified, it must be a subset of bridge subnet
@@ -173,9 +305,29 @@ func (c *networkConfiguration) Validate() error {
 		}
 	}
 
+	// Stateless autoconfiguration needs a /64 prefix to advertise
+	if c.EnableIPv6RA {
+		if !c.EnableIPv6 || c.FixedCIDRv6 == nil {
+			return &ErrInvalidRAPrefix{}
+		}
+		if ones, _ := c.FixedCIDRv6.Mask.Size(); ones != raPrefixLen {
+			return &ErrInvalidRAPrefix{}
+		}
+	}
+
 	return nil
 }
 
+// proxySocketOptions returns the socket options of the userland proxies of the network
+func (c *networkConfiguration) proxySocketOptions() portmapper.SocketOptions {
+	return portmapper.SocketOptions{
+		KeepAlive:         c.ProxyKeepAlive,
+		KeepAliveInterval: time.Duration(c.ProxyKeepAliveInterval) * time.Second,
+		ReadBuffer:        c.ProxyReadBuffer,
+		WriteBuffer:       c.ProxyWriteBuffer,
+	}
+}
+
 // Conflicts check if two NetworkConfiguration objects overlap
 func (c *networkConfiguration) Conflicts(o *networkConfiguration) bool {
 	if o == nil {
@@ -216,6 +368,16 @@ func (c *networkConfiguration) fromMap(data map[string]interface{}) error {
 		}
 	}
 
+	if i, ok := data["TxQueueLen"]; ok && i != nil {
+		if s, ok := i.(string); ok {
+			if c.TxQueueLen, err = strconv.Atoi(s); err != nil {
+				return types.BadRequestErrorf("failed to parse TxQueueLen value: %s", err.Error())
+			}
+		} else {
+			return types.BadRequestErrorf("invalid type for TxQueueLen value")
+		}
+	}
+
 	if i, ok := data["EnableIPv6"]; ok && i != nil {
 		if s, ok := i.(string); ok {
 			if c.EnableIPv6, err = strconv.ParseBool(s); err != nil {
@@ -246,6 +408,90 @@ func (c *networkConfiguration) fromMap(data map[string]interface{}) error {
 		}
 	}
 
+	if i, ok := data["ConntrackZone"]; ok && i != nil {
+		if s, ok := i.(string); ok {
+			if c.ConntrackZone, err = strconv.Atoi(s); err != nil {
+				return types.BadRequestErrorf("failed to parse ConntrackZone value: %s", err.Error())
+			}
+		} else {
+			return types.BadRequestErrorf("invalid type for ConntrackZone value")
+		}
+	}
+
+	if i, ok := data["AgeingTime"]; ok && i != nil {
+		if s, ok := i.(string); ok {
+			if c.AgeingTime, err = strconv.Atoi(s); err != nil {
+				return types.BadRequestErrorf("failed to parse AgeingTime value: %s", err.Error())
+			}
+		} else {
+			return types.BadRequestErrorf("invalid type for AgeingTime value")
+		}
+	}
+
+	if i, ok := data["ForwardDelay"]; ok && i != nil {
+		if s, ok := i.(string); ok {
+			if c.ForwardDelay, err = strconv.Atoi(s); err != nil {
+				return types.BadRequestErrorf("failed to parse ForwardDelay value: %s", err.Error())
+			}
+		} else {
+			return types.BadRequestErrorf("invalid type for ForwardDelay value")
+		}
+	}
+
+	if i, ok := data["GroupFwdMask"]; ok && i != nil {
+		if s, ok := i.(string); ok {
+			var mask uint64
+			if mask, err = strconv.ParseUint(s, 0, 16); err != nil {
+				return types.BadRequestErrorf("failed to parse GroupFwdMask value: %s", err.Error())
+			}
+			c.GroupFwdMask = uint16(mask)
+		} else {
+			return types.BadRequestErrorf("invalid type for GroupFwdMask value")
+		}
+	}
+
+	if i, ok := data["EgressInterface"]; ok && i != nil {
+		if c.EgressInterface, ok = i.(string); !ok {
+			return types.BadRequestErrorf("invalid type for EgressInterface value")
+		}
+	}
+
+	if i, ok := data["ProxyKeepAlive"]; ok && i != nil {
+		if s, ok := i.(string); ok {
+			if c.ProxyKeepAlive, err = strconv.ParseBool(s); err != nil {
+				return types.BadRequestErrorf("failed to parse ProxyKeepAlive value: %s", err.Error())
+			}
+		} else {
+			return types.BadRequestErrorf("invalid type for ProxyKeepAlive value")
+		}
+	}
+
+	for opt, v := range map[string]*int{
+		"ProxyKeepAliveInterval": &c.ProxyKeepAliveInterval,
+		"ProxyReadBuffer":        &c.ProxyReadBuffer,
+		"ProxyWriteBuffer":       &c.ProxyWriteBuffer,
+	} {
+		if i, ok := data[opt]; ok && i != nil {
+			if s, ok := i.(string); ok {
+				if *v, err = strconv.Atoi(s); err != nil {
+					return types.BadRequestErrorf("failed to parse %s value: %s", opt, err.Error())
+				}
+			} else {
+				return types.BadRequestErrorf("invalid type for %s value", opt)
+			}
+		}
+	}
+
+	if i, ok := data["DisableForwarding"]; ok && i != nil {
+		if s, ok := i.(string); ok {
+			if c.DisableForwarding, err = strconv.ParseBool(s); err != nil {
+				return types.BadRequestErrorf("failed to parse DisableForwarding value: %s", err.Error())
+			}
+		} else {
+			return types.BadRequestErrorf("invalid type for DisableForwarding value")
+		}
+	}
+
 	if i, ok := data["AllowNonDefaultBridge"]; ok && i != nil {
 		if s, ok := i.(string); ok {
 			if c.AllowNonDefaultBridge, err = strconv.ParseBool(s); err != nil {
@@ -256,6 +502,38 @@ func (c *networkConfiguration) fromMap(data map[string]interface{}) error {
 		}
 	}
 
+	if i, ok := data["EnableIPv6RA"]; ok && i != nil {
+		if s, ok := i.(string); ok {
+			if c.EnableIPv6RA, err = strconv.ParseBool(s); err != nil {
+				return types.BadRequestErrorf("failed to parse EnableIPv6RA value: %s", err.Error())
+			}
+		} else {
+			return types.BadRequestErrorf("invalid type for EnableIPv6RA value")
+		}
+	}
+
+	if i, ok := data["EnableIPTablesLogging"]; ok && i != nil {
+		if s, ok := i.(string); ok {
+			if c.EnableIPTablesLogging, err = strconv.ParseBool(s); err != nil {
+				return types.BadRequestErrorf("failed to parse EnableIPTablesLogging value: %s", err.Error())
+			}
+		} else {
+			return types.BadRequestErrorf("invalid type for EnableIPTablesLogging value")
+		}
+	}
+
+	if i, ok := data["IPTablesLogPrefix"]; ok && i != nil {
+		if c.IPTablesLogPrefix, ok = i.(string); !ok {
+			return types.BadRequestErrorf("invalid type for IPTablesLogPrefix value")
+		}
+	}
+
+	if i, ok := data["IPTablesLogLimit"]; ok && i != nil {
+		if c.IPTablesLogLimit, ok = i.(string); !ok {
+			return types.BadRequestErrorf("invalid type for IPTablesLogLimit value")
+		}
+	}
+
 	if i, ok := data["AddressIPv4"]; ok && i != nil {
 		if s, ok := i.(string); ok {
 			if ip, nw, e := net.ParseCIDR(s); e == nil {
@@ -282,6 +560,26 @@ func (c *networkConfiguration) fromMap(data map[string]interface{}) error {
 		}
 	}
 
+	if i, ok := data["FixedRangeStart"]; ok && i != nil {
+		if s, ok := i.(string); ok {
+			if c.FixedRangeStart = net.ParseIP(s); c.FixedRangeStart == nil {
+				return types.BadRequestErrorf("failed to parse FixedRangeStart value")
+			}
+		} else {
+			return types.BadRequestErrorf("invalid type for FixedRangeStart value")
+		}
+	}
+
+	if i, ok := data["FixedRangeEnd"]; ok && i != nil {
+		if s, ok := i.(string); ok {
+			if c.FixedRangeEnd = net.ParseIP(s); c.FixedRangeEnd == nil {
+				return types.BadRequestErrorf("failed to parse FixedRangeEnd value")
+			}
+		} else {
+			return types.BadRequestErrorf("invalid type for FixedRangeEnd value")
+		}
+	}
+
 	if i, ok := data["FixedCIDRv6"]; ok && i != nil {
 		if s, ok := i.(string); ok {
 			if ip, nw, e := net.ParseCIDR(s); e == nil {
@@ -365,6 +663,7 @@ func (n *bridgeNetwork) getEndpoint(eid string) (*bridgeEndpoint, error) {
 // from each of the other networks
 func (n *bridgeNetwork) isolateNetwork(others []*bridgeNetwork, enable bool) error {
 	n.Lock()
+	thisConfig := n.config
 	thisV4 := n.bridge.bridgeIPv4
 	thisV6 := getV6Network(n.config, n.bridge)
 	n.Unlock()
@@ -372,21 +671,34 @@ func (n *bridgeNetwork) isolateNetwork(others []*bridgeNetwork, enable bool) err
 	// Install the rules to isolate this networks against each of the other networks
 	for _, o := range others {
 		o.Lock()
+		otherConfig := o.config
 		otherV4 := o.bridge.bridgeIPv4
 		otherV6 := getV6Network(o.config, o.bridge)
 		o.Unlock()
 
-		if !types.CompareIPNet(thisV4, otherV4) {
+		if thisV4 != nil && otherV4 != nil && !types.CompareIPNet(thisV4, otherV4) {
 			// It's ok to pass a.b.c.d/x, iptables will ignore the host subnet bits
 			if err := setINC(thisV4.String(), otherV4.String(), enable); err != nil {
 				return err
 			}
+			if err := setINCLogging(thisConfig, otherV4.String(), thisV4.String(), enable); err != nil {
+				return err
+			}
+			if err := setINCLogging(otherConfig, thisV4.String(), otherV4.String(), enable); err != nil {
+				return err
+			}
 		}
 
 		if thisV6 != nil && otherV6 != nil && !types.CompareIPNet(thisV6, otherV6) {
 			if err := setINC(thisV6.String(), otherV6.String(), enable); err != nil {
 				return err
 			}
+			if err := setINCLogging(thisConfig, otherV6.String(), thisV6.String(), enable); err != nil {
+				return err
+			}
+			if err := setINCLogging(otherConfig, thisV6.String(), otherV6.String(), enable); err != nil {
+				return err
+			}
 		}
 	}
 
@@ -417,7 +729,7 @@ func (c *networkConfiguration) conflictsWithNetworks(id string, others []*bridge
 		// bridges. This could not be completely caught by the config conflict
 		// check, because networks which config does not specify the AddressIPv4
 		// get their address and subnet selected by the driver (see electBridgeIPv4())
-		if c.AddressIPv4 != nil {
+		if c.AddressIPv4 != nil && nwBridge.bridgeIPv4 != nil {
 			if nwBridge.bridgeIPv4.Contains(c.AddressIPv4.IP) ||
 				c.AddressIPv4.Contains(nwBridge.bridgeIPv4.IP) {
 				return types.ForbiddenErrorf("conflicts with network %s (%s) by ip network", nwID, nwConfig.BridgeName)
@@ -454,6 +766,9 @@ func (d *driver) Config(option map[string]interface{}) error {
 			return &ErrInvalidDriverConfig{}
 		}
 
+		if err := config.Validate(); err != nil {
+			return err
+		}
 		d.config = config
 	} else {
 		config = &configuration{}
@@ -532,6 +847,12 @@ func parseNetworkOptions(option options.Generic) (*networkConfiguration, error)
 	if _, ok := option[netlabel.EnableIPv6]; ok {
 		config.EnableIPv6 = option[netlabel.EnableIPv6].(bool)
 	}
+	if s, ok := option[netlabel.AllocationStrategy].(string); ok {
+		config.AllocationStrategy = s
+	}
+	if gw, ok := option[netlabel.Gateway].(net.IP); ok {
+		config.DefaultGatewayIPv4 = gw
+	}
 
 	// Finally validate the configuration
 	if err = config.Validate(); err != nil {
@@ -593,6 +914,13 @@ func (d *driver) CreateNetwork(id string, option map[string]interface{}) error {
 		}
 	}
 
+	// The egress interface must be there to take the network traffic address
+	if config.EgressInterface != "" {
+		if _, err := egressInterfaceAddr(config.EgressInterface); err != nil {
+			return err
+		}
+	}
+
 	// Create and set network handler in driver
 	network := &bridgeNetwork{
 		id:         id,
@@ -601,6 +929,7 @@ func (d *driver) CreateNetwork(id string, option map[string]interface{}) error {
 		portMapper: portmapper.New(),
 		driver:     d,
 	}
+	network.portMapper.SetSocketOptions(config.proxySocketOptions())
 
 	d.Lock()
 	d.networks[id] = network
@@ -619,6 +948,13 @@ func (d *driver) CreateNetwork(id string, option map[string]interface{}) error {
 	bridgeIface := newInterface(config)
 	network.bridge = bridgeIface
 
+	// On failure release the gateway addresses reserved so far
+	defer func() {
+		if err != nil {
+			bridgeIface.releaseReservedIPs()
+		}
+	}()
+
 	// Verify the network configuration does not conflict with previously installed
 	// networks. This step is needed now because driver might have now set the bridge
 	// name on this config struct. And because we need to check for possible address
@@ -674,6 +1010,9 @@ func (d *driver) CreateNetwork(id string, option map[string]interface{}) error {
 		// specified subnet.
 		{config.FixedCIDR != nil, setupFixedCIDRv4},
 
+		// Or in the specified address range.
+		{config.FixedRangeStart != nil, setupFixedRangeV4},
+
 		// Setup the bridge to allocate containers global IPv6 addresses in the
 		// specified subnet.
 		{config.FixedCIDRv6 != nil, setupFixedCIDRv6},
@@ -702,6 +1041,21 @@ func (d *driver) CreateNetwork(id string, option map[string]interface{}) error {
 
 		//Configure bridge networking filtering if ICC is off and IP tables are enabled
 		{!config.EnableICC && d.config.EnableIPTables, setupBridgeNetFiltering},
+
+		// Log the packets dropped by the network's rules
+		{config.EnableIPTablesLogging && d.config.EnableIPTables, setupIPTablesLogging},
+
+		// Keep the network traffic on the host even if IP forwarding is enabled
+		{config.DisableForwarding && d.config.EnableIPTables, setupNoForwarding},
+
+		// Track the network connections in their own conntrack zone
+		{config.ConntrackZone != 0 && d.config.EnableIPTables, setupConntrackZone},
+
+		// Tune the bridge forwarding database ageing and the ports forward delay
+		{config.AgeingTime != 0 || config.ForwardDelay != 0, setupBridgeTimers},
+
+		// Forward the configured link-local frames, like LLDP ones
+		{config.GroupFwdMask != 0, setupGroupFwdMask},
 	} {
 		if step.Condition {
 			bridgeSetup.queueStep(step.Fn)
@@ -710,8 +1064,12 @@ func (d *driver) CreateNetwork(id string, option map[string]interface{}) error {
 
 	// Block bridge IP from being allocated.
 	bridgeSetup.queueStep(allocateBridgeIP)
-	// Apply the prepared list of steps, and abort at the first error.
 	bridgeSetup.queueStep(setupDeviceUp)
+	// Advertise the IPv6 prefix once the bridge is up
+	if config.EnableIPv6RA {
+		bridgeSetup.queueStep(network.setupIPv6RA)
+	}
+	// Apply the prepared list of steps, and abort at the first error.
 	if err = bridgeSetup.apply(); err != nil {
 		return err
 	}
@@ -783,10 +1141,46 @@ func (d *driver) DeleteNetwork(nid string) error {
 		return err
 	}
 
-	// Programming
+	// Remove the ICC logging rule, if any. Do not fail the delete on this.
+	if err := setICCLogging(config, false); err != nil {
+		logrus.Warnf("Failed on removing the iptables logging rule for network %s: %v", nid, err)
+	}
+
+	// Likewise for the rules disabling forwarding
+	if err := setNoForwarding(config, false); err != nil {
+		logrus.Warnf("Failed on removing the iptables forwarding rules for network %s: %v", nid, err)
+	}
+
+	// And for the conntrack zone rules
+	if err := setConntrackZone(config, false); err != nil {
+		logrus.Warnf("Failed on removing the iptables conntrack zone rules for network %s: %v", nid, err)
+	}
+
+	// And for the egress interface address translation rule
+	if err := n.removeEgressNAT(); err != nil {
+		logrus.Warnf("Failed on removing the iptables egress rule for network %s: %v", nid, err)
+	}
+
+	// Stop advertising the IPv6 prefix, if we were
+	n.stopIPv6RA()
+
+	// Programming. The bridge device may already be gone if a previous
+	// delete attempt failed past this point.
 	err = netlink.LinkDel(n.bridge.Link)
+	if err != nil {
+		if _, e := netlink.LinkByName(config.BridgeName); e != nil {
+			logrus.Debugf("Bridge %s of network %s is already gone", config.BridgeName, nid)
+			err = nil
+		}
+	}
+	if err != nil {
+		return err
+	}
 
-	return err
+	// The gateway addresses are free again
+	n.bridge.releaseReservedIPs()
+
+	return nil
 }
 
 func addToBridge(ifaceName, bridgeName string) error {
@@ -878,6 +1272,11 @@ func (d *driver) CreateEndpoint(nid, eid string, epInfo driverapi.EndpointInfo,
 	}
 	n.Unlock()
 
+	// The bridge may have been deleted behind our back
+	if err = n.restoreBridge(); err != nil {
+		return err
+	}
+
 	// Check if endpoint id is good and retrieve correspondent endpoint
 	ep, err := n.getEndpoint(eid)
 	if err != nil {
@@ -895,8 +1294,20 @@ func (d *driver) CreateEndpoint(nid, eid string, epInfo driverapi.EndpointInfo,
 		return err
 	}
 
+	if err := dconfig.checkPortBindings(epConfig); err != nil {
+		return err
+	}
+
 	// Create and add the endpoint
 	n.Lock()
+	if epConfig != nil && epConfig.VIP != nil {
+		for _, other := range n.endpoints {
+			if other.config != nil && other.config.VIP.Equal(epConfig.VIP) {
+				n.Unlock()
+				return ErrVIPInUse(epConfig.VIP.String())
+			}
+		}
+	}
 	endpoint := &bridgeEndpoint{id: eid, config: epConfig}
 	n.endpoints[eid] = endpoint
 	n.Unlock()
@@ -910,21 +1321,177 @@ func (d *driver) CreateEndpoint(nid, eid string, epInfo driverapi.EndpointInfo,
 		}
 	}()
 
-	// Generate a name for what will be the host side pipe interface
-	hostIfName, err := netutils.GenerateIfaceName(vethPrefix, vethLen)
+	// Reserve a name for what will be the host side pipe interface
+	hostIfName, err := d.veths.reserve(eid, "host")
+	if err != nil {
+		return err
+	}
+
+	// Reserve a name for what will be the sandbox side pipe interface
+	containerIfName, err := d.veths.reserve(eid, "sandbox")
 	if err != nil {
+		d.veths.release(hostIfName)
 		return err
 	}
 
-	// Generate a name for what will be the sandbox side pipe interface
-	containerIfName, err := netutils.GenerateIfaceName(vethPrefix, vethLen)
+	// The names are free again once the pipe is gone, the defer which
+	// deletes it on failure runs first
+	defer func() {
+		if err != nil {
+			d.veths.release(hostIfName, containerIfName)
+		}
+	}()
+
+	endpoint.hostIfName = hostIfName
+	endpoint.srcName = containerIfName
+
+	n.Lock()
+	config := n.config
+	n.Unlock()
+
+	// Endpoint settings take precedence over the network ones
+	endpoint.txQueueLen = config.TxQueueLen
+	if epConfig != nil && epConfig.TxQueueLen != 0 {
+		endpoint.txQueueLen = epConfig.TxQueueLen
+	}
+	endpoint.offloads = make(map[string]bool)
+	for f, on := range config.Offloads {
+		endpoint.offloads[f] = on
+	}
+	if epConfig != nil {
+		for f, on := range epConfig.Offloads {
+			endpoint.offloads[f] = on
+		}
+	}
+
+	// v4 address for the sandbox side pipe interface, the requested one if any
+	var reqIP net.IP
+	if epConfig != nil {
+		reqIP = epConfig.IPAddress
+	}
+	var ip4 net.IP
+	if reqIP == nil && epConfig != nil && epConfig.AddressGroup != "" {
+		ip4 = n.requestGroupAddress(eid, epConfig.AddressGroup)
+	}
+	switch {
+	case n.bridge.bridgeIPv4 == nil:
+		// IPv6 only network
+		if reqIP != nil {
+			return types.BadRequestErrorf("network %s has no IPv4 subnet to allocate %s from", nid, reqIP)
+		}
+	case ip4 != nil:
+	case reqIP == nil && config.AllocationStrategy == netlabel.AllocationRandom:
+		ip4, err = ipAllocator.RequestRandomIP(n.bridge.bridgeIPv4)
+	default:
+		ip4, err = ipAllocator.RequestIP(n.bridge.bridgeIPv4, reqIP)
+	}
 	if err != nil {
+		if err == ipallocator.ErrIPAlreadyAllocated {
+			err = ErrIPAddressInUse(reqIP.String())
+		}
 		return err
 	}
+	ipv4Addr := &net.IPNet{}
+	if ip4 != nil {
+		defer func() {
+			if err != nil {
+				ipAllocator.ReleaseIP(n.bridge.bridgeIPv4, ip4)
+			}
+		}()
+		ipv4Addr = &net.IPNet{IP: ip4, Mask: n.bridge.bridgeIPv4.Mask}
+	}
+
+	// Use the MAC configured by user if any, otherwise generate one based on IP.
+	endpoint.macAddress = electMacAddress(epConfig, ip4)
+
+	// v6 address for the sandbox side pipe interface
+	ipv6Addr = &net.IPNet{}
+	if config.EnableIPv6 {
+		var ip6 net.IP
+
+		network := n.bridge.bridgeIPv6
+		if config.FixedCIDRv6 != nil {
+			network = config.FixedCIDRv6
+		}
+
+		ones, _ := network.Mask.Size()
+		if ones <= 80 {
+			ip6 = make(net.IP, len(network.IP))
+			copy(ip6, network.IP)
+			for i, h := range endpoint.macAddress {
+				ip6[i+10] = h
+			}
+		}
+
+		ip6, err = ipAllocator.RequestIP(network, ip6)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err != nil {
+				ipAllocator.ReleaseIP(network, ip6)
+			}
+		}()
+
+		ipv6Addr = &net.IPNet{IP: ip6, Mask: network.Mask}
+	}
+
+	// The pipe of a lazy endpoint is only created on its activation
+	endpoint.linkDeferred = epConfig != nil && epConfig.LazyLink
+	if !endpoint.linkDeferred {
+		if err = d.createLink(n, endpoint); err != nil {
+			return err
+		}
+		defer func() {
+			if err != nil {
+				if link, e := netlink.LinkByName(hostIfName); e == nil {
+					netlink.LinkDel(link)
+				}
+			}
+		}()
+	}
+
+	if ip4 != nil {
+		endpoint.addr = ipv4Addr
+	}
+
+	if config.EnableIPv6 {
+		endpoint.addrv6 = ipv6Addr
+	}
+
+	err = epInfo.AddInterface(ifaceID, endpoint.macAddress, *ipv4Addr, *ipv6Addr)
+	if err != nil {
+		return err
+	}
+
+	// Program any required port mapping and store them in the endpoint
+	endpoint.portMapping, err = n.allocatePorts(epConfig, endpoint, config.DefaultBindingIP, d.config.EnableUserlandProxy)
+	if err != nil {
+		return err
+	}
+	endpoint.portsClaimed = len(endpoint.portMapping) != 0 && epConfig.PortClaim
+
+	return nil
+}
+
+// createLink creates the pipe of the endpoint with its reserved names, attaches
+// the host end to the bridge and configures both ends. The pipe is deleted on
+// failure.
+func (d *driver) createLink(n *bridgeNetwork, endpoint *bridgeEndpoint) (err error) {
+	d.Lock()
+	dconfig := d.config
+	d.Unlock()
+
+	n.Lock()
+	config := n.config
+	n.Unlock()
+
+	eid := endpoint.id
+	hostIfName, containerIfName := endpoint.hostIfName, endpoint.srcName
 
 	// Generate and add the interface pipe host <-> sandbox
 	veth := &netlink.Veth{
-		LinkAttrs: netlink.LinkAttrs{Name: hostIfName, TxQLen: 0},
+		LinkAttrs: netlink.LinkAttrs{Name: hostIfName, TxQLen: endpoint.txQueueLen},
 		PeerName:  containerIfName}
 	if err = netlink.LinkAdd(veth); err != nil {
 		return types.InternalErrorf("failed to add the host (%s) <=> sandbox (%s) pair interfaces: %v", hostIfName, containerIfName, err)
@@ -952,22 +1519,43 @@ func (d *driver) CreateEndpoint(nid, eid string, epInfo driverapi.EndpointInfo,
 		}
 	}()
 
-	n.Lock()
-	config := n.config
-	n.Unlock()
-
-	// Add bridge inherited attributes to pipe interfaces
-	if config.Mtu != 0 {
-		err = netlink.LinkSetMTU(host, config.Mtu)
+	// Add bridge inherited attributes to pipe interfaces. The MTU cannot
+	// exceed the one of the bridge uplinks, else the traffic fragments.
+	endpoint.mtu = config.Mtu
+	maxMTU, err := n.uplinkMTU()
+	if err != nil {
+		return types.InternalErrorf("failed to discover the uplink MTU of bridge %s: %v", config.BridgeName, err)
+	}
+	if mtu := endpoint.mtu; maxMTU != 0 {
+		if mtu == 0 {
+			mtu = host.Attrs().MTU
+		}
+		if mtu > maxMTU {
+			logrus.Warnf("Clamping the MTU of endpoint %s from %d to %d, the MTU of the bridge %s uplinks", eid, mtu, maxMTU, config.BridgeName)
+			endpoint.mtu = maxMTU
+			endpoint.mtuClamped = true
+		}
+	}
+	if endpoint.mtu != 0 {
+		err = netlink.LinkSetMTU(host, endpoint.mtu)
 		if err != nil {
 			return types.InternalErrorf("failed to set MTU on host interface %s: %v", hostIfName, err)
 		}
-		err = netlink.LinkSetMTU(sbox, config.Mtu)
+		err = netlink.LinkSetMTU(sbox, endpoint.mtu)
 		if err != nil {
 			return types.InternalErrorf("failed to set MTU on sandbox interface %s: %v", containerIfName, err)
 		}
 	}
 
+	// Toggle the requested offload features on both ends of the pipe
+	for f, on := range endpoint.offloads {
+		for _, ifName := range []string{hostIfName, containerIfName} {
+			if err = ioctlSetOffload(ifName, f, on); err != nil {
+				return types.InternalErrorf("failed to set %s offload on interface %s: %v", f, ifName, err)
+			}
+		}
+	}
+
 	// Attach host side pipe interface into the bridge
 	if err = addToBridge(hostIfName, config.BridgeName); err != nil {
 		return fmt.Errorf("adding interface %s to bridge %s failed: %v", hostIfName, config.BridgeName, err)
@@ -980,76 +1568,55 @@ func (d *driver) CreateEndpoint(nid, eid string, epInfo driverapi.EndpointInfo,
 		}
 	}
 
-	// v4 address for the sandbox side pipe interface
-	ip4, err := ipAllocator.RequestIP(n.bridge.bridgeIPv4, nil)
-	if err != nil {
-		return err
-	}
-	ipv4Addr := &net.IPNet{IP: ip4, Mask: n.bridge.bridgeIPv4.Mask}
-
 	// Down the interface before configuring mac address.
 	if err = netlink.LinkSetDown(sbox); err != nil {
 		return fmt.Errorf("could not set link down for container interface %s: %v", containerIfName, err)
 	}
 
-	// Set the sbox's MAC. If specified, use the one configured by user, otherwise generate one based on IP.
-	mac := electMacAddress(epConfig, ip4)
-	err = netlink.LinkSetHardwareAddr(sbox, mac)
+	err = netlink.LinkSetHardwareAddr(sbox, endpoint.macAddress)
 	if err != nil {
 		return fmt.Errorf("could not set mac address for container interface %s: %v", containerIfName, err)
 	}
-	endpoint.macAddress = mac
 
 	// Up the host interface after finishing all netlink configuration
 	if err = netlink.LinkSetUp(host); err != nil {
 		return fmt.Errorf("could not set link up for host interface %s: %v", hostIfName, err)
 	}
 
-	// v6 address for the sandbox side pipe interface
-	ipv6Addr = &net.IPNet{}
-	if config.EnableIPv6 {
-		var ip6 net.IP
-
-		network := n.bridge.bridgeIPv6
-		if config.FixedCIDRv6 != nil {
-			network = config.FixedCIDRv6
-		}
-
-		ones, _ := network.Mask.Size()
-		if ones <= 80 {
-			ip6 = make(net.IP, len(network.IP))
-			copy(ip6, network.IP)
-			for i, h := range mac {
-				ip6[i+10] = h
-			}
-		}
+	return nil
+}
 
-		ip6, err := ipAllocator.RequestIP(network, ip6)
-		if err != nil {
-			return err
-		}
+// CreateEndpointLink creates the pipe of an endpoint created with the
+// netlabel.LazyLink option. It is a no-op once the pipe exists.
+func (d *driver) CreateEndpointLink(nid, eid string) error {
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return err
+	}
 
-		ipv6Addr = &net.IPNet{IP: ip6, Mask: network.Mask}
+	ep, err := n.getEndpoint(eid)
+	if err != nil {
+		return err
+	}
+	if ep == nil {
+		return EndpointNotFoundError(eid)
 	}
 
-	// Create the sandbox side pipe interface
-	endpoint.srcName = containerIfName
-	endpoint.addr = ipv4Addr
+	n.Lock()
+	deferred := ep.linkDeferred
+	n.Unlock()
 
-	if config.EnableIPv6 {
-		endpoint.addrv6 = ipv6Addr
+	if !deferred {
+		return nil
 	}
 
-	err = epInfo.AddInterface(ifaceID, endpoint.macAddress, *ipv4Addr, *ipv6Addr)
-	if err != nil {
+	if err := d.createLink(n, ep); err != nil {
 		return err
 	}
 
-	// Program any required port mapping and store them in the endpoint
-	endpoint.portMapping, err = n.allocatePorts(epConfig, endpoint, config.DefaultBindingIP, d.config.EnableUserlandProxy)
-	if err != nil {
-		return err
-	}
+	n.Lock()
+	ep.linkDeferred = false
+	n.Unlock()
 
 	return nil
 }
@@ -1107,9 +1674,11 @@ func (d *driver) DeleteEndpoint(nid, eid string) error {
 	n.releasePorts(ep)
 
 	// Release the v4 address allocated to this endpoint's sandbox interface
-	err = ipAllocator.ReleaseIP(n.bridge.bridgeIPv4, ep.addr.IP)
-	if err != nil {
-		return err
+	if ep.addr != nil {
+		err = ipAllocator.ReleaseIP(n.bridge.bridgeIPv4, ep.addr.IP)
+		if err != nil {
+			return err
+		}
 	}
 
 	n.Lock()
@@ -1128,6 +1697,24 @@ func (d *driver) DeleteEndpoint(nid, eid string) error {
 		}
 	}
 
+	// Remove the access control and marking rules left behind if the endpoint
+	// was not left. Discard error, like for the port mappings.
+	if e := setEndpointACL(config, ep, false); e != nil {
+		logrus.Warnf("Failed to remove acl rules for endpoint %s: %v", eid, e)
+	}
+	if e := setEndpointDSCP(config, ep, false); e != nil {
+		logrus.Warnf("Failed to remove dscp rule for endpoint %s: %v", eid, e)
+	}
+	if e := setEndpointConnLimit(config, ep, false); e != nil {
+		logrus.Warnf("Failed to remove connlimit rule for endpoint %s: %v", eid, e)
+	}
+	if e := setEndpointVIP(config, ep, false); e != nil {
+		logrus.Warnf("Failed to remove vip rules for endpoint %s: %v", eid, e)
+	}
+	if e := setEndpointDrain(n, ep, false); e != nil {
+		logrus.Warnf("Failed to remove drain rule for endpoint %s: %v", eid, e)
+	}
+
 	// Try removal of link. Discard error: link pair might have
 	// already been deleted by sandbox delete. Make sure defer
 	// does not see this error either.
@@ -1135,6 +1722,14 @@ func (d *driver) DeleteEndpoint(nid, eid string) error {
 		netlink.LinkDel(link)
 	}
 
+	// Deleting either end removes the pipe. Keep the names reserved
+	// if the host end is somehow still around.
+	if _, err := netlink.LinkByName(ep.hostIfName); err != nil {
+		d.veths.release(ep.hostIfName, ep.srcName)
+	} else {
+		logrus.Warnf("Host side interface %s of endpoint %s still exists after delete", ep.hostIfName, eid)
+	}
+
 	return nil
 }
 
@@ -1185,6 +1780,47 @@ func (d *driver) EndpointOperInfo(nid, eid string) (map[string]interface{}, erro
 			pmc = append(pmc, pm.GetCopy())
 		}
 		m[netlabel.PortMap] = pmc
+		if ep.portsClaimed {
+			m[netlabel.PortMapClaimOnly] = true
+		}
+	}
+
+	if ep.config.ACL != nil {
+		// Return a copy of the config data
+		acl := make([]types.ACLRule, 0, len(ep.config.ACL))
+		for _, r := range ep.config.ACL {
+			acl = append(acl, r.GetCopy())
+		}
+		m[netlabel.ACL] = acl
+	}
+
+	if ep.config.DSCP != nil {
+		m[netlabel.DSCP] = *ep.config.DSCP
+	}
+
+	if ep.config.ConnLimit != 0 {
+		m[netlabel.ConnLimit] = ep.config.ConnLimit
+	}
+
+	if ep.config.VIP != nil {
+		m[netlabel.VIP] = types.GetIPCopy(ep.config.VIP)
+	}
+
+	if ep.txQueueLen != 0 {
+		m[netlabel.TxQueueLen] = ep.txQueueLen
+	}
+
+	if ep.mtu != 0 {
+		m[netlabel.MTU] = ep.mtu
+		m[netlabel.MTUClamped] = ep.mtuClamped
+	}
+
+	if len(ep.offloads) != 0 {
+		offloads := make(map[string]bool, len(ep.offloads))
+		for f, on := range ep.offloads {
+			offloads[f] = on
+		}
+		m[netlabel.Offloads] = offloads
 	}
 
 	if len(ep.macAddress) != 0 {
@@ -1210,6 +1846,11 @@ func (d *driver) Join(nid, eid string, sboxKey string, jinfo driverapi.JoinInfo,
 		return EndpointNotFoundError(eid)
 	}
 
+	// The bridge may have been deleted behind our back
+	if err = network.restoreBridge(); err != nil {
+		return err
+	}
+
 	for _, iNames := range jinfo.InterfaceNames() {
 		// Make sure to set names on the correct interface ID.
 		if iNames.ID() == ifaceID {
@@ -1230,13 +1871,51 @@ func (d *driver) Join(nid, eid string, sboxKey string, jinfo driverapi.JoinInfo,
 		return err
 	}
 
+	if endpoint.config != nil && endpoint.config.VIP != nil {
+		if err = checkBridgeVIP(network.bridge, endpoint.config.VIP); err != nil {
+			return err
+		}
+	}
+
+	// On failure remove the rules installed so far, including the ones of
+	// the setter which failed part way
+	installed := 0
+	defer func() {
+		if err == nil {
+			return
+		}
+		for i := installed - 1; i >= 0; i-- {
+			if e := endpointRuleSetters[i](network.config, endpoint, false); e != nil {
+				logrus.Warnf("Failed to remove the endpoint %s rules on join failure: %v", eid, e)
+			}
+		}
+	}()
+
+	for _, set := range endpointRuleSetters {
+		installed++
+		if err = set(network.config, endpoint, true); err != nil {
+			return err
+		}
+	}
+
 	if !network.config.EnableICC {
-		return d.link(network, endpoint, options, true)
+		if err = d.link(network, endpoint, options, true); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
+// endpointRuleSetters install/remove the rules of a joined endpoint, in the
+// order they are installed on join. They are removed in the reverse order.
+var endpointRuleSetters = []func(*networkConfiguration, *bridgeEndpoint, bool) error{
+	setEndpointACL,
+	setEndpointDSCP,
+	setEndpointConnLimit,
+	setEndpointVIP,
+}
+
 // Leave method is invoked when a Sandbox detaches from an endpoint.
 func (d *driver) Leave(nid, eid string) error {
 	network, err := d.getNetwork(nid)
@@ -1253,11 +1932,35 @@ func (d *driver) Leave(nid, eid string) error {
 		return EndpointNotFoundError(eid)
 	}
 
+	// Remove whatever can be, a rule failing to go does not keep the others
+	var errs []error
+	for i := len(endpointRuleSetters) - 1; i >= 0; i-- {
+		if err := endpointRuleSetters[i](network.config, endpoint, false); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := setEndpointDrain(network, endpoint, false); err != nil {
+		errs = append(errs, err)
+	}
+
 	if !network.config.EnableICC {
-		return d.link(network, endpoint, nil, false)
+		if err := d.link(network, endpoint, nil, false); err != nil {
+			errs = append(errs, err)
+		}
 	}
 
-	return nil
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return fmt.Errorf("failed to remove the endpoint %s rules: %s", eid, strings.Join(msgs, "; "))
 }
 
 func (d *driver) link(network *bridgeNetwork, endpoint *bridgeEndpoint, options map[string]interface{}, enable bool) error {
@@ -1279,6 +1982,14 @@ func (d *driver) link(network *bridgeNetwork, endpoint *bridgeEndpoint, options
 		return nil
 	}
 
+	// Links are IPv4 iptables rules
+	if endpoint.addr == nil {
+		if enable && (len(cc.ParentEndpoints) != 0 || len(cc.ChildEndpoints) != 0) {
+			return types.ForbiddenErrorf("endpoint %s has no IPv4 address to link", endpoint.id)
+		}
+		return nil
+	}
+
 	if endpoint.config != nil && endpoint.config.ExposedPorts != nil {
 		for _, p := range cc.ParentEndpoints {
 			var parentEndpoint *bridgeEndpoint
@@ -1368,6 +2079,14 @@ func parseEndpointOptions(epOptions map[string]interface{}) (*endpointConfigurat
 		}
 	}
 
+	if opt, ok := epOptions[netlabel.IPAddress]; ok {
+		if ip, ok := opt.(net.IP); ok && ip.To4() != nil {
+			ec.IPAddress = ip.To4()
+		} else {
+			return nil, &ErrInvalidEndpointConfig{}
+		}
+	}
+
 	if opt, ok := epOptions[netlabel.PortMap]; ok {
 		if bs, ok := opt.([]types.PortBinding); ok {
 			ec.PortBindings = bs
@@ -1376,6 +2095,30 @@ func parseEndpointOptions(epOptions map[string]interface{}) (*endpointConfigurat
 		}
 	}
 
+	if opt, ok := epOptions[netlabel.PortMapTarget]; ok {
+		if ip, ok := opt.(net.IP); ok && ip.To4() != nil {
+			ec.PortTarget = ip.To4()
+		} else {
+			return nil, &ErrInvalidEndpointConfig{}
+		}
+	}
+
+	if opt, ok := epOptions[netlabel.PortMapClaimOnly]; ok {
+		if claim, ok := opt.(bool); ok {
+			ec.PortClaim = claim
+		} else {
+			return nil, &ErrInvalidEndpointConfig{}
+		}
+	}
+
+	if opt, ok := epOptions[netlabel.LazyLink]; ok {
+		if lazy, ok := opt.(bool); ok {
+			ec.LazyLink = lazy
+		} else {
+			return nil, &ErrInvalidEndpointConfig{}
+		}
+	}
+
 	if opt, ok := epOptions[netlabel.ExposedPorts]; ok {
 		if ports, ok := opt.([]types.TransportPort); ok {
 			ec.ExposedPorts = ports
@@ -1384,6 +2127,78 @@ func parseEndpointOptions(epOptions map[string]interface{}) (*endpointConfigurat
 		}
 	}
 
+	if opt, ok := epOptions[netlabel.ACL]; ok {
+		acl, ok := opt.([]types.ACLRule)
+		if !ok {
+			return nil, &ErrInvalidEndpointConfig{}
+		}
+		for _, r := range acl {
+			if err := r.Validate(); err != nil {
+				return nil, err
+			}
+		}
+		ec.ACL = acl
+	}
+
+	if opt, ok := epOptions[netlabel.DSCP]; ok {
+		dscp, ok := opt.(int)
+		if !ok {
+			return nil, &ErrInvalidEndpointConfig{}
+		}
+		if dscp < 0 || dscp > maxDSCP {
+			return nil, ErrInvalidDSCP(dscp)
+		}
+		ec.DSCP = &dscp
+	}
+
+	if opt, ok := epOptions[netlabel.ConnLimit]; ok {
+		limit, ok := opt.(int)
+		if !ok {
+			return nil, &ErrInvalidEndpointConfig{}
+		}
+		if limit <= 0 {
+			return nil, ErrInvalidConnLimit(limit)
+		}
+		ec.ConnLimit = limit
+	}
+
+	if opt, ok := epOptions[netlabel.AddressGroup]; ok {
+		group, ok := opt.(string)
+		if !ok {
+			return nil, &ErrInvalidEndpointConfig{}
+		}
+		ec.AddressGroup = group
+	}
+
+	if opt, ok := epOptions[netlabel.VIP]; ok {
+		vip, ok := opt.(net.IP)
+		if !ok || vip.To4() == nil {
+			return nil, &ErrInvalidEndpointConfig{}
+		}
+		ec.VIP = vip.To4()
+	}
+
+	if opt, ok := epOptions[netlabel.TxQueueLen]; ok {
+		if qlen, ok := opt.(int); ok && qlen >= 0 {
+			ec.TxQueueLen = qlen
+		} else {
+			return nil, &ErrInvalidEndpointConfig{}
+		}
+	}
+
+	if opt, ok := epOptions[netlabel.Offloads]; ok {
+		offloads, ok := opt.(map[string]bool)
+		if !ok {
+			return nil, &ErrInvalidEndpointConfig{}
+		}
+		for f := range offloads {
+			if _, ok := offloadCommands[f]; !ok {
+				return nil, &ErrInvalidEndpointConfig{}
+			}
+		}
+		ec.Offloads = offloads
+	}
+
 	return ec, nil
 }
 
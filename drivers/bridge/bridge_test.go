@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/ipallocator"
 	"github.com/docker/libnetwork/iptables"
 	"github.com/docker/libnetwork/netlabel"
 	"github.com/docker/libnetwork/netutils"
@@ -100,6 +101,115 @@ func TestCreate(t *testing.T) {
 	}
 }
 
+func TestDeleteNetworkBridgeGone(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	d := newDriver()
+
+	if err := d.Config(nil); err != nil {
+		t.Fatalf("Failed to setup driver config: %v", err)
+	}
+
+	netconfig := &networkConfiguration{BridgeName: "gone_br", AllowNonDefaultBridge: true}
+	genericOption := make(map[string]interface{})
+	genericOption[netlabel.GenericData] = netconfig
+
+	if err := d.CreateNetwork("dummy", genericOption); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	// Remove the bridge behind the driver's back, as an interrupted delete would
+	link, err := netlink.LinkByName("gone_br")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := netlink.LinkDel(link); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.DeleteNetwork("dummy"); err != nil {
+		t.Fatalf("Expected the delete to complete without the bridge device: %v", err)
+	}
+	if _, ok := d.(*driver).networks["dummy"]; ok {
+		t.Fatal("Expected the network to be removed from the driver")
+	}
+}
+
+func TestGatewayReserved(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	d := newDriver()
+
+	if err := d.Config(nil); err != nil {
+		t.Fatalf("Failed to setup driver config: %v", err)
+	}
+
+	ip, nw, _ := net.ParseCIDR("192.168.130.1/24")
+	nw.IP = ip
+	gw := net.ParseIP("192.168.130.254").To4()
+
+	netconfig := &networkConfiguration{
+		BridgeName:            "gw_br",
+		AllowNonDefaultBridge: true,
+		AddressIPv4:           nw,
+		DefaultGatewayIPv4:    gw,
+	}
+	genericOption := make(map[string]interface{})
+	genericOption[netlabel.GenericData] = netconfig
+
+	if err := d.CreateNetwork("dummy", genericOption); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	bridgeIPv4 := d.(*driver).networks["dummy"].bridge.bridgeIPv4
+	for _, addr := range []net.IP{ip, gw} {
+		if _, err := ipAllocator.RequestIP(bridgeIPv4, addr); err != ipallocator.ErrIPAlreadyAllocated {
+			t.Fatalf("Expected %s to be reported allocated, got: %v", addr, err)
+		}
+	}
+
+	// Reported allocated to the network users
+	info, err := d.(*driver).NetworkOperInfo("dummy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reserved := info[netlabel.ReservedAddresses].(map[string]string)
+	if reserved[gw.String()] != "gateway" || reserved[ip.String()] != "reserved" {
+		t.Fatalf("Expected the gateway and the bridge addresses to be reported reserved, got: %v", reserved)
+	}
+
+	te := &testEndpoint{ifaces: []*testInterface{}}
+	err = d.CreateEndpoint("dummy", "ep1", te, map[string]interface{}{netlabel.IPAddress: gw})
+	if err == nil {
+		t.Fatal("Expected to fail requesting the gateway address for an endpoint")
+	}
+	if _, ok := err.(ErrIPAddressInUse); !ok {
+		t.Fatalf("Unexpected error type returned: %T (%v)", err, err)
+	}
+
+	reqIP := net.ParseIP("192.168.130.100").To4()
+	te = &testEndpoint{ifaces: []*testInterface{}}
+	if err := d.CreateEndpoint("dummy", "ep1", te, map[string]interface{}{netlabel.IPAddress: reqIP}); err != nil {
+		t.Fatalf("Failed to create an endpoint with a requested address: %v", err)
+	}
+	if !te.ifaces[0].addr.IP.Equal(reqIP) {
+		t.Fatalf("Expected endpoint address %s, got %s", reqIP, te.ifaces[0].addr.IP)
+	}
+	if err := d.DeleteEndpoint("dummy", "ep1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.DeleteNetwork("dummy"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The reservations go away with the network
+	for _, addr := range []net.IP{ip, gw} {
+		if _, err := ipAllocator.RequestIP(bridgeIPv4, addr); err != nil {
+			t.Fatalf("Expected %s to be released on network delete, got: %v", addr, err)
+		}
+		ipAllocator.ReleaseIP(bridgeIPv4, addr)
+	}
+}
+
 func TestCreateFail(t *testing.T) {
 	defer netutils.SetupTestNetNS(t)()
 	d := newDriver()
@@ -177,7 +287,7 @@ func TestCreateMultipleNetworks(t *testing.T) {
 }
 
 func verifyV4INCEntries(networks map[string]*bridgeNetwork, numEntries int, t *testing.T) {
-	out, err := iptables.Raw("-L", "FORWARD")
+	out, err := iptables.Exec("-L", "FORWARD")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -474,7 +584,7 @@ func TestLinkContainers(t *testing.T) {
 		t.Fatalf("Failed to link ep1 and ep2")
 	}
 
-	out, err := iptables.Raw("-L", DockerChain)
+	out, err := iptables.Exec("-L", DockerChain)
 	for _, pm := range exposedPorts {
 		regex := fmt.Sprintf("%s dpt:%d", pm.Proto.String(), pm.Port)
 		re := regexp.MustCompile(regex)
@@ -495,7 +605,7 @@ func TestLinkContainers(t *testing.T) {
 		t.Fatalf("Failed to unlink ep1 and ep2")
 	}
 
-	out, err = iptables.Raw("-L", DockerChain)
+	out, err = iptables.Exec("-L", DockerChain)
 	for _, pm := range exposedPorts {
 		regex := fmt.Sprintf("%s dpt:%d", pm.Proto.String(), pm.Port)
 		re := regexp.MustCompile(regex)
@@ -519,7 +629,7 @@ func TestLinkContainers(t *testing.T) {
 
 	err = d.Join("net1", "ep2", "", te2, genericOption)
 	if err != nil {
-		out, err = iptables.Raw("-L", DockerChain)
+		out, err = iptables.Exec("-L", DockerChain)
 		for _, pm := range exposedPorts {
 			regex := fmt.Sprintf("%s dpt:%d", pm.Proto.String(), pm.Port)
 			re := regexp.MustCompile(regex)
@@ -676,3 +786,118 @@ func TestSetDefaultGw(t *testing.T) {
 		t.Fatalf("Failed to configure default gateway. Expected %v. Found %v", gw6, te.gw6)
 	}
 }
+
+func TestCreateIPv6Only(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	d := newDriver()
+
+	if err := d.Config(nil); err != nil {
+		t.Fatalf("Failed to setup driver config: %v", err)
+	}
+
+	// Simulate an IPv6 only host, with no IPv4 range left to elect
+	defer func(nws []*net.IPNet) { bridgeNetworks = nws }(bridgeNetworks)
+	bridgeNetworks = nil
+
+	_, subnetv6, _ := net.ParseCIDR("2001:db8:6::/80")
+	config := &networkConfiguration{
+		BridgeName: DefaultBridgeName,
+	}
+	genericOption := make(map[string]interface{})
+	genericOption[netlabel.GenericData] = config
+
+	if err := d.CreateNetwork("v4", genericOption); err == nil {
+		t.Fatal("Expected the network creation to fail without an IPv4 range nor IPv6")
+	}
+
+	config.EnableIPv6 = true
+	config.FixedCIDRv6 = subnetv6
+	if err := d.CreateNetwork("dummy", genericOption); err != nil {
+		t.Fatalf("Failed to create an IPv6 only bridge: %v", err)
+	}
+
+	te := &testEndpoint{ifaces: []*testInterface{}}
+	if err := d.CreateEndpoint("dummy", "ep", te, nil); err != nil {
+		t.Fatalf("Failed to create endpoint: %v", err)
+	}
+	if te.ifaces[0].addr.IP != nil {
+		t.Fatalf("Unexpected IPv4 address %v on an IPv6 only network", te.ifaces[0].addr)
+	}
+	if !subnetv6.Contains(te.ifaces[0].addrv6.IP) {
+		t.Fatalf("Endpoint IPv6 address %v is not in %v", te.ifaces[0].addrv6, subnetv6)
+	}
+
+	if err := d.Join("dummy", "ep", "sbox", te, nil); err != nil {
+		t.Fatalf("Failed to join endpoint: %v", err)
+	}
+	if te.gw != nil {
+		t.Fatalf("Unexpected IPv4 gateway %v", te.gw)
+	}
+	if te.gw6 == nil {
+		t.Fatal("Expected an IPv6 default gateway")
+	}
+
+	if err := d.DeleteEndpoint("dummy", "ep"); err != nil {
+		t.Fatalf("Failed to delete endpoint: %v", err)
+	}
+}
+
+func TestJoinLeaveEndpointRules(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	if err := netlink.LinkAdd(&netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "br-rules"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The rules carrying the failing argument cannot be programmed
+	var failing string
+	fw := newFirewallShim()
+	defer func(f func(iptRule, string, bool) error) { programEndpointRule = f }(programEndpointRule)
+	programEndpointRule = func(rule iptRule, descr string, insert bool) error {
+		if failing != "" && hasArgs(rule, failing) {
+			return fmt.Errorf("failed to program the %s rule", descr)
+		}
+		return fw.program(rule, descr, insert)
+	}
+
+	epConfig, err := parseEndpointOptions(map[string]interface{}{netlabel.DSCP: 46, netlabel.ConnLimit: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep := &bridgeEndpoint{
+		id:     "ep1",
+		config: epConfig,
+		addr:   &net.IPNet{IP: net.ParseIP("172.18.0.2"), Mask: net.CIDRMask(16, 32)},
+	}
+	d := newDriver().(*driver)
+	d.networks["net1"] = &bridgeNetwork{
+		id:        "net1",
+		config:    &networkConfiguration{BridgeName: "br-rules", EnableICC: true},
+		bridge:    &bridgeInterface{},
+		endpoints: map[string]*bridgeEndpoint{"ep1": ep},
+		driver:    d,
+	}
+
+	// The rules installed before the failing one are removed
+	failing = "--connlimit-above"
+	if err := d.Join("net1", "ep1", "sbox", &testEndpoint{}, nil); err == nil {
+		t.Fatal("Expected the join to fail")
+	}
+	fw.check(t, nil)
+
+	failing = ""
+	if err := d.Join("net1", "ep1", "sbox", &testEndpoint{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	dscp := []string{"-i", "br-rules", "-s", "172.18.0.2", "-j", "DSCP", "--set-dscp", "46"}
+	if len(fw.chains) != 2 {
+		t.Fatalf("Expected the DSCP and connection limit rules to be installed. Got %v", fw.chains)
+	}
+
+	// A rule failing to go does not keep the others
+	failing = "--set-dscp"
+	if err := d.Leave("net1", "ep1"); err == nil {
+		t.Fatal("Expected the leave to report the rule which could not be removed")
+	}
+	fw.check(t, map[string][][]string{chainKey(iptables.Mangle, "PREROUTING"): {dscp}})
+}
@@ -0,0 +1,26 @@
+package bridge
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/docker/libnetwork/iptables"
+)
+
+// getConnLimitRule returns the filter rule rejecting the new TCP connections
+// forwarded through the bridge to the endpoint address once the endpoint has
+// the limit of concurrent connections.
+func getConnLimitRule(bridgeName string, ip net.IP, limit int) iptRule {
+	return iptRule{table: iptables.Filter, chain: "FORWARD",
+		args: []string{"-o", bridgeName, "-d", ip.String(), "-p", "tcp", "--syn",
+			"-m", "connlimit", "--connlimit-above", strconv.Itoa(limit), "--connlimit-mask", "32", "--connlimit-daddr",
+			"-j", "REJECT", "--reject-with", "tcp-reset"}}
+}
+
+// Install/Removes the endpoint connection limit rule
+func setEndpointConnLimit(config *networkConfiguration, ep *bridgeEndpoint, enable bool) error {
+	if ep.config == nil || ep.config.ConnLimit == 0 || ep.addr == nil {
+		return nil
+	}
+	return programEndpointRule(getConnLimitRule(config.BridgeName, ep.addr.IP, ep.config.ConnLimit), "connlimit", enable)
+}
@@ -0,0 +1,50 @@
+package bridge
+
+import (
+	"net"
+	"testing"
+
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/types"
+)
+
+func TestEndpointConnLimit(t *testing.T) {
+	fw := newFirewallShim()
+	defer func(f func(iptRule, string, bool) error) { programEndpointRule = f }(programEndpointRule)
+	programEndpointRule = fw.program
+
+	epConfig, err := parseEndpointOptions(map[string]interface{}{netlabel.ConnLimit: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &networkConfiguration{BridgeName: "br-connlimit"}
+	ep := &bridgeEndpoint{
+		config: epConfig,
+		addr:   &net.IPNet{IP: net.ParseIP("172.18.0.2"), Mask: net.CIDRMask(16, 32)},
+	}
+
+	if err := setEndpointConnLimit(config, ep, true); err != nil {
+		t.Fatal(err)
+	}
+	fw.check(t, map[string][][]string{
+		chainKey(iptables.Filter, "FORWARD"): {{"-o", "br-connlimit", "-d", "172.18.0.2", "-p", "tcp", "--syn",
+			"-m", "connlimit", "--connlimit-above", "100", "--connlimit-mask", "32", "--connlimit-daddr",
+			"-j", "REJECT", "--reject-with", "tcp-reset"}},
+	})
+
+	if err := setEndpointConnLimit(config, ep, false); err != nil {
+		t.Fatal(err)
+	}
+	fw.check(t, nil)
+}
+
+func TestEndpointConnLimitValidation(t *testing.T) {
+	for _, v := range []int{-1, 0} {
+		_, err := parseEndpointOptions(map[string]interface{}{netlabel.ConnLimit: v})
+		if _, ok := err.(types.BadRequestError); !ok {
+			t.Fatalf("Expected a bad request error for connection limit %d. Got %v", v, err)
+		}
+	}
+}
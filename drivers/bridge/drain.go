@@ -0,0 +1,122 @@
+package bridge
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"os/exec"
+	"strings"
+
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/types"
+)
+
+// getDrainRule returns the filter rule rejecting the new connections forwarded
+// through the bridge to the endpoint address. The packets of the established
+// connections are not in the NEW state and keep flowing.
+func getDrainRule(bridgeName string, ip net.IP) iptRule {
+	return iptRule{table: iptables.Filter, chain: "FORWARD",
+		args: []string{"-o", bridgeName, "-d", ip.String(), "-m", "conntrack", "--ctstate", "NEW", "-j", "REJECT"}}
+}
+
+// Install/Removes the endpoint drain rule
+func setEndpointDrain(n *bridgeNetwork, ep *bridgeEndpoint, enable bool) error {
+	n.Lock()
+	config := n.config
+	draining := ep.draining
+	n.Unlock()
+
+	if draining == enable || ep.addr == nil {
+		return nil
+	}
+	if err := programEndpointRule(getDrainRule(config.BridgeName, ep.addr.IP), "drain", enable); err != nil {
+		return err
+	}
+
+	n.Lock()
+	ep.draining = enable
+	n.Unlock()
+
+	return nil
+}
+
+// countConntrack returns the number of established TCP connections the
+// address serves. Tests replace it.
+var countConntrack = countConntrackEntries
+
+// getConntrackCountArgs returns the conntrack arguments listing the established
+// TCP connections answered by the address. Matching on the reply source covers
+// the connections to the published ports, whose original destination is the
+// host address. The closing connections and the UDP flows, which only expire,
+// are left out.
+func getConntrackCountArgs(ip net.IP) []string {
+	return []string{"-L", "-p", "tcp", "--state", "ESTABLISHED", "--reply-src", ip.String()}
+}
+
+func countConntrackEntries(ip net.IP) (int, error) {
+	// Without the tool the connections cannot be counted, reporting none would
+	// end the drain before they closed
+	path, err := exec.LookPath("conntrack")
+	if err != nil {
+		return 0, types.NotImplementedErrorf("cannot count the connections of %s: %v", ip, err)
+	}
+
+	args := getConntrackCountArgs(ip)
+	out, err := exec.Command(path, args...).Output()
+	if err != nil {
+		// conntrack may fail when no entry matched, there is nothing to count then
+		if ee, ok := err.(*exec.ExitError); ok && strings.Contains(string(ee.Stderr), "0 flow entries") {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("conntrack %s failed: %v", strings.Join(args, " "), err)
+	}
+
+	// The entries are listed one per line, the summary goes to stderr
+	count := 0
+	for s := bufio.NewScanner(bytes.NewReader(out)); s.Scan(); {
+		if strings.TrimSpace(s.Text()) != "" {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// DrainEndpoint blocks the new connections to the endpoint
+func (d *driver) DrainEndpoint(nid, eid string, drain bool) error {
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return err
+	}
+
+	ep, err := n.getEndpoint(eid)
+	if err != nil {
+		return err
+	}
+	if ep == nil {
+		return EndpointNotFoundError(eid)
+	}
+
+	return setEndpointDrain(n, ep, drain)
+}
+
+// EndpointConnections returns the number of connections tracked to the endpoint
+func (d *driver) EndpointConnections(nid, eid string) (int, error) {
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return 0, err
+	}
+
+	ep, err := n.getEndpoint(eid)
+	if err != nil {
+		return 0, err
+	}
+	if ep == nil {
+		return 0, EndpointNotFoundError(eid)
+	}
+	if ep.addr == nil {
+		return 0, nil
+	}
+
+	return countConntrack(ep.addr.IP)
+}
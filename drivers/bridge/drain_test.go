@@ -0,0 +1,127 @@
+package bridge
+
+import (
+	"net"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/types"
+)
+
+// acceptsNew returns whether a new connection to the address gets through
+func (f *firewallShim) acceptsNew(ip net.IP) bool {
+	for _, args := range f.chains[chainKey(iptables.Filter, "FORWARD")] {
+		r := iptRule{args: args}
+		if hasArgs(r, "-d", ip.String()) && hasArgs(r, "--ctstate", "NEW", "-j", "REJECT") {
+			return false
+		}
+	}
+	return true
+}
+
+// conntrackTable mocks the kernel connection tracking table
+type conntrackTable struct {
+	entries []net.IP
+}
+
+func (c *conntrackTable) count(ip net.IP) (int, error) {
+	n := 0
+	for _, e := range c.entries {
+		if e.Equal(ip) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func TestDrainEndpoint(t *testing.T) {
+	fw := newFirewallShim()
+	defer func(f func(iptRule, string, bool) error) { programEndpointRule = f }(programEndpointRule)
+	programEndpointRule = fw.program
+
+	ct := &conntrackTable{}
+	defer func(f func(net.IP) (int, error)) { countConntrack = f }(countConntrack)
+	countConntrack = ct.count
+
+	addr := &net.IPNet{IP: net.ParseIP("172.18.0.2"), Mask: net.CIDRMask(16, 32)}
+	ep := &bridgeEndpoint{id: "ep1", addr: addr}
+	d := newDriver().(*driver)
+	d.networks["net1"] = &bridgeNetwork{
+		id:        "net1",
+		config:    &networkConfiguration{BridgeName: "br-drain", EnableICC: true},
+		endpoints: map[string]*bridgeEndpoint{"ep1": ep},
+		driver:    d,
+	}
+
+	// An established connection to the endpoint
+	ct.entries = append(ct.entries, addr.IP, net.ParseIP("172.18.0.3"))
+
+	if err := d.DrainEndpoint("net1", "ep1", true); err != nil {
+		t.Fatal(err)
+	}
+	if fw.acceptsNew(addr.IP) {
+		t.Fatalf("Expected the new connections to the draining endpoint to be blocked. Got %v", fw.chains)
+	}
+	if !fw.acceptsNew(net.ParseIP("172.18.0.3")) {
+		t.Fatal("Expected the other endpoints to accept new connections")
+	}
+	drained := map[string][][]string{
+		chainKey(iptables.Filter, "FORWARD"): {{"-o", "br-drain", "-d", "172.18.0.2", "-m", "conntrack", "--ctstate", "NEW", "-j", "REJECT"}},
+	}
+	fw.check(t, drained)
+
+	// Draining twice programs the rule once
+	if err := d.DrainEndpoint("net1", "ep1", true); err != nil {
+		t.Fatal(err)
+	}
+	fw.check(t, drained)
+
+	conns, err := d.EndpointConnections("net1", "ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conns != 1 {
+		t.Fatalf("Expected the established connection to survive the drain. Got %d connection(s)", conns)
+	}
+
+	ct.entries = ct.entries[1:]
+	if conns, err = d.EndpointConnections("net1", "ep1"); err != nil {
+		t.Fatal(err)
+	}
+	if conns != 0 {
+		t.Fatalf("Expected the endpoint to be drained. Got %d connection(s)", conns)
+	}
+
+	// Leave lifts the block
+	if err := d.Leave("net1", "ep1"); err != nil {
+		t.Fatal(err)
+	}
+	if !fw.acceptsNew(addr.IP) {
+		t.Fatalf("Expected the drain rule to be removed on leave. Got %v", fw.chains)
+	}
+	fw.check(t, nil)
+}
+
+func TestConntrackCountArgs(t *testing.T) {
+	args := strings.Join(getConntrackCountArgs(net.ParseIP("172.18.0.2")), " ")
+	for _, want := range []string{"-p tcp", "--state ESTABLISHED", "--reply-src 172.18.0.2"} {
+		if !strings.Contains(args, want) {
+			t.Fatalf("Expected %q in the conntrack count arguments. Got %q", want, args)
+		}
+	}
+	if strings.Contains(args, "--orig-dst") {
+		t.Fatalf("Published port connections are missed matching the original destination: %q", args)
+	}
+}
+
+func TestConntrackCountMissingTool(t *testing.T) {
+	defer func(path string) { os.Setenv("PATH", path) }(os.Getenv("PATH"))
+	os.Setenv("PATH", "")
+
+	_, err := countConntrackEntries(net.ParseIP("172.18.0.2"))
+	if _, ok := err.(types.NotImplementedError); !ok {
+		t.Fatalf("Expected the count to be reported as not implemented without conntrack. Got %v", err)
+	}
+}
@@ -0,0 +1,26 @@
+package bridge
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/docker/libnetwork/iptables"
+)
+
+// DSCP is the 6 most significant bits of the TOS/traffic class field
+const maxDSCP = 63
+
+// getDSCPRule returns the mangle rule marking the packets entering the bridge
+// from the endpoint address with the DSCP value.
+func getDSCPRule(bridgeName string, ip net.IP, dscp int) iptRule {
+	return iptRule{table: iptables.Mangle, chain: "PREROUTING", preArgs: []string{"-t", string(iptables.Mangle)},
+		args: []string{"-i", bridgeName, "-s", ip.String(), "-j", "DSCP", "--set-dscp", strconv.Itoa(dscp)}}
+}
+
+// Install/Removes the endpoint DSCP marking rule
+func setEndpointDSCP(config *networkConfiguration, ep *bridgeEndpoint, enable bool) error {
+	if ep.config == nil || ep.config.DSCP == nil || ep.addr == nil {
+		return nil
+	}
+	return programEndpointRule(getDSCPRule(config.BridgeName, ep.addr.IP, *ep.config.DSCP), "DSCP", enable)
+}
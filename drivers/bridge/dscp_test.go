@@ -0,0 +1,51 @@
+package bridge
+
+import (
+	"net"
+	"testing"
+
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/types"
+)
+
+func TestEndpointDSCP(t *testing.T) {
+	fw := newFirewallShim()
+	defer func(f func(iptRule, string, bool) error) { programEndpointRule = f }(programEndpointRule)
+	programEndpointRule = fw.program
+
+	epConfig, err := parseEndpointOptions(map[string]interface{}{netlabel.DSCP: 46})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &networkConfiguration{BridgeName: "br-dscp"}
+	ep := &bridgeEndpoint{
+		config: epConfig,
+		addr:   &net.IPNet{IP: net.ParseIP("172.18.0.2"), Mask: net.CIDRMask(16, 32)},
+	}
+
+	if err := setEndpointDSCP(config, ep, true); err != nil {
+		t.Fatal(err)
+	}
+	fw.check(t, map[string][][]string{
+		chainKey(iptables.Mangle, "PREROUTING"): {{"-i", "br-dscp", "-s", "172.18.0.2", "-j", "DSCP", "--set-dscp", "46"}},
+	})
+
+	if err := setEndpointDSCP(config, ep, false); err != nil {
+		t.Fatal(err)
+	}
+	fw.check(t, nil)
+}
+
+func TestEndpointDSCPValidation(t *testing.T) {
+	for _, v := range []int{-1, 64} {
+		_, err := parseEndpointOptions(map[string]interface{}{netlabel.DSCP: v})
+		if _, ok := err.(types.BadRequestError); !ok {
+			t.Fatalf("Expected a bad request error for DSCP %d. Got %v", v, err)
+		}
+	}
+	if _, err := parseEndpointOptions(map[string]interface{}{netlabel.DSCP: 0}); err != nil {
+		t.Fatalf("Unexpected error for DSCP 0: %v", err)
+	}
+}
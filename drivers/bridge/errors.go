@@ -95,6 +95,16 @@ func (eig *ErrInvalidGateway) Error() string {
 // BadRequest denotes the type of this error
 func (eig *ErrInvalidGateway) BadRequest() {}
 
+// ErrInvalidRAPrefix is returned when router advertisements are enabled without a /64 FixedCIDRv6.
+type ErrInvalidRAPrefix struct{}
+
+func (eirp *ErrInvalidRAPrefix) Error() string {
+	return fmt.Sprintf("ipv6 router advertisements require ipv6 enabled and a /%d FixedCIDRv6", raPrefixLen)
+}
+
+// BadRequest denotes the type of this error
+func (eirp *ErrInvalidRAPrefix) BadRequest() {}
+
 // ErrInvalidContainerSubnet is returned when the container subnet (FixedCIDR) is not valid.
 type ErrInvalidContainerSubnet struct{}
 
@@ -105,6 +115,19 @@ func (eis *ErrInvalidContainerSubnet) Error() string {
 // BadRequest denotes the type of this error
 func (eis *ErrInvalidContainerSubnet) BadRequest() {}
 
+// ErrInvalidFixedRange is returned when the container address range (FixedRangeStart-FixedRangeEnd) is not valid.
+type ErrInvalidFixedRange struct {
+	Start net.IP
+	End   net.IP
+}
+
+func (eifr *ErrInvalidFixedRange) Error() string {
+	return fmt.Sprintf("invalid container address range %v-%v: it must be an ordered IPv4 range of the bridge network, exclusive of FixedCIDR", eifr.Start, eifr.End)
+}
+
+// BadRequest denotes the type of this error
+func (eifr *ErrInvalidFixedRange) BadRequest() {}
+
 // ErrInvalidMtu is returned when the user provided MTU is not valid.
 type ErrInvalidMtu int
 
@@ -115,6 +138,152 @@ func (eim ErrInvalidMtu) Error() string {
 // BadRequest denotes the type of this error
 func (eim ErrInvalidMtu) BadRequest() {}
 
+// ErrInvalidTxQueueLen is returned when the user provided transmit queue length is not valid.
+type ErrInvalidTxQueueLen int
+
+func (eiq ErrInvalidTxQueueLen) Error() string {
+	return fmt.Sprintf("invalid transmit queue length: %d", int(eiq))
+}
+
+// BadRequest denotes the type of this error
+func (eiq ErrInvalidTxQueueLen) BadRequest() {}
+
+// ErrInvalidConntrackZone is returned when the user provided conntrack zone is not valid.
+type ErrInvalidConntrackZone int
+
+func (eiz ErrInvalidConntrackZone) Error() string {
+	return fmt.Sprintf("invalid conntrack zone: %d", int(eiz))
+}
+
+// BadRequest denotes the type of this error
+func (eiz ErrInvalidConntrackZone) BadRequest() {}
+
+// ErrInvalidAgeingTime is returned when the user provided bridge ageing time is not valid.
+type ErrInvalidAgeingTime int
+
+func (eiat ErrInvalidAgeingTime) Error() string {
+	return fmt.Sprintf("invalid ageing time (%d-%d seconds): %d", minAgeingTime, maxAgeingTime, int(eiat))
+}
+
+// BadRequest denotes the type of this error
+func (eiat ErrInvalidAgeingTime) BadRequest() {}
+
+// ErrInvalidForwardDelay is returned when the user provided bridge forward delay is not valid.
+type ErrInvalidForwardDelay int
+
+func (eifd ErrInvalidForwardDelay) Error() string {
+	return fmt.Sprintf("invalid forward delay (%d-%d seconds): %d", minForwardDelay, maxForwardDelay, int(eifd))
+}
+
+// BadRequest denotes the type of this error
+func (eifd ErrInvalidForwardDelay) BadRequest() {}
+
+// ErrInvalidGroupFwdMask is returned when the user provided bridge group forward
+// mask has bits the kernel does not let be forwarded.
+type ErrInvalidGroupFwdMask uint16
+
+func (eigm ErrInvalidGroupFwdMask) Error() string {
+	return fmt.Sprintf("invalid group forward mask %#04x: the STP, MAC pause and LACP frames (mask %#04x) cannot be forwarded", uint16(eigm), restrictedGroupFwdMask)
+}
+
+// BadRequest denotes the type of this error
+func (eigm ErrInvalidGroupFwdMask) BadRequest() {}
+
+// ErrInvalidProxyOption is returned when the user provided userland proxy socket option is not valid.
+type ErrInvalidProxyOption string
+
+func (eipo ErrInvalidProxyOption) Error() string {
+	return fmt.Sprintf("invalid userland proxy socket option: %s", string(eipo))
+}
+
+// BadRequest denotes the type of this error
+func (eipo ErrInvalidProxyOption) BadRequest() {}
+
+// ErrInvalidOffload is returned when the user provided offload feature is not known.
+type ErrInvalidOffload string
+
+func (eio ErrInvalidOffload) Error() string {
+	return fmt.Sprintf("invalid offload feature: %q", string(eio))
+}
+
+// BadRequest denotes the type of this error
+func (eio ErrInvalidOffload) BadRequest() {}
+
+// ErrInvalidDSCP is returned when the user provided DSCP value is not valid.
+type ErrInvalidDSCP int
+
+func (eid ErrInvalidDSCP) Error() string {
+	return fmt.Sprintf("invalid DSCP value (0-%d): %d", maxDSCP, int(eid))
+}
+
+// BadRequest denotes the type of this error
+func (eid ErrInvalidDSCP) BadRequest() {}
+
+// ErrInvalidEgressInterface is returned when the user provided egress interface
+// does not exist or has no IPv4 address to translate the network traffic to.
+type ErrInvalidEgressInterface string
+
+// Error returns the error message naming the invalid interface
+func (eiei ErrInvalidEgressInterface) Error() string {
+	return fmt.Sprintf("invalid egress interface, must exist and have an IPv4 address: %s", string(eiei))
+}
+
+// BadRequest denotes the type of this error
+func (eiei ErrInvalidEgressInterface) BadRequest() {}
+
+// ErrInvalidConnLimit is returned when the user provided connection limit is not valid.
+type ErrInvalidConnLimit int
+
+func (eicl ErrInvalidConnLimit) Error() string {
+	return fmt.Sprintf("invalid connection limit, must be greater than 0: %d", int(eicl))
+}
+
+// BadRequest denotes the type of this error
+func (eicl ErrInvalidConnLimit) BadRequest() {}
+
+// ErrInvalidVIP is returned when the virtual address requested for the endpoint
+// is not an alias configured on the bridge.
+type ErrInvalidVIP string
+
+func (eiv ErrInvalidVIP) Error() string {
+	return fmt.Sprintf("virtual address %s is not an alias of the bridge", string(eiv))
+}
+
+// BadRequest denotes the type of this error
+func (eiv ErrInvalidVIP) BadRequest() {}
+
+// ErrVIPInUse is returned when the virtual address requested for the endpoint
+// is already owned by another endpoint of the network.
+type ErrVIPInUse string
+
+func (evu ErrVIPInUse) Error() string {
+	return fmt.Sprintf("virtual address %s is already owned by another endpoint", string(evu))
+}
+
+// Forbidden denotes the type of this error
+func (evu ErrVIPInUse) Forbidden() {}
+
+// ErrIPAddressInUse is returned when the address requested for the endpoint is
+// already allocated, to another endpoint or to the network gateway.
+type ErrIPAddressInUse string
+
+func (eiu ErrIPAddressInUse) Error() string {
+	return fmt.Sprintf("requested address %s is already in use", string(eiu))
+}
+
+// Forbidden denotes the type of this error
+func (eiu ErrIPAddressInUse) Forbidden() {}
+
+// ErrInvalidLogPrefix is returned when the user provided iptables log prefix is not valid.
+type ErrInvalidLogPrefix string
+
+func (eilp ErrInvalidLogPrefix) Error() string {
+	return fmt.Sprintf("invalid iptables log prefix (max %d characters): %q", maxLogPrefixLen, string(eilp))
+}
+
+// BadRequest denotes the type of this error
+func (eilp ErrInvalidLogPrefix) BadRequest() {}
+
 // ErrInvalidPort is returned when the container or host port specified in the port binding is not valid.
 type ErrInvalidPort string
 
@@ -125,6 +294,17 @@ func (ip ErrInvalidPort) Error() string {
 // BadRequest denotes the type of this error
 func (ip ErrInvalidPort) BadRequest() {}
 
+// ErrPortNotAllowed is returned when the port binding publishes to host ports
+// outside of the range allowed by the driver configuration.
+type ErrPortNotAllowed string
+
+func (pna ErrPortNotAllowed) Error() string {
+	return fmt.Sprintf("host port %s is outside of the allowed range", string(pna))
+}
+
+// Forbidden denotes the type of this error
+func (pna ErrPortNotAllowed) Forbidden() {}
+
 // ErrUnsupportedAddressType is returned when the specified address type is not supported.
 type ErrUnsupportedAddressType string
 
@@ -226,6 +406,20 @@ func (fcv4 *FixedCIDRv4Error) Error() string {
 // InternalError denotes the type of this error
 func (fcv4 *FixedCIDRv4Error) InternalError() {}
 
+// BridgeRestoreError is returned when the bridge device of a network, deleted
+// behind the driver's back, could not be recreated.
+type BridgeRestoreError struct {
+	Name string
+	Err  error
+}
+
+func (bre *BridgeRestoreError) Error() string {
+	return fmt.Sprintf("bridge %s is missing and could not be recreated: %v", bre.Name, bre.Err)
+}
+
+// InternalError denotes the type of this error
+func (bre *BridgeRestoreError) InternalError() {}
+
 // FixedCIDRv6Error is returned when fixed-cidrv6 configuration
 // failed.
 type FixedCIDRv6Error struct {
@@ -0,0 +1,62 @@
+package bridge
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+const ioctlEthtool = 0x8946
+
+// Offload features which can be toggled on the endpoint veth pair, mapped to
+// the corresponding ethtool set command
+var offloadCommands = map[string]uint32{
+	"rx":  0x15, // ETHTOOL_SRXCSUM
+	"tx":  0x17, // ETHTOOL_STXCSUM
+	"sg":  0x19, // ETHTOOL_SSG
+	"tso": 0x1f, // ETHTOOL_STSO
+	"gso": 0x24, // ETHTOOL_SGSO
+	"gro": 0x2c, // ETHTOOL_SGRO
+}
+
+type ethtoolValue struct {
+	Cmd  uint32
+	Data uint32
+}
+
+type ifreqData struct {
+	IfrnName [ifNameSize]byte
+	IfruData uintptr
+}
+
+// ioctlSetOffload turns the named offload feature on or off on the interface
+func ioctlSetOffload(name, feature string, on bool) error {
+	if len(name) >= ifNameSize {
+		return fmt.Errorf("Interface name %s too long", name)
+	}
+
+	cmd, ok := offloadCommands[feature]
+	if !ok {
+		return fmt.Errorf("unknown offload feature %s", feature)
+	}
+
+	s, err := getIfSocket()
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(s)
+
+	ev := ethtoolValue{Cmd: cmd}
+	if on {
+		ev.Data = 1
+	}
+
+	ifr := ifreqData{IfruData: uintptr(unsafe.Pointer(&ev))}
+	copy(ifr.IfrnName[:len(ifr.IfrnName)-1], name)
+
+	if _, _, err := syscall.Syscall(syscall.SYS_IOCTL, uintptr(s), ioctlEthtool, uintptr(unsafe.Pointer(&ifr))); err != 0 {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,11 @@
+// +build !linux
+
+package bridge
+
+import "errors"
+
+var offloadCommands = map[string]uint32{}
+
+func ioctlSetOffload(name, feature string, on bool) error {
+	return errors.New("not implemented")
+}
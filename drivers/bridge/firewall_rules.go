@@ -0,0 +1,104 @@
+package bridge
+
+import (
+	"net"
+	"strings"
+
+	"github.com/docker/libnetwork/iptables"
+)
+
+// firewallTables are the iptables tables the driver installs network rules in
+var firewallTables = []iptables.Table{iptables.Nat, iptables.Filter, iptables.Mangle, iptables.Raw}
+
+// listTableRules returns the rules of an iptables table in the iptables-save
+// format, one per line. Tests replace it.
+var listTableRules = func(table iptables.Table) ([]string, error) {
+	out, err := iptables.Exec("-t", string(table), "-S")
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(strings.TrimSpace(string(out)), "\n"), nil
+}
+
+// firewallKeys returns the rule arguments identifying the network: its bridge
+// name, its subnets and the addresses of its endpoints
+func (n *bridgeNetwork) firewallKeys() (map[string]bool, []net.IP) {
+	n.Lock()
+	defer n.Unlock()
+
+	keys := map[string]bool{n.config.BridgeName: true}
+	var ips []net.IP
+	if n.bridge != nil {
+		for _, a := range []*net.IPNet{n.bridge.bridgeIPv4, n.bridge.bridgeIPv6} {
+			if a != nil {
+				keys[(&net.IPNet{IP: a.IP.Mask(a.Mask), Mask: a.Mask}).String()] = true
+			}
+		}
+	}
+	for _, ep := range n.endpoints {
+		for _, a := range []*net.IPNet{ep.addr, ep.addrv6} {
+			if a != nil {
+				ips = append(ips, a.IP)
+			}
+		}
+	}
+	return keys, ips
+}
+
+// matchesRule tells whether any argument of the rule is one of the keys or an
+// address, a host route or an address:port destination of one of the ips
+func matchesRule(rule string, keys map[string]bool, ips []net.IP) bool {
+	for _, arg := range strings.Fields(rule) {
+		if keys[arg] {
+			return true
+		}
+		host := arg
+		if i := strings.Index(host, "/"); i >= 0 {
+			host = host[:i]
+		} else if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		ip := net.ParseIP(host)
+		if ip == nil {
+			continue
+		}
+		for _, epIP := range ips {
+			if ip.Equal(epIP) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// NetworkFirewallRules returns the iptables rules installed for the network,
+// prefixed with their table
+func (d *driver) NetworkFirewallRules(nid string) ([]string, error) {
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return nil, err
+	}
+
+	d.Lock()
+	config := d.config
+	d.Unlock()
+	if config == nil || !config.EnableIPTables {
+		return nil, nil
+	}
+
+	keys, ips := n.firewallKeys()
+	rules := []string{}
+	for _, table := range firewallTables {
+		lines, err := listTableRules(table)
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range lines {
+			if !strings.HasPrefix(l, "-A ") || !matchesRule(l, keys, ips) {
+				continue
+			}
+			rules = append(rules, "-t "+string(table)+" "+l)
+		}
+	}
+	return rules, nil
+}
@@ -0,0 +1,74 @@
+package bridge
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/types"
+)
+
+func TestNetworkFirewallRules(t *testing.T) {
+	tables := map[iptables.Table][]string{
+		iptables.Nat: {
+			"-P POSTROUTING ACCEPT",
+			"-N DOCKER",
+			"-A POSTROUTING -s 172.18.0.0/16 ! -o br-fw -j MASQUERADE",
+			"-A POSTROUTING -s 172.19.0.0/16 ! -o br-other -j MASQUERADE",
+			"-A DOCKER ! -i br-fw -p tcp -m tcp --dport 8080 -j DNAT --to-destination 172.18.0.2:80",
+			"-A DOCKER ! -i br-other -p tcp -m tcp --dport 9090 -j DNAT --to-destination 172.19.0.2:90",
+		},
+		iptables.Filter: {
+			"-A FORWARD -i br-fw -o br-fw -j ACCEPT",
+			"-A FORWARD -s 172.18.0.0/16 -d 172.19.0.0/16 -j DROP",
+			"-A DOCKER -d 172.18.0.2/32 ! -i br-fw -o br-fw -p tcp -m tcp --dport 80 -j ACCEPT",
+			"-A FORWARD -i eth0 -j ACCEPT",
+		},
+	}
+	defer func(f func(iptables.Table) ([]string, error)) { listTableRules = f }(listTableRules)
+	listTableRules = func(table iptables.Table) ([]string, error) {
+		return tables[table], nil
+	}
+
+	_, subnet, _ := net.ParseCIDR("172.18.0.0/16")
+	d := newDriver().(*driver)
+	d.config = &configuration{EnableIPTables: true}
+	d.networks["fw"] = &bridgeNetwork{
+		id:     "fw",
+		driver: d,
+		config: &networkConfiguration{BridgeName: "br-fw"},
+		bridge: &bridgeInterface{bridgeIPv4: &net.IPNet{IP: net.ParseIP("172.18.0.1"), Mask: subnet.Mask}},
+		endpoints: map[string]*bridgeEndpoint{
+			"ep": {
+				id:          "ep",
+				addr:        &net.IPNet{IP: net.ParseIP("172.18.0.2"), Mask: subnet.Mask},
+				portMapping: []types.PortBinding{{Proto: types.TCP, Port: 80, HostPort: 8080}},
+			},
+		},
+	}
+
+	rules, err := d.NetworkFirewallRules("fw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"-t nat -A POSTROUTING -s 172.18.0.0/16 ! -o br-fw -j MASQUERADE",
+		"-t nat -A DOCKER ! -i br-fw -p tcp -m tcp --dport 8080 -j DNAT --to-destination 172.18.0.2:80",
+		"-t filter -A FORWARD -i br-fw -o br-fw -j ACCEPT",
+		"-t filter -A FORWARD -s 172.18.0.0/16 -d 172.19.0.0/16 -j DROP",
+		"-t filter -A DOCKER -d 172.18.0.2/32 ! -i br-fw -o br-fw -p tcp -m tcp --dport 80 -j ACCEPT",
+	}
+	if !reflect.DeepEqual(rules, expected) {
+		t.Fatalf("Unexpected network rules.\nExpected: %v\nGot: %v", expected, rules)
+	}
+
+	d.config.EnableIPTables = false
+	if rules, err := d.NetworkFirewallRules("fw"); err != nil || rules != nil {
+		t.Fatalf("Expected no rules with iptables disabled. Got %v, %v", rules, err)
+	}
+
+	if _, err := d.NetworkFirewallRules("missing"); err == nil {
+		t.Fatal("Expected an error for an unknown network")
+	}
+}
@@ -0,0 +1,72 @@
+package bridge
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/libnetwork/iptables"
+)
+
+// firewallShim stands in for iptables in the tests replacing programNetworkRule
+// or programEndpointRule. It keeps the rules of each chain in order, the way
+// programChainRule leaves them: a rule is inserted at the top of its chain
+// unless already there, and removed if there.
+type firewallShim struct {
+	chains map[string][][]string
+}
+
+func newFirewallShim() *firewallShim {
+	return &firewallShim{chains: map[string][][]string{}}
+}
+
+// chainKey identifies the chain of the table in the shim
+func chainKey(table iptables.Table, chain string) string {
+	return string(table) + " " + chain
+}
+
+func (f *firewallShim) program(rule iptRule, descr string, insert bool) error {
+	// Only the filter table is the default one
+	if rule.table != iptables.Filter && !reflect.DeepEqual(rule.preArgs, []string{"-t", string(rule.table)}) {
+		return fmt.Errorf("%s rule of the %s table programmed with %v", descr, rule.table, rule.preArgs)
+	}
+
+	key := chainKey(rule.table, rule.chain)
+	rules := f.chains[key]
+	found := -1
+	for i, args := range rules {
+		if reflect.DeepEqual(args, rule.args) {
+			found = i
+			break
+		}
+	}
+
+	switch {
+	case insert && found < 0:
+		f.chains[key] = append([][]string{rule.args}, rules...)
+	case !insert && found >= 0:
+		left := append([][]string{}, rules[:found]...)
+		if left = append(left, rules[found+1:]...); len(left) == 0 {
+			delete(f.chains, key)
+		} else {
+			f.chains[key] = left
+		}
+	}
+	return nil
+}
+
+// hasArgs tells whether the rule arguments carry the passed sequence
+func hasArgs(rule iptRule, args ...string) bool {
+	return strings.Contains(strings.Join(rule.args, " "), strings.Join(args, " "))
+}
+
+// check fails the test unless the chains hold exactly the expected rules
+func (f *firewallShim) check(t *testing.T, expected map[string][][]string) {
+	if len(f.chains) == 0 && len(expected) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(f.chains, expected) {
+		t.Fatalf("Unexpected rules.\nExpected: %v\nGot:      %v", expected, f.chains)
+	}
+}
@@ -3,6 +3,7 @@ package bridge
 import (
 	"net"
 
+	"github.com/Sirupsen/logrus"
 	"github.com/vishvananda/netlink"
 )
 
@@ -19,6 +20,14 @@ type bridgeInterface struct {
 	bridgeIPv6  *net.IPNet
 	gatewayIPv4 net.IP
 	gatewayIPv6 net.IP
+	reserved    []reservedIP
+}
+
+// reservedIP is an address the network holds in the ipallocator, like its
+// gateway, so that it is never handed out to an endpoint
+type reservedIP struct {
+	subnet *net.IPNet
+	ip     net.IP
 }
 
 // newInterface creates a new bridge interface structure. It attempts to find
@@ -38,6 +47,56 @@ func newInterface(config *networkConfiguration) *bridgeInterface {
 	return i
 }
 
+// reserveIP allocates the address in the subnet on behalf of the network,
+// until releaseReservedIPs is called
+func (i *bridgeInterface) reserveIP(subnet *net.IPNet, ip net.IP) error {
+	if _, err := ipAllocator.RequestIP(subnet, ip); err != nil {
+		return err
+	}
+	i.reserved = append(i.reserved, reservedIP{subnet: subnet, ip: ip})
+	return nil
+}
+
+// releaseReservedIPs releases the addresses reserved through reserveIP
+func (i *bridgeInterface) releaseReservedIPs() {
+	for _, r := range i.reserved {
+		if err := ipAllocator.ReleaseIP(r.subnet, r.ip); err != nil {
+			logrus.Warnf("Failed to release reserved address %s: %v", r.ip, err)
+		}
+	}
+	i.reserved = nil
+}
+
+// reservedAddresses returns the addresses taken by the bridge, its gateways and the
+// addresses reserved through reserveIP, mapped to "gateway" or "reserved"
+func (i *bridgeInterface) reservedAddresses() map[string]string {
+	m := make(map[string]string)
+	add := func(ip net.IP) {
+		if ip == nil || ip.IsLinkLocalUnicast() {
+			return
+		}
+		if ip.Equal(i.gatewayIPv4) || ip.Equal(i.gatewayIPv6) {
+			m[ip.String()] = "gateway"
+			return
+		}
+		m[ip.String()] = "reserved"
+	}
+
+	if i.bridgeIPv4 != nil {
+		add(i.bridgeIPv4.IP)
+	}
+	if i.bridgeIPv6 != nil {
+		add(i.bridgeIPv6.IP)
+	}
+	add(i.gatewayIPv4)
+	add(i.gatewayIPv6)
+	for _, r := range i.reserved {
+		add(r.ip)
+	}
+
+	return m
+}
+
 // exists indicates if the existing bridge interface exists on the system.
 func (i *bridgeInterface) exists() bool {
 	return i.Link != nil
@@ -0,0 +1,102 @@
+package bridge
+
+import (
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/vishvananda/netlink"
+)
+
+// uplinkMTU returns the lowest MTU among the links enslaved to the network
+// bridge which are not the host side of an endpoint pipe, 0 if there is none.
+// Endpoints with a larger MTU would have their traffic fragmented, or dropped.
+func (n *bridgeNetwork) uplinkMTU() (int, error) {
+	n.Lock()
+	bridge := n.bridge
+	pipes := make(map[string]struct{}, len(n.endpoints))
+	for _, ep := range n.endpoints {
+		if ep.hostIfName != "" {
+			pipes[ep.hostIfName] = struct{}{}
+		}
+	}
+	n.Unlock()
+
+	if bridge == nil || bridge.Link == nil {
+		return 0, nil
+	}
+
+	links, err := netlink.LinkList()
+	if err != nil {
+		return 0, err
+	}
+
+	mtu := 0
+	for _, l := range links {
+		attrs := l.Attrs()
+		if attrs.MasterIndex != bridge.Link.Attrs().Index {
+			continue
+		}
+		if _, ok := pipes[attrs.Name]; ok {
+			continue
+		}
+		if mtu == 0 || attrs.MTU < mtu {
+			mtu = attrs.MTU
+		}
+	}
+
+	return mtu, nil
+}
+
+// NetworkOperInfo reports the network bridge name and link index, the maximum
+// MTU discovered on the network bridge uplinks, whether the network traffic is
+// kept from being forwarded off-host, the addresses the network reserves and the
+// bridge timers and group forward mask set for the network
+func (d *driver) NetworkOperInfo(nid string) (map[string]interface{}, error) {
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return nil, err
+	}
+
+	mtu, err := n.uplinkMTU()
+	if err != nil {
+		return nil, err
+	}
+
+	d.Lock()
+	iptablesOn := d.config != nil && d.config.EnableIPTables
+	d.Unlock()
+
+	n.Lock()
+	noForwarding := n.config.DisableForwarding && iptablesOn
+	zone := n.config.ConntrackZone
+	ageing := n.config.AgeingTime
+	fwdDelay := n.config.ForwardDelay
+	fwdMask := n.config.GroupFwdMask
+	reserved := n.bridge.reservedAddresses()
+	name := n.config.BridgeName
+	link := n.bridge.Link
+	n.Unlock()
+
+	m := make(map[string]interface{})
+	m[netlabel.BridgeName] = name
+	if link != nil {
+		m[netlabel.BridgeIndex] = link.Attrs().Index
+	}
+	m[netlabel.DisableForwarding] = noForwarding
+	m[netlabel.ReservedAddresses] = reserved
+	if zone != 0 && iptablesOn {
+		m[netlabel.ConntrackZone] = zone
+	}
+	if ageing != 0 {
+		m[netlabel.AgeingTime] = ageing
+	}
+	if fwdDelay != 0 {
+		m[netlabel.ForwardDelay] = fwdDelay
+	}
+	if fwdMask != 0 {
+		m[netlabel.GroupFwdMask] = fwdMask
+	}
+	if mtu != 0 {
+		m[netlabel.MaxMTU] = mtu
+	}
+
+	return m, nil
+}
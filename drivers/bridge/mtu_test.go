@@ -0,0 +1,87 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/netutils"
+	"github.com/vishvananda/netlink"
+)
+
+func TestUplinkMTUClamp(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	d := newDriver()
+
+	if err := d.Config(nil); err != nil {
+		t.Fatalf("Failed to setup driver config: %v", err)
+	}
+
+	netconfig := &networkConfiguration{BridgeName: "mtu_br", AllowNonDefaultBridge: true}
+	genericOption := make(map[string]interface{})
+	genericOption[netlabel.GenericData] = netconfig
+
+	if err := d.CreateNetwork("dummy", genericOption); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	info, err := d.(*driver).NetworkOperInfo("dummy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := info[netlabel.MaxMTU]; ok {
+		t.Fatalf("Unexpected max MTU reported on a bridge without uplinks: %v", info)
+	}
+
+	// A veth stands for the low MTU uplink, dummy links are not available everywhere
+	uplink := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "mtu_uplink", MTU: 1400}, PeerName: "mtu_peer"}
+	if err := netlink.LinkAdd(uplink); err != nil {
+		t.Fatalf("Failed to create the uplink: %v", err)
+	}
+	br, err := netlink.LinkByName("mtu_br")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := netlink.LinkSetMasterByIndex(uplink, br.Attrs().Index); err != nil {
+		t.Fatalf("Failed to enslave the uplink: %v", err)
+	}
+
+	te := &testEndpoint{ifaces: []*testInterface{}}
+	if err := d.CreateEndpoint("dummy", "ep1", te, map[string]interface{}{}); err != nil {
+		t.Fatalf("Failed to create an endpoint: %v", err)
+	}
+
+	ep := d.(*driver).networks["dummy"].endpoints["ep1"]
+	for _, name := range []string{ep.srcName, ep.hostIfName} {
+		l, err := netlink.LinkByName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if l.Attrs().MTU != 1400 {
+			t.Fatalf("Expected MTU 1400 on %s, got %d", name, l.Attrs().MTU)
+		}
+	}
+
+	opInfo, err := d.EndpointOperInfo("dummy", "ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opInfo[netlabel.MTU] != 1400 || opInfo[netlabel.MTUClamped] != true {
+		t.Fatalf("Expected the endpoint MTU to be reported as clamped to 1400: %v", opInfo)
+	}
+
+	// The endpoint pipe is not an uplink
+	info, err = d.(*driver).NetworkOperInfo("dummy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info[netlabel.MaxMTU] != 1400 {
+		t.Fatalf("Expected max MTU 1400, got: %v", info)
+	}
+
+	if err := d.DeleteEndpoint("dummy", "ep1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.DeleteNetwork("dummy"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -24,14 +24,25 @@ func (n *bridgeNetwork) allocatePorts(epConfig *endpointConfiguration, ep *bridg
 		defHostIP = reqDefBindIP
 	}
 
-	return n.allocatePortsInternal(epConfig.PortBindings, ep.addr.IP, defHostIP, ulPxyEnabled)
+	// The port mappings of a sidecar setup are forwarded to a sibling endpoint
+	var containerIP net.IP
+	switch {
+	case epConfig.PortTarget != nil:
+		containerIP = epConfig.PortTarget
+	case ep.addr != nil:
+		containerIP = ep.addr.IP
+	default:
+		return nil, types.BadRequestErrorf("port mappings need an IPv4 address, endpoint %s has none", ep.id)
+	}
+
+	return n.allocatePortsInternal(epConfig.PortBindings, containerIP, defHostIP, ulPxyEnabled, epConfig.PortClaim)
 }
 
-func (n *bridgeNetwork) allocatePortsInternal(bindings []types.PortBinding, containerIP, defHostIP net.IP, ulPxyEnabled bool) ([]types.PortBinding, error) {
+func (n *bridgeNetwork) allocatePortsInternal(bindings []types.PortBinding, containerIP, defHostIP net.IP, ulPxyEnabled, claim bool) ([]types.PortBinding, error) {
 	bs := make([]types.PortBinding, 0, len(bindings))
 	for _, c := range bindings {
 		b := c.GetCopy()
-		if err := n.allocatePort(&b, containerIP, defHostIP, ulPxyEnabled); err != nil {
+		if err := n.allocatePort(&b, containerIP, defHostIP, ulPxyEnabled, claim); err != nil {
 			// On allocation failure, release previously allocated ports. On cleanup error, just log a warning message
 			if cuErr := n.releasePortsInternal(bs); cuErr != nil {
 				logrus.Warnf("Upon allocation failure for %v, failed to clear previously allocated port bindings: %v", b, cuErr)
@@ -43,7 +54,9 @@ func (n *bridgeNetwork) allocatePortsInternal(bindings []types.PortBinding, cont
 	return bs, nil
 }
 
-func (n *bridgeNetwork) allocatePort(bnd *types.PortBinding, containerIP, defHostIP net.IP, ulPxyEnabled bool) error {
+// allocatePort maps the host port of the binding to the container one, or only
+// reserves it if claim is set
+func (n *bridgeNetwork) allocatePort(bnd *types.PortBinding, containerIP, defHostIP net.IP, ulPxyEnabled, claim bool) error {
 	var (
 		host net.Addr
 		err  error
@@ -69,8 +82,12 @@ func (n *bridgeNetwork) allocatePort(bnd *types.PortBinding, containerIP, defHos
 	}
 
 	// Try up to maxAllocatePortAttempts times to get a port that's not already allocated.
+	mapRange := n.portMapper.MapRange
+	if claim {
+		mapRange = n.portMapper.ClaimRange
+	}
 	for i := 0; i < maxAllocatePortAttempts; i++ {
-		if host, err = n.portMapper.MapRange(container, bnd.HostIP, int(bnd.HostPort), int(bnd.HostPortEnd), ulPxyEnabled); err == nil {
+		if host, err = mapRange(container, bnd.HostIP, int(bnd.HostPort), int(bnd.HostPortEnd), ulPxyEnabled); err == nil {
 			break
 		}
 		// There is no point in immediately retrying to map an explicitly chosen port.
@@ -126,3 +143,45 @@ func (n *bridgeNetwork) releasePort(bnd types.PortBinding) error {
 	}
 	return n.portMapper.Unmap(host)
 }
+
+// ActivatePortBindings forwards the host ports claimed for the endpoint. The
+// bindings already forwarded are left as they are, a failed activation can be retried.
+func (d *driver) ActivatePortBindings(nid, eid string) error {
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return err
+	}
+
+	ep, err := n.getEndpoint(eid)
+	if err != nil {
+		return err
+	}
+	if ep == nil {
+		return EndpointNotFoundError(eid)
+	}
+
+	n.Lock()
+	claimed := ep.portsClaimed
+	bindings := ep.portMapping
+	n.Unlock()
+
+	if !claimed {
+		return nil
+	}
+
+	for _, b := range bindings {
+		host, err := b.HostAddr()
+		if err != nil {
+			return err
+		}
+		if err := n.portMapper.Activate(host); err != nil {
+			return err
+		}
+	}
+
+	n.Lock()
+	ep.portsClaimed = false
+	n.Unlock()
+
+	return nil
+}
@@ -3,10 +3,13 @@ package bridge
 import (
 	"os"
 	"testing"
+	"time"
 
 	"github.com/docker/docker/pkg/reexec"
+	"github.com/docker/libnetwork/iptables"
 	"github.com/docker/libnetwork/netlabel"
 	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/portmapper"
 	"github.com/docker/libnetwork/types"
 )
 
@@ -78,3 +81,126 @@ func TestPortMappingConfig(t *testing.T) {
 		t.Fatalf("Failed to release mapped ports: %v", err)
 	}
 }
+
+func TestPortMappingClaimOnly(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	d := newDriver()
+
+	genericOption := make(map[string]interface{})
+	genericOption[netlabel.GenericData] = &configuration{EnableIPTables: true}
+	if err := d.Config(genericOption); err != nil {
+		t.Fatalf("Failed to setup driver config: %v", err)
+	}
+
+	netOptions := make(map[string]interface{})
+	netOptions[netlabel.GenericData] = &networkConfiguration{BridgeName: DefaultBridgeName}
+	if err := d.CreateNetwork("dummy", netOptions); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	epOptions := make(map[string]interface{})
+	epOptions[netlabel.PortMap] = []types.PortBinding{{Proto: types.TCP, Port: uint16(80), HostPort: uint16(18090)}}
+	epOptions[netlabel.PortMapClaimOnly] = true
+
+	te := &testEndpoint{ifaces: []*testInterface{}}
+	if err := d.CreateEndpoint("dummy", "ep1", te, epOptions); err != nil {
+		t.Fatalf("Failed to create the endpoint: %v", err)
+	}
+
+	// The claimed host port is not available to another endpoint
+	te2 := &testEndpoint{ifaces: []*testInterface{}}
+	if err := d.CreateEndpoint("dummy", "ep2", te2, epOptions); err == nil {
+		t.Fatal("Expected the claim of a claimed host port to fail")
+	}
+
+	ep := d.(*driver).networks["dummy"].endpoints["ep1"]
+	dnat := []string{"-p", "tcp", "-d", "0/0", "--dport", "18090", "-j", "DNAT",
+		"--to-destination", ep.addr.IP.String() + ":80"}
+	if iptables.Exists(iptables.Nat, DockerChain, dnat...) {
+		t.Fatal("DNAT rule installed for a claimed host port")
+	}
+
+	if err := d.(*driver).ActivatePortBindings("dummy", "ep1"); err != nil {
+		t.Fatalf("Failed to activate the port bindings: %v", err)
+	}
+	if !iptables.Exists(iptables.Nat, DockerChain, dnat...) {
+		t.Fatal("DNAT rule not installed for the activated host port")
+	}
+
+	if err := d.DeleteEndpoint("dummy", "ep1"); err != nil {
+		t.Fatalf("Failed to delete the endpoint: %v", err)
+	}
+	if iptables.Exists(iptables.Nat, DockerChain, dnat...) {
+		t.Fatal("DNAT rule left after the endpoint deletion")
+	}
+}
+
+func TestPortMappingAllowedRange(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	d := newDriver()
+
+	genericOption := make(map[string]interface{})
+	genericOption[netlabel.GenericData] = &configuration{AllowedHostPortStart: 1024, AllowedHostPortEnd: 1023}
+	if err := d.Config(genericOption); err == nil {
+		t.Fatal("Expected the driver config to be rejected for an empty allowed port range")
+	}
+
+	d = newDriver()
+	genericOption[netlabel.GenericData] = &configuration{AllowedHostPortStart: 1024, AllowedHostPortEnd: 65535}
+	if err := d.Config(genericOption); err != nil {
+		t.Fatalf("Failed to setup driver config: %v", err)
+	}
+
+	netOptions := make(map[string]interface{})
+	netOptions[netlabel.GenericData] = &networkConfiguration{BridgeName: DefaultBridgeName}
+	if err := d.CreateNetwork("dummy", netOptions); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	epOptions := make(map[string]interface{})
+	epOptions[netlabel.PortMap] = []types.PortBinding{{Proto: types.TCP, Port: uint16(80), HostPort: uint16(80)}}
+
+	te := &testEndpoint{ifaces: []*testInterface{}}
+	err := d.CreateEndpoint("dummy", "ep1", te, epOptions)
+	if err == nil {
+		t.Fatal("Expected publishing to host port 80 to fail")
+	}
+	if _, ok := err.(ErrPortNotAllowed); !ok {
+		t.Fatalf("Did not fail with expected error. Actual error: %v", err)
+	}
+
+	if _, ok := d.(*driver).networks["dummy"].endpoints["ep1"]; ok {
+		t.Fatal("Endpoint was left registered after the port binding got rejected")
+	}
+}
+
+func TestProxySocketOptionsConfig(t *testing.T) {
+	config := &networkConfiguration{}
+	if err := config.fromMap(map[string]interface{}{
+		"BridgeName":             "br-proxy",
+		"ProxyKeepAlive":         "true",
+		"ProxyKeepAliveInterval": "30",
+		"ProxyReadBuffer":        "65536",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := portmapper.SocketOptions{KeepAlive: true, KeepAliveInterval: 30 * time.Second, ReadBuffer: 65536}
+	if opts := config.proxySocketOptions(); opts != expected {
+		t.Fatalf("Expected socket options %+v. Got %+v", expected, opts)
+	}
+
+	config.ProxyWriteBuffer = -1
+	if err := config.Validate(); err == nil {
+		t.Fatal("Expected failure with a negative buffer size")
+	} else if _, ok := err.(ErrInvalidProxyOption); !ok {
+		t.Fatalf("Unexpected error type: %v", err)
+	}
+
+	if err := config.fromMap(map[string]interface{}{"ProxyReadBuffer": "large"}); err == nil {
+		t.Fatal("Expected failure parsing a non numeric buffer size")
+	}
+}
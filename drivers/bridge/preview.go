@@ -0,0 +1,32 @@
+package bridge
+
+import (
+	"net"
+
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/types"
+)
+
+// PreviewAddress returns the address the next endpoint created on the network
+// would get from the bridge allocator, which skips the gateway and the other
+// reserved addresses. Nothing is reserved, the answer is only advisory.
+func (d *driver) PreviewAddress(nid string) (net.IP, error) {
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return nil, err
+	}
+
+	n.Lock()
+	random := n.config.AllocationStrategy == netlabel.AllocationRandom
+	subnet := n.bridge.bridgeIPv4
+	n.Unlock()
+
+	if random {
+		return nil, types.ForbiddenErrorf("network %s hands out random addresses, the next one cannot be previewed", nid)
+	}
+	if subnet == nil {
+		return nil, types.NotFoundErrorf("network %s has no IPv4 subnet", nid)
+	}
+
+	return ipAllocator.PeekIP(subnet)
+}
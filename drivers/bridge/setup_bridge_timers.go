@@ -0,0 +1,51 @@
+package bridge
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+)
+
+// The ageing time range is the IEEE 802.1D one, the forward delay range is
+// the one the kernel accepts. Both are in seconds.
+const (
+	minAgeingTime   = 10
+	maxAgeingTime   = 1000000
+	minForwardDelay = 2
+	maxForwardDelay = 30
+)
+
+// sysfsNetRoot is where the kernel exposes the network devices attributes.
+// Tests replace it.
+var sysfsNetRoot = "/sys/class/net"
+
+// setupBridgeTimers sets the configured bridge ageing time and forward delay.
+// The kernel defaults are kept for the ones not configured.
+func setupBridgeTimers(config *networkConfiguration, i *bridgeInterface) error {
+	for _, t := range []struct {
+		attr    string
+		seconds int
+	}{
+		{"ageing_time", config.AgeingTime},
+		{"forward_delay", config.ForwardDelay},
+	} {
+		if t.seconds == 0 {
+			continue
+		}
+		if err := setBridgeAttr(config.BridgeName, t.attr, t.seconds); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setBridgeAttr writes a bridge timer attribute, which sysfs expresses in
+// hundredths of second.
+func setBridgeAttr(bridgeName, attr string, seconds int) error {
+	path := filepath.Join(sysfsNetRoot, bridgeName, "bridge", attr)
+	if err := ioutil.WriteFile(path, []byte(strconv.Itoa(seconds*100)), 0644); err != nil {
+		return fmt.Errorf("unable to set %s on bridge %s via sysfs: %v", attr, bridgeName, err)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package bridge
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/libnetwork/types"
+)
+
+func TestSetupBridgeTimers(t *testing.T) {
+	root, err := ioutil.TempDir("", "sysfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	defer func(r string) { sysfsNetRoot = r }(sysfsNetRoot)
+	sysfsNetRoot = root
+
+	// The kernel defaults, in hundredths of second
+	dir := filepath.Join(root, "br-timers", "bridge")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for attr, v := range map[string]string{"ageing_time": "30000", "forward_delay": "1500"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, attr), []byte(v), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := &networkConfiguration{}
+	if err := config.fromMap(map[string]interface{}{"BridgeName": "br-timers", "AgeingTime": "600"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := setupBridgeTimers(config, &bridgeInterface{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for attr, expected := range map[string]string{"ageing_time": "60000", "forward_delay": "1500"} {
+		b, err := ioutil.ReadFile(filepath.Join(dir, attr))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != expected {
+			t.Fatalf("Expected bridge %s to be %s. Got %s", attr, expected, b)
+		}
+	}
+}
+
+func TestBridgeTimersValidation(t *testing.T) {
+	for _, config := range []*networkConfiguration{
+		{AgeingTime: 5},
+		{AgeingTime: maxAgeingTime + 1},
+		{ForwardDelay: 1},
+		{ForwardDelay: 31},
+	} {
+		if _, ok := config.Validate().(types.BadRequestError); !ok {
+			t.Fatalf("Expected a bad request error for ageing time %d and forward delay %d", config.AgeingTime, config.ForwardDelay)
+		}
+	}
+}
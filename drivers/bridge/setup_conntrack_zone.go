@@ -0,0 +1,43 @@
+package bridge
+
+import (
+	"strconv"
+
+	"github.com/docker/libnetwork/iptables"
+)
+
+// conntrack zones are 16 bits wide, zone 0 is the default one
+const maxConntrackZone = 65535
+
+// getConntrackZoneRules returns the rules tracking the connections of the
+// network in its own zone: the packets the endpoints send to the bridge and
+// the ones the host sends out of it. Networks with overlapping subnets then
+// keep distinct conntrack and NAT entries. The zone only applies to the
+// original direction of the connections: their reply direction, which is the
+// one the traffic coming from the uplinks matches, like the replies to the
+// masqueraded connections, stays in the default zone.
+func getConntrackZoneRules(bridgeName string, zone int) []iptRule {
+	raw := []string{"-t", string(iptables.Raw)}
+	ct := []string{"-j", "CT", "--zone-orig", strconv.Itoa(zone)}
+	return []iptRule{
+		{table: iptables.Raw, chain: "PREROUTING", preArgs: raw, args: append([]string{"-i", bridgeName}, ct...)},
+		{table: iptables.Raw, chain: "OUTPUT", preArgs: raw, args: append([]string{"-o", bridgeName}, ct...)},
+	}
+}
+
+func setupConntrackZone(config *networkConfiguration, i *bridgeInterface) error {
+	return setConntrackZone(config, true)
+}
+
+// Install/Removes the rules assigning the network traffic to its conntrack zone.
+func setConntrackZone(config *networkConfiguration, enable bool) error {
+	if config.ConntrackZone == 0 {
+		return nil
+	}
+	for _, rule := range getConntrackZoneRules(config.BridgeName, config.ConntrackZone) {
+		if err := programNetworkRule(rule, "CT ZONE", enable); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package bridge
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/types"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+func TestConntrackZoneRules(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	fw := newFirewallShim()
+	defer func(f func(iptRule, string, bool) error) { programNetworkRule = f }(programNetworkRule)
+	programNetworkRule = fw.program
+
+	d := newDriver()
+	config := &configuration{}
+	if err := d.Config(map[string]interface{}{netlabel.GenericData: config}); err != nil {
+		t.Fatalf("Failed to setup driver config: %v", err)
+	}
+
+	netconfig := &networkConfiguration{BridgeName: "ctzone_br", AllowNonDefaultBridge: true, ConntrackZone: 42}
+	if err := d.CreateNetwork("dummy", map[string]interface{}{netlabel.GenericData: netconfig}); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	// The rules are only programmed with iptables enabled, which the network
+	// creation above would have needed, so program them here
+	config.EnableIPTables = true
+	if err := setupConntrackZone(netconfig, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	fw.check(t, map[string][][]string{
+		chainKey(iptables.Raw, "PREROUTING"): {{"-i", "ctzone_br", "-j", "CT", "--zone-orig", "42"}},
+		chainKey(iptables.Raw, "OUTPUT"):     {{"-o", "ctzone_br", "-j", "CT", "--zone-orig", "42"}},
+	})
+
+	info, err := d.(*driver).NetworkOperInfo("dummy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info[netlabel.ConntrackZone] != 42 {
+		t.Fatalf("Expected the network conntrack zone to be reported: %v", info)
+	}
+
+	if err := d.DeleteNetwork("dummy"); err != nil {
+		t.Fatal(err)
+	}
+	fw.check(t, nil)
+}
+
+func TestConntrackZoneInvalid(t *testing.T) {
+	config := &networkConfiguration{BridgeName: "ctzone_br", ConntrackZone: maxConntrackZone + 1}
+
+	err := config.Validate()
+	if err == nil {
+		t.Fatal("Expected validation to fail for an out of range conntrack zone")
+	}
+	if _, ok := err.(types.BadRequestError); !ok {
+		t.Fatalf("Unexpected error type: %T", err)
+	}
+}
+
+// newTestNs creates a network namespace and switches back to the host one
+func newTestNs(t *testing.T, hostNs netns.NsHandle) netns.NsHandle {
+	ns, err := netns.New()
+	if err != nil {
+		t.Fatalf("Failed to create a network namespace: %v", err)
+	}
+	if err := netns.Set(hostNs); err != nil {
+		t.Fatal(err)
+	}
+	return ns
+}
+
+// moveToNs moves the named link into the namespace, where it is configured
+// with the address and the default gateway
+func moveToNs(t *testing.T, name string, ns, hostNs netns.NsHandle, addr *net.IPNet, gw net.IP) {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := netlink.LinkSetNsFd(link, int(ns)); err != nil {
+		t.Fatalf("Failed to move %s to its namespace: %v", name, err)
+	}
+
+	if err := netns.Set(ns); err != nil {
+		t.Fatal(err)
+	}
+	defer netns.Set(hostNs)
+
+	if link, err = netlink.LinkByName(name); err != nil {
+		t.Fatal(err)
+	}
+	if err := netlink.AddrAdd(link, &netlink.Addr{IPNet: addr}); err != nil {
+		t.Fatalf("Failed to set the %s address: %v", name, err)
+	}
+	if err := netlink.LinkSetUp(link); err != nil {
+		t.Fatal(err)
+	}
+	lo, err := netlink.LinkByName("lo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := netlink.LinkSetUp(lo); err != nil {
+		t.Fatal(err)
+	}
+	if gw != nil {
+		if err := netlink.RouteAdd(&netlink.Route{LinkIndex: link.Attrs().Index, Gw: gw}); err != nil {
+			t.Fatalf("Failed to set the %s default gateway: %v", name, err)
+		}
+	}
+}
+
+// TestConntrackZoneTraffic exchanges packets between an endpoint of a zoned
+// network and a host reached through the masquerading uplink: the replies must
+// find the connection the requests created.
+func TestConntrackZoneTraffic(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	hostNs, err := netns.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hostNs.Close()
+	remoteNs := newTestNs(t, hostNs)
+	defer remoteNs.Close()
+	ctrNs := newTestNs(t, hostNs)
+	defer ctrNs.Close()
+
+	// The uplink and the remote host on its other side
+	uplink := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "ctzone_up0"}, PeerName: "ctzone_up1"}
+	if err := netlink.LinkAdd(uplink); err != nil {
+		t.Fatalf("Failed to create the uplink: %v", err)
+	}
+	if err := netlink.AddrAdd(uplink, &netlink.Addr{IPNet: &net.IPNet{IP: net.ParseIP("10.99.0.1"), Mask: net.CIDRMask(24, 32)}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := netlink.LinkSetUp(uplink); err != nil {
+		t.Fatal(err)
+	}
+	moveToNs(t, "ctzone_up1", remoteNs, hostNs, &net.IPNet{IP: net.ParseIP("10.99.0.2"), Mask: net.CIDRMask(24, 32)}, nil)
+
+	d := newDriver()
+	config := &configuration{EnableIPTables: true, EnableIPForwarding: true}
+	if err := d.Config(map[string]interface{}{netlabel.GenericData: config}); err != nil {
+		t.Fatalf("Failed to setup driver config: %v", err)
+	}
+
+	netconfig := &networkConfiguration{
+		BridgeName:            "ctzone_br",
+		AddressIPv4:           &net.IPNet{IP: net.ParseIP("172.30.42.1"), Mask: net.CIDRMask(24, 32)},
+		EnableIPMasquerade:    true,
+		EnableICC:             true,
+		ConntrackZone:         42,
+		AllowNonDefaultBridge: true,
+	}
+	if err := d.CreateNetwork("dummy", map[string]interface{}{netlabel.GenericData: netconfig}); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+	defer d.DeleteNetwork("dummy")
+
+	te := &testEndpoint{ifaces: []*testInterface{}}
+	if err := d.CreateEndpoint("dummy", "ep1", te, nil); err != nil {
+		t.Fatalf("Failed to create an endpoint: %v", err)
+	}
+	if err := d.Join("dummy", "ep1", "sbox", te, nil); err != nil {
+		t.Fatalf("Failed to join the endpoint: %v", err)
+	}
+	ifAddr := te.ifaces[0].addr
+	moveToNs(t, te.ifaces[0].srcName, ctrNs, hostNs, &ifAddr, te.gw)
+
+	// UDP echo server on the remote host, reporting the sources it sees
+	if err := netns.Set(remoteNs); err != nil {
+		t.Fatal(err)
+	}
+	srv, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("10.99.0.2"), Port: 5353})
+	netns.Set(hostNs)
+	if err != nil {
+		t.Fatalf("Failed to start the echo server: %v", err)
+	}
+	defer srv.Close()
+	sources := make(chan net.IP, 1)
+	go func() {
+		buf := make([]byte, 64)
+		n, from, err := srv.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		sources <- from.IP
+		srv.WriteToUDP(buf[:n], from)
+	}()
+
+	if err := netns.Set(ctrNs); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.ParseIP("10.99.0.2"), Port: 5353})
+	netns.Set(hostNs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("Expected the reply of the remote host to reach the endpoint: %v", err)
+	}
+	if string(buf[:n]) != "ping" {
+		t.Fatalf("Unexpected reply: %q", buf[:n])
+	}
+	if src := <-sources; !src.Equal(net.ParseIP("10.99.0.1")) {
+		t.Fatalf("Expected the request to be masqueraded behind the uplink address, got %s", src)
+	}
+}
@@ -0,0 +1,60 @@
+package bridge
+
+import (
+	"net"
+
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/netutils"
+)
+
+// egressInterfaceAddr returns the IPv4 address of the egress interface the
+// network traffic is translated to. Tests replace it.
+var egressInterfaceAddr = func(name string) (net.IP, error) {
+	addr, _, err := netutils.GetIfaceAddr(name)
+	if err != nil {
+		return nil, ErrInvalidEgressInterface(name)
+	}
+	return addr.(*net.IPNet).IP, nil
+}
+
+// getEgressNATRule returns the rule translating the traffic of the network
+// subnet leaving through the egress interface to the interface address. It is
+// inserted above the masquerading rule of the network, which still applies to
+// the traffic the host routes through its other interfaces.
+func getEgressNATRule(subnet *net.IPNet, egressIface string, egressIP net.IP) iptRule {
+	return iptRule{table: iptables.Nat, chain: "POSTROUTING", preArgs: []string{"-t", "nat"},
+		args: []string{"-s", subnet.String(), "-o", egressIface, "-j", "SNAT", "--to-source", egressIP.String()}}
+}
+
+// setupEgressNAT installs the rule translating the network traffic to the
+// address of its egress interface. The rule is kept with the network so that
+// it is removed as programmed, should the interface address change meanwhile.
+func (n *bridgeNetwork) setupEgressNAT(config *networkConfiguration, subnet *net.IPNet) error {
+	if config.EgressInterface == "" || !config.EnableIPMasquerade || subnet == nil {
+		return nil
+	}
+	ip, err := egressInterfaceAddr(config.EgressInterface)
+	if err != nil {
+		return err
+	}
+	rule := getEgressNATRule(subnet, config.EgressInterface, ip)
+	if err := programNetworkRule(rule, "SNAT EGRESS", true); err != nil {
+		return err
+	}
+	n.Lock()
+	n.egressRule = &rule
+	n.Unlock()
+	return nil
+}
+
+// removeEgressNAT removes the egress interface translation rule of the network, if any
+func (n *bridgeNetwork) removeEgressNAT() error {
+	n.Lock()
+	rule := n.egressRule
+	n.egressRule = nil
+	n.Unlock()
+	if rule == nil {
+		return nil
+	}
+	return programNetworkRule(*rule, "SNAT EGRESS", false)
+}
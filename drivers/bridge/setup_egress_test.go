@@ -0,0 +1,65 @@
+package bridge
+
+import (
+	"net"
+	"testing"
+
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/types"
+)
+
+func TestEgressNATRule(t *testing.T) {
+	fw := newFirewallShim()
+	defer func(f func(iptRule, string, bool) error) { programNetworkRule = f }(programNetworkRule)
+	programNetworkRule = fw.program
+	egressIP := net.ParseIP("10.1.1.5")
+	defer func(f func(string) (net.IP, error)) { egressInterfaceAddr = f }(egressInterfaceAddr)
+	egressInterfaceAddr = func(name string) (net.IP, error) {
+		if name != "eth1" {
+			return nil, ErrInvalidEgressInterface(name)
+		}
+		return egressIP, nil
+	}
+
+	config := &networkConfiguration{}
+	if err := config.fromMap(map[string]interface{}{"BridgeName": "br-egress", "EgressInterface": "eth1"}); err != nil {
+		t.Fatal(err)
+	}
+	config.EnableIPMasquerade = true
+	_, subnet, _ := net.ParseCIDR("172.18.0.0/16")
+
+	n := &bridgeNetwork{id: "dummy", config: config}
+	if err := n.setupEgressNAT(config, subnet); err != nil {
+		t.Fatal(err)
+	}
+	fw.check(t, map[string][][]string{
+		chainKey(iptables.Nat, "POSTROUTING"): {{"-s", "172.18.0.0/16", "-o", "eth1", "-j", "SNAT", "--to-source", "10.1.1.5"}},
+	})
+
+	// The rule is removed as programmed, whatever the current interface address
+	egressIP = net.ParseIP("10.1.1.6")
+	if err := n.removeEgressNAT(); err != nil {
+		t.Fatal(err)
+	}
+	fw.check(t, nil)
+}
+
+func TestEgressInterfaceNotFound(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	d := newDriver()
+	if err := d.Config(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &networkConfiguration{BridgeName: DefaultBridgeName, EgressInterface: "noegress0"}
+	genericOption := make(map[string]interface{})
+	genericOption[netlabel.GenericData] = config
+
+	err := d.CreateNetwork("dummy", genericOption)
+	if _, ok := err.(types.BadRequestError); !ok {
+		t.Fatalf("Expected a bad request error for a missing egress interface. Got %v", err)
+	}
+}
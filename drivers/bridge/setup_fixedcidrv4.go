@@ -9,6 +9,9 @@ func setupFixedCIDRv4(config *networkConfiguration, i *bridgeInterface) error {
 	if err != nil {
 		return err
 	}
+	if addrv4.IPNet == nil {
+		return &ErrNoIPAddr{}
+	}
 
 	log.Debugf("Using IPv4 subnet: %v", config.FixedCIDR)
 	if err := ipAllocator.RegisterSubnet(addrv4.IPNet, config.FixedCIDR); err != nil {
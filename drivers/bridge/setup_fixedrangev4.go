@@ -0,0 +1,56 @@
+package bridge
+
+import (
+	"bytes"
+	"net"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/ipallocator"
+)
+
+func setupFixedRangeV4(config *networkConfiguration, i *bridgeInterface) error {
+	addrv4, _, err := i.addresses()
+	if err != nil {
+		return err
+	}
+	if addrv4.IPNet == nil {
+		return &ErrNoIPAddr{}
+	}
+
+	log.Debugf("Using IPv4 range: %v-%v", config.FixedRangeStart, config.FixedRangeEnd)
+	if err := ipAllocator.RegisterRange(addrv4.IPNet, config.FixedRangeStart, config.FixedRangeEnd); err != nil {
+		if err == ipallocator.ErrBadSubnet || err == ipallocator.ErrBadIPRange {
+			return &ErrInvalidFixedRange{Start: config.FixedRangeStart, End: config.FixedRangeEnd}
+		}
+		return err
+	}
+
+	return nil
+}
+
+// validateFixedRange checks the container address range is a complete, ordered
+// IPv4 range, within the bridge subnet if specified, and not combined with FixedCIDR
+func (c *networkConfiguration) validateFixedRange() error {
+	start, end := c.FixedRangeStart.To4(), c.FixedRangeEnd.To4()
+	if start == nil || end == nil || c.FixedCIDR != nil || bytes.Compare(start, end) > 0 {
+		return &ErrInvalidFixedRange{Start: c.FixedRangeStart, End: c.FixedRangeEnd}
+	}
+	if c.AddressIPv4 != nil && !(c.AddressIPv4.Contains(start) && c.AddressIPv4.Contains(end)) {
+		return &ErrInvalidFixedRange{Start: c.FixedRangeStart, End: c.FixedRangeEnd}
+	}
+	return nil
+}
+
+// containerNetworkContains returns whether the containers IPv4 addresses can be
+// allocated the passed address: the container network restricts them to a part
+// of the bridge subnet, if specified as a subnet or as an address range.
+func (c *networkConfiguration) containerNetworkContains(ip net.IP) bool {
+	switch {
+	case c.FixedCIDR != nil:
+		return c.FixedCIDR.Contains(ip)
+	case c.FixedRangeStart != nil:
+		ip4 := ip.To4()
+		return ip4 != nil && bytes.Compare(ip4, c.FixedRangeStart.To4()) >= 0 && bytes.Compare(ip4, c.FixedRangeEnd.To4()) <= 0
+	}
+	return true
+}
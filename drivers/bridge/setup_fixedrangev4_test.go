@@ -0,0 +1,70 @@
+package bridge
+
+import (
+	"net"
+	"testing"
+
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/types"
+)
+
+func TestFixedRangeV4(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	d := newDriver()
+
+	if err := d.Config(nil); err != nil {
+		t.Fatalf("Failed to setup driver config: %v", err)
+	}
+
+	ip, nw, _ := net.ParseCIDR("172.30.40.1/24")
+	nw.IP = ip
+	netconfig := &networkConfiguration{
+		BridgeName:            "range_br",
+		AllowNonDefaultBridge: true,
+		AddressIPv4:           nw,
+		FixedRangeStart:       net.ParseIP("172.30.40.10"),
+		FixedRangeEnd:         net.ParseIP("172.30.40.12"),
+	}
+	if err := d.CreateNetwork("dummy", map[string]interface{}{netlabel.GenericData: netconfig}); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+	defer d.DeleteNetwork("dummy")
+
+	for _, expected := range []string{"172.30.40.10", "172.30.40.11", "172.30.40.12"} {
+		te := &testEndpoint{ifaces: []*testInterface{}}
+		if err := d.CreateEndpoint("dummy", "ep"+expected, te, nil); err != nil {
+			t.Fatalf("Failed to create an endpoint: %v", err)
+		}
+		if addr := te.Interfaces()[0].Address(); addr.IP.String() != expected {
+			t.Fatalf("Expected endpoint address %s in the range, got %s", expected, addr.IP)
+		}
+	}
+
+	te := &testEndpoint{ifaces: []*testInterface{}}
+	if err := d.CreateEndpoint("dummy", "epexhausted", te, nil); err == nil {
+		t.Fatal("Expected the endpoint creation to fail with the range exhausted")
+	}
+}
+
+func TestFixedRangeV4Invalid(t *testing.T) {
+	_, nw, _ := net.ParseCIDR("172.30.40.0/24")
+	for _, c := range []*networkConfiguration{
+		// End before start
+		{AddressIPv4: nw, FixedRangeStart: net.ParseIP("172.30.40.20"), FixedRangeEnd: net.ParseIP("172.30.40.10")},
+		// Out of the bridge subnet
+		{AddressIPv4: nw, FixedRangeStart: net.ParseIP("172.30.40.10"), FixedRangeEnd: net.ParseIP("172.30.41.10")},
+		// Incomplete
+		{AddressIPv4: nw, FixedRangeStart: net.ParseIP("172.30.40.10")},
+		// Along with a container subnet
+		{AddressIPv4: nw, FixedCIDR: nw, FixedRangeStart: net.ParseIP("172.30.40.10"), FixedRangeEnd: net.ParseIP("172.30.40.20")},
+	} {
+		err := c.Validate()
+		if _, ok := err.(*ErrInvalidFixedRange); !ok {
+			t.Fatalf("Expected ErrInvalidFixedRange for range %v-%v, got %v", c.FixedRangeStart, c.FixedRangeEnd, err)
+		}
+		if _, ok := err.(types.BadRequestError); !ok {
+			t.Fatalf("Unexpected error type: %T", err)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package bridge
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+)
+
+// restrictedGroupFwdMask covers the STP, MAC pause and LACP group addresses,
+// 01:80:C2:00:00:00 to 01:80:C2:00:00:02, whose frames the kernel refuses to
+// forward. LLDP is bit 14.
+const restrictedGroupFwdMask = 0x0007
+
+// setupGroupFwdMask sets the mask of the link-local group addresses whose
+// frames the bridge forwards instead of filtering them.
+func setupGroupFwdMask(config *networkConfiguration, i *bridgeInterface) error {
+	path := filepath.Join(sysfsNetRoot, config.BridgeName, "bridge", "group_fwd_mask")
+	if err := ioutil.WriteFile(path, []byte(strconv.Itoa(int(config.GroupFwdMask))), 0644); err != nil {
+		return fmt.Errorf("unable to set group_fwd_mask on bridge %s via sysfs: %v", config.BridgeName, err)
+	}
+	return nil
+}
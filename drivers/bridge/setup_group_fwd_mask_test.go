@@ -0,0 +1,68 @@
+package bridge
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/libnetwork/types"
+)
+
+func TestSetupGroupFwdMask(t *testing.T) {
+	root, err := ioutil.TempDir("", "sysfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	defer func(r string) { sysfsNetRoot = r }(sysfsNetRoot)
+	sysfsNetRoot = root
+
+	dir := filepath.Join(root, "br-fwdmask", "bridge")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "group_fwd_mask")
+	if err := ioutil.WriteFile(path, []byte("0"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Forward LLDP
+	config := &networkConfiguration{}
+	if err := config.fromMap(map[string]interface{}{"BridgeName": "br-fwdmask", "GroupFwdMask": "0x4000"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := setupGroupFwdMask(config, &bridgeInterface{}); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "16384" {
+		t.Fatalf("Expected bridge group_fwd_mask to be 16384. Got %s", b)
+	}
+}
+
+func TestGroupFwdMaskValidation(t *testing.T) {
+	for _, mask := range []uint16{0x0001, 0x0004, 0x4003} {
+		config := &networkConfiguration{GroupFwdMask: mask}
+		err := config.Validate()
+		if _, ok := err.(ErrInvalidGroupFwdMask); !ok {
+			t.Fatalf("Expected ErrInvalidGroupFwdMask for group forward mask %#04x. Got %v", mask, err)
+		}
+		if _, ok := err.(types.BadRequestError); !ok {
+			t.Fatalf("Expected a bad request error for group forward mask %#04x", mask)
+		}
+	}
+
+	config := &networkConfiguration{}
+	if err := config.fromMap(map[string]interface{}{"GroupFwdMask": "0x10000"}); err == nil {
+		t.Fatal("Expected a failure parsing a group forward mask wider than 16 bits")
+	}
+}
@@ -53,6 +53,11 @@ func (n *bridgeNetwork) setupIPTables(config *networkConfiguration, i *bridgeInt
 		return fmt.Errorf("Cannot program chains, EnableIPTable is disabled")
 	}
 
+	// The rules are IPv4 ones, there is nothing to program for an IPv6 only bridge
+	if i.bridgeIPv4 == nil {
+		return nil
+	}
+
 	// Pickup this configuraton option from driver
 	hairpinMode := !driverConfig.EnableUserlandProxy
 
@@ -69,6 +74,12 @@ func (n *bridgeNetwork) setupIPTables(config *networkConfiguration, i *bridgeInt
 		return fmt.Errorf("Failed to Setup IP tables: %s", err.Error())
 	}
 
+	// With an egress interface, the traffic leaving through it is translated
+	// to its address, the rest is still masqueraded
+	if err = n.setupEgressNAT(config, maskedAddrv4); err != nil {
+		return err
+	}
+
 	natChain, filterChain, err := n.getDriverChains()
 	if err != nil {
 		return fmt.Errorf("Failed to setup IP tables, cannot acquire chain info %s", err.Error())
@@ -138,6 +149,10 @@ func setupIPTablesInternal(bridgeIface string, addr net.Addr, icc, ipmasq, hairp
 	return nil
 }
 
+// programEndpointRule installs/removes the per endpoint rules. Tests replace it
+// to capture the rules instead of programming them.
+var programEndpointRule = programChainRule
+
 func programChainRule(rule iptRule, ruleDescr string, insert bool) error {
 	var (
 		prefix    []string
@@ -160,7 +175,7 @@ func programChainRule(rule iptRule, ruleDescr string, insert bool) error {
 	}
 
 	if condition {
-		if output, err := iptables.Raw(append(prefix, rule.args...)...); err != nil {
+		if output, err := iptables.Exec(append(prefix, rule.args...)...); err != nil {
 			return fmt.Errorf("Unable to %s %s rule: %s", operation, ruleDescr, err.Error())
 		} else if len(output) != 0 {
 			return &iptables.ChainError{Chain: rule.chain, Output: output}
@@ -181,20 +196,20 @@ func setIcc(bridgeIface string, iccEnable, insert bool) error {
 
 	if insert {
 		if !iccEnable {
-			iptables.Raw(append([]string{"-D", chain}, acceptArgs...)...)
+			iptables.Exec(append([]string{"-D", chain}, acceptArgs...)...)
 
 			if !iptables.Exists(table, chain, dropArgs...) {
-				if output, err := iptables.Raw(append([]string{"-A", chain}, dropArgs...)...); err != nil {
+				if output, err := iptables.Exec(append([]string{"-A", chain}, dropArgs...)...); err != nil {
 					return fmt.Errorf("Unable to prevent intercontainer communication: %s", err.Error())
 				} else if len(output) != 0 {
 					return fmt.Errorf("Error disabling intercontainer communication: %s", output)
 				}
 			}
 		} else {
-			iptables.Raw(append([]string{"-D", chain}, dropArgs...)...)
+			iptables.Exec(append([]string{"-D", chain}, dropArgs...)...)
 
 			if !iptables.Exists(table, chain, acceptArgs...) {
-				if output, err := iptables.Raw(append([]string{"-I", chain}, acceptArgs...)...); err != nil {
+				if output, err := iptables.Exec(append([]string{"-I", chain}, acceptArgs...)...); err != nil {
 					return fmt.Errorf("Unable to allow intercontainer communication: %s", err.Error())
 				} else if len(output) != 0 {
 					return fmt.Errorf("Error enabling intercontainer communication: %s", output)
@@ -205,11 +220,11 @@ func setIcc(bridgeIface string, iccEnable, insert bool) error {
 		// Remove any ICC rule.
 		if !iccEnable {
 			if iptables.Exists(table, chain, dropArgs...) {
-				iptables.Raw(append([]string{"-D", chain}, dropArgs...)...)
+				iptables.Exec(append([]string{"-D", chain}, dropArgs...)...)
 			}
 		} else {
 			if iptables.Exists(table, chain, acceptArgs...) {
-				iptables.Raw(append([]string{"-D", chain}, acceptArgs...)...)
+				iptables.Exec(append([]string{"-D", chain}, acceptArgs...)...)
 			}
 		}
 	}
@@ -230,7 +245,7 @@ func setINC(network1, network2 string, enable bool) error {
 			if iptables.Exists(table, chain, args[i]...) {
 				continue
 			}
-			if output, err := iptables.Raw(append([]string{"-I", chain}, args[i]...)...); err != nil {
+			if output, err := iptables.Exec(append([]string{"-I", chain}, args[i]...)...); err != nil {
 				return fmt.Errorf("unable to add inter-network communication rule: %s", err.Error())
 			} else if len(output) != 0 {
 				return fmt.Errorf("error adding inter-network communication rule: %s", string(output))
@@ -241,7 +256,7 @@ func setINC(network1, network2 string, enable bool) error {
 			if !iptables.Exists(table, chain, args[i]...) {
 				continue
 			}
-			if output, err := iptables.Raw(append([]string{"-D", chain}, args[i]...)...); err != nil {
+			if output, err := iptables.Exec(append([]string{"-D", chain}, args[i]...)...); err != nil {
 				return fmt.Errorf("unable to remove inter-network communication rule: %s", err.Error())
 			} else if len(output) != 0 {
 				return fmt.Errorf("error removing inter-network communication rule: %s", string(output))
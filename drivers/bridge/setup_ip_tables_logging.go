@@ -0,0 +1,189 @@
+package bridge
+
+import (
+	"strconv"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/options"
+	"github.com/docker/libnetwork/types"
+)
+
+const (
+	// iptables rejects log prefixes longer than this
+	maxLogPrefixLen  = 29
+	defaultLogLimit  = "5/min"
+	defaultLogPrefix = "DOCKER-DROP "
+)
+
+func setupIPTablesLogging(config *networkConfiguration, i *bridgeInterface) error {
+	return setICCLogging(config, true)
+}
+
+// getLogArgs returns the rate limited LOG target arguments for the network.
+func getLogArgs(config *networkConfiguration) []string {
+	prefix := config.IPTablesLogPrefix
+	if prefix == "" {
+		prefix = defaultLogPrefix
+	}
+
+	limit := config.IPTablesLogLimit
+	if limit == "" {
+		limit = defaultLogLimit
+	}
+
+	return []string{"-m", "limit", "--limit", limit, "-j", "LOG", "--log-prefix", prefix}
+}
+
+// getICCLoggingRule returns the rule logging the inter container packets
+// which are dropped on the bridge when ICC is disabled.
+func getICCLoggingRule(config *networkConfiguration) iptRule {
+	return iptRule{table: iptables.Filter, chain: "FORWARD",
+		args: append([]string{"-i", config.BridgeName, "-o", config.BridgeName}, getLogArgs(config)...)}
+}
+
+// getINCLoggingRule returns the rule logging the packets from the source
+// network which are dropped by the inter network isolation rules.
+func getINCLoggingRule(config *networkConfiguration, src, dst string) iptRule {
+	return iptRule{table: iptables.Filter, chain: "FORWARD",
+		args: append([]string{"-s", src, "-d", dst}, getLogArgs(config)...)}
+}
+
+// Install/Removes the ICC logging rule. The rule is inserted at the top of
+// the chain so that it precedes the DROP rule it mirrors.
+func setICCLogging(config *networkConfiguration, enable bool) error {
+	if !config.EnableIPTablesLogging || config.EnableICC {
+		return nil
+	}
+	return programNetworkRule(getICCLoggingRule(config), "LOG ICC DROP", enable)
+}
+
+// Install/Removes the logging rule for the packets from the src network isolated
+// from the network owning this configuration. The latter's config defines the rule.
+func setINCLogging(config *networkConfiguration, src, dst string, enable bool) error {
+	if !config.EnableIPTablesLogging {
+		return nil
+	}
+	return programNetworkRule(getINCLoggingRule(config, src, dst), "LOG INC DROP", enable)
+}
+
+// Install/Removes the logging rules the passed configuration of the network
+// defines: the ICC one and, for each of the other networks, the one for the
+// packets from that network.
+func (n *bridgeNetwork) setLogging(config *networkConfiguration, others []*bridgeNetwork, enable bool) error {
+	n.Lock()
+	thisV4 := n.bridge.bridgeIPv4
+	thisV6 := getV6Network(n.config, n.bridge)
+	n.Unlock()
+
+	if err := setICCLogging(config, enable); err != nil {
+		return err
+	}
+
+	for _, o := range others {
+		o.Lock()
+		otherV4 := o.bridge.bridgeIPv4
+		otherV6 := getV6Network(o.config, o.bridge)
+		o.Unlock()
+
+		if thisV4 != nil && otherV4 != nil && !types.CompareIPNet(thisV4, otherV4) {
+			if err := setINCLogging(config, otherV4.String(), thisV4.String(), enable); err != nil {
+				return err
+			}
+		}
+		if thisV6 != nil && otherV6 != nil && !types.CompareIPNet(thisV6, otherV6) {
+			if err := setINCLogging(config, otherV6.String(), thisV6.String(), enable); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// UpdateNetwork changes the iptables logging options of the network, the only
+// ones which can be updated at runtime, and reprograms its logging rules.
+func (d *driver) UpdateNetwork(nid string, option map[string]interface{}) error {
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return err
+	}
+
+	var data map[string]interface{}
+	for k, v := range option {
+		if k != netlabel.GenericData {
+			return types.BadRequestErrorf("bridge network option %s cannot be updated", k)
+		}
+		switch opt := v.(type) {
+		case options.Generic:
+			data = opt
+		case map[string]interface{}:
+			data = opt
+		default:
+			return types.BadRequestErrorf("do not recognize network update format: %T", opt)
+		}
+	}
+
+	n.Lock()
+	old := n.config
+	n.Unlock()
+
+	config := *old
+	for k, v := range data {
+		switch k {
+		case "EnableIPTablesLogging":
+			switch b := v.(type) {
+			case bool:
+				config.EnableIPTablesLogging = b
+			case string:
+				if config.EnableIPTablesLogging, err = strconv.ParseBool(b); err != nil {
+					return types.BadRequestErrorf("failed to parse EnableIPTablesLogging value: %s", err.Error())
+				}
+			default:
+				return types.BadRequestErrorf("invalid type for EnableIPTablesLogging value")
+			}
+		case "IPTablesLogPrefix":
+			var ok bool
+			if config.IPTablesLogPrefix, ok = v.(string); !ok {
+				return types.BadRequestErrorf("invalid type for IPTablesLogPrefix value")
+			}
+		case "IPTablesLogLimit":
+			var ok bool
+			if config.IPTablesLogLimit, ok = v.(string); !ok {
+				return types.BadRequestErrorf("invalid type for IPTablesLogLimit value")
+			}
+		default:
+			return types.BadRequestErrorf("bridge network option %s cannot be updated", k)
+		}
+	}
+	if len(config.IPTablesLogPrefix) > maxLogPrefixLen {
+		return ErrInvalidLogPrefix(config.IPTablesLogPrefix)
+	}
+
+	d.Lock()
+	enableIPTables := d.config != nil && d.config.EnableIPTables
+	d.Unlock()
+
+	if enableIPTables {
+		others := d.getNetworks()
+		if err := n.setLogging(old, others, false); err != nil {
+			return err
+		}
+		if err := n.setLogging(&config, others, true); err != nil {
+			if err := n.setLogging(&config, others, false); err != nil {
+				logrus.Warnf("Failed on removing the iptables logging rules on cleanup: %v", err)
+			}
+			if err := n.setLogging(old, others, true); err != nil {
+				logrus.Warnf("Failed on restoring the iptables logging rules on cleanup: %v", err)
+			}
+			return err
+		}
+	}
+
+	n.Lock()
+	n.config = &config
+	n.Unlock()
+
+	return nil
+}
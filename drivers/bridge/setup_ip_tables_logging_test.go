@@ -0,0 +1,137 @@
+package bridge
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/options"
+	"github.com/docker/libnetwork/types"
+)
+
+func TestIPTablesLoggingRules(t *testing.T) {
+	config := &networkConfiguration{
+		BridgeName:            "br-log",
+		EnableIPTablesLogging: true,
+		IPTablesLogPrefix:     "br-log drop: ",
+		IPTablesLogLimit:      "10/sec",
+	}
+
+	icc := getICCLoggingRule(config)
+	if !hasArgs(icc, "-i", "br-log", "-o", "br-log") {
+		t.Fatalf("ICC logging rule does not match the bridge traffic: %v", icc.args)
+	}
+	if !hasArgs(icc, "-j", "LOG", "--log-prefix", "br-log drop: ") {
+		t.Fatalf("ICC logging rule does not carry the configured prefix: %v", icc.args)
+	}
+	if !hasArgs(icc, "-m", "limit", "--limit", "10/sec") {
+		t.Fatalf("ICC logging rule does not carry the configured rate limit: %v", icc.args)
+	}
+
+	inc := getINCLoggingRule(config, "172.18.0.0/16", "172.19.0.0/16")
+	if !hasArgs(inc, "-s", "172.18.0.0/16", "-d", "172.19.0.0/16") {
+		t.Fatalf("INC logging rule does not match the isolated networks: %v", inc.args)
+	}
+	if !hasArgs(inc, "-j", "LOG", "--log-prefix", "br-log drop: ") {
+		t.Fatalf("INC logging rule does not carry the configured prefix: %v", inc.args)
+	}
+
+	// Defaults apply when prefix and limit are not specified
+	config.IPTablesLogPrefix = ""
+	config.IPTablesLogLimit = ""
+	icc = getICCLoggingRule(config)
+	if !hasArgs(icc, "--limit", defaultLogLimit, "-j", "LOG", "--log-prefix", defaultLogPrefix) {
+		t.Fatalf("ICC logging rule does not carry the default prefix and limit: %v", icc.args)
+	}
+}
+
+func TestIPTablesLoggingInvalidPrefix(t *testing.T) {
+	config := &networkConfiguration{
+		BridgeName:        "br-log",
+		IPTablesLogPrefix: strings.Repeat("x", maxLogPrefixLen+1),
+	}
+
+	err := config.Validate()
+	if err == nil {
+		t.Fatal("Expected validation to fail for a too long log prefix")
+	}
+	if _, ok := err.(types.BadRequestError); !ok {
+		t.Fatalf("Unexpected error type: %T", err)
+	}
+}
+
+func TestIPTablesLoggingUpdate(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	fw := newFirewallShim()
+	defer func(f func(iptRule, string, bool) error) { programNetworkRule = f }(programNetworkRule)
+	programNetworkRule = fw.program
+
+	d := newDriver().(*driver)
+	config := &configuration{}
+	if err := d.Config(map[string]interface{}{netlabel.GenericData: config}); err != nil {
+		t.Fatalf("Failed to setup driver config: %v", err)
+	}
+
+	netconfig := &networkConfiguration{BridgeName: "log_br", AllowNonDefaultBridge: true}
+	if err := d.CreateNetwork("dummy", map[string]interface{}{netlabel.GenericData: netconfig}); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+	otherconfig := &networkConfiguration{BridgeName: "log_br2", AllowNonDefaultBridge: true}
+	if err := d.CreateNetwork("other", map[string]interface{}{netlabel.GenericData: otherconfig}); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	// The rules are only programmed with iptables enabled, which the network
+	// creation above would have needed, so program them here
+	config.EnableIPTables = true
+	this := d.networks["dummy"].bridge.bridgeIPv4.String()
+	other := d.networks["other"].bridge.bridgeIPv4.String()
+	logRules := func(prefix string) [][]string {
+		logArgs := []string{"-m", "limit", "--limit", defaultLogLimit, "-j", "LOG", "--log-prefix", prefix}
+		return [][]string{
+			append([]string{"-s", other, "-d", this}, logArgs...),
+			append([]string{"-i", "log_br", "-o", "log_br"}, logArgs...),
+		}
+	}
+
+	if err := d.UpdateNetwork("dummy", map[string]interface{}{netlabel.GenericData: options.Generic{
+		"EnableIPTablesLogging": true,
+		"IPTablesLogPrefix":     "log_br drop: ",
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// ICC is disabled, the ICC drops are logged along with the packets from the other network
+	fw.check(t, map[string][][]string{chainKey(iptables.Filter, "FORWARD"): logRules("log_br drop: ")})
+
+	if err := d.UpdateNetwork("dummy", map[string]interface{}{netlabel.GenericData: options.Generic{
+		"IPTablesLogPrefix": "updated: ",
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	fw.check(t, map[string][][]string{chainKey(iptables.Filter, "FORWARD"): logRules("updated: ")})
+
+	if err := d.UpdateNetwork("dummy", map[string]interface{}{netlabel.GenericData: options.Generic{
+		"EnableIPTablesLogging": false,
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	fw.check(t, nil)
+
+	err := d.UpdateNetwork("dummy", map[string]interface{}{netlabel.GenericData: options.Generic{
+		"EnableICC": false,
+	}})
+	if _, ok := err.(types.BadRequestError); !ok {
+		t.Fatalf("Expected a bad request error updating an option which cannot be updated, got %v", err)
+	}
+
+	config.EnableIPTables = false
+	for _, id := range []string{"dummy", "other"} {
+		if err := d.DeleteNetwork(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -59,6 +59,11 @@ func setupBridgeIPv4(config *networkConfiguration, i *bridgeInterface) error {
 
 	bridgeIPv4, err := electBridgeIPv4(config)
 	if err != nil {
+		// On an IPv6 only host there may be no IPv4 range left to elect
+		if _, ok := err.(IPv4AddrRangeError); ok && config.ipv6Configured() {
+			log.Infof("No IPv4 range available for bridge %s, setting it up IPv6 only", config.BridgeName)
+			return nil
+		}
 		return err
 	}
 
@@ -78,8 +83,8 @@ func allocateBridgeIP(config *networkConfiguration, i *bridgeInterface) error {
 	// Because of the way ipallocator manages the container address space,
 	// reserve bridge address only if it belongs to the container network
 	// (if defined), no need otherwise
-	if config.FixedCIDR == nil || config.FixedCIDR.Contains(i.bridgeIPv4.IP) {
-		ipAllocator.RequestIP(i.bridgeIPv4, i.bridgeIPv4.IP)
+	if i.bridgeIPv4 != nil && config.containerNetworkContains(i.bridgeIPv4.IP) {
+		i.reserveIP(i.bridgeIPv4, i.bridgeIPv4.IP)
 	}
 	return nil
 }
@@ -111,15 +116,20 @@ func electBridgeIPv4(config *networkConfiguration) (*net.IPNet, error) {
 }
 
 func setupGatewayIPv4(config *networkConfiguration, i *bridgeInterface) error {
-	if !i.bridgeIPv4.Contains(config.DefaultGatewayIPv4) {
+	if i.bridgeIPv4 == nil || !i.bridgeIPv4.Contains(config.DefaultGatewayIPv4) {
+		return &ErrInvalidGateway{}
+	}
+
+	// Any host address of the subnet can be the gateway
+	if first, last := netutils.NetworkRange(i.bridgeIPv4); config.DefaultGatewayIPv4.Equal(first) || config.DefaultGatewayIPv4.Equal(last) {
 		return &ErrInvalidGateway{}
 	}
 
 	// Because of the way ipallocator manages the container address space,
 	// reserve default gw address only if it belongs to the container network
 	// (if defined), no need otherwise
-	if config.FixedCIDR == nil || config.FixedCIDR.Contains(config.DefaultGatewayIPv4) {
-		if _, err := ipAllocator.RequestIP(i.bridgeIPv4, config.DefaultGatewayIPv4); err != nil {
+	if config.containerNetworkContains(config.DefaultGatewayIPv4) {
+		if err := i.reserveIP(i.bridgeIPv4, config.DefaultGatewayIPv4); err != nil {
 			return err
 		}
 	}
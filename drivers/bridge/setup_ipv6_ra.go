@@ -0,0 +1,199 @@
+package bridge
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	// Stateless address autoconfiguration only works on /64 prefixes
+	raPrefixLen = 64
+
+	icmpv6RouterSolicitation  = 133
+	icmpv6RouterAdvertisement = 134
+
+	raHopLimit          = 64
+	raRouterLifetime    = 1800  // seconds
+	raValidLifetime     = 86400 // seconds
+	raPreferredLifetime = 14400 // seconds
+)
+
+var (
+	// raInterval is the period of the unsolicited router advertisements
+	raInterval = 10 * time.Second
+	// raPollTimeout bounds how long the responder takes to notice a stop request
+	raPollTimeout = 250 * time.Millisecond
+
+	allNodes   = net.ParseIP("ff02::1")
+	allRouters = net.ParseIP("ff02::2")
+)
+
+// raResponder is a minimal router advertisement daemon. It periodically
+// advertises the network IPv6 prefix on the bridge, and on demand when a
+// router solicitation is received, so that the containers doing stateless
+// address autoconfiguration get an address in the prefix.
+type raResponder struct {
+	bridgeName string
+	prefix     *net.IPNet
+	message    []byte
+	fd         int
+	ifIndex    int
+	stopCh     chan struct{}
+	stopped    chan struct{}
+	once       sync.Once
+}
+
+func (n *bridgeNetwork) setupIPv6RA(config *networkConfiguration, i *bridgeInterface) error {
+	ra, err := newRAResponder(config)
+	if err != nil {
+		return err
+	}
+
+	n.Lock()
+	n.ra = ra
+	n.Unlock()
+
+	go ra.run()
+
+	return nil
+}
+
+func (n *bridgeNetwork) stopIPv6RA() {
+	n.Lock()
+	ra := n.ra
+	n.ra = nil
+	n.Unlock()
+
+	if ra != nil {
+		ra.stop()
+	}
+}
+
+func newRAResponder(config *networkConfiguration) (*raResponder, error) {
+	iface, err := net.InterfaceByName(config.BridgeName)
+	if err != nil {
+		return nil, fmt.Errorf("could not find bridge %s: %v", config.BridgeName, err)
+	}
+
+	fd, err := syscall.Socket(syscall.AF_INET6, syscall.SOCK_RAW, syscall.IPPROTO_ICMPV6)
+	if err != nil {
+		return nil, fmt.Errorf("could not open the router advertisement socket: %v", err)
+	}
+
+	if err := setupRASocket(fd, iface); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("could not setup the router advertisement socket on %s: %v", config.BridgeName, err)
+	}
+
+	return &raResponder{
+		bridgeName: config.BridgeName,
+		prefix:     config.FixedCIDRv6,
+		message:    raMessage(config.FixedCIDRv6, iface.HardwareAddr, config.Mtu),
+		fd:         fd,
+		ifIndex:    iface.Index,
+		stopCh:     make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}, nil
+}
+
+func setupRASocket(fd int, iface *net.Interface) error {
+	if err := syscall.BindToDevice(fd, iface.Name); err != nil {
+		return err
+	}
+	// Neighbor discovery messages must be sent with a hop limit of 255
+	if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_HOPS, 255); err != nil {
+		return err
+	}
+	if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_IF, iface.Index); err != nil {
+		return err
+	}
+	mreq := &syscall.IPv6Mreq{Interface: uint32(iface.Index)}
+	copy(mreq.Multiaddr[:], allRouters)
+	if err := syscall.SetsockoptIPv6Mreq(fd, syscall.IPPROTO_IPV6, syscall.IPV6_JOIN_GROUP, mreq); err != nil {
+		return err
+	}
+	// Wake up the receive loop periodically to honor the stop request
+	tv := syscall.NsecToTimeval(int64(raPollTimeout))
+	return syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv)
+}
+
+// raMessage returns the ICMPv6 router advertisement for the prefix. The
+// checksum is left for the kernel to compute.
+func raMessage(prefix *net.IPNet, mac net.HardwareAddr, mtu int) []byte {
+	b := make([]byte, 16)
+	b[0] = icmpv6RouterAdvertisement
+	b[4] = raHopLimit
+	binary.BigEndian.PutUint16(b[6:8], raRouterLifetime)
+
+	// Prefix information option, on-link and autonomous flags set
+	pio := make([]byte, 32)
+	pio[0] = 3
+	pio[1] = 4
+	ones, _ := prefix.Mask.Size()
+	pio[2] = byte(ones)
+	pio[3] = 0xc0
+	binary.BigEndian.PutUint32(pio[4:8], raValidLifetime)
+	binary.BigEndian.PutUint32(pio[8:12], raPreferredLifetime)
+	copy(pio[16:32], prefix.IP.Mask(prefix.Mask).To16())
+	b = append(b, pio...)
+
+	if mtu != 0 {
+		mo := make([]byte, 8)
+		mo[0] = 5
+		mo[1] = 1
+		binary.BigEndian.PutUint32(mo[4:8], uint32(mtu))
+		b = append(b, mo...)
+	}
+
+	if len(mac) == 6 {
+		b = append(b, append([]byte{1, 1}, mac...)...)
+	}
+
+	return b
+}
+
+func (ra *raResponder) advertise() {
+	dst := &syscall.SockaddrInet6{ZoneId: uint32(ra.ifIndex)}
+	copy(dst.Addr[:], allNodes)
+	if err := syscall.Sendto(ra.fd, ra.message, 0, dst); err != nil {
+		logrus.Debugf("Failed to send router advertisement on %s: %v", ra.bridgeName, err)
+	}
+}
+
+func (ra *raResponder) run() {
+	defer close(ra.stopped)
+	defer syscall.Close(ra.fd)
+
+	buf := make([]byte, 1500)
+	next := time.Now()
+	for {
+		select {
+		case <-ra.stopCh:
+			return
+		default:
+		}
+
+		if !time.Now().Before(next) {
+			ra.advertise()
+			next = time.Now().Add(raInterval)
+		}
+
+		n, _, err := syscall.Recvfrom(ra.fd, buf, 0)
+		if err == nil && n > 0 && buf[0] == icmpv6RouterSolicitation {
+			ra.advertise()
+		}
+	}
+}
+
+func (ra *raResponder) stop() {
+	ra.once.Do(func() {
+		close(ra.stopCh)
+	})
+	<-ra.stopped
+}
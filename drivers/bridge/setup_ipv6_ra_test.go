@@ -0,0 +1,127 @@
+package bridge
+
+import (
+	"bytes"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/types"
+	"github.com/vishvananda/netlink"
+)
+
+// receiveRA waits for a router advertisement on the interface and returns it
+func receiveRA(t *testing.T, ifName string) []byte {
+	fd, err := syscall.Socket(syscall.AF_INET6, syscall.SOCK_RAW, syscall.IPPROTO_ICMPV6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer syscall.Close(fd)
+	if err := syscall.BindToDevice(fd, ifName); err != nil {
+		t.Fatal(err)
+	}
+	tv := syscall.NsecToTimeval(int64(100 * time.Millisecond))
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 1500)
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err == nil && n > 0 && buf[0] == icmpv6RouterAdvertisement {
+			return buf[:n]
+		}
+	}
+	t.Fatalf("No router advertisement received on %s", ifName)
+	return nil
+}
+
+func TestIPv6RA(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	defer func(d time.Duration) { raInterval = d }(raInterval)
+	raInterval = 100 * time.Millisecond
+
+	d := newDriver()
+	if err := d.Config(nil); err != nil {
+		t.Fatalf("Failed to setup driver config: %v", err)
+	}
+
+	_, prefix, _ := net.ParseCIDR("2001:db8:1234:5678::/64")
+	config := &networkConfiguration{
+		BridgeName:            "ra_test",
+		AllowNonDefaultBridge: true,
+		EnableIPv6:            true,
+		EnableIPv6RA:          true,
+		FixedCIDRv6:           prefix,
+	}
+	genericOption := make(map[string]interface{})
+	genericOption[netlabel.GenericData] = config
+	if err := d.CreateNetwork("ra", genericOption); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	n := d.(*driver).networks["ra"]
+	ra := n.ra
+	if ra == nil {
+		t.Fatal("Router advertisement responder was not started with the network")
+	}
+
+	// Plug a pipe in the bridge and listen on the other end, where a container would be
+	veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "ra_veth0"}, PeerName: "ra_veth1"}
+	if err := netlink.LinkAdd(veth); err != nil {
+		t.Fatal(err)
+	}
+	if err := addToBridge("ra_veth0", config.BridgeName); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"ra_veth0", "ra_veth1"} {
+		link, err := netlink.LinkByName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := netlink.LinkSetUp(link); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	msg := receiveRA(t, "ra_veth1")
+	// The prefix information option follows the 16 bytes RA header
+	if len(msg) < 48 || msg[16] != 3 || msg[18] != raPrefixLen || !bytes.Equal(msg[32:48], prefix.IP.To16()) {
+		t.Fatalf("Router advertisement does not advertise %s: %v", prefix, msg)
+	}
+
+	if link, err := netlink.LinkByName("ra_veth0"); err == nil {
+		netlink.LinkDel(link)
+	}
+	if err := d.DeleteNetwork("ra"); err != nil {
+		t.Fatal(err)
+	}
+	if n.ra != nil {
+		t.Fatal("Router advertisement responder was not released with the network")
+	}
+	select {
+	case <-ra.stopped:
+	default:
+		t.Fatal("Router advertisement responder was not stopped with the network")
+	}
+}
+
+func TestIPv6RAInvalidPrefix(t *testing.T) {
+	_, prefix, _ := net.ParseCIDR("2001:db8:1234:5678::/80")
+	config := &networkConfiguration{
+		EnableIPv6:   true,
+		EnableIPv6RA: true,
+		FixedCIDRv6:  prefix,
+	}
+	if _, ok := config.Validate().(types.BadRequestError); !ok {
+		t.Fatal("Expected router advertisements to be refused on a non /64 prefix")
+	}
+
+	config.FixedCIDRv6 = nil
+	if _, ok := config.Validate().(types.BadRequestError); !ok {
+		t.Fatal("Expected router advertisements to be refused without a prefix")
+	}
+}
@@ -0,0 +1,38 @@
+package bridge
+
+import (
+	"github.com/docker/libnetwork/iptables"
+)
+
+// programNetworkRule installs/removes the per network rules. Tests replace it
+// to capture the rules instead of programming them.
+var programNetworkRule = programChainRule
+
+// getNoForwardingRules returns the rules dropping the packets forwarded
+// between the bridge and the other host interfaces, in both directions.
+// Traffic among the bridge ports and to the host itself is not affected.
+func getNoForwardingRules(bridgeName string) []iptRule {
+	return []iptRule{
+		{table: iptables.Filter, chain: "FORWARD", args: []string{"-i", bridgeName, "!", "-o", bridgeName, "-j", "DROP"}},
+		{table: iptables.Filter, chain: "FORWARD", args: []string{"-o", bridgeName, "!", "-i", bridgeName, "-j", "DROP"}},
+	}
+}
+
+func setupNoForwarding(config *networkConfiguration, i *bridgeInterface) error {
+	return setNoForwarding(config, true)
+}
+
+// Install/Removes the rules keeping the network traffic on the host, regardless
+// of the global IP forwarding setting. They are inserted at the top of the chain
+// so that they precede the ACCEPT rules of the network.
+func setNoForwarding(config *networkConfiguration, enable bool) error {
+	if !config.DisableForwarding {
+		return nil
+	}
+	for _, rule := range getNoForwardingRules(config.BridgeName) {
+		if err := programNetworkRule(rule, "DROP FORWARDING", enable); err != nil {
+			return err
+		}
+	}
+	return nil
+}
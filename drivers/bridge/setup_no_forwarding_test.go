@@ -0,0 +1,61 @@
+package bridge
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/docker/libnetwork/iptables"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/netutils"
+)
+
+func TestNoForwardingRules(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	fw := newFirewallShim()
+	defer func(f func(iptRule, string, bool) error) { programNetworkRule = f }(programNetworkRule)
+	programNetworkRule = fw.program
+
+	d := newDriver()
+	config := &configuration{EnableIPForwarding: true}
+	if err := d.Config(map[string]interface{}{netlabel.GenericData: config}); err != nil {
+		t.Fatalf("Failed to setup driver config: %v", err)
+	}
+
+	netconfig := &networkConfiguration{BridgeName: "nofwd_br", AllowNonDefaultBridge: true, DisableForwarding: true}
+	if err := d.CreateNetwork("dummy", map[string]interface{}{netlabel.GenericData: netconfig}); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	// The rules are only programmed with iptables enabled, which the network
+	// creation above would have needed, so program them here
+	config.EnableIPTables = true
+	if err := setupNoForwarding(netconfig, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each rule is inserted at the top of the chain
+	fw.check(t, map[string][][]string{
+		chainKey(iptables.Filter, "FORWARD"): {
+			{"-o", "nofwd_br", "!", "-i", "nofwd_br", "-j", "DROP"},
+			{"-i", "nofwd_br", "!", "-o", "nofwd_br", "-j", "DROP"},
+		},
+	})
+
+	if procSetting := readCurrentIPForwardingSetting(t); !bytes.Equal(procSetting, []byte("1\n")) {
+		t.Fatalf("Expected the global IP forwarding to stay on, got %q", procSetting)
+	}
+
+	info, err := d.(*driver).NetworkOperInfo("dummy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info[netlabel.DisableForwarding] != true {
+		t.Fatalf("Expected the network to be reported as not forwarding: %v", info)
+	}
+
+	if err := d.DeleteNetwork("dummy"); err != nil {
+		t.Fatal(err)
+	}
+	fw.check(t, nil)
+}
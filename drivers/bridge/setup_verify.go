@@ -11,8 +11,8 @@ func setupVerifyAndReconcile(config *networkConfiguration, i *bridgeInterface) e
 		return err
 	}
 
-	// Verify that the bridge does have an IPv4 address.
-	if addrv4.IPNet == nil {
+	// Verify that the bridge does have an IPv4 address, unless it is IPv6 only.
+	if addrv4.IPNet == nil && !config.ipv6Configured() {
 		return &ErrNoIPAddr{}
 	}
 
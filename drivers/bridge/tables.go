@@ -0,0 +1,123 @@
+package bridge
+
+import (
+	"syscall"
+
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/vishvananda/netlink"
+)
+
+// neighStates names the neighbor entry states the way iproute2 does
+var neighStates = []struct {
+	state int
+	name  string
+}{
+	{netlink.NUD_PERMANENT, "permanent"},
+	{netlink.NUD_NOARP, "noarp"},
+	{netlink.NUD_REACHABLE, "reachable"},
+	{netlink.NUD_STALE, "stale"},
+	{netlink.NUD_DELAY, "delay"},
+	{netlink.NUD_PROBE, "probe"},
+	{netlink.NUD_FAILED, "failed"},
+	{netlink.NUD_INCOMPLETE, "incomplete"},
+}
+
+func neighStateName(state int) string {
+	for _, s := range neighStates {
+		if state&s.state != 0 {
+			return s.name
+		}
+	}
+	return "none"
+}
+
+// bridgeDevices returns the link index of the network bridge and the names of
+// the bridge and of its ports, keyed by link index
+func (n *bridgeNetwork) bridgeDevices() (int, map[int]string, error) {
+	n.Lock()
+	name := n.config.BridgeName
+	n.Unlock()
+
+	br, err := netlink.LinkByName(name)
+	if err != nil {
+		return 0, nil, err
+	}
+	index := br.Attrs().Index
+
+	links, err := netlink.LinkList()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	devices := map[int]string{index: name}
+	for _, l := range links {
+		if l.Attrs().MasterIndex == index {
+			devices[l.Attrs().Index] = l.Attrs().Name
+		}
+	}
+	return index, devices, nil
+}
+
+// NetworkFDB returns the forwarding database entries of the network bridge and of its ports
+func (d *driver) NetworkFDB(nid string) ([]driverapi.FDBEntry, error) {
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return nil, err
+	}
+
+	_, devices, err := n.bridgeDevices()
+	if err != nil {
+		return nil, err
+	}
+
+	neighs, err := netlink.NeighList(0, syscall.AF_BRIDGE)
+	if err != nil {
+		return nil, err
+	}
+
+	fdb := []driverapi.FDBEntry{}
+	for _, ne := range neighs {
+		iface, ok := devices[ne.LinkIndex]
+		if !ok || len(ne.HardwareAddr) == 0 {
+			continue
+		}
+		fdb = append(fdb, driverapi.FDBEntry{
+			MacAddress: ne.HardwareAddr,
+			Interface:  iface,
+			Permanent:  ne.State&netlink.NUD_PERMANENT != 0,
+		})
+	}
+	return fdb, nil
+}
+
+// NetworkNeighbors returns the host neighbor entries on the network bridge
+func (d *driver) NetworkNeighbors(nid string) ([]driverapi.NeighborEntry, error) {
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return nil, err
+	}
+
+	index, devices, err := n.bridgeDevices()
+	if err != nil {
+		return nil, err
+	}
+
+	neighs, err := netlink.NeighList(index, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, err
+	}
+
+	nl := []driverapi.NeighborEntry{}
+	for _, ne := range neighs {
+		if ne.IP == nil {
+			continue
+		}
+		nl = append(nl, driverapi.NeighborEntry{
+			IP:         ne.IP,
+			MacAddress: ne.HardwareAddr,
+			Interface:  devices[index],
+			State:      neighStateName(ne.State),
+		})
+	}
+	return nl, nil
+}
@@ -0,0 +1,124 @@
+package bridge
+
+import (
+	"net"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/vishvananda/netlink"
+)
+
+// VerifyNetwork compares the bridge device of the network, its addresses and
+// its ports, against the ones the driver configured
+func (d *driver) VerifyNetwork(nid string) ([]driverapi.Discrepancy, error) {
+	n, err := d.getNetwork(nid)
+	if err != nil {
+		return nil, err
+	}
+
+	n.Lock()
+	name := n.config.BridgeName
+	addrs := []*net.IPNet{}
+	for _, a := range []*net.IPNet{n.bridge.bridgeIPv4, n.bridge.bridgeIPv6} {
+		if a != nil {
+			addrs = append(addrs, a)
+		}
+	}
+	ports := []string{}
+	for _, ep := range n.endpoints {
+		// The pipe of a lazy endpoint is not created until its activation
+		if !ep.linkDeferred {
+			ports = append(ports, ep.hostIfName)
+		}
+	}
+	n.Unlock()
+
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return []driverapi.Discrepancy{{Interface: name, Resource: "bridge", Expected: "present", Actual: "none"}}, nil
+	}
+
+	var dl []driverapi.Discrepancy
+	if link.Type() != "bridge" {
+		dl = append(dl, driverapi.Discrepancy{Interface: name, Resource: "bridge", Expected: "bridge", Actual: link.Type()})
+	}
+
+	found, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addrs {
+		if !hasLinkAddress(found, a) {
+			dl = append(dl, driverapi.Discrepancy{Interface: name, Resource: "address", Expected: a.String(), Actual: "none"})
+		}
+	}
+
+	for _, p := range ports {
+		pl, err := netlink.LinkByName(p)
+		if err != nil {
+			dl = append(dl, driverapi.Discrepancy{Interface: p, Resource: "port", Expected: "present", Actual: "none"})
+			continue
+		}
+		if pl.Attrs().MasterIndex != link.Attrs().Index {
+			dl = append(dl, driverapi.Discrepancy{Interface: p, Resource: "port", Expected: "attached to " + name, Actual: "detached"})
+		}
+	}
+
+	return dl, nil
+}
+
+// hasLinkAddress tells whether addr is among the addresses found on a link. They
+// are compared in string form, as the kernel reports the IPv4 ones with 4 bytes
+// addresses and masks while the driver may hold 16 bytes ones.
+func hasLinkAddress(found []netlink.Addr, addr *net.IPNet) bool {
+	for _, a := range found {
+		if a.IPNet.String() == addr.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// restoreBridge recreates the bridge device of the network, along with its
+// addresses and its ports, when it was deleted behind the driver's back
+func (n *bridgeNetwork) restoreBridge() error {
+	n.Lock()
+	defer n.Unlock()
+
+	config := n.config
+	if _, err := netlink.LinkByName(config.BridgeName); err == nil {
+		return nil
+	}
+
+	logrus.Warnf("Bridge %s of network %s is missing, recreating it", config.BridgeName, n.id)
+
+	i := n.bridge
+	if err := setupDevice(config, i); err != nil {
+		return &BridgeRestoreError{Name: config.BridgeName, Err: err}
+	}
+	if i.bridgeIPv4 != nil {
+		if err := netlink.AddrAdd(i.Link, &netlink.Addr{IPNet: i.bridgeIPv4}); err != nil {
+			return &BridgeRestoreError{Name: config.BridgeName, Err: err}
+		}
+	}
+	if config.EnableIPv6 {
+		if err := setupBridgeIPv6(config, i); err != nil {
+			return &BridgeRestoreError{Name: config.BridgeName, Err: err}
+		}
+	}
+	if err := setupDeviceUp(config, i); err != nil {
+		return &BridgeRestoreError{Name: config.BridgeName, Err: err}
+	}
+
+	// The ports were detached along with the bridge deletion
+	for _, ep := range n.endpoints {
+		if ep.linkDeferred {
+			continue
+		}
+		if err := addToBridge(ep.hostIfName, config.BridgeName); err != nil {
+			return &BridgeRestoreError{Name: config.BridgeName, Err: err}
+		}
+	}
+
+	return nil
+}
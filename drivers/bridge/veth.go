@@ -0,0 +1,67 @@
+package bridge
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"github.com/docker/libnetwork/types"
+	"github.com/vishvananda/netlink"
+)
+
+// Number of candidate names tried for each end of an endpoint pipe
+const maxVethNameAttempts = 16
+
+// vethNameTable keeps track of the pipe interface names in use by the driver
+// endpoints. A name is reserved before its link is created and released only
+// once the link is gone, so that concurrent endpoint creations and deletions
+// never race on a name.
+type vethNameTable struct {
+	names map[string]struct{}
+	sync.Mutex
+}
+
+// vethName returns the attempt-th candidate name for the end of the endpoint
+// pipe. Candidates are derived from the endpoint id, which keeps them distinct
+// across endpoints and stable across attempts.
+func vethName(eid, end string, attempt int) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s/%s/%d", eid, end, attempt)))
+	return vethPrefix + hex.EncodeToString(sum[:])[:vethLen]
+}
+
+// reserve picks the first candidate name for the end of the endpoint pipe which
+// is neither reserved by the driver nor taken by an existing link.
+func (t *vethNameTable) reserve(eid, end string) (string, error) {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.names == nil {
+		t.names = make(map[string]struct{})
+	}
+
+	for i := 0; i < maxVethNameAttempts; i++ {
+		name := vethName(eid, end, i)
+		if _, ok := t.names[name]; ok {
+			continue
+		}
+		if _, err := netlink.LinkByName(name); err == nil {
+			continue
+		}
+		t.names[name] = struct{}{}
+		return name, nil
+	}
+
+	return "", types.InternalErrorf("could not generate a %s interface name for endpoint %s", end, eid)
+}
+
+// release makes the names available again. The caller guarantees their links
+// no longer exist.
+func (t *vethNameTable) release(names ...string) {
+	t.Lock()
+	defer t.Unlock()
+
+	for _, name := range names {
+		delete(t.names, name)
+	}
+}
@@ -0,0 +1,135 @@
+package bridge
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/netutils"
+)
+
+func TestVethNameReserve(t *testing.T) {
+	var table vethNameTable
+
+	name, err := table.reserve("ep1", "host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != vethName("ep1", "host", 0) {
+		t.Fatalf("Expected the first candidate name, got %s", name)
+	}
+	if len(name) != len(vethPrefix)+vethLen {
+		t.Fatalf("Unexpected name length: %s", name)
+	}
+
+	// A reserved name is not handed out again until released
+	again, err := table.reserve("ep1", "host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == name {
+		t.Fatalf("Name %s reserved twice", name)
+	}
+
+	table.release(name)
+	if n, err := table.reserve("ep1", "host"); err != nil || n != name {
+		t.Fatalf("Expected released name %s to be reserved again, got %s (%v)", name, n, err)
+	}
+
+	// All the candidates are taken
+	for i := 2; i < maxVethNameAttempts; i++ {
+		if _, err := table.reserve("ep1", "host"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := table.reserve("ep1", "host"); err == nil {
+		t.Fatal("Expected failure once all the candidate names are reserved")
+	}
+}
+
+func TestVethNameConcurrentReserve(t *testing.T) {
+	var (
+		table vethNameTable
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		seen  = map[string]string{}
+	)
+
+	errCh := make(chan error, 8*100)
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for r := 0; r < 100; r++ {
+				eid := fmt.Sprintf("ep%d", r%4)
+				name, err := table.reserve(eid, "host")
+				if err != nil {
+					errCh <- err
+					return
+				}
+				mu.Lock()
+				if owner, ok := seen[name]; ok {
+					errCh <- fmt.Errorf("name %s handed to worker %d while held by %s", name, w, owner)
+				}
+				seen[name] = fmt.Sprintf("worker %d", w)
+				mu.Unlock()
+
+				mu.Lock()
+				delete(seen, name)
+				mu.Unlock()
+				table.release(name)
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errCh)
+
+	for err := range errCh {
+		t.Error(err)
+	}
+}
+
+func TestEndpointChurn(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+	d := newDriver()
+
+	if err := d.Config(nil); err != nil {
+		t.Fatalf("Failed to setup driver config: %v", err)
+	}
+
+	netconfig := &networkConfiguration{BridgeName: "churn_br", AllowNonDefaultBridge: true}
+	if err := d.CreateNetwork("dummy", map[string]interface{}{netlabel.GenericData: netconfig}); err != nil {
+		t.Fatalf("Failed to create bridge: %v", err)
+	}
+
+	// The links live in the test namespace, the calling thread's only, so the
+	// churn is sequential. Reusing the endpoint ids exercises the names reuse.
+	for r := 0; r < 50; r++ {
+		eid := fmt.Sprintf("ep%d", r%4)
+		te := &testEndpoint{ifaces: []*testInterface{}}
+		if err := d.CreateEndpoint("dummy", eid, te, map[string]interface{}{}); err != nil {
+			t.Fatalf("round %d: create %s: %v", r, eid, err)
+		}
+		if r%2 == 1 {
+			// Keep two endpoints around to overlap the next creations
+			for _, id := range []string{fmt.Sprintf("ep%d", (r-1)%4), eid} {
+				if err := d.DeleteEndpoint("dummy", id); err != nil {
+					t.Fatalf("round %d: delete %s: %v", r, id, err)
+				}
+			}
+		}
+	}
+
+	dr := d.(*driver)
+	dr.veths.Lock()
+	left := len(dr.veths.names)
+	dr.veths.Unlock()
+	if left != 0 {
+		t.Fatalf("Expected all the interface names to be released, %d still reserved", left)
+	}
+
+	if err := d.DeleteNetwork("dummy"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,57 @@
+package bridge
+
+import (
+	"net"
+
+	"github.com/docker/libnetwork/iptables"
+	"github.com/vishvananda/netlink"
+)
+
+// getVIPRules returns the nat rules handing the bridge virtual address over to
+// the endpoint: the traffic to the address, forwarded or locally generated, is
+// redirected to the endpoint address, and the traffic the endpoint sends out of
+// the bridge is sourced from the virtual address.
+func getVIPRules(bridgeName string, ip, vip net.IP) []iptRule {
+	nat := []string{"-t", string(iptables.Nat)}
+	dnat := []string{"-d", vip.String(), "-j", "DNAT", "--to-destination", ip.String()}
+	return []iptRule{
+		{table: iptables.Nat, chain: "PREROUTING", preArgs: nat, args: dnat},
+		{table: iptables.Nat, chain: "OUTPUT", preArgs: nat, args: dnat},
+		{table: iptables.Nat, chain: "POSTROUTING", preArgs: nat,
+			args: []string{"-s", ip.String(), "!", "-o", bridgeName, "-j", "SNAT", "--to-source", vip.String()}},
+	}
+}
+
+// checkBridgeVIP verifies the virtual address is an alias configured on the
+// bridge, other than the bridge own address and the network gateway.
+func checkBridgeVIP(i *bridgeInterface, vip net.IP) error {
+	if (i.bridgeIPv4 != nil && i.bridgeIPv4.IP.Equal(vip)) || i.gatewayIPv4.Equal(vip) {
+		return ErrInvalidVIP(vip.String())
+	}
+	if i.Link == nil {
+		return ErrInvalidVIP(vip.String())
+	}
+	addrs, err := netlink.AddrList(i.Link, netlink.FAMILY_V4)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if addr.IP.Equal(vip) {
+			return nil
+		}
+	}
+	return ErrInvalidVIP(vip.String())
+}
+
+// Install/Removes the rules making the endpoint own its virtual address
+func setEndpointVIP(config *networkConfiguration, ep *bridgeEndpoint, enable bool) error {
+	if ep.config == nil || ep.config.VIP == nil || ep.addr == nil {
+		return nil
+	}
+	for _, rule := range getVIPRules(config.BridgeName, ep.addr.IP, ep.config.VIP) {
+		if err := programEndpointRule(rule, "VIP", enable); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package bridge
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/netutils"
+	"github.com/vishvananda/netlink"
+)
+
+func TestEndpointVIP(t *testing.T) {
+	type natOp struct {
+		chain  string
+		args   []string
+		insert bool
+	}
+	var nat []natOp
+	defer func(f func(iptRule, string, bool) error) { programEndpointRule = f }(programEndpointRule)
+	programEndpointRule = func(rule iptRule, descr string, insert bool) error {
+		nat = append(nat, natOp{chain: rule.chain, args: rule.args, insert: insert})
+		return nil
+	}
+
+	epConfig, err := parseEndpointOptions(map[string]interface{}{netlabel.VIP: net.ParseIP("172.18.255.10")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &networkConfiguration{BridgeName: "br-vip"}
+	ep := &bridgeEndpoint{
+		config: epConfig,
+		addr:   &net.IPNet{IP: net.ParseIP("172.18.0.2"), Mask: net.CIDRMask(16, 32)},
+	}
+
+	dnat := []string{"-d", "172.18.255.10", "-j", "DNAT", "--to-destination", "172.18.0.2"}
+	snat := []string{"-s", "172.18.0.2", "!", "-o", "br-vip", "-j", "SNAT", "--to-source", "172.18.255.10"}
+
+	if err := setEndpointVIP(config, ep, true); err != nil {
+		t.Fatal(err)
+	}
+	expected := []natOp{
+		{chain: "PREROUTING", args: dnat, insert: true},
+		{chain: "OUTPUT", args: dnat, insert: true},
+		{chain: "POSTROUTING", args: snat, insert: true},
+	}
+	if !reflect.DeepEqual(nat, expected) {
+		t.Fatalf("Unexpected rules programmed on join.\nExpected: %v\nGot:      %v", expected, nat)
+	}
+
+	nat = nil
+	if err := setEndpointVIP(config, ep, false); err != nil {
+		t.Fatal(err)
+	}
+	for i := range expected {
+		expected[i].insert = false
+	}
+	if !reflect.DeepEqual(nat, expected) {
+		t.Fatalf("Unexpected rules removed on leave.\nExpected: %v\nGot:      %v", expected, nat)
+	}
+
+	if _, err := parseEndpointOptions(map[string]interface{}{netlabel.VIP: net.ParseIP("fd00::10")}); err == nil {
+		t.Fatal("Expected an IPv6 virtual address to be rejected")
+	}
+}
+
+func TestCheckBridgeVIP(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	config := &networkConfiguration{BridgeName: DefaultBridgeName}
+	br := &bridgeInterface{}
+	if err := setupDevice(config, br); err != nil {
+		t.Fatal(err)
+	}
+	br.bridgeIPv4 = &net.IPNet{IP: net.ParseIP("172.18.0.1"), Mask: net.CIDRMask(16, 32)}
+	br.gatewayIPv4 = br.bridgeIPv4.IP
+	if err := netlink.AddrAdd(br.Link, &netlink.Addr{IPNet: br.bridgeIPv4}); err != nil {
+		t.Fatal(err)
+	}
+
+	vip := net.ParseIP("172.18.255.10")
+	if err := checkBridgeVIP(br, vip); err == nil {
+		t.Fatal("Expected a virtual address missing on the bridge to be rejected")
+	} else if _, ok := err.(ErrInvalidVIP); !ok {
+		t.Fatalf("Did not fail with expected error. Actual error: %v", err)
+	}
+
+	if err := netlink.AddrAdd(br.Link, &netlink.Addr{IPNet: &net.IPNet{IP: vip, Mask: net.CIDRMask(32, 32)}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkBridgeVIP(br, vip); err != nil {
+		t.Fatalf("Expected the bridge alias to be accepted: %v", err)
+	}
+
+	if err := checkBridgeVIP(br, br.gatewayIPv4); err == nil {
+		t.Fatal("Expected the bridge gateway to be rejected as virtual address")
+	}
+}
@@ -3,6 +3,7 @@ package overlay
 import (
 	"fmt"
 
+	"github.com/Sirupsen/logrus"
 	"github.com/docker/libnetwork/driverapi"
 	"github.com/vishvananda/netlink"
 )
@@ -40,6 +41,15 @@ func (d *driver) Join(nid, eid string, sboxKey string, jinfo driverapi.JoinInfo,
 		return fmt.Errorf("could not add veth pair inside the network sandbox: %v", err)
 	}
 
+	if d.neighSuppress {
+		if err := enableNeighSuppress(sbox, name1); err != nil {
+			return err
+		}
+		if err := setSuppressNeighbor(sbox, ep.addr.IP, ep.mac, true); err != nil {
+			return err
+		}
+	}
+
 	veth, err := netlink.LinkByName(name2)
 	if err != nil {
 		return fmt.Errorf("could not find link by name %s: %v", name2, err)
@@ -86,6 +96,14 @@ func (d *driver) Leave(nid, eid string) error {
 		return fmt.Errorf("could not find network with id %s", nid)
 	}
 
+	if ep := n.endpoint(eid); ep != nil && d.neighSuppress {
+		if sbox := n.sandbox(); sbox != nil {
+			if err := setSuppressNeighbor(sbox, ep.addr.IP, ep.mac, false); err != nil {
+				logrus.Warnf("%v", err)
+			}
+		}
+	}
+
 	d.notifyCh <- ovNotify{
 		action: "leave",
 		nid:    nid,
@@ -0,0 +1,110 @@
+package overlay
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/docker/libnetwork/osl"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+)
+
+// iflaBrportNeighSuppress is the IFLA_BRPORT_NEIGH_SUPPRESS bridge port
+// attribute, which the vendored netlink package does not know about
+const iflaBrportNeighSuppress = 32
+
+// setNeighSuppress toggles neighbor suppression on the bridge port: the bridge
+// answers the ARP requests received on the port from its own neighbor table,
+// instead of flooding them. It applies to the network namespace the calling
+// thread is in.
+func setNeighSuppress(link netlink.Link, on bool) error {
+	req := nl.NewNetlinkRequest(syscall.RTM_SETLINK, syscall.NLM_F_ACK)
+
+	msg := nl.NewIfInfomsg(syscall.AF_BRIDGE)
+	msg.Index = int32(link.Attrs().Index)
+	req.AddData(msg)
+
+	var v uint8
+	if on {
+		v = 1
+	}
+	br := nl.NewRtAttr(syscall.IFLA_PROTINFO|syscall.NLA_F_NESTED, nil)
+	nl.NewRtAttrChild(br, iflaBrportNeighSuppress, nl.Uint8Attr(v))
+	req.AddData(br)
+
+	_, err := req.Execute(syscall.NETLINK_ROUTE, 0)
+	return err
+}
+
+// sandboxLinkName returns the name, inside the network sandbox, of the interface
+// moved there with the passed name, or of the bridge if srcName is empty
+func sandboxLinkName(sbox osl.Sandbox, srcName string) string {
+	for _, i := range sbox.Info().Interfaces() {
+		if (srcName == "" && i.Bridge()) || (srcName != "" && i.SrcName() == srcName) {
+			return i.DstName()
+		}
+	}
+	return ""
+}
+
+// enableNeighSuppress turns neighbor suppression on for the bridge port, in the
+// network sandbox, which was moved there with the passed name
+func enableNeighSuppress(sbox osl.Sandbox, srcName string) error {
+	name := sandboxLinkName(sbox, srcName)
+	if name == "" {
+		return fmt.Errorf("could not find interface %s in the network sandbox", srcName)
+	}
+
+	var err error
+	if nErr := sbox.InvokeFunc(func() {
+		var link netlink.Link
+		if link, err = netlink.LinkByName(name); err != nil {
+			return
+		}
+		err = setNeighSuppress(link, true)
+	}); nErr != nil {
+		return nErr
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not enable neighbor suppression on %s: %v", name, err)
+	}
+	return nil
+}
+
+// setSuppressNeighbor adds, or deletes, the static neighbor entry of the peer
+// on the network sandbox bridge, which answers the suppressed ARP requests
+func setSuppressNeighbor(sbox osl.Sandbox, peerIP net.IP, peerMac net.HardwareAddr, add bool) error {
+	name := sandboxLinkName(sbox, "")
+	if name == "" {
+		return fmt.Errorf("could not find the bridge in the network sandbox")
+	}
+
+	var err error
+	if nErr := sbox.InvokeFunc(func() {
+		var link netlink.Link
+		if link, err = netlink.LinkByName(name); err != nil {
+			return
+		}
+		nh := &netlink.Neigh{
+			LinkIndex:    link.Attrs().Index,
+			Family:       netlink.FAMILY_V4,
+			State:        netlink.NUD_PERMANENT,
+			IP:           peerIP,
+			HardwareAddr: peerMac,
+		}
+		if add {
+			err = netlink.NeighSet(nh)
+		} else {
+			err = netlink.NeighDel(nh)
+		}
+	}); nErr != nil {
+		return nErr
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not program the suppression neighbor entry for %s: %v", peerIP, err)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package overlay
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/docker/libnetwork/netutils"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+)
+
+// getNeighSuppress returns the neigh_suppress flag of the bridge port
+func getNeighSuppress(link netlink.Link) (bool, error) {
+	req := nl.NewNetlinkRequest(syscall.RTM_GETLINK, syscall.NLM_F_DUMP)
+	req.AddData(nl.NewIfInfomsg(syscall.AF_BRIDGE))
+
+	msgs, err := req.Execute(syscall.NETLINK_ROUTE, 0)
+	if err != nil {
+		return false, err
+	}
+
+	for _, m := range msgs {
+		ans := nl.DeserializeIfInfomsg(m)
+		if int(ans.Index) != link.Attrs().Index {
+			continue
+		}
+		attrs, err := nl.ParseRouteAttr(m[ans.Len():])
+		if err != nil {
+			return false, err
+		}
+		for _, attr := range attrs {
+			if attr.Attr.Type != syscall.IFLA_PROTINFO|syscall.NLA_F_NESTED {
+				continue
+			}
+			infos, err := nl.ParseRouteAttr(attr.Value)
+			if err != nil {
+				return false, err
+			}
+			for _, info := range infos {
+				if info.Attr.Type == iflaBrportNeighSuppress {
+					return info.Value[0] != 0, nil
+				}
+			}
+		}
+	}
+
+	return false, nil
+}
+
+func TestNeighSuppress(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	d := &driver{
+		networks: networkTable{},
+		peerDb: peerNetworkMap{
+			mp: map[string]*peerMap{},
+		},
+		neighSuppress: true,
+	}
+
+	n := &network{
+		id:        "testnetwork",
+		driver:    d,
+		endpoints: endpointTable{},
+		once:      &sync.Once{},
+	}
+	n.setVxlanID(vxlanIDStart)
+	d.addNetwork(n)
+
+	peerIP := net.ParseIP("172.21.0.2")
+	peerMac, _ := net.ParseMAC("02:42:ac:15:00:02")
+	vtep := net.ParseIP("192.168.56.2")
+	d.peerDbAdd(n.id, "testendpoint", peerIP, peerMac, vtep, false)
+
+	if err := n.initSandbox(); err != nil {
+		t.Fatal(err)
+	}
+	defer n.destroySandbox()
+
+	sbox := n.sandbox()
+	var (
+		neighFound, fdbFound, suppress bool
+		err                            error
+	)
+	if nErr := sbox.InvokeFunc(func() {
+		var br, vxlan netlink.Link
+		if br, err = netlink.LinkByName(sandboxLinkName(sbox, "")); err != nil {
+			return
+		}
+		if vxlan, err = netlink.LinkByName(sandboxLinkName(sbox, n.vxlanName)); err != nil {
+			return
+		}
+
+		var neighs []netlink.Neigh
+		if neighs, err = netlink.NeighList(br.Attrs().Index, netlink.FAMILY_V4); err != nil {
+			return
+		}
+		for _, nh := range neighs {
+			if nh.IP.Equal(peerIP) && bytes.Equal(nh.HardwareAddr, peerMac) && nh.State == netlink.NUD_PERMANENT {
+				neighFound = true
+			}
+		}
+
+		if neighs, err = netlink.NeighList(vxlan.Attrs().Index, syscall.AF_BRIDGE); err != nil {
+			return
+		}
+		for _, nh := range neighs {
+			if nh.IP.Equal(vtep) && bytes.Equal(nh.HardwareAddr, peerMac) {
+				fdbFound = true
+			}
+		}
+
+		suppress, err = getNeighSuppress(vxlan)
+	}); nErr != nil {
+		t.Fatal(nErr)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !neighFound {
+		t.Fatalf("Static neighbor entry for %s not found on the bridge", peerIP)
+	}
+	if !fdbFound {
+		t.Fatalf("Fdb entry for %s not found on the vxlan interface", peerMac)
+	}
+	if !suppress {
+		t.Fatal("Neighbor suppression is not enabled on the vxlan bridge port")
+	}
+
+	if err := d.peerDelete(n.id, "testendpoint", peerIP, peerMac, vtep, true); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/docker/libnetwork/datastore"
 	"github.com/docker/libnetwork/ipallocator"
 	"github.com/docker/libnetwork/osl"
+	"github.com/docker/libnetwork/types"
 	"github.com/vishvananda/netlink"
 	"github.com/vishvananda/netlink/nl"
 )
@@ -37,7 +38,6 @@ type network struct {
 
 func (d *driver) CreateNetwork(id string, option map[string]interface{}) error {
 	logrus.Debugf("create network call recieved at overlay driver")
-	logrus.WarnF("create network call recieved at overlay driver")
 	if id == "" {
 		return fmt.Errorf("invalid network id")
 	}
@@ -67,7 +67,7 @@ func (d *driver) DeleteNetwork(nid string) error {
 
 	n := d.network(nid)
 	if n == nil {
-		return fmt.Errorf("could not find network with id %s", nid)
+		return types.InternalMaskableErrorf("could not find network with id %s", nid)
 	}
 
 	d.deleteNetwork(nid)
@@ -167,6 +167,13 @@ func (n *network) initSandbox() error {
 
 	n.vxlanName = vxlanName
 
+	// Remote hosts answer the ARP requests for their endpoints themselves
+	if n.driver.neighSuppress {
+		if err := enableNeighSuppress(sbox, vxlanName); err != nil {
+			return err
+		}
+	}
+
 	n.setSandbox(sbox)
 
 	n.driver.peerDbUpdateSandbox(n.id)
@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"net"
+	"strconv"
 	"sync"
 
 	"github.com/docker/libnetwork/config"
@@ -24,17 +25,18 @@ const (
 )
 
 type driver struct {
-	eventCh      chan serf.Event
-	notifyCh     chan ovNotify
-	exitCh       chan chan struct{}
-	ifaceName    string
-	neighIP      string
-	peerDb       peerNetworkMap
-	serfInstance *serf.Serf
-	networks     networkTable
-	store        datastore.DataStore
-	ipAllocator  *idm.Idm
-	vxlanIdm     *idm.Idm
+	eventCh       chan serf.Event
+	notifyCh      chan ovNotify
+	exitCh        chan chan struct{}
+	ifaceName     string
+	neighIP       string
+	neighSuppress bool
+	peerDb        peerNetworkMap
+	serfInstance  *serf.Serf
+	networks      networkTable
+	store         datastore.DataStore
+	ipAllocator   *idm.Idm
+	vxlanIdm      *idm.Idm
 	sync.Once
 	sync.Mutex
 }
@@ -76,7 +78,7 @@ func Init(dc driverapi.DriverCallback) error {
 	return dc.RegisterDriver(networkType, &driver{
 		networks: networkTable{},
 		peerDb: peerNetworkMap{
-			mp: map[string]peerMap{},
+			mp: map[string]*peerMap{},
 		},
 	}, c)
 }
@@ -109,6 +111,18 @@ func (d *driver) Config(option map[string]interface{}) error {
 			d.neighIP = neighIP.(string)
 		}
 
+		if suppress, ok := option[netlabel.OverlayNeighSuppress]; ok {
+			switch v := suppress.(type) {
+			case bool:
+				d.neighSuppress = v
+			case string:
+				if d.neighSuppress, err = strconv.ParseBool(v); err != nil {
+					err = fmt.Errorf("invalid value %q for %s: %v", v, netlabel.OverlayNeighSuppress, err)
+					return
+				}
+			}
+		}
+
 		provider, provOk := option[netlabel.KVProvider]
 		provURL, urlOk := option[netlabel.KVProviderURL]
 
@@ -155,3 +169,9 @@ func (d *driver) Config(option map[string]interface{}) error {
 func (d *driver) Type() string {
 	return networkType
 }
+
+// SupportsPoolAddresses tells that the endpoints take the address of the
+// interface they are created with, when passed one
+func (d *driver) SupportsPoolAddresses() bool {
+	return true
+}
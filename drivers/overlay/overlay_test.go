@@ -1,9 +1,11 @@
 package overlay
 
 import (
+	"os"
 	"testing"
 	"time"
 
+	"github.com/docker/docker/pkg/reexec"
 	"github.com/docker/libnetwork/driverapi"
 )
 
@@ -14,6 +16,13 @@ type driverTester struct {
 
 const testNetworkType = "overlay"
 
+func TestMain(m *testing.M) {
+	if reexec.Init() {
+		return
+	}
+	os.Exit(m.Run())
+}
+
 func setupDriver(t *testing.T) *driverTester {
 	dt := &driverTester{t: t}
 	if err := Init(dt); err != nil {
@@ -25,7 +25,7 @@ type peerMap struct {
 }
 
 type peerNetworkMap struct {
-	mp map[string]peerMap
+	mp map[string]*peerMap
 	sync.Mutex
 }
 
@@ -119,7 +119,7 @@ func (d *driver) peerDbAdd(nid, eid string, peerIP net.IP,
 	d.peerDb.Lock()
 	pMap, ok := d.peerDb.mp[nid]
 	if !ok {
-		d.peerDb.mp[nid] = peerMap{
+		d.peerDb.mp[nid] = &peerMap{
 			mp: make(map[string]peerEntry),
 		}
 
@@ -244,6 +244,12 @@ func (d *driver) peerAdd(nid, eid string, peerIP net.IP,
 		return fmt.Errorf("could not add fdb entry into the sandbox: %v", err)
 	}
 
+	if d.neighSuppress {
+		if err := setSuppressNeighbor(sbox, peerIP, peerMac, true); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -268,6 +274,12 @@ func (d *driver) peerDelete(nid, eid string, peerIP net.IP,
 		return nil
 	}
 
+	if d.neighSuppress {
+		if err := setSuppressNeighbor(sbox, peerIP, peerMac, false); err != nil {
+			return err
+		}
+	}
+
 	// Delete fdb entry to the bridge for the peer mac
 	if err := sbox.DeleteNeighbor(vtep, peerMac); err != nil {
 		return fmt.Errorf("could not delete fdb entry into the sandbox: %v", err)
@@ -9,6 +9,10 @@ import "net"
 // Response is the basic response structure used in all responses.
 type Response struct {
 	Err string
+	// ErrCode optionally qualifies Err with one of the driverapi error codes.
+	ErrCode string `json:",omitempty"`
+	// ErrRetryable tells whether the failed request may succeed later.
+	ErrRetryable bool `json:",omitempty"`
 }
 
 // GetError returns the error from the response, if any.
@@ -16,6 +20,16 @@ func (r *Response) GetError() string {
 	return r.Err
 }
 
+// GetErrorCode returns the code qualifying the error, if any.
+func (r *Response) GetErrorCode() string {
+	return r.ErrCode
+}
+
+// IsRetryable returns whether the error may go away by retrying the request.
+func (r *Response) IsRetryable() bool {
+	return r.ErrRetryable
+}
+
 // CreateNetworkRequest requests a new network.
 type CreateNetworkRequest struct {
 	// A network ID that remote plugins are expected to store for future
@@ -97,6 +111,17 @@ type EndpointInfoResponse struct {
 	Value map[string]interface{}
 }
 
+// NetworkInfoRequest retrieves information about the network from the network driver.
+type NetworkInfoRequest struct {
+	NetworkID string
+}
+
+// NetworkInfoResponse is the response to a NetworkInfoRequest.
+type NetworkInfoResponse struct {
+	Response
+	Value map[string]interface{}
+}
+
 // JoinRequest describes the API for joining an endpoint to a sandbox.
 type JoinRequest struct {
 	NetworkID  string
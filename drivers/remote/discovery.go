@@ -0,0 +1,89 @@
+package remote
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/pkg/plugins"
+	"github.com/docker/libnetwork/driverapi"
+)
+
+// pluginSpec is the content of a .json plugin spec file
+type pluginSpec struct {
+	Name string
+	Addr string
+}
+
+// Discover looks the named plugin up in the passed directories, in order, and
+// registers it as a remote driver once activated. It returns plugins.ErrNotFound
+// if none of the directories holds the plugin.
+func Discover(dc driverapi.DriverCallback, dirs []string, name string) error {
+	for _, dir := range dirs {
+		addr, err := lookupPlugin(dir, name)
+		if err == plugins.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		return activate(dc, name, addr)
+	}
+	return plugins.ErrNotFound
+}
+
+// lookupPlugin returns the address of the named plugin in dir, as given by a
+// .spec or .json spec file, or by a unix socket.
+func lookupPlugin(dir, name string) (string, error) {
+	path := filepath.Join(dir, name)
+
+	if content, err := ioutil.ReadFile(path + ".spec"); err == nil {
+		return parsePluginAddr(strings.TrimSpace(string(content)))
+	}
+
+	if content, err := ioutil.ReadFile(path + ".json"); err == nil {
+		var spec pluginSpec
+		if err := json.Unmarshal(content, &spec); err != nil {
+			return "", fmt.Errorf("invalid plugin spec %s.json: %v", path, err)
+		}
+		return parsePluginAddr(spec.Addr)
+	}
+
+	if fi, err := os.Stat(path + ".sock"); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		return "unix://" + path + ".sock", nil
+	}
+
+	return "", plugins.ErrNotFound
+}
+
+func parsePluginAddr(addr string) (string, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", err
+	}
+	if len(u.Scheme) == 0 {
+		return "", fmt.Errorf("unknown protocol in plugin address %q", addr)
+	}
+	return addr, nil
+}
+
+func activate(dc driverapi.DriverCallback, name, addr string) error {
+	client := plugins.NewClient(addr)
+
+	m := &plugins.Manifest{}
+	if err := client.Call("Plugin.Activate", nil, m); err != nil {
+		return err
+	}
+
+	for _, iface := range m.Implements {
+		if iface == driverapi.NetworkPluginEndpointType {
+			return registerDriver(dc, name, client)
+		}
+	}
+
+	return plugins.ErrNotImplements
+}
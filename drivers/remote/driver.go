@@ -3,55 +3,233 @@ package remote
 import (
 	"fmt"
 	"net"
+	"strings"
+	"sync"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/docker/pkg/plugins"
 	"github.com/docker/libnetwork/driverapi"
 	"github.com/docker/libnetwork/drivers/remote/api"
+	"github.com/docker/libnetwork/netlabel"
 	"github.com/docker/libnetwork/types"
 )
 
 type driver struct {
 	endpoint    *plugins.Client
 	networkType string
+	timeout     time.Duration
+	timeouts    map[string]time.Duration
+	stopCh      chan struct{}
+	stopOnce    sync.Once
+	sync.Mutex
 }
 
+// probeInterval is how often the plugins are probed for a restart. Tests
+// shorten it.
+var probeInterval = 10 * time.Second
+
+// probeTimeout is how long the plugins are given to answer a probe. Tests
+// shorten it.
+var probeTimeout = 5 * time.Second
+
+// defaultCallTimeout bounds the plugin calls with no timeout configured
+const defaultCallTimeout = 60 * time.Second
+
+// timeoutLabel is the suffix of the driver labels configuring the timeout of
+// the plugin calls: <driver prefix>.<plugin>.timeout for all of them, and
+// <driver prefix>.<plugin>.timeout.<method>, like timeout.CreateEndpoint, for
+// a single one.
+const timeoutLabel = "timeout"
+
 type maybeError interface {
 	GetError() string
+	GetErrorCode() string
+	IsRetryable() bool
 }
 
 func newDriver(name string, client *plugins.Client) driverapi.Driver {
-	return &driver{networkType: name, endpoint: client}
+	return &driver{networkType: name, endpoint: client, stopCh: make(chan struct{})}
 }
 
 // Init makes sure a remote driver is registered when a network driver
 // plugin is activated.
 func Init(dc driverapi.DriverCallback) error {
 	plugins.Handle(driverapi.NetworkPluginEndpointType, func(name string, client *plugins.Client) {
-		c := driverapi.Capability{
-			Scope: driverapi.GlobalScope,
-		}
-		if err := dc.RegisterDriver(name, newDriver(name, client), c); err != nil {
+		if err := registerDriver(dc, name, client); err != nil {
 			log.Errorf("error registering driver for %s due to %v", name, err)
 		}
 	})
 	return nil
 }
 
-// Config is not implemented for remote drivers, since it is assumed
-// to be supplied to the remote process out-of-band (e.g., as command
-// line arguments).
+func registerDriver(dc driverapi.DriverCallback, name string, client *plugins.Client) error {
+	c := driverapi.Capability{
+		Scope: driverapi.GlobalScope,
+	}
+	d := newDriver(name, client)
+	if err := dc.RegisterDriver(name, d, c); err != nil {
+		return err
+	}
+	if rc, ok := dc.(driverapi.DriverResyncCallback); ok {
+		go d.(*driver).watchPlugin(rc)
+	}
+	return nil
+}
+
+// Teardown stops watching the plugin
+func (d *driver) Teardown() {
+	d.stopOnce.Do(func() { close(d.stopCh) })
+}
+
+// probePlugin calls the plugin activation through the plugin client, which
+// the plugin was activated with
+func (d *driver) probePlugin() <-chan error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- d.endpoint.Call("Plugin.Activate", nil, &plugins.Manifest{})
+	}()
+	return errCh
+}
+
+// watchPlugin probes the plugin and has its networks replayed to it when it
+// answers again after failing a probe, as a restarted plugin lost them. A
+// probe not answered within probeTimeout fails, and is waited for by the next
+// round rather than started over. The plugin client retries the connections
+// meanwhile, so the restarts shorter than probeTimeout go unnoticed. The
+// plugin is watched until the driver is torn down.
+func (d *driver) watchPlugin(rc driverapi.DriverResyncCallback) {
+	var (
+		down    bool
+		pending <-chan error
+	)
+	for {
+		select {
+		case <-d.stopCh:
+			return
+		case <-time.After(probeInterval):
+		}
+
+		if pending == nil {
+			pending = d.probePlugin()
+		}
+		var err error
+		select {
+		case err = <-pending:
+			pending = nil
+		case <-time.After(probeTimeout):
+			err = fmt.Errorf("no answer within %v", probeTimeout)
+		}
+
+		switch {
+		case err != nil:
+			if !down {
+				log.Warnf("remote driver %s failed its probe: %v", d.networkType, err)
+			}
+			down = true
+		case down:
+			down = false
+			log.Infof("remote driver %s is back, replaying its networks", d.networkType)
+			if err := rc.ResyncDriver(d.networkType); err != nil {
+				log.Warnf("failed to replay the networks of remote driver %s: %v", d.networkType, err)
+			}
+		}
+	}
+}
+
+// Config only takes the timeouts of the plugin calls. The configuration of the
+// remote process is assumed to be supplied out-of-band (e.g., as command line
+// arguments).
 func (d *driver) Config(option map[string]interface{}) error {
-	return &driverapi.ErrNotImplemented{}
+	prefix := netlabel.DriverPrefix + "." + d.networkType + "." + timeoutLabel
+
+	var (
+		timeout  time.Duration
+		timeouts = make(map[string]time.Duration)
+	)
+	for k, v := range option {
+		if k != prefix && !strings.HasPrefix(k, prefix+".") {
+			continue
+		}
+		s, ok := v.(string)
+		if !ok {
+			return types.BadRequestErrorf("invalid timeout %v for remote driver %s", v, d.networkType)
+		}
+		t, err := time.ParseDuration(s)
+		if err != nil || t <= 0 {
+			return types.BadRequestErrorf("invalid timeout %q for remote driver %s", s, d.networkType)
+		}
+		if k == prefix {
+			timeout = t
+		} else {
+			timeouts[strings.TrimPrefix(k, prefix+".")] = t
+		}
+	}
+
+	d.Lock()
+	d.timeout = timeout
+	d.timeouts = timeouts
+	d.Unlock()
+
+	return nil
+}
+
+// callTimeout returns the time the plugin is given to complete the method
+func (d *driver) callTimeout(methodName string) time.Duration {
+	d.Lock()
+	defer d.Unlock()
+
+	if t, ok := d.timeouts[methodName]; ok {
+		return t
+	}
+	if d.timeout != 0 {
+		return d.timeout
+	}
+	return defaultCallTimeout
 }
 
 func (d *driver) call(methodName string, arg interface{}, retVal maybeError) error {
+	return d.callWithUndo(methodName, arg, retVal, nil)
+}
+
+// callWithUndo calls the plugin method, failing with a timeout error if the
+// plugin does not answer in time. Should the plugin complete the call past the
+// deadline, undo is run to drop the state the call left behind.
+func (d *driver) callWithUndo(methodName string, arg interface{}, retVal maybeError, undo func() error) error {
 	method := driverapi.NetworkPluginEndpointType + "." + methodName
-	err := d.endpoint.Call(method, arg, retVal)
-	if err != nil {
-		return err
+	timeout := d.callTimeout(methodName)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- d.endpoint.Call(method, arg, retVal)
+	}()
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			return err
+		}
+	case <-time.After(timeout):
+		go func() {
+			if err := <-errCh; err != nil || retVal.GetError() != "" || undo == nil {
+				return
+			}
+			log.Warnf("remote driver %s completed %s past its deadline, rolling it back", d.networkType, methodName)
+			if err := undo(); err != nil {
+				log.Warnf("remote driver %s failed to roll back %s: %v", d.networkType, methodName, err)
+			}
+		}()
+		return types.TimeoutErrorf("remote driver %s did not complete %s within %v", d.networkType, methodName, timeout)
 	}
+
 	if e := retVal.GetError(); e != "" {
+		if code := retVal.GetErrorCode(); code != "" {
+			return &driverapi.DriverError{
+				Code:      code,
+				Message:   fmt.Sprintf("remote: %s", e),
+				Retryable: retVal.IsRetryable(),
+			}
+		}
 		return fmt.Errorf("remote: %s", e)
 	}
 	return nil
@@ -93,7 +271,7 @@ func (d *driver) CreateEndpoint(nid, eid string, epInfo driverapi.EndpointInfo,
 		Options:    epOptions,
 	}
 	var res api.CreateEndpointResponse
-	if err := d.call("CreateEndpoint", create, &res); err != nil {
+	if err := d.callWithUndo("CreateEndpoint", create, &res, func() error { return d.DeleteEndpoint(nid, eid) }); err != nil {
 		return err
 	}
 
@@ -149,6 +327,18 @@ func (d *driver) EndpointOperInfo(nid, eid string) (map[string]interface{}, erro
 	return res.Value, nil
 }
 
+// NetworkOperInfo retrieves the operational data the plugin reports for the network
+func (d *driver) NetworkOperInfo(nid string) (map[string]interface{}, error) {
+	info := &api.NetworkInfoRequest{
+		NetworkID: nid,
+	}
+	var res api.NetworkInfoResponse
+	if err := d.call("NetworkOperInfo", info, &res); err != nil {
+		return nil, err
+	}
+	return res.Value, nil
+}
+
 // Join method is invoked when a Sandbox is attached to an endpoint.
 func (d *driver) Join(nid, eid string, sboxKey string, jinfo driverapi.JoinInfo, options map[string]interface{}) error {
 	join := &api.JoinRequest{
@@ -161,7 +351,7 @@ func (d *driver) Join(nid, eid string, sboxKey string, jinfo driverapi.JoinInfo,
 		res api.JoinResponse
 		err error
 	)
-	if err = d.call("Join", join, &res); err != nil {
+	if err = d.callWithUndo("Join", join, &res, func() error { return d.Leave(nid, eid) }); err != nil {
 		return err
 	}
 
@@ -225,6 +415,12 @@ func (d *driver) Type() string {
 	return d.networkType
 }
 
+// SupportsPoolAddresses tells that the plugins are passed the endpoint
+// interfaces libnetwork populated, which they must not replace
+func (d *driver) SupportsPoolAddresses() bool {
+	return true
+}
+
 func parseStaticRoutes(r api.JoinResponse) ([]*types.StaticRoute, error) {
 	var routes = make([]*types.StaticRoute, len(r.StaticRoutes))
 	for i, inRoute := range r.StaticRoutes {
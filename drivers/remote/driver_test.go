@@ -3,13 +3,17 @@ package remote
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/docker/docker/pkg/plugins"
 	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/netlabel"
 	_ "github.com/docker/libnetwork/netutils"
 	"github.com/docker/libnetwork/types"
 )
@@ -257,6 +261,14 @@ func TestRemoteDriver(t *testing.T) {
 			},
 		}
 	})
+	handle(t, mux, "NetworkOperInfo", func(msg map[string]interface{}) interface{} {
+		return map[string]interface{}{
+			"Value": map[string]interface{}{
+				"VNI":       256,
+				"NetworkID": msg["NetworkID"],
+			},
+		}
+	})
 
 	p, err := plugins.Get(plugin, driverapi.NetworkPluginEndpointType)
 	if err != nil {
@@ -274,6 +286,14 @@ func TestRemoteDriver(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	netInfo, err := driver.(driverapi.NetworkInfoDriver).NetworkOperInfo(netID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if netInfo["VNI"] != float64(256) || netInfo["NetworkID"] != netID {
+		t.Fatalf("Unexpected network operational data: %v", netInfo)
+	}
+
 	endID := "dummy-endpoint"
 	err = driver.CreateEndpoint(netID, endID, ep, map[string]interface{}{})
 	if err != nil {
@@ -420,3 +440,162 @@ func TestRollback(t *testing.T) {
 		t.Fatalf("Expected to have had DeleteEndpoint called")
 	}
 }
+
+// resyncRecorder is the controller registering the drivers and recording
+// their resyncs
+type resyncRecorder struct {
+	drivers  map[string]driverapi.Driver
+	resynced chan string
+}
+
+func (r *resyncRecorder) RegisterDriver(name string, d driverapi.Driver, c driverapi.Capability) error {
+	r.drivers[name] = d
+	return nil
+}
+
+func (r *resyncRecorder) ResyncDriver(name string) error {
+	r.resynced <- name
+	return nil
+}
+
+func TestWatchPluginRestart(t *testing.T) {
+	defer func(d time.Duration) { probeInterval = d }(probeInterval)
+	probeInterval = 10 * time.Millisecond
+	defer func(d time.Duration) { probeTimeout = d }(probeTimeout)
+	probeTimeout = 10 * time.Millisecond
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/Plugin.Activate", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"Implements": ["%s"]}`, driverapi.NetworkPluginEndpointType)
+	})
+
+	// The plugin is only found in its own directory
+	dir, err := ioutil.TempDir("", "plugins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sockPath := filepath.Join(dir, "test-net-driver-restart.sock")
+	l, err := net.Listen("unix", sockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A stopped plugin process drops its connections along with its socket
+	srv := &http.Server{Handler: mux}
+	srv.SetKeepAlivesEnabled(false)
+	go srv.Serve(l)
+
+	rc := &resyncRecorder{drivers: map[string]driverapi.Driver{}, resynced: make(chan string, 1)}
+	if err := Discover(rc, []string{dir}, "test-net-driver-restart"); err != nil {
+		t.Fatal(err)
+	}
+	d, ok := rc.drivers["test-net-driver-restart"]
+	if !ok {
+		t.Fatal("Expected the discovered plugin to be registered")
+	}
+
+	// A plugin answering its probes is not resynced
+	select {
+	case <-rc.resynced:
+		t.Fatal("Unexpected resync of a healthy plugin")
+	case <-time.After(10 * probeInterval):
+	}
+
+	// The plugin process goes away for longer than the probe timeout
+	l.Close()
+	time.Sleep(5 * probeTimeout)
+	if l, err = net.Listen("unix", sockPath); err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go srv.Serve(l)
+
+	// The plugin client retries the pending probe within a few seconds
+	select {
+	case name := <-rc.resynced:
+		if name != "test-net-driver-restart" {
+			t.Fatalf("Unexpected driver resynced: %s", name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the restarted plugin to be resynced")
+	}
+
+	// The watch ends with the driver
+	d.(driverapi.TeardownDriver).Teardown()
+	select {
+	case <-rc.resynced:
+		t.Fatal("Unexpected resync once the driver is torn down")
+	case <-time.After(10 * probeInterval):
+	}
+}
+
+func TestCallTimeout(t *testing.T) {
+	var plugin = "test-net-driver-timeout"
+
+	mux := http.NewServeMux()
+	defer setupPlugin(t, plugin, mux)()
+
+	release := make(chan struct{})
+	rolledback := make(chan string, 2)
+
+	handle(t, mux, "CreateEndpoint", func(msg map[string]interface{}) interface{} {
+		<-release
+		return map[string]interface{}{}
+	})
+	handle(t, mux, "DeleteEndpoint", func(msg map[string]interface{}) interface{} {
+		rolledback <- "DeleteEndpoint"
+		return map[string]interface{}{}
+	})
+	handle(t, mux, "Join", func(msg map[string]interface{}) interface{} {
+		<-release
+		return map[string]interface{}{}
+	})
+	handle(t, mux, "Leave", func(msg map[string]interface{}) interface{} {
+		rolledback <- "Leave"
+		return map[string]interface{}{}
+	})
+
+	p, err := plugins.Get(plugin, driverapi.NetworkPluginEndpointType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newDriver(plugin, p.Client)
+
+	prefix := netlabel.DriverPrefix + "." + plugin + ".timeout"
+	if err := d.Config(map[string]interface{}{prefix: "forever"}); err == nil {
+		t.Fatal("Expected failure configuring an invalid timeout")
+	}
+	if err := d.Config(map[string]interface{}{prefix: "50ms", prefix + ".Join": "100ms"}); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	err = d.CreateEndpoint("dummy", "dummy", &testEndpoint{t: t}, map[string]interface{}{})
+	if _, ok := err.(types.TimeoutError); !ok {
+		t.Fatalf("Expected a timeout error from the slow plugin. Got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Expected the call to time out after 50ms. Took %v", elapsed)
+	}
+
+	if err := d.Join("dummy", "dummy", "sbox", &testEndpoint{t: t}, map[string]interface{}{}); err == nil {
+		t.Fatal("Expected a timeout error from the slow plugin")
+	} else if _, ok := err.(types.TimeoutError); !ok {
+		t.Fatalf("Expected a timeout error from the slow plugin. Got %v", err)
+	}
+
+	// The calls the plugin completes past the deadline are rolled back
+	close(release)
+	got := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case m := <-rolledback:
+			got[m] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected the late calls to be rolled back. Got %v", got)
+		}
+	}
+	if !got["DeleteEndpoint"] || !got["Leave"] {
+		t.Fatalf("Expected DeleteEndpoint and Leave to roll back the late calls. Got %v", got)
+	}
+}
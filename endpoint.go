@@ -1,14 +1,21 @@
 package libnetwork
 
 import (
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"net"
+	"strings"
 	"sync"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/etchosts"
 	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/osl"
 	"github.com/docker/libnetwork/types"
 )
 
@@ -27,15 +34,71 @@ type Endpoint interface {
 	// the network resources allocated for the endpoint.
 	Join(sandbox Sandbox, options ...EndpointOption) error
 
+	// JoinWithResult joins the sandbox to the endpoint like Join does, and
+	// returns the configuration the join programmed in the sandbox.
+	JoinWithResult(sandbox Sandbox, options ...EndpointOption) (*JoinResult, error)
+
 	// Leave detaches the network resources populated in the sandbox.
 	Leave(sandbox Sandbox, options ...EndpointOption) error
 
+	// JoinedAt returns the time the endpoint joined its sandbox, and false if
+	// the endpoint is not joined.
+	JoinedAt() (time.Time, bool)
+
+	// Origin returns how the endpoint was created: through the API,
+	// automatically by libnetwork, or restored from the store on startup.
+	Origin() EndpointOrigin
+
+	// FailureCounts returns the number of failed joins and leaves of the
+	// endpoint since its creation or the last ResetFailureCounts.
+	FailureCounts() FailureCounts
+
+	// ResetFailureCounts zeroes the failed joins and leaves counters.
+	ResetFailureCounts()
+
 	// Return certain operational data belonging to this endpoint
 	Info() EndpointInfo
 
+	// EffectiveConfig returns the configuration libnetwork applied to the
+	// endpoint, merged from its network, creation, join and sandbox options,
+	// along with the layer each setting comes from.
+	EffectiveConfig() (*EffectiveConfig, error)
+
 	// DriverInfo returns a collection of driver operational data related to this endpoint retrieved from the driver
 	DriverInfo() (map[string]interface{}, error)
 
+	// SetAddress assigns the IPv4 address of an endpoint created with
+	// CreateOptionNoAddress. The address is reserved from the network ipam pool,
+	// and programmed in the sandbox if the endpoint is joined. It fails on the
+	// networks without ipam pool, where the address cannot be reserved.
+	SetAddress(addr *net.IPNet) error
+
+	// Renumber moves the endpoint to a new IPv4 address from the network ipam
+	// pool, as updated through UpdateIpamPool, and releases the old one. The
+	// address is reprogrammed in the joined sandbox and in the peers hosts files.
+	// The gateway and the routes through the old subnet move to the new subnet,
+	// keeping their host part. Only the networks using an ipam pool, whose
+	// subnet can change, support it.
+	Renumber() error
+
+	// Verify compares the configuration intended for this endpoint against the
+	// one programmed in the joined sandbox and returns the differences found.
+	Verify() ([]Discrepancy, error)
+
+	// Enable allows the joins on an endpoint created with CreateOptionDisabled.
+	Enable() error
+
+	// Drain blocks the new connections to the endpoint while the established
+	// ones go on, and waits for those to finish, up to the timeout, for the
+	// endpoint to leave without dropping them. A TimeoutError is returned if
+	// connections remain, a NotImplementedError if they cannot be counted. The
+	// block is lifted when the endpoint leaves.
+	Drain(timeout time.Duration) error
+
+	// ActivatePortBindings forwards the host ports of an endpoint created with
+	// CreateOptionPortMappingClaimOnly, reserved until then.
+	ActivatePortBindings() error
+
 	// Delete and detaches this endpoint from the network.
 	Delete() error
 }
@@ -45,16 +108,64 @@ type Endpoint interface {
 // provided by libnetwork, they look like <Create|Join|Leave>Option[...](...)
 type EndpointOption func(ep *endpoint)
 
+// EndpointOrigin tells how an endpoint was created
+type EndpointOrigin string
+
+const (
+	// EndpointOriginAPI is the origin of the endpoints created through CreateEndpoint
+	EndpointOriginAPI EndpointOrigin = "api"
+	// EndpointOriginAuto is the origin of the endpoints libnetwork creates on
+	// its own, like the ones attaching the sandboxes to the default network
+	EndpointOriginAuto EndpointOrigin = "auto"
+	// EndpointOriginRestore is the origin of the endpoints restored from the
+	// store when the controller starts
+	EndpointOriginRestore EndpointOrigin = "restore"
+)
+
+// FailureCounts accounts for the failed operations of an endpoint
+type FailureCounts struct {
+	// Join is the number of failed joins
+	Join uint64
+	// Leave is the number of failed leaves
+	Leave uint64
+}
+
+// LeaveHook is a function run when an endpoint leaves a sandbox, before the
+// endpoint is detached from it
+type LeaveHook func(ep Endpoint, sb Sandbox) error
+
 type endpoint struct {
 	name          string
 	id            string
+	externalKey   string
+	noAddress     bool
+	disabled      bool
+	promiscuous   bool
+	macFromIP     bool
+	primary       bool
+	portTarget    string // ID of the sibling endpoint the port mappings are forwarded to
+	origin        EndpointOrigin
+	replaced      *endpoint     // whose addresses the endpoint takes over on creation
+	createDone    chan struct{} // closed once the creation completed or failed
+	keepAddress   bool          // the addresses were handed over, not to be released
 	network       *network
 	iFaces        []*endpointInterface
 	joinInfo      *endpointJoinInfo
 	sandboxID     string
+	sandboxOwner  string // instance ID of the controller which joined the sandbox
+	joinedAt      time.Time
 	exposedPorts  []types.TransportPort
+	dnsNames      []string
+	dnsForward    map[string][]string // nameservers by domain, for the embedded resolver
+	labels        map[string]string
+	probe         *HealthProbe
+	unhealthy     bool
+	probeStopCh   chan struct{}
 	generic       map[string]interface{}
 	joinLeaveDone chan struct{}
+	lazyJoin      bool
+	correlationID string // of the join or leave in progress
+	failures      FailureCounts
 	dbIndex       uint64
 	dbExists      bool
 	sync.Mutex
@@ -69,8 +180,28 @@ func (ep *endpoint) MarshalJSON() ([]byte, error) {
 	epMap["id"] = ep.id
 	epMap["ep_iface"] = ep.iFaces
 	epMap["exposed_ports"] = ep.exposedPorts
+	epMap["dns_names"] = ep.dnsNames
+	epMap["origin"] = ep.origin
+	if len(ep.dnsForward) != 0 {
+		epMap["dns_forward"] = ep.dnsForward
+	}
+	epMap["labels"] = ep.labels
 	epMap["generic"] = ep.generic
 	epMap["sandbox"] = ep.sandboxID
+	if ep.sandboxOwner != "" {
+		epMap["sandbox_owner"] = ep.sandboxOwner
+	}
+	if !ep.joinedAt.IsZero() {
+		epMap["joined_at"] = ep.joinedAt
+	}
+	epMap["external_key"] = ep.externalKey
+	epMap["disabled"] = ep.disabled
+	epMap["promiscuous"] = ep.promiscuous
+	epMap["mac_from_ip"] = ep.macFromIP
+	epMap["primary"] = ep.primary
+	if ep.portTarget != "" {
+		epMap["port_target"] = ep.portTarget
+	}
 	return json.Marshal(epMap)
 }
 
@@ -98,9 +229,59 @@ func (ep *endpoint) UnmarshalJSON(b []byte) (err error) {
 	json.Unmarshal(tb, &tPorts)
 	ep.exposedPorts = tPorts
 
+	nb, _ := json.Marshal(epMap["dns_names"])
+	var dnsNames []string
+	json.Unmarshal(nb, &dnsNames)
+	ep.dnsNames = dnsNames
+
+	lb, _ := json.Marshal(epMap["labels"])
+	json.Unmarshal(lb, &ep.labels)
+
+	if v, ok := epMap["dns_forward"]; ok {
+		fb, _ := json.Marshal(v)
+		json.Unmarshal(fb, &ep.dnsForward)
+	}
+
 	cb, _ := json.Marshal(epMap["sandbox"])
 	json.Unmarshal(cb, &ep.sandboxID)
 
+	if v, ok := epMap["sandbox_owner"]; ok {
+		ep.sandboxOwner = v.(string)
+	}
+
+	if v, ok := epMap["joined_at"]; ok {
+		jb, _ := json.Marshal(v)
+		json.Unmarshal(jb, &ep.joinedAt)
+	}
+
+	if v, ok := epMap["external_key"]; ok {
+		ep.externalKey = v.(string)
+	}
+
+	if v, ok := epMap["disabled"]; ok {
+		ep.disabled = v.(bool)
+	}
+
+	if v, ok := epMap["promiscuous"]; ok {
+		ep.promiscuous = v.(bool)
+	}
+
+	if v, ok := epMap["mac_from_ip"]; ok {
+		ep.macFromIP = v.(bool)
+	}
+
+	if v, ok := epMap["primary"]; ok {
+		ep.primary = v.(bool)
+	}
+
+	if v, ok := epMap["port_target"]; ok {
+		ep.portTarget = v.(string)
+	}
+
+	if v, ok := epMap["origin"]; ok {
+		ep.origin = EndpointOrigin(v.(string))
+	}
+
 	if epMap["generic"] != nil {
 		ep.generic = epMap["generic"].(map[string]interface{})
 	}
@@ -220,6 +401,16 @@ func (ep *endpoint) joinLeaveEnd() {
 }
 
 func (ep *endpoint) Join(sbox Sandbox, options ...EndpointOption) error {
+	err := ep.join(sbox, options...)
+	if err != nil {
+		ep.Lock()
+		ep.failures.Join++
+		ep.Unlock()
+	}
+	return err
+}
+
+func (ep *endpoint) join(sbox Sandbox, options ...EndpointOption) error {
 	var err error
 
 	if sbox == nil {
@@ -230,18 +421,32 @@ func (ep *endpoint) Join(sbox Sandbox, options ...EndpointOption) error {
 	if !ok {
 		return types.BadRequestErrorf("not a valid Sandbox interface")
 	}
+	if err := ep.getNetwork().getController().checkOwner(sb); err != nil {
+		return err
+	}
+	if err := ep.checkPortTarget(sb); err != nil {
+		return err
+	}
 
 	ep.joinLeaveStart()
 	defer ep.joinLeaveEnd()
 
 	ep.Lock()
+	if ep.disabled {
+		ep.Unlock()
+		return ErrEndpointDisabled(ep.name)
+	}
+
 	if ep.sandboxID != "" {
 		ep.Unlock()
 		return types.ForbiddenErrorf("a sandbox has already joined the endpoint")
 	}
 
 	ep.sandboxID = sbox.ID()
+	ep.sandboxOwner = sb.controller.ownerStamp()
+	ep.joinedAt = time.Now()
 	ep.joinInfo = &endpointJoinInfo{}
+	ep.correlationID = ""
 	network := ep.network
 	epid := ep.id
 	ep.Unlock()
@@ -249,6 +454,8 @@ func (ep *endpoint) Join(sbox Sandbox, options ...EndpointOption) error {
 		if err != nil {
 			ep.Lock()
 			ep.sandboxID = ""
+			ep.sandboxOwner = ""
+			ep.joinedAt = time.Time{}
 			ep.Unlock()
 		}
 	}()
@@ -260,15 +467,48 @@ func (ep *endpoint) Join(sbox Sandbox, options ...EndpointOption) error {
 
 	ep.processOptions(options...)
 
+	logger := ep.opLogger()
+	logger.Debugf("Joining sandbox %s", sb.ID())
+	defer func() {
+		if err != nil {
+			logger.Debugf("Failed to join sandbox %s: %v", sb.ID(), err)
+		}
+	}()
+
+	ep.Lock()
+	parent := ep.joinInfo.parent
+	ep.Unlock()
+	if parent != nil {
+		if err = sb.checkParent(parent); err != nil {
+			return err
+		}
+	}
+
+	if err = sb.reservePorts(ep); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			sb.releasePorts(ep)
+		}
+	}()
+
+	// The interfaces of an endpoint created lazy do not exist until activation
+	ep.Lock()
+	_, lazyLink := ep.generic[netlabel.LazyLink]
+	lazy := ep.lazyJoin || lazyLink
+	ep.lazyJoin = false
+	ep.Unlock()
+
 	err = driver.Join(nid, epid, sbox.Key(), ep, sbox.Labels())
 	if err != nil {
-		return err
+		return mapDriverError(err)
 	}
 	defer func() {
 		if err != nil {
 			// Do not alter global err variable, it's needed by the previous defer
 			if err := driver.Leave(nid, epid); err != nil {
-				log.Warnf("driver leave failed while rolling back join: %v", err)
+				logger.Warnf("driver leave failed while rolling back join: %v", err)
 			}
 		}
 	}()
@@ -281,6 +521,11 @@ func (ep *endpoint) Join(sbox Sandbox, options ...EndpointOption) error {
 		return err
 	}
 
+	// Rebuilding the hosts file drops the links of the endpoints joined before
+	if recs := append(ep.linkRecords(), sb.linkRecords()...); len(recs) != 0 {
+		sb.addHostsEntries(recs)
+	}
+
 	if err = sb.updateDNS(ep.getNetwork().enableIPv6); err != nil {
 		return err
 	}
@@ -289,198 +534,876 @@ func (ep *endpoint) Join(sbox Sandbox, options ...EndpointOption) error {
 		return err
 	}
 
-	if err = sb.populateNetworkResources(ep); err != nil {
+	// Network resources of a lazily joined endpoint are only
+	// programmed in the sandbox on Sandbox.Activate()
+	if lazy {
+		sb.addLazyEndpoint(ep)
+	} else if err = sb.populateNetworkResources(ep); err != nil {
 		return err
 	}
 
+	if err := sb.updateSearchDomains(); err != nil {
+		logger.Warnf("Failed to update the resolv.conf search domains of sandbox %s: %v", sb.ID(), err)
+	}
+
+	if err := sb.updateDNSForwarding(); err != nil {
+		logger.Warnf("Failed to update the DNS forwarding of sandbox %s: %v", sb.ID(), err)
+	}
+
+	network.notifyMembership(MembershipEvent{Type: EndpointJoined, EndpointID: epid, EndpointName: ep.Name(), SandboxID: sb.ID()})
+	logger.Debugf("Joined sandbox %s", sb.ID())
+
 	return nil
 }
 
-func (ep *endpoint) hasInterface(iName string) bool {
+// checkPortTarget fails if the sibling the endpoint forwards its port mappings
+// to, or an endpoint forwarding its port mappings to this one, is joined to
+// another sandbox than sb
+func (ep *endpoint) checkPortTarget(sb *sandbox) error {
 	ep.Lock()
-	defer ep.Unlock()
+	id := ep.id
+	target := ep.portTarget
+	ep.Unlock()
 
-	for _, iface := range ep.iFaces {
-		if iface.srcName == iName {
-			return true
+	for _, e := range ep.getNetwork().Endpoints() {
+		sibling := e.(*endpoint)
+		sibling.Lock()
+		linked := sibling.id == target || (sibling.portTarget == id && id != "")
+		sid := sibling.sandboxID
+		sibling.Unlock()
+		if linked && sid != "" && sid != sb.ID() {
+			return types.ForbiddenErrorf("endpoint %s shares its port mappings with endpoint %s joined to another sandbox", ep.Name(), sibling.Name())
 		}
 	}
-
-	return false
+	return nil
 }
 
-func (ep *endpoint) Leave(sbox Sandbox, options ...EndpointOption) error {
-	ep.joinLeaveStart()
-	defer ep.joinLeaveEnd()
+func (ep *endpoint) Origin() EndpointOrigin {
+	ep.Lock()
+	defer ep.Unlock()
 
-	if sbox == nil || sbox.ID() == "" || sbox.Key() == "" {
-		return types.BadRequestErrorf("invalid Sandbox passed to enpoint leave: %v", sbox)
-	}
+	return ep.origin
+}
 
-	sb, ok := sbox.(*sandbox)
-	if !ok {
-		return types.BadRequestErrorf("not a valid Sandbox interface")
-	}
+func (ep *endpoint) FailureCounts() FailureCounts {
+	ep.Lock()
+	defer ep.Unlock()
 
+	return ep.failures
+}
+
+func (ep *endpoint) ResetFailureCounts() {
 	ep.Lock()
-	sid := ep.sandboxID
+	ep.failures = FailureCounts{}
 	ep.Unlock()
+}
 
-	if sid == "" {
-		return types.ForbiddenErrorf("cannot leave endpoint with no attached sandbox")
-	}
-	if sid != sbox.ID() {
-		return types.ForbiddenErrorf("unexpected sandbox ID in leave request. Expected %s. Got %s", ep.sandboxID, sbox.ID())
-	}
+func (ep *endpoint) JoinedAt() (time.Time, bool) {
+	ep.Lock()
+	defer ep.Unlock()
 
-	ep.processOptions(options...)
+	if ep.sandboxID == "" {
+		return time.Time{}, false
+	}
+	return ep.joinedAt, true
+}
 
+// rejoin programs back the endpoint, persisted as joined to the sandbox, in
+// the sandbox the controller restored after a restart
+func (ep *endpoint) rejoin(sb *sandbox) error {
 	ep.Lock()
-	ep.sandboxID = ""
+	ep.joinInfo = &endpointJoinInfo{}
 	n := ep.network
 	ep.Unlock()
 
 	n.Lock()
-	c := n.ctrlr
 	d := n.driver
+	nid := n.id
 	n.Unlock()
 
-	if err := c.updateEndpointToStore(ep); err != nil {
-		ep.Lock()
-		ep.sandboxID = sid
-		ep.Unlock()
+	if err := sb.reservePorts(ep); err != nil {
 		return err
 	}
-
-	if err := d.Leave(n.id, ep.id); err != nil {
+	if err := d.Join(nid, ep.ID(), sb.Key(), ep, sb.Labels()); err != nil {
+		sb.releasePorts(ep)
+		return mapDriverError(err)
+	}
+	if err := sb.populateNetworkResources(ep); err != nil {
+		sb.releasePorts(ep)
+		if e := d.Leave(nid, ep.ID()); e != nil {
+			log.Warnf("driver leave failed while rolling back the join restore: %v", e)
+		}
 		return err
 	}
 
-	return sb.clearNetworkResources(ep)
+	return nil
+}
+
+func (ep *endpoint) JoinWithResult(sbox Sandbox, options ...EndpointOption) (*JoinResult, error) {
+	if err := ep.Join(sbox, options...); err != nil {
+		return nil, err
+	}
+	return ep.joinResult(sbox.(*sandbox)), nil
 }
 
-func (ep *endpoint) Delete() error {
+func (ep *endpoint) SetAddress(addr *net.IPNet) error {
 	var err error
+
+	if addr == nil || addr.IP.To4() == nil {
+		return types.BadRequestErrorf("invalid IPv4 address %v", addr)
+	}
+
 	ep.Lock()
-	epid := ep.id
-	name := ep.name
+	var iface *endpointInterface
+	if len(ep.iFaces) != 0 {
+		iface = ep.iFaces[0]
+	}
 	n := ep.network
-	if ep.sandboxID != "" {
-		ep.Unlock()
-		return &ActiveContainerError{name: name, id: epid}
+	ep.Unlock()
+
+	if iface == nil {
+		return types.ForbiddenErrorf("endpoint %s has no interface", ep.Name())
+	}
+	if current := iface.Address(); len(current.IP) != 0 {
+		return types.ForbiddenErrorf("endpoint %s already has address %s", ep.Name(), current.String())
 	}
+
 	n.Lock()
+	pool := n.ipamPool
 	ctrlr := n.ctrlr
 	n.Unlock()
-	ep.Unlock()
 
-	if err = ctrlr.deleteEndpointFromStore(ep); err != nil {
-		return err
+	// Only the ipam pools let libnetwork reserve the address, the drivers
+	// allocating their own addresses would not know about it
+	if pool == "" {
+		return types.ForbiddenErrorf("cannot reserve address %s for endpoint %s: network %s has no ipam pool", addr.IP, ep.Name(), n.Name())
+	}
+
+	ipv4, err := ctrlr.requestPoolAddress(pool, addr.IP)
+	if err != nil {
+		return types.ForbiddenErrorf("failed to reserve address %s from ipam pool %s: %v", addr.IP, pool, err)
 	}
 	defer func() {
 		if err != nil {
-			ep.SetIndex(0)
-			if e := ctrlr.updateEndpointToStore(ep); e != nil {
-				log.Warnf("failed to recreate endpoint in store %s : %v", name, err)
-			}
+			ctrlr.releasePoolAddress(pool, ipv4.IP)
 		}
 	}()
 
-	// Update the endpoint count in network and update it in the datastore
-	n.DecEndpointCnt()
-	if err = ctrlr.updateNetworkToStore(n); err != nil {
-		return err
-	}
-	defer func() {
-		if err != nil {
-			n.IncEndpointCnt()
-			if e := ctrlr.updateNetworkToStore(n); e != nil {
-				log.Warnf("failed to update network %s : %v", n.name, e)
+	var osIface osl.Interface
+	if sb, ok := ep.getSandbox(); ok {
+		sb.Lock()
+		_, lazy := sb.lazyEps[ep.ID()]
+		osSbox := sb.osSbox
+		sb.Unlock()
+
+		if !lazy {
+			for _, i := range osSbox.Info().Interfaces() {
+				if i.SrcName() != iface.srcName {
+					continue
+				}
+				if err = i.SetAddress(ipv4); err != nil {
+					return err
+				}
+				osIface = i
 			}
 		}
-	}()
+	}
 
-	if err = ep.deleteEndpoint(); err != nil {
-		return err
+	if e := ep.rederiveMacAddress(iface, osIface, ipv4); e != nil {
+		log.Warnf("failed to derive the mac address of endpoint %s from address %s: %v", ep.Name(), ipv4.IP, e)
+	}
+
+	ep.Lock()
+	iface.addr = *ipv4
+	ep.Unlock()
+	ctrlr.updateLinks(ep)
+
+	if e := ctrlr.updateEndpointToStore(ep); e != nil {
+		log.Warnf("failed to update endpoint %s to store: %v", ep.Name(), e)
 	}
 
 	return nil
 }
 
-func (ep *endpoint) deleteEndpoint() error {
+// electMacAddress returns the MAC address libnetwork assigns to the endpoint
+// interface for the IPv4 address, nil if it is left to the driver
+func (ep *endpoint) electMacAddress(ip net.IP) net.HardwareAddr {
 	ep.Lock()
-	n := ep.network
-	name := ep.name
-	epid := ep.id
-	ep.Unlock()
+	defer ep.Unlock()
 
-	n.Lock()
-	_, ok := n.endpoints[epid]
-	if !ok {
-		n.Unlock()
+	if mac, ok := ep.generic[netlabel.MacAddress].(net.HardwareAddr); ok {
+		return types.GetMacCopy(mac)
+	}
+	if !ep.macFromIP || ip.To4() == nil {
 		return nil
 	}
+	return netutils.GenerateMACFromIP(ip)
+}
 
-	nid := n.id
-	driver := n.driver
-	delete(n.endpoints, epid)
-	n.Unlock()
+// rederiveMacAddress updates the MAC address of the endpoint interface, and of
+// the sandbox one if any, after its address changed to ipv4
+func (ep *endpoint) rederiveMacAddress(iface *endpointInterface, osIface osl.Interface, ipv4 *net.IPNet) error {
+	ep.Lock()
+	_, explicit := ep.generic[netlabel.MacAddress].(net.HardwareAddr)
+	macFromIP := ep.macFromIP
+	ep.Unlock()
 
-	if err := driver.DeleteEndpoint(nid, epid); err != nil {
-		if _, ok := err.(types.ForbiddenError); ok {
-			n.Lock()
-			n.endpoints[epid] = ep
-			n.Unlock()
+	if !macFromIP || explicit {
+		return nil
+	}
+
+	mac := netutils.GenerateMACFromIP(ipv4.IP)
+	if osIface != nil {
+		if err := osIface.SetMacAddress(mac); err != nil {
 			return err
 		}
-		log.Warnf("driver error deleting endpoint %s : %v", name, err)
 	}
 
-	n.updateSvcRecord(ep, false)
+	ep.Lock()
+	iface.mac = mac
+	ep.Unlock()
 	return nil
 }
 
-func (ep *endpoint) getNetwork() *network {
+func (ep *endpoint) isPrimary() bool {
 	ep.Lock()
 	defer ep.Unlock()
-	return ep.network
+
+	return ep.primary
 }
 
-func (ep *endpoint) getSandbox() (*sandbox, bool) {
+func (ep *endpoint) Renumber() error {
+	var err error
+
 	ep.Lock()
-	c := ep.network.getController()
-	sid := ep.sandboxID
+	var iface *endpointInterface
+	if len(ep.iFaces) != 0 {
+		iface = ep.iFaces[0]
+	}
+	n := ep.network
 	ep.Unlock()
 
-	c.Lock()
-	ps, ok := c.sandboxes[sid]
-	c.Unlock()
+	if iface == nil {
+		return types.ForbiddenErrorf("endpoint %s has no interface", ep.Name())
+	}
+	old := iface.Address()
+	if len(old.IP) == 0 {
+		return types.ForbiddenErrorf("endpoint %s has no address to renumber", ep.Name())
+	}
 
-	return ps, ok
-}
+	n.Lock()
+	pool := n.ipamPool
+	ctrlr := n.ctrlr
+	n.Unlock()
 
-func (ep *endpoint) getFirstInterfaceAddress() net.IP {
-	ep.Lock()
-	defer ep.Unlock()
+	// Driver allocated addresses are out of reach
+	if pool == "" {
+		return types.NotImplementedErrorf("endpoint %s address is not allocated from an ipam pool", ep.Name())
+	}
 
-	if len(ep.iFaces) != 0 && ep.iFaces[0] != nil {
-		return ep.iFaces[0].addr.IP
+	ipv4, err := ctrlr.requestPoolAddress(pool, nil)
+	if err != nil {
+		return types.ForbiddenErrorf("failed to allocate a new address from ipam pool %s: %v", pool, err)
 	}
+	defer func() {
+		if err != nil {
+			ctrlr.releasePoolAddress(pool, ipv4.IP)
+		}
+	}()
 
-	return nil
-}
+	var (
+		osIface  osl.Interface
+		joinedSb *sandbox
+		moved    []*types.StaticRoute
+	)
+	if sb, ok := ep.getSandbox(); ok {
+		sb.Lock()
+		_, lazy := sb.lazyEps[ep.ID()]
+		hostsPath := sb.config.hostsPath
+		hostName := sb.config.hostName
+		sb.Unlock()
+
+		if !lazy {
+			joinedSb = sb
+			ep.Lock()
+			joinInfo := ep.joinInfo
+			ep.Unlock()
+			osSbox := sb.osSbox
+			if parent := joinInfo.parentSandbox(); parent != nil {
+				osSbox = parent.osSbox
+			}
 
-// EndpointOptionGeneric function returns an option setter for a Generic option defined
-// in a Dictionary of Key-Value pair
-func EndpointOptionGeneric(generic map[string]interface{}) EndpointOption {
-	return func(ep *endpoint) {
-		for k, v := range generic {
-			ep.generic[k] = v
+			// The routes through a gateway of the old subnet go away along
+			// with the old address, they are moved to the new subnet
+			if joinInfo != nil {
+				if joinInfo.routingTable != 0 {
+					if e := osSbox.RemoveSourceRoute(&old, joinInfo.gw, joinInfo.routingTable); e != nil {
+						log.Debugf("failed to remove the routing table %d of endpoint %s: %v", joinInfo.routingTable, ep.Name(), e)
+					}
+				}
+				for _, r := range joinInfo.StaticRoutes {
+					if r.NextHop != nil && old.Contains(r.NextHop) {
+						if e := osSbox.RemoveStaticRoute(r); e != nil {
+							log.Debugf("failed to remove static route %s of endpoint %s: %v", r.Destination, ep.Name(), e)
+						}
+						moved = append(moved, r)
+					}
+				}
+			}
+
+			for _, i := range osSbox.Info().Interfaces() {
+				if i.SrcName() != iface.srcName {
+					continue
+				}
+				if err = i.SetAddress(ipv4); err != nil {
+					return err
+				}
+				if err = i.RemoveAddress(&old); err != nil {
+					if e := i.RemoveAddress(ipv4); e != nil {
+						log.Warnf("failed to remove address %s from endpoint %s on rollback: %v", ipv4, ep.Name(), e)
+					}
+					return err
+				}
+				osIface = i
+			}
 		}
-	}
-}
 
-// CreateOptionExposedPorts function returns an option setter for the container exposed
+		if hostsPath != "" && hostName != "" {
+			if e := etchosts.Update(hostsPath, ipv4.IP.String(), hostName); e != nil {
+				log.Warnf("failed to update the hosts file of endpoint %s with address %s: %v", ep.Name(), ipv4.IP, e)
+			}
+		}
+	}
+
+	if e := ep.rederiveMacAddress(iface, osIface, ipv4); e != nil {
+		log.Warnf("failed to derive the mac address of endpoint %s from address %s: %v", ep.Name(), ipv4.IP, e)
+	}
+
+	n.updateSvcRecord(ep, false)
+	ep.Lock()
+	iface.addr = *ipv4
+	if ep.joinInfo != nil {
+		ep.joinInfo.gw = renumberIP(ep.joinInfo.gw, &old, ipv4)
+		moved = moved[:0]
+		for i, r := range ep.joinInfo.StaticRoutes {
+			if r.NextHop != nil && old.Contains(r.NextHop) {
+				nr := r.GetCopy()
+				nr.NextHop = renumberIP(r.NextHop, &old, ipv4)
+				ep.joinInfo.StaticRoutes[i] = nr
+				moved = append(moved, nr)
+			}
+		}
+	}
+	ep.Unlock()
+	n.updateSvcRecord(ep, true)
+	ctrlr.updateLinks(ep)
+
+	ctrlr.releasePoolAddress(pool, old.IP)
+
+	if e := ctrlr.updateEndpointToStore(ep); e != nil {
+		log.Warnf("failed to update endpoint %s to store: %v", ep.Name(), e)
+	}
+
+	if joinedSb != nil {
+		return joinedSb.renumberRoutes(ep, moved)
+	}
+
+	return nil
+}
+
+// renumberIP returns the address with the host part of ip in the to subnet,
+// if ip belongs to the from subnet. Otherwise ip is returned unchanged.
+func renumberIP(ip net.IP, from, to *net.IPNet) net.IP {
+	ip4, base := ip.To4(), to.IP.To4()
+	if ip4 == nil || base == nil || !from.Contains(ip) {
+		return ip
+	}
+	fromOnes, fromBits := from.Mask.Size()
+	toOnes, toBits := to.Mask.Size()
+	fromHost := uint32(1<<uint64(fromBits-fromOnes) - 1)
+	toHost := uint32(1<<uint64(toBits-toOnes) - 1)
+
+	res := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(res, binary.BigEndian.Uint32(base)&^toHost|binary.BigEndian.Uint32(ip4)&fromHost&toHost)
+	return res
+}
+
+func (ep *endpoint) Enable() error {
+	ep.Lock()
+	if !ep.disabled {
+		ep.Unlock()
+		return nil
+	}
+	ep.disabled = false
+	n := ep.network
+	ep.Unlock()
+
+	if e := n.getController().updateEndpointToStore(ep); e != nil {
+		log.Warnf("failed to update endpoint %s to store: %v", ep.Name(), e)
+	}
+
+	return nil
+}
+
+// drainPollInterval is how often the connections of a draining endpoint are
+// counted. Tests shorten it.
+var drainPollInterval = time.Second
+
+func (ep *endpoint) Drain(timeout time.Duration) error {
+	ep.Lock()
+	n := ep.network
+	eid := ep.id
+	ep.Unlock()
+
+	n.Lock()
+	d := n.driver
+	nid := n.id
+	n.Unlock()
+
+	dd, ok := d.(driverapi.EndpointDrainDriver)
+	if !ok {
+		return types.NotImplementedErrorf("network %s driver %s cannot drain endpoint %s", n.Name(), d.Type(), ep.Name())
+	}
+
+	if err := dd.DrainEndpoint(nid, eid, true); err != nil {
+		return mapDriverError(err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		conns, err := dd.EndpointConnections(nid, eid)
+		if err != nil {
+			return mapDriverError(err)
+		}
+		if conns == 0 {
+			return nil
+		}
+		if !time.Now().Before(deadline) {
+			return types.TimeoutErrorf("endpoint %s still has %d connection(s) after draining for %v", ep.Name(), conns, timeout)
+		}
+		wait := drainPollInterval
+		if left := deadline.Sub(time.Now()); left < wait {
+			wait = left
+		}
+		time.Sleep(wait)
+	}
+}
+
+func (ep *endpoint) ActivatePortBindings() error {
+	ep.Lock()
+	n := ep.network
+	eid := ep.id
+	ep.Unlock()
+
+	n.Lock()
+	d := n.driver
+	nid := n.id
+	n.Unlock()
+
+	pd, ok := d.(driverapi.PortBindingDriver)
+	if !ok {
+		return types.NotImplementedErrorf("network %s driver %s cannot activate the port bindings of endpoint %s", n.Name(), d.Type(), ep.Name())
+	}
+
+	return mapDriverError(pd.ActivatePortBindings(nid, eid))
+}
+
+// createLink has the driver create the interfaces of an endpoint created with
+// CreateOptionLazy. It is a no-op for the other endpoints.
+func (ep *endpoint) createLink() error {
+	ep.Lock()
+	_, lazy := ep.generic[netlabel.LazyLink]
+	eid := ep.id
+	ep.Unlock()
+
+	if !lazy {
+		return nil
+	}
+
+	n := ep.getNetwork()
+	n.Lock()
+	d := n.driver
+	nid := n.id
+	n.Unlock()
+
+	ld, ok := d.(driverapi.EndpointLinkDriver)
+	if !ok {
+		return types.NotImplementedErrorf("network %s driver %s cannot create the interfaces of endpoint %s", n.Name(), d.Type(), ep.Name())
+	}
+
+	return mapDriverError(ld.CreateEndpointLink(nid, eid))
+}
+
+// matchLabels returns whether the endpoint labels carry all the selector pairs
+func (ep *endpoint) matchLabels(selector map[string]string) bool {
+	ep.Lock()
+	defer ep.Unlock()
+
+	return labelsMatch(ep.labels, selector)
+}
+
+func (ep *endpoint) hasInterface(iName string) bool {
+	ep.Lock()
+	defer ep.Unlock()
+
+	for _, iface := range ep.iFaces {
+		if iface.srcName == iName {
+			return true
+		}
+	}
+
+	return false
+}
+
+// linkRecords returns the hosts file records of the endpoint links whose target has an address
+func (ep *endpoint) linkRecords() []etchosts.Record {
+	ep.Lock()
+	var links []endpointLink
+	if ep.joinInfo != nil {
+		links = ep.joinInfo.links
+	}
+	ep.Unlock()
+
+	var recs []etchosts.Record
+	for _, l := range links {
+		if ip := l.target.getFirstInterfaceAddress(); ip != nil {
+			recs = append(recs, etchosts.Record{Hosts: l.alias, IP: ip.String()})
+		}
+	}
+
+	return recs
+}
+
+// opLogger returns the log entry of the join or leave in progress, tagged with
+// the endpoint name and the correlation ID supplied by the caller, if any
+func (ep *endpoint) opLogger() *log.Entry {
+	ep.Lock()
+	fields := log.Fields{"endpoint": ep.name}
+	if ep.correlationID != "" {
+		fields["correlation_id"] = ep.correlationID
+	}
+	n := ep.network
+	ep.Unlock()
+
+	return n.getController().logger().WithFields(fields)
+}
+
+// leaveOrder returns the leave priority of the endpoint and its network name,
+// which orders the endpoints of a same priority
+func (ep *endpoint) leaveOrder() (int, string) {
+	ep.Lock()
+	prio := 0
+	if ep.joinInfo != nil {
+		prio = ep.joinInfo.leavePriority
+	}
+	n := ep.network
+	ep.Unlock()
+
+	return prio, n.Name()
+}
+
+// linkAliases returns the hosts file records of the endpoint links, by alias only
+func (ep *endpoint) linkAliases() []etchosts.Record {
+	ep.Lock()
+	defer ep.Unlock()
+
+	if ep.joinInfo == nil {
+		return nil
+	}
+	var recs []etchosts.Record
+	for _, l := range ep.joinInfo.links {
+		recs = append(recs, etchosts.Record{Hosts: l.alias})
+	}
+
+	return recs
+}
+
+// linksTo returns the aliases the endpoint links the target under
+func (ep *endpoint) linksTo(target *endpoint) []string {
+	ep.Lock()
+	defer ep.Unlock()
+
+	if ep.joinInfo == nil {
+		return nil
+	}
+	var aliases []string
+	for _, l := range ep.joinInfo.links {
+		if l.target == target {
+			aliases = append(aliases, l.alias)
+		}
+	}
+
+	return aliases
+}
+
+func (ep *endpoint) Leave(sbox Sandbox, options ...EndpointOption) error {
+	err := ep.leave(sbox, options...)
+	if err != nil {
+		ep.Lock()
+		ep.failures.Leave++
+		ep.Unlock()
+	}
+	return err
+}
+
+func (ep *endpoint) leave(sbox Sandbox, options ...EndpointOption) error {
+	ep.joinLeaveStart()
+	defer ep.joinLeaveEnd()
+
+	if sbox == nil || sbox.ID() == "" || sbox.Key() == "" {
+		return types.BadRequestErrorf("invalid Sandbox passed to enpoint leave: %v", sbox)
+	}
+
+	sb, ok := sbox.(*sandbox)
+	if !ok {
+		return types.BadRequestErrorf("not a valid Sandbox interface")
+	}
+	if err := ep.getNetwork().getController().checkOwner(sb); err != nil {
+		return err
+	}
+
+	ep.Lock()
+	sid := ep.sandboxID
+	ep.Unlock()
+
+	if sid == "" {
+		return types.ForbiddenErrorf("cannot leave endpoint with no attached sandbox")
+	}
+	if sid != sbox.ID() {
+		return types.ForbiddenErrorf("unexpected sandbox ID in leave request. Expected %s. Got %s", ep.sandboxID, sbox.ID())
+	}
+
+	ep.Lock()
+	ep.correlationID = ""
+	ep.Unlock()
+	ep.processOptions(options...)
+
+	logger := ep.opLogger()
+	logger.Debugf("Leaving sandbox %s", sid)
+
+	ep.Lock()
+	var hook LeaveHook
+	if ep.joinInfo != nil {
+		hook = ep.joinInfo.preLeave
+	}
+	ep.Unlock()
+	if hook != nil {
+		if err := hook(ep, sb); err != nil {
+			logger.Warnf("Pre-leave hook of endpoint %s failed: %v", ep.Name(), err)
+		}
+	}
+
+	ep.Lock()
+	ep.sandboxID = ""
+	owner := ep.sandboxOwner
+	ep.sandboxOwner = ""
+	joinedAt := ep.joinedAt
+	ep.joinedAt = time.Time{}
+	n := ep.network
+	ep.Unlock()
+
+	n.Lock()
+	c := n.ctrlr
+	d := n.driver
+	n.Unlock()
+
+	if err := c.updateEndpointToStore(ep); err != nil {
+		ep.Lock()
+		ep.sandboxID = sid
+		ep.sandboxOwner = owner
+		ep.joinedAt = joinedAt
+		ep.Unlock()
+		return err
+	}
+
+	if err := d.Leave(n.id, ep.id); err != nil {
+		return err
+	}
+
+	if !sb.removeLazyEndpoint(ep) {
+		if err := sb.clearNetworkResources(ep); err != nil {
+			return err
+		}
+	}
+
+	sb.releasePorts(ep)
+	sb.deleteHostsEntries(ep.linkAliases())
+
+	if err := sb.updateSearchDomains(); err != nil {
+		logger.Warnf("Failed to update the resolv.conf search domains of sandbox %s: %v", sid, err)
+	}
+
+	if err := sb.updateDNSForwarding(); err != nil {
+		logger.Warnf("Failed to update the DNS forwarding of sandbox %s: %v", sid, err)
+	}
+
+	n.notifyMembership(MembershipEvent{Type: EndpointLeft, EndpointID: ep.ID(), EndpointName: ep.Name(), SandboxID: sid})
+	logger.Debugf("Left sandbox %s", sid)
+
+	return nil
+}
+
+func (ep *endpoint) Delete() error {
+	var err error
+	ep.Lock()
+	epid := ep.id
+	name := ep.name
+	n := ep.network
+	if ep.sandboxID != "" {
+		ep.Unlock()
+		return &ActiveContainerError{name: name, id: epid}
+	}
+	n.Lock()
+	ctrlr := n.ctrlr
+	n.Unlock()
+	ep.Unlock()
+
+	if err = ctrlr.deleteEndpointFromStore(ep); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			ep.SetIndex(0)
+			if e := ctrlr.updateEndpointToStore(ep); e != nil {
+				log.Warnf("failed to recreate endpoint in store %s : %v", name, err)
+			}
+		}
+	}()
+
+	// Update the endpoint count in network and update it in the datastore
+	n.DecEndpointCnt()
+	if err = ctrlr.updateNetworkToStore(n); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			n.IncEndpointCnt()
+			if e := ctrlr.updateNetworkToStore(n); e != nil {
+				log.Warnf("failed to update network %s : %v", n.name, e)
+			}
+		}
+	}()
+
+	if err = ep.deleteEndpoint(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (ep *endpoint) deleteEndpoint() error {
+	ep.Lock()
+	n := ep.network
+	name := ep.name
+	epid := ep.id
+	ep.Unlock()
+
+	n.Lock()
+	_, ok := n.endpoints[epid]
+	if !ok {
+		n.Unlock()
+		return nil
+	}
+
+	nid := n.id
+	driver := n.driver
+	pool := n.ipamPool
+	poolV6 := n.ipamPoolV6
+	delete(n.endpoints, epid)
+	n.Unlock()
+
+	ep.stopProbe()
+
+	if err := driver.DeleteEndpoint(nid, epid); err != nil {
+		if _, ok := err.(types.ForbiddenError); ok {
+			n.Lock()
+			n.endpoints[epid] = ep
+			n.Unlock()
+			ep.startProbe()
+			return err
+		}
+		log.Warnf("driver error deleting endpoint %s : %v", name, err)
+	}
+
+	ep.Lock()
+	keep := ep.keepAddress
+	ep.Unlock()
+
+	if pool != "" && !keep {
+		n.ctrlr.releasePoolAddress(pool, ep.getFirstInterfaceAddress())
+	}
+	if poolV6 != "" && !keep {
+		n.ctrlr.releasePoolAddress(poolV6, ep.getFirstInterfaceAddressV6())
+	}
+
+	n.updateSvcRecord(ep, false)
+	n.notifyMembership(MembershipEvent{Type: EndpointRemoved, EndpointID: epid, EndpointName: name})
+	return nil
+}
+
+func (ep *endpoint) getNetwork() *network {
+	ep.Lock()
+	defer ep.Unlock()
+	return ep.network
+}
+
+func (ep *endpoint) getSandbox() (*sandbox, bool) {
+	ep.Lock()
+	c := ep.network.getController()
+	sid := ep.sandboxID
+	ep.Unlock()
+
+	c.Lock()
+	ps, ok := c.sandboxes[sid]
+	c.Unlock()
+
+	return ps, ok
+}
+
+func (ep *endpoint) getFirstInterfaceAddress() net.IP {
+	ep.Lock()
+	defer ep.Unlock()
+
+	if len(ep.iFaces) != 0 && ep.iFaces[0] != nil {
+		return ep.iFaces[0].addr.IP
+	}
+
+	return nil
+}
+
+func (ep *endpoint) getFirstInterfaceNetwork() *net.IPNet {
+	ep.Lock()
+	defer ep.Unlock()
+
+	if len(ep.iFaces) != 0 && ep.iFaces[0] != nil && ep.iFaces[0].addr.IP != nil {
+		return types.GetIPNetCopy(&ep.iFaces[0].addr)
+	}
+
+	return nil
+}
+
+func (ep *endpoint) getFirstInterfaceAddressV6() net.IP {
+	ep.Lock()
+	defer ep.Unlock()
+
+	if len(ep.iFaces) != 0 && ep.iFaces[0] != nil {
+		return ep.iFaces[0].addrv6.IP
+	}
+
+	return nil
+}
+
+// EndpointOptionGeneric function returns an option setter for a Generic option defined
+// in a Dictionary of Key-Value pair
+func EndpointOptionGeneric(generic map[string]interface{}) EndpointOption {
+	return func(ep *endpoint) {
+		for k, v := range generic {
+			ep.generic[k] = v
+		}
+	}
+}
+
+// CreateOptionExposedPorts function returns an option setter for the container exposed
 // ports option to be passed to network.CreateEndpoint() method.
 func CreateOptionExposedPorts(exposedPorts []types.TransportPort) EndpointOption {
 	return func(ep *endpoint) {
@@ -493,6 +1416,115 @@ func CreateOptionExposedPorts(exposedPorts []types.TransportPort) EndpointOption
 	}
 }
 
+// CreateOptionExternalKey function returns an option setter for the external key
+// option to be passed to network.CreateEndpoint() method. The key must be unique
+// within the network: creating an endpoint with the key of an existing endpoint
+// returns the existing endpoint.
+func CreateOptionExternalKey(key string) EndpointOption {
+	return func(ep *endpoint) {
+		ep.externalKey = key
+	}
+}
+
+// CreateOptionIPAddress function returns an option setter for the IPv4 address
+// requested for the endpoint, to be passed to network.CreateEndpoint() method.
+func CreateOptionIPAddress(ip net.IP) EndpointOption {
+	return func(ep *endpoint) {
+		// Store a copy of the address as generic data to pass to the driver
+		ep.generic[netlabel.IPAddress] = types.GetIPCopy(ip)
+	}
+}
+
+// CreateOptionDNSNames function returns an option setter for the names, other
+// than the endpoint name, the endpoint address is resolved with by its peers.
+func CreateOptionDNSNames(names []string) EndpointOption {
+	return func(ep *endpoint) {
+		for _, name := range names {
+			if name = strings.TrimSpace(name); name != "" {
+				ep.dnsNames = append(ep.dnsNames, name)
+			}
+		}
+	}
+}
+
+// createOptionOrigin function returns an option setter for the origin of the
+// endpoints libnetwork creates through CreateEndpoint for its own purposes
+func createOptionOrigin(origin EndpointOrigin) EndpointOption {
+	return func(ep *endpoint) {
+		ep.origin = origin
+	}
+}
+
+// CreateOptionDNSForward function returns an option setter for a DNS
+// forwarding rule of the endpoint: the queries of its sandbox for the domain,
+// "corp" or "*.corp", and its subdomains are forwarded to the nameservers by
+// the sandbox embedded resolver. It wins over a rule of the network for the
+// same domain.
+func CreateOptionDNSForward(domain string, nameservers ...string) EndpointOption {
+	return func(ep *endpoint) {
+		ep.dnsForward = addDNSForward(ep.dnsForward, domain, nameservers)
+	}
+}
+
+// CreateOptionLabels function returns an option setter for the labels the
+// endpoint is selected with through FilterEndpoints.
+func CreateOptionLabels(labels map[string]string) EndpointOption {
+	return func(ep *endpoint) {
+		ep.labels = make(map[string]string, len(labels))
+		for k, v := range labels {
+			ep.labels[k] = v
+		}
+	}
+}
+
+// CreateOptionNoAddress function returns an option setter to create the endpoint
+// with an interface but no address. The address can be assigned later on
+// through SetAddress, on the networks using an ipam pool.
+func CreateOptionNoAddress() EndpointOption {
+	return func(ep *endpoint) {
+		ep.noAddress = true
+	}
+}
+
+// CreateOptionDisabled function returns an option setter to create the endpoint
+// disabled. The endpoint holds its address but refuses the joins until Enable
+// is called on it.
+func CreateOptionDisabled() EndpointOption {
+	return func(ep *endpoint) {
+		ep.disabled = true
+	}
+}
+
+// CreateOptionPromiscuous function returns an option setter to create the endpoint
+// with its sandbox interface in promiscuous mode, for the containers capturing the
+// network traffic. The mode is set on join and cleared on leave.
+func CreateOptionPromiscuous() EndpointOption {
+	return func(ep *endpoint) {
+		ep.promiscuous = true
+	}
+}
+
+// CreateOptionPrimary function returns an option setter to create the primary
+// endpoint of the sandboxes it joins: its interface gets the first name of its
+// prefix, like eth0, and it supplies the default route, regardless of the join
+// order. The secondary endpoint joined before and holding the name is renamed.
+func CreateOptionPrimary() EndpointOption {
+	return func(ep *endpoint) {
+		ep.primary = true
+	}
+}
+
+// CreateOptionMacFromIP function returns an option setter to create the endpoint
+// with the MAC address of its interface derived from its IPv4 address, as 02:42
+// followed by the address bytes, instead of leaving it to the driver. The MAC
+// address follows the endpoint address when it is renumbered. An explicit MAC
+// address passed with the netlabel.MacAddress generic option takes precedence.
+func CreateOptionMacFromIP() EndpointOption {
+	return func(ep *endpoint) {
+		ep.macFromIP = true
+	}
+}
+
 // CreateOptionPortMapping function returns an option setter for the mapping
 // ports option to be passed to network.CreateEndpoint() method.
 func CreateOptionPortMapping(portBindings []types.PortBinding) EndpointOption {
@@ -504,6 +1536,227 @@ func CreateOptionPortMapping(portBindings []types.PortBinding) EndpointOption {
 	}
 }
 
+// CreateOptionPortMappingClaimOnly function returns an option setter to create
+// the endpoint with the host ports of its CreateOptionPortMapping bindings
+// reserved but not forwarded: no DNAT rule is installed until
+// Endpoint.ActivatePortBindings is called. Deleting the endpoint frees the ports.
+func CreateOptionPortMappingClaimOnly() EndpointOption {
+	return func(ep *endpoint) {
+		ep.generic[netlabel.PortMapClaimOnly] = true
+	}
+}
+
+// CreateOptionPortMappingTarget function returns an option setter for the
+// sibling endpoint the port mappings of the endpoint are forwarded to, in place
+// of the endpoint itself. The target must be on the same network and, once both
+// are joined, in the same sandbox.
+func CreateOptionPortMappingTarget(target Endpoint) EndpointOption {
+	return func(ep *endpoint) {
+		ep.portTarget = target.ID()
+	}
+}
+
+// CreateOptionACL function returns an option setter for the access control
+// rules on the traffic reaching the endpoint, to be passed to the
+// network.CreateEndpoint() method. Rules are evaluated in order.
+func CreateOptionACL(rules []types.ACLRule) EndpointOption {
+	return func(ep *endpoint) {
+		// Store a copy of the rules as generic data to pass to the driver
+		acl := make([]types.ACLRule, 0, len(rules))
+		for _, r := range rules {
+			acl = append(acl, r.GetCopy())
+		}
+		ep.generic[netlabel.ACL] = acl
+	}
+}
+
+// CreateOptionDSCP function returns an option setter for the DSCP value (0-63)
+// to mark the packets sourced from the endpoint with, to be passed to the
+// network.CreateEndpoint() method.
+func CreateOptionDSCP(value int) EndpointOption {
+	return func(ep *endpoint) {
+		ep.generic[netlabel.DSCP] = value
+	}
+}
+
+// CreateOptionConnLimit function returns an option setter for the maximum
+// number of concurrent TCP connections to the endpoint, beyond which new
+// connections are rejected, to be passed to the network.CreateEndpoint() method.
+func CreateOptionConnLimit(limit int) EndpointOption {
+	return func(ep *endpoint) {
+		ep.generic[netlabel.ConnLimit] = limit
+	}
+}
+
+// CreateOptionAddressGroup function returns an option setter for the address
+// group of the endpoint, like the replicas of a service. The driver gives the
+// endpoints of a group their address from distinct sub-ranges of the network
+// while it can, then any free address. The bridge driver spreads them across
+// the /28 sub-ranges.
+func CreateOptionAddressGroup(group string) EndpointOption {
+	return func(ep *endpoint) {
+		ep.generic[netlabel.AddressGroup] = group
+	}
+}
+
+// CreateOptionVIP function returns an option setter for a virtual address
+// configured on the network bridge for the endpoint to own, to be passed to
+// the network.CreateEndpoint() method. The traffic to the virtual address is
+// translated to the endpoint address while the endpoint is joined.
+func CreateOptionVIP(vip net.IP) EndpointOption {
+	return func(ep *endpoint) {
+		ep.generic[netlabel.VIP] = types.GetIPCopy(vip)
+	}
+}
+
+// CreateOptionTxQueueLen function returns an option setter for the transmit
+// queue length of the endpoint interfaces, to be passed to the
+// network.CreateEndpoint() method. A length of 0 leaves the driver default.
+func CreateOptionTxQueueLen(qlen int) EndpointOption {
+	return func(ep *endpoint) {
+		ep.generic[netlabel.TxQueueLen] = qlen
+	}
+}
+
+// CreateOptionOffload function returns an option setter to turn the named
+// offload feature (e.g. "tso", "gso", "gro") on or off on the endpoint
+// interfaces, to be passed to the network.CreateEndpoint() method.
+func CreateOptionOffload(feature string, on bool) EndpointOption {
+	return func(ep *endpoint) {
+		offloads, ok := ep.generic[netlabel.Offloads].(map[string]bool)
+		if !ok {
+			offloads = make(map[string]bool)
+			ep.generic[netlabel.Offloads] = offloads
+		}
+		offloads[feature] = on
+	}
+}
+
+// CreateOptionLazy function returns an option setter for the experimental lazy
+// creation option to be passed to network.CreateEndpoint() method. The driver
+// reserves the endpoint addresses but only creates its interfaces when the
+// endpoint is activated with Sandbox.Activate(), so all its joins are lazy.
+func CreateOptionLazy() EndpointOption {
+	return func(ep *endpoint) {
+		ep.generic[netlabel.LazyLink] = true
+	}
+}
+
+// JoinOptionLazy function returns an option setter for the experimental lazy
+// join option to be passed to the endpoint.Join() method. The endpoint's
+// interfaces are not moved into the sandbox until Sandbox.Activate() is called.
+func JoinOptionLazy() EndpointOption {
+	return func(ep *endpoint) {
+		ep.lazyJoin = true
+	}
+}
+
+// JoinOptionRoute function returns an option setter for a static route to the
+// destination through the next hop, programmed with the passed metric in the
+// sandbox on Join. A metric of 0 leaves the kernel default.
+func JoinOptionRoute(destination *net.IPNet, nextHop net.IP, metric int) EndpointOption {
+	return func(ep *endpoint) {
+		if ep.joinInfo == nil {
+			return
+		}
+		r := &types.StaticRoute{
+			Destination: types.GetIPNetCopy(destination),
+			RouteType:   types.NEXTHOP,
+			NextHop:     types.GetIPCopy(nextHop),
+			Metric:      metric,
+		}
+		ep.joinInfo.StaticRoutes = append(ep.joinInfo.StaticRoutes, r)
+		ep.joinInfo.optionRoutes++
+	}
+}
+
+// JoinOptionRoutingTable function returns an option setter for the routing table,
+// other than main, the sandbox traffic sourced from the endpoint address is routed
+// with. The table gets the route to the endpoint subnet, the default route through
+// the endpoint gateway and the endpoint static routes on Join.
+func JoinOptionRoutingTable(table int) EndpointOption {
+	return func(ep *endpoint) {
+		if ep.joinInfo == nil {
+			return
+		}
+		ep.joinInfo.routingTable = table
+	}
+}
+
+// JoinOptionLink function returns an option setter for a hosts file entry of the
+// sandbox resolving the alias to the target endpoint address. The entry follows
+// the target address changes and is removed on Leave. It is not a DNS record.
+func JoinOptionLink(target Endpoint, alias string) EndpointOption {
+	return func(ep *endpoint) {
+		if ep.joinInfo == nil {
+			return
+		}
+		t, ok := target.(*endpoint)
+		if !ok || alias == "" {
+			log.Errorf("Invalid link %q to endpoint %v passed to the join of endpoint %s", alias, target, ep.name)
+			return
+		}
+		ep.joinInfo.links = append(ep.joinInfo.links, endpointLink{target: t, alias: alias})
+	}
+}
+
+// JoinOptionLeavePriority function returns an option setter for the order the
+// endpoint leaves the sandbox in when the sandbox is deleted, to be passed to
+// the endpoint.Join() method. Endpoints with a higher priority leave first,
+// the ones of a same priority leave by network name. The default priority is 0.
+func JoinOptionLeavePriority(prio int) EndpointOption {
+	return func(ep *endpoint) {
+		if ep.joinInfo == nil {
+			return
+		}
+		ep.joinInfo.leavePriority = prio
+	}
+}
+
+// JoinOptionPreLeaveHook function returns an option setter for a function run
+// when the endpoint leaves the sandbox, before it is detached, to be passed to
+// the endpoint.Join() method. A hook failure is logged and does not prevent the
+// endpoint from leaving.
+func JoinOptionPreLeaveHook(hook LeaveHook) EndpointOption {
+	return func(ep *endpoint) {
+		if ep.joinInfo == nil {
+			return
+		}
+		ep.joinInfo.preLeave = hook
+	}
+}
+
+// JoinOptionParentSandbox function returns an option setter for the sandbox of
+// the container the joining one is nested in, to be passed to the endpoint.Join()
+// method. The endpoint interfaces are placed in the network namespace of the
+// parent sandbox, where the nested container runtime picks them up, while the
+// joined sandbox resolves the endpoint names as usual.
+func JoinOptionParentSandbox(parent Sandbox) EndpointOption {
+	return func(ep *endpoint) {
+		if ep.joinInfo == nil {
+			return
+		}
+		ep.joinInfo.parent = parent
+	}
+}
+
+// JoinOptionCorrelationID function returns an option setter for the ID the log
+// entries of the join are tagged with, to be passed to the endpoint.Join() method.
+// It lets the caller correlate them with the ones of the other subsystems.
+func JoinOptionCorrelationID(id string) EndpointOption {
+	return func(ep *endpoint) {
+		ep.correlationID = id
+	}
+}
+
+// LeaveOptionCorrelationID function returns an option setter for the ID the log
+// entries of the leave are tagged with, to be passed to the endpoint.Leave() method.
+func LeaveOptionCorrelationID(id string) EndpointOption {
+	return func(ep *endpoint) {
+		ep.correlationID = id
+	}
+}
+
 // JoinOptionPriority function returns an option setter for priority option to
 // be passed to the endpoint.Join() method.
 func JoinOptionPriority(ep Endpoint, prio int) EndpointOption {
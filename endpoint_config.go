@@ -0,0 +1,171 @@
+package libnetwork
+
+import (
+	"io/ioutil"
+	"net"
+
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/resolvconf"
+	"github.com/docker/libnetwork/types"
+)
+
+// ConfigSource is the layer a setting of the effective endpoint configuration
+// comes from
+type ConfigSource string
+
+const (
+	// ConfigSourceDefault marks a setting left to its default: the kernel one,
+	// or the host one for the DNS settings
+	ConfigSourceDefault ConfigSource = "default"
+	// ConfigSourceNetwork marks a setting of the network options
+	ConfigSourceNetwork ConfigSource = "network"
+	// ConfigSourceEndpoint marks a setting of the endpoint creation options
+	ConfigSourceEndpoint ConfigSource = "endpoint"
+	// ConfigSourceJoin marks a setting of the join options
+	ConfigSourceJoin ConfigSource = "join"
+	// ConfigSourceSandbox marks a setting of the sandbox options
+	ConfigSourceSandbox ConfigSource = "sandbox"
+	// ConfigSourceDriver marks a setting the network driver, or its ipam, chose
+	ConfigSourceDriver ConfigSource = "driver"
+)
+
+// EffectiveConfig is the configuration libnetwork applied to an endpoint once
+// its network, creation, join and sandbox options are merged. The interface
+// settings are the ones of the endpoint first interface. The gateways, DNS and
+// static routes are only known once the endpoint is joined.
+type EffectiveConfig struct {
+	// MTU is the MTU of the endpoint interface, 0 if left to the kernel default.
+	MTU          int
+	MacAddress   net.HardwareAddr
+	Address      *net.IPNet
+	AddressIPv6  *net.IPNet
+	Gateway      net.IP
+	GatewayIPv6  net.IP
+	DNS          []string
+	DNSSearch    []string
+	DNSForward   map[string][]string
+	StaticRoutes []*types.StaticRoute
+
+	// Sources maps the name of each set field above, but DNSForward and
+	// StaticRoutes, to the layer which set it. For DNSSearch, it is the layer
+	// of the first search domains.
+	Sources map[string]ConfigSource
+	// DNSForwardSources maps the domains of DNSForward to the layer of their rule.
+	DNSForwardSources map[string]ConfigSource
+	// RouteSources holds the layer of each of StaticRoutes, at the same index.
+	RouteSources []ConfigSource
+}
+
+func (ep *endpoint) EffectiveConfig() (*EffectiveConfig, error) {
+	info, err := ep.DriverInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &EffectiveConfig{
+		Sources:           make(map[string]ConfigSource),
+		DNSForward:        make(map[string][]string),
+		DNSForwardSources: make(map[string]ConfigSource),
+	}
+
+	cfg.Sources["MTU"] = ConfigSourceDefault
+	if mtu, ok := info[netlabel.MTU].(int); ok {
+		cfg.MTU = mtu
+		cfg.Sources["MTU"] = ConfigSourceNetwork
+		if clamped, _ := info[netlabel.MTUClamped].(bool); clamped {
+			cfg.Sources["MTU"] = ConfigSourceDriver
+		}
+	}
+
+	n := ep.getNetwork()
+	n.Lock()
+	netGw := n.gateway
+	n.Unlock()
+
+	ep.Lock()
+	_, explicitMac := ep.generic[netlabel.MacAddress].(net.HardwareAddr)
+	_, explicitIP := ep.generic[netlabel.IPAddress].(net.IP)
+	if len(ep.iFaces) != 0 {
+		iface := ep.iFaces[0]
+		cfg.MacAddress = types.GetMacCopy(iface.mac)
+		cfg.Sources["MacAddress"] = ConfigSourceDriver
+		if explicitMac || ep.macFromIP {
+			cfg.Sources["MacAddress"] = ConfigSourceEndpoint
+		}
+		if len(iface.addr.IP) != 0 {
+			cfg.Address = types.GetIPNetCopy(&iface.addr)
+			cfg.Sources["Address"] = ConfigSourceDriver
+			if explicitIP {
+				cfg.Sources["Address"] = ConfigSourceEndpoint
+			}
+		}
+		if len(iface.addrv6.IP) != 0 {
+			cfg.AddressIPv6 = types.GetIPNetCopy(&iface.addrv6)
+			cfg.Sources["AddressIPv6"] = ConfigSourceDriver
+		}
+	}
+	if ji := ep.joinInfo; ji != nil {
+		if len(ji.gw) != 0 {
+			cfg.Gateway = types.GetIPCopy(ji.gw)
+			cfg.Sources["Gateway"] = ConfigSourceDriver
+			if netGw != nil && netGw.Equal(ji.gw) {
+				cfg.Sources["Gateway"] = ConfigSourceNetwork
+			}
+		}
+		if len(ji.gw6) != 0 {
+			cfg.GatewayIPv6 = types.GetIPCopy(ji.gw6)
+			cfg.Sources["GatewayIPv6"] = ConfigSourceDriver
+		}
+		for i, r := range ji.StaticRoutes {
+			cfg.StaticRoutes = append(cfg.StaticRoutes, r.GetCopy())
+			// The join options are processed before the driver adds its routes
+			if i < ji.optionRoutes {
+				cfg.RouteSources = append(cfg.RouteSources, ConfigSourceJoin)
+			} else {
+				cfg.RouteSources = append(cfg.RouteSources, ConfigSourceDriver)
+			}
+		}
+	}
+	epFwd := ep.dnsForward
+	ep.Unlock()
+
+	// The rule of the endpoint wins over the one of its network
+	for _, l := range []struct {
+		rules  map[string][]string
+		source ConfigSource
+	}{{epFwd, ConfigSourceEndpoint}, {n.getDNSForward(), ConfigSourceNetwork}} {
+		for d, servers := range l.rules {
+			if _, ok := cfg.DNSForward[d]; !ok {
+				cfg.DNSForward[d] = append([]string(nil), servers...)
+				cfg.DNSForwardSources[d] = l.source
+			}
+		}
+	}
+
+	sb, ok := ep.getSandbox()
+	if !ok {
+		return cfg, nil
+	}
+
+	rc, err := ioutil.ReadFile(sb.config.resolvConfPath)
+	if err != nil {
+		return nil, err
+	}
+	cfg.DNS = resolvconf.GetNameservers(rc)
+	cfg.Sources["DNS"] = ConfigSourceDefault
+	if len(sb.config.dnsList) != 0 {
+		cfg.Sources["DNS"] = ConfigSourceSandbox
+	}
+	cfg.DNSSearch = resolvconf.GetSearchDomains(rc)
+	switch {
+	case len(sb.config.dnsSearchList) != 0:
+		cfg.Sources["DNSSearch"] = ConfigSourceSandbox
+	case len(sb.config.dnsSearchBase) == 0 && len(cfg.DNSSearch) != 0:
+		// Only the domains of the joined networks are listed
+		cfg.Sources["DNSSearch"] = ConfigSourceNetwork
+	default:
+		cfg.Sources["DNSSearch"] = ConfigSourceDefault
+	}
+
+	return cfg, nil
+}
@@ -2,9 +2,13 @@ package libnetwork
 
 import (
 	"encoding/json"
+	"io/ioutil"
 	"net"
 
+	log "github.com/Sirupsen/logrus"
 	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/resolvconf"
 	"github.com/docker/libnetwork/types"
 )
 
@@ -24,6 +28,46 @@ type EndpointInfo interface {
 
 	// Sandbox returns the attached sandbox if there, nil otherwise.
 	Sandbox() Sandbox
+
+	// InterfacesJSON returns the JSON encoded list of the endpoint interfaces,
+	// in the InterfaceJSON schema. Name and MTU are only known once the
+	// interface has been programmed in the sandbox.
+	InterfacesJSON() ([]byte, error)
+
+	// GatewayMAC returns the link layer address of the IPv4 gateway, as resolved
+	// by the kernel in the sandbox. ErrNoContainer is returned if no container
+	// has joined the endpoint.
+	GatewayMAC() (net.HardwareAddr, error)
+}
+
+// InterfaceJSON is the serialization schema of an endpoint interface. It does
+// not depend on the internal types so that it can be exposed as is by a REST layer.
+type InterfaceJSON struct {
+	Name string `json:"name"`
+	MAC  string `json:"mac"`
+	IPv4 string `json:"ipv4"`
+	IPv6 string `json:"ipv6"`
+	MTU  int    `json:"mtu"`
+}
+
+// JoinResult is the configuration an endpoint join programmed in the sandbox,
+// as the container needs it before its process starts.
+type JoinResult struct {
+	Interfaces   []JoinInterface
+	Gateway      net.IP
+	GatewayIPv6  net.IP
+	DNS          []string
+	DNSSearch    []string
+	StaticRoutes []*types.StaticRoute
+}
+
+// JoinInterface describes an endpoint interface programmed in the sandbox. Name
+// is empty as long as the interface of a lazily joined endpoint is not programmed.
+type JoinInterface struct {
+	Name        string
+	MacAddress  net.HardwareAddr
+	Address     *net.IPNet
+	AddressIPv6 *net.IPNet
 }
 
 // InterfaceInfo provides an interface to retrieve interface addresses bound to the endpoint.
@@ -36,6 +80,10 @@ type InterfaceInfo interface {
 
 	// AddressIPv6 returns the IPv6 address assigned to the endpoint.
 	AddressIPv6() net.IPNet
+
+	// AddressNet returns the IPv4 subnet the endpoint address was allocated
+	// from, with the prefix length of the address.
+	AddressNet() *net.IPNet
 }
 
 type endpointInterface struct {
@@ -105,9 +153,32 @@ func (epi *endpointInterface) UnmarshalJSON(b []byte) (err error) {
 }
 
 type endpointJoinInfo struct {
-	gw           net.IP
-	gw6          net.IP
-	StaticRoutes []*types.StaticRoute
+	gw            net.IP
+	gw6           net.IP
+	StaticRoutes  []*types.StaticRoute
+	optionRoutes  int // leading StaticRoutes set through JoinOptionRoute
+	routingTable  int
+	links         []endpointLink
+	leavePriority int
+	preLeave      LeaveHook
+	parent        Sandbox
+}
+
+// parentSandbox returns the sandbox whose namespace the endpoint interfaces are
+// placed in instead of the joined one, nil if there is none
+func (ji *endpointJoinInfo) parentSandbox() *sandbox {
+	if ji == nil {
+		return nil
+	}
+	p, _ := ji.parent.(*sandbox)
+	return p
+}
+
+// endpointLink is the hosts file entry of the joining sandbox for the address of
+// another endpoint, under an alias
+type endpointLink struct {
+	target *endpoint
+	alias  string
 }
 
 func (ep *endpoint) Info() EndpointInfo {
@@ -118,6 +189,7 @@ func (ep *endpoint) DriverInfo() (map[string]interface{}, error) {
 	ep.Lock()
 	network := ep.network
 	epid := ep.id
+	promisc := ep.promiscuous
 	ep.Unlock()
 
 	network.Lock()
@@ -125,7 +197,26 @@ func (ep *endpoint) DriverInfo() (map[string]interface{}, error) {
 	nid := network.id
 	network.Unlock()
 
-	return driver.EndpointOperInfo(nid, epid)
+	info, err := driver.EndpointOperInfo(nid, epid)
+	if err != nil {
+		return nil, err
+	}
+
+	if sb, ok := ep.getSandbox(); ok && sb.config.netClsClassID != 0 {
+		if info == nil {
+			info = make(map[string]interface{})
+		}
+		info[netlabel.NetClsClassID] = sb.config.netClsClassID
+	}
+
+	if promisc {
+		if info == nil {
+			info = make(map[string]interface{})
+		}
+		info[netlabel.Promiscuous] = true
+	}
+
+	return info, nil
 }
 
 func (ep *endpoint) InterfaceList() []InterfaceInfo {
@@ -141,6 +232,132 @@ func (ep *endpoint) InterfaceList() []InterfaceInfo {
 	return iList
 }
 
+func (ep *endpoint) InterfacesJSON() ([]byte, error) {
+	ep.Lock()
+	ifaces := make([]InterfaceJSON, len(ep.iFaces))
+	srcNames := make([]string, len(ep.iFaces))
+	for i, iface := range ep.iFaces {
+		ifaces[i].MAC = iface.mac.String()
+		if len(iface.addr.IP) != 0 {
+			ifaces[i].IPv4 = iface.addr.String()
+		}
+		if len(iface.addrv6.IP) != 0 {
+			ifaces[i].IPv6 = iface.addrv6.String()
+		}
+		srcNames[i] = iface.srcName
+	}
+	ep.Unlock()
+
+	if sb, ok := ep.getSandbox(); ok && sb.osSbox != nil {
+		for _, si := range sb.osSbox.Info().Interfaces() {
+			for i, srcName := range srcNames {
+				if srcName == "" || si.SrcName() != srcName {
+					continue
+				}
+				ifaces[i].Name = si.DstName()
+				var err error
+				if nErr := sb.osSbox.InvokeFunc(func() {
+					ifaces[i].MTU, err = getLinkMTU(si.DstName())
+				}); nErr != nil {
+					return nil, nErr
+				}
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	return json.Marshal(ifaces)
+}
+
+// joinResult returns the configuration the endpoint join programmed in the sandbox
+func (ep *endpoint) joinResult(sb *sandbox) *JoinResult {
+	res := &JoinResult{}
+
+	ep.Lock()
+	srcNames := make([]string, len(ep.iFaces))
+	for i, iface := range ep.iFaces {
+		ji := JoinInterface{MacAddress: types.GetMacCopy(iface.mac)}
+		if len(iface.addr.IP) != 0 {
+			ji.Address = types.GetIPNetCopy(&iface.addr)
+		}
+		if len(iface.addrv6.IP) != 0 {
+			ji.AddressIPv6 = types.GetIPNetCopy(&iface.addrv6)
+		}
+		res.Interfaces = append(res.Interfaces, ji)
+		srcNames[i] = iface.srcName
+	}
+	if ep.joinInfo != nil {
+		res.Gateway = types.GetIPCopy(ep.joinInfo.gw)
+		res.GatewayIPv6 = types.GetIPCopy(ep.joinInfo.gw6)
+		for _, r := range ep.joinInfo.StaticRoutes {
+			res.StaticRoutes = append(res.StaticRoutes, r.GetCopy())
+		}
+	}
+	ep.Unlock()
+
+	if sb.osSbox != nil {
+		for _, si := range sb.osSbox.Info().Interfaces() {
+			for i, srcName := range srcNames {
+				if srcName != "" && si.SrcName() == srcName {
+					res.Interfaces[i].Name = si.DstName()
+				}
+			}
+		}
+	}
+
+	if rc, err := ioutil.ReadFile(sb.config.resolvConfPath); err == nil {
+		res.DNS = resolvconf.GetNameservers(rc)
+		res.DNSSearch = resolvconf.GetSearchDomains(rc)
+	} else {
+		log.Warnf("Failed to read the resolv.conf of sandbox %s: %v", sb.ID(), err)
+	}
+
+	return res
+}
+
+func (ep *endpoint) GatewayMAC() (net.HardwareAddr, error) {
+	ep.Lock()
+	var srcName string
+	if len(ep.iFaces) != 0 {
+		srcName = ep.iFaces[0].srcName
+	}
+	ep.Unlock()
+
+	sb, ok := ep.getSandbox()
+	if !ok || sb.osSbox == nil {
+		return nil, ErrNoContainer{}
+	}
+
+	gw := ep.Gateway()
+	if len(gw) == 0 {
+		return nil, types.NotFoundErrorf("endpoint %s has no gateway", ep.Name())
+	}
+
+	var name string
+	for _, si := range sb.osSbox.Info().Interfaces() {
+		if srcName != "" && si.SrcName() == srcName {
+			name = si.DstName()
+		}
+	}
+	if name == "" {
+		return nil, types.NotFoundErrorf("endpoint %s interface not found in the sandbox", ep.Name())
+	}
+
+	var (
+		mac net.HardwareAddr
+		err error
+	)
+	if nErr := sb.osSbox.InvokeFunc(func() {
+		mac, err = getNeighborMAC(name, gw)
+	}); nErr != nil {
+		return nil, nErr
+	}
+
+	return mac, err
+}
+
 func (ep *endpoint) Interfaces() []driverapi.InterfaceInfo {
 	ep.Lock()
 	defer ep.Unlock()
@@ -185,6 +402,13 @@ func (epi *endpointInterface) AddressIPv6() net.IPNet {
 	return (*types.GetIPNetCopy(&epi.addrv6))
 }
 
+func (epi *endpointInterface) AddressNet() *net.IPNet {
+	if epi.addr.IP == nil {
+		return nil
+	}
+	return types.GetIPNetCanonical(&epi.addr)
+}
+
 func (epi *endpointInterface) SetNames(srcName string, dstPrefix string) error {
 	epi.srcName = srcName
 	epi.dstPrefix = dstPrefix
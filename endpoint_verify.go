@@ -0,0 +1,197 @@
+package libnetwork
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/docker/libnetwork/types"
+)
+
+// Discrepancy describes a difference between the intended configuration of an
+// endpoint and the one found in the network namespace of its sandbox, or
+// between the host resources of a network and the ones its driver configured.
+type Discrepancy struct {
+	// Interface is the interface the resource belongs to, empty for the
+	// sandbox wide resources such as the gateway and the static routes.
+	Interface string
+	// Resource is the kind of resource: interface, address, route or gateway.
+	Resource string
+	Expected string
+	Actual   string
+}
+
+func (d Discrepancy) String() string {
+	if d.Interface == "" {
+		return fmt.Sprintf("%s: expected %s, found %s", d.Resource, d.Expected, d.Actual)
+	}
+	return fmt.Sprintf("%s %s: expected %s, found %s", d.Interface, d.Resource, d.Expected, d.Actual)
+}
+
+// netRoute is a route found in the network namespace of a sandbox
+type netRoute struct {
+	iface string
+	dst   *net.IPNet
+	gw    net.IP
+}
+
+// netState is the network configuration found in the network namespace of a
+// sandbox. Links are keyed by name and map to the addresses configured on them.
+type netState struct {
+	links  map[string][]*net.IPNet
+	routes []netRoute
+}
+
+func (ep *endpoint) Verify() ([]Discrepancy, error) {
+	sb, ok := ep.getSandbox()
+	if !ok {
+		return nil, types.ForbiddenErrorf("endpoint %s is not joined to a sandbox", ep.Name())
+	}
+
+	sb.Lock()
+	_, lazy := sb.lazyEps[ep.ID()]
+	gwEp := len(sb.endpoints) > 0 && sb.endpoints[0] == ep
+	osSbox := sb.osSbox
+	sb.Unlock()
+
+	// Nothing is expected in the sandbox until the endpoint gets activated
+	if lazy {
+		return nil, nil
+	}
+
+	ep.Lock()
+	ifaces := ep.iFaces
+	joinInfo := ep.joinInfo
+	ep.Unlock()
+
+	dstNames := make(map[string]string)
+	for _, i := range osSbox.Info().Interfaces() {
+		dstNames[i.SrcName()] = i.DstName()
+	}
+
+	var (
+		state *netState
+		err   error
+	)
+	// The endpoint routes go to its own routing table if it was joined with one
+	table := 0
+	if joinInfo != nil {
+		table = joinInfo.routingTable
+	}
+	if nErr := osSbox.InvokeFunc(func() {
+		state, err = getNetState(table)
+	}); nErr != nil {
+		return nil, nErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the network state of sandbox %s: %v", sb.ID(), err)
+	}
+
+	var dl []Discrepancy
+	for _, i := range ifaces {
+		name, ok := dstNames[i.srcName]
+		if ok {
+			_, ok = state.links[name]
+		}
+		if !ok {
+			dl = append(dl, Discrepancy{Interface: i.srcName, Resource: "interface", Expected: "present", Actual: "none"})
+			continue
+		}
+
+		var addrs []*net.IPNet
+		if len(i.addr.IP) != 0 {
+			addrs = append(addrs, &i.addr)
+		}
+		if i.addrv6.IP.To16() != nil {
+			addrs = append(addrs, &i.addrv6)
+		}
+		for _, a := range addrs {
+			if !state.hasAddress(name, a) {
+				dl = append(dl, Discrepancy{Interface: name, Resource: "address", Expected: a.String(), Actual: state.addresses(name)})
+			}
+		}
+
+		for _, r := range i.routes {
+			if !state.hasRoute(name, r, nil) {
+				dl = append(dl, Discrepancy{Interface: name, Resource: "route", Expected: r.String(), Actual: "none"})
+			}
+		}
+	}
+
+	if joinInfo == nil {
+		return dl, nil
+	}
+
+	for _, r := range joinInfo.StaticRoutes {
+		if !state.hasRoute("", r.Destination, r.NextHop) {
+			dl = append(dl, Discrepancy{Resource: "route", Expected: fmt.Sprintf("%s via %s", r.Destination, r.NextHop), Actual: "none"})
+		}
+	}
+
+	if !gwEp {
+		return dl, nil
+	}
+
+	for _, gw := range []net.IP{joinInfo.gw, joinInfo.gw6} {
+		if len(gw) == 0 || state.hasRoute("", nil, gw) {
+			continue
+		}
+		dl = append(dl, Discrepancy{Resource: "gateway", Expected: gw.String(), Actual: state.gateways(gw.To4() != nil)})
+	}
+
+	return dl, nil
+}
+
+// hasAddress tells whether addr is configured on iface. Here and in hasRoute
+// networks are compared in string form, as the kernel reports the IPv4 ones
+// with 4 bytes addresses and masks while libnetwork may hold 16 bytes ones.
+func (s *netState) hasAddress(iface string, addr *net.IPNet) bool {
+	for _, a := range s.links[iface] {
+		if a.String() == addr.String() {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *netState) addresses(iface string) string {
+	var al []string
+	for _, a := range s.links[iface] {
+		al = append(al, a.String())
+	}
+	if len(al) == 0 {
+		return "none"
+	}
+	return strings.Join(al, ",")
+}
+
+// hasRoute tells whether a route to dst through the optional gw exists. An
+// empty iface matches the routes on any interface, a nil dst the default ones.
+func (s *netState) hasRoute(iface string, dst *net.IPNet, gw net.IP) bool {
+	for _, r := range s.routes {
+		if iface != "" && r.iface != iface {
+			continue
+		}
+		if (dst == nil) != (r.dst == nil) || dst != nil && dst.String() != r.dst.String() {
+			continue
+		}
+		if gw != nil && !gw.Equal(r.gw) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+func (s *netState) gateways(v4 bool) string {
+	var gl []string
+	for _, r := range s.routes {
+		if r.dst == nil && r.gw != nil && (r.gw.To4() != nil) == v4 {
+			gl = append(gl, r.gw.String())
+		}
+	}
+	if len(gl) == 0 {
+		return "none"
+	}
+	return strings.Join(gl, ",")
+}
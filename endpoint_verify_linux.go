@@ -0,0 +1,159 @@
+package libnetwork
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+)
+
+const (
+	neighResolveAttempts = 30
+	neighResolveInterval = 100 * time.Millisecond
+	// The kernel gives up resolving after a few probes a second apart, the
+	// resolution is triggered again every neighResolveTrigger attempts
+	neighResolveTrigger = 10
+)
+
+// getNetState reads the links, addresses and routes of the network namespace
+// the calling thread is in. The routes are read from the main routing table,
+// and from the passed one if not zero.
+func getNetState(table int) (*netState, error) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, err
+	}
+
+	state := &netState{links: make(map[string][]*net.IPNet)}
+	names := make(map[int]string, len(links))
+	for _, l := range links {
+		name := l.Attrs().Name
+		names[l.Attrs().Index] = name
+
+		addrs, err := netlink.AddrList(l, netlink.FAMILY_ALL)
+		if err != nil {
+			return nil, err
+		}
+		state.links[name] = make([]*net.IPNet, 0, len(addrs))
+		for _, a := range addrs {
+			state.links[name] = append(state.links[name], a.IPNet)
+		}
+	}
+
+	routes, err := netlink.RouteList(nil, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range routes {
+		state.routes = append(state.routes, netRoute{iface: names[r.LinkIndex], dst: r.Dst, gw: r.Gw})
+	}
+
+	if table != 0 {
+		routes, err := tableRoutes(table)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range routes {
+			state.routes = append(state.routes, netRoute{iface: names[r.LinkIndex], dst: r.Dst, gw: r.Gw})
+		}
+	}
+
+	return state, nil
+}
+
+// tableRoutes lists the IPv4 routes of the routing table, which the vendored
+// netlink skips as it only reads the main table.
+func tableRoutes(table int) ([]netlink.Route, error) {
+	req := nl.NewNetlinkRequest(syscall.RTM_GETROUTE, syscall.NLM_F_DUMP)
+	req.AddData(&nl.RtMsg{RtMsg: syscall.RtMsg{Family: syscall.AF_INET}})
+
+	msgs, err := req.Execute(syscall.NETLINK_ROUTE, syscall.RTM_NEWROUTE)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []netlink.Route
+	for _, m := range msgs {
+		msg := nl.DeserializeRtMsg(m)
+		if msg.Flags&syscall.RTM_F_CLONED != 0 {
+			continue
+		}
+		attrs, err := nl.ParseRouteAttr(m[msg.Len():])
+		if err != nil {
+			return nil, err
+		}
+
+		// Table ids above 255 are only carried by the table attribute
+		rtable := int(msg.Table)
+		var r netlink.Route
+		for _, a := range attrs {
+			switch a.Attr.Type {
+			case syscall.RTA_TABLE:
+				rtable = int(nl.NativeEndian().Uint32(a.Value[0:4]))
+			case syscall.RTA_GATEWAY:
+				r.Gw = net.IP(a.Value)
+			case syscall.RTA_DST:
+				r.Dst = &net.IPNet{IP: net.IP(a.Value), Mask: net.CIDRMask(int(msg.Dst_len), 8*len(a.Value))}
+			case syscall.RTA_OIF:
+				r.LinkIndex = int(nl.NativeEndian().Uint32(a.Value[0:4]))
+			}
+		}
+		if rtable == table {
+			res = append(res, r)
+		}
+	}
+	return res, nil
+}
+
+// getLinkMTU returns the MTU of the named link in the network namespace the
+// calling thread is in.
+func getLinkMTU(name string) (int, error) {
+	l, err := netlink.LinkByName(name)
+	if err != nil {
+		return 0, err
+	}
+	return l.Attrs().MTU, nil
+}
+
+// getNeighborMAC returns the link layer address of the ip neighbor on the named
+// link in the network namespace the calling thread is in. If the kernel does not
+// know the neighbor yet, the resolution is triggered and awaited.
+func getNeighborMAC(name string, ip net.IP) (net.HardwareAddr, error) {
+	l, err := netlink.LinkByName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	family := netlink.FAMILY_V4
+	if ip.To4() == nil {
+		family = netlink.FAMILY_V6
+	}
+
+	for i := 0; i < neighResolveAttempts; i++ {
+		neighs, err := netlink.NeighList(l.Attrs().Index, family)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range neighs {
+			if n.IP.Equal(ip) && len(n.HardwareAddr) != 0 && n.State&(netlink.NUD_INCOMPLETE|netlink.NUD_FAILED) == 0 {
+				mac := make(net.HardwareAddr, len(n.HardwareAddr))
+				copy(mac, n.HardwareAddr)
+				return mac, nil
+			}
+		}
+
+		// Any packet to the neighbor gets the kernel to resolve it
+		if i%neighResolveTrigger == 0 {
+			if conn, err := net.Dial("udp", net.JoinHostPort(ip.String(), "9")); err == nil {
+				conn.Write([]byte{0})
+				conn.Close()
+			}
+		}
+		time.Sleep(neighResolveInterval)
+	}
+
+	return nil, fmt.Errorf("neighbor %s could not be resolved on %s", ip, name)
+}
@@ -0,0 +1,21 @@
+// +build !linux
+
+package libnetwork
+
+import (
+	"net"
+
+	"github.com/docker/libnetwork/types"
+)
+
+func getNetState(table int) (*netState, error) {
+	return nil, types.NotImplementedErrorf("endpoint verification is not supported on this platform")
+}
+
+func getLinkMTU(name string) (int, error) {
+	return 0, types.NotImplementedErrorf("link mtu is not supported on this platform")
+}
+
+func getNeighborMAC(name string, ip net.IP) (net.HardwareAddr, error) {
+	return nil, types.NotImplementedErrorf("neighbor resolution is not supported on this platform")
+}
@@ -2,6 +2,9 @@ package libnetwork
 
 import (
 	"fmt"
+
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/types"
 )
 
 // ErrNoSuchNetwork is returned when a network query finds no result
@@ -107,6 +110,21 @@ func (nnr NetworkNameError) Error() string {
 // Forbidden denotes the type of this error
 func (nnr NetworkNameError) Forbidden() {}
 
+// ErrQuotaExceeded is returned when a network is created with a label value
+// which already reached the number of networks its quota allows.
+type ErrQuotaExceeded struct {
+	Label string
+	Value string
+	Limit int
+}
+
+func (qe ErrQuotaExceeded) Error() string {
+	return fmt.Sprintf("network quota exceeded for %s=%s: limit is %d", qe.Label, qe.Value, qe.Limit)
+}
+
+// Forbidden denotes the type of this error
+func (qe ErrQuotaExceeded) Forbidden() {}
+
 // UnknownNetworkError is returned when libnetwork could not find in it's database
 // a network with the same name and id.
 type UnknownNetworkError struct {
@@ -135,6 +153,21 @@ func (aee *ActiveEndpointsError) Error() string {
 // Forbidden denotes the type of this error
 func (aee *ActiveEndpointsError) Forbidden() {}
 
+// ErrNetworkFull is returned when an endpoint is created on a network which
+// already holds its maximum number of endpoints.
+type ErrNetworkFull struct {
+	name string
+	id   string
+	max  uint64
+}
+
+func (nf *ErrNetworkFull) Error() string {
+	return fmt.Sprintf("network with name %s id %s reached its maximum of %d endpoints", nf.name, nf.id, nf.max)
+}
+
+// Forbidden denotes the type of this error
+func (nf *ErrNetworkFull) Forbidden() {}
+
 // UnknownEndpointError is returned when libnetwork could not find in it's database
 // an endpoint with the same name and id.
 type UnknownEndpointError struct {
@@ -173,3 +206,137 @@ func (id InvalidContainerIDError) Error() string {
 
 // BadRequest denotes the type of this error
 func (id InvalidContainerIDError) BadRequest() {}
+
+// ErrStoreUnavailable is returned when a write could not reach the datastore.
+// The operation can be retried once the datastore is back.
+type ErrStoreUnavailable struct {
+	err error
+}
+
+func (esu *ErrStoreUnavailable) Error() string {
+	return fmt.Sprintf("datastore is unavailable: %v", esu.err)
+}
+
+// Retry denotes the type of this error
+func (esu *ErrStoreUnavailable) Retry() {}
+
+// ErrEndpointDisabled is returned when a join is attempted on an endpoint
+// created disabled and not enabled yet.
+type ErrEndpointDisabled string
+
+func (ed ErrEndpointDisabled) Error() string {
+	return fmt.Sprintf("endpoint %s is disabled", string(ed))
+}
+
+// Forbidden denotes the type of this error
+func (ed ErrEndpointDisabled) Forbidden() {}
+
+// ErrSandboxConflict is returned when a sandbox is requested for a container
+// which already has one configured differently.
+type ErrSandboxConflict string
+
+func (sc ErrSandboxConflict) Error() string {
+	return fmt.Sprintf("container %s already has a sandbox with different options", string(sc))
+}
+
+// Forbidden denotes the type of this error
+func (sc ErrSandboxConflict) Forbidden() {}
+
+// ErrSharedNamespace is returned when the namespace identifier of a sandbox
+// sharing the host network namespace is requested.
+type ErrSharedNamespace string
+
+func (sn ErrSharedNamespace) Error() string {
+	return fmt.Sprintf("sandbox %s shares the host network namespace", string(sn))
+}
+
+// BadRequest denotes the type of this error
+func (sn ErrSharedNamespace) BadRequest() {}
+
+// ErrForeignSandbox is returned when a controller is asked to manage a sandbox
+// owned by another controller instance.
+type ErrForeignSandbox struct {
+	Sandbox string
+	Owner   string
+}
+
+func (fs ErrForeignSandbox) Error() string {
+	return fmt.Sprintf("sandbox %s is owned by controller %s", fs.Sandbox, fs.Owner)
+}
+
+// Forbidden denotes the type of this error
+func (fs ErrForeignSandbox) Forbidden() {}
+
+// ErrContainerPortConflict is returned when an endpoint joins a sandbox in which
+// another endpoint already exposes one of its container ports.
+type ErrContainerPortConflict struct {
+	Port     types.TransportPort
+	Endpoint string
+}
+
+func (pc ErrContainerPortConflict) Error() string {
+	return fmt.Sprintf("container port %d/%s is already exposed by endpoint %s in the sandbox", pc.Port.Port, pc.Port.Proto.String(), pc.Endpoint)
+}
+
+// Forbidden denotes the type of this error
+func (pc ErrContainerPortConflict) Forbidden() {}
+
+// ErrStartupCanceled is returned by NewWithContext when its context is done
+// before the controller initialization completes. Err holds the context error.
+type ErrStartupCanceled struct {
+	Err error
+}
+
+func (sc ErrStartupCanceled) Error() string {
+	return fmt.Sprintf("controller startup canceled: %v", sc.Err)
+}
+
+// ErrQueueNotSupported is returned when a network is set to queue the endpoint
+// creations once its ipam pool is exhausted, which is not supported.
+type ErrQueueNotSupported string
+
+func (qn ErrQueueNotSupported) Error() string {
+	return fmt.Sprintf("network %s: queueing the endpoint creations on ipam pool exhaustion is not supported", string(qn))
+}
+
+// NotImplemented denotes the type of this error
+func (qn ErrQueueNotSupported) NotImplemented() {}
+
+// mapDriverError reports a structured driver error as the kind of error its
+// code denotes. Other errors are returned unchanged.
+func mapDriverError(err error) error {
+	de, ok := err.(*driverapi.DriverError)
+	if !ok {
+		return err
+	}
+	switch de.Code {
+	case driverapi.ErrCodeBadRequest:
+		return driverBadRequest{de}
+	case driverapi.ErrCodeForbidden:
+		return driverForbidden{de}
+	case driverapi.ErrCodeNotFound:
+		return driverNotFound{de}
+	default:
+		return driverInternal{de}
+	}
+}
+
+type driverBadRequest struct{ *driverapi.DriverError }
+
+// BadRequest denotes the type of this error
+func (driverBadRequest) BadRequest() {}
+
+type driverForbidden struct{ *driverapi.DriverError }
+
+// Forbidden denotes the type of this error
+func (driverForbidden) Forbidden() {}
+
+type driverNotFound struct{ *driverapi.DriverError }
+
+// NotFound denotes the type of this error
+func (driverNotFound) NotFound() {}
+
+type driverInternal struct{ *driverapi.DriverError }
+
+// Internal denotes the type of this error
+func (driverInternal) Internal() {}
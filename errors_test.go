@@ -38,7 +38,7 @@ func TestErrorInterfaces(t *testing.T) {
 		}
 	}
 
-	forbiddenErrorList := []error{NetworkTypeError(""), &UnknownNetworkError{}, &UnknownEndpointError{}}
+	forbiddenErrorList := []error{NetworkTypeError(""), &UnknownNetworkError{}, &UnknownEndpointError{}, &ErrNetworkFull{}, ErrEndpointDisabled("")}
 	for _, err := range forbiddenErrorList {
 		switch u := err.(type) {
 		case types.ForbiddenError:
@@ -48,4 +48,14 @@ func TestErrorInterfaces(t *testing.T) {
 		}
 	}
 
+	retryErrorList := []error{&ErrStoreUnavailable{}}
+	for _, err := range retryErrorList {
+		switch u := err.(type) {
+		case types.RetryError:
+			return
+		default:
+			t.Fatalf("Failed to detect err %v is of type RetryError. Got type: %T", err, u)
+		}
+	}
+
 }
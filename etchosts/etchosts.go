@@ -7,6 +7,8 @@ import (
 	"io/ioutil"
 	"os"
 	"regexp"
+
+	"github.com/docker/libnetwork/fileutils"
 )
 
 // Record Structure for a single host record
@@ -63,7 +65,7 @@ func Build(path, IP, hostname, domainname string, extraContent []Record) error {
 		}
 	}
 
-	return ioutil.WriteFile(path, content.Bytes(), 0644)
+	return fileutils.WriteFile(path, content.Bytes(), 0644)
 }
 
 // Add adds an arbitrary number of Records to an already existing /etc/hosts file
@@ -90,7 +92,7 @@ func Add(path string, recs []Record) error {
 		}
 	}
 
-	return ioutil.WriteFile(path, content.Bytes(), 0644)
+	return fileutils.WriteFile(path, content.Bytes(), 0644)
 }
 
 // Delete deletes an arbitrary number of Records already existing in /etc/hosts file
@@ -110,7 +112,7 @@ func Delete(path string, recs []Record) error {
 	}
 
 	var re = regexp.MustCompile(regexpStr)
-	return ioutil.WriteFile(path, re.ReplaceAll(old, []byte("")), 0644)
+	return fileutils.WriteFile(path, re.ReplaceAll(old, []byte("")), 0644)
 }
 
 // Update all IP addresses where hostname matches.
@@ -123,5 +125,5 @@ func Update(path, IP, hostname string) error {
 		return err
 	}
 	var re = regexp.MustCompile(fmt.Sprintf("(\\S*)(\\t%s)", regexp.QuoteMeta(hostname)))
-	return ioutil.WriteFile(path, re.ReplaceAll(old, []byte(IP+"$2")), 0644)
+	return fileutils.WriteFile(path, re.ReplaceAll(old, []byte(IP+"$2")), 0644)
 }
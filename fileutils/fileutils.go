@@ -0,0 +1,130 @@
+// Package fileutils provides the helpers writing the files libnetwork manages
+// for the sandboxes, like their resolv.conf and hosts files.
+package fileutils
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+const (
+	// writeAttempts bounds the attempts of a write failing transiently
+	writeAttempts = 5
+	// retryDelay is the wait between two attempts
+	retryDelay = 10 * time.Millisecond
+)
+
+// filesystem is the set of file operations the writes go through
+type filesystem interface {
+	WriteFile(name string, data []byte, perm os.FileMode) error
+	Rename(oldpath, newpath string) error
+}
+
+type osFS struct{}
+
+// WriteFile writes the data over the file content, then truncates the file
+// to the data length. Unlike ioutil.WriteFile, it does not truncate the file
+// before writing, which would leave it empty until the write.
+func (osFS) WriteFile(name string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE, perm)
+	if err != nil {
+		return err
+	}
+	n, err := f.WriteAt(data, 0)
+	if err == nil && n < len(data) {
+		err = io.ErrShortWrite
+	}
+	if err == nil {
+		err = f.Truncate(int64(len(data)))
+	}
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
+
+func (osFS) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+// fs is the filesystem the files are written to. Tests replace it to inject
+// write failures.
+var fs filesystem = osFS{}
+
+// isTransient tells whether the failed operation may succeed if retried, as
+// happens on overlay and network filesystems
+func isTransient(err error) bool {
+	switch e := err.(type) {
+	case *os.PathError:
+		err = e.Err
+	case *os.LinkError:
+		err = e.Err
+	case *os.SyscallError:
+		err = e.Err
+	}
+	switch err {
+	case syscall.EINTR, syscall.EAGAIN, syscall.ESTALE:
+		return true
+	}
+	return false
+}
+
+// retry runs the operation until it succeeds, fails with a non transient
+// error or runs out of attempts
+func retry(op func() error) error {
+	var err error
+	for i := 0; i < writeAttempts; i++ {
+		if err = op(); err == nil || !isTransient(err) {
+			return err
+		}
+		time.Sleep(retryDelay)
+	}
+	return err
+}
+
+// WriteFile writes the data in place, keeping the file inode. It is used for
+// the files bind mounted in the containers, like their resolv.conf and hosts
+// files, which a rename would detach from the mounts. The write is not atomic:
+// the data is written over the old content before the file is truncated to
+// its length, so a reader never sees an empty file but may see the new data
+// followed by the end of a longer old content. The writes failing with a
+// transient error are retried a bounded number of times, each retry rewriting
+// the whole content.
+func WriteFile(filename string, data []byte, perm os.FileMode) error {
+	return retry(func() error { return fs.WriteFile(filename, data, perm) })
+}
+
+// AtomicWriteFile writes the data to a temporary file next to filename, then
+// renames it over filename, so that the file is never observed partially
+// written. The rename replaces the file inode, hence it is only meant for the
+// files which are not bind mounted, like the hash files. The writes failing
+// with a transient error are retried a bounded number of times.
+func AtomicWriteFile(filename string, data []byte, perm os.FileMode) error {
+	dir, base := filepath.Split(filename)
+	f, err := ioutil.TempFile(dir, "."+base)
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	f.Close()
+
+	// ioutil.TempFile creates the file with 0600 permissions
+	if err := os.Chmod(tmp, perm); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := retry(func() error { return fs.WriteFile(tmp, data, perm) }); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := retry(func() error { return fs.Rename(tmp, filename) }); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
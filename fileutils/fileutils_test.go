@@ -0,0 +1,217 @@
+package fileutils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// flakyFS fails the first writes and renames, leaving the written files
+// truncated like an interrupted write would
+type flakyFS struct {
+	writeErrs  []error
+	renameErrs []error
+	writes     int
+	renames    int
+}
+
+func (f *flakyFS) WriteFile(name string, data []byte, perm os.FileMode) error {
+	f.writes++
+	if len(f.writeErrs) > 0 {
+		err := f.writeErrs[0]
+		f.writeErrs = f.writeErrs[1:]
+		ioutil.WriteFile(name, data[:len(data)/2], perm)
+		return &os.PathError{Op: "write", Path: name, Err: err}
+	}
+	return ioutil.WriteFile(name, data, perm)
+}
+
+func (f *flakyFS) Rename(oldpath, newpath string) error {
+	f.renames++
+	if len(f.renameErrs) > 0 {
+		err := f.renameErrs[0]
+		f.renameErrs = f.renameErrs[1:]
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
+	}
+	return os.Rename(oldpath, newpath)
+}
+
+func withFS(f filesystem) func() {
+	old := fs
+	fs = f
+	return func() { fs = old }
+}
+
+func checkContent(t *testing.T, path, expected string) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != expected {
+		t.Fatalf("Expected content %q, got %q", expected, content)
+	}
+}
+
+func checkNoTempFiles(t *testing.T, dir string) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("Expected only the written file in %s, got %d files", dir, len(files))
+	}
+}
+
+func TestAtomicWriteFileRetry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fileutils")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "resolv.conf")
+	if err := ioutil.WriteFile(path, []byte("nameserver 8.8.8.8\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f := &flakyFS{
+		writeErrs:  []error{syscall.EINTR, syscall.ESTALE},
+		renameErrs: []error{syscall.ESTALE},
+	}
+	defer withFS(f)()
+
+	content := "search example.com\nnameserver 10.0.0.1\nnameserver 10.0.0.2\n"
+	if err := AtomicWriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if f.writes != 3 || f.renames != 2 {
+		t.Fatalf("Expected 3 writes and 2 renames, got %d and %d", f.writes, f.renames)
+	}
+
+	checkContent(t, path, content)
+	checkNoTempFiles(t, dir)
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0644 {
+		t.Fatalf("Expected 0644 permissions, got %v", fi.Mode().Perm())
+	}
+}
+
+func TestAtomicWriteFileFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fileutils")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	old := "127.0.0.1\tlocalhost\n"
+	path := filepath.Join(dir, "hosts")
+	if err := ioutil.WriteFile(path, []byte(old), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A non transient error is not retried
+	f := &flakyFS{writeErrs: []error{syscall.ENOSPC}}
+	defer withFS(f)()
+	if err := AtomicWriteFile(path, []byte("127.0.0.1\tlocalhost\n10.0.0.2\tc1\n"), 0644); err == nil {
+		t.Fatal("Expected the write to fail")
+	}
+	if f.writes != 1 {
+		t.Fatalf("Expected a single write, got %d", f.writes)
+	}
+	checkContent(t, path, old)
+	checkNoTempFiles(t, dir)
+
+	// The transient errors are retried a bounded number of times
+	errs := make([]error, writeAttempts)
+	for i := range errs {
+		errs[i] = syscall.EINTR
+	}
+	f = &flakyFS{writeErrs: errs}
+	fs = f
+	if err := AtomicWriteFile(path, []byte("127.0.0.1\tlocalhost\n10.0.0.2\tc1\n"), 0644); err == nil {
+		t.Fatal("Expected the write to fail")
+	}
+	if f.writes != writeAttempts {
+		t.Fatalf("Expected %d writes, got %d", writeAttempts, f.writes)
+	}
+	checkContent(t, path, old)
+	checkNoTempFiles(t, dir)
+}
+
+func TestWriteFileInPlace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fileutils")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "hosts")
+	if err := ioutil.WriteFile(path, []byte("127.0.0.1\tlocalhost\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The transient errors leave the file truncated, it is rewritten whole
+	f := &flakyFS{writeErrs: []error{syscall.EINTR, syscall.ESTALE}}
+	defer withFS(f)()
+
+	content := "127.0.0.1\tlocalhost\n10.0.0.2\tc1\n"
+	if err := WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if f.writes != 3 || f.renames != 0 {
+		t.Fatalf("Expected 3 writes and no rename, got %d and %d", f.writes, f.renames)
+	}
+	checkContent(t, path, content)
+	checkNoTempFiles(t, dir)
+
+	// A bind mount of the file keeps pointing to it
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(before, after) {
+		t.Fatal("Expected the file to be written in place")
+	}
+}
+
+func TestOSWriteFileShorter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fileutils")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "resolv.conf")
+	if err := (osFS{}).WriteFile(path, []byte("search example.com\nnameserver 10.0.0.1\nnameserver 10.0.0.2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The end of the longer old content is truncated away
+	content := "nameserver 10.0.0.3\n"
+	if err := (osFS{}).WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	checkContent(t, path, content)
+
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(before, after) {
+		t.Fatal("Expected the file to be written in place")
+	}
+}
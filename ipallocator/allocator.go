@@ -3,6 +3,7 @@
 package ipallocator
 
 import (
+	"crypto/rand"
 	"errors"
 	"math/big"
 	"net"
@@ -25,6 +26,11 @@ func newAllocatedMap(network *net.IPNet) *allocatedMap {
 	begin := big.NewInt(0).Add(ipToBigInt(firstIP), big.NewInt(1))
 	end := big.NewInt(0).Sub(ipToBigInt(lastIP), big.NewInt(1))
 
+	return newAllocatedRange(begin, end)
+}
+
+// newAllocatedRange returns the set of the allocated IPs among the inclusive range begin-end
+func newAllocatedRange(begin, end *big.Int) *allocatedMap {
 	return &allocatedMap{
 		p:     make(map[string]struct{}),
 		begin: begin,
@@ -46,6 +52,8 @@ var (
 	ErrNetworkAlreadyRegistered = errors.New("network already registered")
 	// ErrBadSubnet preformatted error
 	ErrBadSubnet = errors.New("network does not contain specified subnet")
+	// ErrBadIPRange preformatted error
+	ErrBadIPRange = errors.New("invalid ip range")
 )
 
 // IPAllocator manages the ipam
@@ -83,6 +91,37 @@ func (a *IPAllocator) RegisterSubnet(network *net.IPNet, subnet *net.IPNet) erro
 	return nil
 }
 
+// RegisterRange registers network in global allocator with the inclusive
+// bounds start and end, which do not need to fall on a subnet boundary. Like
+// RegisterSubnet, it must be called before the first RequestIP on the network.
+func (a *IPAllocator) RegisterRange(network *net.IPNet, start, end net.IP) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	nw := &net.IPNet{IP: network.IP.Mask(network.Mask), Mask: network.Mask}
+	key := nw.String()
+	if _, ok := a.allocatedIPs[key]; ok {
+		return ErrNetworkAlreadyRegistered
+	}
+
+	if (start.To4() == nil) != (end.To4() == nil) {
+		return ErrBadIPRange
+	}
+	begin, last := ipToBigInt(start), ipToBigInt(end)
+	if begin == nil || last == nil || begin.Cmp(last) == 1 {
+		return ErrBadIPRange
+	}
+
+	// Check that the range is within the network host addresses
+	firstIP, lastIP := netutils.NetworkRange(nw)
+	if !(network.Contains(start) && network.Contains(end)) || start.Equal(firstIP) || end.Equal(lastIP) {
+		return ErrBadSubnet
+	}
+
+	a.allocatedIPs[key] = newAllocatedRange(begin, last)
+	return nil
+}
+
 // RequestIP requests an available ip from the given network.  It
 // will return the next available ip if the ip provided is nil.  If the
 // ip provided is not nil it will validate that the provided ip is available
@@ -105,6 +144,74 @@ func (a *IPAllocator) RequestIP(network *net.IPNet, ip net.IP) (net.IP, error) {
 	return allocated.checkIP(ip)
 }
 
+// RequestIPInRange requests the first available ip of the given network
+// among the inclusive range start-end. The part of the range outside the
+// network allocation bounds is ignored.
+func (a *IPAllocator) RequestIPInRange(network *net.IPNet, start, end net.IP) (net.IP, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	nw := &net.IPNet{IP: network.IP.Mask(network.Mask), Mask: network.Mask}
+	key := nw.String()
+	allocated, ok := a.allocatedIPs[key]
+	if !ok {
+		allocated = newAllocatedMap(nw)
+		a.allocatedIPs[key] = allocated
+	}
+
+	pos, last := ipToBigInt(start), ipToBigInt(end)
+	if pos.Cmp(allocated.begin) < 0 {
+		pos.Set(allocated.begin)
+	}
+	if last.Cmp(allocated.end) > 0 {
+		last.Set(allocated.end)
+	}
+	for ; pos.Cmp(last) <= 0; pos.Add(pos, big.NewInt(1)) {
+		ip := bigIntToIP(pos)
+		if _, ok := allocated.p[ip.String()]; !ok {
+			allocated.p[ip.String()] = struct{}{}
+			return ip, nil
+		}
+	}
+	return nil, ErrNoAvailableIPs
+}
+
+// PeekIP returns the ip RequestIP would hand out next on the given network,
+// without allocating it. A concurrent request can take it in the meantime.
+func (a *IPAllocator) PeekIP(network *net.IPNet) (net.IP, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	nw := &net.IPNet{IP: network.IP.Mask(network.Mask), Mask: network.Mask}
+	allocated, ok := a.allocatedIPs[nw.String()]
+	if !ok {
+		allocated = newAllocatedMap(nw)
+	}
+
+	pos, err := allocated.findNextIP()
+	if err != nil {
+		return nil, err
+	}
+	return bigIntToIP(pos), nil
+}
+
+// RequestRandomIP requests an available ip from the given network, picked
+// at random among the available ones.
+func (a *IPAllocator) RequestRandomIP(network *net.IPNet) (net.IP, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	nw := &net.IPNet{IP: network.IP.Mask(network.Mask), Mask: network.Mask}
+	key := nw.String()
+	allocated, ok := a.allocatedIPs[key]
+	if !ok {
+		allocated = newAllocatedMap(nw)
+		a.allocatedIPs[key] = allocated
+	}
+
+	return allocated.getRandomIP()
+}
+
 // ReleaseIP adds the provided ip back into the pool of
 // available ips to be returned for use.
 func (a *IPAllocator) ReleaseIP(network *net.IPNet, ip net.IP) error {
@@ -138,6 +245,17 @@ func (allocated *allocatedMap) checkIP(ip net.IP) (net.IP, error) {
 // return an available ip if one is currently available.  If not,
 // return the next available ip for the network
 func (allocated *allocatedMap) getNextIP() (net.IP, error) {
+	pos, err := allocated.findNextIP()
+	if err != nil {
+		return nil, err
+	}
+	allocated.p[bigIntToIP(pos).String()] = struct{}{}
+	allocated.last.Set(pos)
+	return bigIntToIP(pos), nil
+}
+
+// return the position of the next available ip after the last allocated one
+func (allocated *allocatedMap) findNextIP() (*big.Int, error) {
 	pos := big.NewInt(0).Set(allocated.last)
 	allRange := big.NewInt(0).Sub(allocated.end, allocated.begin)
 	for i := big.NewInt(0); i.Cmp(allRange) <= 0; i.Add(i, big.NewInt(1)) {
@@ -148,9 +266,31 @@ func (allocated *allocatedMap) getNextIP() (net.IP, error) {
 		if _, ok := allocated.p[bigIntToIP(pos).String()]; ok {
 			continue
 		}
-		allocated.p[bigIntToIP(pos).String()] = struct{}{}
-		allocated.last.Set(pos)
-		return bigIntToIP(pos), nil
+		return pos, nil
+	}
+	return nil, ErrNoAvailableIPs
+}
+
+// return an available ip, scanning the network range from a random position
+func (allocated *allocatedMap) getRandomIP() (net.IP, error) {
+	allRange := big.NewInt(0).Sub(allocated.end, allocated.begin)
+	if allRange.Sign() < 0 {
+		return nil, ErrNoAvailableIPs
+	}
+	offset, err := rand.Int(rand.Reader, big.NewInt(0).Add(allRange, big.NewInt(1)))
+	if err != nil {
+		return nil, err
+	}
+	pos := big.NewInt(0).Add(allocated.begin, offset)
+	for i := big.NewInt(0); i.Cmp(allRange) <= 0; i.Add(i, big.NewInt(1)) {
+		if _, ok := allocated.p[bigIntToIP(pos).String()]; !ok {
+			allocated.p[bigIntToIP(pos).String()] = struct{}{}
+			return bigIntToIP(pos), nil
+		}
+		pos.Add(pos, big.NewInt(1))
+		if pos.Cmp(allocated.end) == 1 {
+			pos.Set(allocated.begin)
+		}
 	}
 	return nil, ErrNoAvailableIPs
 }
@@ -664,6 +664,52 @@ func TestAllocateFromRange(t *testing.T) {
 	}
 }
 
+func TestAllocateFromIPRange(t *testing.T) {
+	a := New()
+	network := &net.IPNet{
+		IP:   []byte{10, 0, 0, 1},
+		Mask: []byte{255, 255, 255, 0},
+	}
+	start, end := net.IPv4(10, 0, 0, 10), net.IPv4(10, 0, 0, 12)
+
+	if err := a.RegisterRange(network, end, start); err != ErrBadIPRange {
+		t.Fatalf("Expected ErrBadIPRange error, got %v", err)
+	}
+	if err := a.RegisterRange(network, start, net.IPv4(10, 0, 1, 12)); err != ErrBadSubnet {
+		t.Fatalf("Expected ErrBadSubnet error, got %v", err)
+	}
+	if err := a.RegisterRange(network, start, end); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedIPs := []net.IP{
+		0: net.IPv4(10, 0, 0, 10),
+		1: net.IPv4(10, 0, 0, 11),
+		2: net.IPv4(10, 0, 0, 12),
+	}
+	for _, ip := range expectedIPs {
+		rip, err := a.RequestIP(network, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertIPEquals(t, ip, rip)
+	}
+
+	if _, err := a.RequestIP(network, nil); err != ErrNoAvailableIPs {
+		t.Fatalf("Expected ErrNoAvailableIPs error, got %v", err)
+	}
+	if _, err := a.RequestIP(network, net.IPv4(10, 0, 0, 13)); err != ErrIPOutOfRange {
+		t.Fatalf("Expected ErrIPOutOfRange error, got %v", err)
+	}
+
+	a.ReleaseIP(network, expectedIPs[2])
+	rip, err := a.RequestIP(network, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIPEquals(t, expectedIPs[2], rip)
+}
+
 func assertIPEquals(t *testing.T, ip1, ip2 net.IP) {
 	if !ip1.Equal(ip2) {
 		t.Fatalf("Expected IP %s, got %s", ip1, ip2)
@@ -688,3 +734,101 @@ func BenchmarkRequestIP(b *testing.B) {
 		}
 	}
 }
+
+func TestRequestRandomIps(t *testing.T) {
+	a := New()
+
+	network := &net.IPNet{
+		IP:   []byte{192, 168, 0, 1},
+		Mask: []byte{255, 255, 255, 248},
+	}
+
+	seen := map[string]bool{}
+	for i := 0; i < 6; i++ {
+		ip, err := a.RequestRandomIP(network)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !network.Contains(ip) {
+			t.Fatalf("Allocated address %s is outside of %s", ip, network)
+		}
+		if seen[ip.String()] {
+			t.Fatalf("Address %s allocated twice", ip)
+		}
+		seen[ip.String()] = true
+	}
+
+	if _, err := a.RequestRandomIP(network); err != ErrNoAvailableIPs {
+		t.Fatalf("Expected ErrNoAvailableIPs on an exhausted network, got %v", err)
+	}
+
+	released := net.ParseIP("192.168.0.3")
+	if err := a.ReleaseIP(network, released); err != nil {
+		t.Fatal(err)
+	}
+	ip, err := a.RequestRandomIP(network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ip.Equal(released) {
+		t.Fatalf("Expected the released address %s, got %s", released, ip)
+	}
+}
+
+func TestPeekIP(t *testing.T) {
+	a := New()
+	network := &net.IPNet{
+		IP:   []byte{192, 168, 0, 1},
+		Mask: []byte{255, 255, 255, 0},
+	}
+
+	if _, err := a.RequestIP(network, net.ParseIP("192.168.0.1")); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		ip, err := a.PeekIP(network)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertIPEquals(t, net.ParseIP("192.168.0.2"), ip)
+	}
+
+	ip, err := a.RequestIP(network, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIPEquals(t, net.ParseIP("192.168.0.2"), ip)
+
+	if ip, err = a.PeekIP(network); err != nil {
+		t.Fatal(err)
+	}
+	assertIPEquals(t, net.ParseIP("192.168.0.3"), ip)
+}
+
+func TestRequestIPInRange(t *testing.T) {
+	a := New()
+	network := &net.IPNet{
+		IP:   []byte{192, 168, 0, 1},
+		Mask: []byte{255, 255, 255, 0},
+	}
+
+	// The range is clipped to the network host addresses
+	ip, err := a.RequestIPInRange(network, net.ParseIP("192.168.0.0"), net.ParseIP("192.168.0.2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertIPEquals(t, net.ParseIP("192.168.0.1"), ip)
+
+	if ip, err = a.RequestIPInRange(network, net.ParseIP("192.168.0.0"), net.ParseIP("192.168.0.2")); err != nil {
+		t.Fatal(err)
+	}
+	assertIPEquals(t, net.ParseIP("192.168.0.2"), ip)
+
+	if _, err = a.RequestIPInRange(network, net.ParseIP("192.168.0.0"), net.ParseIP("192.168.0.2")); err != ErrNoAvailableIPs {
+		t.Fatalf("Expected ErrNoAvailableIPs on an exhausted range. Got %v", err)
+	}
+	if _, err = a.RequestIPInRange(network, net.ParseIP("192.168.0.250"), net.ParseIP("192.168.0.255")); err != nil {
+		t.Fatal(err)
+	}
+}
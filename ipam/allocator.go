@@ -429,6 +429,38 @@ func (a *Allocator) request(addrSpace AddressSpace, req *AddressRequest, version
 	return response, err
 }
 
+// Peek returns the address Request would hand out next from the specified subnet
+// of the address space, without reserving it. A concurrent request can take it
+// in the meantime.
+func (a *Allocator) Peek(addrSpace AddressSpace, subnet *net.IPNet) (net.IP, error) {
+	if addrSpace == "" {
+		return nil, ErrInvalidAddressSpace
+	}
+	if subnet == nil {
+		return nil, ErrInvalidSubnet
+	}
+
+	subnetList, err := getInternalSubnets(subnet, a.internalHostSize)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range subnetList {
+		key := subnetKey{addrSpace, subnet.String(), s.String()}
+		a.Lock()
+		bitmask, ok := a.addresses[key]
+		a.Unlock()
+		if !ok || bitmask.Unselected() == 0 {
+			continue
+		}
+		ordinal, err := bitmask.FirstAvailable()
+		if err == nil {
+			return generateAddress(ordinal, key.canonicalChildSubnet()), nil
+		}
+	}
+
+	return nil, ErrNoAvailableIPs
+}
+
 // Release allows releasing the address from the specified address space
 func (a *Allocator) Release(addrSpace AddressSpace, address net.IP) {
 	var (
@@ -558,6 +590,23 @@ func (a *Allocator) getAddress(subnet *net.IPNet, bitmask *bitseq.Handle, prefAd
 	return generateAddress(ordinal, subnet), nil
 }
 
+// Usage returns the total number of addresses and the number of free ones in
+// the subnets of the specified address space
+func (a *Allocator) Usage(addrSpace AddressSpace) (uint32, uint32) {
+	var total, free uint32
+
+	a.Lock()
+	defer a.Unlock()
+	for k, h := range a.addresses {
+		if k.addressSpace == addrSpace {
+			total += h.Bits()
+			free += h.Unselected()
+		}
+	}
+
+	return total, free
+}
+
 // DumpDatabase dumps the internal info
 func (a *Allocator) DumpDatabase() {
 	a.Lock()
@@ -610,3 +610,32 @@ func BenchmarkRequest_16(b *testing.B) {
 func BenchmarkRequest_8(b *testing.B) {
 	benchmarkRequest(&net.IPNet{IP: []byte{10, 0, 0, 0}, Mask: []byte{255, 0xfc, 0, 0}})
 }
+
+func TestUsage(t *testing.T) {
+	a, err := NewAllocator(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, sub, _ := net.ParseCIDR("192.168.10.0/24")
+	if err := a.AddSubnet("usage", &SubnetInfo{Subnet: sub}); err != nil {
+		t.Fatal(err)
+	}
+
+	total, free := a.Usage("usage")
+	if total == 0 || free != total-1 {
+		t.Fatalf("Unexpected usage for an empty subnet: total %d free %d", total, free)
+	}
+
+	if _, err := a.Request("usage", &AddressRequest{Subnet: *sub}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, f := a.Usage("usage"); f != free-1 {
+		t.Fatalf("Expected %d free addresses after a request. Got %d", free-1, f)
+	}
+
+	if total, _ := a.Usage("other"); total != 0 {
+		t.Fatalf("Expected no addresses in an unknown address space. Got %d", total)
+	}
+}
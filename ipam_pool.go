@@ -0,0 +1,503 @@
+package libnetwork
+
+import (
+	"encoding/json"
+	"net"
+	"sort"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/config"
+	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/ipam"
+	"github.com/docker/libnetwork/types"
+)
+
+// ifaceID is the ID of the interface libnetwork populates, with an address from
+// the pool or without any address, before handing the endpoint to the driver
+const ifaceID = 1
+
+// ipamPool is a named address pool whose lifecycle is independent from the
+// networks using it. Endpoints created on those networks get their address
+// from the pool, which keeps track of the allocations across network deletes.
+// The subnets the pool was moved away from are retired: they are kept in the
+// allocator for the endpoints not yet renumbered, until the pool is deleted.
+// The secondary subnets expand the pool once its subnet is exhausted.
+// The pools are kept in the datastore, when configured, and restored before
+// the networks using them.
+type ipamPool struct {
+	name      string
+	subnet    *net.IPNet
+	retired   []*net.IPNet
+	secondary []*net.IPNet
+	networks  map[string]struct{}
+	dbIndex   uint64
+	dbExists  bool
+	sync.Mutex
+}
+
+type ipamPoolTable map[string]*ipamPool
+
+// PoolInfo describes a named address pool and its utilization. Addresses and
+// Allocated account for all the subnets of the pool, retired and secondary ones
+// included. The IPv4 network addresses, never handed out, are left out.
+type PoolInfo struct {
+	Name      string
+	Subnet    *net.IPNet
+	Addresses uint32
+	Allocated uint32
+	// Networks lists the IDs of the networks using the pool
+	Networks []string
+}
+
+type byPoolName []PoolInfo
+
+func (p byPoolName) Len() int           { return len(p) }
+func (p byPoolName) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p byPoolName) Less(i, j int) bool { return p[i].Name < p[j].Name }
+
+func (p *ipamPool) Key() []string {
+	p.Lock()
+	defer p.Unlock()
+	return []string{datastore.IpamPoolKeyPrefix, p.name}
+}
+
+func (p *ipamPool) KeyPrefix() []string {
+	return []string{datastore.IpamPoolKeyPrefix}
+}
+
+func (p *ipamPool) Value() []byte {
+	p.Lock()
+	defer p.Unlock()
+	b, err := json.Marshal(p)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+func (p *ipamPool) SetValue(value []byte) error {
+	return json.Unmarshal(value, p)
+}
+
+func (p *ipamPool) Index() uint64 {
+	p.Lock()
+	defer p.Unlock()
+	return p.dbIndex
+}
+
+func (p *ipamPool) SetIndex(index uint64) {
+	p.Lock()
+	p.dbIndex = index
+	p.dbExists = true
+	p.Unlock()
+}
+
+func (p *ipamPool) Exists() bool {
+	p.Lock()
+	defer p.Unlock()
+	return p.dbExists
+}
+
+// subnets returns all the subnets of the pool, the retired and secondary ones included
+func (p *ipamPool) subnets() []*net.IPNet {
+	return append(append([]*net.IPNet{p.subnet}, p.retired...), p.secondary...)
+}
+
+func ipNetsToStrings(l []*net.IPNet) []string {
+	s := make([]string, 0, len(l))
+	for _, n := range l {
+		s = append(s, n.String())
+	}
+	return s
+}
+
+func stringsToIPNets(l []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(l))
+	for _, s := range l {
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func (p *ipamPool) MarshalJSON() ([]byte, error) {
+	poolMap := make(map[string]interface{})
+	poolMap["name"] = p.name
+	poolMap["subnet"] = p.subnet.String()
+	poolMap["retired"] = ipNetsToStrings(p.retired)
+	poolMap["secondary"] = ipNetsToStrings(p.secondary)
+	return json.Marshal(poolMap)
+}
+
+func (p *ipamPool) UnmarshalJSON(b []byte) (err error) {
+	var poolMap struct {
+		Name      string   `json:"name"`
+		Subnet    string   `json:"subnet"`
+		Retired   []string `json:"retired"`
+		Secondary []string `json:"secondary"`
+	}
+	if err := json.Unmarshal(b, &poolMap); err != nil {
+		return err
+	}
+	p.name = poolMap.Name
+	if _, p.subnet, err = net.ParseCIDR(poolMap.Subnet); err != nil {
+		return err
+	}
+	if p.retired, err = stringsToIPNets(poolMap.Retired); err != nil {
+		return err
+	}
+	if p.secondary, err = stringsToIPNets(poolMap.Secondary); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *controller) getIpamAllocator() (*ipam.Allocator, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.ipam == nil {
+		a, err := ipam.NewAllocator(c.store)
+		if err != nil {
+			return nil, err
+		}
+		c.ipam = a
+	}
+
+	return c.ipam, nil
+}
+
+func (c *controller) CreateIpamPool(name string, subnet *net.IPNet) error {
+	if !config.IsValidName(name) {
+		return ErrInvalidName(name)
+	}
+	if subnet == nil {
+		return types.BadRequestErrorf("invalid subnet for ipam pool %s", name)
+	}
+
+	a, err := c.getIpamAllocator()
+	if err != nil {
+		return err
+	}
+
+	pool := &ipamPool{
+		name:     name,
+		subnet:   &net.IPNet{IP: subnet.IP.Mask(subnet.Mask), Mask: subnet.Mask},
+		networks: map[string]struct{}{},
+	}
+
+	c.Lock()
+	if _, ok := c.ipamPools[name]; ok {
+		c.Unlock()
+		return types.ForbiddenErrorf("ipam pool %s already exists", name)
+	}
+	c.ipamPools[name] = pool
+	c.Unlock()
+
+	if err := a.AddSubnet(ipam.AddressSpace(name), &ipam.SubnetInfo{Subnet: pool.subnet}); err != nil {
+		c.Lock()
+		delete(c.ipamPools, name)
+		c.Unlock()
+		return types.BadRequestErrorf("failed to create ipam pool %s: %v", name, err)
+	}
+
+	if err := c.updateIpamPoolToStore(pool); err != nil {
+		if e := a.RemoveSubnet(ipam.AddressSpace(name), pool.subnet); e != nil {
+			log.Warnf("Failed to remove subnet %s of ipam pool %s: %v", pool.subnet, name, e)
+		}
+		c.Lock()
+		delete(c.ipamPools, name)
+		c.Unlock()
+		return err
+	}
+
+	return nil
+}
+
+func (c *controller) DeleteIpamPool(name string) error {
+	c.Lock()
+	pool, ok := c.ipamPools[name]
+	if !ok {
+		c.Unlock()
+		return types.NotFoundErrorf("ipam pool %s not found", name)
+	}
+	if n := len(pool.networks); n != 0 {
+		c.Unlock()
+		return types.ForbiddenErrorf("ipam pool %s is in use by %d network(s)", name, n)
+	}
+	delete(c.ipamPools, name)
+	a := c.ipam
+	c.Unlock()
+
+	if err := c.deleteIpamPoolFromStore(pool); err != nil {
+		c.Lock()
+		c.ipamPools[name] = pool
+		c.Unlock()
+		return err
+	}
+
+	if err := a.RemoveSubnet(ipam.AddressSpace(name), pool.subnet); err != nil {
+		c.Lock()
+		c.ipamPools[name] = pool
+		c.Unlock()
+		return err
+	}
+
+	for _, s := range pool.retired {
+		if err := a.RemoveSubnet(ipam.AddressSpace(name), s); err != nil {
+			log.Warnf("Failed to remove retired subnet %s of ipam pool %s: %v", s, name, err)
+		}
+	}
+	for _, s := range pool.secondary {
+		if err := a.RemoveSubnet(ipam.AddressSpace(name), s); err != nil {
+			log.Warnf("Failed to remove secondary subnet %s of ipam pool %s: %v", s, name, err)
+		}
+	}
+
+	return nil
+}
+
+func (c *controller) UpdateIpamPool(name string, subnet *net.IPNet) error {
+	if subnet == nil {
+		return types.BadRequestErrorf("invalid subnet for ipam pool %s", name)
+	}
+	subnet = &net.IPNet{IP: subnet.IP.Mask(subnet.Mask), Mask: subnet.Mask}
+
+	c.Lock()
+	pool, ok := c.ipamPools[name]
+	a := c.ipam
+	c.Unlock()
+
+	if !ok {
+		return types.NotFoundErrorf("ipam pool %s not found", name)
+	}
+	if types.CompareIPNet(pool.subnet, subnet) {
+		return nil
+	}
+	if isV6(pool.subnet) != isV6(subnet) {
+		return types.BadRequestErrorf("ipam pool %s cannot move to subnet %s of another address family", name, subnet)
+	}
+
+	if err := a.AddSubnet(ipam.AddressSpace(name), &ipam.SubnetInfo{Subnet: subnet}); err != nil {
+		return types.BadRequestErrorf("failed to move ipam pool %s to subnet %s: %v", name, subnet, err)
+	}
+
+	c.Lock()
+	pool.Lock()
+	old := pool.subnet
+	pool.retired = append(pool.retired, pool.subnet)
+	pool.subnet = subnet
+	pool.Unlock()
+	c.Unlock()
+
+	if err := c.updateIpamPoolToStore(pool); err != nil {
+		c.Lock()
+		pool.Lock()
+		pool.retired = pool.retired[:len(pool.retired)-1]
+		pool.subnet = old
+		pool.Unlock()
+		c.Unlock()
+		if e := a.RemoveSubnet(ipam.AddressSpace(name), subnet); e != nil {
+			log.Warnf("Failed to remove subnet %s of ipam pool %s: %v", subnet, name, e)
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (c *controller) IpamPools() []PoolInfo {
+	c.Lock()
+	pools := make([]PoolInfo, 0, len(c.ipamPools))
+	// The allocator reserves the network address of each IPv4 subnet
+	reserved := make([]uint32, 0, len(c.ipamPools))
+	for _, p := range c.ipamPools {
+		pi := PoolInfo{Name: p.name, Subnet: types.GetIPNetCopy(p.subnet), Networks: []string{}}
+		for nid := range p.networks {
+			pi.Networks = append(pi.Networks, nid)
+		}
+		sort.Strings(pi.Networks)
+		pools = append(pools, pi)
+
+		var r uint32
+		for _, s := range p.subnets() {
+			if !isV6(s) {
+				r++
+			}
+		}
+		reserved = append(reserved, r)
+	}
+	a := c.ipam
+	c.Unlock()
+
+	if a != nil {
+		for i := range pools {
+			total, free := a.Usage(ipam.AddressSpace(pools[i].Name))
+			pools[i].Addresses = total - reserved[i]
+			pools[i].Allocated = total - free - reserved[i]
+		}
+	}
+
+	sort.Sort(byPoolName(pools))
+	return pools
+}
+
+// attachIpamPool records the network as a user of the named pool, which must
+// be of the requested address family
+func (c *controller) attachIpamPool(name, nid string, v6 bool) error {
+	c.Lock()
+	defer c.Unlock()
+
+	pool, ok := c.ipamPools[name]
+	if !ok {
+		return types.NotFoundErrorf("ipam pool %s not found", name)
+	}
+	if isV6(pool.subnet) != v6 {
+		family := "IPv4"
+		if v6 {
+			family = "IPv6"
+		}
+		return types.BadRequestErrorf("ipam pool %s subnet %s is not %s", name, pool.subnet, family)
+	}
+	pool.networks[nid] = struct{}{}
+
+	return nil
+}
+
+func (c *controller) detachIpamPool(name, nid string) {
+	c.Lock()
+	defer c.Unlock()
+
+	if pool, ok := c.ipamPools[name]; ok {
+		delete(pool.networks, nid)
+	}
+}
+
+// expandIpamPool adds the subnet to the named pool as a secondary subnet, the
+// addresses are handed out from once the pool subnet is exhausted
+func (c *controller) expandIpamPool(name string, subnet *net.IPNet) error {
+	c.Lock()
+	pool, ok := c.ipamPools[name]
+	a := c.ipam
+	expanded := false
+	if ok {
+		for _, s := range pool.secondary {
+			expanded = expanded || types.CompareIPNet(s, subnet)
+		}
+	}
+	c.Unlock()
+
+	if !ok {
+		return types.NotFoundErrorf("ipam pool %s not found", name)
+	}
+	// Another network of the pool may have expanded it meanwhile
+	if expanded {
+		return nil
+	}
+
+	if err := a.AddSubnet(ipam.AddressSpace(name), &ipam.SubnetInfo{Subnet: subnet}); err != nil {
+		return types.ForbiddenErrorf("failed to expand ipam pool %s with subnet %s: %v", name, subnet, err)
+	}
+
+	c.Lock()
+	pool.Lock()
+	pool.secondary = append(pool.secondary, subnet)
+	pool.Unlock()
+	c.Unlock()
+
+	if err := c.updateIpamPoolToStore(pool); err != nil {
+		c.Lock()
+		pool.Lock()
+		pool.secondary = pool.secondary[:len(pool.secondary)-1]
+		pool.Unlock()
+		c.Unlock()
+		if e := a.RemoveSubnet(ipam.AddressSpace(name), subnet); e != nil {
+			log.Warnf("Failed to remove secondary subnet %s of ipam pool %s: %v", subnet, name, e)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// requestPoolAddress reserves the passed address, or any if nil, from the named
+// pool. Any address comes from the secondary subnets once the pool subnet is exhausted.
+func (c *controller) requestPoolAddress(name string, ip net.IP) (*net.IPNet, error) {
+	c.Lock()
+	pool, ok := c.ipamPools[name]
+	a := c.ipam
+	var subnets []*net.IPNet
+	if ok {
+		subnets = append([]*net.IPNet{pool.subnet}, pool.secondary...)
+	}
+	c.Unlock()
+
+	if !ok {
+		return nil, types.NotFoundErrorf("ipam pool %s not found", name)
+	}
+
+	// A given address is requested from the subnet it belongs to
+	if ip != nil {
+		match := subnets[0]
+		for _, s := range subnets[1:] {
+			if s.Contains(ip) {
+				match = s
+			}
+		}
+		subnets = []*net.IPNet{match}
+	}
+
+	var err error
+	for _, subnet := range subnets {
+		request := a.Request
+		if isV6(subnet) {
+			request = a.RequestV6
+		}
+		var rsp *ipam.AddressResponse
+		rsp, err = request(ipam.AddressSpace(name), &ipam.AddressRequest{Subnet: *subnet, Address: ip})
+		if err == nil {
+			return &net.IPNet{IP: rsp.Address, Mask: subnet.Mask}, nil
+		}
+		if err != ipam.ErrNoAvailableIPs {
+			return nil, err
+		}
+	}
+
+	return nil, err
+}
+
+// previewPoolAddress returns the address the named pool would hand out next, without reserving it
+func (c *controller) previewPoolAddress(name string) (net.IP, error) {
+	c.Lock()
+	pool, ok := c.ipamPools[name]
+	a := c.ipam
+	var subnet *net.IPNet
+	if ok {
+		subnet = pool.subnet
+	}
+	c.Unlock()
+
+	if !ok {
+		return nil, types.NotFoundErrorf("ipam pool %s not found", name)
+	}
+
+	return a.Peek(ipam.AddressSpace(name), subnet)
+}
+
+func (c *controller) releasePoolAddress(name string, ip net.IP) {
+	c.Lock()
+	a := c.ipam
+	c.Unlock()
+
+	if a != nil {
+		a.Release(ipam.AddressSpace(name), ip)
+	}
+}
+
+func isV6(subnet *net.IPNet) bool {
+	return subnet.IP.To4() == nil
+}
@@ -142,7 +142,7 @@ func TestPrerouting(t *testing.T) {
 	}
 
 	delRule := append([]string{"-D", "PREROUTING", "-t", string(Nat)}, args...)
-	if _, err = Raw(delRule...); err != nil {
+	if _, err = Exec(delRule...); err != nil {
 		t.Fatal(err)
 	}
 }
@@ -163,7 +163,7 @@ func TestOutput(t *testing.T) {
 
 	delRule := append([]string{"-D", "OUTPUT", "-t",
 		string(natChain.Table)}, args...)
-	if _, err = Raw(delRule...); err != nil {
+	if _, err = Exec(delRule...); err != nil {
 		t.Fatal(err)
 	}
 }
@@ -200,7 +200,7 @@ func RunConcurrencyTest(t *testing.T, allowXlock bool) {
 			defer wg.Done()
 			err := natChain.Forward(Append, ip, port, proto, dstAddr, dstPort, "lo")
 			if err != nil {
-				t.Fatal(err)
+				t.Error(err)
 			}
 		}()
 	}
@@ -216,7 +216,7 @@ func TestCleanup(t *testing.T) {
 		string(Delete), "FORWARD",
 		"-o", bridgeName,
 		"-j", filterChain.Name}
-	if _, err = Raw(link...); err != nil {
+	if _, err = Exec(link...); err != nil {
 		t.Fatal(err)
 	}
 	filterChain.Remove()
@@ -1,10 +1,31 @@
 package libnetwork
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/docker/libkv/store"
+	"github.com/docker/libnetwork/config"
 	"github.com/docker/libnetwork/datastore"
 	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/options"
+	"github.com/docker/libnetwork/osl"
+	"github.com/docker/libnetwork/resolvconf"
+	"github.com/docker/libnetwork/types"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
 )
 
 func TestDriverRegistration(t *testing.T) {
@@ -30,3 +51,3675 @@ func SetTestDataStore(c NetworkController, custom datastore.DataStore) {
 	con := c.(*controller)
 	con.store = custom
 }
+
+type poolTestDriver struct{}
+
+func (d *poolTestDriver) Config(options map[string]interface{}) error {
+	return nil
+}
+
+func (d *poolTestDriver) CreateNetwork(nid string, options map[string]interface{}) error {
+	return nil
+}
+
+func (d *poolTestDriver) DeleteNetwork(nid string) error {
+	return nil
+}
+
+func (d *poolTestDriver) CreateEndpoint(nid, eid string, epInfo driverapi.EndpointInfo, options map[string]interface{}) error {
+	if len(epInfo.Interfaces()) != 1 {
+		return fmt.Errorf("expected one interface populated by libnetwork. Got %d", len(epInfo.Interfaces()))
+	}
+	return nil
+}
+
+func (d *poolTestDriver) DeleteEndpoint(nid, eid string) error {
+	return nil
+}
+
+func (d *poolTestDriver) EndpointOperInfo(nid, eid string) (map[string]interface{}, error) {
+	return nil, nil
+}
+
+func (d *poolTestDriver) Join(nid, eid string, sboxKey string, jinfo driverapi.JoinInfo, options map[string]interface{}) error {
+	return nil
+}
+
+func (d *poolTestDriver) Leave(nid, eid string) error {
+	return nil
+}
+
+func (d *poolTestDriver) Type() string {
+	return "pool-test"
+}
+
+func (d *poolTestDriver) SupportsPoolAddresses() bool {
+	return true
+}
+
+func TestIpamPool(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.(*controller).RegisterDriver("pool-test", &poolTestDriver{}, driverapi.Capability{}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, subnet, _ := net.ParseCIDR("192.168.100.0/24")
+	if err := c.CreateIpamPool("pool1", subnet); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.CreateIpamPool("pool1", subnet); err == nil {
+		t.Fatal("Expected failure creating an ipam pool with a duplicate name")
+	}
+
+	if _, err := c.NewNetwork("pool-test", "net0", NetworkOptionIpamPool("nopool")); err == nil {
+		t.Fatal("Expected failure creating a network on a non existent ipam pool")
+	}
+
+	n1, err := c.NewNetwork("pool-test", "net1", NetworkOptionIpamPool("pool1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	preview, err := n1.PreviewNextAddress()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep1, err := n1.CreateEndpoint("ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr1 := ep1.Info().InterfaceList()[0].Address()
+	if !addr1.IP.Equal(preview) {
+		t.Fatalf("Expected the endpoint to get the previewed address %s. Got %s", preview, addr1.IP)
+	}
+	if !subnet.Contains(addr1.IP) {
+		t.Fatalf("Endpoint address %s not allocated from the ipam pool subnet %s", addr1.String(), subnet)
+	}
+
+	if err := c.DeleteIpamPool("pool1"); err == nil {
+		t.Fatal("Expected failure deleting an ipam pool in use")
+	} else if _, ok := err.(types.ForbiddenError); !ok {
+		t.Fatalf("Unexpected error type deleting an ipam pool in use: %v", err)
+	}
+
+	// Allocations must survive the deletion of the other networks using the pool
+	n2, err := c.NewNetwork("pool-test", "net2", NetworkOptionIpamPool("pool1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep2, err := n2.CreateEndpoint("ep2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr2 := ep2.Info().InterfaceList()[0].Address()
+	if addr2.IP.Equal(addr1.IP) {
+		t.Fatalf("Address %s allocated twice from the ipam pool", addr1.String())
+	}
+
+	if err := ep2.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if err := n2.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	n3, err := c.NewNetwork("pool-test", "net3", NetworkOptionIpamPool("pool1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep3, err := n3.CreateEndpoint("ep3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr3 := ep3.Info().InterfaceList()[0].Address(); addr3.IP.Equal(addr1.IP) {
+		t.Fatalf("Address %s allocated twice from the ipam pool after a network delete", addr1.String())
+	}
+
+	for _, ep := range []Endpoint{ep3, ep1} {
+		if err := ep.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, n := range []Network{n3, n1} {
+		if err := n.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := c.DeleteIpamPool("pool1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.DeleteIpamPool("pool1"); err == nil {
+		t.Fatal("Expected failure deleting a non existent ipam pool")
+	}
+}
+
+func TestIpamPoolPerFamily(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.(*controller).RegisterDriver("pool-test", &poolTestDriver{}, driverapi.Capability{}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, subnet4, _ := net.ParseCIDR("192.168.120.0/24")
+	if err := c.CreateIpamPool("pool4", subnet4); err != nil {
+		t.Fatal(err)
+	}
+	_, subnet6, _ := net.ParseCIDR("fd00:120::/120")
+	if err := c.CreateIpamPool("pool6", subnet6); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.NewNetwork("pool-test", "net0", NetworkOptionIpamPoolV6("pool4")); err == nil {
+		t.Fatal("Expected failure creating a network drawing IPv6 addresses from an IPv4 pool")
+	} else if _, ok := err.(types.BadRequestError); !ok {
+		t.Fatalf("Unexpected error type: %v", err)
+	}
+	if _, err := c.NewNetwork("pool-test", "net0", NetworkOptionIpamPool("pool6")); err == nil {
+		t.Fatal("Expected failure creating a network drawing IPv4 addresses from an IPv6 pool")
+	}
+
+	n, err := c.NewNetwork("pool-test", "net1", NetworkOptionIpamPool("pool4"), NetworkOptionIpamPoolV6("pool6"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := map[string]bool{}
+	var eps []Endpoint
+	for i := 0; i < 3; i++ {
+		ep, err := n.CreateEndpoint(fmt.Sprintf("ep%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		eps = append(eps, ep)
+
+		iface := ep.Info().InterfaceList()[0]
+		addr, addrv6 := iface.Address(), iface.AddressIPv6()
+		if !subnet4.Contains(addr.IP) {
+			t.Fatalf("Endpoint address %s not allocated from the IPv4 pool subnet %s", addr.IP, subnet4)
+		}
+		if !subnet6.Contains(addrv6.IP) {
+			t.Fatalf("Endpoint IPv6 address %s not allocated from the IPv6 pool subnet %s", addrv6.IP, subnet6)
+		}
+		for _, a := range []string{addr.IP.String(), addrv6.IP.String()} {
+			if seen[a] {
+				t.Fatalf("Address %s allocated twice", a)
+			}
+			seen[a] = true
+		}
+	}
+
+	// A v6 only network does not need a v4 pool
+	n6, err := c.NewNetwork("pool-test", "net2", NetworkOptionIpamPoolV6("pool6"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep6, err := n6.CreateEndpoint("ep6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	iface := ep6.Info().InterfaceList()[0]
+	if len(iface.Address().IP) != 0 || !subnet6.Contains(iface.AddressIPv6().IP) {
+		t.Fatalf("Expected only an IPv6 address from the pool. Got %s and %s", iface.Address().IP, iface.AddressIPv6().IP)
+	}
+
+	for _, ep := range append(eps, ep6) {
+		if err := ep.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, n := range []Network{n6, n} {
+		if err := n.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The addresses went back to the pools along with the networks
+	if err := c.DeleteIpamPool("pool6"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteIpamPool("pool4"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIpamPools(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.(*controller).RegisterDriver("pool-test", &poolTestDriver{}, driverapi.Capability{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, cidr := range map[string]string{"pool-b": "192.168.110.0/24", "pool-a": "192.168.111.0/28", "pool-c": "192.168.112.0/28"} {
+		_, subnet, _ := net.ParseCIDR(cidr)
+		if err := c.CreateIpamPool(name, subnet); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// pool-b is shared by two networks, pool-a has one network without
+	// endpoints and pool-c none
+	n1, err := c.NewNetwork("pool-test", "net1", NetworkOptionIpamPool("pool-b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n2, err := c.NewNetwork("pool-test", "net2", NetworkOptionIpamPool("pool-b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n3, err := c.NewNetwork("pool-test", "net3", NetworkOptionIpamPool("pool-a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var eps []Endpoint
+	for i, n := range []Network{n1, n1, n1, n2} {
+		ep, err := n.CreateEndpoint(fmt.Sprintf("ep%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		eps = append(eps, ep)
+	}
+	if err := eps[1].Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	sortedIDs := func(nws ...Network) []string {
+		var ids []string
+		for _, n := range nws {
+			ids = append(ids, n.ID())
+		}
+		sort.Strings(ids)
+		return ids
+	}
+	expected := []struct {
+		name      string
+		subnet    string
+		addresses uint32
+		allocated uint32
+		networks  []string
+	}{
+		{"pool-a", "192.168.111.0/28", 14, 0, sortedIDs(n3)},
+		{"pool-b", "192.168.110.0/24", 254, 3, sortedIDs(n1, n2)},
+		{"pool-c", "192.168.112.0/28", 14, 0, []string{}},
+	}
+
+	pools := c.IpamPools()
+	if len(pools) != len(expected) {
+		t.Fatalf("Expected %d ipam pools, got %d", len(expected), len(pools))
+	}
+	for i, e := range expected {
+		p := pools[i]
+		if p.Name != e.name || p.Subnet.String() != e.subnet {
+			t.Fatalf("Expected ipam pool %s with subnet %s, got %s with %s", e.name, e.subnet, p.Name, p.Subnet)
+		}
+		if p.Addresses != e.addresses || p.Allocated != e.allocated {
+			t.Fatalf("Expected %d allocated addresses out of %d in ipam pool %s, got %d out of %d", e.allocated, e.addresses, e.name, p.Allocated, p.Addresses)
+		}
+		if !reflect.DeepEqual(p.Networks, e.networks) {
+			t.Fatalf("Expected networks %v using ipam pool %s, got %v", e.networks, e.name, p.Networks)
+		}
+	}
+
+	// The report follows the pool moves
+	_, moved, _ := net.ParseCIDR("192.168.113.0/28")
+	if err := c.UpdateIpamPool("pool-b", moved); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := n2.CreateEndpoint("ep4"); err != nil {
+		t.Fatal(err)
+	}
+	p := c.IpamPools()[1]
+	if p.Subnet.String() != moved.String() || p.Addresses != 254+14 || p.Allocated != 4 {
+		t.Fatalf("Unexpected report of the moved ipam pool: %s, %d allocated addresses out of %d", p.Subnet, p.Allocated, p.Addresses)
+	}
+}
+
+func TestIpamPoolExhaustionPolicy(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.(*controller).RegisterDriver("pool-test", &poolTestDriver{}, driverapi.Capability{}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, failSubnet, _ := net.ParseCIDR("192.168.130.0/29")
+	if err := c.CreateIpamPool("small-fail", failSubnet); err != nil {
+		t.Fatal(err)
+	}
+	_, subnet, _ := net.ParseCIDR("192.168.131.0/29")
+	if err := c.CreateIpamPool("small", subnet); err != nil {
+		t.Fatal(err)
+	}
+	_, expansion, _ := net.ParseCIDR("192.168.132.0/28")
+
+	if _, err := c.NewNetwork("pool-test", "queue", NetworkOptionIpamPool("small"),
+		NetworkOptionExhaustionPolicy(netlabel.ExhaustionQueue, nil)); err == nil {
+		t.Fatal("Expected failure creating a network queueing on pool exhaustion")
+	} else if _, ok := err.(types.NotImplementedError); !ok {
+		t.Fatalf("Unexpected error type: %v", err)
+	}
+	if _, err := c.NewNetwork("pool-test", "expand", NetworkOptionIpamPool("small"),
+		NetworkOptionExhaustionPolicy(netlabel.ExhaustionExpand, nil)); err == nil {
+		t.Fatal("Expected failure creating a network expanding its pool without an expansion subnet")
+	} else if _, ok := err.(types.BadRequestError); !ok {
+		t.Fatalf("Unexpected error type: %v", err)
+	}
+
+	var eps []Endpoint
+
+	// The default policy fails once the pool is exhausted
+	nf, err := c.NewNetwork("pool-test", "fail", NetworkOptionIpamPool("small-fail"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	capacity := 0
+	for ; ; capacity++ {
+		ep, err := nf.CreateEndpoint(fmt.Sprintf("fail%d", capacity))
+		if err != nil {
+			break
+		}
+		eps = append(eps, ep)
+	}
+	if capacity == 0 || capacity > 8 {
+		t.Fatalf("Unexpected number of addresses handed out by a /29 pool: %d", capacity)
+	}
+
+	n, err := c.NewNetwork("pool-test", "expand", NetworkOptionIpamPool("small"),
+		NetworkOptionExhaustionPolicy(netlabel.ExhaustionExpand, expansion))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i <= capacity; i++ {
+		ep, err := n.CreateEndpoint(fmt.Sprintf("ep%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		eps = append(eps, ep)
+
+		ip := ep.Info().InterfaceList()[0].Address().IP
+		if i < capacity && !subnet.Contains(ip) {
+			t.Fatalf("Expected address %s from the pool subnet %s", ip, subnet)
+		}
+		if i == capacity && !expansion.Contains(ip) {
+			t.Fatalf("Expected address %s from the expansion subnet %s once the pool is exhausted", ip, expansion)
+		}
+	}
+
+	for _, ep := range eps {
+		if err := ep.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, n := range []Network{n, nf} {
+		if err := n.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"small", "small-fail"} {
+		if err := c.DeleteIpamPool(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestIpamPoolRestore(t *testing.T) {
+	ds := datastore.NewCustomDataStore(datastore.NewMockStore())
+	newController := func() *controller {
+		c, err := New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.(*controller).RegisterDriver("pool-test", &poolTestDriver{}, driverapi.Capability{Scope: driverapi.GlobalScope}); err != nil {
+			t.Fatal(err)
+		}
+		SetTestDataStore(c, ds)
+		return c.(*controller)
+	}
+
+	c := newController()
+
+	_, subnet, _ := net.ParseCIDR("192.168.140.0/24")
+	if err := c.CreateIpamPool("persisted", subnet); err != nil {
+		t.Fatal(err)
+	}
+	// Only the drivers programming the pool addresses can use a pool
+	for _, driver := range []string{"bridge", "null"} {
+		if _, err := c.NewNetwork(driver, "pool"+driver, NetworkOptionIpamPool("persisted")); err == nil {
+			t.Fatalf("Expected failure creating a %s network using an ipam pool", driver)
+		} else if _, ok := err.(types.BadRequestError); !ok {
+			t.Fatalf("Unexpected error type: %v", err)
+		}
+	}
+
+	_, moved, _ := net.ParseCIDR("192.168.141.0/24")
+	if err := c.UpdateIpamPool("persisted", moved); err != nil {
+		t.Fatal(err)
+	}
+	n, err := c.NewNetwork("pool-test", "poolnet", NetworkOptionIpamPool("persisted"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep1, err := n.CreateEndpoint("ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip1 := ep1.Info().InterfaceList()[0].Address().IP
+
+	// The restarted controller restores the pools, then the networks using them
+	c = newController()
+	if err := c.restoreIpamPools(); err != nil {
+		t.Fatal(err)
+	}
+	nws, err := c.getNetworksFromStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.processNetworkUpdate(nws, nil)
+
+	pools := c.IpamPools()
+	if len(pools) != 1 || pools[0].Name != "persisted" || !types.CompareIPNet(pools[0].Subnet, moved) {
+		t.Fatalf("Unexpected restored pools: %v", pools)
+	}
+	if len(pools[0].Networks) != 1 || pools[0].Networks[0] != n.ID() {
+		t.Fatalf("Expected the restored network to use the pool: %v", pools[0].Networks)
+	}
+	if err := c.DeleteIpamPool("persisted"); err == nil {
+		t.Fatal("Expected failure deleting a restored pool in use")
+	}
+
+	rn, err := c.NetworkByID(n.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	eps, err := c.getEndpointsFromStore(rn.(*network))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ep := range eps {
+		if err := c.newEndpointFromStore(ep.id, ep); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ep2, err := rn.CreateEndpoint("ep2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip2 := ep2.Info().InterfaceList()[0].Address().IP
+	if !moved.Contains(ip2) || ip2.Equal(ip1) {
+		t.Fatalf("Expected a new address from the restored pool subnet %s, got %s (ep1 has %s)", moved, ip2, ip1)
+	}
+
+	for _, id := range []string{ep1.ID(), ep2.ID()} {
+		ep, err := rn.EndpointByID(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ep.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rn.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteIpamPool("persisted"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ds.KVStore().List(datastore.Key(datastore.IpamPoolKeyPrefix)); err != datastore.ErrKeyNotFound {
+		t.Fatalf("Expected the pool to be removed from the datastore: %v", err)
+	}
+}
+
+// noAddrTestDriver plumbs a veth pair on join for endpoints whose interface
+// is populated by libnetwork
+type noAddrTestDriver struct {
+	poolTestDriver
+}
+
+func (d *noAddrTestDriver) Join(nid, eid string, sboxKey string, jinfo driverapi.JoinInfo, options map[string]interface{}) error {
+	veth := &netlink.Veth{
+		LinkAttrs: netlink.LinkAttrs{Name: "noaddrveth0"},
+		PeerName:  "noaddrveth1"}
+	if err := netlink.LinkAdd(veth); err != nil {
+		return err
+	}
+	return jinfo.InterfaceNames()[0].SetNames("noaddrveth1", "eth")
+}
+
+func (d *noAddrTestDriver) Leave(nid, eid string) error {
+	link, err := netlink.LinkByName("noaddrveth0")
+	if err != nil {
+		return err
+	}
+	return netlink.LinkDel(link)
+}
+
+func TestEndpointSetAddress(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.(*controller).RegisterDriver("noaddr-test", &noAddrTestDriver{}, driverapi.Capability{}); err != nil {
+		t.Fatal(err)
+	}
+
+	ip, addr, _ := net.ParseCIDR("192.168.50.10/24")
+	addr.IP = ip
+
+	// The address cannot be reserved without an ipam pool
+	nopool, err := c.NewNetwork("noaddr-test", "testnopool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep0, err := nopool.CreateEndpoint("ep0", CreateOptionNoAddress())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ep0.SetAddress(addr); err == nil {
+		t.Fatal("Expected failure setting the address of an endpoint on a network without ipam pool")
+	} else if _, ok := err.(types.ForbiddenError); !ok {
+		t.Fatalf("Unexpected error type: %v", err)
+	}
+	if err := ep0.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, subnet, _ := net.ParseCIDR("192.168.50.0/24")
+	if err := c.CreateIpamPool("noaddr", subnet); err != nil {
+		t.Fatal(err)
+	}
+	n, err := c.NewNetwork("noaddr-test", "testnoaddr", NetworkOptionIpamPool("noaddr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep, err := n.CreateEndpoint("ep1", CreateOptionNoAddress())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ifaces := ep.Info().InterfaceList()
+	if len(ifaces) != 1 {
+		t.Fatalf("Expected one interface on the endpoint. Got %d", len(ifaces))
+	}
+	if addr := ifaces[0].Address(); len(addr.IP) != 0 {
+		t.Fatalf("Expected no address on the endpoint. Got %s", addr.String())
+	}
+
+	sbx, err := c.NewSandbox("sandbox1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ep.Join(sbx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ep.SetAddress(addr); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := ep.Info().InterfaceList()[0].Address(); got.String() != addr.String() {
+		t.Fatalf("Expected address %s on the endpoint. Got %s", addr, got.String())
+	}
+	if _, err := c.(*controller).requestPoolAddress("noaddr", ip); err == nil {
+		t.Fatalf("Expected address %s to be reserved in the pool", ip)
+	}
+
+	if err := ep.SetAddress(addr); err == nil {
+		t.Fatal("Expected failure setting the address of an endpoint which has one")
+	}
+
+	osSbox := sbx.(*sandbox).osSbox
+	dstName := osSbox.Info().Interfaces()[0].DstName()
+	var found bool
+	if err := osSbox.InvokeFunc(func() {
+		link, lErr := netlink.LinkByName(dstName)
+		if lErr != nil {
+			return
+		}
+		addrs, _ := netlink.AddrList(link, netlink.FAMILY_V4)
+		for _, a := range addrs {
+			if a.IPNet.String() == addr.String() {
+				found = true
+			}
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !found {
+		t.Fatalf("Address %s not found on %s in the sandbox", addr, dstName)
+	}
+
+	if err := ep.Leave(sbx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sbx.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ep.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if pools := c.IpamPools(); pools[0].Allocated != 0 {
+		t.Fatalf("Expected the address to be released along with the endpoint: %v", pools[0])
+	}
+
+	osl.GC()
+}
+
+// sandboxAddrs returns the IPv4 addresses of the first sandbox interface
+func sandboxAddrs(t *testing.T, sbx Sandbox) []string {
+	osSbox := sbx.(*sandbox).osSbox
+	dstName := osSbox.Info().Interfaces()[0].DstName()
+	var addrs []string
+	if err := osSbox.InvokeFunc(func() {
+		link, lErr := netlink.LinkByName(dstName)
+		if lErr != nil {
+			return
+		}
+		list, _ := netlink.AddrList(link, netlink.FAMILY_V4)
+		for _, a := range list {
+			addrs = append(addrs, a.IPNet.String())
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return addrs
+}
+
+// renumberTestDriver routes the sandbox through a gateway and a next hop of the
+// network subnet
+type renumberTestDriver struct {
+	noAddrTestDriver
+	gw      net.IP
+	nextHop net.IP
+	dest    *net.IPNet
+}
+
+func (d *renumberTestDriver) Join(nid, eid string, sboxKey string, jinfo driverapi.JoinInfo, options map[string]interface{}) error {
+	if err := d.noAddrTestDriver.Join(nid, eid, sboxKey, jinfo, options); err != nil {
+		return err
+	}
+	if err := jinfo.SetGateway(d.gw); err != nil {
+		return err
+	}
+	return jinfo.AddStaticRoute(d.dest, types.NEXTHOP, d.nextHop, 0)
+}
+
+// sandboxRoutes returns the gateways of the IPv4 routes of the sandbox, by destination
+func sandboxRoutes(t *testing.T, sbx Sandbox) map[string]string {
+	routes := map[string]string{}
+	if err := sbx.(*sandbox).osSbox.InvokeFunc(func() {
+		list, _ := netlink.RouteList(nil, netlink.FAMILY_V4)
+		for _, r := range list {
+			if r.Gw == nil {
+				continue
+			}
+			dst := "default"
+			if r.Dst != nil {
+				dst = r.Dst.String()
+			}
+			routes[dst] = r.Gw.String()
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return routes
+}
+
+func TestEndpointRenumber(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, dest, _ := net.ParseCIDR("10.10.0.0/16")
+	d := &renumberTestDriver{gw: net.ParseIP("192.168.60.254"), nextHop: net.ParseIP("192.168.60.253"), dest: dest}
+	if err := c.(*controller).RegisterDriver("noaddr-test", d, driverapi.Capability{}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, subnet, _ := net.ParseCIDR("192.168.60.0/24")
+	if err := c.CreateIpamPool("renumber", subnet); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := c.NewNetwork("noaddr-test", "testrenumber", NetworkOptionIpamPool("renumber"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep, err := n.CreateEndpoint("ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := ep.Info().InterfaceList()[0].Address()
+
+	sbx, err := c.NewSandbox("sandbox1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ep.Join(sbx); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"default": "192.168.60.254", "10.10.0.0/16": "192.168.60.253"}
+	if routes := sandboxRoutes(t, sbx); !reflect.DeepEqual(routes, expected) {
+		t.Fatalf("Unexpected sandbox routes before renumbering.\nExpected: %v\nGot:      %v", expected, routes)
+	}
+
+	_, newSubnet, _ := net.ParseCIDR("192.168.61.0/24")
+	if err := c.UpdateIpamPool("renumber", newSubnet); err != nil {
+		t.Fatal(err)
+	}
+
+	// The endpoint keeps its address until renumbered
+	if got := ep.Info().InterfaceList()[0].Address(); got.String() != old.String() {
+		t.Fatalf("Expected address %s on the endpoint before renumbering. Got %s", old.String(), got.String())
+	}
+
+	if err := ep.Renumber(); err != nil {
+		t.Fatal(err)
+	}
+
+	addr := ep.Info().InterfaceList()[0].Address()
+	if !newSubnet.Contains(addr.IP) {
+		t.Fatalf("Endpoint address %s not allocated from the updated subnet %s", addr.String(), newSubnet)
+	}
+
+	addrs := sandboxAddrs(t, sbx)
+	if len(addrs) != 1 || addrs[0] != addr.String() {
+		t.Fatalf("Expected only address %s in the sandbox. Got %v", addr.String(), addrs)
+	}
+
+	if ip := n.(*network).svcRecords["ep1"]; !ip.Equal(addr.IP) {
+		t.Fatalf("Expected the service record of ep1 to point to %s. Got %s", addr.IP, ip)
+	}
+
+	// The gateway and the routes move to the new subnet along with the address
+	expected = map[string]string{"default": "192.168.61.254", "10.10.0.0/16": "192.168.61.253"}
+	if routes := sandboxRoutes(t, sbx); !reflect.DeepEqual(routes, expected) {
+		t.Fatalf("Unexpected sandbox routes after renumbering.\nExpected: %v\nGot:      %v", expected, routes)
+	}
+	if gw := ep.Info().Gateway(); !gw.Equal(net.ParseIP("192.168.61.254")) {
+		t.Fatalf("Expected the endpoint gateway to move to the new subnet. Got %s", gw)
+	}
+
+	if err := ep.Leave(sbx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sbx.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ep.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := n.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.DeleteIpamPool("renumber"); err != nil {
+		t.Fatal(err)
+	}
+
+	osl.GC()
+}
+
+func TestReplaceEndpoint(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.(*controller).RegisterDriver("pool-test", &poolTestDriver{}, driverapi.Capability{}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, subnet, _ := net.ParseCIDR("192.168.64.0/24")
+	if err := c.CreateIpamPool("replace", subnet); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := c.NewNetwork("pool-test", "testreplace", NetworkOptionIpamPool("replace"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old, err := n.CreateEndpoint("web", CreateOptionDNSNames([]string{"frontend"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := old.Info().InterfaceList()[0].Address()
+
+	ep, err := n.ReplaceEndpoint(old, EndpointSpec{Options: []EndpointOption{CreateOptionLabels(map[string]string{"version": "2"})}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ep.ID() == old.ID() || ep.Name() != "web" {
+		t.Fatalf("Expected a new endpoint named web. Got %s (%s)", ep.Name(), ep.ID())
+	}
+	if got := ep.Info().InterfaceList()[0].Address(); got.String() != addr.String() {
+		t.Fatalf("Expected the new endpoint to keep address %s. Got %s", addr.String(), got.String())
+	}
+	if _, err := n.EndpointByID(old.ID()); err == nil {
+		t.Fatal("Expected the old endpoint to be deleted")
+	}
+
+	// The DNS names still resolve to the address
+	for _, name := range []string{"web", "frontend", "web.testreplace"} {
+		if ip := n.(*network).svcRecords[name]; !ip.Equal(addr.IP) {
+			t.Fatalf("Expected %s to resolve to %s. Got %v", name, addr.IP, ip)
+		}
+	}
+
+	// The address is still reserved in the pool
+	other, err := n.CreateEndpoint("other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := other.Info().InterfaceList()[0].Address(); got.IP.Equal(addr.IP) {
+		t.Fatalf("Address %s of the replacing endpoint was handed out again", addr.IP)
+	}
+
+	// A replacement with a taken name leaves the old endpoint in place
+	if _, err := n.ReplaceEndpoint(ep, EndpointSpec{Name: "other"}); err == nil {
+		t.Fatal("Expected failure replacing an endpoint with a name in use")
+	}
+	if _, err := n.EndpointByID(ep.ID()); err != nil {
+		t.Fatalf("Expected the endpoint to be kept after a failed replacement: %v", err)
+	}
+
+	n2, err := c.NewNetwork("pool-test", "testreplace2", NetworkOptionIpamPool("replace"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := n2.ReplaceEndpoint(ep, EndpointSpec{}); err == nil {
+		t.Fatal("Expected failure replacing an endpoint of another network")
+	} else if _, ok := err.(types.BadRequestError); !ok {
+		t.Fatalf("Unexpected error type: %v", err)
+	}
+
+	for _, e := range []Endpoint{ep, other} {
+		if err := e.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, nw := range []Network{n, n2} {
+		if err := nw.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.DeleteIpamPool("replace"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func sandboxMac(t *testing.T, sbx Sandbox) string {
+	osSbox := sbx.(*sandbox).osSbox
+	dstName := osSbox.Info().Interfaces()[0].DstName()
+	var mac string
+	if err := osSbox.InvokeFunc(func() {
+		link, lErr := netlink.LinkByName(dstName)
+		if lErr != nil {
+			return
+		}
+		mac = link.Attrs().HardwareAddr.String()
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return mac
+}
+
+func TestEndpointMacFromIP(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.(*controller).RegisterDriver("noaddr-test", &noAddrTestDriver{}, driverapi.Capability{}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, subnet, _ := net.ParseCIDR("192.168.62.0/24")
+	if err := c.CreateIpamPool("macip", subnet); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := c.NewNetwork("noaddr-test", "testmacip", NetworkOptionIpamPool("macip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	derived := func(ip net.IP) string {
+		ip4 := ip.To4()
+		return fmt.Sprintf("02:42:%02x:%02x:%02x:%02x", ip4[0], ip4[1], ip4[2], ip4[3])
+	}
+
+	ep, err := n.CreateEndpoint("ep1", CreateOptionMacFromIP())
+	if err != nil {
+		t.Fatal(err)
+	}
+	iface := ep.Info().InterfaceList()[0]
+	if got, expected := iface.MacAddress().String(), derived(iface.Address().IP); got != expected {
+		t.Fatalf("Expected mac address %s for address %s. Got %s", expected, iface.Address().IP, got)
+	}
+
+	sbx, err := c.NewSandbox("sandbox1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ep.Join(sbx); err != nil {
+		t.Fatal(err)
+	}
+	if got := sandboxMac(t, sbx); got != iface.MacAddress().String() {
+		t.Fatalf("Expected mac address %s in the sandbox. Got %s", iface.MacAddress(), got)
+	}
+
+	_, newSubnet, _ := net.ParseCIDR("192.168.63.0/24")
+	if err := c.UpdateIpamPool("macip", newSubnet); err != nil {
+		t.Fatal(err)
+	}
+	if err := ep.Renumber(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The mac address follows the new address
+	iface = ep.Info().InterfaceList()[0]
+	expected := derived(iface.Address().IP)
+	if got := iface.MacAddress().String(); got != expected {
+		t.Fatalf("Expected mac address %s after renumbering to %s. Got %s", expected, iface.Address().IP, got)
+	}
+	if got := sandboxMac(t, sbx); got != expected {
+		t.Fatalf("Expected mac address %s in the sandbox after renumbering. Got %s", expected, got)
+	}
+
+	// An explicit mac address takes precedence
+	explicit, _ := net.ParseMAC("02:00:00:00:00:01")
+	ep2, err := n.CreateEndpoint("ep2", CreateOptionMacFromIP(), EndpointOptionGeneric(map[string]interface{}{netlabel.MacAddress: explicit}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ep2.Info().InterfaceList()[0].MacAddress().String(); got != explicit.String() {
+		t.Fatalf("Expected the explicit mac address %s. Got %s", explicit, got)
+	}
+
+	// Without the option the mac address is left to the driver
+	ep3, err := n.CreateEndpoint("ep3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mac := ep3.Info().InterfaceList()[0].MacAddress(); len(mac) != 0 {
+		t.Fatalf("Expected no mac address from libnetwork. Got %s", mac)
+	}
+
+	if err := ep.Leave(sbx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sbx.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range []Endpoint{ep, ep2, ep3} {
+		if err := e.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := n.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.DeleteIpamPool("macip"); err != nil {
+		t.Fatal(err)
+	}
+
+	osl.GC()
+}
+
+func TestEndpointHealthProbe(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		failing = map[string]bool{}
+	)
+	defer func(f func(string, time.Duration) error) { probeDial = f }(probeDial)
+	probeDial = func(addr string, timeout time.Duration) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if failing[addr] {
+			return fmt.Errorf("connection refused")
+		}
+		return nil
+	}
+
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.(*controller).RegisterDriver("pool-test", &poolTestDriver{}, driverapi.Capability{}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, subnet, _ := net.ParseCIDR("192.168.70.0/24")
+	if err := c.CreateIpamPool("probe", subnet); err != nil {
+		t.Fatal(err)
+	}
+
+	nw, err := c.NewNetwork("pool-test", "testprobe", NetworkOptionIpamPool("probe"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := nw.(*network)
+
+	probe := CreateOptionHealthProbe(HealthProbe{Port: 5432, Interval: 10 * time.Millisecond, Threshold: 2})
+	active, err := n.CreateEndpoint("active", CreateOptionDNSNames([]string{"db"}), probe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	standby, err := n.CreateEndpoint("standby", CreateOptionDNSNames([]string{"db"}), probe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	activeIP := active.Info().InterfaceList()[0].Address().IP
+	standbyIP := standby.Info().InterfaceList()[0].Address().IP
+
+	// resolve waits for the name to resolve to the ip
+	resolve := func(name string, ip net.IP) {
+		for i := 0; i < 100; i++ {
+			n.Lock()
+			got := n.svcRecords[name]
+			n.Unlock()
+			if got.Equal(ip) {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("Expected %s to resolve to %v", name, ip)
+	}
+
+	resolve("db", standbyIP)
+
+	mu.Lock()
+	failing[net.JoinHostPort(standbyIP.String(), "5432")] = true
+	mu.Unlock()
+
+	resolve("db", activeIP)
+	resolve("standby", nil)
+
+	for _, r := range n.getSvcRecords() {
+		if r.IP == standbyIP.String() {
+			t.Fatalf("Unhealthy endpoint still resolved with %s", r.Hosts)
+		}
+	}
+
+	// The endpoint is resolved again once it recovers
+	mu.Lock()
+	failing = map[string]bool{}
+	mu.Unlock()
+
+	resolve("standby", standbyIP)
+
+	for _, ep := range []Endpoint{standby, active} {
+		if err := ep.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := n.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteIpamPool("probe"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// flakyStore is a mock store which can be made unreachable, or fail the
+// writes with a given error
+type flakyStore struct {
+	*datastore.MockStore
+	down    bool
+	failPut error
+	sync.Mutex
+}
+
+func (s *flakyStore) setDown(down bool) {
+	s.Lock()
+	s.down = down
+	s.Unlock()
+}
+
+func (s *flakyStore) setFailPut(err error) {
+	s.Lock()
+	s.failPut = err
+	s.Unlock()
+}
+
+func (s *flakyStore) Get(key string) (*store.KVPair, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.down {
+		return nil, store.ErrNotReachable
+	}
+	return s.MockStore.Get(key)
+}
+
+func (s *flakyStore) Exists(key string) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.down {
+		return false, store.ErrNotReachable
+	}
+	return s.MockStore.Exists(key)
+}
+
+func (s *flakyStore) AtomicPut(key string, newValue []byte, previous *store.KVPair, options *store.WriteOptions) (bool, *store.KVPair, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.down {
+		return false, nil, store.ErrNotReachable
+	}
+	if s.failPut != nil {
+		return false, nil, s.failPut
+	}
+	return s.MockStore.AtomicPut(key, newValue, previous, options)
+}
+
+func (s *flakyStore) AtomicDelete(key string, previous *store.KVPair) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.down {
+		return false, store.ErrNotReachable
+	}
+	return s.MockStore.AtomicDelete(key, previous)
+}
+
+type storeTestDriver struct {
+	poolTestDriver
+}
+
+func (d *storeTestDriver) CreateEndpoint(nid, eid string, epInfo driverapi.EndpointInfo, options map[string]interface{}) error {
+	return nil
+}
+
+func newFlakyStoreController(t *testing.T, cfgOptions ...config.Option) (*controller, *flakyStore) {
+	c, err := New(cfgOptions...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.(*controller).RegisterDriver("store-test", &storeTestDriver{}, driverapi.Capability{Scope: driverapi.GlobalScope}); err != nil {
+		t.Fatal(err)
+	}
+	fs := &flakyStore{MockStore: datastore.NewMockStore()}
+	SetTestDataStore(c, datastore.NewCustomDataStore(fs))
+	return c.(*controller), fs
+}
+
+func TestStoreUnavailableFail(t *testing.T) {
+	c, fs := newFlakyStoreController(t)
+
+	n1, err := c.NewNetwork("store-test", "network1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs.setDown(true)
+
+	// Reads are served from the in-memory state
+	if _, err := c.NetworkByName("network1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Networks()) != 1 {
+		t.Fatalf("Expected one network. Got %d", len(c.Networks()))
+	}
+
+	_, err = c.NewNetwork("store-test", "network2")
+	if _, ok := err.(*ErrStoreUnavailable); !ok {
+		t.Fatalf("Expected ErrStoreUnavailable creating a network. Got %v", err)
+	}
+	if _, ok := err.(types.RetryError); !ok {
+		t.Fatalf("Expected a retryable error. Got %T", err)
+	}
+	if _, err := c.NetworkByName("network2"); err == nil {
+		t.Fatal("Expected the failed network not to be left behind")
+	}
+
+	_, err = n1.CreateEndpoint("ep1")
+	if _, ok := err.(*ErrStoreUnavailable); !ok {
+		t.Fatalf("Expected ErrStoreUnavailable creating an endpoint. Got %v", err)
+	}
+	if cnt := n1.(*network).EndpointCnt(); cnt != 0 {
+		t.Fatalf("Expected the endpoint count to be restored. Got %d", cnt)
+	}
+
+	fs.setDown(false)
+
+	if _, err := c.NewNetwork("store-test", "network2"); err != nil {
+		t.Fatalf("Expected the retry to succeed once the store is back. Got %v", err)
+	}
+}
+
+func TestStoreUnavailableQueue(t *testing.T) {
+	defer func(d time.Duration) { storeRetryInterval = d }(storeRetryInterval)
+	storeRetryInterval = 10 * time.Millisecond
+
+	c, fs := newFlakyStoreController(t, config.OptionKVQueueWrites(true))
+
+	fs.setDown(true)
+
+	n, err := c.NewNetwork("store-test", "network1")
+	if err != nil {
+		t.Fatalf("Expected the write to be queued. Got %v", err)
+	}
+	ep, err := n.CreateEndpoint("ep1")
+	if err != nil {
+		t.Fatalf("Expected the write to be queued. Got %v", err)
+	}
+	if _, err := n.EndpointByName("ep1"); err != nil {
+		t.Fatal(err)
+	}
+
+	nKey := datastore.Key(n.(*network).Key()...)
+	epKey := datastore.Key(ep.(*endpoint).Key()...)
+
+	fs.setDown(false)
+
+	for i := 0; i < 100; i++ {
+		nOk, _ := fs.Exists(nKey)
+		epOk, _ := fs.Exists(epKey)
+		if nOk && epOk {
+			return
+		}
+		time.Sleep(storeRetryInterval)
+	}
+	t.Fatal("Queued writes were not flushed once the store came back")
+}
+
+func TestStoreRejectedWriteNotQueued(t *testing.T) {
+	defer func(d time.Duration) { storeRetryInterval = d }(storeRetryInterval)
+	storeRetryInterval = time.Hour
+
+	c, fs := newFlakyStoreController(t, config.OptionKVQueueWrites(true))
+
+	// A write the datastore rejects is returned, not queued
+	fs.setFailPut(store.ErrNotSupported)
+	if _, err := c.NewNetwork("store-test", "network1"); err != store.ErrNotSupported {
+		t.Fatalf("Expected the rejected write error to be returned. Got %v", err)
+	}
+	if n := len(c.storeQueue.ops); n != 0 {
+		t.Fatalf("Expected no queued write. Got %d", n)
+	}
+
+	// A connection error is an outage
+	fs.setFailPut(&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")})
+	if _, err := c.NewNetwork("store-test", "network1"); err != nil {
+		t.Fatalf("Expected the write to be queued. Got %v", err)
+	}
+	if n := len(c.storeQueue.ops); n != 1 {
+		t.Fatalf("Expected one queued write. Got %d", n)
+	}
+}
+
+func TestStoreQueueSnapshot(t *testing.T) {
+	defer func(d time.Duration) { storeRetryInterval = d }(storeRetryInterval)
+	storeRetryInterval = time.Hour
+
+	c, fs := newFlakyStoreController(t, config.OptionKVQueueWrites(true))
+
+	fs.setDown(true)
+	nw, err := c.NewNetwork("store-test", "network1", NetworkOptionLabels(map[string]string{"tier": "1"}))
+	if err != nil {
+		t.Fatalf("Expected the write to be queued. Got %v", err)
+	}
+	n := nw.(*network)
+
+	// Written again while the first write is queued, then changed without
+	// being written
+	n.Lock()
+	n.labels["tier"] = "2"
+	n.Unlock()
+	if err := c.updateNetworkToStore(n); err != nil {
+		t.Fatal(err)
+	}
+	n.Lock()
+	n.labels["tier"] = "3"
+	n.Unlock()
+
+	fs.setDown(false)
+	c.storeQueue.Lock()
+	left := c.flushStoreQueueLocked(c.store)
+	c.storeQueue.Unlock()
+	if left != 0 {
+		t.Fatalf("Expected the queued writes to be flushed. %d left", left)
+	}
+
+	pair, err := fs.Get(datastore.Key(n.Key()...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := &network{}
+	if err := stored.SetValue(pair.Value); err != nil {
+		t.Fatal(err)
+	}
+	if stored.labels["tier"] != "2" {
+		t.Fatalf("Expected the network as last written to be stored. Got labels %v", stored.labels)
+	}
+	if n.Index() != pair.LastIndex {
+		t.Fatalf("Expected the network index %d to be the stored one %d", n.Index(), pair.LastIndex)
+	}
+}
+
+func TestPauseBackgroundTasks(t *testing.T) {
+	defer func(d time.Duration) { storeRetryInterval = d }(storeRetryInterval)
+	storeRetryInterval = 10 * time.Millisecond
+
+	var (
+		mu      sync.Mutex
+		failing bool
+	)
+	defer func(f func(string, time.Duration) error) { probeDial = f }(probeDial)
+	probeDial = func(addr string, timeout time.Duration) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if failing {
+			return fmt.Errorf("connection refused")
+		}
+		return nil
+	}
+
+	// The probed endpoint needs an address, the flaky store one a datastore
+	pc, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pc.(*controller).RegisterDriver("pool-test", &poolTestDriver{}, driverapi.Capability{}); err != nil {
+		t.Fatal(err)
+	}
+	_, subnet, _ := net.ParseCIDR("192.168.71.0/24")
+	if err := pc.CreateIpamPool("pause", subnet); err != nil {
+		t.Fatal(err)
+	}
+	pn, err := pc.NewNetwork("pool-test", "network1", NetworkOptionIpamPool("pause"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	probe := CreateOptionHealthProbe(HealthProbe{Port: 5432, Interval: 10 * time.Millisecond, Threshold: 2})
+	probed, err := pn.CreateEndpoint("ep1", probe)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, fs := newFlakyStoreController(t, config.OptionKVQueueWrites(true))
+	n, err := c.NewNetwork("store-test", "network1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pc.PauseBackgroundTasks()
+	c.PauseBackgroundTasks()
+
+	// Changes made while paused are left alone
+	mu.Lock()
+	failing = true
+	mu.Unlock()
+
+	fs.setDown(true)
+	ep, err := n.CreateEndpoint("ep1")
+	if err != nil {
+		t.Fatalf("Expected the write to be queued. Got %v", err)
+	}
+	fs.setDown(false)
+	epKey := datastore.Key(ep.(*endpoint).Key()...)
+
+	time.Sleep(20 * storeRetryInterval)
+
+	if !probed.(*endpoint).isHealthy() {
+		t.Fatal("Health probe ran while the background tasks were paused")
+	}
+	if ok, _ := fs.Exists(epKey); ok {
+		t.Fatal("Queued write flushed while the background tasks were paused")
+	}
+
+	// And caught up with once resumed
+	pc.ResumeBackgroundTasks()
+	c.ResumeBackgroundTasks()
+
+	for i := 0; i < 100; i++ {
+		ok, _ := fs.Exists(epKey)
+		if ok && !probed.(*endpoint).isHealthy() {
+			if err := probed.Delete(); err != nil {
+				t.Fatal(err)
+			}
+			if err := pn.Delete(); err != nil {
+				t.Fatal(err)
+			}
+			return
+		}
+		time.Sleep(storeRetryInterval)
+	}
+	t.Fatal("Background tasks did not catch up once resumed")
+}
+
+// slowStore is a mock store taking delay to serve each atomic write
+type slowStore struct {
+	*datastore.MockStore
+	delay time.Duration
+}
+
+func (s *slowStore) AtomicPut(key string, newValue []byte, previous *store.KVPair, options *store.WriteOptions) (bool, *store.KVPair, error) {
+	time.Sleep(s.delay)
+	return s.MockStore.AtomicPut(key, newValue, previous, options)
+}
+
+func TestStoreMetrics(t *testing.T) {
+	c, fs := newFlakyStoreController(t)
+
+	if m := c.Metrics(); len(m.Store) != 0 {
+		t.Fatalf("Expected no store metrics before any store operation. Got %v", m.Store)
+	}
+
+	delay := 20 * time.Millisecond
+	SetTestDataStore(c, datastore.NewCustomDataStore(&slowStore{MockStore: fs.MockStore, delay: delay}))
+
+	if _, err := c.NewNetwork("store-test", "network1"); err != nil {
+		t.Fatal(err)
+	}
+
+	m, ok := c.Metrics().Store["AtomicPut"]
+	if !ok || m.Count == 0 {
+		t.Fatalf("Expected the network write to be accounted for. Got %v", c.Metrics().Store)
+	}
+	if m.Errors != 0 {
+		t.Fatalf("Unexpected errors accounted for the network write: %+v", m)
+	}
+	if m.MaxLatency < delay || m.Latency < m.MaxLatency {
+		t.Fatalf("Expected the latency to account for the store delay %v. Got %+v", delay, m)
+	}
+
+	// Failures are counted as well
+	SetTestDataStore(c, datastore.NewCustomDataStore(fs))
+	fs.setDown(true)
+	if _, err := c.NewNetwork("store-test", "network2"); err == nil {
+		t.Fatal("Expected the network creation to fail with the store down")
+	}
+	if m := c.Metrics().Store["AtomicPut"]; m.Errors == 0 {
+		t.Fatalf("Expected the failed network write to be accounted for. Got %+v", m)
+	}
+}
+
+func TestNetworkScope(t *testing.T) {
+	c, _ := newFlakyStoreController(t)
+
+	local, err := c.NewNetwork("null", "local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := local.Scope(); s != datastore.LocalScope {
+		t.Fatalf("Expected local scope for the null network. Got %q", s)
+	}
+
+	global, err := c.NewNetwork("store-test", "global")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := global.Scope(); s != datastore.GlobalScope {
+		t.Fatalf("Expected global scope for the store-test network. Got %q", s)
+	}
+
+	// The scope is persisted with the network
+	n := &network{}
+	if err := n.SetValue(global.(*network).Value()); err != nil {
+		t.Fatal(err)
+	}
+	if n.Scope() != datastore.GlobalScope {
+		t.Fatalf("Expected the global scope to be restored from the store. Got %q", n.Scope())
+	}
+}
+
+func TestListNetworks(t *testing.T) {
+	c, _ := newFlakyStoreController(t)
+
+	for i := 0; i < 25; i++ {
+		if _, err := c.NewNetwork("store-test", fmt.Sprintf("network%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	seen := make(map[string]bool)
+	var ids []string
+	for offset := 0; ; offset += 10 {
+		page, total, err := c.ListNetworks(offset, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total != 25 {
+			t.Fatalf("Expected a total of 25 networks. Got %d", total)
+		}
+		if len(page) == 0 {
+			break
+		}
+		for _, n := range page {
+			if seen[n.ID()] {
+				t.Fatalf("Network %s returned in more than one page", n.Name())
+			}
+			seen[n.ID()] = true
+			ids = append(ids, n.ID())
+		}
+	}
+
+	if len(ids) != 25 {
+		t.Fatalf("Expected the pages to cover the 25 networks. Got %d", len(ids))
+	}
+	if !sort.StringsAreSorted(ids) {
+		t.Fatalf("Expected the networks to be ordered by id. Got %v", ids)
+	}
+
+	// A zero limit returns the remaining networks
+	if page, _, err := c.ListNetworks(20, 0); err != nil || len(page) != 5 {
+		t.Fatalf("Expected the last 5 networks. Got %d, %v", len(page), err)
+	}
+
+	if _, _, err := c.ListNetworks(-1, 10); err == nil {
+		t.Fatal("Expected failure on a negative offset")
+	} else if _, ok := err.(types.BadRequestError); !ok {
+		t.Fatalf("Did not fail with expected error. Actual error: %v", err)
+	}
+}
+
+func TestNetworkQuota(t *testing.T) {
+	c, err := New(config.OptionNetworkQuota("tenant", "t1", 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.(*controller).RegisterDriver("pool-test", &poolTestDriver{}, driverapi.Capability{}); err != nil {
+		t.Fatal(err)
+	}
+
+	t1 := NetworkOptionLabels(map[string]string{"tenant": "t1"})
+	var nws []Network
+	for i := 0; i < 2; i++ {
+		n, err := c.NewNetwork("pool-test", fmt.Sprintf("t1net%d", i), t1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nws = append(nws, n)
+	}
+
+	if used, limit := c.NetworkQuota("tenant", "t1"); used != 2 || limit != 2 {
+		t.Fatalf("Expected 2 networks out of 2 for tenant t1. Got %d out of %d", used, limit)
+	}
+
+	_, err = c.NewNetwork("pool-test", "t1net2", t1)
+	if _, ok := err.(ErrQuotaExceeded); !ok {
+		t.Fatalf("Expected ErrQuotaExceeded for the third network of tenant t1. Got %v", err)
+	}
+	if _, ok := err.(types.ForbiddenError); !ok {
+		t.Fatalf("Expected a forbidden error. Got %v", err)
+	}
+	if _, err := c.NetworkByName("t1net2"); err == nil {
+		t.Fatal("Network over quota must not be created")
+	}
+
+	// Other tenants are not affected
+	for i := 0; i < 3; i++ {
+		if _, err := c.NewNetwork("pool-test", fmt.Sprintf("t2net%d", i), NetworkOptionLabels(map[string]string{"tenant": "t2"})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if used, limit := c.NetworkQuota("tenant", "t2"); used != 3 || limit != 0 {
+		t.Fatalf("Expected 3 networks and no quota for tenant t2. Got %d out of %d", used, limit)
+	}
+
+	// Deleting a network frees quota
+	if err := nws[0].Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.NewNetwork("pool-test", "t1net2", t1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// slowCreateDriver takes its time creating the networks and the endpoints
+type slowCreateDriver struct {
+	poolTestDriver
+	endpoints map[string]bool
+	sync.Mutex
+}
+
+func (d *slowCreateDriver) CreateNetwork(nid string, options map[string]interface{}) error {
+	time.Sleep(10 * time.Millisecond)
+	return nil
+}
+
+func (d *slowCreateDriver) CreateEndpoint(nid, eid string, epInfo driverapi.EndpointInfo, options map[string]interface{}) error {
+	time.Sleep(10 * time.Millisecond)
+	d.Lock()
+	d.endpoints[eid] = true
+	d.Unlock()
+	return nil
+}
+
+func (d *slowCreateDriver) created(eid string) bool {
+	d.Lock()
+	defer d.Unlock()
+	return d.endpoints[eid]
+}
+
+func TestNetworkQuotaConcurrent(t *testing.T) {
+	c, err := New(config.OptionNetworkQuota("tenant", "t1", 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.(*controller).RegisterDriver("slow-test", &slowCreateDriver{endpoints: map[string]bool{}}, driverapi.Capability{}); err != nil {
+		t.Fatal(err)
+	}
+
+	t1 := NetworkOptionLabels(map[string]string{"tenant": "t1"})
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		go func(i int) {
+			_, err := c.NewNetwork("slow-test", fmt.Sprintf("t1net%d", i), t1)
+			errs <- err
+		}(i)
+	}
+	created := 0
+	for i := 0; i < 5; i++ {
+		err := <-errs
+		if err == nil {
+			created++
+		} else if _, ok := err.(ErrQuotaExceeded); !ok {
+			t.Fatalf("Unexpected error creating a network concurrently: %v", err)
+		}
+	}
+	if created != 2 {
+		t.Fatalf("Expected the quota to allow 2 networks out of the concurrent creations. Got %d", created)
+	}
+	if used, _ := c.NetworkQuota("tenant", "t1"); used != 2 {
+		t.Fatalf("Expected 2 networks for tenant t1. Got %d", used)
+	}
+}
+
+func TestConcurrentEndpointExternalKeyWait(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &slowCreateDriver{endpoints: map[string]bool{}}
+	if err := c.(*controller).RegisterDriver("slow-test", d, driverapi.Capability{}); err != nil {
+		t.Fatal(err)
+	}
+	n, err := c.NewNetwork("slow-test", "slow0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		go func(i int) {
+			ep, err := n.CreateEndpoint(fmt.Sprintf("ep%d", i), CreateOptionExternalKey("ext-key-1"), CreateOptionNoAddress())
+			if err == nil && !d.created(ep.ID()) {
+				err = fmt.Errorf("creation %d returned endpoint %s before the driver created it", i, ep.ID())
+			}
+			errs <- err
+		}(i)
+	}
+	for i := 0; i < 4; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if eps := n.Endpoints(); len(eps) != 1 {
+		t.Fatalf("Expected a single endpoint for the external key. Got %d", len(eps))
+	}
+}
+
+// drainTestDriver tracks the connections of its endpoints, one of which
+// finishes every time they are counted
+type drainTestDriver struct {
+	poolTestDriver
+	draining bool
+	conns    int
+	stuck    bool
+}
+
+func (d *drainTestDriver) DrainEndpoint(nid, eid string, drain bool) error {
+	d.draining = drain
+	return nil
+}
+
+func (d *drainTestDriver) EndpointConnections(nid, eid string) (int, error) {
+	conns := d.conns
+	if d.conns > 0 && !d.stuck {
+		d.conns--
+	}
+	return conns, nil
+}
+
+func TestEndpointDrain(t *testing.T) {
+	defer func(d time.Duration) { drainPollInterval = d }(drainPollInterval)
+	drainPollInterval = time.Millisecond
+
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &drainTestDriver{conns: 3}
+	if err := c.(*controller).RegisterDriver("drain-test", d, driverapi.Capability{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.(*controller).RegisterDriver("pool-test", &poolTestDriver{}, driverapi.Capability{}); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := c.NewNetwork("drain-test", "drain0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep, err := n.CreateEndpoint("ep0", CreateOptionNoAddress())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ep.Drain(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if !d.draining || d.conns != 0 {
+		t.Fatalf("Expected the endpoint to be drained. Draining: %t, connections: %d", d.draining, d.conns)
+	}
+
+	d.conns, d.stuck = 1, true
+	if err := ep.Drain(10 * time.Millisecond); err == nil {
+		t.Fatal("Expected the drain to time out")
+	} else if _, ok := err.(types.TimeoutError); !ok {
+		t.Fatalf("Expected a timeout error. Got %v", err)
+	}
+
+	n2, err := c.NewNetwork("pool-test", "nodrain0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep2, err := n2.CreateEndpoint("ep0", CreateOptionNoAddress())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ep2.Drain(time.Second).(types.NotImplementedError); !ok {
+		t.Fatal("Expected a not implemented error draining an endpoint of a driver without drain support")
+	}
+}
+
+// partialDeleteDriver completes its part of a network delete, then fails it when asked to
+type partialDeleteDriver struct {
+	poolTestDriver
+	networks map[string]bool
+	failNext bool
+}
+
+func (d *partialDeleteDriver) CreateNetwork(nid string, options map[string]interface{}) error {
+	d.networks[nid] = true
+	return nil
+}
+
+func (d *partialDeleteDriver) DeleteNetwork(nid string) error {
+	if !d.networks[nid] {
+		return types.InternalMaskableErrorf("network %s does not exist", nid)
+	}
+	delete(d.networks, nid)
+	if d.failNext {
+		d.failNext = false
+		return fmt.Errorf("injected failure deleting network %s", nid)
+	}
+	return nil
+}
+
+func TestNetworkDeleteResume(t *testing.T) {
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := &partialDeleteDriver{networks: map[string]bool{}}
+	if err := c.(*controller).RegisterDriver("partial-delete", d, driverapi.Capability{}); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := c.NewNetwork("partial-delete", "network1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d.failNext = true
+	if err := n.Delete(); err == nil {
+		t.Fatal("Expected the injected failure")
+	}
+	if _, err := c.NetworkByID(n.ID()); err != nil {
+		t.Fatalf("Expected the half deleted network to be kept for a retry: %v", err)
+	}
+
+	if err := n.Delete(); err != nil {
+		t.Fatalf("Expected the retry to complete the delete: %v", err)
+	}
+	if _, err := c.NetworkByID(n.ID()); err == nil {
+		t.Fatal("Expected the network to be gone")
+	}
+	if len(d.networks) != 0 {
+		t.Fatalf("Expected the driver network to be gone. Got %v", d.networks)
+	}
+
+	if _, ok := n.Delete().(*UnknownNetworkError); !ok {
+		t.Fatal("Expected UnknownNetworkError deleting a fully deleted network")
+	}
+}
+
+// sandboxRouteGateway returns the gateway the sandbox routes the traffic
+// from src to dst through
+func sandboxRouteGateway(t *testing.T, sbx Sandbox, src, dst net.IP) net.IP {
+	var (
+		gw  net.IP
+		err error
+	)
+	if iErr := sbx.(*sandbox).osSbox.InvokeFunc(func() {
+		req := nl.NewNetlinkRequest(syscall.RTM_GETROUTE, syscall.NLM_F_REQUEST)
+		msg := &nl.RtMsg{}
+		msg.Family = syscall.AF_INET
+		msg.Src_len = 32
+		msg.Dst_len = 32
+		req.AddData(msg)
+		req.AddData(nl.NewRtAttr(syscall.RTA_SRC, src.To4()))
+		req.AddData(nl.NewRtAttr(syscall.RTA_DST, dst.To4()))
+
+		var msgs [][]byte
+		if msgs, err = req.Execute(syscall.NETLINK_ROUTE, syscall.RTM_NEWROUTE); err != nil {
+			return
+		}
+		for _, m := range msgs {
+			attrs, pErr := nl.ParseRouteAttr(m[msg.Len():])
+			if pErr != nil {
+				err = pErr
+				return
+			}
+			for _, a := range attrs {
+				if a.Attr.Type == syscall.RTA_GATEWAY {
+					gw = net.IP(a.Value)
+				}
+			}
+		}
+	}); iErr != nil {
+		t.Fatal(iErr)
+	}
+	if err != nil {
+		t.Fatalf("Failed to get the route from %s to %s: %v", src, dst, err)
+	}
+	return gw
+}
+
+func TestJoinRoutingTable(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.ConfigureNetworkDriver("bridge", map[string]interface{}{netlabel.GenericData: options.Generic{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var eps []Endpoint
+	for i, name := range []string{"rtable0", "rtable1"} {
+		n, err := c.NewNetwork("bridge", name, NetworkOptionGeneric(options.Generic{
+			netlabel.GenericData: options.Generic{
+				"BridgeName":            name,
+				"AllowNonDefaultBridge": true,
+			},
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer n.Delete()
+
+		ep, err := n.CreateEndpoint(fmt.Sprintf("ep%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ep.Delete()
+		eps = append(eps, ep)
+	}
+
+	sbx, err := c.NewSandbox("rtable_c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sbx.Delete()
+
+	if err := eps[0].Join(sbx, JoinOptionRoutingTable(100)); err != nil {
+		t.Fatal(err)
+	}
+	if err := eps[1].Join(sbx, JoinOptionRoutingTable(101)); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := net.ParseIP("8.8.8.8")
+	for _, ep := range eps {
+		src := ep.Info().InterfaceList()[0].Address().IP
+		want := ep.Info().Gateway()
+		if gw := sandboxRouteGateway(t, sbx, src, dst); !gw.Equal(want) {
+			t.Fatalf("Expected the traffic from %s to be routed through %s. Got %v", src, want, gw)
+		}
+	}
+
+	// Leave removes the rule and the table route, the endpoint can join back
+	if err := eps[1].Leave(sbx); err != nil {
+		t.Fatal(err)
+	}
+	if err := eps[1].Join(sbx, JoinOptionRoutingTable(101)); err != nil {
+		t.Fatal(err)
+	}
+	src := eps[1].Info().InterfaceList()[0].Address().IP
+	if gw := sandboxRouteGateway(t, sbx, src, dst); !gw.Equal(eps[1].Info().Gateway()) {
+		t.Fatalf("Expected the traffic from %s to be routed through %s after rejoin. Got %v", src, eps[1].Info().Gateway(), gw)
+	}
+
+	if err := eps[1].Leave(sbx); err != nil {
+		t.Fatal(err)
+	}
+	if err := eps[0].Leave(sbx); err != nil {
+		t.Fatal(err)
+	}
+
+	osl.GC()
+}
+
+// sandboxTableRoutes returns the destinations of the IPv4 routes of the
+// routing table in the sandbox, "default" for the default route
+func sandboxTableRoutes(t *testing.T, sbx Sandbox, table int) []string {
+	var (
+		dsts []string
+		err  error
+	)
+	if iErr := sbx.(*sandbox).osSbox.InvokeFunc(func() {
+		req := nl.NewNetlinkRequest(syscall.RTM_GETROUTE, syscall.NLM_F_DUMP)
+		req.AddData(&nl.RtMsg{RtMsg: syscall.RtMsg{Family: syscall.AF_INET}})
+
+		var msgs [][]byte
+		if msgs, err = req.Execute(syscall.NETLINK_ROUTE, syscall.RTM_NEWROUTE); err != nil {
+			return
+		}
+		for _, m := range msgs {
+			msg := nl.DeserializeRtMsg(m)
+			attrs, pErr := nl.ParseRouteAttr(m[msg.Len():])
+			if pErr != nil {
+				err = pErr
+				return
+			}
+			rtable := int(msg.Table)
+			dst := "default"
+			for _, a := range attrs {
+				switch a.Attr.Type {
+				case syscall.RTA_TABLE:
+					rtable = int(nl.NativeEndian().Uint32(a.Value))
+				case syscall.RTA_DST:
+					dst = (&net.IPNet{IP: net.IP(a.Value), Mask: net.CIDRMask(int(msg.Dst_len), 32)}).String()
+				}
+			}
+			if rtable == table {
+				dsts = append(dsts, dst)
+			}
+		}
+	}); iErr != nil {
+		t.Fatal(iErr)
+	}
+	if err != nil {
+		t.Fatalf("Failed to list the routes of table %d: %v", table, err)
+	}
+	sort.Strings(dsts)
+	return dsts
+}
+
+func TestJoinRoutingTableRoutes(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.ConfigureNetworkDriver("bridge", map[string]interface{}{netlabel.GenericData: options.Generic{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := c.NewNetwork("bridge", "rtable2", NetworkOptionGeneric(options.Generic{
+		netlabel.GenericData: options.Generic{
+			"BridgeName":            "rtable2",
+			"AllowNonDefaultBridge": true,
+		},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Delete()
+
+	ep, err := n.CreateEndpoint("ep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep.Delete()
+
+	sbx, err := c.NewSandbox("rtable_c2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sbx.Delete()
+
+	addr := ep.Info().InterfaceList()[0].Address()
+	subnet := &net.IPNet{IP: addr.IP.Mask(addr.Mask), Mask: addr.Mask}
+	// The bridge gateway is the first address of the subnet
+	nh := types.GetIPCopy(subnet.IP.To4())
+	nh[3]++
+	_, dst, _ := net.ParseCIDR("10.99.0.0/16")
+	if err := ep.Join(sbx, JoinOptionRoutingTable(100), JoinOptionRoute(dst, nh, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{dst.String(), subnet.String(), "default"}
+	sort.Strings(expected)
+	if routes := sandboxTableRoutes(t, sbx, 100); !reflect.DeepEqual(routes, expected) {
+		t.Fatalf("Expected the routes %v in table 100. Got %v", expected, routes)
+	}
+	for _, r := range sandboxTableRoutes(t, sbx, syscall.RT_TABLE_MAIN) {
+		if r == dst.String() {
+			t.Fatalf("Expected the route to %s in table 100 only", dst)
+		}
+	}
+
+	// The routes are verified in the table they were programmed into
+	dl, err := ep.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dl) != 0 {
+		t.Fatalf("Expected no discrepancies for an endpoint joined with a routing table. Got %v", dl)
+	}
+
+	if err := ep.Leave(sbx); err != nil {
+		t.Fatal(err)
+	}
+	if routes := sandboxTableRoutes(t, sbx, 100); len(routes) != 0 {
+		t.Fatalf("Expected table 100 to be empty after leave. Got %v", routes)
+	}
+
+	osl.GC()
+}
+
+// linkPromisc returns whether the link with the passed name, in the current
+// network namespace, is in promiscuous mode
+func linkPromisc(name string) (bool, error) {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return false, err
+	}
+
+	req := nl.NewNetlinkRequest(syscall.RTM_GETLINK, syscall.NLM_F_ACK)
+	msg := nl.NewIfInfomsg(syscall.AF_UNSPEC)
+	msg.Index = int32(link.Attrs().Index)
+	req.AddData(msg)
+
+	msgs, err := req.Execute(syscall.NETLINK_ROUTE, syscall.RTM_NEWLINK)
+	if err != nil {
+		return false, err
+	}
+	return nl.DeserializeIfInfomsg(msgs[0]).Flags&syscall.IFF_PROMISC != 0, nil
+}
+
+func TestEndpointPromiscuous(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.ConfigureNetworkDriver("bridge", map[string]interface{}{netlabel.GenericData: options.Generic{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := c.NewNetwork("bridge", "promisc0", NetworkOptionGeneric(options.Generic{
+		netlabel.GenericData: options.Generic{
+			"BridgeName":            "promisc0",
+			"AllowNonDefaultBridge": true,
+		},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Delete()
+
+	ep, err := n.CreateEndpoint("ep0", CreateOptionPromiscuous())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep.Delete()
+
+	info, err := ep.DriverInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if promisc, _ := info[netlabel.Promiscuous].(bool); !promisc {
+		t.Fatalf("Expected the driver info to report the promiscuous mode. Got %v", info)
+	}
+
+	sbx, err := c.NewSandbox("promisc_c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sbx.Delete()
+
+	if err := ep.Join(sbx); err != nil {
+		t.Fatal(err)
+	}
+
+	var promisc bool
+	if iErr := sbx.(*sandbox).osSbox.InvokeFunc(func() {
+		promisc, err = linkPromisc("eth0")
+	}); iErr != nil {
+		t.Fatal(iErr)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !promisc {
+		t.Fatal("Expected the sandbox interface to be in promiscuous mode")
+	}
+
+	// The interface is back out of the sandbox, without the promiscuous mode
+	if err := ep.Leave(sbx); err != nil {
+		t.Fatal(err)
+	}
+	if promisc, err = linkPromisc(ep.(*endpoint).iFaces[0].srcName); err != nil {
+		t.Fatal(err)
+	}
+	if promisc {
+		t.Fatal("Expected the promiscuous mode to be cleared on leave")
+	}
+
+	osl.GC()
+}
+
+func TestNetworkFDB(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.ConfigureNetworkDriver("bridge", map[string]interface{}{netlabel.GenericData: options.Generic{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := c.NewNetwork("bridge", "fdb0", NetworkOptionGeneric(options.Generic{
+		netlabel.GenericData: options.Generic{
+			"BridgeName":            "fdb0",
+			"AllowNonDefaultBridge": true,
+		},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Delete()
+
+	macs := map[string]string{}
+	for i := 0; i < 2; i++ {
+		ep, err := n.CreateEndpoint(fmt.Sprintf("ep%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ep.Delete()
+
+		sbx, err := c.NewSandbox(fmt.Sprintf("fdb_c%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sbx.Delete()
+
+		if err := ep.Join(sbx); err != nil {
+			t.Fatal(err)
+		}
+		defer ep.Leave(sbx)
+
+		// The bridge learns the endpoint MAC from the ARP request for the gateway
+		gw := ep.Info().Gateway()
+		if iErr := sbx.(*sandbox).osSbox.InvokeFunc(func() {
+			var conn net.Conn
+			if conn, err = net.Dial("udp", net.JoinHostPort(gw.String(), "9")); err == nil {
+				_, err = conn.Write([]byte("fdb"))
+				conn.Close()
+			}
+		}); iErr != nil {
+			t.Fatal(iErr)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		iface := ep.Info().InterfaceList()[0]
+		macs[iface.MacAddress().String()] = iface.Address().IP.String()
+	}
+
+	var fdb []FDBEntry
+	var neighs []NeighborEntry
+	for i := 0; i < 100; i++ {
+		if fdb, err = n.FDB(); err != nil {
+			t.Fatal(err)
+		}
+		if neighs, err = n.Neighbors(); err != nil {
+			t.Fatal(err)
+		}
+
+		learned := 0
+		for _, e := range fdb {
+			if _, ok := macs[e.MacAddress]; ok && !e.Permanent {
+				learned++
+			}
+		}
+		known := 0
+		for _, e := range neighs {
+			if ip, ok := macs[e.MacAddress]; ok && ip == e.IP && e.Interface == "fdb0" {
+				known++
+			}
+		}
+		if learned == len(macs) && known == len(macs) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for mac, ip := range macs {
+		found := false
+		for _, e := range fdb {
+			found = found || e.MacAddress == mac
+		}
+		if !found {
+			t.Fatalf("Endpoint MAC %s not in the FDB: %v", mac, fdb)
+		}
+		found = false
+		for _, e := range neighs {
+			found = found || (e.MacAddress == mac && e.IP == ip)
+		}
+		if !found {
+			t.Fatalf("Endpoint %s at %s not in the neighbor table: %v", ip, mac, neighs)
+		}
+	}
+
+	// The tables are part of the diagnostics report
+	r, err := c.Diagnostics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, nr := range r.Networks {
+		if nr.Name == "fdb0" && (len(nr.FDB) == 0 || nr.TablesError != "") {
+			t.Fatalf("Expected the FDB in the network report. Got %+v", nr)
+		}
+	}
+
+	osl.GC()
+}
+
+func TestJoinParentSandbox(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.ConfigureNetworkDriver("bridge", map[string]interface{}{netlabel.GenericData: options.Generic{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := c.NewNetwork("bridge", "nested0", NetworkOptionGeneric(options.Generic{
+		netlabel.GenericData: options.Generic{
+			"BridgeName":            "nested0",
+			"AllowNonDefaultBridge": true,
+		},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Delete()
+
+	ep, err := n.CreateEndpoint("ep0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep.Delete()
+	ip := ep.Info().InterfaceList()[0].Address().IP
+
+	parent, err := c.NewSandbox("parent_c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer parent.Delete()
+
+	child, err := c.NewSandbox("nested_c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer child.Delete()
+
+	// hasAddress tells whether the endpoint address is configured in the sandbox
+	hasAddress := func(sbx Sandbox) bool {
+		var addrs []netlink.Addr
+		if iErr := sbx.(*sandbox).osSbox.InvokeFunc(func() {
+			addrs, err = netlink.AddrList(nil, netlink.FAMILY_V4)
+		}); iErr != nil {
+			t.Fatal(iErr)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, a := range addrs {
+			if a.IP.Equal(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if err := ep.Join(child, JoinOptionParentSandbox(child)); err == nil {
+		t.Fatal("Expected failure joining with the sandbox as its own parent")
+	} else if _, ok := err.(types.BadRequestError); !ok {
+		t.Fatalf("Unexpected error type: %v", err)
+	}
+
+	gone, err := c.NewSandbox("gone_c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gone.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ep.Join(child, JoinOptionParentSandbox(gone)); err == nil {
+		t.Fatal("Expected failure joining with a deleted parent sandbox")
+	} else if _, ok := err.(types.NotFoundError); !ok {
+		t.Fatalf("Unexpected error type: %v", err)
+	}
+
+	if err := ep.Join(child, JoinOptionParentSandbox(parent)); err != nil {
+		t.Fatal(err)
+	}
+	if sid := ep.Info().Sandbox(); sid == nil || sid.ID() != child.ID() {
+		t.Fatalf("Expected the endpoint to be joined to the nested sandbox. Got %v", sid)
+	}
+	if !hasAddress(parent) {
+		t.Fatal("Expected the endpoint interface in the parent sandbox")
+	}
+	if hasAddress(child) {
+		t.Fatal("Unexpected endpoint interface in the nested sandbox")
+	}
+
+	if err := ep.Leave(child); err != nil {
+		t.Fatal(err)
+	}
+	if hasAddress(parent) {
+		t.Fatal("Expected the endpoint interface out of the parent sandbox after leave")
+	}
+
+	osl.GC()
+}
+
+func TestEndpointJoinedAt(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.ConfigureNetworkDriver("null", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := c.NewNetwork("null", "testnull")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep, err := n.CreateEndpoint("ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep.Delete()
+
+	if _, ok := ep.JoinedAt(); ok {
+		t.Fatal("Expected no join time before the endpoint joins")
+	}
+
+	sbx, err := c.NewSandbox("joined_at_c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sbx.Delete()
+
+	before := time.Now()
+	if err := ep.Join(sbx); err != nil {
+		t.Fatal(err)
+	}
+	after := time.Now()
+
+	joinedAt, ok := ep.JoinedAt()
+	if !ok {
+		t.Fatal("Expected a join time once the endpoint joined")
+	}
+	if joinedAt.Before(before) || joinedAt.After(after) {
+		t.Fatalf("Expected the join time to be between %v and %v. Got %v", before, after, joinedAt)
+	}
+
+	// The join time survives the endpoint reload from the store
+	b, err := json.Marshal(ep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rep := &endpoint{}
+	if err := json.Unmarshal(b, rep); err != nil {
+		t.Fatal(err)
+	}
+	if restored, ok := rep.JoinedAt(); !ok || !restored.Equal(joinedAt) {
+		t.Fatalf("Expected the reloaded join time to be %v. Got %v", joinedAt, restored)
+	}
+
+	if err := ep.Leave(sbx); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ep.JoinedAt(); ok {
+		t.Fatal("Expected no join time after the endpoint left")
+	}
+
+	osl.GC()
+}
+
+func TestContainerPortConflict(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.ConfigureNetworkDriver("null", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := c.NewNetwork("null", "testnull")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	web := types.TransportPort{Proto: types.TCP, Port: 80}
+	dns := types.TransportPort{Proto: types.UDP, Port: 53}
+
+	ep1, err := n.CreateEndpoint("ep1", CreateOptionExposedPorts([]types.TransportPort{web}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep2, err := n.CreateEndpoint("ep2", CreateOptionExposedPorts([]types.TransportPort{dns, web}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep3, err := n.CreateEndpoint("ep3", CreateOptionExposedPorts([]types.TransportPort{dns}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sbx, err := c.NewSandbox("port_conflict_c")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ep1.Join(sbx); err != nil {
+		t.Fatal(err)
+	}
+
+	err = ep2.Join(sbx)
+	if err == nil {
+		t.Fatal("Expected a conflict joining an endpoint exposing an already exposed container port")
+	}
+	pc, ok := err.(ErrContainerPortConflict)
+	if !ok {
+		t.Fatalf("Unexpected error type: %v", err)
+	}
+	if pc.Port != web || pc.Endpoint != "ep1" {
+		t.Fatalf("Unexpected conflict reported: %v", err)
+	}
+	if ep2.Info().Sandbox() != nil {
+		t.Fatal("Expected the conflicting endpoint not to be joined")
+	}
+
+	// The failed join did not hold the ports it could have claimed
+	if err := ep3.Join(sbx); err != nil {
+		t.Fatal(err)
+	}
+
+	// A port is available again once its endpoint leaves
+	for _, ep := range []Endpoint{ep1, ep3} {
+		if err := ep.Leave(sbx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ep2.Join(sbx); err != nil {
+		t.Fatal(err)
+	}
+	if err := ep2.Leave(sbx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sbx.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	for _, ep := range []Endpoint{ep1, ep2, ep3} {
+		if err := ep.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	osl.GC()
+}
+
+// restoreTestDriver gives the endpoints an address and, on join, a veth
+// interface for libnetwork to move into the sandbox
+type restoreTestDriver struct {
+	storeTestDriver
+	next byte
+}
+
+func (d *restoreTestDriver) CreateEndpoint(nid, eid string, epInfo driverapi.EndpointInfo, options map[string]interface{}) error {
+	if len(epInfo.Interfaces()) != 0 {
+		return nil
+	}
+	d.next++
+	return epInfo.AddInterface(1, nil, net.IPNet{IP: net.IPv4(192, 168, 210, d.next), Mask: net.CIDRMask(24, 32)}, net.IPNet{})
+}
+
+func (d *restoreTestDriver) Join(nid, eid string, sboxKey string, jinfo driverapi.JoinInfo, options map[string]interface{}) error {
+	name := "rst" + eid[:7]
+	if err := netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: name}, PeerName: "rsp" + eid[:7]}); err != nil {
+		return err
+	}
+	return jinfo.InterfaceNames()[0].SetNames(name, "eth")
+}
+
+func TestRestoreSandboxes(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	ds := datastore.NewCustomDataStore(datastore.NewMockStore())
+	newController := func() *controller {
+		c, err := New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.(*controller).RegisterDriver("restore-test", &restoreTestDriver{}, driverapi.Capability{Scope: driverapi.GlobalScope}); err != nil {
+			t.Fatal(err)
+		}
+		SetTestDataStore(c, ds)
+		return c.(*controller)
+	}
+
+	c := newController()
+	n, err := c.NewNetwork("restore-test", "restorenet")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep1, err := n.CreateEndpoint("ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep2, err := n.CreateEndpoint("ep2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sb1, err := c.NewSandbox("restore_c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sb2, err := c.NewSandbox("restore_c2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ep1.Join(sb1); err != nil {
+		t.Fatal(err)
+	}
+	if err := ep2.Join(sb2); err != nil {
+		t.Fatal(err)
+	}
+
+	// Crash: the interface of ep1 is gone while the network namespace of its
+	// sandbox is still there. The namespace of the ep2 sandbox is gone.
+	var delErr error
+	if err := sb1.(*sandbox).osSbox.InvokeFunc(func() {
+		var link netlink.Link
+		if link, delErr = netlink.LinkByName("eth0"); delErr == nil {
+			delErr = netlink.LinkDel(link)
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if delErr != nil {
+		t.Fatal(delErr)
+	}
+	if err := sb2.(*sandbox).osSbox.Destroy(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The restarted controller restores the networks, then the sandboxes
+	c = newController()
+	nws, err := c.getNetworksFromStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.processNetworkUpdate(nws, nil)
+	c.restoreSandboxes()
+
+	rsb, err := c.SandboxByID(sb1.ID())
+	if err != nil {
+		t.Fatalf("Expected the sandbox of ep1 to be restored: %v", err)
+	}
+	defer rsb.Delete()
+
+	expected := ep1.Info().InterfaceList()[0].Address()
+	var (
+		addrs    []netlink.Addr
+		addrsErr error
+	)
+	if err := rsb.(*sandbox).osSbox.InvokeFunc(func() {
+		var link netlink.Link
+		if link, addrsErr = netlink.LinkByName("eth0"); addrsErr == nil {
+			addrs, addrsErr = netlink.AddrList(link, netlink.FAMILY_V4)
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if addrsErr != nil {
+		t.Fatalf("Expected the interface of ep1 to be rebuilt in the sandbox: %v", addrsErr)
+	}
+	if len(addrs) != 1 || !addrs[0].IP.Equal(expected.IP) {
+		t.Fatalf("Expected the rebuilt interface to have address %s. Got %v", expected.IP, addrs)
+	}
+
+	if _, err := c.SandboxByID(sb2.ID()); err == nil {
+		t.Fatal("Expected the sandbox of ep2 not to be restored")
+	}
+	rn, err := c.NetworkByID(n.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rep2, err := rn.EndpointByID(ep2.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := rep2.JoinedAt(); ok {
+		t.Fatal("Expected the stale join of ep2 to be cleared")
+	}
+
+	osl.GC()
+}
+
+func TestEndpointOrigin(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	ds := datastore.NewCustomDataStore(datastore.NewMockStore())
+	newController := func() *controller {
+		c, err := New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.(*controller).RegisterDriver("restore-test", &restoreTestDriver{}, driverapi.Capability{Scope: driverapi.GlobalScope}); err != nil {
+			t.Fatal(err)
+		}
+		SetTestDataStore(c, ds)
+		return c.(*controller)
+	}
+
+	c := newController()
+	n, err := c.NewNetwork("restore-test", "originnet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetDefaultNetwork(n.ID()); err != nil {
+		t.Fatal(err)
+	}
+
+	ep, err := n.CreateEndpoint("ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o := ep.Origin(); o != EndpointOriginAPI {
+		t.Fatalf("Expected origin %s for the endpoint created through the API, got %s", EndpointOriginAPI, o)
+	}
+
+	// The sandbox gets an endpoint on the default network
+	sbx, err := c.NewSandbox("origin_c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sbx.Delete()
+	dep := sbx.(*sandbox).defaultEp
+	if dep == nil {
+		t.Fatal("Expected the sandbox to be attached to the default network")
+	}
+	if o := dep.Origin(); o != EndpointOriginAuto {
+		t.Fatalf("Expected origin %s for the default network endpoint, got %s", EndpointOriginAuto, o)
+	}
+	if err := ep.Join(sbx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The restarted controller restores both endpoints
+	c = newController()
+	nws, err := c.getNetworksFromStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.processNetworkUpdate(nws, nil)
+	c.restoreSandboxes()
+
+	rsb, err := c.SandboxByID(sbx.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rsb.Delete()
+
+	rn, err := c.NetworkByID(n.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{ep.ID(), dep.ID()} {
+		rep, err := rn.EndpointByID(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if o := rep.Origin(); o != EndpointOriginRestore {
+			t.Fatalf("Expected origin %s for the restored endpoint %s, got %s", EndpointOriginRestore, rep.Name(), o)
+		}
+	}
+
+	osl.GC()
+}
+
+// closeRecordingStore is a mock store reporting when it gets closed
+type closeRecordingStore struct {
+	*datastore.MockStore
+	closed chan struct{}
+}
+
+func (s *closeRecordingStore) Close() {
+	close(s.closed)
+}
+
+// startupContext is a Context done on cancel or once its deadline passes
+type startupContext struct {
+	done chan struct{}
+	once sync.Once
+	err  error
+}
+
+func newStartupContext(timeout time.Duration) *startupContext {
+	ctx := &startupContext{done: make(chan struct{})}
+	if timeout > 0 {
+		time.AfterFunc(timeout, func() { ctx.stop(errStartupDeadline) })
+	}
+	return ctx
+}
+
+var (
+	errStartupCanceled = errors.New("startup canceled")
+	errStartupDeadline = errors.New("startup deadline exceeded")
+)
+
+func (ctx *startupContext) stop(err error) {
+	ctx.once.Do(func() {
+		ctx.err = err
+		close(ctx.done)
+	})
+}
+
+func (ctx *startupContext) Done() <-chan struct{} {
+	return ctx.done
+}
+
+func (ctx *startupContext) Err() error {
+	select {
+	case <-ctx.done:
+		return ctx.err
+	default:
+		return nil
+	}
+}
+
+func TestNewWithContext(t *testing.T) {
+	// The overlay driver connects to the datastore on its own
+	cfgOptions := []config.Option{
+		config.OptionKVProvider("consul"),
+		config.OptionKVProviderURL("127.0.0.1:1"),
+		config.OptionDisableDriver("overlay"),
+	}
+
+	// An already canceled context returns right away
+	ctx := newStartupContext(0)
+	ctx.stop(errStartupCanceled)
+	start := time.Now()
+	_, err := NewWithContext(ctx, cfgOptions...)
+	sc, ok := err.(ErrStartupCanceled)
+	if !ok {
+		t.Fatalf("Expected ErrStartupCanceled with a canceled context. Got %v", err)
+	}
+	if sc.Err != errStartupCanceled {
+		t.Fatalf("Expected the error to hold the context error. Got %v", sc.Err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("Expected the startup to return promptly. Took %v", d)
+	}
+
+	// A datastore connection hanging is given up on the deadline, the
+	// connection is closed once established
+	release := make(chan struct{})
+	ks := &closeRecordingStore{MockStore: datastore.NewMockStore(), closed: make(chan struct{})}
+	defer func(f func(*config.DatastoreCfg) (datastore.DataStore, error)) { newDataStore = f }(newDataStore)
+	newDataStore = func(*config.DatastoreCfg) (datastore.DataStore, error) {
+		<-release
+		return datastore.NewCustomDataStore(ks), nil
+	}
+
+	ctx = newStartupContext(50 * time.Millisecond)
+	defer ctx.stop(errStartupCanceled)
+	start = time.Now()
+	_, err = NewWithContext(ctx, cfgOptions...)
+	if sc, ok := err.(ErrStartupCanceled); !ok || sc.Err != errStartupDeadline {
+		t.Fatalf("Expected the startup to fail on the context deadline. Got %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("Expected the startup to return promptly. Took %v", d)
+	}
+
+	close(release)
+	select {
+	case <-ks.closed:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the late datastore connection to be closed")
+	}
+}
+
+// failJoinTestDriver fails the joins while failJoin is set
+type failJoinTestDriver struct {
+	storeTestDriver
+	failJoin bool
+}
+
+func (d *failJoinTestDriver) Join(nid, eid string, sboxKey string, jinfo driverapi.JoinInfo, options map[string]interface{}) error {
+	if d.failJoin {
+		return fmt.Errorf("join failure injected for endpoint %s", eid)
+	}
+	return nil
+}
+
+func TestEndpointFailureCounts(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := &failJoinTestDriver{failJoin: true}
+	if err := c.(*controller).RegisterDriver("failjoin-test", d, driverapi.Capability{}); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := c.NewNetwork("failjoin-test", "failjoinnet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Delete()
+
+	ep, err := n.CreateEndpoint("ep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep.Delete()
+
+	sbx, err := c.NewSandbox("failjoin_c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sbx.Delete()
+
+	// The failed joins are accounted from concurrent callers
+	const joins = 8
+	var wg sync.WaitGroup
+	for i := 0; i < joins; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := ep.Join(sbx); err == nil {
+				t.Error("Expected the join to fail")
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Leaving a sandbox not joined fails
+	if err := ep.Leave(sbx); err == nil {
+		t.Fatal("Expected the leave to fail")
+	}
+
+	if fc := ep.FailureCounts(); fc != (FailureCounts{Join: joins, Leave: 1}) {
+		t.Fatalf("Expected %d failed joins and 1 failed leave. Got %+v", joins, fc)
+	}
+
+	r, err := c.Diagnostics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, nr := range r.Networks {
+		for _, er := range nr.Endpoints {
+			if er.ID == ep.ID() && (er.JoinFailures != joins || er.LeaveFailures != 1) {
+				t.Fatalf("Expected the diagnostics to report the failures. Got %d and %d", er.JoinFailures, er.LeaveFailures)
+			}
+		}
+	}
+
+	ep.ResetFailureCounts()
+	if fc := ep.FailureCounts(); fc != (FailureCounts{}) {
+		t.Fatalf("Expected the counters to be reset. Got %+v", fc)
+	}
+
+	// A successful join and leave leave the counters alone
+	d.failJoin = false
+	if err := ep.Join(sbx); err != nil {
+		t.Fatal(err)
+	}
+	if err := ep.Leave(sbx); err != nil {
+		t.Fatal(err)
+	}
+	if fc := ep.FailureCounts(); fc != (FailureCounts{}) {
+		t.Fatalf("Expected no failure after a successful join and leave. Got %+v", fc)
+	}
+
+	osl.GC()
+}
+
+func TestForeignSandbox(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	c1, err := New(config.OptionInstanceID("ctrl1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := New(config.OptionInstanceID("ctrl2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c1.ID() != "ctrl1" || c2.ID() != "ctrl2" {
+		t.Fatalf("Unexpected controller IDs %s and %s", c1.ID(), c2.ID())
+	}
+
+	sbx, err := c1.NewSandbox("sandbox1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sbx.Delete()
+	if sbx.Owner() != "ctrl1" {
+		t.Fatalf("Unexpected sandbox owner %s", sbx.Owner())
+	}
+
+	err = c2.DeleteSandbox(sbx)
+	if _, ok := err.(ErrForeignSandbox); !ok {
+		t.Fatalf("Expected ErrForeignSandbox, got %v", err)
+	}
+	if _, ok := err.(types.ForbiddenError); !ok {
+		t.Fatalf("Expected a forbidden error, got %v", err)
+	}
+	if _, err := c1.SandboxByID(sbx.ID()); err != nil {
+		t.Fatalf("Sandbox deleted by the foreign controller: %v", err)
+	}
+
+	if err := c2.ConfigureNetworkDriver("null", nil); err != nil {
+		t.Fatal(err)
+	}
+	n, err := c2.NewNetwork("null", "testnull")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep, err := n.CreateEndpoint("ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep.Delete()
+
+	if err := ep.Join(sbx); err == nil {
+		t.Fatal("Expected the join to a foreign sandbox to fail")
+	}
+
+	if err := c1.DeleteSandbox(sbx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPortMappingTarget(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	c, nw1, nw2 := getTestEnv(t)
+
+	ep2, err := nw1.CreateEndpoint("sidecar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep2.Delete()
+
+	bindings := []types.PortBinding{{Proto: types.TCP, Port: uint16(8080), HostPort: uint16(18080)}}
+	if _, err := nw2.CreateEndpoint("ep", CreateOptionPortMapping(bindings), CreateOptionPortMappingTarget(ep2)); err == nil {
+		t.Fatal("Expected a port mapping target on another network to be rejected")
+	} else if _, ok := err.(types.BadRequestError); !ok {
+		t.Fatalf("Expected a bad request error, got %v", err)
+	}
+
+	ep1, err := nw1.CreateEndpoint("ep1", CreateOptionPortMapping(bindings), CreateOptionPortMappingTarget(ep2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep1.Delete()
+
+	info, err := ep1.DriverInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pbs, ok := info[netlabel.PortMap].([]types.PortBinding)
+	if !ok || len(pbs) != 1 {
+		t.Fatalf("Unexpected port mappings %v", info[netlabel.PortMap])
+	}
+	// The DNAT destination of the mapping is the sidecar address
+	target := ep2.Info().InterfaceList()[0].Address().IP
+	if !pbs[0].IP.Equal(target) {
+		t.Fatalf("Port mapping forwarded to %s, expected the sidecar address %s", pbs[0].IP, target)
+	}
+	if pbs[0].IP.Equal(ep1.Info().InterfaceList()[0].Address().IP) {
+		t.Fatal("Port mapping forwarded to the publishing endpoint")
+	}
+
+	sb1, err := c.NewSandbox("sandbox1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sb1.Delete()
+	sb2, err := c.NewSandbox("sandbox2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sb2.Delete()
+
+	if err := ep2.Join(sb1); err != nil {
+		t.Fatal(err)
+	}
+	defer ep2.Leave(sb1)
+	if err := ep1.Join(sb2); err == nil {
+		t.Fatal("Expected the join to a sandbox other than the target one to fail")
+	}
+}
+
+// dnsQuery returns a DNS query message for the A record of name
+func dnsQuery(id uint16, name string) []byte {
+	msg := []byte{byte(id >> 8), byte(id), 1, 0, 0, 1, 0, 0, 0, 0, 0, 0}
+	for _, l := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		msg = append(msg, byte(len(l)))
+		msg = append(msg, l...)
+	}
+	return append(msg, 0, 0, 1, 0, 1)
+}
+
+// fakeNameserver answers the queries it gets with the query itself and
+// reports their names on the returned channel
+func fakeNameserver(t *testing.T, invoke func(func())) (string, chan string) {
+	var (
+		conn net.PacketConn
+		err  error
+	)
+	invoke(func() { conn, err = net.ListenPacket("udp", "127.0.0.1:0") })
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make(chan string, 10)
+	go func() {
+		buf := make([]byte, dnsMaxMsgSize)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			name, _ := dnsQuestionName(buf[:n])
+			names <- name
+			conn.WriteTo(buf[:n], addr)
+		}
+	}()
+	return conn.LocalAddr().String(), names
+}
+
+func TestDNSForwarder(t *testing.T) {
+	// The sockets are all created in the namespace, like the resolver does
+	s, err := osl.NewSandbox(osl.GenerateKey("dnsforwarder"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Destroy()
+	invoke := func(f func()) {
+		if err := s.InvokeFunc(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	corp, corpNames := fakeNameserver(t, invoke)
+	upstream, upstreamNames := fakeNameserver(t, invoke)
+
+	var conn net.PacketConn
+	invoke(func() { conn, err = net.ListenPacket("udp", "127.0.0.1:0") })
+	if err != nil {
+		t.Fatal(err)
+	}
+	fwd := newDNSForwarder(conn, []string{upstream}, func(addr string) (c net.Conn, err error) {
+		if nErr := s.InvokeFunc(func() { c, err = net.Dial("udp", addr) }); nErr != nil {
+			return nil, nErr
+		}
+		return c, err
+	})
+	fwd.setRules(addDNSForward(nil, "*.corp", []string{corp}))
+	go fwd.serve()
+	defer fwd.stop()
+
+	var client net.Conn
+	invoke(func() { client, err = net.Dial("udp", conn.LocalAddr().String()) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	for i, tc := range []struct {
+		name     string
+		expected chan string
+		other    chan string
+	}{
+		{"git.eng.corp.", corpNames, upstreamNames},
+		{"CORP.", corpNames, upstreamNames},
+		{"example.com.", upstreamNames, corpNames},
+		{"notcorp.", upstreamNames, corpNames},
+	} {
+		query := dnsQuery(uint16(i+1), tc.name)
+		if _, err := client.Write(query); err != nil {
+			t.Fatal(err)
+		}
+		client.SetReadDeadline(time.Now().Add(5 * time.Second))
+		resp := make([]byte, dnsMaxMsgSize)
+		n, err := client.Read(resp)
+		if err != nil {
+			t.Fatalf("No answer to the query for %s: %v", tc.name, err)
+		}
+		if string(resp[:n]) != string(query) {
+			t.Fatalf("Unexpected answer to the query for %s", tc.name)
+		}
+
+		select {
+		case name := <-tc.expected:
+			if name != tc.name {
+				t.Fatalf("Nameserver got a query for %s, expected %s", name, tc.name)
+			}
+		default:
+			t.Fatalf("Query for %s not forwarded to the expected nameserver", tc.name)
+		}
+		select {
+		case name := <-tc.other:
+			t.Fatalf("Query for %s forwarded to the wrong nameserver", name)
+		default:
+		}
+	}
+}
+
+func TestSandboxDNSForward(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ConfigureNetworkDriver("null", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.NewNetwork("null", "badfwd", NetworkOptionDNSForward("corp", "resolver.corp")); err == nil {
+		t.Fatal("Expected a forwarding rule with an invalid nameserver to be rejected")
+	} else if _, ok := err.(types.BadRequestError); !ok {
+		t.Fatalf("Expected a bad request error, got %v", err)
+	}
+
+	n, err := c.NewNetwork("null", "testfwd",
+		NetworkOptionDNSForward("*.corp", "10.1.1.1"),
+		NetworkOptionDNSForward("lab.example", "10.3.3.3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep, err := n.CreateEndpoint("ep1", CreateOptionDNSForward(".corp", "10.2.2.2", "10.2.2.3:5353"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep.Delete()
+
+	sbx, err := c.NewSandbox("sandbox1", OptionDNS("10.9.9.9"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sbx.Delete()
+	if err := ep.Join(sbx); err != nil {
+		t.Fatal(err)
+	}
+	defer ep.Leave(sbx)
+
+	sb := sbx.(*sandbox)
+	rules := sb.dnsForwardRules()
+	if !reflect.DeepEqual(rules["corp."], []string{"10.2.2.2:53", "10.2.2.3:5353"}) {
+		t.Fatalf("Expected the endpoint rule to win for corp, got %v", rules["corp."])
+	}
+	if !reflect.DeepEqual(rules["lab.example."], []string{"10.3.3.3:53"}) {
+		t.Fatalf("Expected the network rule for lab.example, got %v", rules["lab.example."])
+	}
+
+	if sb.resolver == nil {
+		t.Fatal("Embedded resolver not started")
+	}
+	content, err := ioutil.ReadFile(sb.config.resolvConfPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ns := resolvconf.GetNameservers(content); !reflect.DeepEqual(ns, []string{resolverIP, "10.9.9.9"}) {
+		t.Fatalf("Expected resolv.conf to list the embedded resolver first, got %v", ns)
+	}
+
+	// Placed last, the resolver stays among the nameservers the clients try
+	ep2, err := n.CreateEndpoint("ep2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep2.Delete()
+
+	sbx2, err := c.NewSandbox("sandbox2", OptionEmbeddedResolverLast(),
+		OptionDNS("10.9.9.7"), OptionDNS("10.9.9.8"), OptionDNS("10.9.9.9"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sbx2.Delete()
+	if err := ep2.Join(sbx2); err != nil {
+		t.Fatal(err)
+	}
+	defer ep2.Leave(sbx2)
+
+	sb2 := sbx2.(*sandbox)
+	content, err = ioutil.ReadFile(sb2.config.resolvConfPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ns := resolvconf.GetNameservers(content); !reflect.DeepEqual(ns, []string{"10.9.9.7", "10.9.9.8", resolverIP}) {
+		t.Fatalf("Expected resolv.conf to list the embedded resolver last, got %v", ns)
+	}
+	// The resolver still forwards to all the host nameservers
+	if up := sb2.resolver.nameservers("example.com."); !reflect.DeepEqual(up, []string{"10.9.9.7:53", "10.9.9.8:53", "10.9.9.9:53"}) {
+		t.Fatalf("Expected the resolver to forward to all the host nameservers, got %v", up)
+	}
+}
+
+func TestSandboxDNSForwardHostBackend(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	c, err := New(config.OptionSandboxBackend(osl.HostBackend))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ConfigureNetworkDriver("null", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := c.NewNetwork("null", "testfwd", NetworkOptionDNSForward("*.corp", "10.1.1.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep, err := n.CreateEndpoint("ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep.Delete()
+
+	sbx, err := c.NewSandbox("host_backend_fwd", OptionDNS("10.9.9.9"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sbx.Delete()
+	if err := ep.Join(sbx); err != nil {
+		t.Fatal(err)
+	}
+	defer ep.Leave(sbx)
+
+	// The resolver would have to listen in the host namespace
+	sb := sbx.(*sandbox)
+	if sb.resolver != nil {
+		t.Fatal("Embedded resolver started for a host backend sandbox")
+	}
+	content, err := ioutil.ReadFile(sb.config.resolvConfPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ns := resolvconf.GetNameservers(content); !reflect.DeepEqual(ns, []string{"10.9.9.9"}) {
+		t.Fatalf("Expected resolv.conf to list the configured nameserver only, got %v", ns)
+	}
+}
+
+func TestResolverNameservers(t *testing.T) {
+	for _, c := range []struct {
+		host     []string
+		last     bool
+		expected []string
+	}{
+		{nil, false, []string{resolverIP}},
+		{nil, true, []string{resolverIP}},
+		{[]string{"10.0.0.1"}, false, []string{resolverIP, "10.0.0.1"}},
+		{[]string{"10.0.0.1"}, true, []string{"10.0.0.1", resolverIP}},
+		{[]string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}, false, []string{resolverIP, "10.0.0.1", "10.0.0.2"}},
+		{[]string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}, true, []string{"10.0.0.1", "10.0.0.2", resolverIP}},
+	} {
+		if ns := resolverNameservers(c.host, c.last); !reflect.DeepEqual(ns, c.expected) {
+			t.Fatalf("Expected nameservers %v for %v with the resolver last %t, got %v", c.expected, c.host, c.last, ns)
+		}
+	}
+}
+
+func TestEndpointEffectiveConfig(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.ConfigureNetworkDriver("bridge", map[string]interface{}{netlabel.GenericData: options.Generic{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	ip, subnet, err := net.ParseCIDR("192.168.61.1/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	subnet.IP = ip
+	gw := net.ParseIP("192.168.61.254")
+	n, err := c.NewNetwork("bridge", "effective", NetworkOptionGeneric(options.Generic{
+		netlabel.GenericData: options.Generic{
+			"BridgeName":            "effective",
+			"AddressIPv4":           subnet,
+			"Mtu":                   1400,
+			"AllowNonDefaultBridge": true,
+		},
+	}),
+		NetworkOptionGateway(gw),
+		NetworkOptionDNSForward("corp", "10.1.1.1"),
+		NetworkOptionDNSForward("lab.example", "10.3.3.3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Delete()
+
+	ep, err := n.CreateEndpoint("ep", CreateOptionMacFromIP(), CreateOptionDNSForward("corp", "10.2.2.2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep.Delete()
+
+	cfg, err := ep.EffectiveConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MTU != 1400 || cfg.Sources["MTU"] != ConfigSourceNetwork {
+		t.Fatalf("Expected the network MTU 1400, got %d from %s", cfg.MTU, cfg.Sources["MTU"])
+	}
+	if cfg.Address == nil || cfg.Sources["Address"] != ConfigSourceDriver {
+		t.Fatalf("Expected a driver allocated address, got %v from %s", cfg.Address, cfg.Sources["Address"])
+	}
+	if mac := netutils.GenerateMACFromIP(cfg.Address.IP); cfg.MacAddress.String() != mac.String() || cfg.Sources["MacAddress"] != ConfigSourceEndpoint {
+		t.Fatalf("Expected the endpoint MAC address %s, got %s from %s", mac, cfg.MacAddress, cfg.Sources["MacAddress"])
+	}
+	if !reflect.DeepEqual(cfg.DNSForward["corp."], []string{"10.2.2.2:53"}) || cfg.DNSForwardSources["corp."] != ConfigSourceEndpoint {
+		t.Fatalf("Expected the endpoint rule to win for corp, got %v from %s", cfg.DNSForward["corp."], cfg.DNSForwardSources["corp."])
+	}
+	if !reflect.DeepEqual(cfg.DNSForward["lab.example."], []string{"10.3.3.3:53"}) || cfg.DNSForwardSources["lab.example."] != ConfigSourceNetwork {
+		t.Fatalf("Expected the network rule for lab.example, got %v from %s", cfg.DNSForward["lab.example."], cfg.DNSForwardSources["lab.example."])
+	}
+	if cfg.Gateway != nil || len(cfg.DNS) != 0 {
+		t.Fatalf("Expected no gateway nor DNS before join, got %v and %v", cfg.Gateway, cfg.DNS)
+	}
+
+	sbx, err := c.NewSandbox("effective_c", OptionDNS("10.9.9.9"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sbx.Delete()
+
+	_, dst, _ := net.ParseCIDR("10.99.0.0/16")
+	if err := ep.Join(sbx, JoinOptionRoute(dst, gw, 0)); err != nil {
+		t.Fatal(err)
+	}
+	defer ep.Leave(sbx)
+
+	if cfg, err = ep.EffectiveConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.Gateway.Equal(gw) || cfg.Sources["Gateway"] != ConfigSourceNetwork {
+		t.Fatalf("Expected the network gateway %s, got %s from %s", gw, cfg.Gateway, cfg.Sources["Gateway"])
+	}
+	if len(cfg.StaticRoutes) != 1 || cfg.StaticRoutes[0].Destination.String() != dst.String() || cfg.RouteSources[0] != ConfigSourceJoin {
+		t.Fatalf("Expected the join route to %s, got %v from %v", dst, cfg.StaticRoutes, cfg.RouteSources)
+	}
+	if !reflect.DeepEqual(cfg.DNS, []string{resolverIP, "10.9.9.9"}) || cfg.Sources["DNS"] != ConfigSourceSandbox {
+		t.Fatalf("Expected the embedded resolver and the sandbox nameserver, got %v from %s", cfg.DNS, cfg.Sources["DNS"])
+	}
+
+	osl.GC()
+}
+
+// waitLinkState waits for the link state event of the endpoint with the passed state
+func waitLinkState(t *testing.T, events <-chan MembershipEvent, ep Endpoint, up bool) MembershipEvent {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev := <-events:
+			if ev.Type == LinkStateChanged && ev.EndpointID == ep.ID() && ev.LinkUp == up {
+				return ev
+			}
+		case <-timeout:
+			t.Fatalf("Timed out waiting for the link of endpoint %s to go up=%t", ep.Name(), up)
+		}
+	}
+}
+
+func TestLinkStateChanged(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.ConfigureNetworkDriver("bridge", map[string]interface{}{netlabel.GenericData: options.Generic{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := c.NewNetwork("bridge", "linkstate", NetworkOptionGeneric(options.Generic{
+		netlabel.GenericData: options.Generic{
+			"BridgeName":            "linkstate",
+			"AllowNonDefaultBridge": true,
+		},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Delete()
+
+	ep, err := n.CreateEndpoint("ep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ep.Delete()
+
+	sbx, err := c.NewSandbox("linkstate_c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sbx.Delete()
+
+	if err := ep.Join(sbx); err != nil {
+		t.Fatal(err)
+	}
+	defer ep.Leave(sbx)
+
+	events, cancel := n.Watch()
+	defer cancel()
+
+	sb := sbx.(*sandbox)
+	var name string
+	for _, si := range sb.osSbox.Info().Interfaces() {
+		if ep.(*endpoint).hasInterface(si.SrcName()) {
+			name = si.DstName()
+		}
+	}
+	if name == "" {
+		t.Fatal("Endpoint interface not found in the sandbox")
+	}
+
+	setLink := func(up bool) {
+		if nErr := sb.osSbox.InvokeFunc(func() {
+			var link netlink.Link
+			if link, err = netlink.LinkByName(name); err != nil {
+				return
+			}
+			if up {
+				err = netlink.LinkSetUp(link)
+			} else {
+				err = netlink.LinkSetDown(link)
+			}
+		}); nErr != nil {
+			t.Fatal(nErr)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	setLink(false)
+	ev := waitLinkState(t, events, ep, false)
+	if ev.Interface != name || ev.SandboxID != sbx.ID() {
+		t.Fatalf("Expected the link down event of interface %s in sandbox %s, got %+v", name, sbx.ID(), ev)
+	}
+
+	setLink(true)
+	waitLinkState(t, events, ep, true)
+
+	osl.GC()
+}
+
+func TestMergeGenericOptions(t *testing.T) {
+	stored := map[string]interface{}{
+		netlabel.GenericData: options.Generic{"BridgeName": "br0", "Mtu": 1500},
+		"other":              "kept",
+	}
+	merged, err := mergeGenericOptions(stored, map[string]interface{}{
+		netlabel.GenericData: map[string]interface{}{"Mtu": 9000},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := merged[netlabel.GenericData].(options.Generic)
+	if data["BridgeName"] != "br0" || data["Mtu"] != 9000 || merged["other"] != "kept" {
+		t.Fatalf("Unexpected merged options: %v", merged)
+	}
+
+	// Driver specific data cannot be merged into
+	stored = map[string]interface{}{netlabel.GenericData: &struct{ Mtu int }{Mtu: 1500}}
+	_, err = mergeGenericOptions(stored, map[string]interface{}{
+		netlabel.GenericData: map[string]interface{}{"Mtu": 9000},
+	})
+	if _, ok := err.(types.BadRequestError); !ok {
+		t.Fatalf("Expected a bad request error merging into driver specific data. Got %v", err)
+	}
+}
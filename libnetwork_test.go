@@ -2,6 +2,7 @@ package libnetwork_test
 
 import (
 	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -9,8 +10,11 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"reflect"
 	"runtime"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
 
@@ -18,12 +22,14 @@ import (
 	"github.com/docker/docker/pkg/plugins"
 	"github.com/docker/docker/pkg/reexec"
 	"github.com/docker/libnetwork"
+	"github.com/docker/libnetwork/config"
 	"github.com/docker/libnetwork/datastore"
 	"github.com/docker/libnetwork/driverapi"
 	"github.com/docker/libnetwork/netlabel"
 	"github.com/docker/libnetwork/netutils"
 	"github.com/docker/libnetwork/options"
 	"github.com/docker/libnetwork/osl"
+	"github.com/docker/libnetwork/resolvconf"
 	"github.com/docker/libnetwork/types"
 	"github.com/vishvananda/netlink"
 	"github.com/vishvananda/netns"
@@ -255,6 +261,31 @@ func TestHost(t *testing.T) {
 	}
 }
 
+func TestDisabledHostDriver(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	c, err := libnetwork.New(config.OptionDisableDriver("host"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	libnetwork.SetTestDataStore(c, datastore.NewCustomDataStore(datastore.NewMockStore()))
+
+	_, err = c.NewNetwork("host", "testhost")
+	if err == nil {
+		t.Fatal("Expected to fail creating a network with the disabled host driver")
+	}
+	if _, ok := err.(types.NotFoundError); !ok {
+		t.Fatalf("Did not fail with expected error. Actual error: %v", err)
+	}
+
+	// The other special network is still available
+	if _, err := c.NewNetwork("null", "testnull"); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestBridge(t *testing.T) {
 	if !netutils.IsRunningInContainer() {
 		defer netutils.SetupTestNetNS(t)()
@@ -770,392 +801,2342 @@ func TestDuplicateEndpoint(t *testing.T) {
 	}
 }
 
-func TestControllerQuery(t *testing.T) {
+func TestDuplicateEndpointExternalKey(t *testing.T) {
 	if !netutils.IsRunningInContainer() {
 		defer netutils.SetupTestNetNS(t)()
 	}
 
-	// Create network 1
 	netOption := options.Generic{
 		netlabel.GenericData: options.Generic{
-			"BridgeName":            "network1",
+			"BridgeName":            "testnetwork",
 			"AllowNonDefaultBridge": true,
 		},
 	}
-	net1, err := createTestNetwork(bridgeNetType, "network1", netOption)
+	n, err := createTestNetwork(bridgeNetType, "testnetwork", netOption)
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer func() {
-		if err := net1.Delete(); err != nil {
+		if err := n.Delete(); err != nil {
 			t.Fatal(err)
 		}
 	}()
 
-	// Create network 2
-	netOption = options.Generic{
-		netlabel.GenericData: options.Generic{
-			"BridgeName":            "network2",
-			"AllowNonDefaultBridge": true,
-		},
-	}
-	net2, err := createTestNetwork(bridgeNetType, "network2", netOption)
+	ep, err := n.CreateEndpoint("ep1", libnetwork.CreateOptionExternalKey("ext-key-1"))
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer func() {
-		if err := net2.Delete(); err != nil {
+		if err := ep.Delete(); err != nil {
 			t.Fatal(err)
 		}
 	}()
 
-	_, err = controller.NetworkByName("")
-	if err == nil {
-		t.Fatalf("NetworkByName() succeeded with invalid target name")
-	}
-	if _, ok := err.(libnetwork.ErrInvalidName); !ok {
-		t.Fatalf("Expected NetworkByName() to fail with ErrInvalidName error. Got: %v", err)
+	ep2, err := n.CreateEndpoint("ep1", libnetwork.CreateOptionExternalKey("ext-key-1"))
+	if err != nil {
+		t.Fatalf("Expected creation with an existing external key to succeed. Instead failed with: %v", err)
 	}
 
-	_, err = controller.NetworkByID("")
-	if err == nil {
-		t.Fatalf("NetworkByID() succeeded with invalid target id")
-	}
-	if _, ok := err.(libnetwork.ErrInvalidID); !ok {
-		t.Fatalf("NetworkByID() failed with unexpected error: %v", err)
+	if ep2.ID() != ep.ID() {
+		t.Fatalf("Expected endpoint %s to be returned. Got %s", ep.ID(), ep2.ID())
 	}
 
-	g, err := controller.NetworkByID("network1")
-	if err == nil {
-		t.Fatalf("Unexpected success for NetworkByID(): %v", g)
-	}
-	if _, ok := err.(libnetwork.ErrNoSuchNetwork); !ok {
-		t.Fatalf("NetworkByID() failed with unexpected error: %v", err)
+	if len(n.Endpoints()) != 1 {
+		t.Fatalf("Expected 1 endpoint in the network. Got %d", len(n.Endpoints()))
 	}
+}
 
-	g, err = controller.NetworkByName("network1")
-	if err != nil {
-		t.Fatalf("Unexpected failure for NetworkByName(): %v", err)
-	}
-	if g == nil {
-		t.Fatalf("NetworkByName() did not find the network")
+func TestConcurrentEndpointExternalKey(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
 	}
 
-	if g != net1 {
-		t.Fatalf("NetworkByName() returned the wrong network")
+	netOption := options.Generic{
+		netlabel.GenericData: options.Generic{
+			"BridgeName":            "testnetwork",
+			"AllowNonDefaultBridge": true,
+		},
 	}
-
-	g, err = controller.NetworkByID(net1.ID())
+	n, err := createTestNetwork(bridgeNetType, "testnetwork", netOption)
 	if err != nil {
-		t.Fatalf("Unexpected failure for NetworkByID(): %v", err)
-	}
-	if net1 != g {
-		t.Fatalf("NetworkByID() returned unexpected element: %v", g)
+		t.Fatal(err)
 	}
+	defer func() {
+		if err := n.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
 
-	g, err = controller.NetworkByName("network2")
+	testNs, err := netns.Get()
 	if err != nil {
-		t.Fatalf("Unexpected failure for NetworkByName(): %v", err)
+		t.Fatal(err)
 	}
-	if g == nil {
-		t.Fatalf("NetworkByName() did not find the network")
+	defer testNs.Close()
+
+	const creators = 8
+	var wg sync.WaitGroup
+	ids := make([]string, creators)
+	for i := 0; i < creators; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// The endpoint interfaces are created in the namespace of the thread
+			runtime.LockOSThread()
+			defer runtime.UnlockOSThread()
+			origNs, err := netns.Get()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer origNs.Close()
+			if err := netns.Set(testNs); err != nil {
+				t.Error(err)
+				return
+			}
+			defer netns.Set(origNs)
+
+			ep, err := n.CreateEndpoint(fmt.Sprintf("ep%d", i), libnetwork.CreateOptionExternalKey("ext-key-1"))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			// The endpoint is only returned once fully created
+			if ifaces := ep.Info().InterfaceList(); len(ifaces) == 0 || ifaces[0].Address().IP == nil {
+				t.Errorf("Creation %d returned endpoint %s before its interface was set up", i, ep.ID())
+			}
+			ids[i] = ep.ID()
+		}(i)
 	}
+	wg.Wait()
 
-	if g != net2 {
-		t.Fatalf("NetworkByName() returned the wrong network")
+	for i := 1; i < creators; i++ {
+		if ids[i] != ids[0] {
+			t.Fatalf("Expected all the creations to return endpoint %s. Got %s", ids[0], ids[i])
+		}
 	}
 
-	g, err = controller.NetworkByID(net2.ID())
-	if err != nil {
-		t.Fatalf("Unexpected failure for NetworkByID(): %v", err)
+	eps := n.Endpoints()
+	if len(eps) != 1 {
+		t.Fatalf("Expected 1 endpoint in the network. Got %d", len(eps))
 	}
-	if net2 != g {
-		t.Fatalf("NetworkByID() returned unexpected element: %v", g)
+	if err := eps[0].Delete(); err != nil {
+		t.Fatal(err)
 	}
 }
 
-func TestNetworkQuery(t *testing.T) {
+func TestNetworkUpdate(t *testing.T) {
 	if !netutils.IsRunningInContainer() {
 		defer netutils.SetupTestNetNS(t)()
 	}
 
-	// Create network 1 and add 2 endpoint: ep11, ep12
 	netOption := options.Generic{
 		netlabel.GenericData: options.Generic{
-			"BridgeName":            "network1",
+			"BridgeName":            "testnetwork",
 			"AllowNonDefaultBridge": true,
 		},
 	}
-	net1, err := createTestNetwork(bridgeNetType, "network1", netOption)
+	n, err := createTestNetwork(bridgeNetType, "testnetwork", netOption)
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer func() {
-		if err := net1.Delete(); err != nil {
+		if err := n.Delete(); err != nil {
 			t.Fatal(err)
 		}
 	}()
 
-	ep11, err := net1.CreateEndpoint("ep11")
-	if err != nil {
+	if err := n.Update(options.Generic{
+		netlabel.GenericData: options.Generic{
+			"EnableIPTablesLogging": true,
+			"IPTablesLogPrefix":     "testnetwork: ",
+		},
+	}); err != nil {
 		t.Fatal(err)
 	}
-	defer func() {
-		if err := ep11.Delete(); err != nil {
-			t.Fatal(err)
-		}
-	}()
 
-	ep12, err := net1.CreateEndpoint("ep12")
+	err = n.Update(options.Generic{
+		netlabel.GenericData: options.Generic{
+			"BridgeName": "renamed",
+		},
+	})
+	if _, ok := err.(types.BadRequestError); !ok {
+		t.Fatalf("Expected a bad request error updating the bridge name, got %v", err)
+	}
+}
+
+func TestNetworkMaxEndpoints(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	netOption := options.Generic{
+		netlabel.GenericData: options.Generic{
+			"BridgeName":            "testnetwork",
+			"AllowNonDefaultBridge": true,
+		},
+	}
+	n, err := controller.NewNetwork(bridgeNetType, "testnetwork",
+		libnetwork.NetworkOptionGeneric(netOption),
+		libnetwork.NetworkOptionMaxEndpoints(2))
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer func() {
-		if err := ep12.Delete(); err != nil {
+		if err := n.Delete(); err != nil {
 			t.Fatal(err)
 		}
 	}()
 
-	e, err := net1.EndpointByName("ep11")
-	if err != nil {
-		t.Fatal(err)
-	}
-	if ep11 != e {
-		t.Fatalf("EndpointByName() returned %v instead of %v", e, ep11)
+	for _, name := range []string{"ep1", "ep2"} {
+		ep, err := n.CreateEndpoint(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			if err := ep.Delete(); err != nil {
+				t.Fatal(err)
+			}
+		}()
 	}
 
-	e, err = net1.EndpointByName("")
+	_, err = n.CreateEndpoint("ep3")
 	if err == nil {
-		t.Fatalf("EndpointByName() succeeded with invalid target name")
+		t.Fatal("Expected failure creating an endpoint beyond the network maximum")
 	}
-	if _, ok := err.(libnetwork.ErrInvalidName); !ok {
-		t.Fatalf("Expected EndpointByName() to fail with ErrInvalidName error. Got: %v", err)
+	if _, ok := err.(*libnetwork.ErrNetworkFull); !ok {
+		t.Fatalf("Unexpected error type: %v", err)
 	}
 
-	e, err = net1.EndpointByName("IamNotAnEndpoint")
-	if err == nil {
-		t.Fatalf("EndpointByName() succeeded with unknown target name")
-	}
-	if _, ok := err.(libnetwork.ErrNoSuchEndpoint); !ok {
-		t.Fatal(err)
+	if cnt := n.Info().EndpointCount(); cnt != 2 {
+		t.Fatalf("Expected 2 endpoints in the network. Got %d", cnt)
 	}
-	if e != nil {
-		t.Fatalf("EndpointByName(): expected nil, got %v", e)
+	if max := n.Info().MaxEndpoints(); max != 2 {
+		t.Fatalf("Expected a maximum of 2 endpoints. Got %d", max)
 	}
+}
 
-	e, err = net1.EndpointByID(ep12.ID())
-	if err != nil {
-		t.Fatal(err)
-	}
-	if ep12 != e {
-		t.Fatalf("EndpointByID() returned %v instead of %v", e, ep12)
+func TestControllerDiagnostics(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
 	}
 
-	e, err = net1.EndpointByID("")
-	if err == nil {
-		t.Fatalf("EndpointByID() succeeded with invalid target id")
+	netOption := options.Generic{
+		netlabel.GenericData: options.Generic{
+			"BridgeName":            "testnetwork",
+			"AllowNonDefaultBridge": true,
+		},
 	}
-	if _, ok := err.(libnetwork.ErrInvalidID); !ok {
-		t.Fatalf("EndpointByID() failed with unexpected error: %v", err)
+	n, err := createTestNetwork(bridgeNetType, "testnetwork", netOption)
+	if err != nil {
+		t.Fatal(err)
 	}
-}
-
-const containerID = "valid_c"
+	defer func() {
+		if err := n.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
 
-func checkSandbox(t *testing.T, info libnetwork.EndpointInfo) {
-	origns, err := netns.Get()
+	ep, err := n.CreateEndpoint("ep1")
 	if err != nil {
-		t.Fatalf("Could not get the current netns: %v", err)
+		t.Fatal(err)
 	}
-	defer origns.Close()
+	defer func() {
+		if err := ep.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
 
-	key := info.Sandbox().Key()
-	f, err := os.OpenFile(key, os.O_RDONLY, 0)
+	r, err := controller.Diagnostics()
 	if err != nil {
-		t.Fatalf("Failed to open network namespace path %q: %v", key, err)
+		t.Fatal(err)
 	}
-	defer f.Close()
 
-	runtime.LockOSThread()
-	defer runtime.UnlockOSThread()
+	if _, err := json.Marshal(r); err != nil {
+		t.Fatalf("Failed to serialize the diagnostics report: %v", err)
+	}
 
-	nsFD := f.Fd()
-	if err = netns.Set(netns.NsHandle(nsFD)); err != nil {
-		t.Fatalf("Setting to the namespace pointed to by the sandbox %s failed: %v", key, err)
+	var nr *libnetwork.NetworkReport
+	for i := range r.Networks {
+		if r.Networks[i].ID == n.ID() {
+			nr = &r.Networks[i]
+		}
+	}
+	if nr == nil {
+		t.Fatalf("Network %s not found in the diagnostics report", n.Name())
+	}
+	if nr.Name != "testnetwork" || nr.Type != bridgeNetType || nr.EndpointCount != 1 {
+		t.Fatalf("Unexpected network report: %+v", nr)
 	}
-	defer netns.Set(origns)
 
-	_, err = netlink.LinkByName("eth0")
-	if err != nil {
-		t.Fatalf("Could not find the interface eth0 inside the sandbox: %v", err)
+	if len(nr.Endpoints) != 1 {
+		t.Fatalf("Expected 1 endpoint in the network report. Got %d", len(nr.Endpoints))
+	}
+	er := nr.Endpoints[0]
+	if er.ID != ep.ID() || er.Name != "ep1" {
+		t.Fatalf("Unexpected endpoint report: %+v", er)
+	}
+	if len(er.Interfaces) != 1 || er.Interfaces[0].Address == "" {
+		t.Fatalf("Expected the endpoint interface address in the report. Got %+v", er.Interfaces)
+	}
+	if er.DriverInfo == nil || er.DriverInfoError != "" {
+		t.Fatalf("Expected the endpoint driver info in the report. Got %+v", er)
 	}
 
-	_, err = netlink.LinkByName("eth1")
-	if err != nil {
-		t.Fatalf("Could not find the interface eth1 inside the sandbox: %v", err)
+	var found bool
+	for _, d := range r.Drivers {
+		if d.Type == bridgeNetType {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("Driver %s not found in the diagnostics report", bridgeNetType)
 	}
 }
 
-func TestEndpointJoin(t *testing.T) {
+func TestNetworkWatch(t *testing.T) {
 	if !netutils.IsRunningInContainer() {
 		defer netutils.SetupTestNetNS(t)()
 	}
 
-	// Create network 1 and add 2 endpoint: ep11, ep12
-	n1, err := createTestNetwork(bridgeNetType, "testnetwork1", options.Generic{
+	netOption := options.Generic{
 		netlabel.GenericData: options.Generic{
-			"BridgeName":            "testnetwork1",
+			"BridgeName":            "testnetwork",
 			"AllowNonDefaultBridge": true,
 		},
-	})
+	}
+	n, err := createTestNetwork(bridgeNetType, "testnetwork", netOption)
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer func() {
-		if err := n1.Delete(); err != nil {
+		if err := n.Delete(); err != nil {
 			t.Fatal(err)
 		}
 	}()
 
-	ep1, err := n1.CreateEndpoint("ep1")
+	events, cancel := n.Watch()
+
+	ep, err := n.CreateEndpoint("ep1")
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer func() {
-		if err := ep1.Delete(); err != nil {
-			t.Fatal(err)
-		}
-	}()
 
-	// Validate if ep.Info() only gives me IP address info and not names and gateway during CreateEndpoint()
-	info := ep1.Info()
-	for _, iface := range info.InterfaceList() {
-		if iface.Address().IP.To4() == nil {
+	sb, err := controller.NewSandbox(containerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ep.Join(sb); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ep.Leave(sb); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sb.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ep.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+
+	expected := []libnetwork.MembershipEventType{libnetwork.EndpointAdded, libnetwork.EndpointJoined, libnetwork.EndpointLeft, libnetwork.EndpointRemoved}
+	var got []libnetwork.MembershipEvent
+	for ev := range events {
+		got = append(got, ev)
+	}
+
+	if len(got) != len(expected) {
+		t.Fatalf("Expected %d membership events. Got %v", len(expected), got)
+	}
+	for i, ev := range got {
+		if ev.Type != expected[i] || ev.EndpointID != ep.ID() || ev.EndpointName != "ep1" {
+			t.Fatalf("Unexpected membership event %d: %+v", i, ev)
+		}
+	}
+	if got[1].SandboxID != sb.ID() || got[2].SandboxID != sb.ID() {
+		t.Fatalf("Expected sandbox %s in the join and leave events. Got %+v", sb.ID(), got)
+	}
+
+	// Unsubscribing twice must be safe
+	cancel()
+}
+
+func TestControllerQuery(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	// Create network 1
+	netOption := options.Generic{
+		netlabel.GenericData: options.Generic{
+			"BridgeName":            "network1",
+			"AllowNonDefaultBridge": true,
+		},
+	}
+	net1, err := createTestNetwork(bridgeNetType, "network1", netOption)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := net1.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// Create network 2
+	netOption = options.Generic{
+		netlabel.GenericData: options.Generic{
+			"BridgeName":            "network2",
+			"AllowNonDefaultBridge": true,
+		},
+	}
+	net2, err := createTestNetwork(bridgeNetType, "network2", netOption)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := net2.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	_, err = controller.NetworkByName("")
+	if err == nil {
+		t.Fatalf("NetworkByName() succeeded with invalid target name")
+	}
+	if _, ok := err.(libnetwork.ErrInvalidName); !ok {
+		t.Fatalf("Expected NetworkByName() to fail with ErrInvalidName error. Got: %v", err)
+	}
+
+	_, err = controller.NetworkByID("")
+	if err == nil {
+		t.Fatalf("NetworkByID() succeeded with invalid target id")
+	}
+	if _, ok := err.(libnetwork.ErrInvalidID); !ok {
+		t.Fatalf("NetworkByID() failed with unexpected error: %v", err)
+	}
+
+	g, err := controller.NetworkByID("network1")
+	if err == nil {
+		t.Fatalf("Unexpected success for NetworkByID(): %v", g)
+	}
+	if _, ok := err.(libnetwork.ErrNoSuchNetwork); !ok {
+		t.Fatalf("NetworkByID() failed with unexpected error: %v", err)
+	}
+
+	g, err = controller.NetworkByName("network1")
+	if err != nil {
+		t.Fatalf("Unexpected failure for NetworkByName(): %v", err)
+	}
+	if g == nil {
+		t.Fatalf("NetworkByName() did not find the network")
+	}
+
+	if g != net1 {
+		t.Fatalf("NetworkByName() returned the wrong network")
+	}
+
+	g, err = controller.NetworkByID(net1.ID())
+	if err != nil {
+		t.Fatalf("Unexpected failure for NetworkByID(): %v", err)
+	}
+	if net1 != g {
+		t.Fatalf("NetworkByID() returned unexpected element: %v", g)
+	}
+
+	g, err = controller.NetworkByName("network2")
+	if err != nil {
+		t.Fatalf("Unexpected failure for NetworkByName(): %v", err)
+	}
+	if g == nil {
+		t.Fatalf("NetworkByName() did not find the network")
+	}
+
+	if g != net2 {
+		t.Fatalf("NetworkByName() returned the wrong network")
+	}
+
+	g, err = controller.NetworkByID(net2.ID())
+	if err != nil {
+		t.Fatalf("Unexpected failure for NetworkByID(): %v", err)
+	}
+	if net2 != g {
+		t.Fatalf("NetworkByID() returned unexpected element: %v", g)
+	}
+}
+
+func TestNetworkQuery(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	// Create network 1 and add 2 endpoint: ep11, ep12
+	netOption := options.Generic{
+		netlabel.GenericData: options.Generic{
+			"BridgeName":            "network1",
+			"AllowNonDefaultBridge": true,
+		},
+	}
+	net1, err := createTestNetwork(bridgeNetType, "network1", netOption)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := net1.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	ep11, err := net1.CreateEndpoint("ep11")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := ep11.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	ep12, err := net1.CreateEndpoint("ep12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := ep12.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	e, err := net1.EndpointByName("ep11")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ep11 != e {
+		t.Fatalf("EndpointByName() returned %v instead of %v", e, ep11)
+	}
+
+	e, err = net1.EndpointByName("")
+	if err == nil {
+		t.Fatalf("EndpointByName() succeeded with invalid target name")
+	}
+	if _, ok := err.(libnetwork.ErrInvalidName); !ok {
+		t.Fatalf("Expected EndpointByName() to fail with ErrInvalidName error. Got: %v", err)
+	}
+
+	e, err = net1.EndpointByName("IamNotAnEndpoint")
+	if err == nil {
+		t.Fatalf("EndpointByName() succeeded with unknown target name")
+	}
+	if _, ok := err.(libnetwork.ErrNoSuchEndpoint); !ok {
+		t.Fatal(err)
+	}
+	if e != nil {
+		t.Fatalf("EndpointByName(): expected nil, got %v", e)
+	}
+
+	e, err = net1.EndpointByID(ep12.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ep12 != e {
+		t.Fatalf("EndpointByID() returned %v instead of %v", e, ep12)
+	}
+
+	e, err = net1.EndpointByID("")
+	if err == nil {
+		t.Fatalf("EndpointByID() succeeded with invalid target id")
+	}
+	if _, ok := err.(libnetwork.ErrInvalidID); !ok {
+		t.Fatalf("EndpointByID() failed with unexpected error: %v", err)
+	}
+}
+
+const containerID = "valid_c"
+
+func checkSandbox(t *testing.T, info libnetwork.EndpointInfo) {
+	origns, err := netns.Get()
+	if err != nil {
+		t.Fatalf("Could not get the current netns: %v", err)
+	}
+	defer origns.Close()
+
+	key := info.Sandbox().Key()
+	f, err := os.OpenFile(key, os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatalf("Failed to open network namespace path %q: %v", key, err)
+	}
+	defer f.Close()
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	nsFD := f.Fd()
+	if err = netns.Set(netns.NsHandle(nsFD)); err != nil {
+		t.Fatalf("Setting to the namespace pointed to by the sandbox %s failed: %v", key, err)
+	}
+	defer netns.Set(origns)
+
+	_, err = netlink.LinkByName("eth0")
+	if err != nil {
+		t.Fatalf("Could not find the interface eth0 inside the sandbox: %v", err)
+	}
+
+	_, err = netlink.LinkByName("eth1")
+	if err != nil {
+		t.Fatalf("Could not find the interface eth1 inside the sandbox: %v", err)
+	}
+}
+
+func TestEndpointAddressNet(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	_, cidr, err := net.ParseCIDR("172.29.7.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	subnet := types.GetIPNetCopy(cidr)
+	subnet.IP = net.ParseIP("172.29.7.1")
+
+	n, err := createTestNetwork(bridgeNetType, "testnetwork", options.Generic{
+		netlabel.GenericData: options.Generic{
+			"BridgeName":            "testnetwork",
+			"AddressIPv4":           subnet,
+			"FixedCIDR":             cidr,
+			"AllowNonDefaultBridge": true,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := n.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	ep, err := n.CreateEndpoint("ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := ep.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	ifaces := ep.Info().InterfaceList()
+	if len(ifaces) != 1 {
+		t.Fatalf("Expected one interface, got %d", len(ifaces))
+	}
+
+	addr := ifaces[0].Address()
+	if !cidr.Contains(addr.IP) {
+		t.Fatalf("Allocated address %v is outside of %v", addr.IP, cidr)
+	}
+	cones, _ := cidr.Mask.Size()
+	if ones, _ := addr.Mask.Size(); ones != cones {
+		t.Fatalf("Expected prefix length %d on the address, got %d", cones, ones)
+	}
+
+	if nw := ifaces[0].AddressNet(); !types.CompareIPNet(nw, cidr) {
+		t.Fatalf("Expected the address subnet to be %v, got %v", cidr, nw)
+	}
+}
+
+func TestNewSandboxIdempotent(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	sb1, err := controller.NewSandbox(containerID, libnetwork.OptionHostname("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := sb1.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	sb2, err := controller.NewSandbox(containerID, libnetwork.OptionHostname("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sb2.ID() != sb1.ID() {
+		t.Fatalf("Expected the existing sandbox %s to be returned, got %s", sb1.ID(), sb2.ID())
+	}
+
+	cnt := 0
+	controller.WalkSandboxes(func(sb libnetwork.Sandbox) bool {
+		if sb.ContainerID() == containerID {
+			cnt++
+		}
+		return false
+	})
+	if cnt != 1 {
+		t.Fatalf("Expected one sandbox for the container, found %d", cnt)
+	}
+
+	_, err = controller.NewSandbox(containerID, libnetwork.OptionHostname("other"))
+	if err == nil {
+		t.Fatal("Expected a sandbox request with a different hostname to fail")
+	}
+	if _, ok := err.(types.ForbiddenError); !ok {
+		t.Fatalf("Did not fail with expected error. Actual error: %v", err)
+	}
+}
+
+func TestNetworkRandomAllocation(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	netOption := options.Generic{
+		netlabel.GenericData: options.Generic{
+			"BridgeName":            "testnetwork",
+			"AllowNonDefaultBridge": true,
+		},
+	}
+	if _, err := controller.NewNetwork(bridgeNetType, "testnetwork",
+		libnetwork.NetworkOptionGeneric(netOption),
+		libnetwork.NetworkOptionAllocationStrategy("shuffled")); err == nil {
+		t.Fatal("Expected an invalid allocation strategy to be rejected")
+	} else if _, ok := err.(types.BadRequestError); !ok {
+		t.Fatalf("Did not fail with expected error. Actual error: %v", err)
+	}
+
+	n, err := controller.NewNetwork(bridgeNetType, "testnetwork",
+		libnetwork.NetworkOptionGeneric(netOption),
+		libnetwork.NetworkOptionAllocationStrategy(netlabel.AllocationRandom))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := n.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	var (
+		eps        []libnetwork.Endpoint
+		addrs      []net.IP
+		seen       = map[string]bool{}
+		sequential = true
+	)
+	defer func() {
+		for _, ep := range eps {
+			if err := ep.Delete(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		ep, err := n.CreateEndpoint(fmt.Sprintf("ep%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		eps = append(eps, ep)
+
+		ip := ep.Info().InterfaceList()[0].Address().IP.To4()
+		if seen[ip.String()] {
+			t.Fatalf("Address %s allocated twice", ip)
+		}
+		seen[ip.String()] = true
+		if len(addrs) > 0 && ip[3] != addrs[len(addrs)-1][3]+1 {
+			sequential = false
+		}
+		addrs = append(addrs, ip)
+	}
+	if sequential {
+		t.Fatalf("Expected the addresses not to be handed out in order: %v", addrs)
+	}
+
+	// A released address can be handed out again
+	if err := eps[0].Delete(); err != nil {
+		t.Fatal(err)
+	}
+	eps = eps[1:]
+	ep, err := n.CreateEndpoint("ep-reuse", libnetwork.CreateOptionIPAddress(addrs[0]))
+	if err != nil {
+		t.Fatalf("Failed to reallocate the released address %s: %v", addrs[0], err)
+	}
+	eps = append(eps, ep)
+}
+
+func TestNetworkGatewayLastAddress(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	ip, subnet, err := net.ParseCIDR("192.168.57.1/28")
+	if err != nil {
+		t.Fatal(err)
+	}
+	subnet.IP = ip
+	netOption := options.Generic{
+		netlabel.GenericData: options.Generic{
+			"BridgeName":            "testnetwork",
+			"AddressIPv4":           subnet,
+			"AllowNonDefaultBridge": true,
+		},
+	}
+
+	if _, err := controller.NewNetwork(bridgeNetType, "testnetwork",
+		libnetwork.NetworkOptionGeneric(netOption),
+		libnetwork.NetworkOptionGateway(net.ParseIP("192.168.57.15"))); err == nil {
+		t.Fatal("Expected the broadcast address to be rejected as gateway")
+	}
+
+	gw := net.ParseIP("192.168.57.14")
+	n, err := controller.NewNetwork(bridgeNetType, "testnetwork",
+		libnetwork.NetworkOptionGeneric(netOption),
+		libnetwork.NetworkOptionGateway(gw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := n.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// Exhaust the subnet: all the host addresses but the bridge and the gateway ones
+	var eps []libnetwork.Endpoint
+	defer func() {
+		for _, ep := range eps {
+			if err := ep.Delete(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}()
+	for {
+		ep, err := n.CreateEndpoint(fmt.Sprintf("ep%d", len(eps)))
+		if err != nil {
+			break
+		}
+		eps = append(eps, ep)
+		if addr := ep.Info().InterfaceList()[0].Address(); addr.IP.Equal(gw) {
+			t.Fatalf("Endpoint %s got the gateway address %s", ep.Name(), addr.IP)
+		}
+	}
+	if len(eps) != 12 {
+		t.Fatalf("Expected 12 endpoints to get an address, got %d", len(eps))
+	}
+
+	sb, err := controller.NewSandbox(containerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := sb.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	err = eps[0].Join(sb)
+	runtime.LockOSThread()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err := eps[0].Leave(sb)
+		runtime.LockOSThread()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	if !eps[0].Info().Gateway().Equal(gw) {
+		t.Fatalf("Expected gateway %s, got %s", gw, eps[0].Info().Gateway())
+	}
+}
+
+func TestEndpointJoin(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	// Create network 1 and add 2 endpoint: ep11, ep12
+	n1, err := createTestNetwork(bridgeNetType, "testnetwork1", options.Generic{
+		netlabel.GenericData: options.Generic{
+			"BridgeName":            "testnetwork1",
+			"AllowNonDefaultBridge": true,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := n1.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	ep1, err := n1.CreateEndpoint("ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := ep1.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// Validate if ep.Info() only gives me IP address info and not names and gateway during CreateEndpoint()
+	info := ep1.Info()
+	for _, iface := range info.InterfaceList() {
+		if iface.Address().IP.To4() == nil {
 			t.Fatalf("Invalid IP address returned: %v", iface.Address())
 		}
 	}
 
-	if info.Gateway().To4() != nil {
-		t.Fatalf("Expected empty gateway for an empty endpoint. Instead found a gateway: %v", info.Gateway())
+	if info.Gateway().To4() != nil {
+		t.Fatalf("Expected empty gateway for an empty endpoint. Instead found a gateway: %v", info.Gateway())
+	}
+
+	if info.Sandbox() != nil {
+		t.Fatalf("Expected an empty sandbox key for an empty endpoint. Instead found a non-empty sandbox key: %s", info.Sandbox().Key())
+	}
+
+	// test invalid joins
+	err = ep1.Join(nil)
+	if err == nil {
+		t.Fatalf("Expected to fail join with nil Sandbox")
+	}
+	if _, ok := err.(types.BadRequestError); !ok {
+		t.Fatalf("Unexpected error type returned: %T", err)
+	}
+
+	fsbx := &fakeSandbox{}
+	if err = ep1.Join(fsbx); err == nil {
+		t.Fatalf("Expected to fail join with invalid Sandbox")
+	}
+	if _, ok := err.(types.BadRequestError); !ok {
+		t.Fatalf("Unexpected error type returned: %T", err)
+	}
+
+	sb, err := controller.NewSandbox(containerID,
+		libnetwork.OptionHostname("test"),
+		libnetwork.OptionDomainname("docker.io"),
+		libnetwork.OptionExtraHost("web", "192.168.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		if err := sb.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	err = ep1.Join(sb)
+	runtime.LockOSThread()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = ep1.Leave(sb)
+		runtime.LockOSThread()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// Validate if ep.Info() only gives valid gateway and sandbox key after has container has joined.
+	info = ep1.Info()
+	if info.Gateway().To4() == nil {
+		t.Fatalf("Expected a valid gateway for a joined endpoint. Instead found an invalid gateway: %v", info.Gateway())
+	}
+
+	if info.Sandbox() == nil {
+		t.Fatalf("Expected an non-empty sandbox key for a joined endpoint. Instead found a empty sandbox key")
+	}
+
+	// Check endpoint provided container information
+	if ep1.Info().Sandbox().Key() != sb.Key() {
+		t.Fatalf("Endpoint Info returned unexpected sandbox key: %s", sb.Key())
+	}
+
+	// Attempt retrieval of endpoint interfaces statistics
+	stats, err := sb.Statistics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := stats["eth0"]; !ok {
+		t.Fatalf("Did not find eth0 statistics")
+	}
+
+	// Now test the container joining another network
+	n2, err := createTestNetwork(bridgeNetType, "testnetwork2",
+		options.Generic{
+			netlabel.GenericData: options.Generic{
+				"BridgeName":            "testnetwork2",
+				"AllowNonDefaultBridge": true,
+			},
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := n2.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	ep2, err := n2.CreateEndpoint("ep2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := ep2.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	err = ep2.Join(sb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runtime.LockOSThread()
+	defer func() {
+		err = ep2.Leave(sb)
+		runtime.LockOSThread()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	if ep1.Info().Sandbox().Key() != ep2.Info().Sandbox().Key() {
+		t.Fatalf("ep1 and ep2 returned different container sandbox key")
+	}
+
+	checkSandbox(t, info)
+}
+
+func TestNetworkVerifyBridgeDrift(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	n, err := createTestNetwork(bridgeNetType, "testnetwork", options.Generic{
+		netlabel.GenericData: options.Generic{
+			"BridgeName":            "testnetwork",
+			"AllowNonDefaultBridge": true,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := n.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	ep1, err := n.CreateEndpoint("ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := ep1.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	if dl, err := n.Verify(); err != nil || len(dl) != 0 {
+		t.Fatalf("Expected no discrepancy for the network as created. Got %v, %v", dl, err)
+	}
+
+	// Delete the bridge behind libnetwork's back
+	br, err := netlink.LinkByName("testnetwork")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := netlink.LinkDel(br); err != nil {
+		t.Fatal(err)
+	}
+
+	dl, err := n.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dl) != 1 || dl[0].Resource != "bridge" || dl[0].Actual != "none" {
+		t.Fatalf("Expected the missing bridge to be reported. Got %v", dl)
+	}
+
+	// Joining the endpoint recreates the bridge and reattaches its port
+	sb, err := controller.NewSandbox(containerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := sb.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	err = ep1.Join(sb)
+	runtime.LockOSThread()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = ep1.Leave(sb)
+		runtime.LockOSThread()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	if dl, err := n.Verify(); err != nil || len(dl) != 0 {
+		t.Fatalf("Expected no discrepancy once the bridge is recreated. Got %v, %v", dl, err)
+	}
+
+	// Likewise for the endpoint creation
+	br, err = netlink.LinkByName("testnetwork")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := netlink.LinkDel(br); err != nil {
+		t.Fatal(err)
+	}
+
+	ep2, err := n.CreateEndpoint("ep2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := ep2.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	if dl, err := n.Verify(); err != nil || len(dl) != 0 {
+		t.Fatalf("Expected no discrepancy once the bridge is recreated. Got %v, %v", dl, err)
+	}
+}
+
+func TestEndpointJoinWithResult(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	n, err := createTestNetwork(bridgeNetType, "testnetwork", options.Generic{
+		netlabel.GenericData: options.Generic{
+			"BridgeName":            "testnetwork",
+			"AllowNonDefaultBridge": true,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := n.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	ep, err := n.CreateEndpoint("ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := ep.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	resolvConfPath := "/tmp/libnetwork_test/resolv.conf"
+	defer os.Remove(resolvConfPath)
+
+	sb, err := controller.NewSandbox(containerID,
+		libnetwork.OptionResolvConfPath(resolvConfPath),
+		libnetwork.OptionDNS("8.8.8.8"),
+		libnetwork.OptionDNSSearch("example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := sb.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	res, err := ep.JoinWithResult(sb)
+	runtime.LockOSThread()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = ep.Leave(sb)
+		runtime.LockOSThread()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// Compare against the configuration programmed in the sandbox
+	b, err := ep.Info().InterfacesJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ifaces []libnetwork.InterfaceJSON
+	if err := json.Unmarshal(b, &ifaces); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Interfaces) != len(ifaces) || len(ifaces) != 1 {
+		t.Fatalf("Expected one interface in the join result. Got %v", res.Interfaces)
+	}
+	ri := res.Interfaces[0]
+	if ri.Name == "" || ri.Name != ifaces[0].Name {
+		t.Fatalf("Expected interface name %q in the join result. Got %q", ifaces[0].Name, ri.Name)
+	}
+	if ri.Address == nil || ri.Address.String() != ifaces[0].IPv4 {
+		t.Fatalf("Expected interface address %s in the join result. Got %v", ifaces[0].IPv4, ri.Address)
+	}
+	if ri.MacAddress.String() != ifaces[0].MAC {
+		t.Fatalf("Expected interface mac %s in the join result. Got %v", ifaces[0].MAC, ri.MacAddress)
+	}
+
+	stats, err := sb.Statistics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := stats[ri.Name]; !ok {
+		t.Fatalf("Interface %s of the join result not found in the sandbox: %v", ri.Name, stats)
+	}
+
+	if !res.Gateway.Equal(ep.Info().Gateway()) || res.Gateway.To4() == nil {
+		t.Fatalf("Expected gateway %v in the join result. Got %v", ep.Info().Gateway(), res.Gateway)
+	}
+
+	content, err := ioutil.ReadFile(resolvConfPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(res.DNS, resolvconf.GetNameservers(content)) || !reflect.DeepEqual(res.DNS, []string{"8.8.8.8"}) {
+		t.Fatalf("Expected the nameservers of the sandbox resolv.conf in the join result. Got %v", res.DNS)
+	}
+	if !reflect.DeepEqual(res.DNSSearch, []string{"example.com"}) {
+		t.Fatalf("Expected the search domains of the sandbox resolv.conf in the join result. Got %v", res.DNSSearch)
+	}
+}
+
+func TestEndpointInterfacesJSON(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	n, err := createTestNetwork(bridgeNetType, "testnetwork", options.Generic{
+		netlabel.GenericData: options.Generic{
+			"BridgeName":            "testnetwork",
+			"AllowNonDefaultBridge": true,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := n.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	ep, err := n.CreateEndpoint("ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := ep.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	iface := ep.Info().InterfaceList()[0]
+	mac := iface.MacAddress().String()
+	addr := iface.Address()
+	expected := fmt.Sprintf(`[{"name":"","mac":"%s","ipv4":"%s","ipv6":"","mtu":0}]`, mac, addr.String())
+
+	b, err := ep.Info().InterfacesJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != expected {
+		t.Fatalf("Unexpected interfaces json before join.\nExpected: %s\nGot:      %s", expected, b)
+	}
+
+	sb, err := controller.NewSandbox("interfaces_json_c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := sb.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	err = ep.Join(sb)
+	runtime.LockOSThread()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = ep.Leave(sb)
+		runtime.LockOSThread()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	expected = fmt.Sprintf(`[{"name":"eth0","mac":"%s","ipv4":"%s","ipv6":"","mtu":1500}]`, mac, addr.String())
+
+	b, err = ep.Info().InterfacesJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != expected {
+		t.Fatalf("Unexpected interfaces json after join.\nExpected: %s\nGot:      %s", expected, b)
+	}
+}
+
+func TestMoveEndpoint(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	n1, err := createTestNetwork(bridgeNetType, "testnetwork1", options.Generic{
+		netlabel.GenericData: options.Generic{
+			"BridgeName":            "testnetwork1",
+			"AllowNonDefaultBridge": true,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := n1.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	n2, err := createTestNetwork(bridgeNetType, "testnetwork2", options.Generic{
+		netlabel.GenericData: options.Generic{
+			"BridgeName":            "testnetwork2",
+			"AllowNonDefaultBridge": true,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := n2.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	ep1, err := n1.CreateEndpoint("ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sb, err := controller.NewSandbox("move_endpoint_c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := sb.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	err = ep1.Join(sb)
+	runtime.LockOSThread()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := controller.MoveEndpoint(ep1, n1); err == nil {
+		t.Fatal("Expected to fail moving an endpoint to its own network")
+	} else if _, ok := err.(types.ForbiddenError); !ok {
+		t.Fatalf("Unexpected error type returned: %T", err)
+	}
+
+	ep2, err := controller.MoveEndpoint(ep1, n2)
+	runtime.LockOSThread()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := ep2.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	defer func() {
+		err = ep2.Leave(sb)
+		runtime.LockOSThread()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	if ep2.Network() != n2.Name() || ep2.Name() != "ep1" {
+		t.Fatalf("Unexpected moved endpoint %s on network %s", ep2.Name(), ep2.Network())
+	}
+	if ep2.Info().Sandbox() == nil || ep2.Info().Sandbox().ID() != sb.ID() {
+		t.Fatal("Expected the moved endpoint to be joined to the sandbox")
+	}
+
+	if eps := n1.Endpoints(); len(eps) != 0 {
+		t.Fatalf("Expected no endpoint left on %s, found %d", n1.Name(), len(eps))
+	}
+	if _, err := n1.EndpointByID(ep1.ID()); err == nil {
+		t.Fatal("Expected the moved endpoint to be deleted from its original network")
+	}
+
+	stats, err := sb.Statistics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 1 {
+		t.Fatalf("Expected one interface in the sandbox, found %d", len(stats))
+	}
+
+	b, err := ep2.Info().InterfacesJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ifaces []libnetwork.InterfaceJSON
+	if err := json.Unmarshal(b, &ifaces); err != nil {
+		t.Fatal(err)
+	}
+	if len(ifaces) != 1 || ifaces[0].Name == "" {
+		t.Fatalf("Expected the moved endpoint interface in the sandbox, found %s", b)
+	}
+	if _, ok := stats[ifaces[0].Name]; !ok {
+		t.Fatalf("Did not find %s in the sandbox", ifaces[0].Name)
+	}
+}
+
+func TestEndpointDNSNames(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	n, err := createTestNetwork(bridgeNetType, "testnetwork", options.Generic{
+		netlabel.GenericData: options.Generic{
+			"BridgeName":            "testnetwork",
+			"AllowNonDefaultBridge": true,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := n.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	ep1, err := n.CreateEndpoint("ep1", libnetwork.CreateOptionDNSNames([]string{"web", "www"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := ep1.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	if ep1.Name() != "ep1" {
+		t.Fatalf("Unexpected endpoint name: %s", ep1.Name())
+	}
+	if _, err := n.EndpointByName("ep1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := n.EndpointByName("web"); err == nil {
+		t.Fatal("Expected the dns name not to be usable as the endpoint name")
+	}
+
+	ep2, err := n.CreateEndpoint("ep2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := ep2.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	hostsPath := "/tmp/libnetwork_test/dns_names/hosts"
+	defer os.RemoveAll("/tmp/libnetwork_test/dns_names")
+
+	sb, err := controller.NewSandbox("dns_names_c", libnetwork.OptionHostsPath(hostsPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := sb.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	err = ep2.Join(sb)
+	runtime.LockOSThread()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = ep2.Leave(sb)
+		runtime.LockOSThread()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	content, err := ioutil.ReadFile(hostsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ip := ep1.Info().InterfaceList()[0].Address().IP
+	for _, name := range []string{"ep1", "web", "www", "web.testnetwork"} {
+		if !bytes.Contains(content, []byte(fmt.Sprintf("%s\t%s\n", ip, name))) {
+			t.Fatalf("Expected %s to resolve to %s in the peer hosts file:\n%s", name, ip, content)
+		}
+	}
+}
+
+func TestEndpointDisabled(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	n, err := createTestNetwork(bridgeNetType, "testnetwork", options.Generic{
+		netlabel.GenericData: options.Generic{
+			"BridgeName":            "testnetwork",
+			"AllowNonDefaultBridge": true,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := n.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	ep, err := n.CreateEndpoint("ep1", libnetwork.CreateOptionDisabled())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := ep.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// The address is reserved while the endpoint is disabled
+	if ip := ep.Info().InterfaceList()[0].Address().IP; ip == nil {
+		t.Fatal("Expected the disabled endpoint to hold an address")
+	}
+
+	sb, err := controller.NewSandbox("disabled_c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := sb.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	err = ep.Join(sb)
+	runtime.LockOSThread()
+	if err == nil {
+		t.Fatal("Expected the join on a disabled endpoint to fail")
+	}
+	if _, ok := err.(libnetwork.ErrEndpointDisabled); !ok {
+		t.Fatalf("Unexpected error type returned: %T (%v)", err, err)
+	}
+
+	if err := ep.Enable(); err != nil {
+		t.Fatal(err)
+	}
+
+	err = ep.Join(sb)
+	runtime.LockOSThread()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ep.Leave(sb)
+	runtime.LockOSThread()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFilterByLabels(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	var nets []libnetwork.Network
+	for _, tenant := range []string{"acme", "globex"} {
+		n, err := controller.NewNetwork(bridgeNetType, tenant+"_net",
+			libnetwork.NetworkOptionGeneric(options.Generic{
+				netlabel.GenericData: options.Generic{
+					"BridgeName":            tenant + "_net",
+					"AllowNonDefaultBridge": true,
+				},
+			}),
+			libnetwork.NetworkOptionLabels(map[string]string{"tenant": tenant}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		nets = append(nets, n)
+	}
+	defer func() {
+		for _, n := range nets {
+			if err := n.Delete(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}()
+
+	var eps []libnetwork.Endpoint
+	for i, tenant := range []string{"acme", "globex"} {
+		for _, tier := range []string{"web", "db"} {
+			ep, err := nets[i].CreateEndpoint(tier, libnetwork.CreateOptionLabels(map[string]string{"tenant": tenant, "tier": tier}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			eps = append(eps, ep)
+		}
+	}
+	defer func() {
+		for _, ep := range eps {
+			if err := ep.Delete(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}()
+
+	if l := controller.FilterNetworks(map[string]string{"tenant": "acme"}); len(l) != 1 || l[0].Name() != "acme_net" {
+		t.Fatalf("Unexpected networks for tenant acme: %v", l)
+	}
+	if l := controller.FilterNetworks(map[string]string{"tenant": "initech"}); len(l) != 0 {
+		t.Fatalf("Unexpected networks for tenant initech: %v", l)
+	}
+	if l := controller.FilterNetworks(nil); len(l) != len(controller.Networks()) {
+		t.Fatalf("Expected the empty selector to return all %d networks, got %d", len(controller.Networks()), len(l))
+	}
+
+	l := controller.FilterEndpoints(map[string]string{"tenant": "acme"})
+	if len(l) != 2 {
+		t.Fatalf("Expected 2 endpoints for tenant acme, got %d", len(l))
+	}
+	for _, ep := range l {
+		if ep.Network() != "acme_net" {
+			t.Fatalf("Unexpected endpoint %s of network %s for tenant acme", ep.Name(), ep.Network())
+		}
+	}
+
+	// AND semantics across keys
+	l = controller.FilterEndpoints(map[string]string{"tenant": "globex", "tier": "db"})
+	if len(l) != 1 || l[0].Name() != "db" || l[0].Network() != "globex_net" {
+		t.Fatalf("Unexpected endpoints for tenant globex and tier db: %v", l)
+	}
+	all := 0
+	for _, n := range controller.Networks() {
+		all += len(n.Endpoints())
+	}
+	if l = controller.FilterEndpoints(map[string]string{}); len(l) != all {
+		t.Fatalf("Expected the empty selector to return all %d endpoints, got %d", all, len(l))
+	}
+}
+
+func TestEndpointGatewayMAC(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	n, err := createTestNetwork(bridgeNetType, "testnetwork", options.Generic{
+		netlabel.GenericData: options.Generic{
+			"BridgeName":            "testnetwork",
+			"AllowNonDefaultBridge": true,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := n.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	ep, err := n.CreateEndpoint("ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := ep.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	if _, err := ep.Info().GatewayMAC(); err == nil {
+		t.Fatal("Expected failure reading the gateway mac of an endpoint not joined")
+	} else if _, ok := err.(libnetwork.ErrNoContainer); !ok {
+		t.Fatalf("Unexpected error type returned: %T (%v)", err, err)
+	}
+
+	sb, err := controller.NewSandbox("gateway_mac_c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := sb.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	err = ep.Join(sb)
+	runtime.LockOSThread()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = ep.Leave(sb)
+		runtime.LockOSThread()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	mac, err := ep.Info().GatewayMAC()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The gateway is the bridge itself
+	br, err := net.InterfaceByName("testnetwork")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mac.String() != br.HardwareAddr.String() {
+		t.Fatalf("Expected gateway mac %s, got %s", br.HardwareAddr, mac)
+	}
+}
+
+func TestNetworkAddressAllocations(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	n, err := createTestNetwork(bridgeNetType, "testnetwork", options.Generic{
+		netlabel.GenericData: options.Generic{
+			"BridgeName":            "testnetwork",
+			"AllowNonDefaultBridge": true,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := n.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	expected := map[string]string{}
+	for i := 0; i < 3; i++ {
+		ep, err := n.CreateEndpoint(fmt.Sprintf("ep%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			if err := ep.Delete(); err != nil {
+				t.Fatal(err)
+			}
+		}()
+		expected[ep.Info().InterfaceList()[0].Address().IP.String()] = ep.ID()
+	}
+
+	br, err := netlink.LinkByName("testnetwork")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrs, err := netlink.AddrList(br, netlink.FAMILY_V4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected[addrs[0].IP.String()] = "gateway"
+
+	allocs, err := n.AddressAllocations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(allocs, expected) {
+		t.Fatalf("Expected address allocations %v. Got %v", expected, allocs)
+	}
+}
+
+func TestPreviewNextAddress(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	n, err := createTestNetwork(bridgeNetType, "testnetwork", options.Generic{
+		netlabel.GenericData: options.Generic{
+			"BridgeName":            "testnetwork",
+			"AllowNonDefaultBridge": true,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := n.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	for i := 0; i < 3; i++ {
+		preview, err := n.PreviewNextAddress()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// A second preview must not have reserved the first one
+		again, err := n.PreviewNextAddress()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !again.Equal(preview) {
+			t.Fatalf("Expected the preview to be stable. Got %s then %s", preview, again)
+		}
+
+		ep, err := n.CreateEndpoint(fmt.Sprintf("ep%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			if err := ep.Delete(); err != nil {
+				t.Fatal(err)
+			}
+		}()
+
+		if addr := ep.Info().InterfaceList()[0].Address(); !addr.IP.Equal(preview) {
+			t.Fatalf("Expected the endpoint to get the previewed address %s. Got %s", preview, addr.IP)
+		}
+	}
+}
+
+func TestNetworkDriverOperInfo(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	n, err := createTestNetwork(bridgeNetType, "testnetwork", options.Generic{
+		netlabel.GenericData: options.Generic{
+			"BridgeName":            "testnetwork",
+			"AllowNonDefaultBridge": true,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := n.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	info, err := n.DriverOperInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	link, err := netlink.LinkByName("testnetwork")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info[netlabel.BridgeName] != "testnetwork" {
+		t.Fatalf("Unexpected bridge name reported: %v", info[netlabel.BridgeName])
 	}
+	if info[netlabel.BridgeIndex] != link.Attrs().Index {
+		t.Fatalf("Unexpected bridge index reported. Expected %d. Got %v", link.Attrs().Index, info[netlabel.BridgeIndex])
+	}
+}
 
-	if info.Sandbox() != nil {
-		t.Fatalf("Expected an empty sandbox key for an empty endpoint. Instead found a non-empty sandbox key: %s", info.Sandbox().Key())
+func TestJoinLink(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
 	}
 
-	// test invalid joins
-	err = ep1.Join(nil)
-	if err == nil {
-		t.Fatalf("Expected to fail join with nil Sandbox")
+	n, err := createTestNetwork(bridgeNetType, "testnetwork", options.Generic{
+		netlabel.GenericData: options.Generic{
+			"BridgeName":            "testnetwork",
+			"AllowNonDefaultBridge": true,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
 	}
-	if _, ok := err.(types.BadRequestError); !ok {
-		t.Fatalf("Unexpected error type returned: %T", err)
+	defer func() {
+		if err := n.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	ep1, err := n.CreateEndpoint("ep1")
+	if err != nil {
+		t.Fatal(err)
 	}
+	defer func() {
+		if err := ep1.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
 
-	fsbx := &fakeSandbox{}
-	if err = ep1.Join(fsbx); err == nil {
-		t.Fatalf("Expected to fail join with invalid Sandbox")
+	ep2, err := n.CreateEndpoint("ep2")
+	if err != nil {
+		t.Fatal(err)
 	}
-	if _, ok := err.(types.BadRequestError); !ok {
-		t.Fatalf("Unexpected error type returned: %T", err)
+	defer func() {
+		if err := ep2.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	hostsPath := "/tmp/libnetwork_test/link/hosts"
+	defer os.RemoveAll("/tmp/libnetwork_test/link")
+
+	sb, err := controller.NewSandbox("link_c", libnetwork.OptionHostsPath(hostsPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := sb.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	err = ep1.Join(sb, libnetwork.JoinOptionLink(ep2, "db"))
+	runtime.LockOSThread()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(hostsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := fmt.Sprintf("%s\tdb\n", ep2.Info().InterfaceList()[0].Address().IP)
+	if !bytes.Contains(content, []byte(entry)) {
+		t.Fatalf("Expected the link entry %q in the hosts file:\n%s", entry, content)
+	}
+
+	err = ep1.Leave(sb)
+	runtime.LockOSThread()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err = ioutil.ReadFile(hostsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(content, []byte("\tdb\n")) {
+		t.Fatalf("Expected the link entry to be removed on leave:\n%s", content)
+	}
+}
+
+func TestSandboxStatisticsChurn(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	n, err := createTestNetwork(bridgeNetType, "testnetwork", options.Generic{
+		netlabel.GenericData: options.Generic{
+			"BridgeName":            "testnetwork",
+			"AllowNonDefaultBridge": true,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := n.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	ep1, err := n.CreateEndpoint("ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := ep1.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	ep2, err := n.CreateEndpoint("ep2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := ep2.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	sb, err := controller.NewSandbox("stats_churn_c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := sb.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// ep1 stays joined, ep2 joins and leaves while the statistics are read
+	err = ep1.Join(sb)
+	runtime.LockOSThread()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		err = ep1.Leave(sb)
+		runtime.LockOSThread()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	stop := make(chan struct{})
+	errCh := make(chan error, 1)
+	go func() {
+		defer close(errCh)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			stats, err := sb.Statistics()
+			if err != nil {
+				errCh <- err
+				return
+			}
+			if _, ok := stats["eth0"]; !ok {
+				errCh <- fmt.Errorf("statistics of the joined endpoint missing: %v", stats)
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < 20; i++ {
+		err = ep2.Join(sb)
+		runtime.LockOSThread()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ep2.Leave(sb)
+		runtime.LockOSThread()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJoinLeaveCorrelationID(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	var buf bytes.Buffer
+	logger := log.New()
+	logger.Out = &buf
+	logger.Level = log.DebugLevel
+
+	c, err := libnetwork.New(config.OptionLogger(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	libnetwork.SetTestDataStore(c, datastore.NewCustomDataStore(datastore.NewMockStore()))
+
+	// Null networks cannot be deleted, the controller goes away with the test
+	n, err := c.NewNetwork("null", "testnull")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep, err := n.CreateEndpoint("ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := ep.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	sb, err := c.NewSandbox("correlation_c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := sb.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// Every log line of an operation carries its correlation ID
+	checkLines := func(op, id string) {
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		if len(lines) == 0 || lines[0] == "" {
+			t.Fatalf("Expected the %s to be logged", op)
+		}
+		for _, l := range lines {
+			if !strings.Contains(l, "correlation_id="+id) || !strings.Contains(l, "endpoint=ep1") {
+				t.Fatalf("Expected the %s log line to carry correlation ID %s: %s", op, id, l)
+			}
+		}
+		buf.Reset()
+	}
+
+	err = ep.Join(sb, libnetwork.JoinOptionCorrelationID("join-1234"))
+	runtime.LockOSThread()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkLines("join", "join-1234")
+
+	err = ep.Leave(sb, libnetwork.LeaveOptionCorrelationID("leave-5678"))
+	runtime.LockOSThread()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkLines("leave", "leave-5678")
+
+	// The ID does not outlive the operation it was passed to
+	err = ep.Join(sb)
+	runtime.LockOSThread()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "correlation_id") {
+		t.Fatalf("Unexpected correlation ID on a join without one: %s", buf.String())
+	}
+	err = ep.Leave(sb)
+	runtime.LockOSThread()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNetworkDomainSearchMerge(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
 	}
 
+	resolvConfPath := "/tmp/libnetwork_test/resolv.conf"
+	defer os.Remove(resolvConfPath)
+
 	sb, err := controller.NewSandbox(containerID,
-		libnetwork.OptionHostname("test"),
-		libnetwork.OptionDomainname("docker.io"),
-		libnetwork.OptionExtraHost("web", "192.168.0.1"))
+		libnetwork.OptionResolvConfPath(resolvConfPath),
+		libnetwork.OptionDNS("8.8.8.8"),
+		libnetwork.OptionDNSSearch("s1.example"),
+		libnetwork.OptionDNSSearch("s2.example"),
+		libnetwork.OptionDNSSearch("s3.example"),
+		libnetwork.OptionDNSSearch("s4.example"),
+		libnetwork.OptionDNSSearch("s5.example"))
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer func() {
+		if err := sb.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	eps := map[string]libnetwork.Endpoint{}
+	nws := []libnetwork.Network{}
+	for _, nc := range []struct {
+		name   string
+		domain string
+		prio   int
+	}{
+		{"low", "low.example", 1},
+		{"high", "high.example", 10},
+		// Already in the sandbox own search list
+		{"dup", "s3.example", 5},
+	} {
+		n, err := controller.NewNetwork(bridgeNetType, nc.name,
+			libnetwork.NetworkOptionGeneric(options.Generic{
+				netlabel.GenericData: options.Generic{
+					"BridgeName":            nc.name,
+					"AllowNonDefaultBridge": true,
+				},
+			}),
+			libnetwork.NetworkOptionDomain(nc.domain))
+		if err != nil {
+			t.Fatal(err)
+		}
+		nws = append(nws, n)
+		ep, err := n.CreateEndpoint("ep")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ep.Join(sb, libnetwork.JoinOptionPriority(ep, nc.prio))
+		runtime.LockOSThread()
+		if err != nil {
+			t.Fatal(err)
+		}
+		eps[nc.name] = ep
+	}
 
-	defer func() {
-		if err := sb.Delete(); err != nil {
+	checkSearch := func(expected []string) {
+		content, err := ioutil.ReadFile(resolvConfPath)
+		if err != nil {
 			t.Fatal(err)
 		}
-	}()
+		if search := resolvconf.GetSearchDomains(content); !reflect.DeepEqual(search, expected) {
+			t.Fatalf("Expected search domains %v. Got %v", expected, search)
+		}
+		if ns := resolvconf.GetNameservers(content); !reflect.DeepEqual(ns, []string{"8.8.8.8"}) {
+			t.Fatalf("Expected the nameservers to be kept. Got %v", ns)
+		}
+	}
 
-	err = ep1.Join(sb)
+	// The duplicate is merged and the lowest priority domain exceeds the limit
+	checkSearch([]string{"s1.example", "s2.example", "s3.example", "s4.example", "s5.example", "high.example"})
+
+	// Once the high priority network is left, the low priority one fits
+	err = eps["high"].Leave(sb)
 	runtime.LockOSThread()
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer func() {
-		err = ep1.Leave(sb)
-		runtime.LockOSThread()
-		if err != nil {
+	checkSearch([]string{"s1.example", "s2.example", "s3.example", "s4.example", "s5.example", "low.example"})
+
+	for _, ep := range eps {
+		if ep.Info().Sandbox() != nil {
+			err = ep.Leave(sb)
+			runtime.LockOSThread()
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := ep.Delete(); err != nil {
 			t.Fatal(err)
 		}
-	}()
-
-	// Validate if ep.Info() only gives valid gateway and sandbox key after has container has joined.
-	info = ep1.Info()
-	if info.Gateway().To4() == nil {
-		t.Fatalf("Expected a valid gateway for a joined endpoint. Instead found an invalid gateway: %v", info.Gateway())
 	}
-
-	if info.Sandbox() == nil {
-		t.Fatalf("Expected an non-empty sandbox key for a joined endpoint. Instead found a empty sandbox key")
+	for _, n := range nws {
+		if err := n.Delete(); err != nil {
+			t.Fatal(err)
+		}
 	}
+	checkSearch([]string{"s1.example", "s2.example", "s3.example", "s4.example", "s5.example"})
+}
 
-	// Check endpoint provided container information
-	if ep1.Info().Sandbox().Key() != sb.Key() {
-		t.Fatalf("Endpoint Info returned unexpected sandbox key: %s", sb.Key())
+func TestHostSandboxBackend(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
 	}
 
-	// Attempt retrieval of endpoint interfaces statistics
-	stats, err := sb.Statistics()
+	c, err := libnetwork.New(config.OptionSandboxBackend(osl.HostBackend))
 	if err != nil {
 		t.Fatal(err)
 	}
-	if _, ok := stats["eth0"]; !ok {
-		t.Fatalf("Did not find eth0 statistics")
+	libnetwork.SetTestDataStore(c, datastore.NewCustomDataStore(datastore.NewMockStore()))
+
+	if err := c.ConfigureNetworkDriver(bridgeNetType, getEmptyGenericOption()); err != nil {
+		t.Fatal(err)
 	}
 
-	// Now test the container joining another network
-	n2, err := createTestNetwork(bridgeNetType, "testnetwork2",
-		options.Generic{
-			netlabel.GenericData: options.Generic{
-				"BridgeName":            "testnetwork2",
-				"AllowNonDefaultBridge": true,
-			},
-		})
+	n, err := c.NewNetwork(bridgeNetType, "testnetwork", libnetwork.NetworkOptionGeneric(options.Generic{
+		netlabel.GenericData: options.Generic{
+			"BridgeName":            "testnetwork",
+			"AllowNonDefaultBridge": true,
+		},
+	}))
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer func() {
-		if err := n2.Delete(); err != nil {
+		if err := n.Delete(); err != nil {
 			t.Fatal(err)
 		}
 	}()
 
-	ep2, err := n2.CreateEndpoint("ep2")
+	ep, err := n.CreateEndpoint("ep1")
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer func() {
-		if err := ep2.Delete(); err != nil {
+		if err := ep.Delete(); err != nil {
 			t.Fatal(err)
 		}
 	}()
 
-	err = ep2.Join(sb)
+	sb, err := c.NewSandbox("host_backend_c")
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer func() {
+		if err := sb.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	err = ep.Join(sb)
 	runtime.LockOSThread()
+	if err != nil {
+		t.Fatal(err)
+	}
 	defer func() {
-		err = ep2.Leave(sb)
+		err = ep.Leave(sb)
 		runtime.LockOSThread()
 		if err != nil {
 			t.Fatal(err)
 		}
 	}()
 
-	if ep1.Info().Sandbox().Key() != ep2.Info().Sandbox().Key() {
-		t.Fatalf("ep1 and ep2 returned different container sandbox key")
+	if _, err := os.Stat(sb.Key()); !os.IsNotExist(err) {
+		t.Fatalf("Expected no namespace file at %s. Got: %v", sb.Key(), err)
 	}
 
-	checkSandbox(t, info)
+	// The endpoint interface is set up in the current namespace
+	addrs, err := netlink.AddrList(nil, netlink.FAMILY_V4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip := ep.Info().InterfaceList()[0].Address().IP
+	for _, a := range addrs {
+		if a.IP.Equal(ip) {
+			return
+		}
+	}
+	t.Fatalf("Endpoint address %s not found in the current namespace: %v", ip, addrs)
 }
 
 type fakeSandbox struct{}
@@ -1180,6 +3161,26 @@ func (f *fakeSandbox) Statistics() (map[string]*osl.InterfaceStatistics, error)
 	return nil, nil
 }
 
+func (f *fakeSandbox) NamespaceID() (uint64, error) {
+	return 0, nil
+}
+
+func (f *fakeSandbox) Owner() string {
+	return ""
+}
+
+func (f *fakeSandbox) Activate(ep libnetwork.Endpoint) error {
+	return nil
+}
+
+func (f *fakeSandbox) PendingEndpoints() []libnetwork.Endpoint {
+	return nil
+}
+
+func (f *fakeSandbox) PendingDone() <-chan struct{} {
+	return nil
+}
+
 func (f *fakeSandbox) Delete() error {
 	return nil
 }
@@ -1384,7 +3385,73 @@ func TestLeaveAll(t *testing.T) {
 	}
 }
 
-func TestontainerInvalidLeave(t *testing.T) {
+func TestLeavePriority(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	n, err := createTestNetwork(bridgeNetType, "testnetwork", options.Generic{
+		netlabel.GenericData: options.Generic{
+			"BridgeName":            "testnetwork",
+			"AllowNonDefaultBridge": true,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		// If this goes through, all the endpoints left the sandbox
+		if err := n.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	var order []string
+	hook := func(ep libnetwork.Endpoint, sb libnetwork.Sandbox) error {
+		order = append(order, ep.Name())
+		if ep.Name() == "ep3" {
+			return fmt.Errorf("cleanup of %s failed", ep.Name())
+		}
+		return nil
+	}
+
+	cnt, err := controller.NewSandbox("leavepriority")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		name string
+		prio int
+	}{{"ep1", 1}, {"ep2", 10}, {"ep3", 5}} {
+		ep, err := n.CreateEndpoint(c.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			if err := ep.Delete(); err != nil {
+				t.Fatal(err)
+			}
+		}()
+
+		err = ep.Join(cnt, libnetwork.JoinOptionLeavePriority(c.prio), libnetwork.JoinOptionPreLeaveHook(hook))
+		runtime.LockOSThread()
+		if err != nil {
+			t.Fatalf("Failed to join %s: %v", c.name, err)
+		}
+	}
+
+	if err := cnt.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"ep2", "ep3", "ep1"}
+	if !reflect.DeepEqual(order, expected) {
+		t.Fatalf("Unexpected leave order: %v. Expected: %v", order, expected)
+	}
+}
+
+func TestContainerInvalidLeave(t *testing.T) {
 	if !netutils.IsRunningInContainer() {
 		defer netutils.SetupTestNetNS(t)()
 	}
@@ -1435,7 +3502,7 @@ func TestontainerInvalidLeave(t *testing.T) {
 		t.Fatalf("Failed with unexpected error type: %T. Desc: %s", err, err.Error())
 	}
 
-	if err := ep.Leave(nil); err == nil {
+	if err = ep.Leave(nil); err == nil {
 		t.Fatalf("Expected to fail leave nil Sandbox")
 	}
 	if _, ok := err.(types.BadRequestError); !ok {
@@ -1798,70 +3865,245 @@ func TestResolvConf(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	if !bytes.Equal(content, expectedResolvConf1) {
-		fmt.Printf("\n%v\n%v\n", expectedResolvConf1, content)
-		t.Fatalf("Expected:\n%s\nGot:\n%s", string(expectedResolvConf1), string(content))
+
+	if !bytes.Equal(content, expectedResolvConf1) {
+		fmt.Printf("\n%v\n%v\n", expectedResolvConf1, content)
+		t.Fatalf("Expected:\n%s\nGot:\n%s", string(expectedResolvConf1), string(content))
+	}
+
+	err = ep.Leave(sb1)
+	runtime.LockOSThread()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile("/etc/resolv.conf", tmpResolvConf2, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sb2, err := controller.NewSandbox(containerID+"_2", libnetwork.OptionResolvConfPath(resolvConfPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := sb2.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	err = ep.Join(sb2)
+	runtime.LockOSThread()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err = ioutil.ReadFile(resolvConfPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(content, expectedResolvConf2) {
+		t.Fatalf("Expected:\n%s\nGot:\n%s", string(expectedResolvConf2), string(content))
+	}
+
+	if err := ioutil.WriteFile(resolvConfPath, tmpResolvConf3, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err = ep.Leave(sb2)
+	runtime.LockOSThread()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ep.Join(sb2)
+	runtime.LockOSThread()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err = ioutil.ReadFile(resolvConfPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(content, tmpResolvConf3) {
+		t.Fatalf("Expected:\n%s\nGot:\n%s", string(tmpResolvConf3), string(content))
+	}
+}
+
+func TestResolvConfGenerator(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	tmpResolvConf := []byte("search pommesfrites.fr\nnameserver 12.34.56.78\nnameserver 2001:4860:4860::8888\n")
+	customLine := "options rotate # generated"
+	// The IPv6 nameserver is filtered out on join, through the generator
+	expectedBase := []byte("search pommesfrites.fr\nnameserver 12.34.56.78\n")
+	expectedResolvConf := []byte(string(expectedBase) + customLine + "\n")
+
+	//take a copy of resolv.conf for restoring after test completes
+	resolvConfSystem, err := ioutil.ReadFile("/etc/resolv.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//cleanup
+	defer func() {
+		if err := ioutil.WriteFile("/etc/resolv.conf", resolvConfSystem, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	if err := ioutil.WriteFile("/etc/resolv.conf", tmpResolvConf, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	resolvConfPath := "/tmp/libnetwork_test/resolv.conf"
+	defer os.Remove(resolvConfPath)
+
+	var lastBase []byte
+	controller.SetResolvConfGenerator(func(sb libnetwork.Sandbox, base []byte) ([]byte, error) {
+		lastBase = base
+		if len(base) == 0 {
+			return nil, fmt.Errorf("empty base resolv.conf for sandbox %s", sb.ID())
+		}
+		if bytes.Contains(base, []byte(customLine)) {
+			return nil, fmt.Errorf("generator passed its own output for sandbox %s:\n%s", sb.ID(), base)
+		}
+		// The container must never see the file without the generated content
+		if content, err := ioutil.ReadFile(resolvConfPath); err == nil && !bytes.Contains(content, []byte(customLine)) {
+			return nil, fmt.Errorf("resolv.conf of sandbox %s written before the generator ran:\n%s", sb.ID(), content)
+		}
+		return append(append([]byte{}, base...), []byte(customLine+"\n")...), nil
+	})
+	defer controller.SetResolvConfGenerator(nil)
+
+	sb, err := controller.NewSandbox(containerID, libnetwork.OptionResolvConfPath(resolvConfPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := sb.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	content, err := ioutil.ReadFile(resolvConfPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), customLine) {
+		t.Fatalf("Expected the generated line %q in the sandbox resolv.conf. Got:\n%s", customLine, content)
 	}
 
-	err = ep.Leave(sb1)
-	runtime.LockOSThread()
-	if err != nil {
-		t.Fatal(err)
+	netOption := options.Generic{
+		netlabel.GenericData: options.Generic{
+			"BridgeName":            "testnetwork",
+			"AllowNonDefaultBridge": true,
+		},
 	}
-
-	if err := ioutil.WriteFile("/etc/resolv.conf", tmpResolvConf2, 0644); err != nil {
+	n, err := createTestNetwork("bridge", "testnetwork", netOption)
+	if err != nil {
 		t.Fatal(err)
 	}
+	defer func() {
+		if err := n.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
 
-	sb2, err := controller.NewSandbox(containerID+"_2", libnetwork.OptionResolvConfPath(resolvConfPath))
+	ep, err := n.CreateEndpoint("ep")
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer func() {
-		if err := sb2.Delete(); err != nil {
+		if err := ep.Delete(); err != nil {
 			t.Fatal(err)
 		}
 	}()
 
-	err = ep.Join(sb2)
+	err = ep.Join(sb)
 	runtime.LockOSThread()
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer func() {
+		err := ep.Leave(sb)
+		runtime.LockOSThread()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
 
 	content, err = ioutil.ReadFile(resolvConfPath)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if !bytes.Equal(lastBase, expectedBase) {
+		t.Fatalf("Expected the generator to be passed the updated resolv.conf on join:\n%s\nGot:\n%s", expectedBase, lastBase)
+	}
+	if !bytes.Equal(content, expectedResolvConf) {
+		t.Fatalf("Expected the joined sandbox resolv.conf to go through the generator once:\n%s\nGot:\n%s", expectedResolvConf, content)
+	}
+}
 
-	if !bytes.Equal(content, expectedResolvConf2) {
-		t.Fatalf("Expected:\n%s\nGot:\n%s", string(expectedResolvConf2), string(content))
+func TestResolvConfOptions(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
 	}
 
-	if err := ioutil.WriteFile(resolvConfPath, tmpResolvConf3, 0644); err != nil {
+	tmpResolvConf := []byte("search pommesfrites.fr\nnameserver 12.34.56.78\noptions timeout:3 rotate ndots:5\n")
+	expectedResolvConf := []byte("search pommesfrites.fr\nnameserver 12.34.56.78\noptions timeout:3 rotate ndots:2\n")
+
+	//take a copy of resolv.conf for restoring after test completes
+	resolvConfSystem, err := ioutil.ReadFile("/etc/resolv.conf")
+	if err != nil {
 		t.Fatal(err)
 	}
+	//cleanup
+	defer func() {
+		if err := ioutil.WriteFile("/etc/resolv.conf", resolvConfSystem, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}()
 
-	err = ep.Leave(sb2)
-	runtime.LockOSThread()
-	if err != nil {
+	if err := ioutil.WriteFile("/etc/resolv.conf", tmpResolvConf, 0644); err != nil {
 		t.Fatal(err)
 	}
 
-	err = ep.Join(sb2)
-	runtime.LockOSThread()
+	resolvConfPath := "/tmp/libnetwork_test/resolv.conf"
+	defer os.Remove(resolvConfPath)
+
+	sb, err := controller.NewSandbox(containerID,
+		libnetwork.OptionResolvConfPath(resolvConfPath),
+		libnetwork.OptionDNSNdots(2))
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer func() {
+		if err := sb.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
 
-	content, err = ioutil.ReadFile(resolvConfPath)
+	content, err := ioutil.ReadFile(resolvConfPath)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if !bytes.Equal(content, tmpResolvConf3) {
-		t.Fatalf("Expected:\n%s\nGot:\n%s", string(tmpResolvConf3), string(content))
+	if !bytes.Equal(content, expectedResolvConf) {
+		t.Fatalf("Expected:\n%s\nGot:\n%s", string(expectedResolvConf), string(content))
+	}
+
+	_, err = controller.NewSandbox(containerID+"_2",
+		libnetwork.OptionResolvConfPath(resolvConfPath),
+		libnetwork.OptionDNSAttempts(10))
+	if err == nil {
+		t.Fatal("Expected to fail with an out of range attempts value")
+	}
+	if _, ok := err.(types.BadRequestError); !ok {
+		t.Fatalf("Unexpected error type returned: %T", err)
 	}
 }
 
@@ -1969,6 +4211,282 @@ func TestValidRemoteDriver(t *testing.T) {
 	}()
 }
 
+func TestRemoteDriverPluginDirs(t *testing.T) {
+	mux := http.NewServeMux()
+
+	created := false
+	mux.HandleFunc("/Plugin.Activate", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/vnd.docker.plugins.v1+json")
+		fmt.Fprintf(w, `{"Implements": ["%s"]}`, driverapi.NetworkPluginEndpointType)
+	})
+	mux.HandleFunc(fmt.Sprintf("/%s.CreateNetwork", driverapi.NetworkPluginEndpointType), func(w http.ResponseWriter, r *http.Request) {
+		created = true
+		w.Header().Set("Content-Type", "application/vnd.docker.plugins.v1+json")
+		fmt.Fprintf(w, "null")
+	})
+	mux.HandleFunc(fmt.Sprintf("/%s.DeleteNetwork", driverapi.NetworkPluginEndpointType), func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/vnd.docker.plugins.v1+json")
+		fmt.Fprintf(w, "null")
+	})
+
+	emptyDir, err := ioutil.TempDir("", "plugins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(emptyDir)
+
+	pluginDir, err := ioutil.TempDir("", "plugins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(pluginDir)
+
+	// Serve the plugin on a unix socket, reachable whatever the network namespace
+	sockPath := filepath.Join(pluginDir, "server.sock")
+	l, err := net.Listen("unix", sockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go http.Serve(l, mux)
+
+	spec := fmt.Sprintf(`{"Name": "custom-network-driver", "Addr": "unix://%s"}`, sockPath)
+	if err := ioutil.WriteFile(filepath.Join(pluginDir, "custom-network-driver.json"), []byte(spec), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := libnetwork.New(config.OptionPluginDirs([]string{emptyDir, pluginDir}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := c.NewNetwork("custom-network-driver", "dummy",
+		libnetwork.NetworkOptionGeneric(getEmptyGenericOption()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := n.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	if !created {
+		t.Fatal("Expected the network to be created by the plugin found in the custom directory")
+	}
+
+	if _, err := c.NewNetwork("missing-network-driver", "dummy2"); err == nil {
+		t.Fatal("Expected to fail for a plugin not found in any directory")
+	} else if _, ok := err.(types.NotFoundError); !ok {
+		t.Fatalf("Did not fail with expected error. Actual error: %v", err)
+	}
+}
+
+func TestRemoteDriverNetworkOperInfo(t *testing.T) {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/Plugin.Activate", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/vnd.docker.plugins.v1+json")
+		fmt.Fprintf(w, `{"Implements": ["%s"]}`, driverapi.NetworkPluginEndpointType)
+	})
+	for _, method := range []string{"CreateNetwork", "DeleteNetwork"} {
+		mux.HandleFunc(fmt.Sprintf("/%s.%s", driverapi.NetworkPluginEndpointType, method), func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/vnd.docker.plugins.v1+json")
+			fmt.Fprintf(w, "null")
+		})
+	}
+	mux.HandleFunc(fmt.Sprintf("/%s.NetworkOperInfo", driverapi.NetworkPluginEndpointType), func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/vnd.docker.plugins.v1+json")
+		fmt.Fprintf(w, `{"Value": {"vni": 4097, "vtep": "192.168.50.10"}}`)
+	})
+
+	pluginDir, err := ioutil.TempDir("", "plugins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(pluginDir)
+
+	sockPath := filepath.Join(pluginDir, "server.sock")
+	l, err := net.Listen("unix", sockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go http.Serve(l, mux)
+
+	spec := fmt.Sprintf(`{"Name": "operinfo-network-driver", "Addr": "unix://%s"}`, sockPath)
+	if err := ioutil.WriteFile(filepath.Join(pluginDir, "operinfo-network-driver.json"), []byte(spec), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := libnetwork.New(config.OptionPluginDirs([]string{pluginDir}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := c.NewNetwork("operinfo-network-driver", "dummy",
+		libnetwork.NetworkOptionGeneric(getEmptyGenericOption()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := n.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	info, err := n.DriverOperInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info["vni"] != float64(4097) || info["vtep"] != "192.168.50.10" {
+		t.Fatalf("Unexpected operational data reported by the plugin: %v", info)
+	}
+}
+
+func TestRemoteDriverStructuredError(t *testing.T) {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/Plugin.Activate", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/vnd.docker.plugins.v1+json")
+		fmt.Fprintf(w, `{"Implements": ["%s"]}`, driverapi.NetworkPluginEndpointType)
+	})
+	mux.HandleFunc(fmt.Sprintf("/%s.CreateNetwork", driverapi.NetworkPluginEndpointType), func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/vnd.docker.plugins.v1+json")
+		fmt.Fprintf(w, `{"Err": "vlan pool exhausted", "ErrCode": "%s", "ErrRetryable": true}`, driverapi.ErrCodeForbidden)
+	})
+
+	pluginDir, err := ioutil.TempDir("", "plugins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(pluginDir)
+
+	sockPath := filepath.Join(pluginDir, "server.sock")
+	l, err := net.Listen("unix", sockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go http.Serve(l, mux)
+
+	spec := fmt.Sprintf(`{"Name": "structured-error-driver", "Addr": "unix://%s"}`, sockPath)
+	if err := ioutil.WriteFile(filepath.Join(pluginDir, "structured-error-driver.json"), []byte(spec), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := libnetwork.New(config.OptionPluginDirs([]string{pluginDir}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = c.NewNetwork("structured-error-driver", "dummy",
+		libnetwork.NetworkOptionGeneric(getEmptyGenericOption()))
+	if err == nil {
+		t.Fatal("Expected the network creation to fail")
+	}
+	if _, ok := err.(types.ForbiddenError); !ok {
+		t.Fatalf("Did not fail with expected error. Actual error: %v", err)
+	}
+	if !strings.Contains(err.Error(), "vlan pool exhausted") {
+		t.Fatalf("Expected the plugin message in the error. Actual error: %v", err)
+	}
+	if te, ok := err.(interface {
+		Temporary() bool
+	}); !ok || !te.Temporary() {
+		t.Fatalf("Expected the error to be retryable. Actual error: %v", err)
+	}
+}
+
+func TestRemoteDriverResync(t *testing.T) {
+	mux := http.NewServeMux()
+
+	var (
+		mu        sync.Mutex
+		networks  = map[string]bool{}
+		endpoints = map[string]bool{}
+	)
+	mux.HandleFunc("/Plugin.Activate", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/vnd.docker.plugins.v1+json")
+		fmt.Fprintf(w, `{"Implements": ["%s"]}`, driverapi.NetworkPluginEndpointType)
+	})
+	mux.HandleFunc(fmt.Sprintf("/%s.CreateNetwork", driverapi.NetworkPluginEndpointType), func(w http.ResponseWriter, r *http.Request) {
+		var req struct{ NetworkID string }
+		json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		networks[req.NetworkID] = true
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/vnd.docker.plugins.v1+json")
+		fmt.Fprintf(w, "null")
+	})
+	mux.HandleFunc(fmt.Sprintf("/%s.CreateEndpoint", driverapi.NetworkPluginEndpointType), func(w http.ResponseWriter, r *http.Request) {
+		var req struct{ NetworkID, EndpointID string }
+		json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		endpoints[req.NetworkID+"/"+req.EndpointID] = true
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/vnd.docker.plugins.v1+json")
+		fmt.Fprintf(w, "null")
+	})
+
+	pluginDir, err := ioutil.TempDir("", "plugins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(pluginDir)
+
+	sockPath := filepath.Join(pluginDir, "server.sock")
+	l, err := net.Listen("unix", sockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go http.Serve(l, mux)
+
+	spec := fmt.Sprintf(`{"Name": "resync-driver", "Addr": "unix://%s"}`, sockPath)
+	if err := ioutil.WriteFile(filepath.Join(pluginDir, "resync-driver.json"), []byte(spec), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := libnetwork.New(config.OptionPluginDirs([]string{pluginDir}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := c.NewNetwork("resync-driver", "dummy",
+		libnetwork.NetworkOptionGeneric(getEmptyGenericOption()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep, err := n.CreateEndpoint("ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The plugin restarts and forgets about everything
+	mu.Lock()
+	networks = map[string]bool{}
+	endpoints = map[string]bool{}
+	mu.Unlock()
+
+	rc, ok := c.(driverapi.DriverResyncCallback)
+	if !ok {
+		t.Fatal("Expected the controller to resync the drivers")
+	}
+	if err := rc.ResyncDriver("resync-driver"); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !networks[n.ID()] {
+		t.Fatalf("Expected network %s to be replayed to the plugin. Got %v", n.ID(), networks)
+	}
+	if !endpoints[n.ID()+"/"+ep.ID()] {
+		t.Fatalf("Expected endpoint %s to be replayed to the plugin. Got %v", ep.ID(), endpoints)
+	}
+}
+
 var (
 	once   sync.Once
 	start  = make(chan struct{})
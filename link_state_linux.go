@@ -0,0 +1,198 @@
+package libnetwork
+
+import (
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/vishvananda/netlink/nl"
+)
+
+// linkPollTimeout is how often the receive loop of a link monitor wakes up to
+// honor a stop request
+const linkPollTimeout = 500 * time.Millisecond
+
+// linkMonitor watches the links of the network namespace of a sandbox and
+// reports the operational state changes of the endpoint interfaces, like a
+// carrier loss on a macvlan or ipvlan uplink, as LinkStateChanged events on
+// the endpoint network.
+type linkMonitor struct {
+	sb      *sandbox
+	fd      int
+	states  map[int32]bool // last known operational state, by link index
+	stopCh  chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+}
+
+// startLinkMonitor starts the link monitor of the sandbox, if not running yet.
+// The host network namespace is not monitored.
+func (sb *sandbox) startLinkMonitor() {
+	if sb.config.useDefaultSandBox || sb.osSbox == nil {
+		return
+	}
+
+	sb.Lock()
+	defer sb.Unlock()
+	if sb.linkMon != nil {
+		return
+	}
+
+	var (
+		fd     int
+		states map[int32]bool
+		err    error
+	)
+	// The socket only gets the messages of the namespace it is created in
+	if nErr := sb.osSbox.InvokeFunc(func() {
+		if fd, err = openLinkSocket(); err != nil {
+			return
+		}
+		// Subscribed first, no change is missed between the dump and the messages
+		if states, err = linkStates(); err != nil {
+			syscall.Close(fd)
+		}
+	}); nErr != nil {
+		err = nErr
+	}
+	if err != nil {
+		log.Warnf("Failed to monitor the links of sandbox %s: %v", sb.id, err)
+		return
+	}
+
+	sb.linkMon = &linkMonitor{
+		sb:      sb,
+		fd:      fd,
+		states:  states,
+		stopCh:  make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go sb.linkMon.run()
+}
+
+func (sb *sandbox) stopLinkMonitor() {
+	sb.Lock()
+	m := sb.linkMon
+	sb.linkMon = nil
+	sb.Unlock()
+
+	if m != nil {
+		m.stop()
+	}
+}
+
+// openLinkSocket returns a netlink socket subscribed to the link messages of
+// the network namespace the calling thread is in
+func openLinkSocket() (int, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return -1, err
+	}
+	lsa := &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: 1 << (syscall.RTNLGRP_LINK - 1)}
+	if err := syscall.Bind(fd, lsa); err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+	// Wake up the receive loop periodically to honor the stop request
+	tv := syscall.NsecToTimeval(int64(linkPollTimeout))
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		syscall.Close(fd)
+		return -1, err
+	}
+	return fd, nil
+}
+
+// linkStates returns whether the links of the network namespace the calling
+// thread is in are operationally up, by link index
+func linkStates() (map[int32]bool, error) {
+	req := nl.NewNetlinkRequest(syscall.RTM_GETLINK, syscall.NLM_F_DUMP)
+	req.AddData(nl.NewIfInfomsg(syscall.AF_UNSPEC))
+
+	msgs, err := req.Execute(syscall.NETLINK_ROUTE, syscall.RTM_NEWLINK)
+	if err != nil {
+		return nil, err
+	}
+	states := make(map[int32]bool, len(msgs))
+	for _, m := range msgs {
+		ifi := nl.DeserializeIfInfomsg(m)
+		states[ifi.Index] = ifi.Flags&syscall.IFF_RUNNING != 0
+	}
+	return states, nil
+}
+
+func (m *linkMonitor) run() {
+	defer close(m.stopped)
+	defer syscall.Close(m.fd)
+
+	buf := make([]byte, 65536)
+	for {
+		select {
+		case <-m.stopCh:
+			return
+		default:
+		}
+
+		n, _, err := syscall.Recvfrom(m.fd, buf, 0)
+		if err != nil || n < syscall.NLMSG_HDRLEN {
+			continue
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			log.Debugf("Failed to parse the link messages of sandbox %s: %v", m.sb.ID(), err)
+			continue
+		}
+		for _, msg := range msgs {
+			if len(msg.Data) < syscall.SizeofIfInfomsg {
+				continue
+			}
+			switch msg.Header.Type {
+			case syscall.RTM_NEWLINK:
+				m.handleLink(msg.Data)
+			case syscall.RTM_DELLINK:
+				delete(m.states, nl.DeserializeIfInfomsg(msg.Data).Index)
+			}
+		}
+	}
+}
+
+// handleLink records the state carried by the link message and notifies its
+// change if the link is the interface of an endpoint joined to the sandbox. A
+// link moved into the namespace has no known state, its first one is recorded.
+func (m *linkMonitor) handleLink(data []byte) {
+	ifi := nl.DeserializeIfInfomsg(data)
+	index := ifi.Index
+	up := ifi.Flags&syscall.IFF_RUNNING != 0
+
+	prev, known := m.states[index]
+	m.states[index] = up
+	if !known || prev == up {
+		return
+	}
+
+	attrs, err := nl.ParseRouteAttr(data[syscall.SizeofIfInfomsg:])
+	if err != nil {
+		return
+	}
+	var name string
+	for _, a := range attrs {
+		if a.Attr.Type == syscall.IFLA_IFNAME && len(a.Value) > 0 {
+			name = string(a.Value[:len(a.Value)-1])
+		}
+	}
+
+	ep := m.sb.endpointByInterface(name)
+	if ep == nil {
+		return
+	}
+
+	ep.getNetwork().notifyMembership(MembershipEvent{Type: LinkStateChanged, EndpointID: ep.ID(), EndpointName: ep.Name(),
+		SandboxID: m.sb.ID(), Interface: name, LinkUp: up})
+}
+
+func (m *linkMonitor) stop() {
+	m.once.Do(func() {
+		close(m.stopCh)
+	})
+	<-m.stopped
+}
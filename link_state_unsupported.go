@@ -0,0 +1,10 @@
+// +build !linux
+
+package libnetwork
+
+// linkMonitor is not supported on this platform
+type linkMonitor struct{}
+
+func (sb *sandbox) startLinkMonitor() {}
+
+func (sb *sandbox) stopLinkMonitor() {}
@@ -15,12 +15,95 @@ const (
 	// PortMap constant represents Port Mapping
 	PortMap = Prefix + ".portmap"
 
+	// PortMapTarget constant represents the address the port mappings of the endpoint are forwarded to
+	PortMapTarget = Prefix + ".portmap.target"
+
+	// PortMapClaimOnly constant represents the host ports of the port mappings being
+	// reserved without being forwarded until the port bindings are activated
+	PortMapClaimOnly = Prefix + ".portmap.claim_only"
+
+	// LazyLink constant represents the endpoint interfaces being created on the
+	// endpoint activation rather than on its creation
+	LazyLink = Prefix + ".endpoint.lazylink"
+
 	// MacAddress constant represents Mac Address config of a Container
 	MacAddress = Prefix + ".endpoint.macaddress"
 
+	// IPAddress constant represents the IPv4 address requested for the endpoint
+	IPAddress = Prefix + ".endpoint.ipaddress"
+
 	// ExposedPorts constant represents exposedports of a Container
 	ExposedPorts = Prefix + ".endpoint.exposedports"
 
+	// NetClsClassID constant represents the net_cls classid of a Container
+	NetClsClassID = Prefix + ".endpoint.net_cls_classid"
+
+	// TxQueueLen constant represents the transmit queue length of the endpoint interfaces
+	TxQueueLen = Prefix + ".endpoint.txqueuelen"
+
+	// Offloads constant represents the offload features toggled on the endpoint interfaces
+	Offloads = Prefix + ".endpoint.offloads"
+
+	// ACL constant represents the access control rules of the endpoint
+	ACL = Prefix + ".endpoint.acl"
+
+	// DSCP constant represents the DSCP value marked on the endpoint egress packets
+	DSCP = Prefix + ".endpoint.dscp"
+
+	// ConnLimit constant represents the maximum number of concurrent connections to the endpoint
+	ConnLimit = Prefix + ".endpoint.connlimit"
+
+	// AddressGroup constant represents the group of endpoints spread across distinct address sub-ranges
+	AddressGroup = Prefix + ".endpoint.address_group"
+
+	// Promiscuous constant represents whether the endpoint interface is in promiscuous mode
+	Promiscuous = Prefix + ".endpoint.promiscuous"
+
+	// VIP constant represents the bridge virtual address the endpoint owns through NAT
+	VIP = Prefix + ".endpoint.vip"
+
+	// MTU constant represents the MTU of the endpoint interfaces
+	MTU = Prefix + ".endpoint.mtu"
+
+	// MTUClamped constant represents the endpoint MTU being lowered to the network maximum MTU
+	MTUClamped = Prefix + ".endpoint.mtu_clamped"
+
+	// DisableForwarding constant represents the network traffic not being forwarded off-host
+	DisableForwarding = Prefix + ".disable_forwarding"
+
+	// ConntrackZone constant represents the conntrack zone the network traffic is tracked in
+	ConntrackZone = Prefix + ".conntrack_zone"
+
+	// AgeingTime constant represents the lifetime, in seconds, of the network bridge forwarding database entries
+	AgeingTime = Prefix + ".ageing_time"
+
+	// ForwardDelay constant represents the forward delay, in seconds, of the network bridge ports
+	ForwardDelay = Prefix + ".forward_delay"
+
+	// GroupFwdMask constant represents the mask of the link-local group addresses
+	// whose frames the network bridge forwards
+	GroupFwdMask = Prefix + ".group_fwd_mask"
+
+	// BridgeName constant represents the name of the bridge device backing the network
+	BridgeName = Prefix + ".bridge_name"
+
+	// BridgeIndex constant represents the link index of the bridge device backing the network
+	BridgeIndex = Prefix + ".bridge_index"
+
+	// MaxMTU constant represents the largest MTU the network endpoints can use without fragmentation
+	MaxMTU = Prefix + ".max_mtu"
+
+	// ReservedAddresses constant represents the addresses the network holds for itself,
+	// mapped to "gateway" for its gateways and to "reserved" for the other ones
+	ReservedAddresses = Prefix + ".reserved_addresses"
+
+	// AllocationStrategy constant represents the order the network endpoints get
+	// their address in, AllocationSequential or AllocationRandom
+	AllocationStrategy = Prefix + ".allocation_strategy"
+
+	// Gateway constant represents the IPv4 gateway of the network endpoints
+	Gateway = Prefix + ".gateway"
+
 	//EnableIPv6 constant represents enabling IPV6 at network level
 	EnableIPv6 = Prefix + ".enable_ipv6"
 
@@ -35,6 +118,30 @@ const (
 
 	// OverlayNeighborIP constant represents overlay driver neighbor IP
 	OverlayNeighborIP = DriverPrefix + ".overlay.neighbor_ip"
+
+	// OverlayNeighSuppress constant represents enabling ARP suppression in the overlay driver
+	OverlayNeighSuppress = DriverPrefix + ".overlay.neigh_suppress"
+)
+
+// Values of the AllocationStrategy label
+const (
+	// AllocationSequential hands out the addresses in order, the default
+	AllocationSequential = "sequential"
+
+	// AllocationRandom hands out the free addresses in random order
+	AllocationRandom = "random"
+)
+
+// Exhaustion policies of the networks backed by an ipam pool
+const (
+	// ExhaustionFail fails the endpoint creations once the pool is exhausted, the default
+	ExhaustionFail = "fail"
+
+	// ExhaustionExpand adds the network expansion subnet to the pool once it is exhausted
+	ExhaustionExpand = "expand"
+
+	// ExhaustionQueue holds the endpoint creations until an address is released
+	ExhaustionQueue = "queue"
 )
 
 // Key extracts the key portion of the label
@@ -3,6 +3,7 @@ package libnetwork
 import (
 	"encoding/json"
 	"net"
+	"strings"
 	"sync"
 
 	log "github.com/Sirupsen/logrus"
@@ -11,11 +12,20 @@ import (
 	"github.com/docker/libnetwork/datastore"
 	"github.com/docker/libnetwork/driverapi"
 	"github.com/docker/libnetwork/etchosts"
+	"github.com/docker/libnetwork/ipam"
 	"github.com/docker/libnetwork/netlabel"
 	"github.com/docker/libnetwork/options"
 	"github.com/docker/libnetwork/types"
 )
 
+// EndpointSpec describes the endpoint replacing another one
+type EndpointSpec struct {
+	// Name of the new endpoint, the one of the replaced endpoint if empty
+	Name string
+	// Options the new endpoint is created with
+	Options []EndpointOption
+}
+
 // A Network represents a logical connectivity zone that containers may
 // join using the Link method. A Network is managed by a specific driver.
 type Network interface {
@@ -28,14 +38,29 @@ type Network interface {
 	// The type of network, which corresponds to its managing driver.
 	Type() string
 
+	// Scope returns whether the network is local to this host or spans the
+	// hosts of the cluster, as given by the scope capability of its driver.
+	Scope() datastore.DataScope
+
 	// Create a new endpoint to this network symbolically identified by the
 	// specified unique name. The options parameter carry driver specific options.
-	// Labels support will be added in the near future.
+	// Labels are passed through CreateOptionLabels.
 	CreateEndpoint(name string, options ...EndpointOption) (Endpoint, error)
 
+	// ReplaceEndpoint creates an endpoint as described by the spec which takes
+	// over the addresses and the DNS names of the old one, then deletes the old
+	// endpoint. The addresses stay reserved during the swap. The old endpoint
+	// is kept if the replacement fails.
+	ReplaceEndpoint(old Endpoint, spec EndpointSpec) (Endpoint, error)
+
 	// Delete the network.
 	Delete() error
 
+	// Update changes at runtime the driver options of the network, passed in
+	// the format of NetworkOptionGeneric. Drivers only support updating some of
+	// their options, like the bridge iptables logging ones.
+	Update(generic map[string]interface{}) error
+
 	// Endpoints returns the list of Endpoint(s) in this network.
 	Endpoints() []Endpoint
 
@@ -47,6 +72,102 @@ type Network interface {
 
 	// EndpointByID returns the Endpoint which has the passed id. If not found, the error ErrNoSuchEndpoint is returned.
 	EndpointByID(id string) (Endpoint, error)
+
+	// Return certain operational data belonging to this network
+	Info() NetworkInfo
+
+	// DriverOperInfo returns the operational data the driver reports for this
+	// network, like the bridge device or the overlay VNI, as opposed to the
+	// configuration the network was created with. It is nil when the driver
+	// does not report any.
+	DriverOperInfo() (map[string]interface{}, error)
+
+	// Watch returns a channel delivering the membership changes of this
+	// network, and the function to call to stop watching.
+	Watch() (<-chan MembershipEvent, func())
+
+	// AddressAllocations returns the addresses allocated in this network, mapped to
+	// the id of the endpoint they are assigned to. The addresses the driver reserves
+	// for the network itself are mapped to "gateway" or "reserved".
+	AddressAllocations() (map[string]string, error)
+
+	// PreviewNextAddress returns the IPv4 address the next endpoint created on
+	// this network would get, past the gateway and the other reserved addresses,
+	// without reserving it. It is advisory only: a concurrent endpoint creation
+	// can take the address before the caller gets to use the answer.
+	PreviewNextAddress() (net.IP, error)
+
+	// Verify compares the host resources of the network, like the bridge device
+	// and its ports, against the ones its driver configured and returns the
+	// differences found.
+	Verify() ([]Discrepancy, error)
+
+	// FDB returns the forwarding database of the host devices backing the
+	// network, such as the bridge and its ports.
+	FDB() ([]FDBEntry, error)
+
+	// Neighbors returns the host neighbor cache entries on the devices backing the network.
+	Neighbors() ([]NeighborEntry, error)
+
+	// FirewallRules returns the host firewall rules the driver installed for the
+	// network, such as its NAT, filtering and isolation rules, as rendered by
+	// the firewall.
+	FirewallRules() ([]string, error)
+}
+
+// MembershipEventType identifies the kind of membership change of a network
+type MembershipEventType int
+
+const (
+	// EndpointAdded is the event of an endpoint created on the network
+	EndpointAdded MembershipEventType = iota
+	// EndpointRemoved is the event of an endpoint deleted from the network
+	EndpointRemoved
+	// EndpointJoined is the event of an endpoint joined by a sandbox
+	EndpointJoined
+	// EndpointLeft is the event of an endpoint left by its sandbox
+	EndpointLeft
+	// LinkStateChanged is the event of the interface of a joined endpoint
+	// going operationally up or down in its sandbox, like on a carrier loss
+	LinkStateChanged
+)
+
+// MembershipEvent describes a membership change of a network
+type MembershipEvent struct {
+	Type         MembershipEventType
+	EndpointID   string
+	EndpointName string
+	// SandboxID is only set for the join, leave and link state events
+	SandboxID string
+	// Interface and LinkUp are only set for the link state events: the name
+	// of the endpoint interface in the sandbox and whether its link is up
+	Interface string
+	LinkUp    bool
+}
+
+// membershipEventBuffer is the number of events a watcher can lag behind
+// before the following events are dropped for it
+const membershipEventBuffer = 64
+
+// NetworkInfo returns operational information about the network
+type NetworkInfo interface {
+	// EndpointCount returns the number of endpoints in the network
+	EndpointCount() uint64
+
+	// MaxEndpoints returns the maximum number of endpoints the network accepts, 0 if unlimited
+	MaxEndpoints() uint64
+
+	// MaxMTU returns the largest MTU the network endpoints can use without fragmentation,
+	// as discovered by the driver, 0 if unknown
+	MaxMTU() int
+
+	// ForwardingDisabled returns whether the driver keeps the network traffic
+	// from being forwarded off-host, regardless of the global IP forwarding
+	ForwardingDisabled() bool
+
+	// GroupFwdMask returns the mask of the link-local group addresses whose
+	// frames the network bridge forwards, 0 if none
+	GroupFwdMask() uint16
 }
 
 // EndpointWalker is a client provided function which will be used to walk the Endpoints.
@@ -56,19 +177,31 @@ type EndpointWalker func(ep Endpoint) bool
 type svcMap map[string]net.IP
 
 type network struct {
-	ctrlr       *controller
-	name        string
-	networkType string
-	id          string
-	driver      driverapi.Driver
-	enableIPv6  bool
-	endpointCnt uint64
-	endpoints   endpointTable
-	generic     options.Generic
-	dbIndex     uint64
-	svcRecords  svcMap
-	dbExists    bool
-	stopWatchCh chan struct{}
+	ctrlr        *controller
+	name         string
+	networkType  string
+	id           string
+	driver       driverapi.Driver
+	enableIPv6   bool
+	ipamPool     string
+	ipamPoolV6   string
+	allocation   string
+	exhaustion   string
+	expansion    *net.IPNet
+	gateway      net.IP
+	scope        datastore.DataScope
+	domain       string
+	dnsForward   map[string][]string // nameservers by domain, for the embedded resolver
+	endpointCnt  uint64
+	maxEndpoints uint64
+	labels       map[string]string
+	endpoints    endpointTable
+	generic      options.Generic
+	dbIndex      uint64
+	svcRecords   svcMap
+	dbExists     bool
+	stopWatchCh  chan struct{}
+	watchers     map[chan MembershipEvent]struct{}
 	sync.Mutex
 }
 
@@ -97,6 +230,13 @@ func (n *network) Type() string {
 	return n.driver.Type()
 }
 
+func (n *network) Scope() datastore.DataScope {
+	n.Lock()
+	defer n.Unlock()
+
+	return n.scope
+}
+
 func (n *network) Key() []string {
 	n.Lock()
 	defer n.Unlock()
@@ -140,12 +280,188 @@ func (n *network) Exists() bool {
 	return n.dbExists
 }
 
+func (n *network) Info() NetworkInfo {
+	return n
+}
+
+func (n *network) EndpointCount() uint64 {
+	return n.EndpointCnt()
+}
+
+func (n *network) MaxEndpoints() uint64 {
+	n.Lock()
+	defer n.Unlock()
+	return n.maxEndpoints
+}
+
+func (n *network) MaxMTU() int {
+	mtu, _ := n.driverInfo()[netlabel.MaxMTU].(int)
+	return mtu
+}
+
+func (n *network) ForwardingDisabled() bool {
+	disabled, _ := n.driverInfo()[netlabel.DisableForwarding].(bool)
+	return disabled
+}
+
+func (n *network) GroupFwdMask() uint16 {
+	mask, _ := n.driverInfo()[netlabel.GroupFwdMask].(uint16)
+	return mask
+}
+
+func (n *network) DriverOperInfo() (map[string]interface{}, error) {
+	n.Lock()
+	d := n.driver
+	id := n.id
+	n.Unlock()
+
+	nd, ok := d.(driverapi.NetworkInfoDriver)
+	if !ok {
+		return nil, nil
+	}
+
+	return nd.NetworkOperInfo(id)
+}
+
+// driverInfo returns the network operational data reported by the driver, if any
+func (n *network) driverInfo() map[string]interface{} {
+	info, err := n.DriverOperInfo()
+	if err != nil {
+		log.Debugf("Failed to retrieve the operational data of network %s: %v", n.ID(), err)
+		return nil
+	}
+
+	return info
+}
+
+func (n *network) AddressAllocations() (map[string]string, error) {
+	allocs := make(map[string]string)
+
+	// Remote plugins predating NetworkOperInfo fail the call, they reserve nothing
+	if reserved, ok := n.driverInfo()[netlabel.ReservedAddresses].(map[string]string); ok {
+		for ip, kind := range reserved {
+			allocs[ip] = kind
+		}
+	}
+
+	for _, e := range n.Endpoints() {
+		ep := e.(*endpoint)
+		ep.Lock()
+		for _, i := range ep.iFaces {
+			if len(i.addr.IP) != 0 {
+				allocs[i.addr.IP.String()] = ep.id
+			}
+			if len(i.addrv6.IP) != 0 {
+				allocs[i.addrv6.IP.String()] = ep.id
+			}
+		}
+		ep.Unlock()
+	}
+
+	return allocs, nil
+}
+
+func (n *network) PreviewNextAddress() (net.IP, error) {
+	n.Lock()
+	d := n.driver
+	id := n.id
+	pool := n.ipamPool
+	n.Unlock()
+
+	if pool != "" {
+		return n.ctrlr.previewPoolAddress(pool)
+	}
+
+	pd, ok := d.(driverapi.AddressPreviewDriver)
+	if !ok {
+		return nil, types.NotImplementedErrorf("network %s driver %s cannot preview the endpoint addresses", n.Name(), d.Type())
+	}
+	ip, err := pd.PreviewAddress(id)
+	if err != nil {
+		return nil, mapDriverError(err)
+	}
+	return ip, nil
+}
+
+func (n *network) Verify() ([]Discrepancy, error) {
+	n.Lock()
+	d := n.driver
+	id := n.id
+	n.Unlock()
+
+	vd, ok := d.(driverapi.NetworkVerifyDriver)
+	if !ok {
+		return nil, types.NotImplementedErrorf("driver %s does not support network verification", d.Type())
+	}
+
+	ddl, err := vd.VerifyNetwork(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var dl []Discrepancy
+	for _, dd := range ddl {
+		dl = append(dl, Discrepancy{Interface: dd.Interface, Resource: dd.Resource, Expected: dd.Expected, Actual: dd.Actual})
+	}
+	return dl, nil
+}
+
+func (n *network) Watch() (<-chan MembershipEvent, func()) {
+	ch := make(chan MembershipEvent, membershipEventBuffer)
+
+	n.Lock()
+	if n.watchers == nil {
+		n.watchers = make(map[chan MembershipEvent]struct{})
+	}
+	n.watchers[ch] = struct{}{}
+	n.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			n.Lock()
+			delete(n.watchers, ch)
+			close(ch)
+			n.Unlock()
+		})
+	}
+}
+
+// notifyMembership delivers the event to the network watchers. It never blocks:
+// the event is dropped for the watchers which are not keeping up.
+func (n *network) notifyMembership(ev MembershipEvent) {
+	n.Lock()
+	defer n.Unlock()
+
+	for ch := range n.watchers {
+		select {
+		case ch <- ev:
+		default:
+			log.Warnf("dropping membership event %d for endpoint %s on network %s", ev.Type, ev.EndpointName, n.name)
+		}
+	}
+}
+
 func (n *network) EndpointCnt() uint64 {
 	n.Lock()
 	defer n.Unlock()
 	return n.endpointCnt
 }
 
+// incEndpointCntChecked increments the endpoint count unless the network
+// already holds its maximum number of endpoints
+func (n *network) incEndpointCntChecked() error {
+	n.Lock()
+	defer n.Unlock()
+
+	if n.maxEndpoints != 0 && n.endpointCnt >= n.maxEndpoints {
+		return &ErrNetworkFull{name: n.name, id: n.id, max: n.maxEndpoints}
+	}
+	n.endpointCnt++
+
+	return nil
+}
+
 func (n *network) IncEndpointCnt() {
 	n.Lock()
 	n.endpointCnt++
@@ -165,7 +481,28 @@ func (n *network) MarshalJSON() ([]byte, error) {
 	netMap["id"] = n.id
 	netMap["networkType"] = n.networkType
 	netMap["endpointCnt"] = n.endpointCnt
+	netMap["maxEndpoints"] = n.maxEndpoints
 	netMap["enableIPv6"] = n.enableIPv6
+	netMap["ipamPool"] = n.ipamPool
+	if n.ipamPoolV6 != "" {
+		netMap["ipamPoolV6"] = n.ipamPoolV6
+	}
+	netMap["allocation"] = n.allocation
+	if n.exhaustion != "" {
+		netMap["exhaustion"] = n.exhaustion
+	}
+	if n.expansion != nil {
+		netMap["expansion"] = n.expansion.String()
+	}
+	if n.gateway != nil {
+		netMap["gateway"] = n.gateway.String()
+	}
+	netMap["scope"] = string(n.scope)
+	netMap["domain"] = n.domain
+	if len(n.dnsForward) != 0 {
+		netMap["dns_forward"] = n.dnsForward
+	}
+	netMap["labels"] = n.labels
 	netMap["generic"] = n.generic
 	return json.Marshal(netMap)
 }
@@ -180,7 +517,42 @@ func (n *network) UnmarshalJSON(b []byte) (err error) {
 	n.id = netMap["id"].(string)
 	n.networkType = netMap["networkType"].(string)
 	n.endpointCnt = uint64(netMap["endpointCnt"].(float64))
+	if v, ok := netMap["maxEndpoints"]; ok {
+		n.maxEndpoints = uint64(v.(float64))
+	}
 	n.enableIPv6 = netMap["enableIPv6"].(bool)
+	if v, ok := netMap["ipamPool"]; ok {
+		n.ipamPool = v.(string)
+	}
+	if v, ok := netMap["ipamPoolV6"]; ok {
+		n.ipamPoolV6 = v.(string)
+	}
+	if v, ok := netMap["allocation"]; ok {
+		n.allocation = v.(string)
+	}
+	if v, ok := netMap["exhaustion"]; ok {
+		n.exhaustion = v.(string)
+	}
+	if v, ok := netMap["expansion"]; ok {
+		if _, n.expansion, err = net.ParseCIDR(v.(string)); err != nil {
+			return err
+		}
+	}
+	if v, ok := netMap["gateway"]; ok {
+		n.gateway = net.ParseIP(v.(string))
+	}
+	if v, ok := netMap["scope"]; ok {
+		n.scope = datastore.DataScope(v.(string))
+	}
+	if v, ok := netMap["domain"]; ok {
+		n.domain = v.(string)
+	}
+	if v, ok := netMap["dns_forward"]; ok {
+		fb, _ := json.Marshal(v)
+		json.Unmarshal(fb, &n.dnsForward)
+	}
+	lb, _ := json.Marshal(netMap["labels"])
+	json.Unmarshal(lb, &n.labels)
 	if netMap["generic"] != nil {
 		n.generic = netMap["generic"].(map[string]interface{})
 	}
@@ -192,6 +564,99 @@ func (n *network) UnmarshalJSON(b []byte) (err error) {
 // provided by libnetwork, they look like NetworkOptionXXXX(...)
 type NetworkOption func(n *network)
 
+// NetworkOptionMaxEndpoints function returns an option setter for the maximum
+// number of endpoints the network accepts. A value of 0 means no limit.
+func NetworkOptionMaxEndpoints(max int) NetworkOption {
+	return func(n *network) {
+		if max > 0 {
+			n.maxEndpoints = uint64(max)
+		}
+	}
+}
+
+// NetworkOptionIpamPool function returns an option setter for the name of the
+// ipam pool, created through CreateIpamPool, the network endpoints get their
+// address from. Only the drivers implementing driverapi.PoolAddressDriver
+// support ipam pools, network creation fails with the others.
+func NetworkOptionIpamPool(name string) NetworkOption {
+	return func(n *network) {
+		n.ipamPool = name
+	}
+}
+
+// NetworkOptionIpamPoolV6 function returns an option setter for the name of the
+// IPv6 ipam pool the network endpoints get their IPv6 address from. It is
+// independent from the IPv4 pool set through NetworkOptionIpamPool.
+func NetworkOptionIpamPoolV6(name string) NetworkOption {
+	return func(n *network) {
+		n.ipamPoolV6 = name
+	}
+}
+
+// NetworkOptionExhaustionPolicy function returns an option setter for what the
+// endpoint creations do once the ipam pool of the network is exhausted. With
+// netlabel.ExhaustionExpand, the expansion subnet is added to the pool as a
+// secondary subnet the following addresses come from. The default policy,
+// netlabel.ExhaustionFail, fails them. netlabel.ExhaustionQueue is not supported.
+func NetworkOptionExhaustionPolicy(policy string, expansion *net.IPNet) NetworkOption {
+	return func(n *network) {
+		n.exhaustion = policy
+		if expansion != nil {
+			n.expansion = &net.IPNet{IP: expansion.IP.Mask(expansion.Mask), Mask: expansion.Mask}
+		}
+	}
+}
+
+// NetworkOptionAllocationStrategy function returns an option setter for the
+// order the network endpoints get their address in, netlabel.AllocationSequential
+// or netlabel.AllocationRandom.
+func NetworkOptionAllocationStrategy(strategy string) NetworkOption {
+	return func(n *network) {
+		n.allocation = strategy
+	}
+}
+
+// NetworkOptionGateway function returns an option setter for the IPv4 gateway
+// of the network endpoints, any host address of the network subnet rather
+// than the driver default. The gateway address is not handed out to endpoints.
+func NetworkOptionGateway(gw net.IP) NetworkOption {
+	return func(n *network) {
+		n.gateway = types.GetIPCopy(gw)
+	}
+}
+
+// NetworkOptionDomain function returns an option setter for the DNS domain of
+// the network. The sandboxes joined to the network get it in their resolv.conf
+// search list, after their own search domains and the ones of the networks
+// they are joined to with a higher priority.
+func NetworkOptionDomain(domain string) NetworkOption {
+	return func(n *network) {
+		n.domain = strings.TrimSpace(domain)
+	}
+}
+
+// NetworkOptionDNSForward function returns an option setter for a DNS
+// forwarding rule of the network: the queries of the sandboxes joined to the
+// network for the domain, "corp" or "*.corp", and its subdomains are forwarded
+// to the nameservers by the sandbox embedded resolver. The other queries go to
+// the nameservers of the sandbox resolv.conf.
+func NetworkOptionDNSForward(domain string, nameservers ...string) NetworkOption {
+	return func(n *network) {
+		n.dnsForward = addDNSForward(n.dnsForward, domain, nameservers)
+	}
+}
+
+// NetworkOptionLabels function returns an option setter for the labels the
+// network is selected with through FilterNetworks.
+func NetworkOptionLabels(labels map[string]string) NetworkOption {
+	return func(n *network) {
+		n.labels = make(map[string]string, len(labels))
+		for k, v := range labels {
+			n.labels[k] = v
+		}
+	}
+}
+
 // NetworkOptionGeneric function returns an option setter for a Generic option defined
 // in a Dictionary of Key-Value pair
 func NetworkOptionGeneric(generic map[string]interface{}) NetworkOption {
@@ -203,6 +668,35 @@ func NetworkOptionGeneric(generic map[string]interface{}) NetworkOption {
 	}
 }
 
+// driverOptions returns the options the network is created with in the driver
+func (n *network) driverOptions() map[string]interface{} {
+	n.Lock()
+	defer n.Unlock()
+
+	if n.allocation == "" && n.gateway == nil {
+		return n.generic
+	}
+	opts := make(map[string]interface{}, len(n.generic)+2)
+	for k, v := range n.generic {
+		opts[k] = v
+	}
+	if n.allocation != "" {
+		opts[netlabel.AllocationStrategy] = n.allocation
+	}
+	if n.gateway != nil {
+		opts[netlabel.Gateway] = types.GetIPCopy(n.gateway)
+	}
+	return opts
+}
+
+// matchLabels returns whether the network labels carry all the selector pairs
+func (n *network) matchLabels(selector map[string]string) bool {
+	n.Lock()
+	defer n.Unlock()
+
+	return labelsMatch(n.labels, selector)
+}
+
 func (n *network) processOptions(options ...NetworkOption) {
 	for _, opt := range options {
 		if opt != nil {
@@ -232,10 +726,11 @@ func (n *network) Delete() error {
 	}
 
 	// deleteNetworkFromStore performs an atomic delete operation and the network.endpointCnt field will help
-	// prevent any possible race between endpoint join and network delete
-	if err = ctrlr.deleteNetworkFromStore(n); err != nil {
+	// prevent any possible race between endpoint join and network delete. The key is already gone if a
+	// previous delete attempt failed past this point: carry on with the rest of the cleanup.
+	if err = ctrlr.deleteNetworkFromStore(n); err != nil && err != datastore.ErrKeyNotFound {
 		if err == datastore.ErrKeyModified {
-			return types.InternalErrorf("operation in progress. delete failed for network %s. Please try again.")
+			return types.InternalErrorf("operation in progress. delete failed for network %s. Please try again.", n.Name())
 		}
 		return err
 	}
@@ -251,20 +746,31 @@ func (n *network) deleteNetwork() error {
 	n.Lock()
 	id := n.id
 	d := n.driver
-	n.ctrlr.Lock()
-	delete(n.ctrlr.networks, id)
-	n.ctrlr.Unlock()
 	n.Unlock()
 
-	if err := d.DeleteNetwork(n.id); err != nil {
-		// Forbidden Errors should be honored
-		if _, ok := err.(types.ForbiddenError); ok {
-			n.ctrlr.Lock()
-			n.ctrlr.networks[n.id] = n
-			n.ctrlr.Unlock()
+	// The network is dropped from the controller only once the driver is done
+	// with it, so that a failed delete can be resumed by calling Delete again.
+	if err := d.DeleteNetwork(id); err != nil {
+		// A maskable error tells the driver does not know about the network
+		// anymore, as when a previous delete attempt got past the driver
+		if _, ok := err.(types.MaskableError); !ok {
 			return err
 		}
-		log.Warnf("driver error deleting network %s : %v", n.name, err)
+		log.Debugf("driver does not know network %s anymore, completing its delete: %v", n.name, err)
+	}
+
+	n.ctrlr.Lock()
+	delete(n.ctrlr.networks, id)
+	if n.ctrlr.defaultNw == id {
+		n.ctrlr.defaultNw = ""
+	}
+	n.ctrlr.Unlock()
+
+	if n.ipamPool != "" {
+		n.ctrlr.detachIpamPool(n.ipamPool, n.id)
+	}
+	if n.ipamPoolV6 != "" {
+		n.ctrlr.detachIpamPool(n.ipamPoolV6, n.id)
 	}
 	n.stopWatch()
 	return nil
@@ -275,8 +781,16 @@ func (n *network) addEndpoint(ep *endpoint) error {
 	n.Lock()
 	n.endpoints[ep.id] = ep
 	d := n.driver
+	pool := n.ipamPool
+	poolV6 := n.ipamPoolV6
 	n.Unlock()
 
+	ep.Lock()
+	replaced := ep.replaced
+	ep.replaced = nil
+	restored := len(ep.iFaces) != 0
+	ep.Unlock()
+
 	defer func() {
 		if err != nil {
 			n.Lock()
@@ -285,38 +799,303 @@ func (n *network) addEndpoint(ep *endpoint) error {
 		}
 	}()
 
+	// Endpoints on networks backed by ipam pools are handed to the driver
+	// with their interface already populated with an address from each pool.
+	// Endpoints created without address get an interface with no address.
+	switch {
+	case replaced != nil:
+		// The replaced endpoint hands its addresses over, they stay reserved in the pools
+		var addr, addrv6 net.IPNet
+		replaced.Lock()
+		if len(replaced.iFaces) != 0 {
+			addr = replaced.iFaces[0].Address()
+			addrv6 = replaced.iFaces[0].AddressIPv6()
+		}
+		replaced.Unlock()
+		if err = ep.AddInterface(ifaceID, ep.electMacAddress(addr.IP), addr, addrv6); err != nil {
+			return err
+		}
+	case restored:
+		// The endpoint comes from the datastore, its addresses are still reserved in the pools
+	case ep.noAddress:
+		if err = ep.AddInterface(ifaceID, nil, net.IPNet{}, net.IPNet{}); err != nil {
+			return err
+		}
+	case pool != "" || poolV6 != "":
+		var addr, addrv6 net.IPNet
+		if pool != "" {
+			var a *net.IPNet
+			if a, err = n.requestPoolAddress(pool); err != nil {
+				return types.InternalErrorf("failed to allocate an address from ipam pool %s for endpoint %s: %v", pool, ep.Name(), err)
+			}
+			defer func() {
+				if err != nil {
+					n.ctrlr.releasePoolAddress(pool, a.IP)
+				}
+			}()
+			addr = *a
+		}
+		if poolV6 != "" {
+			var a *net.IPNet
+			if a, err = n.ctrlr.requestPoolAddress(poolV6, nil); err != nil {
+				return types.InternalErrorf("failed to allocate an IPv6 address from ipam pool %s for endpoint %s: %v", poolV6, ep.Name(), err)
+			}
+			defer func() {
+				if err != nil {
+					n.ctrlr.releasePoolAddress(poolV6, a.IP)
+				}
+			}()
+			addrv6 = *a
+		}
+		if err = ep.AddInterface(ifaceID, ep.electMacAddress(addr.IP), addr, addrv6); err != nil {
+			return err
+		}
+	}
+
 	err = d.CreateEndpoint(n.id, ep.id, ep, ep.generic)
 	if err != nil {
+		if _, ok := err.(*driverapi.DriverError); ok {
+			return mapDriverError(err)
+		}
 		return types.InternalErrorf("failed to create endpoint %s on network %s: %v", ep.Name(), n.Name(), err)
 	}
 
 	n.updateSvcRecord(ep, true)
+	ep.startProbe()
 	return nil
 }
 
+// requestPoolAddress reserves an address from the IPv4 ipam pool of the network,
+// expanding the pool first if it is exhausted and the network policy says so
+func (n *network) requestPoolAddress(pool string) (*net.IPNet, error) {
+	a, err := n.ctrlr.requestPoolAddress(pool, nil)
+
+	n.Lock()
+	policy := n.exhaustion
+	expansion := n.expansion
+	n.Unlock()
+
+	if err != ipam.ErrNoAvailableIPs || policy != netlabel.ExhaustionExpand {
+		return a, err
+	}
+
+	log.Infof("ipam pool %s exhausted, expanding it with subnet %s for network %s", pool, expansion, n.Name())
+	if err := n.ctrlr.expandIpamPool(pool, expansion); err != nil {
+		return nil, err
+	}
+	return n.ctrlr.requestPoolAddress(pool, nil)
+}
+
+func (n *network) Update(generic map[string]interface{}) error {
+	n.Lock()
+	d := n.driver
+	id := n.id
+	ctrlr := n.ctrlr
+	merged, err := mergeGenericOptions(n.generic, generic)
+	n.Unlock()
+
+	ud, ok := d.(driverapi.NetworkUpdateDriver)
+	if !ok {
+		return types.NotImplementedErrorf("driver %s cannot update network %s", d.Type(), n.Name())
+	}
+	// An update which cannot be recorded is not applied either
+	if err != nil {
+		return err
+	}
+	if err := ud.UpdateNetwork(id, generic); err != nil {
+		return mapDriverError(err)
+	}
+
+	// Record the update, the network is replayed with it to a reloaded driver
+	n.Lock()
+	n.generic = merged
+	n.Unlock()
+
+	return ctrlr.updateNetworkToStore(n)
+}
+
+// mergeGenericOptions returns the generic options overridden by the update. The
+// driver generic data are merged key by key when both are in the map format.
+// Stored data in a driver specific format cannot be merged into, the update is
+// then refused.
+func mergeGenericOptions(generic, update map[string]interface{}) (map[string]interface{}, error) {
+	merged := make(map[string]interface{}, len(generic)+len(update))
+	for k, v := range generic {
+		merged[k] = v
+	}
+	for k, v := range update {
+		if cur, ok := merged[k]; ok && k == netlabel.GenericData {
+			old, oldOk := genericMap(cur)
+			upd, updOk := genericMap(v)
+			if !oldOk {
+				return nil, types.BadRequestErrorf("cannot update the generic data of type %T, only generic data maps can be updated", cur)
+			}
+			if updOk {
+				data := make(options.Generic, len(old)+len(upd))
+				for dk, dv := range old {
+					data[dk] = dv
+				}
+				for dk, dv := range upd {
+					data[dk] = dv
+				}
+				merged[k] = data
+				continue
+			}
+		}
+		merged[k] = v
+	}
+	return merged, nil
+}
+
+func genericMap(v interface{}) (map[string]interface{}, bool) {
+	switch m := v.(type) {
+	case options.Generic:
+		return m, true
+	case map[string]interface{}:
+		return m, true
+	}
+	return nil, false
+}
+
 func (n *network) CreateEndpoint(name string, options ...EndpointOption) (Endpoint, error) {
+	return n.createEndpoint(name, nil, options...)
+}
+
+func (n *network) ReplaceEndpoint(old Endpoint, spec EndpointSpec) (Endpoint, error) {
+	oldEp, ok := old.(*endpoint)
+	if !ok || oldEp.getNetwork() != n {
+		return nil, types.BadRequestErrorf("endpoint %s does not belong to network %s", old.Name(), n.Name())
+	}
+
+	n.Lock()
+	pool := n.ipamPool
+	n.Unlock()
+
+	// Driver allocated addresses cannot be handed over
+	if pool == "" {
+		return nil, types.ForbiddenErrorf("endpoint %s address is not allocated from an ipam pool", old.Name())
+	}
+
+	oldEp.Lock()
+	name := oldEp.name
+	epid := oldEp.id
+	joined := oldEp.sandboxID != ""
+	dnsNames := append([]string(nil), oldEp.dnsNames...)
+	oldEp.Unlock()
+
+	if joined {
+		return nil, &ActiveContainerError{name: name, id: epid}
+	}
+	if spec.Name != "" {
+		name = spec.Name
+	}
+
+	// The new endpoint inherits the DNS names, unless the spec sets its own
+	options := append([]EndpointOption{CreateOptionDNSNames(dnsNames)}, spec.Options...)
+	ep, err := n.createEndpoint(name, oldEp, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	// The addresses now belong to the new endpoint
+	oldEp.Lock()
+	oldEp.keepAddress = true
+	oldEp.Unlock()
+	if err := oldEp.Delete(); err != nil {
+		oldEp.Lock()
+		oldEp.keepAddress = false
+		oldEp.Unlock()
+
+		newEp := ep.(*endpoint)
+		newEp.Lock()
+		newEp.keepAddress = true
+		newEp.Unlock()
+		if e := newEp.Delete(); e != nil {
+			log.Warnf("failed to delete endpoint %s after failing to replace endpoint %s: %v", ep.Name(), old.Name(), e)
+		}
+		return nil, err
+	}
+
+	return ep, nil
+}
+
+// createEndpoint creates an endpoint, which takes the addresses of the replaced
+// endpoint over if any
+func (n *network) createEndpoint(name string, replaced *endpoint, options ...EndpointOption) (Endpoint, error) {
 	var err error
 	if !config.IsValidName(name) {
 		return nil, ErrInvalidName(name)
 	}
 
-	if _, err = n.EndpointByName(name); err == nil {
-		return nil, types.ForbiddenErrorf("service endpoint with name %s already exists", name)
-	}
-
 	ep := &endpoint{name: name,
-		iFaces:  []*endpointInterface{},
-		generic: make(map[string]interface{})}
+		iFaces:   []*endpointInterface{},
+		generic:  make(map[string]interface{}),
+		origin:   EndpointOriginAPI,
+		replaced: replaced}
 	ep.id = stringid.GenerateRandomID()
 	ep.network = n
 	ep.processOptions(options...)
+	if err = validateDNSForward(ep.dnsForward); err != nil {
+		return nil, err
+	}
+	if err = n.resolvePortTarget(ep); err != nil {
+		return nil, err
+	}
+
+	if _, ok := ep.generic[netlabel.LazyLink]; ok {
+		n.Lock()
+		d := n.driver
+		n.Unlock()
+		if _, ok := d.(driverapi.EndpointLinkDriver); !ok {
+			return nil, types.NotImplementedErrorf("network %s driver %s cannot defer the creation of the endpoint interfaces", n.Name(), d.Type())
+		}
+	}
 
+	// Creation is idempotent for endpoints carrying an external key. The key
+	// and name lookups and the insert share the critical section, so that
+	// concurrent creations with the same key make a single endpoint. The
+	// later ones wait for the first to complete, and make the endpoint
+	// themselves should it fail.
 	n.Lock()
+	for {
+		e := n.endpointByExternalKey(ep.externalKey)
+		if e == nil {
+			break
+		}
+		n.Unlock()
+		e.waitCreated()
+		n.Lock()
+		if _, ok := n.endpoints[e.id]; ok {
+			n.Unlock()
+			return e, nil
+		}
+	}
+	for _, e := range n.endpoints {
+		if e.Name() == name && e != replaced {
+			n.Unlock()
+			return nil, types.ForbiddenErrorf("service endpoint with name %s already exists", name)
+		}
+	}
+	ep.createDone = make(chan struct{})
+	n.endpoints[ep.id] = ep
 	ctrlr := n.ctrlr
 	n.Unlock()
 
-	n.IncEndpointCnt()
+	// Deferred first to run last, after the failure cleanups
+	defer close(ep.createDone)
+	defer func() {
+		if err != nil {
+			n.Lock()
+			delete(n.endpoints, ep.id)
+			n.Unlock()
+		}
+	}()
+
+	if err = n.incEndpointCntChecked(); err != nil {
+		return nil, err
+	}
 	if err = ctrlr.updateNetworkToStore(n); err != nil {
+		n.DecEndpointCnt()
 		return nil, err
 	}
 	defer func() {
@@ -332,7 +1111,8 @@ func (n *network) CreateEndpoint(name string, options ...EndpointOption) (Endpoi
 	}
 	defer func() {
 		if err != nil {
-			if e := ep.Delete(); ep != nil {
+			// The endpoint never made it to the store, only the local state is cleaned up
+			if e := ep.deleteEndpoint(); e != nil {
 				log.Warnf("cleaning up endpoint failed %s : %v", name, e)
 			}
 		}
@@ -342,9 +1122,37 @@ func (n *network) CreateEndpoint(name string, options ...EndpointOption) (Endpoi
 		return nil, err
 	}
 
+	n.notifyMembership(MembershipEvent{Type: EndpointAdded, EndpointID: ep.id, EndpointName: name})
+
 	return ep, nil
 }
 
+// resolvePortTarget passes to the driver the address of the sibling endpoint
+// the port mappings of ep are forwarded to
+func (n *network) resolvePortTarget(ep *endpoint) error {
+	if ep.portTarget == "" {
+		return nil
+	}
+
+	e, err := n.EndpointByID(ep.portTarget)
+	if err != nil {
+		return types.BadRequestErrorf("port mapping target endpoint %s is not on network %s", ep.portTarget, n.Name())
+	}
+	target := e.(*endpoint)
+
+	var ip net.IP
+	target.Lock()
+	if len(target.iFaces) > 0 && target.iFaces[0].addr.IP.To4() != nil {
+		ip = types.GetIPCopy(target.iFaces[0].addr.IP)
+	}
+	target.Unlock()
+	if ip == nil {
+		return types.BadRequestErrorf("port mapping target endpoint %s has no IPv4 address", target.Name())
+	}
+	ep.generic[netlabel.PortMapTarget] = ip
+	return nil
+}
+
 func (n *network) Endpoints() []Endpoint {
 	n.Lock()
 	defer n.Unlock()
@@ -399,6 +1207,35 @@ func (n *network) EndpointByID(id string) (Endpoint, error) {
 	return nil, ErrNoSuchEndpoint(id)
 }
 
+// waitCreated returns once the creation of the endpoint completed, or failed
+func (ep *endpoint) waitCreated() {
+	ep.Lock()
+	done := ep.createDone
+	ep.Unlock()
+	if done != nil {
+		<-done
+	}
+}
+
+// endpointByExternalKey returns the endpoint carrying the external key, if any.
+// It must be called with the network lock held.
+func (n *network) endpointByExternalKey(key string) *endpoint {
+	if key == "" {
+		return nil
+	}
+
+	for _, e := range n.endpoints {
+		e.Lock()
+		eKey := e.externalKey
+		e.Unlock()
+		if eKey == key {
+			return e
+		}
+	}
+
+	return nil
+}
+
 func (n *network) isGlobalScoped() (bool, error) {
 	n.Lock()
 	c := n.ctrlr
@@ -407,29 +1244,86 @@ func (n *network) isGlobalScoped() (bool, error) {
 }
 
 func (n *network) updateSvcRecord(ep *endpoint, isAdd bool) {
+	// The records of an endpoint failing its health probe are already gone,
+	// they are restored once it recovers.
+	if !ep.isHealthy() {
+		return
+	}
+	n.programSvcRecord(ep, isAdd)
+}
+
+func (n *network) programSvcRecord(ep *endpoint, isAdd bool) {
+	// The endpoint is resolved with its name and its dns names
+	ep.Lock()
+	names := append([]string{ep.name}, ep.dnsNames...)
+	ep.Unlock()
+
 	n.Lock()
+	recs := n.svcRecordsFor(ep, names, isAdd)
+	n.Unlock()
+
+	n.updateHostsEntries(recs, isAdd)
+
+	if isAdd {
+		return
+	}
+
+	// Hand the names over to the other healthy endpoints sharing them
+	n.Lock()
+	var restored []etchosts.Record
+	for _, e := range n.endpoints {
+		if e == ep || !e.isHealthy() {
+			continue
+		}
+		e.Lock()
+		var shared []string
+		for _, en := range append([]string{e.name}, e.dnsNames...) {
+			for _, name := range names {
+				if en == name {
+					shared = append(shared, name)
+				}
+			}
+		}
+		e.Unlock()
+		restored = append(restored, n.svcRecordsFor(e, shared, true)...)
+	}
+	n.Unlock()
+
+	n.updateHostsEntries(restored, true)
+}
+
+// svcRecordsFor adds or removes the service records of the endpoint names and
+// returns the corresponding hosts entries. Network lock must be held.
+func (n *network) svcRecordsFor(ep *endpoint, names []string, isAdd bool) []etchosts.Record {
 	var recs []etchosts.Record
 	for _, iface := range ep.InterfaceList() {
-		if isAdd {
-			n.svcRecords[ep.Name()] = iface.Address().IP
-			n.svcRecords[ep.Name()+"."+n.name] = iface.Address().IP
-		} else {
-			delete(n.svcRecords, ep.Name())
-			delete(n.svcRecords, ep.Name()+"."+n.name)
-		}
+		for _, name := range names {
+			if isAdd {
+				n.svcRecords[name] = iface.Address().IP
+				n.svcRecords[name+"."+n.name] = iface.Address().IP
+			} else {
+				delete(n.svcRecords, name)
+				delete(n.svcRecords, name+"."+n.name)
+			}
 
-		recs = append(recs, etchosts.Record{
-			Hosts: ep.Name(),
-			IP:    iface.Address().IP.String(),
-		})
+			recs = append(recs, etchosts.Record{
+				Hosts: name,
+				IP:    iface.Address().IP.String(),
+			})
 
-		recs = append(recs, etchosts.Record{
-			Hosts: ep.Name() + "." + n.name,
-			IP:    iface.Address().IP.String(),
-		})
+			recs = append(recs, etchosts.Record{
+				Hosts: name + "." + n.name,
+				IP:    iface.Address().IP.String(),
+			})
+		}
 	}
-	n.Unlock()
 
+	return recs
+}
+
+// updateHostsEntries adds or removes the records in the hosts file of the
+// sandboxes joined to the network
+func (n *network) updateHostsEntries(recs []etchosts.Record, isAdd bool) {
 	// If there are no records to add or delete then simply return here
 	if len(recs) == 0 {
 		return
@@ -467,6 +1361,20 @@ func (n *network) getSvcRecords() []etchosts.Record {
 	return recs
 }
 
+func (n *network) getDNSForward() map[string][]string {
+	n.Lock()
+	defer n.Unlock()
+
+	return n.dnsForward
+}
+
+func (n *network) getDomain() string {
+	n.Lock()
+	defer n.Unlock()
+
+	return n.domain
+}
+
 func (n *network) getController() *controller {
 	n.Lock()
 	defer n.Unlock()
@@ -0,0 +1,89 @@
+package libnetwork
+
+import (
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/types"
+)
+
+// FDBEntry is an entry of the forwarding database of a network device: the
+// frames to MacAddress are sent out of Interface
+type FDBEntry struct {
+	MacAddress string `json:"mac_address"`
+	Interface  string `json:"interface"`
+	// Permanent is set for the entries not learned from the traffic
+	Permanent bool `json:"permanent,omitempty"`
+}
+
+// NeighborEntry is an entry of the host neighbor cache on a network device
+type NeighborEntry struct {
+	IP         string `json:"ip"`
+	MacAddress string `json:"mac_address,omitempty"`
+	Interface  string `json:"interface"`
+	State      string `json:"state"`
+}
+
+func (n *network) tablesDriver() (driverapi.NetworkTablesDriver, string, error) {
+	n.Lock()
+	d := n.driver
+	id := n.id
+	n.Unlock()
+
+	td, ok := d.(driverapi.NetworkTablesDriver)
+	if !ok {
+		return nil, "", types.NotImplementedErrorf("driver %s does not report its forwarding tables", d.Type())
+	}
+	return td, id, nil
+}
+
+func (n *network) FDB() ([]FDBEntry, error) {
+	td, id, err := n.tablesDriver()
+	if err != nil {
+		return nil, err
+	}
+
+	dfdb, err := td.NetworkFDB(id)
+	if err != nil {
+		return nil, err
+	}
+
+	fdb := []FDBEntry{}
+	for _, de := range dfdb {
+		fdb = append(fdb, FDBEntry{MacAddress: de.MacAddress.String(), Interface: de.Interface, Permanent: de.Permanent})
+	}
+	return fdb, nil
+}
+
+func (n *network) Neighbors() ([]NeighborEntry, error) {
+	td, id, err := n.tablesDriver()
+	if err != nil {
+		return nil, err
+	}
+
+	dnl, err := td.NetworkNeighbors(id)
+	if err != nil {
+		return nil, err
+	}
+
+	nl := []NeighborEntry{}
+	for _, de := range dnl {
+		ne := NeighborEntry{IP: de.IP.String(), Interface: de.Interface, State: de.State}
+		if len(de.MacAddress) != 0 {
+			ne.MacAddress = de.MacAddress.String()
+		}
+		nl = append(nl, ne)
+	}
+	return nl, nil
+}
+
+func (n *network) FirewallRules() ([]string, error) {
+	n.Lock()
+	d := n.driver
+	id := n.id
+	n.Unlock()
+
+	fd, ok := d.(driverapi.NetworkFirewallDriver)
+	if !ok {
+		return nil, types.NotImplementedErrorf("driver %s does not report its firewall rules", d.Type())
+	}
+	return fd.NetworkFirewallRules(id)
+}
@@ -6,9 +6,11 @@ import (
 	"os/exec"
 	"regexp"
 	"sync"
+	"syscall"
 
 	"github.com/docker/libnetwork/types"
 	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
 )
 
 // IfaceOption is a function option type to set interface options
@@ -23,6 +25,9 @@ type nwIface struct {
 	addressIPv6 *net.IPNet
 	routes      []*net.IPNet
 	bridge      bool
+	promisc     bool
+	macAddress  net.HardwareAddr
+	primary     bool
 	ns          *networkNamespace
 	sync.Mutex
 }
@@ -76,6 +81,20 @@ func (i *nwIface) AddressIPv6() *net.IPNet {
 	return types.GetIPNetCopy(i.addressIPv6)
 }
 
+func (i *nwIface) Promiscuous() bool {
+	i.Lock()
+	defer i.Unlock()
+
+	return i.promisc
+}
+
+func (i *nwIface) MacAddress() net.HardwareAddr {
+	i.Lock()
+	defer i.Unlock()
+
+	return types.GetMacCopy(i.macAddress)
+}
+
 func (i *nwIface) Routes() []*net.IPNet {
 	i.Lock()
 	defer i.Unlock()
@@ -107,11 +126,14 @@ func (i *nwIface) Remove() error {
 	n := i.ns
 	i.Unlock()
 
+	// Stop listing the interface while it is torn down, so that it is not
+	// handed out half removed
 	n.Lock()
 	path := n.path
+	listed := n.unlistInterface(i)
 	n.Unlock()
 
-	return nsInvoke(path, func(nsFD int) error { return nil }, func(callerFD int) error {
+	err := nsInvoke(path, func(nsFD int) error { return nil }, func(callerFD int) error {
 		// Find the network inteerface identified by the DstName attribute.
 		iface, err := netlink.LinkByName(i.DstName())
 		if err != nil {
@@ -123,6 +145,13 @@ func (i *nwIface) Remove() error {
 			return err
 		}
 
+		// The interface leaves the sandbox the way it came in
+		if i.Promiscuous() {
+			if err := setLinkPromisc(iface, false); err != nil {
+				return fmt.Errorf("failed to clear promiscuous mode on %q: %v", i.DstName(), err)
+			}
+		}
+
 		err = netlink.LinkSetName(iface, i.SrcName())
 		if err != nil {
 			fmt.Println("LinkSetName failed: ", err)
@@ -142,17 +171,110 @@ func (i *nwIface) Remove() error {
 			}
 		}
 
+		return nil
+	})
+	if err != nil && listed {
 		n.Lock()
-		for index, intf := range n.iFaces {
-			if intf == i {
-				n.iFaces = append(n.iFaces[:index], n.iFaces[index+1:]...)
-				break
-			}
-		}
+		n.iFaces = append(n.iFaces, i)
 		n.Unlock()
+	}
 
-		return nil
+	return err
+}
+
+// unlistInterface removes the interface from the namespace interfaces and
+// returns whether it was listed. The namespace lock must be held.
+func (n *networkNamespace) unlistInterface(i *nwIface) bool {
+	for index, intf := range n.iFaces {
+		if intf == i {
+			n.iFaces = append(n.iFaces[:index], n.iFaces[index+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (i *nwIface) SetAddress(addr *net.IPNet) error {
+	i.Lock()
+	n := i.ns
+	i.Unlock()
+
+	n.Lock()
+	path := n.path
+	n.Unlock()
+
+	err := nsInvoke(path, func(nsFD int) error { return nil }, func(callerFD int) error {
+		iface, err := netlink.LinkByName(i.DstName())
+		if err != nil {
+			return err
+		}
+		return netlink.AddrAdd(iface, &netlink.Addr{IPNet: addr})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set address %s on %s in netns %s: %v", addr, i.DstName(), path, err)
+	}
+
+	i.Lock()
+	i.address = types.GetIPNetCopy(addr)
+	i.Unlock()
+
+	return nil
+}
+
+func (i *nwIface) SetMacAddress(mac net.HardwareAddr) error {
+	i.Lock()
+	n := i.ns
+	i.Unlock()
+
+	n.Lock()
+	path := n.path
+	n.Unlock()
+
+	err := nsInvoke(path, func(nsFD int) error { return nil }, func(callerFD int) error {
+		iface, err := netlink.LinkByName(i.DstName())
+		if err != nil {
+			return err
+		}
+		return netlink.LinkSetHardwareAddr(iface, mac)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set mac address %s on %s in netns %s: %v", mac, i.DstName(), path, err)
+	}
+
+	i.Lock()
+	i.macAddress = types.GetMacCopy(mac)
+	i.Unlock()
+
+	return nil
+}
+
+func (i *nwIface) RemoveAddress(addr *net.IPNet) error {
+	i.Lock()
+	n := i.ns
+	i.Unlock()
+
+	n.Lock()
+	path := n.path
+	n.Unlock()
+
+	err := nsInvoke(path, func(nsFD int) error { return nil }, func(callerFD int) error {
+		iface, err := netlink.LinkByName(i.DstName())
+		if err != nil {
+			return err
+		}
+		return netlink.AddrDel(iface, &netlink.Addr{IPNet: addr})
 	})
+	if err != nil {
+		return fmt.Errorf("failed to remove address %s from %s in netns %s: %v", addr, i.DstName(), path, err)
+	}
+
+	i.Lock()
+	if i.address != nil && i.address.IP.Equal(addr.IP) {
+		i.address = nil
+	}
+	i.Unlock()
+
+	return nil
 }
 
 // Returns the sandbox's side veth interface statistics
@@ -212,12 +334,36 @@ func (n *networkNamespace) AddInterface(srcName, dstPrefix string, options ...If
 		}
 	}
 
+	var displaced *nwIface
 	n.Lock()
-	i.dstName = fmt.Sprintf("%s%d", i.dstName, n.nextIfIndex)
-	n.nextIfIndex++
+	if n.path != "" {
+		i.dstName = fmt.Sprintf("%s%d", i.dstName, n.nextIfIndex)
+		n.nextIfIndex++
+		// A primary interface takes the first name of its prefix over from a
+		// secondary one, which gets the name allocated to the primary instead
+		if i.primary {
+			first := dstPrefix + "0"
+			for _, intf := range n.iFaces {
+				if intf.dstName == first && !intf.primary {
+					displaced = intf
+				}
+			}
+		}
+	} else {
+		// Sharing the host namespace, the interface keeps its unique name
+		i.dstName = i.srcName
+	}
 	path := n.path
 	n.Unlock()
 
+	if displaced != nil {
+		name := i.dstName
+		i.dstName = displaced.DstName()
+		if err := n.renameInterface(displaced, name); err != nil {
+			return err
+		}
+	}
+
 	return nsInvoke(path, func(nsFD int) error {
 		// If it is a bridge interface we have to create the bridge inside
 		// the namespace so don't try to lookup the interface using srcName
@@ -279,6 +425,60 @@ func (n *networkNamespace) AddInterface(srcName, dstPrefix string, options ...If
 	})
 }
 
+// renameInterface renames an interface of the namespace. The interface is down
+// for the time of the rename, the routes through it are programmed again.
+func (n *networkNamespace) renameInterface(i *nwIface, dstName string) error {
+	path := n.nsPath()
+	err := nsInvoke(path, func(nsFD int) error { return nil }, func(callerFD int) error {
+		iface, err := netlink.LinkByName(i.DstName())
+		if err != nil {
+			return err
+		}
+		if err := netlink.LinkSetDown(iface); err != nil {
+			return err
+		}
+		if err := netlink.LinkSetName(iface, dstName); err != nil {
+			return err
+		}
+		if err := netlink.LinkSetUp(iface); err != nil {
+			return err
+		}
+		return setInterfaceRoutes(iface, i)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to rename interface %s to %s in netns %s: %v", i.DstName(), dstName, path, err)
+	}
+
+	i.Lock()
+	i.dstName = dstName
+	i.Unlock()
+
+	// Taking the link down flushed the gateway and static routes through it
+	onLink := func(ip net.IP) bool {
+		for _, a := range []*net.IPNet{i.Address(), i.AddressIPv6()} {
+			if a != nil && a.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, gw := range []net.IP{n.Gateway(), n.GatewayIPv6()} {
+		if len(gw) != 0 && onLink(gw) {
+			if err := programGateway(path, gw, true); err != nil {
+				return err
+			}
+		}
+	}
+	for _, r := range n.StaticRoutes() {
+		if onLink(r.NextHop) {
+			if err := programRoute(path, r.Destination, r.NextHop, r.Metric, r.Table); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func configureInterface(iface netlink.Link, i *nwIface) error {
 	ifaceName := iface.Attrs().Name
 	ifaceConfigurators := []struct {
@@ -286,10 +486,12 @@ func configureInterface(iface netlink.Link, i *nwIface) error {
 		ErrMessage string
 	}{
 		{setInterfaceName, fmt.Sprintf("error renaming interface %q to %q", ifaceName, i.DstName())},
+		{setInterfaceMacAddress, fmt.Sprintf("error setting interface %q mac address to %q", ifaceName, i.MacAddress())},
 		{setInterfaceIP, fmt.Sprintf("error setting interface %q IP to %q", ifaceName, i.Address())},
 		{setInterfaceIPv6, fmt.Sprintf("error setting interface %q IPv6 to %q", ifaceName, i.AddressIPv6())},
 		{setInterfaceRoutes, fmt.Sprintf("error setting interface %q routes to %q", ifaceName, i.Routes())},
 		{setInterfaceMaster, fmt.Sprintf("error setting interface %q master to %q", ifaceName, i.DstMaster())},
+		{setInterfacePromisc, fmt.Sprintf("error setting interface %q promiscuous mode", ifaceName)},
 	}
 
 	for _, config := range ifaceConfigurators {
@@ -309,6 +511,38 @@ func setInterfaceMaster(iface netlink.Link, i *nwIface) error {
 		LinkAttrs: netlink.LinkAttrs{Name: i.DstMaster()}})
 }
 
+func setInterfacePromisc(iface netlink.Link, i *nwIface) error {
+	if !i.Promiscuous() {
+		return nil
+	}
+
+	return setLinkPromisc(iface, true)
+}
+
+// setLinkPromisc turns the promiscuous mode of the link on or off
+func setLinkPromisc(iface netlink.Link, on bool) error {
+	req := nl.NewNetlinkRequest(syscall.RTM_NEWLINK, syscall.NLM_F_ACK)
+
+	msg := nl.NewIfInfomsg(syscall.AF_UNSPEC)
+	msg.Change = syscall.IFF_PROMISC
+	if on {
+		msg.Flags = syscall.IFF_PROMISC
+	}
+	msg.Index = int32(iface.Attrs().Index)
+	req.AddData(msg)
+
+	_, err := req.Execute(syscall.NETLINK_ROUTE, 0)
+	return err
+}
+
+func setInterfaceMacAddress(iface netlink.Link, i *nwIface) error {
+	if len(i.MacAddress()) == 0 {
+		return nil
+	}
+
+	return netlink.LinkSetHardwareAddr(iface, i.MacAddress())
+}
+
 func setInterfaceIP(iface netlink.Link, i *nwIface) error {
 	if i.Address() == nil {
 		return nil
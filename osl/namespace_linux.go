@@ -19,6 +19,12 @@ import (
 
 const prefix = "/var/run/docker/netns"
 
+// Filesystem types of a namespace file bind mount, depending on the kernel version
+const (
+	nsfsMagic = 0x6e736673
+	procMagic = 0x9fa0
+)
+
 var (
 	once             sync.Once
 	garbagePathMap   = make(map[string]bool)
@@ -26,13 +32,15 @@ var (
 	gpmWg            sync.WaitGroup
 	gpmCleanupPeriod = 60 * time.Second
 	gpmChan          = make(chan chan struct{})
+	gpmPauses        int
 )
 
 // The networkNamespace type is the linux implementation of the Sandbox
 // interface. It represents a linux network namespace, and moves an interface
 // into it when called on method AddInterface or sets the gateway etc.
 type networkNamespace struct {
-	path         string
+	key          string
+	path         string // empty for the sandboxes of the host backend
 	iFaces       []*nwIface
 	gw           net.IP
 	gwv6         net.IP
@@ -74,6 +82,11 @@ func removeUnusedPaths() {
 		}
 
 		gpmLock.Lock()
+		// The paths stay in the map while paused, the first tick after resuming removes them
+		if gpmPauses > 0 && !gcOk {
+			gpmLock.Unlock()
+			continue
+		}
 		pathList := make([]string, 0, len(garbagePathMap))
 		for path := range garbagePathMap {
 			pathList = append(pathList, path)
@@ -125,6 +138,37 @@ func GC() {
 	<-waitGC
 }
 
+// PauseGC suspends the periodic garbage collection of namespace paths until
+// ResumeGC is called as many times. GC still collects right away when called.
+func PauseGC() {
+	gpmLock.Lock()
+	gpmPauses++
+	gpmLock.Unlock()
+}
+
+// ResumeGC resumes the periodic garbage collection suspended by PauseGC
+func ResumeGC() {
+	gpmLock.Lock()
+	if gpmPauses > 0 {
+		gpmPauses--
+	}
+	gpmLock.Unlock()
+}
+
+// NamespaceID returns the inode of the network namespace bind mounted at the
+// sandbox key, the identifier the kernel reports the namespace with
+func NamespaceID(key string) (uint64, error) {
+	fi, err := os.Stat(key)
+	if err != nil {
+		return 0, err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, fmt.Errorf("failed to read the inode of namespace %s", key)
+	}
+	return st.Ino, nil
+}
+
 // GenerateKey generates a sandbox key based on the passed
 // container id.
 func GenerateKey(containerID string) string {
@@ -144,7 +188,28 @@ func NewSandbox(key string, osCreate bool) (Sandbox, error) {
 		return nil, err
 	}
 
-	return &networkNamespace{path: key}, nil
+	return &networkNamespace{key: key, path: key}, nil
+}
+
+// RestoreSandbox returns the sandbox of the network namespace created before
+// under the passed key, as long as the namespace file is still bound to it
+func RestoreSandbox(key string) (Sandbox, error) {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(key, &fs); err != nil {
+		return nil, err
+	}
+	if fs.Type != nsfsMagic && fs.Type != procMagic {
+		return nil, fmt.Errorf("%s is not bound to a network namespace", key)
+	}
+
+	removeFromGarbagePaths(key)
+	return &networkNamespace{key: key, path: key}, nil
+}
+
+// newHostSandbox returns a sandbox of the host backend, which has no namespace
+// file and invokes its operations in the caller network namespace
+func newHostSandbox(key string, osCreate bool) (Sandbox, error) {
+	return &networkNamespace{key: key}, nil
 }
 
 func (n *networkNamespace) InterfaceOptions() IfaceOptionSetter {
@@ -252,6 +317,14 @@ func nsInvoke(path string, prefunc func(nsFD int) error, postfunc func(callerFD
 	}
 	defer origns.Close()
 
+	// Host backend sandboxes live in the caller namespace
+	if path == "" {
+		if err := prefunc(int(origns)); err != nil {
+			return fmt.Errorf("failed in prefunc: %v", err)
+		}
+		return postfunc(int(origns))
+	}
+
 	f, err := os.OpenFile(path, os.O_RDONLY, 0)
 	if err != nil {
 		return fmt.Errorf("failed get network namespace %q: %v", path, err)
@@ -287,10 +360,19 @@ func (n *networkNamespace) Info() Info {
 }
 
 func (n *networkNamespace) Key() string {
-	return n.path
+	return n.key
+}
+
+// isHost returns whether the sandbox is of the host backend
+func (n *networkNamespace) isHost() bool {
+	return n.nsPath() == ""
 }
 
 func (n *networkNamespace) Destroy() error {
+	if n.isHost() {
+		return nil
+	}
+
 	// Assuming no running process is executing in this network namespace,
 	// unmounting is sufficient to destroy it.
 	if err := syscall.Unmount(n.path, syscall.MNT_DETACH); err != nil {
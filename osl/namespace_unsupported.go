@@ -2,7 +2,22 @@
 
 package osl
 
+import "github.com/docker/libnetwork/types"
+
 // GC triggers garbage collection of namespace path right away
 // and waits for it.
 func GC() {
 }
+
+// PauseGC suspends the periodic garbage collection of namespace paths
+func PauseGC() {
+}
+
+// ResumeGC resumes the periodic garbage collection suspended by PauseGC
+func ResumeGC() {
+}
+
+// NamespaceID returns the identifier of the network namespace of the sandbox key
+func NamespaceID(key string) (uint64, error) {
+	return 0, types.NotImplementedErrorf("namespace identifiers are not supported on this platform")
+}
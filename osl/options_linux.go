@@ -59,3 +59,21 @@ func (n *networkNamespace) Routes(routes []*net.IPNet) IfaceOption {
 		i.routes = routes
 	}
 }
+
+func (n *networkNamespace) Promiscuous(promisc bool) IfaceOption {
+	return func(i *nwIface) {
+		i.promisc = promisc
+	}
+}
+
+func (n *networkNamespace) Primary(primary bool) IfaceOption {
+	return func(i *nwIface) {
+		i.primary = primary
+	}
+}
+
+func (n *networkNamespace) MacAddress(mac net.HardwareAddr) IfaceOption {
+	return func(i *nwIface) {
+		i.macAddress = mac
+	}
+}
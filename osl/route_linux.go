@@ -3,9 +3,11 @@ package osl
 import (
 	"fmt"
 	"net"
+	"syscall"
 
 	"github.com/docker/libnetwork/types"
 	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
 )
 
 func (n *networkNamespace) Gateway() net.IP {
@@ -48,8 +50,8 @@ func (n *networkNamespace) setGatewayIPv6(gwv6 net.IP) {
 }
 
 func (n *networkNamespace) SetGateway(gw net.IP) error {
-	// Silently return if the gateway is empty
-	if len(gw) == 0 {
+	// Silently return if the gateway is empty, or if the routing is the host one
+	if n.isHost() || len(gw) == 0 {
 		return nil
 	}
 
@@ -100,43 +102,103 @@ func programGateway(path string, gw net.IP, isAdd bool) error {
 	})
 }
 
-// Program a route in to the namespace routing table.
-func programRoute(path string, dest *net.IPNet, nh net.IP) error {
+// Program a route in to the namespace routing table, main if table is 0.
+func programRoute(path string, dest *net.IPNet, nh net.IP, metric, table int) error {
 	return nsInvoke(path, func(nsFD int) error { return nil }, func(callerFD int) error {
 		gwRoutes, err := netlink.RouteGet(nh)
 		if err != nil {
 			return fmt.Errorf("route for the next hop %s could not be found: %v", nh, err)
 		}
 
-		return netlink.RouteAdd(&netlink.Route{
+		route := &netlink.Route{
 			Scope:     netlink.SCOPE_UNIVERSE,
 			LinkIndex: gwRoutes[0].LinkIndex,
 			Gw:        nh,
 			Dst:       dest,
-		})
+		}
+		if metric != 0 || table != 0 {
+			return routeWithAttrs(route, metric, table, true)
+		}
+		return netlink.RouteAdd(route)
 	})
 }
 
-// Delete a route from the namespace routing table.
-func removeRoute(path string, dest *net.IPNet, nh net.IP) error {
+// Delete a route from the namespace routing table, main if table is 0.
+func removeRoute(path string, dest *net.IPNet, nh net.IP, metric, table int) error {
 	return nsInvoke(path, func(nsFD int) error { return nil }, func(callerFD int) error {
 		gwRoutes, err := netlink.RouteGet(nh)
 		if err != nil {
 			return fmt.Errorf("route for the next hop could not be found: %v", err)
 		}
 
-		return netlink.RouteDel(&netlink.Route{
+		route := &netlink.Route{
 			Scope:     netlink.SCOPE_UNIVERSE,
 			LinkIndex: gwRoutes[0].LinkIndex,
 			Gw:        nh,
 			Dst:       dest,
-		})
+		}
+		if metric != 0 || table != 0 {
+			return routeWithAttrs(route, metric, table, false)
+		}
+		return netlink.RouteDel(route)
 	})
 }
 
+// routeWithAttrs adds or deletes a next hop route carrying a metric, or going
+// in a routing table other than main. The vendored netlink Route has neither
+// priority nor table field, so the request is built here.
+func routeWithAttrs(route *netlink.Route, metric, table int, add bool) error {
+	var (
+		req *nl.NetlinkRequest
+		msg *nl.RtMsg
+	)
+	if add {
+		req = nl.NewNetlinkRequest(syscall.RTM_NEWROUTE, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL|syscall.NLM_F_ACK)
+		msg = nl.NewRtMsg()
+	} else {
+		req = nl.NewNetlinkRequest(syscall.RTM_DELROUTE, syscall.NLM_F_ACK)
+		msg = nl.NewRtDelMsg()
+	}
+	msg.Scope = uint8(route.Scope)
+
+	family := nl.GetIPFamily(route.Gw)
+	msg.Family = uint8(family)
+	ipData := func(ip net.IP) []byte {
+		if family == netlink.FAMILY_V4 {
+			return ip.To4()
+		}
+		return ip.To16()
+	}
+
+	var rtAttrs []*nl.RtAttr
+	if route.Dst != nil && route.Dst.IP != nil {
+		dstLen, _ := route.Dst.Mask.Size()
+		msg.Dst_len = uint8(dstLen)
+		rtAttrs = append(rtAttrs, nl.NewRtAttr(syscall.RTA_DST, ipData(route.Dst.IP)))
+	}
+	rtAttrs = append(rtAttrs, nl.NewRtAttr(syscall.RTA_GATEWAY, ipData(route.Gw)))
+
+	if metric != 0 {
+		rtAttrs = append(rtAttrs, uint32Attr(syscall.RTA_PRIORITY, uint32(metric)))
+	}
+	if table != 0 {
+		msg.Table = tableMsgID(table)
+		rtAttrs = append(rtAttrs, uint32Attr(syscall.RTA_TABLE, uint32(table)))
+	}
+	rtAttrs = append(rtAttrs, uint32Attr(syscall.RTA_OIF, uint32(route.LinkIndex)))
+
+	req.AddData(msg)
+	for _, attr := range rtAttrs {
+		req.AddData(attr)
+	}
+
+	_, err := req.Execute(syscall.NETLINK_ROUTE, 0)
+	return err
+}
+
 func (n *networkNamespace) SetGatewayIPv6(gwv6 net.IP) error {
-	// Silently return if the gateway is empty
-	if len(gwv6) == 0 {
+	// Silently return if the gateway is empty, or if the routing is the host one
+	if n.isHost() || len(gwv6) == 0 {
 		return nil
 	}
 
@@ -167,7 +229,10 @@ func (n *networkNamespace) UnsetGatewayIPv6() error {
 }
 
 func (n *networkNamespace) AddStaticRoute(r *types.StaticRoute) error {
-	err := programRoute(n.nsPath(), r.Destination, r.NextHop)
+	if n.isHost() {
+		return nil
+	}
+	err := programRoute(n.nsPath(), r.Destination, r.NextHop, r.Metric, r.Table)
 	if err == nil {
 		n.Lock()
 		n.staticRoutes = append(n.staticRoutes, r)
@@ -177,8 +242,11 @@ func (n *networkNamespace) AddStaticRoute(r *types.StaticRoute) error {
 }
 
 func (n *networkNamespace) RemoveStaticRoute(r *types.StaticRoute) error {
+	if n.isHost() {
+		return nil
+	}
 
-	err := removeRoute(n.nsPath(), r.Destination, r.NextHop)
+	err := removeRoute(n.nsPath(), r.Destination, r.NextHop, r.Metric, r.Table)
 	if err == nil {
 		n.Lock()
 		lastIndex := len(n.staticRoutes) - 1
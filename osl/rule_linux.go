@@ -0,0 +1,184 @@
+package osl
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+)
+
+// The vendored netlink has no policy rules support, nor routing tables other
+// than main. These are the bits of the kernel fib rules api needed here.
+const (
+	fraSrc     = 2  // FRA_SRC: source address attribute
+	fraTable   = 15 // FRA_TABLE: 32 bits table id attribute
+	frActToTbl = 1  // FR_ACT_TO_TBL: lookup the table action
+)
+
+func validRoutingTable(table int) error {
+	switch {
+	case table <= 0:
+		return fmt.Errorf("invalid routing table %d", table)
+	case table == syscall.RT_TABLE_DEFAULT || table == syscall.RT_TABLE_MAIN || table == syscall.RT_TABLE_LOCAL:
+		return fmt.Errorf("routing table %d is reserved", table)
+	}
+	return nil
+}
+
+// tableMsgID returns the table id to carry in the message header. Ids which
+// do not fit go in the table attribute only.
+func tableMsgID(table int) uint8 {
+	if table > 255 {
+		return syscall.RT_TABLE_UNSPEC
+	}
+	return uint8(table)
+}
+
+func uint32Attr(attrType int, v uint32) *nl.RtAttr {
+	b := make([]byte, 4)
+	nl.NativeEndian().PutUint32(b, v)
+	return nl.NewRtAttr(attrType, b)
+}
+
+// programTableRoutes adds or deletes the route to the source subnet and the
+// default route through the gateway in the routing table, in the namespace the
+// calling thread is in.
+func programTableRoutes(src *net.IPNet, gw net.IP, table int, add bool) error {
+	gwRoutes, err := netlink.RouteGet(gw)
+	if err != nil {
+		return fmt.Errorf("route for the gateway %s could not be found: %v", gw, err)
+	}
+	link := gwRoutes[0].LinkIndex
+
+	subnet := &net.IPNet{IP: src.IP.Mask(src.Mask), Mask: src.Mask}
+	if add {
+		if err := programTableRoute(subnet, nil, link, table, true); err != nil {
+			return err
+		}
+		if err := programTableRoute(nil, gw, link, table, true); err != nil {
+			programTableRoute(subnet, nil, link, table, false)
+			return err
+		}
+		return nil
+	}
+
+	// Remove as much as possible
+	sErr := programTableRoute(subnet, nil, link, table, false)
+	if err := programTableRoute(nil, gw, link, table, false); err != nil {
+		return err
+	}
+	return sErr
+}
+
+// programTableRoute adds or deletes the route to dst, or the default route if
+// nil, through the gateway, or directly connected if nil, in the routing table
+func programTableRoute(dst *net.IPNet, gw net.IP, link, table int, add bool) error {
+	var (
+		req *nl.NetlinkRequest
+		msg *nl.RtMsg
+	)
+	if add {
+		req = nl.NewNetlinkRequest(syscall.RTM_NEWROUTE, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL|syscall.NLM_F_ACK)
+		msg = nl.NewRtMsg()
+	} else {
+		req = nl.NewNetlinkRequest(syscall.RTM_DELROUTE, syscall.NLM_F_ACK)
+		msg = nl.NewRtDelMsg()
+	}
+	msg.Family = syscall.AF_INET
+	msg.Scope = syscall.RT_SCOPE_UNIVERSE
+	msg.Table = tableMsgID(table)
+	if dst != nil {
+		ones, _ := dst.Mask.Size()
+		msg.Dst_len = uint8(ones)
+	}
+	if gw == nil {
+		msg.Scope = syscall.RT_SCOPE_LINK
+	}
+
+	req.AddData(msg)
+	if dst != nil {
+		req.AddData(nl.NewRtAttr(syscall.RTA_DST, dst.IP.To4()))
+	}
+	if gw != nil {
+		req.AddData(nl.NewRtAttr(syscall.RTA_GATEWAY, gw.To4()))
+	}
+	req.AddData(uint32Attr(syscall.RTA_OIF, uint32(link)))
+	req.AddData(uint32Attr(syscall.RTA_TABLE, uint32(table)))
+
+	_, err := req.Execute(syscall.NETLINK_ROUTE, 0)
+	return err
+}
+
+// programSourceRule adds or deletes the policy rule having the traffic sourced
+// from the address lookup the routing table, in the namespace the calling
+// thread is in.
+func programSourceRule(src net.IP, table int, add bool) error {
+	var req *nl.NetlinkRequest
+	if add {
+		req = nl.NewNetlinkRequest(syscall.RTM_NEWRULE, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL|syscall.NLM_F_ACK)
+	} else {
+		req = nl.NewNetlinkRequest(syscall.RTM_DELRULE, syscall.NLM_F_ACK)
+	}
+
+	// The fib rule header has the rtmsg layout, the action in place of the type
+	msg := &nl.RtMsg{}
+	msg.Family = syscall.AF_INET
+	msg.Src_len = 32
+	msg.Table = tableMsgID(table)
+	msg.Type = frActToTbl
+
+	req.AddData(msg)
+	req.AddData(nl.NewRtAttr(fraSrc, src.To4()))
+	req.AddData(uint32Attr(fraTable, uint32(table)))
+
+	_, err := req.Execute(syscall.NETLINK_ROUTE, 0)
+	return err
+}
+
+func programSourceRoute(path string, src *net.IPNet, gw net.IP, table int, add bool) error {
+	if src == nil || src.IP.To4() == nil || gw.To4() == nil {
+		return fmt.Errorf("invalid source %v or gateway %v for routing table %d", src, gw, table)
+	}
+	if err := validRoutingTable(table); err != nil {
+		return err
+	}
+
+	return nsInvoke(path, func(nsFD int) error { return nil }, func(callerFD int) error {
+		if !add {
+			// Remove as much as possible
+			rErr := programSourceRule(src.IP, table, false)
+			if err := programTableRoutes(src, gw, table, false); err != nil {
+				return fmt.Errorf("failed to remove the routes of table %d: %v", table, err)
+			}
+			if rErr != nil {
+				return fmt.Errorf("failed to remove the rule for %s to table %d: %v", src, table, rErr)
+			}
+			return nil
+		}
+
+		if err := programTableRoutes(src, gw, table, true); err != nil {
+			return fmt.Errorf("failed to add the routes through %s to table %d: %v", gw, table, err)
+		}
+		if err := programSourceRule(src.IP, table, true); err != nil {
+			programTableRoutes(src, gw, table, false)
+			return fmt.Errorf("failed to add the rule for %s to table %d: %v", src, table, err)
+		}
+		return nil
+	})
+}
+
+func (n *networkNamespace) AddSourceRoute(src *net.IPNet, gw net.IP, table int) error {
+	if n.isHost() {
+		return nil
+	}
+	return programSourceRoute(n.nsPath(), src, gw, table, true)
+}
+
+func (n *networkNamespace) RemoveSourceRoute(src *net.IPNet, gw net.IP, table int) error {
+	if n.isHost() {
+		return nil
+	}
+	return programSourceRoute(n.nsPath(), src, gw, table, false)
+}
@@ -8,6 +8,39 @@ import (
 	"github.com/docker/libnetwork/types"
 )
 
+// Sandbox backends, selecting how the sandboxes are isolated from the host
+const (
+	// NamespaceBackend creates a network namespace per sandbox
+	NamespaceBackend = "namespace"
+	// HostBackend sets the sandbox interfaces up in the network namespace of
+	// the caller, where new network namespaces cannot be created. The host
+	// routing is left untouched: gateways and routes are not programmed.
+	HostBackend = "host"
+)
+
+// Backend creates the sandboxes
+type Backend interface {
+	// NewSandbox creates the sandbox identified by the key
+	NewSandbox(key string, osCreate bool) (Sandbox, error)
+}
+
+type backendFunc func(key string, osCreate bool) (Sandbox, error)
+
+func (f backendFunc) NewSandbox(key string, osCreate bool) (Sandbox, error) {
+	return f(key, osCreate)
+}
+
+// GetBackend returns the named sandbox backend, the namespace one if the name is empty
+func GetBackend(name string) (Backend, error) {
+	switch name {
+	case "", NamespaceBackend:
+		return backendFunc(NewSandbox), nil
+	case HostBackend:
+		return backendFunc(newHostSandbox), nil
+	}
+	return nil, fmt.Errorf("unknown sandbox backend %q", name)
+}
+
 // Sandbox represents a network sandbox, identified by a specific key.  It
 // holds a list of Interfaces, routes etc, and more can be added dynamically.
 type Sandbox interface {
@@ -39,6 +72,14 @@ type Sandbox interface {
 	// Remove a static route from the sandbox.
 	RemoveStaticRoute(*types.StaticRoute) error
 
+	// AddSourceRoute sets the route to the src subnet and the default route
+	// through gw in the routing table, and the policy rule having the traffic
+	// sourced from the src address lookup the table.
+	AddSourceRoute(src *net.IPNet, gw net.IP, table int) error
+
+	// RemoveSourceRoute removes the rule and routes set by AddSourceRoute.
+	RemoveSourceRoute(src *net.IPNet, gw net.IP, table int) error
+
 	// AddNeighbor adds a neighbor entry into the sandbox.
 	AddNeighbor(dstIP net.IP, dstMac net.HardwareAddr, option ...NeighOption) error
 
@@ -90,6 +131,16 @@ type IfaceOptionSetter interface {
 
 	// Address returns an option setter to set interface routes.
 	Routes([]*net.IPNet) IfaceOption
+
+	// Promiscuous returns an option setter to set the interface in promiscuous mode.
+	Promiscuous(bool) IfaceOption
+
+	// MacAddress returns an option setter to set the hardware address of the interface.
+	MacAddress(net.HardwareAddr) IfaceOption
+
+	// Primary returns an option setter to give the interface the first name of
+	// its prefix, renaming the secondary interface holding it.
+	Primary(bool) IfaceOption
 }
 
 // Info represents all possible information that
@@ -151,6 +202,16 @@ type Interface interface {
 
 	// Statistics returns the statistics for this interface
 	Statistics() (*InterfaceStatistics, error)
+
+	// SetAddress programs the IPv4 address of an interface which was added to
+	// the sandbox without one.
+	SetAddress(*net.IPNet) error
+
+	// RemoveAddress removes the passed IPv4 address from the interface.
+	RemoveAddress(*net.IPNet) error
+
+	// SetMacAddress changes the hardware address of the interface.
+	SetMacAddress(net.HardwareAddr) error
 }
 
 // InterfaceStatistics represents the interface's statistics
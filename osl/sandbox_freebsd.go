@@ -1,5 +1,7 @@
 package osl
 
+import "github.com/docker/libnetwork/types"
+
 // GenerateKey generates a sandbox key based on the passed
 // container id.
 func GenerateKey(containerID string) string {
@@ -17,7 +19,30 @@ func NewSandbox(key string, osCreate bool) (Sandbox, error) {
 	return nil, nil
 }
 
+// RestoreSandbox returns the sandbox of the network namespace created before
+// under the passed key
+func RestoreSandbox(key string) (Sandbox, error) {
+	return nil, nil
+}
+
+func newHostSandbox(key string, osCreate bool) (Sandbox, error) {
+	return nil, nil
+}
+
 // GC triggers garbage collection of namespace path right away
 // and waits for it.
 func GC() {
 }
+
+// PauseGC suspends the periodic garbage collection of namespace paths
+func PauseGC() {
+}
+
+// ResumeGC resumes the periodic garbage collection suspended by PauseGC
+func ResumeGC() {
+}
+
+// NamespaceID returns the identifier of the network namespace of the sandbox key
+func NamespaceID(key string) (uint64, error) {
+	return 0, types.NotImplementedErrorf("namespace identifiers are not supported on this platform")
+}
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/docker/libnetwork/netutils"
+	"github.com/docker/libnetwork/types"
 	"github.com/vishvananda/netlink"
 	"github.com/vishvananda/netns"
 )
@@ -178,3 +179,72 @@ func TestScanStatistics(t *testing.T) {
 		t.Fatalf("Error scanning the statistics")
 	}
 }
+
+func TestStaticRouteMetric(t *testing.T) {
+	defer netutils.SetupTestNetNS(t)()
+
+	key, err := newKey(t)
+	if err != nil {
+		t.Fatalf("Failed to obtain a key: %v", err)
+	}
+
+	s, err := NewSandbox(key, true)
+	if err != nil {
+		t.Fatalf("Failed to create a new sandbox: %v", err)
+	}
+	runtime.LockOSThread()
+
+	tbox, err := newInfo(t)
+	if err != nil {
+		t.Fatalf("Failed to generate new sandbox info: %v", err)
+	}
+
+	i := tbox.Info().Interfaces()[0]
+	if err := s.AddInterface(i.SrcName(), i.DstName(),
+		tbox.InterfaceOptions().Address(i.Address())); err != nil {
+		t.Fatalf("Failed to add interface to sandbox: %v", err)
+	}
+	runtime.LockOSThread()
+
+	_, dst, _ := net.ParseCIDR("10.10.0.0/16")
+	primary := &types.StaticRoute{Destination: dst, RouteType: types.NEXTHOP, NextHop: net.ParseIP("192.168.1.2"), Metric: 100}
+	backup := &types.StaticRoute{Destination: dst, RouteType: types.NEXTHOP, NextHop: net.ParseIP("192.168.1.1"), Metric: 200}
+	for _, r := range []*types.StaticRoute{backup, primary} {
+		if err := s.AddStaticRoute(r); err != nil {
+			t.Fatalf("Failed to add static route with metric %d: %v", r.Metric, err)
+		}
+		runtime.LockOSThread()
+	}
+
+	nextHop := func() net.IP {
+		var gw net.IP
+		if err := s.InvokeFunc(func() {
+			routes, err := netlink.RouteGet(net.ParseIP("10.10.1.1"))
+			if err == nil && len(routes) != 0 {
+				gw = routes[0].Gw
+			}
+		}); err != nil {
+			t.Fatal(err)
+		}
+		runtime.LockOSThread()
+		return gw
+	}
+
+	if gw := nextHop(); !gw.Equal(primary.NextHop) {
+		t.Fatalf("Expected the route with the lowest metric via %s to be preferred. Got %v", primary.NextHop, gw)
+	}
+
+	if err := s.RemoveStaticRoute(primary); err != nil {
+		t.Fatalf("Failed to remove static route: %v", err)
+	}
+	runtime.LockOSThread()
+
+	if gw := nextHop(); !gw.Equal(backup.NextHop) {
+		t.Fatalf("Expected the remaining route via %s to be used. Got %v", backup.NextHop, gw)
+	}
+
+	if err := s.Destroy(); err != nil {
+		t.Fatal(err)
+	}
+	GC()
+}
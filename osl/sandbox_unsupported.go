@@ -15,6 +15,16 @@ func NewSandbox(key string, osCreate bool) (Sandbox, error) {
 	return nil, ErrNotImplemented
 }
 
+// RestoreSandbox returns the sandbox of the network namespace created before
+// under the passed key
+func RestoreSandbox(key string) (Sandbox, error) {
+	return nil, ErrNotImplemented
+}
+
+func newHostSandbox(key string, osCreate bool) (Sandbox, error) {
+	return nil, ErrNotImplemented
+}
+
 // GenerateKey generates a sandbox key based on the passed
 // container id.
 func GenerateKey(containerID string) string {
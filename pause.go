@@ -0,0 +1,68 @@
+package libnetwork
+
+import (
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/osl"
+)
+
+// backgroundPause gates the controller background tasks. The resume channel is
+// set while the tasks are paused and closed when they are resumed.
+type backgroundPause struct {
+	resumeCh chan struct{}
+	sync.Mutex
+}
+
+func (c *controller) PauseBackgroundTasks() {
+	p := &c.bgPause
+	p.Lock()
+	defer p.Unlock()
+
+	if p.resumeCh != nil {
+		return
+	}
+	p.resumeCh = make(chan struct{})
+	osl.PauseGC()
+	log.Infof("Background tasks paused")
+}
+
+func (c *controller) ResumeBackgroundTasks() {
+	p := &c.bgPause
+	p.Lock()
+	defer p.Unlock()
+
+	if p.resumeCh == nil {
+		return
+	}
+	close(p.resumeCh)
+	p.resumeCh = nil
+	osl.ResumeGC()
+	log.Infof("Background tasks resumed")
+}
+
+// waitBackgroundTasks blocks while the background tasks are paused. It returns
+// false if stopCh is closed first, in which case the task must return.
+func (c *controller) waitBackgroundTasks(stopCh <-chan struct{}) bool {
+	p := &c.bgPause
+	p.Lock()
+	resumeCh := p.resumeCh
+	p.Unlock()
+
+	select {
+	case <-stopCh:
+		return false
+	default:
+	}
+
+	if resumeCh == nil {
+		return true
+	}
+
+	select {
+	case <-resumeCh:
+		return true
+	case <-stopCh:
+		return false
+	}
+}
@@ -0,0 +1,38 @@
+package portmapper
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// flushConntrack deletes the connection tracking entries of the flows destined
+// to the host port. Entries created before the port got (re)mapped, like the ones
+// left over by a daemon restart, would otherwise keep the translation of the
+// previous rules. Tests replace it.
+var flushConntrack = flushConntrackEntries
+
+func flushConntrackEntries(proto string, hostIP net.IP, hostPort int) error {
+	path, err := exec.LookPath("conntrack")
+	if err != nil {
+		logrus.Debugf("Skipping the conntrack flush of %s port %d: %v", proto, hostPort, err)
+		return nil
+	}
+
+	args := []string{"-D", "-p", proto, "--orig-port-dst", strconv.Itoa(hostPort)}
+	if hostIP != nil && !hostIP.IsUnspecified() {
+		args = append(args, "--orig-dst", hostIP.String())
+	}
+
+	out, err := exec.Command(path, args...).CombinedOutput()
+	// conntrack fails when no entry matched, there is nothing to flush then
+	if err != nil && !strings.Contains(string(out), "0 flow entries") {
+		return fmt.Errorf("conntrack %s failed: %v (%s)", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
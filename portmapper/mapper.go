@@ -16,6 +16,7 @@ type mapping struct {
 	userlandProxy userlandProxy
 	host          net.Addr
 	container     net.Addr
+	claimed       bool // the host port is reserved but not forwarded yet
 }
 
 var newProxy = newProxyCommand
@@ -33,6 +34,7 @@ var (
 type PortMapper struct {
 	chain      *iptables.ChainInfo
 	bridgeName string
+	sockOpts   SocketOptions
 
 	// udp:ip:port
 	currentMappings map[string]*mapping
@@ -60,6 +62,14 @@ func (pm *PortMapper) SetIptablesChain(c *iptables.ChainInfo, bridgeName string)
 	pm.bridgeName = bridgeName
 }
 
+// SetSocketOptions sets the socket options of the userland proxies of the
+// TCP mappings made from now on
+func (pm *PortMapper) SetSocketOptions(opts SocketOptions) {
+	pm.lock.Lock()
+	pm.sockOpts = opts
+	pm.lock.Unlock()
+}
+
 // Map maps the specified container transport address to the host's network address and transport port
 func (pm *PortMapper) Map(container net.Addr, hostIP net.IP, hostPort int, useProxy bool) (host net.Addr, err error) {
 	return pm.MapRange(container, hostIP, hostPort, hostPort, useProxy)
@@ -67,6 +77,18 @@ func (pm *PortMapper) Map(container net.Addr, hostIP net.IP, hostPort int, usePr
 
 // MapRange maps the specified container transport address to the host's network address and transport port range
 func (pm *PortMapper) MapRange(container net.Addr, hostIP net.IP, hostPortStart, hostPortEnd int, useProxy bool) (host net.Addr, err error) {
+	return pm.mapRange(container, hostIP, hostPortStart, hostPortEnd, useProxy, false)
+}
+
+// ClaimRange reserves a host transport port in the range for the specified
+// container transport address like MapRange does, without forwarding it: no
+// iptables rule is installed nor proxy started until Activate is called with
+// the returned host address. Unmap releases the claim.
+func (pm *PortMapper) ClaimRange(container net.Addr, hostIP net.IP, hostPortStart, hostPortEnd int, useProxy bool) (host net.Addr, err error) {
+	return pm.mapRange(container, hostIP, hostPortStart, hostPortEnd, useProxy, true)
+}
+
+func (pm *PortMapper) mapRange(container net.Addr, hostIP net.IP, hostPortStart, hostPortEnd int, useProxy, claimOnly bool) (host net.Addr, err error) {
 	pm.lock.Lock()
 	defer pm.lock.Unlock()
 
@@ -90,7 +112,7 @@ func (pm *PortMapper) MapRange(container net.Addr, hostIP net.IP, hostPortStart,
 		}
 
 		if useProxy {
-			m.userlandProxy = newProxy(proto, hostIP, allocatedHostPort, container.(*net.TCPAddr).IP, container.(*net.TCPAddr).Port)
+			m.userlandProxy = newProxy(proto, hostIP, allocatedHostPort, container.(*net.TCPAddr).IP, container.(*net.TCPAddr).Port, pm.sockOpts)
 		} else {
 			m.userlandProxy = newDummyProxy(proto, hostIP, allocatedHostPort)
 		}
@@ -107,7 +129,7 @@ func (pm *PortMapper) MapRange(container net.Addr, hostIP net.IP, hostPortStart,
 		}
 
 		if useProxy {
-			m.userlandProxy = newProxy(proto, hostIP, allocatedHostPort, container.(*net.UDPAddr).IP, container.(*net.UDPAddr).Port)
+			m.userlandProxy = newProxy(proto, hostIP, allocatedHostPort, container.(*net.UDPAddr).IP, container.(*net.UDPAddr).Port, pm.sockOpts)
 		} else {
 			m.userlandProxy = newDummyProxy(proto, hostIP, allocatedHostPort)
 		}
@@ -127,31 +149,60 @@ func (pm *PortMapper) MapRange(container net.Addr, hostIP net.IP, hostPortStart,
 		return nil, ErrPortMappedForIP
 	}
 
-	containerIP, containerPort := getIPAndPort(m.container)
-	if err := pm.forward(iptables.Append, m.proto, hostIP, allocatedHostPort, containerIP.String(), containerPort); err != nil {
+	if claimOnly {
+		m.claimed = true
+		pm.currentMappings[key] = m
+		return m.host, nil
+	}
+
+	if err := pm.activate(m); err != nil {
 		return nil, err
 	}
 
-	cleanup := func() error {
-		// need to undo the iptables rules before we return
-		m.userlandProxy.Stop()
-		pm.forward(iptables.Delete, m.proto, hostIP, allocatedHostPort, containerIP.String(), containerPort)
-		if err := pm.Allocator.ReleasePort(hostIP, m.proto, allocatedHostPort); err != nil {
-			return err
-		}
+	pm.currentMappings[key] = m
+	return m.host, nil
+}
 
+// Activate forwards the host transport address claimed through ClaimRange to
+// its container address. Activating a forwarded address does nothing.
+func (pm *PortMapper) Activate(host net.Addr) error {
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+
+	m, exists := pm.currentMappings[getKey(host)]
+	if !exists {
+		return ErrPortNotMapped
+	}
+	if !m.claimed {
 		return nil
 	}
 
+	if err := pm.activate(m); err != nil {
+		return err
+	}
+	m.claimed = false
+	return nil
+}
+
+// activate installs the iptables rules and starts the proxy of the mapping
+func (pm *PortMapper) activate(m *mapping) error {
+	containerIP, containerPort := getIPAndPort(m.container)
+	hostIP, hostPort := getIPAndPort(m.host)
+	if err := pm.forward(iptables.Append, m.proto, hostIP, hostPort, containerIP.String(), containerPort); err != nil {
+		return err
+	}
+
 	if err := m.userlandProxy.Start(); err != nil {
-		if err := cleanup(); err != nil {
-			return nil, fmt.Errorf("Error during port allocation cleanup: %v", err)
-		}
-		return nil, err
+		// need to undo the iptables rules before we return
+		m.userlandProxy.Stop()
+		pm.forward(iptables.Delete, m.proto, hostIP, hostPort, containerIP.String(), containerPort)
+		return err
 	}
 
-	pm.currentMappings[key] = m
-	return m.host, nil
+	if err := flushConntrack(m.proto, hostIP, hostPort); err != nil {
+		logrus.Warnf("Failed to flush the conntrack entries of %s: %v", getKey(m.host), err)
+	}
+	return nil
 }
 
 // Unmap removes stored mapping for the specified host transport address
@@ -165,16 +216,18 @@ func (pm *PortMapper) Unmap(host net.Addr) error {
 		return ErrPortNotMapped
 	}
 
-	if data.userlandProxy != nil {
-		data.userlandProxy.Stop()
-	}
-
 	delete(pm.currentMappings, key)
 
-	containerIP, containerPort := getIPAndPort(data.container)
-	hostIP, hostPort := getIPAndPort(data.host)
-	if err := pm.forward(iptables.Delete, data.proto, hostIP, hostPort, containerIP.String(), containerPort); err != nil {
-		logrus.Errorf("Error on iptables delete: %s", err)
+	if !data.claimed {
+		if data.userlandProxy != nil {
+			data.userlandProxy.Stop()
+		}
+
+		containerIP, containerPort := getIPAndPort(data.container)
+		hostIP, hostPort := getIPAndPort(data.host)
+		if err := pm.forward(iptables.Delete, data.proto, hostIP, hostPort, containerIP.String(), containerPort); err != nil {
+			logrus.Errorf("Error on iptables delete: %s", err)
+		}
 	}
 
 	switch a := host.(type) {
@@ -190,10 +243,17 @@ func (pm *PortMapper) Unmap(host net.Addr) error {
 func (pm *PortMapper) ReMapAll() {
 	logrus.Debugln("Re-applying all port mappings.")
 	for _, data := range pm.currentMappings {
+		if data.claimed {
+			continue
+		}
 		containerIP, containerPort := getIPAndPort(data.container)
 		hostIP, hostPort := getIPAndPort(data.host)
 		if err := pm.forward(iptables.Append, data.proto, hostIP, hostPort, containerIP.String(), containerPort); err != nil {
 			logrus.Errorf("Error on iptables add: %s", err)
+			continue
+		}
+		if err := flushConntrack(data.proto, hostIP, hostPort); err != nil {
+			logrus.Warnf("Failed to flush the conntrack entries of %s: %v", getKey(data.host), err)
 		}
 	}
 }
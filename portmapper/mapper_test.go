@@ -3,7 +3,9 @@ package portmapper
 import (
 	"net"
 	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/docker/libnetwork/iptables"
 	_ "github.com/docker/libnetwork/netutils"
@@ -12,6 +14,11 @@ import (
 func init() {
 	// override this func to mock out the proxy server
 	newProxy = newMockProxyCommand
+	flushConntrack = noopFlushConntrack
+}
+
+func noopFlushConntrack(proto string, hostIP net.IP, hostPort int) error {
+	return nil
 }
 
 func TestSetIptablesChain(t *testing.T) {
@@ -77,6 +84,39 @@ func TestMapTCPPorts(t *testing.T) {
 	}
 }
 
+func TestClaimTCPPort(t *testing.T) {
+	pm := New()
+	hostIP := net.ParseIP("192.168.0.1")
+	hostAddr := &net.TCPAddr{IP: hostIP, Port: 80}
+	srcAddr1 := &net.TCPAddr{Port: 1080, IP: net.ParseIP("172.16.0.1")}
+	srcAddr2 := &net.TCPAddr{Port: 1080, IP: net.ParseIP("172.16.0.2")}
+
+	if _, err := pm.ClaimRange(srcAddr1, hostIP, 80, 80, true); err != nil {
+		t.Fatalf("Failed to claim port: %s", err)
+	}
+	if pm.currentMappings[getKey(hostAddr)].userlandProxy.(*mockProxyCommand).started {
+		t.Fatal("Proxy started for a claimed port")
+	}
+
+	if _, err := pm.Map(srcAddr2, hostIP, 80, true); err == nil {
+		t.Fatal("Port is claimed - mapping should have failed")
+	}
+
+	if err := pm.Activate(hostAddr); err != nil {
+		t.Fatalf("Failed to activate port: %s", err)
+	}
+	if !pm.currentMappings[getKey(hostAddr)].userlandProxy.(*mockProxyCommand).started {
+		t.Fatal("Proxy not started for an activated port")
+	}
+
+	if err := pm.Unmap(hostAddr); err != nil {
+		t.Fatalf("Failed to release port: %s", err)
+	}
+	if err := pm.Activate(hostAddr); err != ErrPortNotMapped {
+		t.Fatalf("Expected ErrPortNotMapped activating a released port, got %v", err)
+	}
+}
+
 func TestGetUDPKey(t *testing.T) {
 	addr := &net.UDPAddr{IP: net.ParseIP("192.168.1.5"), Port: 53}
 
@@ -268,3 +308,148 @@ func TestMapUDPDummyListen(t *testing.T) {
 		}
 	}
 }
+
+type conntrackEntry struct {
+	proto string
+	port  int
+}
+
+// conntrackShim mocks the kernel connection tracking table
+type conntrackShim struct {
+	entries []conntrackEntry
+}
+
+func (c *conntrackShim) flush(proto string, hostIP net.IP, hostPort int) error {
+	var left []conntrackEntry
+	for _, e := range c.entries {
+		if e.proto != proto || e.port != hostPort {
+			left = append(left, e)
+		}
+	}
+	c.entries = left
+	return nil
+}
+
+func (c *conntrackShim) has(proto string, port int) bool {
+	for _, e := range c.entries {
+		if e.proto == proto && e.port == port {
+			return true
+		}
+	}
+	return false
+}
+
+func TestMapFlushConntrack(t *testing.T) {
+	shim := &conntrackShim{}
+	flushConntrack = shim.flush
+	defer func() { flushConntrack = noopFlushConntrack }()
+
+	// Entries left over by the mappings before the restart
+	shim.entries = []conntrackEntry{{"tcp", 8080}, {"udp", 8080}, {"tcp", 8081}}
+
+	pm := New()
+	hostIP := net.ParseIP("0.0.0.0")
+	if _, err := pm.Map(&net.TCPAddr{IP: net.ParseIP("172.17.0.2"), Port: 80}, hostIP, 8080, false); err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Unmap(&net.TCPAddr{IP: hostIP, Port: 8080})
+
+	if shim.has("tcp", 8080) {
+		t.Fatal("Stale tcp entries of the mapped port were not flushed")
+	}
+	if !shim.has("udp", 8080) || !shim.has("tcp", 8081) {
+		t.Fatalf("Entries of other ports or protocols were flushed: %v", shim.entries)
+	}
+
+	// Re-applying the mappings flushes the entries again
+	shim.entries = append(shim.entries, conntrackEntry{"tcp", 8080})
+	pm.ReMapAll()
+	if shim.has("tcp", 8080) {
+		t.Fatal("Stale tcp entries of the mapped port were not flushed on re-mapping")
+	}
+}
+
+func TestProxySocketOptions(t *testing.T) {
+	opts := SocketOptions{KeepAlive: true, KeepAliveInterval: 30 * time.Second, ReadBuffer: 65536, WriteBuffer: 65536}
+
+	type applied struct {
+		keepAlive, interval, rcvbuf, sndbuf int
+	}
+	results := make(chan applied, 2)
+	defer func(f func(*net.TCPConn, SocketOptions) error) { applySocketOptions = f }(applySocketOptions)
+	set := applySocketOptions
+	applySocketOptions = func(conn *net.TCPConn, o SocketOptions) error {
+		if err := set(conn, o); err != nil {
+			return err
+		}
+		f, err := conn.File()
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		fd := int(f.Fd())
+		var a applied
+		a.keepAlive, _ = syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE)
+		a.interval, _ = syscall.GetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL)
+		a.rcvbuf, _ = syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF)
+		a.sndbuf, _ = syscall.GetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_SNDBUF)
+		results <- a
+		return nil
+	}
+
+	backend, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	go func() {
+		if c, err := backend.Accept(); err == nil {
+			c.Close()
+		}
+	}()
+
+	p, err := newTCPProxy(&net.TCPAddr{IP: net.ParseIP("127.0.0.1")}, backend.Addr().(*net.TCPAddr), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	go p.Run()
+
+	client, err := net.Dial("tcp", p.FrontendAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// Both the client and the backend connections get the options
+	for i := 0; i < 2; i++ {
+		select {
+		case a := <-results:
+			if a.keepAlive != 1 || a.interval != 30 {
+				t.Fatalf("Expected keepalive every 30s on the proxied connection. Got %+v", a)
+			}
+			// The kernel doubles the requested buffer sizes for its bookkeeping
+			if a.rcvbuf < opts.ReadBuffer || a.sndbuf < opts.WriteBuffer {
+				t.Fatalf("Expected buffers of at least %d bytes on the proxied connection. Got %+v", opts.ReadBuffer, a)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for the proxy to set the socket options")
+		}
+	}
+}
+
+func TestProxyCommandSocketOptions(t *testing.T) {
+	opts := SocketOptions{KeepAlive: true, KeepAliveInterval: time.Minute, ReadBuffer: 4096}
+	ip := net.ParseIP("172.17.0.2")
+
+	tcp := newProxyCommand("tcp", ip, 8080, ip, 80, opts).(*proxyCommand)
+	args := strings.Join(tcp.cmd.Args, " ")
+	if !strings.Contains(args, "-keepalive -keepalive-interval 1m0s -rcvbuf 4096") || strings.Contains(args, "-sndbuf") {
+		t.Fatalf("Unexpected tcp proxy arguments: %s", args)
+	}
+
+	udp := newProxyCommand("udp", ip, 8080, ip, 80, opts).(*proxyCommand)
+	if args := strings.Join(udp.cmd.Args, " "); strings.Contains(args, "-keepalive") {
+		t.Fatalf("Expected no socket options for the udp proxy. Got %s", args)
+	}
+}
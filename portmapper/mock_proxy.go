@@ -2,17 +2,20 @@ package portmapper
 
 import "net"
 
-func newMockProxyCommand(proto string, hostIP net.IP, hostPort int, containerIP net.IP, containerPort int) userlandProxy {
+func newMockProxyCommand(proto string, hostIP net.IP, hostPort int, containerIP net.IP, containerPort int, opts SocketOptions) userlandProxy {
 	return &mockProxyCommand{}
 }
 
 type mockProxyCommand struct {
+	started bool
 }
 
 func (p *mockProxyCommand) Start() error {
+	p.started = true
 	return nil
 }
 
 func (p *mockProxyCommand) Stop() error {
+	p.started = false
 	return nil
 }
@@ -38,9 +38,17 @@ type proxyCommand struct {
 // execProxy is the reexec function that is registered to start the userland proxies
 func execProxy() {
 	f := os.NewFile(3, "signal-parent")
-	host, container := parseHostContainerAddrs()
+	host, container, opts := parseHostContainerAddrs()
 
-	p, err := proxy.NewProxy(host, container)
+	var (
+		p   proxy.Proxy
+		err error
+	)
+	if tcpHost, ok := host.(*net.TCPAddr); ok && !opts.isZero() {
+		p, err = newTCPProxy(tcpHost, container.(*net.TCPAddr), opts)
+	} else {
+		p, err = proxy.NewProxy(host, container)
+	}
 	if err != nil {
 		fmt.Fprintf(f, "1\n%s", err)
 		f.Close()
@@ -55,8 +63,8 @@ func execProxy() {
 }
 
 // parseHostContainerAddrs parses the flags passed on reexec to create the TCP or UDP
-// net.Addrs to map the host and container ports
-func parseHostContainerAddrs() (host net.Addr, container net.Addr) {
+// net.Addrs to map the host and container ports, and the TCP socket options
+func parseHostContainerAddrs() (host net.Addr, container net.Addr, opts SocketOptions) {
 	var (
 		proto         = flag.String("proto", "tcp", "proxy protocol")
 		hostIP        = flag.String("host-ip", "", "host ip")
@@ -64,6 +72,7 @@ func parseHostContainerAddrs() (host net.Addr, container net.Addr) {
 		containerIP   = flag.String("container-ip", "", "container ip")
 		containerPort = flag.Int("container-port", -1, "container port")
 	)
+	socketOptionFlags(&opts)
 
 	flag.Parse()
 
@@ -78,7 +87,7 @@ func parseHostContainerAddrs() (host net.Addr, container net.Addr) {
 		log.Fatalf("unsupported protocol %s", *proto)
 	}
 
-	return host, container
+	return host, container, opts
 }
 
 func handleStopSignals(p proxy.Proxy) {
@@ -92,7 +101,7 @@ func handleStopSignals(p proxy.Proxy) {
 	}
 }
 
-func newProxyCommand(proto string, hostIP net.IP, hostPort int, containerIP net.IP, containerPort int) userlandProxy {
+func newProxyCommand(proto string, hostIP net.IP, hostPort int, containerIP net.IP, containerPort int, opts SocketOptions) userlandProxy {
 	args := []string{
 		userlandProxyCommandName,
 		"-proto", proto,
@@ -101,6 +110,10 @@ func newProxyCommand(proto string, hostIP net.IP, hostPort int, containerIP net.
 		"-container-ip", containerIP.String(),
 		"-container-port", strconv.Itoa(containerPort),
 	}
+	// The socket options only apply to the TCP proxy
+	if proto == "tcp" {
+		args = append(args, opts.args()...)
+	}
 
 	return &proxyCommand{
 		cmd: &exec.Cmd{
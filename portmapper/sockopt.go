@@ -0,0 +1,96 @@
+package portmapper
+
+import (
+	"flag"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// SocketOptions are the options the userland proxy sets on the sockets of the
+// TCP connections it forwards. The zero value leaves the system defaults.
+type SocketOptions struct {
+	// KeepAlive enables the TCP keepalive probes
+	KeepAlive bool
+	// KeepAliveInterval is the period of the keepalive probes
+	KeepAliveInterval time.Duration
+	// ReadBuffer and WriteBuffer are the socket buffer sizes, in bytes
+	ReadBuffer  int
+	WriteBuffer int
+}
+
+func (o SocketOptions) isZero() bool {
+	return o == SocketOptions{}
+}
+
+// args returns the userland proxy command arguments passing the options
+func (o SocketOptions) args() []string {
+	var args []string
+	if o.KeepAlive {
+		args = append(args, "-keepalive")
+	}
+	if o.KeepAliveInterval != 0 {
+		args = append(args, "-keepalive-interval", o.KeepAliveInterval.String())
+	}
+	if o.ReadBuffer != 0 {
+		args = append(args, "-rcvbuf", strconv.Itoa(o.ReadBuffer))
+	}
+	if o.WriteBuffer != 0 {
+		args = append(args, "-sndbuf", strconv.Itoa(o.WriteBuffer))
+	}
+	return args
+}
+
+// socketOptionFlags registers the userland proxy command flags of the options
+func socketOptionFlags(o *SocketOptions) {
+	flag.BoolVar(&o.KeepAlive, "keepalive", false, "enable tcp keepalive")
+	flag.DurationVar(&o.KeepAliveInterval, "keepalive-interval", 0, "tcp keepalive interval")
+	flag.IntVar(&o.ReadBuffer, "rcvbuf", 0, "socket receive buffer size")
+	flag.IntVar(&o.WriteBuffer, "sndbuf", 0, "socket send buffer size")
+}
+
+// applySocketOptions sets the options on a connection forwarded by the proxy.
+// Tests replace it.
+var applySocketOptions = func(conn *net.TCPConn, o SocketOptions) error {
+	if o.KeepAlive {
+		if err := conn.SetKeepAlive(true); err != nil {
+			return err
+		}
+		if o.KeepAliveInterval != 0 {
+			if err := setKeepAliveInterval(conn, o.KeepAliveInterval); err != nil {
+				return err
+			}
+		}
+	}
+	if o.ReadBuffer != 0 {
+		if err := conn.SetReadBuffer(o.ReadBuffer); err != nil {
+			return err
+		}
+	}
+	if o.WriteBuffer != 0 {
+		if err := conn.SetWriteBuffer(o.WriteBuffer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setKeepAliveInterval sets both the idle time before the first keepalive probe
+// and the interval between the probes to d
+func setKeepAliveInterval(conn *net.TCPConn, d time.Duration) error {
+	if err := conn.SetKeepAlivePeriod(d); err != nil {
+		return err
+	}
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	if err := rc.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL, int(d/time.Second))
+	}); err != nil {
+		return err
+	}
+	return serr
+}
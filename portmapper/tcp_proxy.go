@@ -0,0 +1,97 @@
+package portmapper
+
+import (
+	"io"
+	"net"
+	"syscall"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// tcpProxy is the userland TCP proxy setting socket options on both ends of
+// the connections it forwards, which the docker proxy package does not expose
+type tcpProxy struct {
+	listener     *net.TCPListener
+	frontendAddr *net.TCPAddr
+	backendAddr  *net.TCPAddr
+	opts         SocketOptions
+}
+
+func newTCPProxy(frontendAddr, backendAddr *net.TCPAddr, opts SocketOptions) (*tcpProxy, error) {
+	listener, err := net.ListenTCP("tcp", frontendAddr)
+	if err != nil {
+		return nil, err
+	}
+	return &tcpProxy{
+		listener:     listener,
+		frontendAddr: listener.Addr().(*net.TCPAddr),
+		backendAddr:  backendAddr,
+		opts:         opts,
+	}, nil
+}
+
+func (p *tcpProxy) clientLoop(client *net.TCPConn, quit chan bool) {
+	if err := applySocketOptions(client, p.opts); err != nil {
+		logrus.Warnf("Failed to set socket options on client connection from %v: %v", client.RemoteAddr(), err)
+	}
+
+	backend, err := net.DialTCP("tcp", nil, p.backendAddr)
+	if err != nil {
+		logrus.Printf("Can't forward traffic to backend tcp/%v: %s\n", p.backendAddr, err)
+		client.Close()
+		return
+	}
+	if err := applySocketOptions(backend, p.opts); err != nil {
+		logrus.Warnf("Failed to set socket options on backend connection to %v: %v", p.backendAddr, err)
+	}
+
+	event := make(chan int64)
+	var broker = func(to, from *net.TCPConn) {
+		written, err := io.Copy(to, from)
+		if err != nil {
+			// If the socket we are writing to is shutdown with
+			// SHUT_WR, forward it to the other end of the pipe:
+			if err, ok := err.(*net.OpError); ok && err.Err == syscall.EPIPE {
+				from.CloseWrite()
+			}
+		}
+		to.CloseRead()
+		event <- written
+	}
+
+	go broker(client, backend)
+	go broker(backend, client)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-event:
+		case <-quit:
+			// Interrupt the two brokers and "join" them.
+			client.Close()
+			backend.Close()
+			for ; i < 2; i++ {
+				<-event
+			}
+			return
+		}
+	}
+	client.Close()
+	backend.Close()
+}
+
+func (p *tcpProxy) Run() {
+	quit := make(chan bool)
+	defer close(quit)
+	for {
+		client, err := p.listener.Accept()
+		if err != nil {
+			logrus.Printf("Stopping proxy on tcp/%v for tcp/%v (%s)", p.frontendAddr, p.backendAddr, err)
+			return
+		}
+		go p.clientLoop(client.(*net.TCPConn), quit)
+	}
+}
+
+func (p *tcpProxy) Close()                 { p.listener.Close() }
+func (p *tcpProxy) FrontendAddr() net.Addr { return p.frontendAddr }
+func (p *tcpProxy) BackendAddr() net.Addr  { return p.backendAddr }
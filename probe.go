@@ -0,0 +1,139 @@
+package libnetwork
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	defaultProbeInterval  = 5 * time.Second
+	defaultProbeThreshold = 3
+)
+
+// HealthProbe describes the connectivity check run against an endpoint. The
+// endpoint is dropped from the network service records after Threshold
+// consecutive failures to connect to its TCP Port, and is restored on the
+// first successful connection.
+type HealthProbe struct {
+	Port      uint16
+	Interval  time.Duration
+	Threshold int
+}
+
+// probeDial checks the connectivity to the address. Tests replace it to
+// simulate the endpoint failures.
+var probeDial = func(addr string, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// CreateOptionHealthProbe function returns an option setter for the health probe
+// the endpoint service records depend on. Endpoints sharing a name through
+// CreateOptionDNSNames are thereby resolved to the healthy ones only.
+func CreateOptionHealthProbe(probe HealthProbe) EndpointOption {
+	return func(ep *endpoint) {
+		p := probe
+		if p.Interval <= 0 {
+			p.Interval = defaultProbeInterval
+		}
+		if p.Threshold <= 0 {
+			p.Threshold = defaultProbeThreshold
+		}
+		ep.probe = &p
+	}
+}
+
+func (ep *endpoint) isHealthy() bool {
+	ep.Lock()
+	defer ep.Unlock()
+
+	return !ep.unhealthy
+}
+
+// startProbe runs the endpoint health probe, if any, until stopProbe is called
+func (ep *endpoint) startProbe() {
+	ep.Lock()
+	probe := ep.probe
+	if probe == nil || ep.probeStopCh != nil {
+		ep.Unlock()
+		return
+	}
+	stopCh := make(chan struct{})
+	ep.probeStopCh = stopCh
+	ep.Unlock()
+
+	go ep.runProbe(probe, stopCh)
+}
+
+func (ep *endpoint) stopProbe() {
+	ep.Lock()
+	defer ep.Unlock()
+
+	if ep.probeStopCh != nil {
+		close(ep.probeStopCh)
+		ep.probeStopCh = nil
+	}
+}
+
+func (ep *endpoint) runProbe(probe *HealthProbe, stopCh chan struct{}) {
+	ticker := time.NewTicker(probe.Interval)
+	defer ticker.Stop()
+
+	failures := 0
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+
+		if !ep.getNetwork().ctrlr.waitBackgroundTasks(stopCh) {
+			return
+		}
+
+		ip := ep.getFirstInterfaceAddress()
+		if ip == nil {
+			continue
+		}
+
+		err := probeDial(net.JoinHostPort(ip.String(), strconv.Itoa(int(probe.Port))), probe.Interval)
+		if err == nil {
+			failures = 0
+			ep.setHealthy(true, stopCh)
+			continue
+		}
+
+		failures++
+		log.Debugf("Health probe %d of endpoint %s failed: %v", failures, ep.Name(), err)
+		if failures >= probe.Threshold {
+			ep.setHealthy(false, stopCh)
+		}
+	}
+}
+
+// setHealthy moves the endpoint service records according to its health
+func (ep *endpoint) setHealthy(healthy bool, stopCh chan struct{}) {
+	ep.Lock()
+	if ep.probeStopCh != stopCh || ep.unhealthy == !healthy {
+		ep.Unlock()
+		return
+	}
+	ep.unhealthy = !healthy
+	n := ep.network
+	ep.Unlock()
+
+	if healthy {
+		log.Infof("Endpoint %s recovered, restoring its service records", ep.Name())
+		n.updateSvcRecord(ep, true)
+		return
+	}
+
+	log.Warnf("Endpoint %s failed its health probe, removing its service records", ep.Name())
+	n.programSvcRecord(ep, false)
+}
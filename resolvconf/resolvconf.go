@@ -10,6 +10,7 @@ import (
 
 	"github.com/Sirupsen/logrus"
 	"github.com/docker/docker/pkg/ioutils"
+	"github.com/docker/libnetwork/fileutils"
 	"github.com/docker/libnetwork/resolvconf/dns"
 )
 
@@ -86,15 +87,19 @@ func GetLastModified() ([]byte, string) {
 //    resolv.conf, removing local nameserver entries, and, if the resulting
 //    cleaned config has no defined nameservers left, adds default DNS entries
 // 2. Given the caller provides the enable/disable state of IPv6, the filter
-//    code will remove all IPv6 nameservers if it is not enabled for containers
+//    code will remove all IPv6 nameservers if it is not enabled for containers,
+//    unless they are the only ones left as on an IPv6 only host
 //
 // It returns a boolean to notify the caller if changes were made at all
 func FilterResolvDNS(resolvConf []byte, ipv6Enabled bool) ([]byte, bool) {
 	changed := false
 	cleanedResolvConf := localhostNSRegexp.ReplaceAll(resolvConf, []byte{})
-	// if IPv6 is not enabled, also clean out any IPv6 address nameserver
+	// if IPv6 is not enabled, also clean out any IPv6 address nameserver, but
+	// keep them when there is no other: the IPv4 defaults would be unreachable
 	if !ipv6Enabled {
-		cleanedResolvConf = nsIPv6Regexp.ReplaceAll(cleanedResolvConf, []byte{})
+		if v4Only := nsIPv6Regexp.ReplaceAll(cleanedResolvConf, []byte{}); len(GetNameservers(v4Only)) != 0 {
+			cleanedResolvConf = v4Only
+		}
 	}
 	// if the resulting resolvConf has no more nameservers defined, add appropriate
 	// default DNS servers for IPv4 and (optionally) IPv6
@@ -181,35 +186,35 @@ func GetOptions(resolvConf []byte) []string {
 	return options
 }
 
-// Build writes a configuration file to path containing a "nameserver" entry
-// for every element in dns, a "search" entry for every element in
-// dnsSearch, and an "options" entry for every element in dnsOptions.
-func Build(path string, dns, dnsSearch, dnsOptions []string) (string, error) {
+// Content returns the content of a configuration file containing a
+// "nameserver" entry for every element in dns, a "search" entry for every
+// element in dnsSearch, and an "options" entry for every element in dnsOptions.
+func Content(dns, dnsSearch, dnsOptions []string) []byte {
 	content := bytes.NewBuffer(nil)
 	if len(dnsSearch) > 0 {
 		if searchString := strings.Join(dnsSearch, " "); strings.Trim(searchString, " ") != "." {
-			if _, err := content.WriteString("search " + searchString + "\n"); err != nil {
-				return "", err
-			}
+			content.WriteString("search " + searchString + "\n")
 		}
 	}
 	for _, dns := range dns {
-		if _, err := content.WriteString("nameserver " + dns + "\n"); err != nil {
-			return "", err
-		}
+		content.WriteString("nameserver " + dns + "\n")
 	}
 	if len(dnsOptions) > 0 {
 		if optsString := strings.Join(dnsOptions, " "); strings.Trim(optsString, " ") != "" {
-			if _, err := content.WriteString("options " + optsString + "\n"); err != nil {
-				return "", err
-			}
+			content.WriteString("options " + optsString + "\n")
 		}
 	}
+	return content.Bytes()
+}
 
-	hash, err := ioutils.HashData(bytes.NewReader(content.Bytes()))
+// Build writes a configuration file to path with the Content of dns,
+// dnsSearch and dnsOptions. It returns the hash of the content.
+func Build(path string, dns, dnsSearch, dnsOptions []string) (string, error) {
+	content := Content(dns, dnsSearch, dnsOptions)
+	hash, err := ioutils.HashData(bytes.NewReader(content))
 	if err != nil {
 		return "", err
 	}
 
-	return hash, ioutil.WriteFile(path, content.Bytes(), 0644)
+	return hash, fileutils.WriteFile(path, content, 0644)
 }
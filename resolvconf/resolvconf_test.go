@@ -288,4 +288,13 @@ func TestFilterResolvDns(t *testing.T) {
 			t.Fatalf("Failed no Localhost+IPv6 enabled: expected \n<%s> got \n<%s>", ns0, string(result))
 		}
 	}
+
+	// with IPv6 disabled, the IPv6 nameservers of an IPv6 only host should be preserved
+	ns0 = "search example.com\nnameserver 2002:dead:beef::1\n"
+	ns1 = "search example.com\nnameserver ::1\nnameserver 2002:dead:beef::1\n"
+	if result, _ := FilterResolvDNS([]byte(ns1), false); result != nil {
+		if ns0 != string(result) {
+			t.Fatalf("Failed IPv6 only+IPv6 off: expected \n<%s> got \n<%s>", ns0, string(result))
+		}
+	}
 }
@@ -0,0 +1,327 @@
+package libnetwork
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/libnetwork/osl"
+	"github.com/docker/libnetwork/resolvconf"
+	"github.com/docker/libnetwork/types"
+)
+
+const (
+	// resolverIP is the address the embedded resolver listens on in the
+	// sandbox network namespace
+	resolverIP = "127.0.0.11"
+	// dnsTimeout bounds the wait for the answer of an upstream nameserver
+	dnsTimeout = 2 * time.Second
+	// dnsMaxMsgSize is the largest DNS message carried over UDP
+	dnsMaxMsgSize = 65535
+	// maxNameservers is the number of resolv.conf nameservers the clients honor
+	maxNameservers = 3
+)
+
+// dnsForwardDomain returns the fully qualified lower case form of the domain of
+// a forwarding rule, "*.corp" and ".corp" standing for "corp."
+func dnsForwardDomain(domain string) string {
+	d := strings.Trim(strings.TrimPrefix(strings.ToLower(strings.TrimSpace(domain)), "*"), ".")
+	if d == "" {
+		return ""
+	}
+	return d + "."
+}
+
+// dnsForwardServer returns the nameserver address with the DNS port if it has none
+func dnsForwardServer(ns string) string {
+	ns = strings.TrimSpace(ns)
+	if net.ParseIP(ns) != nil {
+		return net.JoinHostPort(ns, "53")
+	}
+	return ns
+}
+
+// addDNSForward adds the nameservers of the domain to the forwarding rules
+func addDNSForward(rules map[string][]string, domain string, nameservers []string) map[string][]string {
+	if rules == nil {
+		rules = make(map[string][]string)
+	}
+	d := dnsForwardDomain(domain)
+	for _, ns := range nameservers {
+		rules[d] = append(rules[d], dnsForwardServer(ns))
+	}
+	return rules
+}
+
+func validateDNSForward(rules map[string][]string) error {
+	for d, servers := range rules {
+		if d == "" || strings.ContainsAny(d, " \t") {
+			return types.BadRequestErrorf("invalid dns forwarding domain %q", d)
+		}
+		if len(servers) == 0 {
+			return types.BadRequestErrorf("no nameserver to forward the domain %s to", d)
+		}
+		for _, s := range servers {
+			if host, _, err := net.SplitHostPort(s); err != nil || net.ParseIP(host) == nil {
+				return types.BadRequestErrorf("invalid nameserver %q to forward the domain %s to", s, d)
+			}
+		}
+	}
+	return nil
+}
+
+// resolverNameservers returns the resolv.conf nameservers of a sandbox whose
+// embedded resolver runs: the resolver address first, or last if requested,
+// along with the host nameservers. Those are cut for the resolver to remain
+// among the nameservers the clients try.
+func resolverNameservers(hostNS []string, last bool) []string {
+	if len(hostNS) > maxNameservers-1 {
+		hostNS = hostNS[:maxNameservers-1]
+	}
+	if last {
+		return append(append([]string{}, hostNS...), resolverIP)
+	}
+	return append([]string{resolverIP}, hostNS...)
+}
+
+// dnsQuestionName returns the name of the first question of a DNS message
+func dnsQuestionName(msg []byte) (string, error) {
+	const headerLen = 12
+	if len(msg) < headerLen || binary.BigEndian.Uint16(msg[4:6]) == 0 {
+		return "", fmt.Errorf("no question in DNS message")
+	}
+
+	var labels []string
+	for i := headerLen; i < len(msg); {
+		l := int(msg[i])
+		if l == 0 {
+			return strings.Join(labels, ".") + ".", nil
+		}
+		// Question names are never compressed
+		if l&0xc0 != 0 || i+1+l > len(msg) {
+			return "", fmt.Errorf("invalid question name in DNS message")
+		}
+		labels = append(labels, string(msg[i+1:i+1+l]))
+		i += 1 + l
+	}
+	return "", fmt.Errorf("truncated question name in DNS message")
+}
+
+// dnsForwarder is the embedded resolver of a sandbox. The queries for the
+// domains of the forwarding rules go to the nameservers of the longest matching
+// domain, the other ones to the default upstream nameservers. Only UDP is served.
+type dnsForwarder struct {
+	conn      net.PacketConn
+	upstreams []string
+	rules     map[string][]string
+	dial      func(addr string) (net.Conn, error)
+	sync.Mutex
+}
+
+func newDNSForwarder(conn net.PacketConn, upstreams []string, dial func(addr string) (net.Conn, error)) *dnsForwarder {
+	return &dnsForwarder{conn: conn, upstreams: upstreams, dial: dial}
+}
+
+func (f *dnsForwarder) setRules(rules map[string][]string) {
+	f.Lock()
+	f.rules = rules
+	f.Unlock()
+}
+
+// nameservers returns the nameservers the query for name is forwarded to
+func (f *dnsForwarder) nameservers(name string) []string {
+	name = strings.ToLower(name)
+
+	f.Lock()
+	defer f.Unlock()
+	var match string
+	for d := range f.rules {
+		if (name == d || strings.HasSuffix(name, "."+d)) && len(d) > len(match) {
+			match = d
+		}
+	}
+	if match != "" {
+		return f.rules[match]
+	}
+	return f.upstreams
+}
+
+func (f *dnsForwarder) serve() {
+	buf := make([]byte, dnsMaxMsgSize)
+	for {
+		n, client, err := f.conn.ReadFrom(buf)
+		if err != nil {
+			// The resolver was stopped
+			return
+		}
+		query := make([]byte, n)
+		copy(query, buf[:n])
+		go f.forward(query, client)
+	}
+}
+
+func (f *dnsForwarder) forward(query []byte, client net.Addr) {
+	name, err := dnsQuestionName(query)
+	if err != nil {
+		log.Debugf("Dropping DNS query from %s: %v", client, err)
+		return
+	}
+
+	for _, ns := range f.nameservers(name) {
+		resp, err := f.exchange(ns, query)
+		if err != nil {
+			log.Debugf("Forwarding the DNS query for %s to %s failed: %v", name, ns, err)
+			continue
+		}
+		if _, err := f.conn.WriteTo(resp, client); err != nil {
+			log.Debugf("Failed to answer the DNS query for %s from %s: %v", name, client, err)
+		}
+		return
+	}
+}
+
+func (f *dnsForwarder) exchange(ns string, query []byte) ([]byte, error) {
+	conn, err := f.dial(ns)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(dnsTimeout))
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	resp := make([]byte, dnsMaxMsgSize)
+	n, err := conn.Read(resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp[:n], nil
+}
+
+func (f *dnsForwarder) stop() {
+	f.conn.Close()
+}
+
+// dnsForwardRules returns the forwarding rules of the endpoints joined to the
+// sandbox and of their networks. For a domain, the rule of an endpoint wins
+// over the one of its network and the endpoints are taken in priority order.
+func (sb *sandbox) dnsForwardRules() map[string][]string {
+	eps := sb.joinedEndpoints()
+	sort.Sort(epHeap(eps))
+
+	rules := make(map[string][]string)
+	for _, ep := range eps {
+		ep.Lock()
+		epRules := ep.dnsForward
+		ep.Unlock()
+		for _, r := range []map[string][]string{epRules, ep.getNetwork().getDNSForward()} {
+			for d, servers := range r {
+				if _, ok := rules[d]; !ok {
+					rules[d] = servers
+				}
+			}
+		}
+	}
+	return rules
+}
+
+// updateDNSForwarding starts the embedded resolver of the sandbox when a
+// forwarding rule first applies to it, and adds it to the sandbox resolv.conf
+// nameservers. Once started, the resolver serves the sandbox until it is deleted, its
+// rules following the joins and leaves of the endpoints.
+func (sb *sandbox) updateDNSForwarding() error {
+	// This is for the host mode networking
+	if sb.config.originResolvConfPath != "" || sb.config.useDefaultSandBox || sb.osSbox == nil {
+		return nil
+	}
+
+	rules := sb.dnsForwardRules()
+
+	sb.Lock()
+	fwd := sb.resolver
+	sb.Unlock()
+	if fwd != nil {
+		fwd.setRules(rules)
+		return nil
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+	// The resolver would listen in the host namespace, on the address of the
+	// other sandboxes resolvers, and its rules would apply to the whole host
+	if sb.controller != nil && sb.controller.hostSandboxes() {
+		log.Warnf("Not starting the embedded resolver of sandbox %s, created with the %s backend", sb.ID(), osl.HostBackend)
+		return nil
+	}
+
+	touched, err := sb.resolvConfTouched()
+	if err != nil {
+		return err
+	}
+	if touched {
+		log.Infof("Skipping the DNS forwarding setup of sandbox %s because resolv.conf was touched by user", sb.ID())
+		return nil
+	}
+	resolvConf, err := sb.resolvConfBaseContent()
+	if err != nil {
+		return err
+	}
+
+	hostNS := resolvconf.GetNameservers(resolvConf)
+	var upstreams []string
+	for _, ns := range hostNS {
+		upstreams = append(upstreams, dnsForwardServer(ns))
+	}
+
+	var conn net.PacketConn
+	osSbox := sb.osSbox
+	if nErr := osSbox.InvokeFunc(func() {
+		conn, err = net.ListenPacket("udp", net.JoinHostPort(resolverIP, "53"))
+	}); nErr != nil {
+		return nErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to start the embedded resolver of sandbox %s: %v", sb.ID(), err)
+	}
+
+	fwd = newDNSForwarder(conn, upstreams, func(addr string) (c net.Conn, err error) {
+		// The nameservers are reached from the sandbox namespace
+		if nErr := osSbox.InvokeFunc(func() {
+			c, err = net.DialTimeout("udp", addr, dnsTimeout)
+		}); nErr != nil {
+			return nil, nErr
+		}
+		return c, err
+	})
+	fwd.setRules(rules)
+
+	nameservers := resolverNameservers(hostNS, sb.config.resolverLast)
+	if err := sb.writeResolvConf(resolvconf.Content(nameservers, resolvconf.GetSearchDomains(resolvConf), resolvconf.GetOptions(resolvConf))); err != nil {
+		fwd.stop()
+		return err
+	}
+
+	go fwd.serve()
+
+	sb.Lock()
+	sb.resolver = fwd
+	sb.Unlock()
+	return nil
+}
+
+func (sb *sandbox) stopResolver() {
+	sb.Lock()
+	fwd := sb.resolver
+	sb.resolver = nil
+	sb.Unlock()
+
+	if fwd != nil {
+		fwd.stop()
+	}
+}
@@ -7,13 +7,17 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
-	"path"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/docker/pkg/ioutils"
+	"github.com/docker/docker/pkg/stringid"
 	"github.com/docker/libnetwork/etchosts"
+	"github.com/docker/libnetwork/fileutils"
+	"github.com/docker/libnetwork/iptables"
 	"github.com/docker/libnetwork/osl"
 	"github.com/docker/libnetwork/resolvconf"
 	"github.com/docker/libnetwork/types"
@@ -31,6 +35,20 @@ type Sandbox interface {
 	Labels() map[string]interface{}
 	// Statistics retrieves the interfaces' statistics for the sandbox
 	Statistics() (map[string]*osl.InterfaceStatistics, error)
+	// NamespaceID returns the inode of the sandbox network namespace, the
+	// identifier the kernel reports the namespace with
+	NamespaceID() (uint64, error)
+	// Owner returns the ID of the controller instance managing the sandbox
+	Owner() string
+	// Activate programs into the sandbox the network resources of an endpoint
+	// which was lazily joined. It is a no-op for an endpoint already active.
+	Activate(ep Endpoint) error
+	// PendingEndpoints returns the endpoints lazily joined to the sandbox and
+	// not activated yet
+	PendingEndpoints() []Endpoint
+	// PendingDone returns a channel which is closed once no endpoint is
+	// pending activation in the sandbox
+	PendingDone() <-chan struct{}
 	// Delete destroys this container after detaching it from all connected endpoints.
 	Delete() error
 }
@@ -48,6 +66,28 @@ func (sb *sandbox) processOptions(options ...SandboxOption) {
 	}
 }
 
+// compatibleOptions returns whether the options would configure a sandbox
+// the same as this one, as far as its identity is concerned.
+func (sb *sandbox) compatibleOptions(options ...SandboxOption) bool {
+	tmp := &sandbox{}
+	tmp.processOptions(options...)
+
+	sb.Lock()
+	defer sb.Unlock()
+	return tmp.config.hostName == sb.config.hostName &&
+		tmp.config.domainName == sb.config.domainName
+}
+
+// waitCreated returns once the creation of the sandbox completed, or failed
+func (sb *sandbox) waitCreated() {
+	sb.Lock()
+	done := sb.createDone
+	sb.Unlock()
+	if done != nil {
+		<-done
+	}
+}
+
 type epHeap []*endpoint
 
 type sandbox struct {
@@ -59,9 +99,17 @@ type sandbox struct {
 	refCnt      int
 	endpoints   epHeap
 	epPriority  map[string]int
+	lazyEps     map[string]*endpoint
+	defaultEp   *endpoint // endpoint on the controller default network, if any
+	// container side ports exposed by the joined endpoints
+	containerPorts map[types.TransportPort]*endpoint
 	//hostsPath      string
 	//resolvConfPath string
 	joinLeaveDone chan struct{}
+	createDone    chan struct{} // closed once the creation completed or failed
+	pendingDone   chan struct{} // closed when lazyEps becomes empty
+	resolver      *dnsForwarder // embedded resolver, started with the first DNS forwarding rule
+	linkMon       *linkMonitor  // link state monitor, started with the first endpoint interface
 	sync.Mutex
 }
 
@@ -93,7 +141,22 @@ type resolvConfPathConfig struct {
 	resolvConfHashFile   string
 	dnsList              []string
 	dnsSearchList        []string
+	dnsSearchBase        []string // the search domains resolv.conf was set up with
 	dnsOptionsList       []string
+	dnsResolverOpts      map[string]int // ndots, timeout and attempts values overriding the resolv.conf options
+	resolverLast         bool           // the embedded resolver goes after the host nameservers
+	resolvConfBase       []byte         // the resolv.conf content libnetwork generated, before the generator ran
+}
+
+// dnsResolverOptLimits are the ranges accepted by the resolver for the options
+// which can be set through OptionDNSNdots, OptionDNSTimeout and OptionDNSAttempts
+var dnsResolverOptLimits = []struct {
+	name     string
+	min, max int
+}{
+	{"ndots", 0, 15},
+	{"timeout", 1, 30},
+	{"attempts", 1, 5},
 }
 
 type containerConfig struct {
@@ -101,6 +164,8 @@ type containerConfig struct {
 	resolvConfPathConfig
 	generic           map[string]interface{}
 	useDefaultSandBox bool
+	noDefaultNetwork  bool
+	netClsClassID     uint32
 	prio              int // higher the value, more the priority
 }
 
@@ -119,6 +184,18 @@ func (sb *sandbox) Key() string {
 	return osl.GenerateKey(sb.id)
 }
 
+func (sb *sandbox) Owner() string {
+	return sb.controller.ID()
+}
+
+func (sb *sandbox) NamespaceID() (uint64, error) {
+	// The host namespace is shared by all the sandboxes using it
+	if sb.config.useDefaultSandBox {
+		return 0, ErrSharedNamespace(sb.id)
+	}
+	return osl.NamespaceID(sb.Key())
+}
+
 func (sb *sandbox) Labels() map[string]interface{} {
 	return sb.config.generic
 }
@@ -126,36 +203,73 @@ func (sb *sandbox) Labels() map[string]interface{} {
 func (sb *sandbox) Statistics() (map[string]*osl.InterfaceStatistics, error) {
 	m := make(map[string]*osl.InterfaceStatistics)
 
-	if sb.osSbox == nil {
-		return m, nil
+	// Interfaces being removed are not listed, the ones in the snapshot
+	// can still go away while their statistics are read
+	sb.Lock()
+	osSbox := sb.osSbox
+	var ifaces []osl.Interface
+	if osSbox != nil {
+		ifaces = osSbox.Info().Interfaces()
 	}
+	sb.Unlock()
 
-	var err error
-	for _, i := range sb.osSbox.Info().Interfaces() {
-		if m[i.DstName()], err = i.Statistics(); err != nil {
+	for _, i := range ifaces {
+		s, err := i.Statistics()
+		if err != nil {
+			if !isListedInterface(osSbox, i) {
+				continue
+			}
 			return m, err
 		}
+		m[i.DstName()] = s
 	}
 
 	return m, nil
 }
 
+func isListedInterface(osSbox osl.Sandbox, iface osl.Interface) bool {
+	for _, i := range osSbox.Info().Interfaces() {
+		if i == iface {
+			return true
+		}
+	}
+	return false
+}
+
 func (sb *sandbox) Delete() error {
 	sb.Lock()
 	c := sb.controller
-	eps := make([]*endpoint, len(sb.endpoints))
-	for i, ep := range sb.endpoints {
-		eps[i] = ep
+	eps := make([]*endpoint, 0, len(sb.endpoints)+len(sb.lazyEps))
+	for _, ep := range sb.endpoints {
+		eps = append(eps, ep)
+	}
+	for _, ep := range sb.lazyEps {
+		eps = append(eps, ep)
 	}
 	sb.Unlock()
 
-	// Detach from all containers
+	// Detach from all containers, in the order of the leave priorities
+	sort.Sort(byLeavePriority(eps))
 	for _, ep := range eps {
 		if err := ep.Leave(sb); err != nil {
 			log.Warnf("Failed detaching sandbox %s from endpoint %s: %v\n", sb.ID(), ep.ID(), err)
 		}
 	}
 
+	// The endpoint on the default network is owned by the sandbox
+	sb.Lock()
+	dep := sb.defaultEp
+	sb.defaultEp = nil
+	sb.Unlock()
+	if dep != nil {
+		if err := dep.Delete(); err != nil {
+			log.Warnf("Failed deleting endpoint %s on the default network for sandbox %s: %v", dep.ID(), sb.ID(), err)
+		}
+	}
+
+	sb.stopResolver()
+	sb.stopLinkMonitor()
+
 	if sb.osSbox != nil {
 		sb.osSbox.Destroy()
 	}
@@ -167,6 +281,197 @@ func (sb *sandbox) Delete() error {
 	return nil
 }
 
+// joinDefaultNetwork connects the sandbox to the controller default network,
+// through an endpoint created for the purpose and deleted with the sandbox.
+func (sb *sandbox) joinDefaultNetwork() error {
+	c := sb.controller
+	c.Lock()
+	nid := c.defaultNw
+	c.Unlock()
+
+	if nid == "" || sb.config.noDefaultNetwork {
+		return nil
+	}
+
+	n, err := c.NetworkByID(nid)
+	if err != nil {
+		return err
+	}
+
+	ep, err := n.CreateEndpoint("sb-"+stringid.TruncateID(sb.id), createOptionOrigin(EndpointOriginAuto))
+	if err != nil {
+		return fmt.Errorf("failed to create endpoint on the default network %s: %v", n.Name(), err)
+	}
+
+	if err := ep.Join(sb); err != nil {
+		if e := ep.Delete(); e != nil {
+			log.Warnf("Failed deleting endpoint %s on the default network: %v", ep.ID(), e)
+		}
+		return fmt.Errorf("failed to join the default network %s: %v", n.Name(), err)
+	}
+
+	sb.Lock()
+	sb.defaultEp = ep.(*endpoint)
+	sb.Unlock()
+
+	return nil
+}
+
+func (sb *sandbox) Activate(ep Endpoint) error {
+	e, ok := ep.(*endpoint)
+	if !ok {
+		return types.BadRequestErrorf("not a valid Endpoint interface")
+	}
+
+	e.joinLeaveStart()
+	defer e.joinLeaveEnd()
+
+	e.Lock()
+	sid := e.sandboxID
+	e.Unlock()
+
+	if sid != sb.ID() {
+		return types.ForbiddenErrorf("endpoint %s is not joined to sandbox %s", e.Name(), sb.ID())
+	}
+
+	sb.Lock()
+	_, ok = sb.lazyEps[e.ID()]
+	sb.Unlock()
+	if !ok {
+		return nil
+	}
+
+	if err := e.createLink(); err != nil {
+		return err
+	}
+
+	if err := sb.populateNetworkResources(e); err != nil {
+		return err
+	}
+
+	sb.Lock()
+	delete(sb.lazyEps, e.ID())
+	sb.notifyPendingDone()
+	sb.Unlock()
+
+	return nil
+}
+
+func (sb *sandbox) PendingEndpoints() []Endpoint {
+	sb.Lock()
+	eps := make([]*endpoint, 0, len(sb.lazyEps))
+	for _, ep := range sb.lazyEps {
+		eps = append(eps, ep)
+	}
+	sb.Unlock()
+
+	sort.Sort(epHeap(eps))
+	l := make([]Endpoint, 0, len(eps))
+	for _, ep := range eps {
+		l = append(l, ep)
+	}
+	return l
+}
+
+func (sb *sandbox) PendingDone() <-chan struct{} {
+	sb.Lock()
+	defer sb.Unlock()
+
+	if len(sb.lazyEps) == 0 {
+		done := make(chan struct{})
+		close(done)
+		return done
+	}
+	if sb.pendingDone == nil {
+		sb.pendingDone = make(chan struct{})
+	}
+	return sb.pendingDone
+}
+
+// notifyPendingDone wakes up the PendingDone waiters if no endpoint is left
+// pending activation. It must be called with the sandbox lock held.
+func (sb *sandbox) notifyPendingDone() {
+	if len(sb.lazyEps) != 0 || sb.pendingDone == nil {
+		return
+	}
+	close(sb.pendingDone)
+	sb.pendingDone = nil
+}
+
+// addLazyEndpoint records a joined endpoint whose network resources are not
+// yet programmed in the sandbox.
+func (sb *sandbox) addLazyEndpoint(ep *endpoint) {
+	sb.Lock()
+	sb.lazyEps[ep.ID()] = ep
+	sb.Unlock()
+}
+
+// removeLazyEndpoint forgets the passed endpoint if it was lazily joined and
+// never activated. It returns true if that was the case.
+func (sb *sandbox) removeLazyEndpoint(ep *endpoint) bool {
+	sb.Lock()
+	defer sb.Unlock()
+
+	if _, ok := sb.lazyEps[ep.ID()]; !ok {
+		return false
+	}
+	delete(sb.lazyEps, ep.ID())
+	sb.notifyPendingDone()
+	return true
+}
+
+// checkParent validates the parent sandbox passed to the join of an endpoint
+func (sb *sandbox) checkParent(parent Sandbox) error {
+	p, ok := parent.(*sandbox)
+	if !ok {
+		return types.BadRequestErrorf("not a valid parent Sandbox interface")
+	}
+	if p == sb {
+		return types.BadRequestErrorf("sandbox %s cannot be its own parent", sb.ID())
+	}
+	if _, err := sb.controller.SandboxByID(p.ID()); err != nil || p.controller != sb.controller {
+		return types.NotFoundErrorf("parent sandbox %s not found", p.ID())
+	}
+	return nil
+}
+
+// reservePorts claims for the endpoint the container side ports it exposes.
+// It fails if another endpoint joined to the sandbox already exposes one of them.
+func (sb *sandbox) reservePorts(ep *endpoint) error {
+	ep.Lock()
+	ports := ep.exposedPorts
+	ep.Unlock()
+
+	sb.Lock()
+	for _, p := range ports {
+		if owner, ok := sb.containerPorts[p]; ok && owner != ep {
+			sb.Unlock()
+			return ErrContainerPortConflict{Port: p, Endpoint: owner.Name()}
+		}
+	}
+	if sb.containerPorts == nil {
+		sb.containerPorts = make(map[types.TransportPort]*endpoint)
+	}
+	for _, p := range ports {
+		sb.containerPorts[p] = ep
+	}
+	sb.Unlock()
+
+	return nil
+}
+
+// releasePorts gives back the container side ports claimed by the endpoint
+func (sb *sandbox) releasePorts(ep *endpoint) {
+	sb.Lock()
+	defer sb.Unlock()
+
+	for p, owner := range sb.containerPorts {
+		if owner == ep {
+			delete(sb.containerPorts, p)
+		}
+	}
+}
+
 func (sb *sandbox) MarshalJSON() ([]byte, error) {
 	sb.Lock()
 	defer sb.Unlock()
@@ -210,32 +515,121 @@ func (sb *sandbox) updateGateway(ep *endpoint) error {
 	return nil
 }
 
+// renumberRoutes programs the routes of the joined endpoint moved to the new
+// subnet of its address, along with the sandbox gateway if the endpoint
+// provides it
+func (sb *sandbox) renumberRoutes(ep *endpoint, moved []*types.StaticRoute) error {
+	ep.Lock()
+	joinInfo := ep.joinInfo
+	ep.Unlock()
+
+	if joinInfo == nil {
+		return nil
+	}
+
+	osSbox := sb.osSbox
+	parent := joinInfo.parentSandbox()
+	if parent != nil {
+		osSbox = parent.osSbox
+	}
+
+	for _, r := range moved {
+		r.Table = joinInfo.routingTable
+		if err := osSbox.AddStaticRoute(r); err != nil {
+			return fmt.Errorf("failed to add static route %s: %v", r.Destination.String(), err)
+		}
+	}
+
+	if joinInfo.routingTable != 0 {
+		if err := osSbox.AddSourceRoute(ep.getFirstInterfaceNetwork(), joinInfo.gw, joinInfo.routingTable); err != nil {
+			return fmt.Errorf("failed to set routing table %d for endpoint %s: %v", joinInfo.routingTable, ep.Name(), err)
+		}
+	}
+
+	if parent != nil {
+		return nil
+	}
+
+	sb.Lock()
+	gwEp := len(sb.endpoints) != 0 && sb.endpoints[0] == ep
+	sb.Unlock()
+	if gwEp {
+		return sb.updateGateway(ep)
+	}
+
+	return nil
+}
+
 func (sb *sandbox) populateNetworkResources(ep *endpoint) error {
 	ep.Lock()
 	joinInfo := ep.joinInfo
 	ifaces := ep.iFaces
+	promisc := ep.promiscuous
+	macFromIP := ep.macFromIP
+	primary := ep.primary
 	ep.Unlock()
 
+	owner := sb
+	parent := joinInfo.parentSandbox()
+	if parent != nil {
+		owner = parent
+	}
+	osSbox := owner.osSbox
+
 	for _, i := range ifaces {
 		var ifaceOptions []osl.IfaceOption
 
-		ifaceOptions = append(ifaceOptions, sb.osSbox.InterfaceOptions().Address(&i.addr), sb.osSbox.InterfaceOptions().Routes(i.routes))
+		ifaceOptions = append(ifaceOptions, osSbox.InterfaceOptions().Routes(i.routes))
+		if promisc {
+			ifaceOptions = append(ifaceOptions, osSbox.InterfaceOptions().Promiscuous(true))
+		}
+		if primary {
+			ifaceOptions = append(ifaceOptions, osSbox.InterfaceOptions().Primary(true))
+		}
+		if macFromIP && len(i.mac) != 0 {
+			ifaceOptions = append(ifaceOptions, osSbox.InterfaceOptions().MacAddress(i.mac))
+		}
+		if len(i.addr.IP) != 0 {
+			ifaceOptions = append(ifaceOptions, osSbox.InterfaceOptions().Address(&i.addr))
+		}
 		if i.addrv6.IP.To16() != nil {
-			ifaceOptions = append(ifaceOptions, sb.osSbox.InterfaceOptions().AddressIPv6(&i.addrv6))
+			ifaceOptions = append(ifaceOptions, osSbox.InterfaceOptions().AddressIPv6(&i.addrv6))
 		}
 
-		if err := sb.osSbox.AddInterface(i.srcName, i.dstPrefix, ifaceOptions...); err != nil {
+		if err := osSbox.AddInterface(i.srcName, i.dstPrefix, ifaceOptions...); err != nil {
 			return fmt.Errorf("failed to add interface %s to sandbox: %v", i.srcName, err)
 		}
+
+		// The traffic is classified as the one of the namespace owner
+		for _, si := range osSbox.Info().Interfaces() {
+			if si.SrcName() == i.srcName {
+				if err := owner.setNetCls(si.DstName(), true); err != nil {
+					return err
+				}
+			}
+		}
 	}
 
 	if joinInfo != nil {
-		// Set up non-interface routes.
+		// Set up non-interface routes, in the endpoint routing table if any
 		for _, r := range joinInfo.StaticRoutes {
-			if err := sb.osSbox.AddStaticRoute(r); err != nil {
+			r.Table = joinInfo.routingTable
+			if err := osSbox.AddStaticRoute(r); err != nil {
 				return fmt.Errorf("failed to add static route %s: %v", r.Destination.String(), err)
 			}
 		}
+
+		// Route the traffic sourced from the endpoint through its own gateway
+		if joinInfo.routingTable != 0 {
+			if err := osSbox.AddSourceRoute(ep.getFirstInterfaceNetwork(), joinInfo.gw, joinInfo.routingTable); err != nil {
+				return fmt.Errorf("failed to set routing table %d for endpoint %s: %v", joinInfo.routingTable, ep.Name(), err)
+			}
+		}
+	}
+
+	// The endpoint does not take part in the routing of the nested sandbox
+	if parent != nil {
+		return nil
 	}
 
 	sb.Lock()
@@ -248,31 +642,79 @@ func (sb *sandbox) populateNetworkResources(ep *endpoint) error {
 		}
 	}
 
+	sb.startLinkMonitor()
+
+	return nil
+}
+
+// endpointByInterface returns the endpoint joined to the sandbox whose
+// interface has the passed name in the sandbox, nil if there is none
+func (sb *sandbox) endpointByInterface(name string) *endpoint {
+	var srcName string
+	for _, si := range sb.osSbox.Info().Interfaces() {
+		if si.DstName() == name {
+			srcName = si.SrcName()
+		}
+	}
+	if srcName == "" {
+		return nil
+	}
+
+	sb.Lock()
+	eps := make([]*endpoint, len(sb.endpoints))
+	copy(eps, sb.endpoints)
+	sb.Unlock()
+
+	for _, ep := range eps {
+		if ep.hasInterface(srcName) {
+			return ep
+		}
+	}
 	return nil
 }
 
 func (sb *sandbox) clearNetworkResources(ep *endpoint) error {
+	ep.Lock()
+	joinInfo := ep.joinInfo
+	ep.Unlock()
+
+	owner := sb
+	parent := joinInfo.parentSandbox()
+	if parent != nil {
+		owner = parent
+	}
+	osSbox := owner.osSbox
 
-	for _, i := range sb.osSbox.Info().Interfaces() {
+	// The gateway route of the table goes along with the interface
+	if joinInfo.routingTable != 0 {
+		if err := osSbox.RemoveSourceRoute(ep.getFirstInterfaceNetwork(), joinInfo.gw, joinInfo.routingTable); err != nil {
+			log.Debugf("Remove routing table %d failed: %v", joinInfo.routingTable, err)
+		}
+	}
+
+	for _, i := range osSbox.Info().Interfaces() {
 		// Only remove the interfaces owned by this endpoint from the sandbox.
 		if ep.hasInterface(i.SrcName()) {
+			if err := owner.setNetCls(i.DstName(), false); err != nil {
+				log.Warnf("Failed to remove the net_cls classification of interface %s: %v", i.DstName(), err)
+			}
 			if err := i.Remove(); err != nil {
 				log.Debugf("Remove interface failed: %v", err)
 			}
 		}
 	}
 
-	ep.Lock()
-	joinInfo := ep.joinInfo
-	ep.Unlock()
-
 	// Remove non-interface routes.
 	for _, r := range joinInfo.StaticRoutes {
-		if err := sb.osSbox.RemoveStaticRoute(r); err != nil {
+		if err := osSbox.RemoveStaticRoute(r); err != nil {
 			log.Debugf("Remove route failed: %v", err)
 		}
 	}
 
+	if parent != nil {
+		return nil
+	}
+
 	sb.Lock()
 	if len(sb.endpoints) == 0 {
 		// sb.endpoints should never be empty and this is unexpected error condition
@@ -365,6 +807,55 @@ func (sb *sandbox) deleteHostsEntries(recs []etchosts.Record) {
 	}
 }
 
+// joinedEndpoints returns the endpoints joined to the sandbox, lazily or not
+func (sb *sandbox) joinedEndpoints() []*endpoint {
+	sb.Lock()
+	defer sb.Unlock()
+
+	eps := make([]*endpoint, 0, len(sb.endpoints)+len(sb.lazyEps))
+	eps = append(eps, sb.endpoints...)
+	for _, ep := range sb.lazyEps {
+		eps = append(eps, ep)
+	}
+
+	return eps
+}
+
+// linkRecords returns the hosts file records of the links of the endpoints joined to the sandbox
+func (sb *sandbox) linkRecords() []etchosts.Record {
+	var recs []etchosts.Record
+	for _, ep := range sb.joinedEndpoints() {
+		recs = append(recs, ep.linkRecords()...)
+	}
+
+	return recs
+}
+
+// updateLinks rewrites the hosts file entries of the sandboxes linking to the
+// target endpoint with its current address
+func (c *controller) updateLinks(target *endpoint) {
+	ip := target.getFirstInterfaceAddress()
+	if ip == nil {
+		return
+	}
+
+	c.Lock()
+	sandboxes := make([]*sandbox, 0, len(c.sandboxes))
+	for _, sb := range c.sandboxes {
+		sandboxes = append(sandboxes, sb)
+	}
+	c.Unlock()
+
+	for _, sb := range sandboxes {
+		for _, ep := range sb.joinedEndpoints() {
+			for _, alias := range ep.linksTo(target) {
+				sb.deleteHostsEntries([]etchosts.Record{{Hosts: alias}})
+				sb.addHostsEntries([]etchosts.Record{{Hosts: alias, IP: ip.String()}})
+			}
+		}
+	}
+}
+
 func (sb *sandbox) updateParentHosts() error {
 	var pSb Sandbox
 
@@ -395,10 +886,11 @@ func (sb *sandbox) setupDNS() error {
 
 	// This is for the host mode networking
 	if sb.config.originResolvConfPath != "" {
-		if err := copyFile(sb.config.originResolvConfPath, sb.config.resolvConfPath); err != nil {
+		resolvConf, err := ioutil.ReadFile(sb.config.originResolvConfPath)
+		if err != nil {
 			return fmt.Errorf("could not copy source resolv.conf file %s to %s: %v", sb.config.originResolvConfPath, sb.config.resolvConfPath, err)
 		}
-		return nil
+		return sb.writeResolvConf(resolvConf)
 	}
 
 	resolvConf, err := resolvconf.Get()
@@ -421,88 +913,251 @@ func (sb *sandbox) setupDNS() error {
 		}
 	}
 
-	hash, err := resolvconf.Build(sb.config.resolvConfPath, dnsList, dnsSearchList, dnsOptionsList)
-	if err != nil {
+	if dnsOptionsList, err = sb.config.mergeDNSResolverOpts(dnsOptionsList); err != nil {
 		return err
 	}
 
-	// write hash
-	if err := ioutil.WriteFile(sb.config.resolvConfHashFile, []byte(hash), filePerm); err != nil {
-		return types.InternalErrorf("failed to write resol.conf hash file when setting up dns for sandbox %s: %v", sb.ID(), err)
+	if err := sb.writeResolvConf(resolvconf.Content(dnsList, dnsSearchList, dnsOptionsList)); err != nil {
+		return err
 	}
+	sb.config.dnsSearchBase = dnsSearchList
 
 	return nil
 }
 
-func (sb *sandbox) updateDNS(ipv6Enabled bool) error {
-	var oldHash []byte
-	hashFile := sb.config.resolvConfHashFile
+// writeResolvConf passes the resolv.conf content libnetwork generated for the
+// sandbox through the controller generator, if any, and writes the result to
+// the sandbox resolv.conf file along with its hash. The file is written once,
+// so the generator output is the only content the container sees.
+func (sb *sandbox) writeResolvConf(base []byte) error {
+	content := base
+	if sb.controller != nil {
+		if gen := sb.controller.resolvConfGenerator(); gen != nil {
+			var err error
+			if content, err = gen(sb, base); err != nil {
+				return fmt.Errorf("resolv.conf generator failed for sandbox %s: %v", sb.ID(), err)
+			}
+		}
+	}
 
+	if err := fileutils.WriteFile(sb.config.resolvConfPath, content, filePerm); err != nil {
+		return err
+	}
+	sb.config.resolvConfBase = base
+
+	hash, err := ioutils.HashData(bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	if err := fileutils.AtomicWriteFile(sb.config.resolvConfHashFile, []byte(hash), filePerm); err != nil {
+		return types.InternalErrorf("failed to write resol.conf hash file for sandbox %s: %v", sb.ID(), err)
+	}
+	return nil
+}
+
+// resolvConfTouched tells whether the sandbox resolv.conf file was changed by
+// the user since libnetwork last wrote it
+func (sb *sandbox) resolvConfTouched() (bool, error) {
 	resolvConf, err := ioutil.ReadFile(sb.config.resolvConfPath)
 	if err != nil {
-		if !os.IsNotExist(err) {
-			return err
+		if os.IsNotExist(err) {
+			return false, nil
 		}
-	} else {
-		oldHash, err = ioutil.ReadFile(hashFile)
-		if err != nil {
-			if !os.IsNotExist(err) {
-				return err
-			}
+		return false, err
+	}
+	oldHash, err := ioutil.ReadFile(sb.config.resolvConfHashFile)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	curHash, err := ioutils.HashData(bytes.NewReader(resolvConf))
+	if err != nil {
+		return false, err
+	}
+	return len(oldHash) != 0 && curHash != string(oldHash), nil
+}
+
+// resolvConfBaseContent returns the resolv.conf content libnetwork generated
+// for the sandbox, which the updates start from. It is read from the file when
+// unknown, as for a sandbox restored after a restart.
+func (sb *sandbox) resolvConfBaseContent() ([]byte, error) {
+	if sb.config.resolvConfBase != nil {
+		return sb.config.resolvConfBase, nil
+	}
+	resolvConf, err := ioutil.ReadFile(sb.config.resolvConfPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return resolvConf, nil
+}
 
-			oldHash = []byte{}
+// mergeDNSResolverOpts returns the passed resolv.conf options with the ndots,
+// timeout and attempts values set for the sandbox. Those replace the options of
+// the same name, the other options are kept as they are.
+func (cfg *resolvConfPathConfig) mergeDNSResolverOpts(options []string) ([]string, error) {
+	if len(cfg.dnsResolverOpts) == 0 {
+		return options, nil
+	}
+
+	merged := make([]string, 0, len(options)+len(cfg.dnsResolverOpts))
+	for _, opt := range options {
+		name := strings.SplitN(opt, ":", 2)[0]
+		if _, ok := cfg.dnsResolverOpts[name]; !ok {
+			merged = append(merged, opt)
 		}
 	}
 
-	curHash, err := ioutils.HashData(bytes.NewReader(resolvConf))
-	if err != nil {
-		return err
+	for _, l := range dnsResolverOptLimits {
+		v, ok := cfg.dnsResolverOpts[l.name]
+		if !ok {
+			continue
+		}
+		if v < l.min || v > l.max {
+			return nil, types.BadRequestErrorf("invalid dns option %s:%d, must be in the range %d-%d", l.name, v, l.min, l.max)
+		}
+		merged = append(merged, fmt.Sprintf("%s:%d", l.name, v))
 	}
 
-	if string(oldHash) != "" && curHash != string(oldHash) {
-		// Seems the user has changed the container resolv.conf since the last time
-		// we checked so return without doing anything.
-		log.Infof("Skipping update of resolv.conf file with ipv6Enabled: %t because file was touched by user", ipv6Enabled)
+	return merged, nil
+}
+
+// maxDNSSearch is the number of search domains the resolver honors
+const maxDNSSearch = 6
+
+// mergeSearchDomains returns the base search domains followed by the network
+// domains, passed in priority order, without duplicates. The list is capped at
+// the resolver limit by dropping the lowest priority domains.
+func mergeSearchDomains(base, domains []string) []string {
+	merged := []string{}
+	seen := make(map[string]bool)
+	for _, d := range append(append([]string{}, base...), domains...) {
+		if d == "" || seen[d] {
+			continue
+		}
+		seen[d] = true
+		merged = append(merged, d)
+	}
+
+	if len(merged) > maxDNSSearch {
+		log.Warnf("Dropping search domains %v exceeding the resolver limit of %d", merged[maxDNSSearch:], maxDNSSearch)
+		merged = merged[:maxDNSSearch]
+	}
+	return merged
+}
+
+// updateSearchDomains rewrites the resolv.conf search list with the sandbox own
+// search domains followed by the domains of the networks it is joined to, in
+// the priority order of its endpoints
+func (sb *sandbox) updateSearchDomains() error {
+	// This is for the host mode networking
+	if sb.config.originResolvConfPath != "" {
 		return nil
 	}
 
-	// replace any localhost/127.* and remove IPv6 nameservers if IPv6 disabled.
-	resolvConf, _ = resolvconf.FilterResolvDNS(resolvConf, ipv6Enabled)
+	eps := sb.joinedEndpoints()
+	sort.Sort(epHeap(eps))
+	var domains []string
+	for _, ep := range eps {
+		domains = append(domains, ep.getNetwork().getDomain())
+	}
+	search := mergeSearchDomains(sb.config.dnsSearchBase, domains)
 
-	newHash, err := ioutils.HashData(bytes.NewReader(resolvConf))
+	resolvConf, err := sb.resolvConfBaseContent()
 	if err != nil {
 		return err
 	}
+	if strings.Join(resolvconf.GetSearchDomains(resolvConf), " ") == strings.Join(search, " ") {
+		return nil
+	}
 
-	// for atomic updates to these files, use temporary files with os.Rename:
-	dir := path.Dir(sb.config.resolvConfPath)
-	tmpHashFile, err := ioutil.TempFile(dir, "hash")
+	touched, err := sb.resolvConfTouched()
 	if err != nil {
 		return err
 	}
-	tmpResolvFile, err := ioutil.TempFile(dir, "resolv")
+	if touched {
+		log.Infof("Skipping update of resolv.conf search domains because file was touched by user")
+		return nil
+	}
+
+	return sb.writeResolvConf(resolvconf.Content(resolvconf.GetNameservers(resolvConf), search, resolvconf.GetOptions(resolvConf)))
+}
+
+func (sb *sandbox) updateDNS(ipv6Enabled bool) error {
+	// This is for the host mode networking
+	if sb.config.originResolvConfPath != "" {
+		return nil
+	}
+
+	// The filtering would drop the embedded resolver local address
+	sb.Lock()
+	fwd := sb.resolver
+	sb.Unlock()
+	if fwd != nil {
+		return nil
+	}
+
+	touched, err := sb.resolvConfTouched()
 	if err != nil {
 		return err
 	}
+	if touched {
+		// Seems the user has changed the container resolv.conf since the last time
+		// we checked so return without doing anything.
+		log.Infof("Skipping update of resolv.conf file with ipv6Enabled: %t because file was touched by user", ipv6Enabled)
+		return nil
+	}
 
-	// Change the perms to filePerm (0644) since ioutil.TempFile creates it by default as 0600
-	if err := os.Chmod(tmpResolvFile.Name(), filePerm); err != nil {
+	resolvConf, err := sb.resolvConfBaseContent()
+	if err != nil {
 		return err
 	}
 
-	// write the updates to the temp files
-	if err = ioutil.WriteFile(tmpHashFile.Name(), []byte(newHash), filePerm); err != nil {
-		return err
+	// replace any localhost/127.* and remove IPv6 nameservers if IPv6 disabled.
+	resolvConf, _ = resolvconf.FilterResolvDNS(resolvConf, ipv6Enabled)
+
+	return sb.writeResolvConf(resolvConf)
+}
+
+// netClsRule returns the iptables rule which classifies the packets leaving
+// the sandbox through the named interface with the passed net_cls classid, in
+// major:minor form.
+func netClsRule(ifName string, classid uint32) []string {
+	return []string{"-o", ifName, "-j", "CLASSIFY", "--set-class", fmt.Sprintf("%x:%x", classid>>16, classid&0xffff)}
+}
+
+// setNetCls installs/removes the rule classifying the packets leaving the
+// sandbox through the named interface with the sandbox net_cls classid
+func (sb *sandbox) setNetCls(ifName string, enable bool) error {
+	if sb.config.netClsClassID == 0 {
+		return nil
 	}
-	if err = ioutil.WriteFile(tmpResolvFile.Name(), resolvConf, filePerm); err != nil {
-		return err
+
+	var err error
+	rule := netClsRule(ifName, sb.config.netClsClassID)
+	if nErr := sb.osSbox.InvokeFunc(func() {
+		if iptables.Exists(iptables.Mangle, "POSTROUTING", rule...) == enable {
+			return
+		}
+		op := "-A"
+		if !enable {
+			op = "-D"
+		}
+		args := append([]string{"-t", string(iptables.Mangle), op, "POSTROUTING"}, rule...)
+		output, rErr := iptables.Exec(args...)
+		if rErr != nil {
+			err = rErr
+			return
+		}
+		if len(output) != 0 {
+			err = &iptables.ChainError{Chain: "POSTROUTING", Output: output}
+		}
+	}); nErr != nil {
+		return nErr
 	}
 
-	// rename the temp files for atomic replace
-	if err = os.Rename(tmpHashFile.Name(), hashFile); err != nil {
-		return err
+	if err != nil {
+		return fmt.Errorf("failed to program net_cls classid %#x on interface %s: %v", sb.config.netClsClassID, ifName, err)
 	}
-	return os.Rename(tmpResolvFile.Name(), sb.config.resolvConfPath)
+	return nil
 }
 
 // OptionHostname function returns an option setter for hostname option to
@@ -593,6 +1248,46 @@ func OptionDNSOptions(options string) SandboxOption {
 	}
 }
 
+// OptionDNSNdots function returns an option setter for the ndots value of the
+// resolv.conf options, to be passed to container Create method.
+func OptionDNSNdots(ndots int) SandboxOption {
+	return func(sb *sandbox) {
+		sb.config.setDNSResolverOpt("ndots", ndots)
+	}
+}
+
+// OptionDNSTimeout function returns an option setter for the timeout value, in
+// seconds, of the resolv.conf options, to be passed to container Create method.
+func OptionDNSTimeout(timeout int) SandboxOption {
+	return func(sb *sandbox) {
+		sb.config.setDNSResolverOpt("timeout", timeout)
+	}
+}
+
+// OptionDNSAttempts function returns an option setter for the attempts value of
+// the resolv.conf options, to be passed to container Create method.
+func OptionDNSAttempts(attempts int) SandboxOption {
+	return func(sb *sandbox) {
+		sb.config.setDNSResolverOpt("attempts", attempts)
+	}
+}
+
+func (cfg *resolvConfPathConfig) setDNSResolverOpt(name string, value int) {
+	if cfg.dnsResolverOpts == nil {
+		cfg.dnsResolverOpts = make(map[string]int)
+	}
+	cfg.dnsResolverOpts[name] = value
+}
+
+// OptionEmbeddedResolverLast function returns an option setter placing the
+// embedded resolver after the host nameservers in the sandbox resolv.conf,
+// instead of first, to be passed to NewSandbox method.
+func OptionEmbeddedResolverLast() SandboxOption {
+	return func(sb *sandbox) {
+		sb.config.resolverLast = true
+	}
+}
+
 // OptionUseDefaultSandbox function returns an option setter for using default sandbox to
 // be passed to container Create method.
 func OptionUseDefaultSandbox() SandboxOption {
@@ -601,6 +1296,23 @@ func OptionUseDefaultSandbox() SandboxOption {
 	}
 }
 
+// OptionNoDefaultNetwork function returns an option setter to keep the sandbox
+// off the controller default network, to be passed to NewSandbox method.
+func OptionNoDefaultNetwork() SandboxOption {
+	return func(sb *sandbox) {
+		sb.config.noDefaultNetwork = true
+	}
+}
+
+// OptionNetClsClassID function returns an option setter for the net_cls classid
+// to be set on the traffic leaving the sandbox interfaces, to be passed to
+// NewSandbox method. It cannot be combined with OptionUseDefaultSandbox.
+func OptionNetClsClassID(classid uint32) SandboxOption {
+	return func(sb *sandbox) {
+		sb.config.netClsClassID = classid
+	}
+}
+
 // OptionGeneric function returns an option setter for Generic configuration
 // that is not managed by libNetwork but can be used by the Drivers during the call to
 // net container creation method. Container Labels are a good example.
@@ -613,6 +1325,11 @@ func OptionGeneric(generic map[string]interface{}) SandboxOption {
 func (eh epHeap) Len() int { return len(eh) }
 
 func (eh epHeap) Less(i, j int) bool {
+	// The primary endpoint supplies the gateway whatever the priorities
+	if pi, pj := eh[i].isPrimary(), eh[j].isPrimary(); pi != pj {
+		return pi
+	}
+
 	ci, _ := eh[i].getSandbox()
 	cj, _ := eh[j].getSandbox()
 
@@ -671,5 +1388,20 @@ func copyFile(src, dst string) error {
 	if err != nil {
 		return err
 	}
-	return ioutil.WriteFile(dst, sBytes, filePerm)
+	return fileutils.WriteFile(dst, sBytes, filePerm)
+}
+
+// byLeavePriority sorts the endpoints by decreasing leave priority, then by
+// network name
+type byLeavePriority []*endpoint
+
+func (eps byLeavePriority) Len() int      { return len(eps) }
+func (eps byLeavePriority) Swap(i, j int) { eps[i], eps[j] = eps[j], eps[i] }
+func (eps byLeavePriority) Less(i, j int) bool {
+	pi, ni := eps[i].leaveOrder()
+	pj, nj := eps[j].leaveOrder()
+	if pi != pj {
+		return pi > pj
+	}
+	return ni < nj
 }
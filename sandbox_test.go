@@ -1,16 +1,25 @@
 package libnetwork
 
 import (
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/docker/libnetwork/iptables"
 	"github.com/docker/libnetwork/netlabel"
 	"github.com/docker/libnetwork/netutils"
 	"github.com/docker/libnetwork/options"
 	"github.com/docker/libnetwork/osl"
+	"github.com/vishvananda/netlink"
 )
 
 func createEmptyCtrlr() *controller {
-	return &controller{sandboxes: sandboxTable{}}
+	backend, _ := osl.GetBackend(osl.NamespaceBackend)
+	return &controller{sandboxes: sandboxTable{}, creatingSb: sandboxTable{}, sboxBackend: backend}
 }
 
 func createEmptyEndpoint() *endpoint {
@@ -82,6 +91,63 @@ func TestSandboxAddEmpty(t *testing.T) {
 	osl.GC()
 }
 
+// gatedBackend holds the sandbox creations until released
+type gatedBackend struct {
+	osl.Backend
+	entered chan struct{}
+	release chan struct{}
+	sync.Mutex
+	calls int
+}
+
+func (b *gatedBackend) NewSandbox(key string, osCreate bool) (osl.Sandbox, error) {
+	b.Lock()
+	b.calls++
+	b.Unlock()
+	b.entered <- struct{}{}
+	<-b.release
+	return b.Backend.NewSandbox(key, osCreate)
+}
+
+func TestSandboxConcurrentSameContainer(t *testing.T) {
+	ctrlr := createEmptyCtrlr()
+	backend := &gatedBackend{Backend: ctrlr.sboxBackend, entered: make(chan struct{}, 2), release: make(chan struct{})}
+	ctrlr.sboxBackend = backend
+
+	type result struct {
+		sb  Sandbox
+		err error
+	}
+	results := make(chan result, 2)
+	create := func() {
+		sb, err := ctrlr.NewSandbox("sandbox-dup")
+		results <- result{sb, err}
+	}
+
+	// The second creation starts while the first one is in flight
+	go create()
+	<-backend.entered
+	go create()
+	time.Sleep(50 * time.Millisecond)
+	close(backend.release)
+
+	r1, r2 := <-results, <-results
+	if r1.err != nil || r2.err != nil {
+		t.Fatalf("Failed to create the sandboxes: %v, %v", r1.err, r2.err)
+	}
+	if r1.sb.ID() != r2.sb.ID() {
+		t.Fatalf("Expected the same sandbox for the container, got %s and %s", r1.sb.ID(), r2.sb.ID())
+	}
+	if backend.calls != 1 || len(ctrlr.sandboxes) != 1 {
+		t.Fatalf("Expected a single sandbox, got %d creations and %d sandboxes", backend.calls, len(ctrlr.sandboxes))
+	}
+
+	if err := r1.sb.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	osl.GC()
+}
+
 func TestSandboxAddMultiPrio(t *testing.T) {
 	if !netutils.IsRunningInContainer() {
 		defer netutils.SetupTestNetNS(t)()
@@ -217,3 +283,604 @@ func TestSandboxAddSamePrio(t *testing.T) {
 
 	osl.GC()
 }
+
+func TestSandboxLazyJoin(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	c, nw, _ := getTestEnv(t)
+	ctrlr := c.(*controller)
+
+	sbx, err := ctrlr.NewSandbox("sandbox1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep, err := nw.CreateEndpoint("ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ep.Join(sbx, JoinOptionLazy()); err != nil {
+		t.Fatal(err)
+	}
+
+	osSbox := sbx.(*sandbox).osSbox
+	if n := len(osSbox.Info().Interfaces()); n != 0 {
+		t.Fatalf("Expected no interface in the sandbox before activation. Found %d", n)
+	}
+
+	if err := sbx.Activate(ep); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(osSbox.Info().Interfaces()); n != 1 {
+		t.Fatalf("Expected one interface in the sandbox after activation. Found %d", n)
+	}
+
+	// A second activation must be a no-op
+	if err := sbx.Activate(ep); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ep.Leave(sbx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sbx.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	osl.GC()
+}
+
+func TestSandboxLazyCreate(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	c, nw, _ := getTestEnv(t)
+	ctrlr := c.(*controller)
+
+	sbx, err := ctrlr.NewSandbox("sandbox1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep, err := nw.CreateEndpoint("ep1", CreateOptionLazy())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ep.Info().InterfaceList()[0].Address().IP == nil {
+		t.Fatal("Expected the address of the lazy endpoint to be reserved at creation")
+	}
+
+	// No JoinOptionLazy, the joins of a lazy endpoint are all lazy
+	if err := ep.Join(sbx); err != nil {
+		t.Fatal(err)
+	}
+
+	srcName := ep.(*endpoint).iFaces[0].srcName
+	if _, err := netlink.LinkByName(srcName); err == nil {
+		t.Fatalf("Expected no interface %s before activation", srcName)
+	}
+
+	osSbox := sbx.(*sandbox).osSbox
+	if n := len(osSbox.Info().Interfaces()); n != 0 {
+		t.Fatalf("Expected no interface in the sandbox before activation. Found %d", n)
+	}
+
+	if err := sbx.Activate(ep); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(osSbox.Info().Interfaces()); n != 1 {
+		t.Fatalf("Expected one interface in the sandbox after activation. Found %d", n)
+	}
+
+	if err := ep.Leave(sbx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ep.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sbx.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	osl.GC()
+}
+
+func TestSandboxPendingEndpoints(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	c, nw, _ := getTestEnv(t)
+	ctrlr := c.(*controller)
+
+	sbx, err := ctrlr.NewSandbox("sandbox1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep1, err := nw.CreateEndpoint("ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep2, err := nw.CreateEndpoint("ep2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ep := range []Endpoint{ep1, ep2} {
+		if err := ep.Join(sbx, JoinOptionLazy()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := len(sbx.PendingEndpoints()); n != 2 {
+		t.Fatalf("Expected two pending endpoints. Found %d", n)
+	}
+
+	done := sbx.PendingDone()
+
+	if err := sbx.Activate(ep1); err != nil {
+		t.Fatal(err)
+	}
+
+	pending := sbx.PendingEndpoints()
+	if len(pending) != 1 || pending[0].ID() != ep2.ID() {
+		t.Fatalf("Expected ep2 to be the only pending endpoint. Found %v", pending)
+	}
+
+	select {
+	case <-done:
+		t.Fatal("Pending done signalled while ep2 is still pending")
+	default:
+	}
+
+	if err := sbx.Activate(ep2); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(sbx.PendingEndpoints()); n != 0 {
+		t.Fatalf("Expected no pending endpoint. Found %d", n)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Pending done not signalled after all endpoints were activated")
+	}
+
+	if err := sbx.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	osl.GC()
+}
+
+func TestSandboxNetClsClassID(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	c, nw, _ := getTestEnv(t)
+	ctrlr := c.(*controller)
+
+	classid := uint32(0x100001)
+	if _, err := ctrlr.NewSandbox("sandbox0", OptionNetClsClassID(classid), OptionUseDefaultSandbox()); err == nil {
+		t.Fatal("Expected the classid to be refused on a sandbox using the host network namespace")
+	}
+
+	sbx, err := ctrlr.NewSandbox("sandbox1", OptionNetClsClassID(classid))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep, err := nw.CreateEndpoint("ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ep.Join(sbx); err != nil {
+		t.Fatal(err)
+	}
+
+	rule := netClsRule("eth0", classid)
+	expected := []string{"-o", "eth0", "-j", "CLASSIFY", "--set-class", "10:1"}
+	if !reflect.DeepEqual(rule, expected) {
+		t.Fatalf("Unexpected classification rule. Expected %v. Got %v", expected, rule)
+	}
+
+	ruleExists := func() bool {
+		var found bool
+		if err := sbx.(*sandbox).osSbox.InvokeFunc(func() {
+			found = iptables.Exists(iptables.Mangle, "POSTROUTING", rule...)
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return found
+	}
+	if !ruleExists() {
+		t.Fatalf("Classification rule %v not programmed in the sandbox", rule)
+	}
+
+	info, err := ep.DriverInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := info[netlabel.NetClsClassID]; !ok || v.(uint32) != classid {
+		t.Fatalf("Expected classid %#x in driver info. Got %v", classid, info)
+	}
+
+	if err := ep.Leave(sbx); err != nil {
+		t.Fatal(err)
+	}
+	if ruleExists() {
+		t.Fatalf("Classification rule %v left in the sandbox after the endpoint left", rule)
+	}
+
+	if err := ep.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sbx.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	osl.GC()
+}
+
+func TestEndpointVerify(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	c, nw, _ := getTestEnv(t)
+	ctrlr := c.(*controller)
+
+	sbx, err := ctrlr.NewSandbox("sandbox1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep, err := nw.CreateEndpoint("ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ep.Verify(); err == nil {
+		t.Fatal("Expected failure verifying an endpoint not joined to a sandbox")
+	}
+
+	if err := ep.Join(sbx); err != nil {
+		t.Fatal(err)
+	}
+
+	dl, err := ep.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dl) != 0 {
+		t.Fatalf("Expected no discrepancies for a freshly joined endpoint. Got %v", dl)
+	}
+
+	// Remove the default route from the sandbox behind libnetwork's back
+	gw := ep.Info().Gateway()
+	if err := sbx.(*sandbox).osSbox.InvokeFunc(func() {
+		routes, rErr := netlink.RouteList(nil, netlink.FAMILY_V4)
+		if rErr != nil {
+			err = rErr
+			return
+		}
+		for _, r := range routes {
+			if r.Dst == nil && gw.Equal(r.Gw) {
+				err = netlink.RouteDel(&r)
+				return
+			}
+		}
+		err = fmt.Errorf("default route via %s not found", gw)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dl, err = ep.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dl) != 1 || dl[0].Resource != "gateway" || dl[0].Expected != gw.String() {
+		t.Fatalf("Expected the missing gateway %s to be reported. Got %v", gw, dl)
+	}
+
+	if err := ep.Leave(sbx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sbx.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	osl.GC()
+}
+
+func TestEndpointTxQueueLen(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	c, nw, _ := getTestEnv(t)
+	ctrlr := c.(*controller)
+
+	sbx, err := ctrlr.NewSandbox("sandbox1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep, err := nw.CreateEndpoint("ep1", CreateOptionTxQueueLen(2000), CreateOptionOffload("tso", false))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := ep.DriverInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if qlen, ok := info[netlabel.TxQueueLen]; !ok || qlen != 2000 {
+		t.Fatalf("Expected the transmit queue length in the driver info. Got %v", info)
+	}
+	if offloads, ok := info[netlabel.Offloads].(map[string]bool); !ok || offloads["tso"] {
+		t.Fatalf("Expected tso turned off in the driver info. Got %v", info)
+	}
+
+	if err := ep.Join(sbx); err != nil {
+		t.Fatal(err)
+	}
+
+	ifaces := sbx.(*sandbox).osSbox.Info().Interfaces()
+	if len(ifaces) != 1 {
+		t.Fatalf("Expected one interface in the sandbox. Got %d", len(ifaces))
+	}
+
+	var qlen int
+	if err := sbx.(*sandbox).osSbox.InvokeFunc(func() {
+		link, lErr := netlink.LinkByName(ifaces[0].DstName())
+		if lErr != nil {
+			err = lErr
+			return
+		}
+		qlen = link.Attrs().TxQLen
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if qlen != 2000 {
+		t.Fatalf("Expected a transmit queue length of 2000 inside the sandbox. Got %d", qlen)
+	}
+
+	if err := ep.Leave(sbx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sbx.Delete(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSandboxDefaultNetwork(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	c, nw, _ := getTestEnv(t)
+
+	if err := c.SetDefaultNetwork("badid"); err == nil {
+		t.Fatal("Expected failure setting an unknown default network")
+	}
+	if err := c.SetDefaultNetwork(nw.ID()); err != nil {
+		t.Fatal(err)
+	}
+
+	sbx1, err := c.NewSandbox("sandbox1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eps := nw.Endpoints()
+	if len(eps) != 1 || eps[0].Info().Sandbox() == nil || eps[0].Info().Sandbox().ID() != sbx1.ID() {
+		t.Fatalf("Expected the sandbox to be joined to the default network. Got endpoints %v", eps)
+	}
+
+	if err := sbx1.(*sandbox).osSbox.InvokeFunc(func() {
+		_, err = netlink.LinkByName("eth0")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err != nil {
+		t.Fatalf("Expected eth0 in the sandbox: %v", err)
+	}
+
+	sbx2, err := c.NewSandbox("sandbox2", OptionNoDefaultNetwork())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(nw.Endpoints()); n != 1 {
+		t.Fatalf("Expected the opted out sandbox not to be joined to the default network. Got %d endpoints", n)
+	}
+
+	if err := sbx1.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(nw.Endpoints()); n != 0 {
+		t.Fatalf("Expected the default network endpoint to be deleted with the sandbox. Got %d endpoints", n)
+	}
+
+	if err := sbx2.Delete(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSandboxNamespaceID(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sbx1, err := c.NewSandbox("sandbox1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := sbx1.NamespaceID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id == 0 {
+		t.Fatal("Expected a non zero namespace id")
+	}
+
+	fi, err := os.Stat(sbx1.Key())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ino := fi.Sys().(*syscall.Stat_t).Ino; id != ino {
+		t.Fatalf("Expected the namespace id to be the inode %d of %s. Got %d", ino, sbx1.Key(), id)
+	}
+
+	if again, err := sbx1.NamespaceID(); err != nil || again != id {
+		t.Fatalf("Expected a stable namespace id %d. Got %d, %v", id, again, err)
+	}
+
+	sbx2, err := c.NewSandbox("sandbox2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id2, err := sbx2.NamespaceID(); err != nil || id2 == id {
+		t.Fatalf("Expected a distinct namespace id from %d. Got %d, %v", id, id2, err)
+	}
+
+	sbx3, err := c.NewSandbox("sandbox3", OptionUseDefaultSandbox())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sbx3.NamespaceID(); err == nil {
+		t.Fatal("Expected an error for the sandbox sharing the host namespace")
+	} else if _, ok := err.(ErrSharedNamespace); !ok {
+		t.Fatalf("Unexpected error type: %v", err)
+	}
+
+	for _, sb := range []Sandbox{sbx1, sbx2, sbx3} {
+		if err := sb.Delete(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSandboxPrimaryEndpoint(t *testing.T) {
+	if !netutils.IsRunningInContainer() {
+		defer netutils.SetupTestNetNS(t)()
+	}
+
+	c, nw1, nw2 := getTestEnv(t)
+
+	sbx, err := c.NewSandbox("sandbox1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ep1, err := nw1.CreateEndpoint("ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep2, err := nw2.CreateEndpoint("ep2", CreateOptionPrimary())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The secondary joins first, with a higher priority
+	if err := ep1.Join(sbx, JoinOptionPriority(ep1, 5)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ep2.Join(sbx); err != nil {
+		t.Fatal(err)
+	}
+
+	ifaceAddr := func(name string) string {
+		var addr string
+		if err := sbx.(*sandbox).osSbox.InvokeFunc(func() {
+			link, lErr := netlink.LinkByName(name)
+			if lErr != nil {
+				return
+			}
+			list, _ := netlink.AddrList(link, netlink.FAMILY_V4)
+			if len(list) != 0 {
+				addr = list[0].IPNet.String()
+			}
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return addr
+	}
+	defaultRoute := func() (string, string) {
+		var dev, gw string
+		if err := sbx.(*sandbox).osSbox.InvokeFunc(func() {
+			routes, _ := netlink.RouteList(nil, netlink.FAMILY_V4)
+			for _, r := range routes {
+				if r.Dst != nil {
+					continue
+				}
+				if link, lErr := netlink.LinkByIndex(r.LinkIndex); lErr == nil {
+					dev = link.Attrs().Name
+				}
+				gw = r.Gw.String()
+			}
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return dev, gw
+	}
+
+	addr1 := ep1.Info().InterfaceList()[0].Address()
+	addr2 := ep2.Info().InterfaceList()[0].Address()
+	if got := ifaceAddr("eth0"); got != addr2.String() {
+		t.Fatalf("Expected the primary endpoint address %s on eth0. Got %q", addr2.String(), got)
+	}
+	if got := ifaceAddr("eth1"); got != addr1.String() {
+		t.Fatalf("Expected the secondary endpoint address %s on eth1. Got %q", addr1.String(), got)
+	}
+
+	dev, gw := defaultRoute()
+	if dev != "eth0" || gw != ep2.Info().Gateway().String() {
+		t.Fatalf("Expected the default route via %s on eth0. Got via %s on %s", ep2.Info().Gateway(), gw, dev)
+	}
+
+	// The secondary supplies the default route once the primary left
+	if err := ep2.Leave(sbx); err != nil {
+		t.Fatal(err)
+	}
+	dev, gw = defaultRoute()
+	if dev != "eth1" || gw != ep1.Info().Gateway().String() {
+		t.Fatalf("Expected the default route via %s on eth1. Got via %s on %s", ep1.Info().Gateway(), gw, dev)
+	}
+
+	if err := sbx.Delete(); err != nil {
+		t.Fatal(err)
+	}
+
+	osl.GC()
+}
@@ -1,19 +1,127 @@
 package libnetwork
 
 import (
+	"container/heap"
 	"encoding/json"
 	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/libkv/store"
+	"github.com/docker/libnetwork/config"
 	"github.com/docker/libnetwork/datastore"
+	"github.com/docker/libnetwork/ipam"
+	"github.com/docker/libnetwork/osl"
 )
 
+// storeRetryInterval is how often the queued writes are retried while the
+// datastore is unavailable
+var storeRetryInterval = 5 * time.Second
+
+type storeOp struct {
+	kvObject datastore.KV
+	delete   bool
+}
+
+// storeSnapshot is the copy of an object taken when its write is queued, so
+// that the write flushed later stores the object as it was written rather than
+// its current state. The index the write results in is reported to the object.
+type storeSnapshot struct {
+	obj       datastore.KV
+	key       []string
+	keyPrefix []string
+	value     []byte
+	index     uint64
+	exists    bool
+}
+
+func newStoreSnapshot(obj datastore.KV) *storeSnapshot {
+	return &storeSnapshot{
+		obj:       obj,
+		key:       obj.Key(),
+		keyPrefix: obj.KeyPrefix(),
+		value:     obj.Value(),
+		index:     obj.Index(),
+		exists:    obj.Exists(),
+	}
+}
+
+func (s *storeSnapshot) Key() []string {
+	return s.key
+}
+
+func (s *storeSnapshot) KeyPrefix() []string {
+	return s.keyPrefix
+}
+
+func (s *storeSnapshot) Value() []byte {
+	return s.value
+}
+
+func (s *storeSnapshot) SetValue(value []byte) error {
+	s.value = value
+	return nil
+}
+
+func (s *storeSnapshot) Index() uint64 {
+	return s.index
+}
+
+func (s *storeSnapshot) SetIndex(index uint64) {
+	s.index = index
+	s.exists = true
+	s.obj.SetIndex(index)
+}
+
+func (s *storeSnapshot) Exists() bool {
+	return s.exists
+}
+
+// storeQueue holds the writes which could not reach the datastore, in order.
+// The queued objects are snapshots.
+type storeQueue struct {
+	ops      []storeOp
+	retrying bool
+	sync.Mutex
+}
+
 func (c *controller) validateDatastoreConfig() bool {
 	return c.cfg != nil && c.cfg.Datastore.Client.Provider != "" && c.cfg.Datastore.Client.Address != ""
 }
 
-func (c *controller) initDataStore() error {
+// newDataStore connects to the configured datastore. Tests replace it.
+var newDataStore = datastore.NewDataStore
+
+// connectDataStore connects to the datastore, giving up once the context is
+// done. A connection completing afterwards is closed.
+func connectDataStore(ctx Context, cfg *config.DatastoreCfg) (datastore.DataStore, error) {
+	type result struct {
+		ds  datastore.DataStore
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		ds, err := newDataStore(cfg)
+		done <- result{ds, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.ds, r.err
+	case <-ctx.Done():
+		go func() {
+			if r := <-done; r.err == nil {
+				r.ds.KVStore().Close()
+			}
+		}()
+		return nil, ErrStartupCanceled{Err: ctx.Err()}
+	}
+}
+
+func (c *controller) initDataStore(ctx Context) error {
 	c.Lock()
 	cfg := c.cfg
 	c.Unlock()
@@ -21,7 +129,7 @@ func (c *controller) initDataStore() error {
 		return fmt.Errorf("datastore initialization requires a valid configuration")
 	}
 
-	store, err := datastore.NewDataStore(&cfg.Datastore)
+	store, err := connectDataStore(ctx, &cfg.Datastore)
 	if err != nil {
 		return err
 	}
@@ -29,15 +137,46 @@ func (c *controller) initDataStore() error {
 	c.store = store
 	c.Unlock()
 
+	// The networks being restored attach to their ipam pools
+	if err := c.restoreIpamPools(); err != nil {
+		log.Warnf("failed to restore ipam pools from datastore during init : %v", err)
+	}
+	if err := ctx.Err(); err != nil {
+		return ErrStartupCanceled{Err: err}
+	}
+
 	nws, err := c.getNetworksFromStore()
+	if err := ctx.Err(); err != nil {
+		return ErrStartupCanceled{Err: err}
+	}
 	if err == nil {
 		c.processNetworkUpdate(nws, nil)
+		if err := ctx.Err(); err != nil {
+			return ErrStartupCanceled{Err: err}
+		}
+		c.restoreSandboxes()
 	} else if err != datastore.ErrKeyNotFound {
 		log.Warnf("failed to read networks from datastore during init : %v", err)
 	}
+	if err := ctx.Err(); err != nil {
+		return ErrStartupCanceled{Err: err}
+	}
 	return c.watchNetworks()
 }
 
+// closeDataStore closes the datastore connection of a controller whose
+// startup was aborted
+func (c *controller) closeDataStore() {
+	c.Lock()
+	cs := c.store
+	c.store = nil
+	c.Unlock()
+
+	if cs != nil {
+		cs.KVStore().Close()
+	}
+}
+
 func (c *controller) getNetworksFromStore() ([]*store.KVPair, error) {
 	c.Lock()
 	cs := c.store
@@ -49,9 +188,31 @@ func (c *controller) newNetworkFromStore(n *network) error {
 	n.Lock()
 	n.ctrlr = c
 	n.endpoints = endpointTable{}
+	pool, poolV6 := n.ipamPool, n.ipamPoolV6
 	n.Unlock()
 
-	return c.addNetwork(n)
+	if pool != "" {
+		if err := c.attachIpamPool(pool, n.id, false); err != nil {
+			log.Warnf("network %s restored without its ipam pool: %v", n.Name(), err)
+		}
+	}
+	if poolV6 != "" {
+		if err := c.attachIpamPool(poolV6, n.id, true); err != nil {
+			log.Warnf("network %s restored without its IPv6 ipam pool: %v", n.Name(), err)
+		}
+	}
+
+	if err := c.addNetwork(n); err != nil {
+		if pool != "" {
+			c.detachIpamPool(pool, n.id)
+		}
+		if poolV6 != "" {
+			c.detachIpamPool(poolV6, n.id)
+		}
+		return err
+	}
+
+	return nil
 }
 
 func (c *controller) updateNetworkToStore(n *network) error {
@@ -67,7 +228,7 @@ func (c *controller) updateNetworkToStore(n *network) error {
 		return nil
 	}
 
-	return cs.PutObjectAtomic(n)
+	return c.writeToStore(cs, storeOp{kvObject: n})
 }
 
 func (c *controller) deleteNetworkFromStore(n *network) error {
@@ -83,11 +244,7 @@ func (c *controller) deleteNetworkFromStore(n *network) error {
 		return nil
 	}
 
-	if err := cs.DeleteObjectAtomic(n); err != nil {
-		return err
-	}
-
-	return nil
+	return c.writeToStore(cs, storeOp{kvObject: n, delete: true})
 }
 
 func (c *controller) getNetworkFromStore(nid string) (*network, error) {
@@ -98,6 +255,75 @@ func (c *controller) getNetworkFromStore(nid string) (*network, error) {
 	return &n, nil
 }
 
+// restoreIpamPools loads the ipam pools from the datastore. Their subnets are
+// restored by the ipam allocator along with the addresses allocated in them.
+func (c *controller) restoreIpamPools() error {
+	c.Lock()
+	cs := c.store
+	c.Unlock()
+
+	kvs, err := cs.KVStore().List(datastore.Key(datastore.IpamPoolKeyPrefix))
+	if err != nil {
+		if err == datastore.ErrKeyNotFound {
+			return nil
+		}
+		return err
+	}
+
+	a, err := c.getIpamAllocator()
+	if err != nil {
+		return err
+	}
+
+	for _, kv := range kvs {
+		pool := &ipamPool{networks: map[string]struct{}{}}
+		if err := json.Unmarshal(kv.Value, pool); err != nil {
+			log.Warnf("failed to decode ipam pool %s from datastore: %v", kv.Key, err)
+			continue
+		}
+		pool.SetIndex(kv.LastIndex)
+
+		// The allocator normally knows the subnets already
+		for _, s := range pool.subnets() {
+			if err := a.AddSubnet(ipam.AddressSpace(pool.name), &ipam.SubnetInfo{Subnet: s}); err != nil && err != ipam.ErrOverlapSubnet {
+				log.Warnf("failed to restore subnet %s of ipam pool %s: %v", s, pool.name, err)
+			}
+		}
+
+		c.Lock()
+		if _, ok := c.ipamPools[pool.name]; !ok {
+			c.ipamPools[pool.name] = pool
+		}
+		c.Unlock()
+	}
+
+	return nil
+}
+
+func (c *controller) updateIpamPoolToStore(pool *ipamPool) error {
+	c.Lock()
+	cs := c.store
+	c.Unlock()
+	if cs == nil {
+		log.Debugf("datastore not initialized. ipam pool %s is not added to the store", pool.name)
+		return nil
+	}
+
+	return c.writeToStore(cs, storeOp{kvObject: pool})
+}
+
+func (c *controller) deleteIpamPoolFromStore(pool *ipamPool) error {
+	c.Lock()
+	cs := c.store
+	c.Unlock()
+	if cs == nil {
+		log.Debugf("datastore not initialized. ipam pool %s is not deleted from datastore", pool.name)
+		return nil
+	}
+
+	return c.writeToStore(cs, storeOp{kvObject: pool, delete: true})
+}
+
 func (c *controller) newEndpointFromStore(key string, ep *endpoint) error {
 	ep.Lock()
 	n := ep.network
@@ -113,6 +339,31 @@ func (c *controller) newEndpointFromStore(key string, ep *endpoint) error {
 	return err
 }
 
+func (c *controller) getEndpointsFromStore(n *network) ([]*endpoint, error) {
+	c.Lock()
+	cs := c.store
+	c.Unlock()
+
+	tmp := endpoint{network: n}
+	kvs, err := cs.KVStore().List(datastore.Key(tmp.KeyPrefix()...))
+	if err != nil {
+		return nil, err
+	}
+
+	eps := make([]*endpoint, 0, len(kvs))
+	for _, kv := range kvs {
+		ep := &endpoint{}
+		if err := json.Unmarshal(kv.Value, ep); err != nil {
+			log.Warnf("failed to decode endpoint %s from datastore: %v", kv.Key, err)
+			continue
+		}
+		ep.SetIndex(kv.LastIndex)
+		ep.network = n
+		eps = append(eps, ep)
+	}
+	return eps, nil
+}
+
 func (c *controller) updateEndpointToStore(ep *endpoint) error {
 	ep.Lock()
 	n := ep.network
@@ -130,7 +381,7 @@ func (c *controller) updateEndpointToStore(ep *endpoint) error {
 		return nil
 	}
 
-	return cs.PutObjectAtomic(ep)
+	return c.writeToStore(cs, storeOp{kvObject: ep})
 }
 
 func (c *controller) getEndpointFromStore(eid string) (*endpoint, error) {
@@ -158,13 +409,129 @@ func (c *controller) deleteEndpointFromStore(ep *endpoint) error {
 		return nil
 	}
 
-	if err := cs.DeleteObjectAtomic(ep); err != nil {
+	return c.writeToStore(cs, storeOp{kvObject: ep, delete: true})
+}
+
+// isStoreUnavailable tells whether the error comes from the datastore not
+// being reachable. Any other error rejects the operation itself and is
+// returned to the caller rather than retried.
+func isStoreUnavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if err == store.ErrNotReachable {
+		return true
+	}
+	if uerr, ok := err.(*url.Error); ok {
+		err = uerr.Err
+	}
+	_, ok := err.(net.Error)
+	return ok
+}
+
+func applyStoreOp(cs datastore.DataStore, op storeOp) error {
+	if op.delete {
+		return cs.DeleteObjectAtomic(op.kvObject)
+	}
+	return cs.PutObjectAtomic(op.kvObject)
+}
+
+// writeToStore applies the write to the datastore. If the datastore is not
+// reachable, the write is either failed with ErrStoreUnavailable or queued to
+// be flushed once the datastore is back, as configured.
+func (c *controller) writeToStore(cs datastore.DataStore, op storeOp) error {
+	c.Lock()
+	queueWrites := c.cfg != nil && c.cfg.Datastore.QueueWrites
+	c.Unlock()
+
+	if !queueWrites {
+		err := applyStoreOp(cs, op)
+		if isStoreUnavailable(err) {
+			return &ErrStoreUnavailable{err: err}
+		}
 		return err
 	}
 
+	q := &c.storeQueue
+	q.Lock()
+	defer q.Unlock()
+
+	// Writes queued earlier must land first
+	if len(q.ops) == 0 || c.flushStoreQueueLocked(cs) == 0 {
+		err := applyStoreOp(cs, op)
+		if !isStoreUnavailable(err) {
+			return err
+		}
+		log.Warnf("datastore unavailable, queueing write for key %s: %v", datastore.Key(op.kvObject.Key()...), err)
+	}
+
+	// The object may change, and be written again, before the write is
+	// flushed: queue it as it is now
+	q.ops = append(q.ops, storeOp{kvObject: newStoreSnapshot(op.kvObject), delete: op.delete})
+	if !q.retrying {
+		q.retrying = true
+		go c.retryStoreQueue(cs)
+	}
+
 	return nil
 }
 
+// flushStoreQueueLocked applies the queued writes in order until the datastore
+// fails again, and returns the number of writes left in the queue. Must be
+// called with the queue locked.
+func (c *controller) flushStoreQueueLocked(cs datastore.DataStore) int {
+	q := &c.storeQueue
+	for len(q.ops) != 0 {
+		op := q.ops[0]
+		err := applyStoreOp(cs, op)
+		if isStoreUnavailable(err) {
+			break
+		}
+		if err != nil {
+			log.Warnf("dropping queued write for key %s: %v", datastore.Key(op.kvObject.Key()...), err)
+		}
+		q.ops = q.ops[1:]
+		if err == nil {
+			chainStoreIndex(op, q.ops)
+		}
+	}
+	return len(q.ops)
+}
+
+// chainStoreIndex passes the index the write resulted in to the later writes
+// of the same object, which were queued with the index the object had then
+func chainStoreIndex(done storeOp, ops []storeOp) {
+	snap := done.kvObject.(*storeSnapshot)
+	key := datastore.Key(snap.key...)
+	for _, op := range ops {
+		next := op.kvObject.(*storeSnapshot)
+		if datastore.Key(next.key...) != key {
+			continue
+		}
+		if done.delete {
+			next.index, next.exists = 0, false
+		} else {
+			next.index, next.exists = snap.index, true
+		}
+	}
+}
+
+func (c *controller) retryStoreQueue(cs datastore.DataStore) {
+	q := &c.storeQueue
+	for {
+		time.Sleep(storeRetryInterval)
+		c.waitBackgroundTasks(nil)
+		q.Lock()
+		if c.flushStoreQueueLocked(cs) == 0 {
+			q.retrying = false
+			q.Unlock()
+			log.Infof("datastore available again, queued writes flushed")
+			return
+		}
+		q.Unlock()
+	}
+}
+
 func (c *controller) watchNetworks() error {
 	if !c.validateDatastoreConfig() {
 		return nil
@@ -182,6 +549,8 @@ func (c *controller) watchNetworks() error {
 		for {
 			select {
 			case nws := <-nwPairs:
+				// The watcher holds back its next update meanwhile, nothing is lost
+				c.waitBackgroundTasks(nil)
 				c.Lock()
 				tmpview := networkTable{}
 				lview := c.networks
@@ -238,6 +607,9 @@ func (n *network) watchEndpoints() error {
 			case <-stopCh:
 				return
 			case eps := <-epPairs:
+				if !n.ctrlr.waitBackgroundTasks(stopCh) {
+					return
+				}
 				n.Lock()
 				tmpview := endpointTable{}
 				lview := n.endpoints
@@ -362,3 +734,103 @@ func (c *controller) processEndpointUpdate(ep *endpoint) bool {
 
 	return false
 }
+
+// restoreSandboxes reconciles the endpoint joins persisted in the datastore
+// with the sandboxes left on the host, once the networks are restored. The
+// joins whose sandbox network namespace is still there are programmed back in
+// it, which gives the containers their network back after a controller crash.
+// The other joins are stale: they are cleared from the endpoints.
+func (c *controller) restoreSandboxes() {
+	for _, nw := range c.Networks() {
+		n := nw.(*network)
+		if global, _ := n.isGlobalScoped(); !global {
+			continue
+		}
+
+		eps, err := c.getEndpointsFromStore(n)
+		if err != nil {
+			if err != datastore.ErrKeyNotFound {
+				log.Warnf("failed to read the endpoints of network %s from datastore: %v", n.Name(), err)
+			}
+			continue
+		}
+
+		for _, ep := range eps {
+			ep.Lock()
+			sid := ep.sandboxID
+			owner := ep.sandboxOwner
+			ep.Unlock()
+			if sid == "" {
+				continue
+			}
+			if owner != "" && owner != c.ownerStamp() {
+				log.Debugf("Skipping the join of endpoint %s to sandbox %s owned by controller %s", ep.Name(), sid, owner)
+				continue
+			}
+
+			if e, err := n.EndpointByID(ep.id); err == nil {
+				ep = e.(*endpoint)
+			} else {
+				ep.origin = EndpointOriginRestore
+				if err := c.newEndpointFromStore(datastore.Key(ep.Key()...), ep); err != nil {
+					log.Warnf("failed to restore endpoint %s: %v", ep.Name(), err)
+					continue
+				}
+			}
+
+			sb, err := c.restoreSandbox(sid)
+			if err != nil {
+				log.Infof("Clearing the stale join of endpoint %s to sandbox %s: %v", ep.Name(), sid, err)
+				ep.Lock()
+				ep.sandboxID = ""
+				ep.joinedAt = time.Time{}
+				ep.Unlock()
+				if err := c.updateEndpointToStore(ep); err != nil {
+					log.Warnf("failed to update endpoint %s to store: %v", ep.Name(), err)
+				}
+				continue
+			}
+
+			if err := ep.rejoin(sb); err != nil {
+				log.Warnf("Failed to restore the join of endpoint %s to sandbox %s: %v", ep.Name(), sid, err)
+			}
+		}
+	}
+}
+
+// restoreSandbox returns the sandbox with the passed id, rebuilt around its
+// network namespace if the controller does not know of it. The hosts and
+// resolv.conf files are the ones the sandbox had at their default location.
+func (c *controller) restoreSandbox(sid string) (*sandbox, error) {
+	c.Lock()
+	sb, ok := c.sandboxes[sid]
+	c.Unlock()
+	if ok {
+		return sb, nil
+	}
+
+	sb = &sandbox{
+		id:         sid,
+		endpoints:  epHeap{},
+		epPriority: map[string]int{},
+		lazyEps:    map[string]*endpoint{},
+		config:     containerConfig{},
+		controller: c,
+	}
+	sb.config.hostsPath = defaultPrefix + "/" + sid + "/hosts"
+	sb.config.resolvConfPath = defaultPrefix + "/" + sid + "/resolv.conf"
+	sb.config.resolvConfHashFile = sb.config.resolvConfPath + ".hash"
+	heap.Init(&sb.endpoints)
+
+	osSbox, err := osl.RestoreSandbox(sb.Key())
+	if err != nil {
+		return nil, err
+	}
+	sb.osSbox = osSbox
+
+	c.Lock()
+	c.sandboxes[sid] = sb
+	c.Unlock()
+
+	return sb, nil
+}
@@ -106,6 +106,59 @@ func (p *PortBinding) Equal(o *PortBinding) bool {
 	return true
 }
 
+// ACLAction is the action taken on the traffic matched by an ACLRule
+type ACLAction string
+
+const (
+	// ACLAllow exempts the matched traffic from the next rules of the list, it
+	// is still subject to the filtering of the network
+	ACLAllow ACLAction = "allow"
+	// ACLDeny drops the matched traffic
+	ACLDeny ACLAction = "deny"
+)
+
+// ACLRule represents an allow or deny rule on the traffic reaching an endpoint.
+// Zero value match fields match any traffic.
+type ACLRule struct {
+	Action ACLAction
+	Proto  Protocol
+	Port   uint16
+	Source *net.IPNet
+}
+
+// Validate checks that the rule can be enforced
+func (r *ACLRule) Validate() error {
+	if r.Action != ACLAllow && r.Action != ACLDeny {
+		return BadRequestErrorf("invalid acl action: %q", string(r.Action))
+	}
+	if r.Port != 0 && r.Proto != TCP && r.Proto != UDP {
+		return BadRequestErrorf("acl rule on port %d requires the tcp or udp protocol", r.Port)
+	}
+	return nil
+}
+
+// GetCopy returns a copy of this ACLRule structure instance
+func (r *ACLRule) GetCopy() ACLRule {
+	return ACLRule{
+		Action: r.Action,
+		Proto:  r.Proto,
+		Port:   r.Port,
+		Source: GetIPNetCopy(r.Source),
+	}
+}
+
+func (r ACLRule) String() string {
+	src := "any"
+	if r.Source != nil {
+		src = r.Source.String()
+	}
+	proto := "any"
+	if r.Proto != 0 {
+		proto = r.Proto.String()
+	}
+	return fmt.Sprintf("%s %s/%d from %s", r.Action, proto, r.Port, src)
+}
+
 // ErrInvalidProtocolBinding is returned when the port binding protocol is not valid.
 type ErrInvalidProtocolBinding string
 
@@ -272,6 +325,13 @@ type StaticRoute struct {
 	// are interpreted as directly connected to the specified interface (no
 	// next hop will be used).
 	InterfaceID int
+
+	// Metric is the priority of the route. Among the routes to the same
+	// destination the kernel prefers the one with the lowest metric.
+	Metric int
+
+	// Table is the routing table the route is installed in, main if 0.
+	Table int
 }
 
 // GetCopy returns a copy of this StaticRoute structure
@@ -281,7 +341,9 @@ func (r *StaticRoute) GetCopy() *StaticRoute {
 	return &StaticRoute{Destination: d,
 		RouteType:   r.RouteType,
 		NextHop:     nh,
-		InterfaceID: r.InterfaceID}
+		InterfaceID: r.InterfaceID,
+		Metric:      r.Metric,
+		Table:       r.Table}
 }
 
 /******************************